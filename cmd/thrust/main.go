@@ -9,18 +9,47 @@ import (
 )
 
 func setLogger(level slog.Level) {
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(internal.NewRequestIDLogHandler(handler)))
 }
 
-func main() {
+func newService() (*internal.Service, error) {
 	config, err := internal.NewConfig()
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 
 	setLogger(config.LogLevel)
 
-	service := internal.NewService(config)
+	return internal.NewService(config), nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		os.Exit(internal.RunSystemServiceCommand(os.Args[2:], newService))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export-state" {
+		os.Exit(internal.RunExportStateCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "apply" {
+		os.Exit(internal.RunApplyCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "geo" {
+		os.Exit(internal.RunGeoCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(internal.RunHealthcheckCommand(os.Args[2:]))
+	}
+
+	service, err := newService()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
 	os.Exit(service.Run())
 }
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var accessLogTestEntry = AccessLogEntry{
+	Time:              time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC),
+	Duration:          25 * time.Millisecond,
+	RemoteAddr:        "203.0.113.7:52814",
+	Method:            "GET",
+	Path:              "/search",
+	Query:             "q=thruster",
+	Proto:             "HTTP/1.1",
+	Status:            200,
+	RespContentLength: 512,
+	Referer:           "https://example.com/",
+	UserAgent:         `Robot "1"`,
+	Cache:             "miss",
+	Tags:              map[string]string{TagCountry: "GB", TagASN: "AS64500"},
+}
+
+func TestParseAccessLogFormat(t *testing.T) {
+	format, err := ParseAccessLogFormat("JSON")
+	require.NoError(t, err)
+	assert.Equal(t, AccessLogFormatJSON, format)
+
+	format, err = ParseAccessLogFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, AccessLogFormatDefault, format)
+
+	_, err = ParseAccessLogFormat("xml")
+	assert.ErrorIs(t, err, ErrInvalidAccessLogFormat)
+}
+
+func TestAccessLog_common(t *testing.T) {
+	out := &strings.Builder{}
+	NewAccessLog(AccessLogFormatCommon, out, false).Write(accessLogTestEntry)
+
+	assert.Equal(t, `203.0.113.7 - - [05/Mar/2024:14:30:00 +0000] "GET /search?q=thruster HTTP/1.1" 200 512`+"\n", out.String())
+}
+
+func TestAccessLog_combined(t *testing.T) {
+	out := &strings.Builder{}
+	NewAccessLog(AccessLogFormatCombined, out, false).Write(accessLogTestEntry)
+
+	assert.Equal(t, `203.0.113.7 - - [05/Mar/2024:14:30:00 +0000] "GET /search?q=thruster HTTP/1.1" 200 512 "https://example.com/" "Robot \"1\""`+"\n", out.String())
+}
+
+func TestAccessLog_combined_with_geo_fields(t *testing.T) {
+	entry := accessLogTestEntry
+	entry.RemoteAddr = "2001:db8::1"
+	entry.RespContentLength = 0
+	entry.Referer = ""
+	entry.Tags = map[string]string{TagCountry: "RU", TagBlocked: BlockedByCountry}
+
+	out := &strings.Builder{}
+	NewAccessLog(AccessLogFormatCombined, out, true).Write(entry)
+
+	assert.Equal(t, `2001:db8::1 - - [05/Mar/2024:14:30:00 +0000] "GET /search?q=thruster HTTP/1.1" 200 - "-" "Robot \"1\"" "RU" "-" "country"`+"\n", out.String())
+}
+
+func TestAccessLog_json(t *testing.T) {
+	out := &strings.Builder{}
+	NewAccessLog(AccessLogFormatJSON, out, true).Write(accessLogTestEntry)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out.String()), &line))
+
+	assert.Equal(t, "2024-03-05T14:30:00Z", line["time"])
+	assert.Equal(t, "203.0.113.7", line["remote_addr"])
+	assert.Equal(t, "/search", line["path"])
+	assert.Equal(t, "q=thruster", line["query"])
+	assert.Equal(t, float64(200), line["status"])
+	assert.Equal(t, float64(512), line["bytes"])
+	assert.Equal(t, float64(25), line["duration_ms"])
+	assert.Equal(t, `Robot "1"`, line["user_agent"])
+	assert.Equal(t, "GB", line["country"])
+	assert.Equal(t, "AS64500", line["asn"])
+	assert.NotContains(t, line, "blocked")
+}
+
+func TestAccessLog_json_without_geo_fields(t *testing.T) {
+	out := &strings.Builder{}
+	NewAccessLog(AccessLogFormatJSON, out, false).Write(accessLogTestEntry)
+
+	assert.NotContains(t, out.String(), "country")
+	assert.NotContains(t, out.String(), "asn")
+}
+
+func TestAccessLog_default(t *testing.T) {
+	out := &strings.Builder{}
+	NewAccessLog(AccessLogFormatDefault, out, true).Write(accessLogTestEntry)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out.String()), &line))
+
+	assert.Equal(t, "Request", line["msg"])
+	assert.Equal(t, "/search", line["path"])
+	assert.Equal(t, "203.0.113.7:52814", line["remote_addr"])
+}
+
+func TestAccessLogQuote(t *testing.T) {
+	assert.Equal(t, `"-"`, accessLogQuote(""))
+	assert.Equal(t, `"a \"b\" \\ c"`, accessLogQuote(`a "b" \ c`))
+	assert.Equal(t, `"line\x0abreak"`, accessLogQuote("line\nbreak"))
+}
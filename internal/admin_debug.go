@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+type adminRuntimeInfo struct {
+	GoVersion  string            `json:"go_version"`
+	Uptime     int64             `json:"uptime_seconds"`
+	Goroutines int               `json:"goroutines"`
+	Memory     adminMemoryInfo   `json:"memory"`
+	Cache      adminCacheInfo    `json:"cache"`
+	GeoIP2     []GeoDatabaseInfo `json:"geoip2"`
+	Rules      adminRulesSummary `json:"rules"`
+}
+
+type adminMemoryInfo struct {
+	HeapAlloc   uint64 `json:"heap_alloc_bytes"`
+	HeapObjects uint64 `json:"heap_objects"`
+	Sys         uint64 `json:"sys_bytes"`
+	NumGC       uint32 `json:"gc_cycles"`
+}
+
+type adminCacheInfo struct {
+	Hits        uint64  `json:"hits"`
+	Misses      uint64  `json:"misses"`
+	Stale       uint64  `json:"stale"`
+	Revalidated uint64  `json:"revalidated"`
+	Bypasses    uint64  `json:"bypasses"`
+	Stores      uint64  `json:"stores"`
+	Evictions   uint64  `json:"evictions"`
+	StoredBytes int64   `json:"stored_bytes"`
+	Entries     int64   `json:"entries"`
+	HitRatio    float64 `json:"hit_ratio"`
+}
+
+type adminRulesSummary struct {
+	PolicyETag        string `json:"policy_etag"`
+	AllowCountries    int    `json:"allow_countries"`
+	BlockCountries    int    `json:"block_countries"`
+	CountryRateLimits int    `json:"country_rate_limits"`
+	ClientRateLimit   string `json:"client_rate_limit"`
+	RateLimitExempt   int    `json:"rate_limit_exempt_cidrs"`
+	RiskScores        int    `json:"risk_scores"`
+	BodyRules         int    `json:"body_rules"`
+	Geofences         int    `json:"geofences"`
+}
+
+// SetDebug enables the profiling and runtime endpoints. They reveal a good deal
+// about the process, and profiling has a cost of its own, so they're only
+// served when asked for.
+func (s *AdminServer) SetDebug(cacheStats *CacheStats) {
+	s.cacheStats = cacheStats
+
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.mux.HandleFunc("GET /debug/runtime", s.serveRuntime)
+}
+
+// Private
+
+func (s *AdminServer) serveRuntime(w http.ResponseWriter, r *http.Request) {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	cache := s.cacheStats.Snapshot()
+	policy, etag := s.policies.Current()
+
+	info := adminRuntimeInfo{
+		GoVersion:  runtime.Version(),
+		Uptime:     int64(time.Since(s.started).Seconds()),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: adminMemoryInfo{
+			HeapAlloc:   memory.HeapAlloc,
+			HeapObjects: memory.HeapObjects,
+			Sys:         memory.Sys,
+			NumGC:       memory.NumGC,
+		},
+		Cache: adminCacheInfo{
+			Hits:        cache.Hits,
+			Misses:      cache.Misses,
+			Stale:       cache.Stale,
+			Revalidated: cache.Revalidated,
+			Bypasses:    cache.Bypasses,
+			Stores:      cache.Stores,
+			Evictions:   cache.Evictions,
+			StoredBytes: cache.StoredBytes,
+			Entries:     cache.Entries,
+			HitRatio:    cache.HitRatio(),
+		},
+		Rules: adminRulesSummary{
+			PolicyETag:        etag,
+			AllowCountries:    len(policy.AllowCountries),
+			BlockCountries:    len(policy.BlockCountries),
+			CountryRateLimits: len(policy.CountryRateLimits),
+			ClientRateLimit:   stateRateLimit(policy.ClientRateLimit),
+			RateLimitExempt:   len(policy.RateLimitExemptCIDRs),
+			RiskScores:        len(policy.RiskScores),
+			BodyRules:         len(policy.BodyRules),
+			Geofences:         len(policy.Geofences),
+		},
+	}
+
+	if s.geoResolver != nil {
+		info.GeoIP2 = s.geoResolver.Databases()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
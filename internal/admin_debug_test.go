@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminServer_debug_disabled_by_default(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.Equal(t, http.StatusNotFound, adminServerTestRequest(t, server, "/debug/pprof/", "", "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, adminServerTestRequest(t, server, "/debug/runtime", "", "").StatusCode)
+}
+
+func TestAdminServer_debug_pprof(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "s3cret", NewPolicySource(Policy{}))
+	server.SetDebug(nil)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.Equal(t, http.StatusUnauthorized, adminServerTestRequest(t, server, "/debug/pprof/", "", "").StatusCode)
+
+	resp := adminServerTestRequest(t, server, "/debug/pprof/goroutine?debug=1", "s3cret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "goroutine profile:")
+}
+
+func TestAdminServer_debug_runtime(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	stats := NewCacheStats()
+	stats.Served("hit")
+	stats.Served("miss")
+
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN", "RU"}, ClientRateLimit: RateLimit{Rate: 10, Burst: 20}})
+	_, etag := source.Current()
+
+	server := NewAdminServer("127.0.0.1:0", "", source)
+	server.SetGeoIP2(resolver, nil, 0)
+	server.SetDebug(stats)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	resp := adminServerTestRequest(t, server, "/debug/runtime", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var info adminRuntimeInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+
+	assert.Positive(t, info.Goroutines)
+	assert.Positive(t, info.Memory.Sys)
+	assert.Equal(t, uint64(1), info.Cache.Hits)
+	assert.Equal(t, 0.5, info.Cache.HitRatio)
+	require.Len(t, info.GeoIP2, 1)
+	assert.Equal(t, "GeoLite2-Country", info.GeoIP2[0].DatabaseType)
+	assert.False(t, info.GeoIP2[0].BuildDate.IsZero())
+	assert.Equal(t, etag, info.Rules.PolicyETag)
+	assert.Equal(t, 2, info.Rules.BlockCountries)
+	assert.Equal(t, "10:20", info.Rules.ClientRateLimit)
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/basecamp/thruster/internal/adminpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const adminHealthUpdateInterval = time.Second
+
+// Services reported by the admin gRPC health service. The overall status,
+// under the empty service name, is serving only when they all are.
+const (
+	AdminHealthServiceStartup  = "thruster.startup"
+	AdminHealthServiceUpstream = "thruster.upstream"
+)
+
+// AdminGRPCServer serves the admin API over gRPC, so that tooling built around
+// gRPC can manage the policy and bans, read the stats and stream the requests
+// we refuse, without scraping HTTP endpoints. The service is described in
+// adminpb/admin.proto, and is also discoverable through reflection.
+//
+// When a token is set, calls must give it as a bearer token in their
+// authorization metadata, as with the admin HTTP API. The exception is the
+// standard health service, which load balancers and orchestrators probe
+// without credentials.
+type AdminGRPCServer struct {
+	address   string
+	token     string
+	policies  *PolicySource
+	upstreams *UpstreamPool
+	startup   *Startup
+	server    *grpc.Server
+	health    *health.Server
+	listener  net.Listener
+	done      chan struct{}
+	replica   bool
+
+	geoResolver    *GeoResolver
+	bans           *Bans
+	countryStats   *CountryStats
+	blocklistStats *BlocklistStats
+	decisions      *Decisions
+}
+
+func NewAdminGRPCServer(address, token string, policies *PolicySource, upstreams *UpstreamPool, startup *Startup) *AdminGRPCServer {
+	s := &AdminGRPCServer{
+		address:   address,
+		token:     token,
+		policies:  policies,
+		upstreams: upstreams,
+		startup:   startup,
+		health:    health.NewServer(),
+		done:      make(chan struct{}),
+	}
+
+	s.server = grpc.NewServer(
+		grpc.UnaryInterceptor(s.authenticatedUnary),
+		grpc.StreamInterceptor(s.authenticatedStream),
+	)
+
+	healthpb.RegisterHealthServer(s.server, s.health)
+	adminpb.RegisterAdminServer(s.server, &adminGRPCService{server: s})
+	reflection.Register(s.server)
+
+	return s
+}
+
+// SetReplica refuses changes to the policy, which replicas take from their
+// primary.
+func (s *AdminGRPCServer) SetReplica() {
+	s.replica = true
+}
+
+// SetGeoIP2 allows policies with rules that need the client's location.
+func (s *AdminGRPCServer) SetGeoIP2(resolver *GeoResolver) {
+	s.geoResolver = resolver
+}
+
+// SetBans enables listing and lifting bans.
+func (s *AdminGRPCServer) SetBans(bans *Bans) {
+	s.bans = bans
+}
+
+// SetCountryStats enables reporting on the traffic from each country.
+func (s *AdminGRPCServer) SetCountryStats(stats *CountryStats) {
+	s.countryStats = stats
+}
+
+// SetBlocklistStats enables reporting on the blocklist feeds.
+func (s *AdminGRPCServer) SetBlocklistStats(stats *BlocklistStats) {
+	s.blocklistStats = stats
+}
+
+// SetDecisions enables streaming the requests we refuse.
+func (s *AdminGRPCServer) SetDecisions(decisions *Decisions) {
+	s.decisions = decisions
+}
+
+// Start binds the admin address and begins serving in the background.
+func (s *AdminGRPCServer) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.updateHealth()
+	go s.watchHealth()
+	go s.server.Serve(listener)
+
+	slog.Info("Admin gRPC server started", "address", listener.Addr().String())
+	return nil
+}
+
+func (s *AdminGRPCServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stop reports every service as not serving to anyone watching, and then
+// closes the server. Watchers hold their streams open indefinitely, so we
+// don't wait for them to finish.
+func (s *AdminGRPCServer) Stop() error {
+	close(s.done)
+	s.health.Shutdown()
+	s.server.Stop()
+
+	slog.Info("Admin gRPC server stopped")
+	return nil
+}
+
+// Private
+
+func (s *AdminGRPCServer) authenticatedUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *AdminGRPCServer) authenticatedStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := s.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+func (s *AdminGRPCServer) authenticate(ctx context.Context, method string) error {
+	if s.token == "" || strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "Unauthorized")
+}
+
+func (s *AdminGRPCServer) watchHealth() {
+	ticker := time.NewTicker(adminHealthUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.updateHealth()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *AdminGRPCServer) updateHealth() {
+	warm := s.startup == nil || s.startup.Warm()
+	healthy := len(s.upstreams.healthyUpstreams()) > 0
+
+	s.health.SetServingStatus(AdminHealthServiceStartup, servingStatus(warm))
+	s.health.SetServingStatus(AdminHealthServiceUpstream, servingStatus(healthy))
+	s.health.SetServingStatus("", servingStatus(warm && healthy))
+}
+
+func servingStatus(serving bool) healthpb.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+func TestAdminGRPCServer_health(t *testing.T) {
+	target, _ := url.Parse("http://localhost:3000")
+	pool := NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin)
+
+	server := NewAdminGRPCServer("127.0.0.1:0", "", NewPolicySource(Policy{}), pool, nil)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	client := healthpb.NewHealthClient(adminGRPCTestConn(t, server))
+
+	status := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(AdminHealthServiceStartup))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(AdminHealthServiceUpstream))
+
+	pool.Upstreams()[0].healthy.Store(false)
+	server.updateHealth()
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(AdminHealthServiceStartup))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(AdminHealthServiceUpstream))
+}
+
+func TestAdminGRPCServer_not_serving_until_startup_is_warm(t *testing.T) {
+	target, _ := url.Parse("http://localhost:3000")
+	pool := NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin)
+
+	startup := NewStartup()
+	startup.pending.Add(1)
+
+	server := NewAdminGRPCServer("127.0.0.1:0", "", NewPolicySource(Policy{}), pool, startup)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	client := healthpb.NewHealthClient(adminGRPCTestConn(t, server))
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: AdminHealthServiceStartup})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
+
+func TestAdminGRPCServer_reflection(t *testing.T) {
+	target, _ := url.Parse("http://localhost:3000")
+	server := NewAdminGRPCServer("127.0.0.1:0", "", NewPolicySource(Policy{}), NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin), nil)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	client := reflectionpb.NewServerReflectionClient(adminGRPCTestConn(t, server))
+	stream, err := client.ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, service := range resp.GetListServicesResponse().Service {
+		names = append(names, service.Name)
+	}
+	assert.Contains(t, names, "grpc.health.v1.Health")
+	assert.Contains(t, names, "thruster.admin.v1.Admin")
+}
+
+// Helpers
+
+func adminGRPCTestConn(t *testing.T, server *AdminGRPCServer) *grpc.ClientConn {
+	conn, err := grpc.NewClient(server.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
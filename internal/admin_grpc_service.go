@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/basecamp/thruster/internal/adminpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adminGRPCService implements the calls of the admin gRPC API, with the same
+// behaviour as their counterparts in the admin HTTP API.
+type adminGRPCService struct {
+	adminpb.UnimplementedAdminServer
+	server *AdminGRPCServer
+}
+
+func (a *adminGRPCService) GetPolicy(ctx context.Context, req *adminpb.GetPolicyRequest) (*adminpb.PolicyResponse, error) {
+	policy, etag := a.server.policies.Current()
+
+	return &adminpb.PolicyResponse{Policy: policyToProto(policy), Etag: etag}, nil
+}
+
+func (a *adminGRPCService) ApplyPolicy(ctx context.Context, req *adminpb.ApplyPolicyRequest) (*adminpb.PolicyResponse, error) {
+	if a.server.replica {
+		return nil, status.Error(codes.FailedPrecondition, "The policy is followed from the primary; apply it there")
+	}
+	if req.Policy == nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid policy: a policy is required")
+	}
+
+	policy, err := policyFromProto(req.Policy)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid policy: "+err.Error())
+	}
+	if policy.NeedsGeoIP2() && (a.server.geoResolver == nil || !a.server.geoResolver.HasCountries()) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid policy: "+ErrPolicyNeedsGeoIP2.Error())
+	}
+
+	policy, etag, err := a.server.policies.Apply(policy, req.IfMatch)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, "The policy has changed since it was read")
+	}
+
+	slog.Info("Policy applied through the admin gRPC API", "etag", etag)
+
+	return &adminpb.PolicyResponse{Policy: policyToProto(policy), Etag: etag}, nil
+}
+
+func (a *adminGRPCService) ListBans(ctx context.Context, req *adminpb.ListBansRequest) (*adminpb.ListBansResponse, error) {
+	if a.server.bans == nil {
+		return nil, status.Error(codes.Unimplemented, "Auto-banning is not enabled")
+	}
+
+	bans, err := a.server.bans.List()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &adminpb.ListBansResponse{}
+	for _, ban := range bans {
+		resp.Bans = append(resp.Bans, &adminpb.Ban{
+			Ip:        ban.IP,
+			Reason:    ban.Reason,
+			Offences:  ban.Offences,
+			BannedAt:  protoTime(ban.BannedAt),
+			ExpiresAt: protoTime(ban.ExpiresAt),
+		})
+	}
+	return resp, nil
+}
+
+func (a *adminGRPCService) LiftBan(ctx context.Context, req *adminpb.LiftBanRequest) (*adminpb.LiftBanResponse, error) {
+	if a.server.bans == nil {
+		return nil, status.Error(codes.Unimplemented, "Auto-banning is not enabled")
+	}
+
+	ip := net.ParseIP(req.Ip)
+	if ip == nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid IP address")
+	}
+
+	err := a.server.bans.Unban(ip)
+	switch {
+	case errors.Is(err, ErrNotBanned):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	default:
+		return &adminpb.LiftBanResponse{}, nil
+	}
+}
+
+func (a *adminGRPCService) GetCountryStats(ctx context.Context, req *adminpb.GetCountryStatsRequest) (*adminpb.CountryStats, error) {
+	if a.server.countryStats == nil {
+		return nil, status.Error(codes.Unimplemented, "Country stats are not enabled")
+	}
+
+	var snapshot CountryStatsSnapshot
+	if req.ResetCounts {
+		snapshot = a.server.countryStats.Reset()
+	} else {
+		snapshot = a.server.countryStats.Snapshot()
+	}
+
+	resp := &adminpb.CountryStats{Since: protoTime(snapshot.Since)}
+	for _, country := range snapshot.Countries {
+		resp.Countries = append(resp.Countries, &adminpb.CountryStatsStatus{
+			Country:  country.Country,
+			Requests: country.Requests,
+			Allowed:  country.Allowed,
+			Blocked:  country.Blocked,
+			BytesIn:  country.BytesIn,
+			BytesOut: country.BytesOut,
+			Statuses: country.Statuses,
+		})
+	}
+	return resp, nil
+}
+
+func (a *adminGRPCService) GetBlocklistStats(ctx context.Context, req *adminpb.GetBlocklistStatsRequest) (*adminpb.BlocklistStats, error) {
+	if a.server.blocklistStats == nil {
+		return nil, status.Error(codes.Unimplemented, "No blocklist feeds are configured")
+	}
+
+	resp := &adminpb.BlocklistStats{}
+	for _, feed := range a.server.blocklistStats.Snapshot() {
+		resp.Feeds = append(resp.Feeds, &adminpb.BlocklistFeedStatus{
+			Name:        feed.Name,
+			Entries:     int64(feed.Entries),
+			Skipped:     int64(feed.Skipped),
+			LastChecked: protoTime(feed.LastChecked),
+			LastUpdated: protoTime(feed.LastUpdated),
+			LastError:   feed.LastError,
+			Matches:     feed.Matches,
+		})
+	}
+	return resp, nil
+}
+
+func (a *adminGRPCService) StreamDecisions(req *adminpb.StreamDecisionsRequest, stream grpc.ServerStreamingServer[adminpb.Decision]) error {
+	if a.server.decisions == nil {
+		return status.Error(codes.Unimplemented, "Decision streaming is not enabled")
+	}
+
+	records, stop := a.server.decisions.Watch()
+	defer stop()
+
+	for {
+		select {
+		case record := <-records:
+			err := stream.Send(&adminpb.Decision{
+				Time:      protoTime(record.Time),
+				Ip:        record.IP,
+				Country:   record.Country,
+				Asn:       record.ASN,
+				Reason:    record.Reason,
+				Rule:      record.Rule,
+				Method:    record.Method,
+				Host:      record.Host,
+				Path:      record.Path,
+				Status:    int32(record.Status),
+				UserAgent: record.UserAgent,
+				RequestId: record.RequestID,
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Private
+
+func policyToProto(policy Policy) *adminpb.Policy {
+	doc := newPolicyDocument(policy)
+
+	pb := &adminpb.Policy{
+		AllowCountries:          doc.AllowCountries,
+		BlockCountries:          doc.BlockCountries,
+		ChallengeCountries:      doc.ChallengeCountries,
+		CountryRateLimits:       doc.CountryRateLimits,
+		DefaultCountryRateLimit: doc.DefaultCountryRateLimit,
+		ClientRateLimit:         doc.ClientRateLimit,
+		RateLimitExemptCidrs:    doc.RateLimitExemptCIDRs,
+		RiskScores:              doc.RiskScores,
+		RiskTagScore:            int32(doc.RiskTagScore),
+		RiskBlockScore:          int32(doc.RiskBlockScore),
+		BodyRules:               doc.BodyRules,
+		Blocklists:              map[string]*adminpb.Networks{},
+		Geofences:               doc.Geofences,
+	}
+	for name, cidrs := range doc.Blocklists {
+		pb.Blocklists[name] = &adminpb.Networks{Cidrs: cidrs}
+	}
+
+	return pb
+}
+
+func policyFromProto(pb *adminpb.Policy) (Policy, error) {
+	doc := policyDocument{
+		AllowCountries:          pb.AllowCountries,
+		BlockCountries:          pb.BlockCountries,
+		ChallengeCountries:      pb.ChallengeCountries,
+		CountryRateLimits:       pb.CountryRateLimits,
+		DefaultCountryRateLimit: pb.DefaultCountryRateLimit,
+		ClientRateLimit:         pb.ClientRateLimit,
+		RateLimitExemptCIDRs:    pb.RateLimitExemptCidrs,
+		RiskScores:              pb.RiskScores,
+		RiskTagScore:            int(pb.RiskTagScore),
+		RiskBlockScore:          int(pb.RiskBlockScore),
+		BodyRules:               pb.BodyRules,
+		Blocklists:              map[string][]string{},
+		Geofences:               pb.Geofences,
+	}
+	for name, networks := range pb.Blocklists {
+		doc.Blocklists[name] = networks.GetCidrs()
+	}
+
+	return doc.policy()
+}
+
+// protoTime converts a time, leaving it unset when it's zero, as for a feed
+// that has never been checked.
+func protoTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/basecamp/thruster/internal/adminpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminGRPCService_requires_the_token(t *testing.T) {
+	server := newTestAdminGRPCServer(t, "s3cret", NewPolicySource(Policy{}))
+	conn := adminGRPCTestConn(t, server)
+	client := adminpb.NewAdminClient(conn)
+
+	_, err := client.GetPolicy(context.Background(), &adminpb.GetPolicyRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetPolicy(adminGRPCTestToken("wrong"), &adminpb.GetPolicyRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetPolicy(adminGRPCTestToken("s3cret"), &adminpb.GetPolicyRequest{})
+	assert.NoError(t, err)
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestAdminGRPCService_policy(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.0.2.0/24")
+	blocklists := map[string][]*net.IPNet{"spamhaus": {network}}
+	source := NewPolicySource(Policy{ClientRateLimit: RateLimit{Rate: 5, Burst: 10}, Blocklists: blocklists})
+
+	server := newTestAdminGRPCServer(t, "", source)
+	client := adminpb.NewAdminClient(adminGRPCTestConn(t, server))
+
+	resp, err := client.GetPolicy(context.Background(), &adminpb.GetPolicyRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "5:10", resp.Policy.ClientRateLimit)
+	assert.Equal(t, []string{"192.0.2.0/24"}, resp.Policy.Blocklists["spamhaus"].Cidrs)
+
+	etag := resp.Etag
+	policy := &adminpb.Policy{ClientRateLimit: "10:20"}
+
+	_, err = client.ApplyPolicy(context.Background(), &adminpb.ApplyPolicyRequest{Policy: policy, IfMatch: "stale"})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	resp, err = client.ApplyPolicy(context.Background(), &adminpb.ApplyPolicyRequest{Policy: policy, IfMatch: etag})
+	require.NoError(t, err)
+	assert.Equal(t, "10:20", resp.Policy.ClientRateLimit)
+
+	applied, current := source.Current()
+	assert.Equal(t, current, resp.Etag)
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 20}, applied.ClientRateLimit)
+	assert.Equal(t, blocklists, applied.Blocklists)
+
+	_, err = client.ApplyPolicy(context.Background(), &adminpb.ApplyPolicyRequest{Policy: &adminpb.Policy{ClientRateLimit: "fast"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ApplyPolicy(context.Background(), &adminpb.ApplyPolicyRequest{Policy: &adminpb.Policy{BlockCountries: []string{"CN"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	server.SetReplica()
+	_, err = client.ApplyPolicy(context.Background(), &adminpb.ApplyPolicyRequest{Policy: policy})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, unchanged := source.Current()
+	assert.Equal(t, current, unchanged)
+}
+
+func TestAdminGRPCService_bans(t *testing.T) {
+	server := newTestAdminGRPCServer(t, "", NewPolicySource(Policy{}))
+	client := adminpb.NewAdminClient(adminGRPCTestConn(t, server))
+
+	_, err := client.ListBans(context.Background(), &adminpb.ListBansRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	bans := NewBans(NewMemoryStore(), 1, time.Minute, time.Hour)
+	bans.Offence(net.ParseIP("192.0.2.1"), "status 404")
+	server.SetBans(bans)
+
+	resp, err := client.ListBans(context.Background(), &adminpb.ListBansRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Bans, 1)
+	assert.Equal(t, "192.0.2.1", resp.Bans[0].Ip)
+	assert.Equal(t, "status 404", resp.Bans[0].Reason)
+
+	lift := func(ip string) codes.Code {
+		_, err := client.LiftBan(context.Background(), &adminpb.LiftBanRequest{Ip: ip})
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.InvalidArgument, lift("nonsense"))
+	assert.Equal(t, codes.OK, lift("192.0.2.1"))
+	assert.Equal(t, codes.NotFound, lift("192.0.2.1"))
+}
+
+func TestAdminGRPCService_stats(t *testing.T) {
+	server := newTestAdminGRPCServer(t, "", NewPolicySource(Policy{}))
+	client := adminpb.NewAdminClient(adminGRPCTestConn(t, server))
+
+	_, err := client.GetCountryStats(context.Background(), &adminpb.GetCountryStatsRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	stats := NewCountryStats()
+	stats.Record("DE", false, 200, 100, 1000)
+	stats.Record("DE", true, 403, 100, 10)
+	server.SetCountryStats(stats)
+
+	resp, err := client.GetCountryStats(context.Background(), &adminpb.GetCountryStatsRequest{ResetCounts: true})
+	require.NoError(t, err)
+	require.Len(t, resp.Countries, 1)
+	assert.Equal(t, "DE", resp.Countries[0].Country)
+	assert.Equal(t, uint64(2), resp.Countries[0].Requests)
+	assert.Equal(t, uint64(1), resp.Countries[0].Blocked)
+
+	resp, err = client.GetCountryStats(context.Background(), &adminpb.GetCountryStatsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Countries)
+
+	blocklistStats := NewBlocklistStats()
+	blocklistStats.updated("spamhaus", 10, 1)
+	blocklistStats.Matched("spamhaus")
+	server.SetBlocklistStats(blocklistStats)
+
+	feeds, err := client.GetBlocklistStats(context.Background(), &adminpb.GetBlocklistStatsRequest{})
+	require.NoError(t, err)
+	require.Len(t, feeds.Feeds, 1)
+	assert.Equal(t, "spamhaus", feeds.Feeds[0].Name)
+	assert.Equal(t, int64(10), feeds.Feeds[0].Entries)
+	assert.Equal(t, uint64(1), feeds.Feeds[0].Matches)
+}
+
+func TestAdminGRPCService_stream_decisions(t *testing.T) {
+	server := newTestAdminGRPCServer(t, "", NewPolicySource(Policy{}))
+	client := adminpb.NewAdminClient(adminGRPCTestConn(t, server))
+
+	stream, err := client.StreamDecisions(context.Background(), &adminpb.StreamDecisionsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	decisions := NewDecisions()
+	server.SetDecisions(decisions)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err = client.StreamDecisions(ctx, &adminpb.StreamDecisionsRequest{})
+	require.NoError(t, err)
+
+	// Published until received, as the stream may not be watching yet
+	go func() {
+		for ctx.Err() == nil {
+			decisions.Publish(AuditRecord{IP: "192.0.2.1", Reason: BlockedByCountry, Rule: "block_country:RU", Status: 403})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	decision, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", decision.Ip)
+	assert.Equal(t, BlockedByCountry, decision.Reason)
+	assert.Equal(t, "block_country:RU", decision.Rule)
+	assert.Equal(t, int32(403), decision.Status)
+}
+
+// Helpers
+
+func newTestAdminGRPCServer(t *testing.T, token string, policies *PolicySource) *AdminGRPCServer {
+	target, _ := url.Parse("http://localhost:3000")
+	server := NewAdminGRPCServer("127.0.0.1:0", token, policies, NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin), nil)
+	require.NoError(t, server.Start())
+	t.Cleanup(func() { server.Stop() })
+
+	return server
+}
+
+func adminGRPCTestToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
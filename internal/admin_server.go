@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminPolicyMaxWait     = time.Minute
+	adminPolicyMaxSize     = 16 << 20
+	adminReadHeaderTimeout = 10 * time.Second
+)
+
+// AdminServer serves the admin HTTP API, for managing the proxy and for other
+// instances to follow it. When a token is set, requests must give it as a
+// bearer token.
+//
+// GET /policy returns the policy in effect as JSON, with its ETag. Given an
+// If-None-Match of the current ETag and a `wait` of some seconds, it holds the
+// request until the policy changes, answering 304 Not Modified if it hasn't by
+// the end of the wait.
+//
+// PUT /policy puts the policy in the body, in the form GET returns, into
+// effect once it's been checked, and returns it with its new ETag. Blocklists
+// are left as the feeds have them. Given an If-Match, the policy is only
+// changed while the one in effect still has that ETag, so that changes made
+// since it was read aren't lost. Replicas refuse, as they follow their
+// primary's policy.
+//
+// POST /geoip/simulate?path=<mmdb> compares the GeoIP2 database in use with the
+// one at the given path, over a sample of recent clients, to show how many of
+// them would be located in a different country before it's put into use.
+//
+// GET /maintenance returns the state of maintenance mode. POST turns it on,
+// with an optional `retry_after` in seconds for the Retry-After header, and
+// DELETE turns it off again (although it stays on while the maintenance file
+// exists).
+//
+// GET /blocklists returns the state of each blocklist feed: how many entries it
+// has, when it was last fetched and changed, and how many requests it has
+// blocked.
+//
+// GET /stats/countries returns the requests counted from each country since
+// startup or the last reset, and DELETE returns them and resets the counts.
+//
+// GET /bans lists the clients banned for being refused too often, and
+// DELETE /bans/<ip> lifts a ban.
+//
+// GET /config/changes returns the recorded changes to the configuration, most
+// recent first.
+//
+// With debugging enabled, it also serves the profiles of net/http/pprof under
+// /debug/pprof/, and a summary of the running process at GET /debug/runtime.
+type AdminServer struct {
+	address  string
+	token    string
+	policies *PolicySource
+	mux      *http.ServeMux
+	server   *http.Server
+	listener net.Listener
+	started  time.Time
+	replica  bool
+
+	geoResolver             *GeoResolver
+	recentClients           *RecentClients
+	geoIP2SuspiciousPercent float64
+	cacheStats              *CacheStats
+	maintenanceMode         *MaintenanceMode
+	blocklistStats          *BlocklistStats
+	countryStats            *CountryStats
+	bans                    *Bans
+	configChanges           *ConfigChangeLog
+}
+
+func NewAdminServer(address, token string, policies *PolicySource) *AdminServer {
+	s := &AdminServer{
+		address:  address,
+		token:    token,
+		policies: policies,
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /policy", s.servePolicy)
+	s.mux.HandleFunc("PUT /policy", s.serveApplyPolicy)
+	s.mux.HandleFunc("POST /geoip/simulate", s.serveGeoIP2Simulation)
+	s.mux.HandleFunc("GET /maintenance", s.serveMaintenance)
+	s.mux.HandleFunc("POST /maintenance", s.serveMaintenance)
+	s.mux.HandleFunc("DELETE /maintenance", s.serveMaintenance)
+	s.mux.HandleFunc("GET /blocklists", s.serveBlocklists)
+	s.mux.HandleFunc("GET /stats/countries", s.serveCountryStats)
+	s.mux.HandleFunc("DELETE /stats/countries", s.serveCountryStats)
+	s.mux.HandleFunc("GET /bans", s.serveBans)
+	s.mux.HandleFunc("DELETE /bans/{ip}", s.serveUnban)
+	s.mux.HandleFunc("GET /config/changes", s.serveConfigChanges)
+
+	s.server = &http.Server{
+		Handler:           s.authenticated(s.mux),
+		ReadHeaderTimeout: adminReadHeaderTimeout,
+	}
+
+	return s
+}
+
+// SetReplica refuses changes to the policy, which replicas take from their
+// primary.
+func (s *AdminServer) SetReplica() {
+	s.replica = true
+}
+
+// SetGeoIP2 enables simulating upgrades of the GeoIP2 country database in
+// use, over the sample of clients kept in recentClients.
+func (s *AdminServer) SetGeoIP2(resolver *GeoResolver, recentClients *RecentClients, suspiciousPercent float64) {
+	s.geoResolver = resolver
+	s.recentClients = recentClients
+	s.geoIP2SuspiciousPercent = suspiciousPercent
+}
+
+// SetMaintenanceMode enables turning maintenance mode on and off.
+func (s *AdminServer) SetMaintenanceMode(mode *MaintenanceMode) {
+	s.maintenanceMode = mode
+}
+
+// SetBlocklistStats enables reporting on the blocklist feeds.
+func (s *AdminServer) SetBlocklistStats(stats *BlocklistStats) {
+	s.blocklistStats = stats
+}
+
+// SetCountryStats enables reporting on the traffic from each country.
+func (s *AdminServer) SetCountryStats(stats *CountryStats) {
+	s.countryStats = stats
+}
+
+// SetBans enables listing and lifting bans.
+func (s *AdminServer) SetBans(bans *Bans) {
+	s.bans = bans
+}
+
+// SetConfigChanges enables listing the changes to the configuration.
+func (s *AdminServer) SetConfigChanges(changes *ConfigChangeLog) {
+	s.configChanges = changes
+}
+
+// Start binds the admin address and begins serving in the background.
+func (s *AdminServer) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.started = time.Now()
+
+	go s.server.Serve(listener)
+
+	slog.Info("Admin server started", "address", listener.Addr().String())
+	return nil
+}
+
+func (s *AdminServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stop closes the server. Requests waiting for the policy to change are
+// abandoned rather than waited for.
+func (s *AdminServer) Stop() error {
+	err := s.server.Close()
+
+	slog.Info("Admin server stopped")
+	return err
+}
+
+// Private
+
+func (s *AdminServer) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *AdminServer) servePolicy(w http.ResponseWriter, r *http.Request) {
+	etag := r.Header.Get("If-None-Match")
+
+	wait, err := strconv.Atoi(r.URL.Query().Get("wait"))
+	if err == nil && wait > 0 && etag != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), min(time.Duration(wait)*time.Second, adminPolicyMaxWait))
+		defer cancel()
+
+		s.policies.Wait(ctx, etag)
+	}
+
+	policy, current := s.policies.Current()
+	w.Header().Set("Etag", current)
+
+	if current == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (s *AdminServer) serveApplyPolicy(w http.ResponseWriter, r *http.Request) {
+	if s.replica {
+		http.Error(w, "The policy is followed from the primary; apply it there", http.StatusConflict)
+		return
+	}
+
+	var policy Policy
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminPolicyMaxSize)).Decode(&policy)
+	if err != nil {
+		http.Error(w, "Invalid policy: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if policy.NeedsGeoIP2() && (s.geoResolver == nil || !s.geoResolver.HasCountries()) {
+		http.Error(w, "Invalid policy: "+ErrPolicyNeedsGeoIP2.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	policy, etag, err := s.policies.Apply(policy, r.Header.Get("If-Match"))
+	w.Header().Set("Etag", etag)
+	if err != nil {
+		http.Error(w, "The policy has changed since it was read", http.StatusPreconditionFailed)
+		return
+	}
+
+	slog.Info("Policy applied through the admin API", "etag", etag)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+func (s *AdminServer) serveGeoIP2Simulation(w http.ResponseWriter, r *http.Request) {
+	if s.geoResolver == nil || !s.geoResolver.HasCountries() {
+		http.Error(w, "GeoIP2 is not enabled", http.StatusNotFound)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	candidate, err := OpenGeoResolver(s.geoResolver.Provider(), []string{path})
+	if candidate == nil {
+		http.Error(w, "Unable to open database: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	defer candidate.Close()
+
+	report := SimulateGeoIP2Upgrade(s.geoResolver, candidate, s.recentClients.Sample(), s.geoIP2SuspiciousPercent)
+	report.Log(path)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (s *AdminServer) serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.maintenanceMode == nil {
+		http.Error(w, "Maintenance mode is not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var retryAfter time.Duration
+		if value := r.URL.Query().Get("retry_after"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				http.Error(w, "retry_after must be a number of seconds", http.StatusBadRequest)
+				return
+			}
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		s.maintenanceMode.Enable(retryAfter)
+	case http.MethodDelete:
+		s.maintenanceMode.Disable()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.maintenanceMode.Status())
+}
+
+func (s *AdminServer) serveBlocklists(w http.ResponseWriter, r *http.Request) {
+	if s.blocklistStats == nil {
+		http.Error(w, "No blocklist feeds are configured", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.blocklistStats.Snapshot())
+}
+
+func (s *AdminServer) serveCountryStats(w http.ResponseWriter, r *http.Request) {
+	if s.countryStats == nil {
+		http.Error(w, "Country stats are not enabled", http.StatusNotFound)
+		return
+	}
+
+	var snapshot CountryStatsSnapshot
+	if r.Method == http.MethodDelete {
+		snapshot = s.countryStats.Reset()
+	} else {
+		snapshot = s.countryStats.Snapshot()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func (s *AdminServer) serveBans(w http.ResponseWriter, r *http.Request) {
+	if s.bans == nil {
+		http.Error(w, "Auto-banning is not enabled", http.StatusNotFound)
+		return
+	}
+
+	bans, err := s.bans.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
+
+func (s *AdminServer) serveUnban(w http.ResponseWriter, r *http.Request) {
+	if s.bans == nil {
+		http.Error(w, "Auto-banning is not enabled", http.StatusNotFound)
+		return
+	}
+
+	ip := net.ParseIP(r.PathValue("ip"))
+	if ip == nil {
+		http.Error(w, "Invalid IP address", http.StatusBadRequest)
+		return
+	}
+
+	err := s.bans.Unban(ip)
+	switch {
+	case errors.Is(err, ErrNotBanned):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *AdminServer) serveConfigChanges(w http.ResponseWriter, r *http.Request) {
+	if s.configChanges == nil {
+		http.Error(w, "The configuration change log is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.configChanges.Changes())
+}
@@ -0,0 +1,360 @@
+package internal
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminServerTestRequest(t *testing.T, server *AdminServer, path, token, etag string) *http.Response {
+	req, err := http.NewRequest("GET", "http://"+server.Addr().String()+path, nil)
+	require.NoError(t, err)
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestAdminServer_policy(t *testing.T) {
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+	_, etag := source.Current()
+
+	server := NewAdminServer("127.0.0.1:0", "", source)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	resp := adminServerTestRequest(t, server, "/policy", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("Etag"))
+
+	var policy Policy
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&policy))
+	assert.Equal(t, []string{"CN"}, policy.BlockCountries)
+
+	resp = adminServerTestRequest(t, server, "/policy", "", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
+
+func TestAdminServer_policy_long_polls_for_changes(t *testing.T) {
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+	_, etag := source.Current()
+
+	server := NewAdminServer("127.0.0.1:0", "", source)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		source.Set(Policy{BlockCountries: []string{"RU"}})
+	}()
+
+	resp := adminServerTestRequest(t, server, "/policy?wait=5", "", etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("Etag"))
+
+	var policy Policy
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&policy))
+	assert.Equal(t, []string{"RU"}, policy.BlockCountries)
+}
+
+func TestAdminServer_requires_token(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "s3cret", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	resp := adminServerTestRequest(t, server, "/policy", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+
+	resp = adminServerTestRequest(t, server, "/policy", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = adminServerTestRequest(t, server, "/policy", "s3cret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAdminServer_geoip_simulate(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	recentClients := NewRecentClients(10)
+	recentClients.Add(net.ParseIP("81.2.69.142"))
+
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	server.SetGeoIP2(resolver, recentClients, 5)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	post := func(query string) *http.Response {
+		resp, err := http.Post("http://"+server.Addr().String()+"/geoip/simulate"+query, "", nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := post("?path=" + url.QueryEscape(fixturePath("GeoLite2-Country.mmdb")))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var report GeoIP2UpgradeReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, 1, report.Sampled)
+	assert.Equal(t, 0, report.Changed)
+
+	assert.Equal(t, http.StatusBadRequest, post("").StatusCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, post("?path=missing.mmdb").StatusCode)
+}
+
+func TestAdminServer_geoip_simulate_when_geoip_disabled(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	resp, err := http.Post("http://"+server.Addr().String()+"/geoip/simulate?path=x", "", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAdminServer_maintenance(t *testing.T) {
+	mode := NewMaintenanceMode(5 * time.Minute)
+
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	server.SetMaintenanceMode(mode)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	maintenance := func(method, query string) (int, MaintenanceModeStatus) {
+		req, err := http.NewRequest(method, "http://"+server.Addr().String()+"/maintenance"+query, nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var status MaintenanceModeStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		return resp.StatusCode, status
+	}
+
+	code, status := maintenance("GET", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, status.Enabled)
+
+	code, status = maintenance("POST", "?retry_after=600")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, MaintenanceModeStatus{Enabled: true, Manual: true, RetryAfter: 600}, status)
+	assert.True(t, mode.Enabled())
+
+	code, _ = maintenance("POST", "?retry_after=soon")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, status = maintenance("DELETE", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, status.Enabled)
+	assert.False(t, mode.Enabled())
+}
+
+func TestAdminServer_maintenance_when_disabled(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	resp := adminServerTestRequest(t, server, "/maintenance", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAdminServer_blocklists(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.Equal(t, http.StatusNotFound, adminServerTestRequest(t, server, "/blocklists", "", "").StatusCode)
+
+	stats := NewBlocklistStats()
+	stats.updated("drop", 1370, 2)
+	stats.Matched("drop")
+	server.SetBlocklistStats(stats)
+
+	resp := adminServerTestRequest(t, server, "/blocklists", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var statuses []BlocklistFeedStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "drop", statuses[0].Name)
+	assert.Equal(t, 1370, statuses[0].Entries)
+	assert.Equal(t, 2, statuses[0].Skipped)
+	assert.Equal(t, uint64(1), statuses[0].Matches)
+}
+
+func TestAdminServer_country_stats(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.Equal(t, http.StatusNotFound, adminServerTestRequest(t, server, "/stats/countries", "", "").StatusCode)
+
+	stats := NewCountryStats()
+	stats.Record("GB", false, http.StatusOK, 0, 100)
+	stats.Record("GB", true, http.StatusForbidden, 0, 14)
+	server.SetCountryStats(stats)
+
+	resp := adminServerTestRequest(t, server, "/stats/countries", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var snapshot CountryStatsSnapshot
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&snapshot))
+	require.Len(t, snapshot.Countries, 1)
+	assert.Equal(t, "GB", snapshot.Countries[0].Country)
+	assert.Equal(t, uint64(2), snapshot.Countries[0].Requests)
+	assert.Equal(t, uint64(1), snapshot.Countries[0].Blocked)
+	assert.Equal(t, map[string]uint64{"2xx": 1, "4xx": 1}, snapshot.Countries[0].Statuses)
+
+	req, err := http.NewRequest(http.MethodDelete, "http://"+server.Addr().String()+"/stats/countries", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	snapshot = CountryStatsSnapshot{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&snapshot))
+	assert.Len(t, snapshot.Countries, 1)
+
+	assert.Empty(t, stats.Snapshot().Countries)
+}
+
+func TestAdminServer_bans(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.Equal(t, http.StatusNotFound, adminServerTestRequest(t, server, "/bans", "", "").StatusCode)
+
+	bans := NewBans(NewMemoryStore(), 1, time.Minute, time.Hour)
+	bans.Offence(net.ParseIP("192.0.2.1"), "status 404")
+	server.SetBans(bans)
+
+	resp := adminServerTestRequest(t, server, "/bans", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var list []Ban
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "192.0.2.1", list[0].IP)
+	assert.Equal(t, "status 404", list[0].Reason)
+
+	unban := func(ip string) int {
+		req, err := http.NewRequest(http.MethodDelete, "http://"+server.Addr().String()+"/bans/"+ip, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusBadRequest, unban("nonsense"))
+	assert.Equal(t, http.StatusNoContent, unban("192.0.2.1"))
+	assert.Equal(t, http.StatusNotFound, unban("192.0.2.1"))
+
+	_, banned := bans.Banned(net.ParseIP("192.0.2.1"))
+	assert.False(t, banned)
+}
+
+func TestAdminServer_config_changes(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	assert.Equal(t, http.StatusNotFound, adminServerTestRequest(t, server, "/config/changes", "", "").StatusCode)
+
+	changes := NewConfigChangeLog(filepath.Join(t.TempDir(), configChangesFileName), 10)
+	changes.Record(State{Options: map[string]string{"RATE_LIMIT": "10:20"}})
+	changes.Record(State{Options: map[string]string{"RATE_LIMIT": "5:10"}})
+	server.SetConfigChanges(changes)
+
+	resp := adminServerTestRequest(t, server, "/config/changes", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var diffs []StateDiff
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&diffs))
+	require.Len(t, diffs, 1)
+	assert.Equal(t, []StateChange{{Kind: StateChangeKindOption, Action: StateChangeModified, Key: "RATE_LIMIT", Old: "10:20", New: "5:10"}}, diffs[0].Changes)
+}
+
+func TestAdminServer_apply_policy(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.0.2.0/24")
+	blocklists := map[string][]*net.IPNet{"spamhaus": {network}}
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN"}, Blocklists: blocklists})
+	_, etag := source.Current()
+
+	server := NewAdminServer("127.0.0.1:0", "", source)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	put := func(body, ifMatch string) *http.Response {
+		req, err := http.NewRequest("PUT", "http://"+server.Addr().String()+"/policy", strings.NewReader(body))
+		require.NoError(t, err)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := put(`{"client_rate_limit":"10:20"}`, "stale")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("Etag"))
+
+	resp = put(`{"client_rate_limit":"10:20"}`, etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	policy, current := source.Current()
+	assert.Equal(t, current, resp.Header.Get("Etag"))
+	assert.NotEqual(t, etag, current)
+	assert.True(t, policy.ClientRateLimit.Enabled())
+	assert.Empty(t, policy.BlockCountries)
+	assert.Equal(t, blocklists, policy.Blocklists)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`{"client_rate_limit":`, "").StatusCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`{"block_countries":["CN"]}`, "").StatusCode)
+
+	_, unchanged := source.Current()
+	assert.Equal(t, current, unchanged)
+}
+
+func TestAdminServer_apply_policy_on_a_replica(t *testing.T) {
+	server := NewAdminServer("127.0.0.1:0", "", NewPolicySource(Policy{}))
+	server.SetReplica()
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	req, err := http.NewRequest("PUT", "http://"+server.Addr().String()+"/policy", strings.NewReader(`{}`))
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+const altSvcMaxAge = 30 * 24 * time.Hour
+
+// AltSvcMiddleware advertises our HTTP/3 listener to clients that reached us
+// over TCP, so they can switch to QUIC for subsequent requests.
+type AltSvcMiddleware struct {
+	value string
+	next  http.Handler
+}
+
+func NewAltSvcMiddleware(port int, next http.Handler) *AltSvcMiddleware {
+	return &AltSvcMiddleware{
+		value: fmt.Sprintf(`%s=":%d"; ma=%d`, http3.NextProtoH3, port, int64(altSvcMaxAge.Seconds())),
+		next:  next,
+	}
+}
+
+func (h *AltSvcMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor < 3 {
+		w.Header().Set("Alt-Svc", h.value)
+	}
+
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAltSvcMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	middleware := NewAltSvcMiddleware(8443, next)
+
+	t.Run("advertises HTTP/3 to TCP clients", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		middleware.ServeHTTP(w, r)
+
+		assert.Equal(t, `h3=":8443"; ma=2592000`, w.Header().Get("Alt-Svc"))
+	})
+
+	t.Run("is not needed on HTTP/3 requests", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		r.ProtoMajor = 3
+		middleware.ServeHTTP(w, r)
+
+		assert.Empty(t, w.Header().Get("Alt-Svc"))
+	})
+}
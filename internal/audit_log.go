@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuditLogKind string
+
+const (
+	AuditLogFile   AuditLogKind = "file"
+	AuditLogSyslog AuditLogKind = "syslog"
+	AuditLogUDP    AuditLogKind = "udp"
+)
+
+var (
+	ErrInvalidAuditLog        = errors.New("audit log must be a file path, syslog, or udp://host:port")
+	ErrAuditSyslogUnsupported = errors.New("syslog is not supported on this platform")
+)
+
+// AuditLogDestination is where audit records are written: a file, the local
+// syslog daemon, or a UDP endpoint, such as a log collector.
+type AuditLogDestination struct {
+	Kind   AuditLogKind
+	Target string
+}
+
+// ParseAuditLogDestination parses `syslog`, `udp://host:port`, or else a file
+// path. An empty value means no audit log.
+func ParseAuditLogDestination(value string) (AuditLogDestination, error) {
+	value = strings.TrimSpace(value)
+
+	switch {
+	case value == "":
+		return AuditLogDestination{}, nil
+	case strings.EqualFold(value, "syslog"):
+		return AuditLogDestination{Kind: AuditLogSyslog}, nil
+	case strings.HasPrefix(value, "udp://"):
+		endpoint, err := url.Parse(value)
+		if err != nil || endpoint.Path != "" || endpoint.Port() == "" || endpoint.Hostname() == "" {
+			return AuditLogDestination{}, ErrInvalidAuditLog
+		}
+		return AuditLogDestination{Kind: AuditLogUDP, Target: endpoint.Host}, nil
+	case strings.Contains(value, "://"):
+		return AuditLogDestination{}, ErrInvalidAuditLog
+	default:
+		return AuditLogDestination{Kind: AuditLogFile, Target: value}, nil
+	}
+}
+
+func (d AuditLogDestination) Enabled() bool {
+	return d.Kind != ""
+}
+
+// String formats the destination in the form that ParseAuditLogDestination
+// reads.
+func (d AuditLogDestination) String() string {
+	switch d.Kind {
+	case AuditLogSyslog:
+		return "syslog"
+	case AuditLogUDP:
+		return "udp://" + d.Target
+	default:
+		return d.Target
+	}
+}
+
+// Open opens the destination for writing. Files are appended to, and never
+// rotated by us, so that no record is lost; each record sent over UDP is a
+// datagram of its own.
+func (d AuditLogDestination) Open() (io.WriteCloser, error) {
+	switch d.Kind {
+	case AuditLogSyslog:
+		return openAuditSyslog()
+	case AuditLogUDP:
+		return net.Dial("udp", d.Target)
+	default:
+		return NewRotatingFile(d.Target, 0, 0, 0)
+	}
+}
+
+// AuditRecord describes a request we refused, and why.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	Country   string    `json:"country,omitempty"`
+	ASN       string    `json:"asn,omitempty"`
+	Reason    string    `json:"reason"`
+	Rule      string    `json:"rule,omitempty"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// AuditLog writes a JSON line for every request we refuse, so that there's a
+// complete record of them in one place, whatever the log level, and whether
+// or not requests are logged.
+//
+// A nil *AuditLog is valid, and writes nothing.
+type AuditLog struct {
+	sync.Mutex
+	out io.Writer
+}
+
+func NewAuditLog(out io.Writer) *AuditLog {
+	return &AuditLog{out: out}
+}
+
+func (l *AuditLog) Write(record AuditRecord) {
+	if l == nil {
+		return
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	// Written in one go, so that each record is a single datagram or
+	// syslog message
+	_, err = l.out.Write(append(data, '\n'))
+	if err != nil {
+		slog.Error("Unable to write audit record", "ip", record.IP, "reason", record.Reason, "error", err)
+	}
+}
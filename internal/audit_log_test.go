@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAuditLogDestination(t *testing.T) {
+	tests := map[string]AuditLogDestination{
+		"":                        {},
+		"syslog":                  {Kind: AuditLogSyslog},
+		"SYSLOG":                  {Kind: AuditLogSyslog},
+		"udp://10.0.0.5:5140":     {Kind: AuditLogUDP, Target: "10.0.0.5:5140"},
+		"udp://[2001:db8::1]:514": {Kind: AuditLogUDP, Target: "[2001:db8::1]:514"},
+		"/var/log/audit.jsonl":    {Kind: AuditLogFile, Target: "/var/log/audit.jsonl"},
+		"log/audit.jsonl":         {Kind: AuditLogFile, Target: "log/audit.jsonl"},
+	}
+
+	for value, expected := range tests {
+		destination, err := ParseAuditLogDestination(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, destination, value)
+
+		if value != "" && value != "SYSLOG" {
+			assert.Equal(t, value, destination.String())
+		}
+	}
+
+	for _, value := range []string{"udp://10.0.0.5", "udp://:514", "udp://10.0.0.5:514/path", "tcp://10.0.0.5:514", "https://example.com"} {
+		_, err := ParseAuditLogDestination(value)
+		assert.ErrorIs(t, err, ErrInvalidAuditLog, value)
+	}
+}
+
+func TestAuditLog_Write(t *testing.T) {
+	var out bytes.Buffer
+	auditLog := NewAuditLog(&out)
+
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	auditLog.Write(AuditRecord{Time: at, IP: "192.0.2.1", Country: "RU", Reason: BlockedByCountry, Rule: "block_country:RU", Method: "GET", Host: "example.com", Path: "/", Status: 403, UserAgent: "curl/8.0"})
+	auditLog.Write(AuditRecord{Time: at, IP: "192.0.2.2", Reason: BlockedByBan, Method: "POST", Host: "example.com", Path: "/login", Status: 403})
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	assert.JSONEq(t, `{"time":"2026-10-16T12:00:00Z","ip":"192.0.2.1","country":"RU","reason":"country","rule":"block_country:RU","method":"GET","host":"example.com","path":"/","status":403,"user_agent":"curl/8.0"}`, lines[0])
+	assert.JSONEq(t, `{"time":"2026-10-16T12:00:00Z","ip":"192.0.2.2","reason":"ban","method":"POST","host":"example.com","path":"/login","status":403,"user_agent":""}`, lines[1])
+}
+
+func TestAuditLog_nil_writes_nothing(t *testing.T) {
+	var auditLog *AuditLog
+	auditLog.Write(AuditRecord{IP: "192.0.2.1"})
+}
+
+func TestAuditLogDestination_Open_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o644))
+
+	out, err := AuditLogDestination{Kind: AuditLogFile, Target: path}.Open()
+	require.NoError(t, err)
+
+	NewAuditLog(out).Write(AuditRecord{IP: "192.0.2.1", Reason: BlockedByBlocklist})
+	require.NoError(t, out.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2, "appends to the file")
+	assert.Contains(t, lines[1], `"reason":"blocklist"`)
+}
+
+func TestAuditLogDestination_Open_udp(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	out, err := AuditLogDestination{Kind: AuditLogUDP, Target: listener.LocalAddr().String()}.Open()
+	require.NoError(t, err)
+	defer out.Close()
+
+	auditLog := NewAuditLog(out)
+	auditLog.Write(AuditRecord{IP: "192.0.2.1", Reason: BlockedByRateLimit, Rule: "rate_limit"})
+	auditLog.Write(AuditRecord{IP: "192.0.2.2", Reason: BlockedByGeofence})
+
+	buffer := make([]byte, 2048)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buffer)
+		require.NoError(t, err)
+
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal(buffer[:n], &record))
+		assert.Equal(t, ip, record.IP)
+	}
+}
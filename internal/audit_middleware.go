@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"net/http"
+	"time"
+)
+
+// AuditMiddleware writes an audit record for every request refused by a
+// later stage, and passes it on to those watching the decisions.
+type AuditMiddleware struct {
+	auditLog  *AuditLog
+	decisions *Decisions
+	next      http.Handler
+}
+
+func NewAuditMiddleware(auditLog *AuditLog, decisions *Decisions, next http.Handler) *AuditMiddleware {
+	return &AuditMiddleware{
+		auditLog:  auditLog,
+		decisions: decisions,
+		next:      next,
+	}
+}
+
+func (m *AuditMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, tags := WithRequestTags(r)
+	writer := newResponseWriter(w)
+
+	m.next.ServeHTTP(writer, r)
+
+	reason := tags.Get(TagBlocked)
+	if reason == "" {
+		return
+	}
+
+	host, _ := clientIP(r)
+	record := AuditRecord{
+		Time:      time.Now().UTC(),
+		IP:        host,
+		Country:   tags.Get(TagCountry),
+		ASN:       tags.Get(TagASN),
+		Reason:    reason,
+		Rule:      tags.Get(TagBlockedRule),
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Status:    writer.statusCode,
+		UserAgent: r.UserAgent(),
+		RequestID: tags.Get(TagRequestID),
+	}
+
+	m.auditLog.Write(record)
+	m.decisions.Publish(record)
+}
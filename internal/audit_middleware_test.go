@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tags := RequestTagsFromContext(r.Context())
+		tags.Set(TagCountry, "RU")
+		tags.Set(TagASN, "12389")
+		if r.URL.Path == "/admin" {
+			tags.Set(TagBlocked, BlockedByCountry)
+			tags.Set(TagBlockedRule, "block_country:RU")
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	middleware := NewAuditMiddleware(NewAuditLog(&out), nil, next)
+
+	serve := func(path string) {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("User-Agent", "curl/8.0")
+		middleware.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve("/")
+	serve("/admin")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 1)
+
+	var record AuditRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+
+	assert.WithinDuration(t, time.Now(), record.Time, time.Minute)
+	record.Time = time.Time{}
+
+	assert.Equal(t, AuditRecord{
+		IP:        "192.0.2.1",
+		Country:   "RU",
+		ASN:       "12389",
+		Reason:    BlockedByCountry,
+		Rule:      "block_country:RU",
+		Method:    "GET",
+		Host:      "example.com",
+		Path:      "/admin",
+		Status:    http.StatusForbidden,
+		UserAgent: "curl/8.0",
+	}, record)
+}
+
+func TestAuditMiddleware_publishes_decisions(t *testing.T) {
+	decisions := NewDecisions()
+	records, stop := decisions.Watch()
+	defer stop()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RequestTagsFromContext(r.Context()).Set(TagBlocked, BlockedByCountry)
+		http.Error(w, "Access denied", http.StatusForbidden)
+	})
+	middleware := NewAuditMiddleware(nil, decisions, next)
+
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	middleware.ServeHTTP(httptest.NewRecorder(), r)
+
+	require.Len(t, records, 1)
+	record := <-records
+	assert.Equal(t, "192.0.2.1", record.IP)
+	assert.Equal(t, BlockedByCountry, record.Reason)
+}
+
+func TestHandlerAuditLog(t *testing.T) {
+	var out bytes.Buffer
+
+	options := handlerOptions("http://localhost:3000")
+	options.blocklists = testBlocklists(t, map[string][]string{"drop": {"203.0.113.0/24"}})
+	options.auditLog = NewAuditLog(&out)
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/login", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	h.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	var record AuditRecord
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "203.0.113.7", record.IP)
+	assert.Equal(t, BlockedByBlocklist, record.Reason)
+	assert.Equal(t, "blocklist_feed:drop", record.Rule)
+	assert.Equal(t, "/login", record.Path)
+}
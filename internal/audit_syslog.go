@@ -0,0 +1,12 @@
+//go:build !windows
+
+package internal
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func openAuditSyslog() (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, "thruster")
+}
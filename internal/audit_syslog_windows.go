@@ -0,0 +1,11 @@
+//go:build windows
+
+package internal
+
+import (
+	"io"
+)
+
+func openAuditSyslog() (io.WriteCloser, error) {
+	return nil, ErrAuditSyslogUnsupported
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidBanStatus = errors.New("ban statuses must be status codes, such as 401, or classes, such as 4xx")
+
+// BanStatus is a response status, or a class of them, that counts as an
+// offence.
+type BanStatus struct {
+	Min, Max int
+}
+
+// ParseBanStatus parses a status code, such as `401`, or a class, such as
+// `4xx`.
+func ParseBanStatus(value string) (BanStatus, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	if len(value) == 3 && strings.HasSuffix(value, "xx") && value[0] >= '1' && value[0] <= '5' {
+		class := int(value[0]-'0') * 100
+		return BanStatus{Min: class, Max: class + 99}, nil
+	}
+
+	code, err := strconv.Atoi(value)
+	if err != nil || code < 100 || code > 599 {
+		return BanStatus{}, ErrInvalidBanStatus
+	}
+	return BanStatus{Min: code, Max: code}, nil
+}
+
+func (s BanStatus) String() string {
+	if s.Min == s.Max {
+		return strconv.Itoa(s.Min)
+	}
+	return strconv.Itoa(s.Min/100) + "xx"
+}
+
+type BanStatuses []BanStatus
+
+func ParseBanStatuses(values []string) (BanStatuses, error) {
+	statuses := BanStatuses{}
+	for _, value := range values {
+		status, err := ParseBanStatus(value)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (s BanStatuses) Contains(code int) bool {
+	for _, status := range s {
+		if code >= status.Min && code <= status.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func (s BanStatuses) String() string {
+	values := make([]string, len(s))
+	for i, status := range s {
+		values[i] = status.String()
+	}
+	return strings.Join(values, ",")
+}
+
+// AutoBanMiddleware refuses requests from banned clients, and counts an
+// offence against the client whenever a request is refused by a later stage,
+// or answered by the app with one of the offending statuses. Requests from
+// local and internal addresses are never counted or refused.
+type AutoBanMiddleware struct {
+	logger      *slog.Logger
+	next        http.Handler
+	bans        *Bans
+	statuses    BanStatuses
+	blockedPage *PageTemplate
+}
+
+func NewAutoBanMiddleware(logger *slog.Logger, next http.Handler, bans *Bans) *AutoBanMiddleware {
+	return &AutoBanMiddleware{
+		logger: logger,
+		next:   next,
+		bans:   bans,
+	}
+}
+
+// SetStatuses has responses from the app with any of the statuses counted as
+// offences, as well as the requests we refuse.
+func (m *AutoBanMiddleware) SetStatuses(statuses BanStatuses) {
+	m.statuses = statuses
+}
+
+// SetBlockedPage sets the page served to banned clients, in place of a plain
+// "Access denied".
+func (m *AutoBanMiddleware) SetBlockedPage(page *PageTemplate) {
+	m.blockedPage = page
+}
+
+func (m *AutoBanMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, ip := clientIP(r)
+	if ip == nil || isLocalOrInternalIP(ip) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	r, tags := WithRequestTags(r)
+
+	if ban, banned := m.bans.Banned(ip); banned {
+		m.logger.InfoContext(r.Context(), "Request blocked - client banned", "ip", host, "path", r.URL.Path, "expires_at", ban.ExpiresAt)
+		m.writeBlocked(w, r, "ban:"+ban.Reason)
+		return
+	}
+
+	writer := newResponseWriter(w)
+	m.next.ServeHTTP(writer, r)
+
+	if reason := m.offenceReason(tags, writer.statusCode); reason != "" {
+		m.bans.Offence(ip, reason)
+	}
+}
+
+// Private
+
+func (m *AutoBanMiddleware) writeBlocked(w http.ResponseWriter, r *http.Request, rule string) {
+	tags := RequestTagsFromContext(r.Context())
+	tags.Set(TagBlocked, BlockedByBan)
+	tags.Set(TagBlockedRule, rule)
+
+	if m.blockedPage != nil {
+		m.blockedPage.Render(w, r, http.StatusForbidden)
+		return
+	}
+
+	http.Error(w, "Access denied", http.StatusForbidden)
+}
+
+// offenceReason describes why a response counts against the client, or is
+// empty when it doesn't.
+func (m *AutoBanMiddleware) offenceReason(tags *RequestTags, statusCode int) string {
+	if blocked := tags.Get(TagBlocked); blocked != "" {
+		return "blocked by " + blocked
+	}
+	if m.statuses.Contains(statusCode) {
+		return "status " + strconv.Itoa(statusCode)
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoBanMiddleware(t *testing.T) {
+	bans := NewBans(NewMemoryStore(), 2, time.Minute, time.Hour)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	middleware := NewAutoBanMiddleware(slog.Default(), next, bans)
+	middleware.SetStatuses(BanStatuses{{Min: 404, Max: 404}})
+
+	serve := func(remoteAddr, path string) (*httptest.ResponseRecorder, *RequestTags) {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = remoteAddr
+		r, tags := WithRequestTags(r)
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		return w, tags
+	}
+
+	w, _ := serve("192.0.2.1:1234", "/")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = serve("192.0.2.1:1234", "/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = serve("192.0.2.1:1234", "/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, tags := serve("192.0.2.1:1234", "/")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, BlockedByBan, tags.Get(TagBlocked))
+
+	w, _ = serve("192.0.2.2:1234", "/")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAutoBanMiddleware_counts_blocked_requests(t *testing.T) {
+	bans := NewBans(NewMemoryStore(), 1, time.Minute, time.Hour)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RequestTagsFromContext(r.Context()).Set(TagBlocked, BlockedByCountry)
+		w.Write([]byte("blocked, but politely"))
+	})
+	middleware := NewAutoBanMiddleware(slog.Default(), next, bans)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	middleware.ServeHTTP(httptest.NewRecorder(), r)
+
+	list, _ := bans.List()
+	assert.Len(t, list, 1)
+	assert.Equal(t, "blocked by country", list[0].Reason)
+}
+
+func TestAutoBanMiddleware_never_bans_internal_addresses(t *testing.T) {
+	bans := NewBans(NewMemoryStore(), 1, time.Minute, time.Hour)
+	middleware := NewAutoBanMiddleware(slog.Default(), http.NotFoundHandler(), bans)
+	middleware.SetStatuses(BanStatuses{{Min: 400, Max: 499}})
+
+	for range 3 {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+
+	list, _ := bans.List()
+	assert.Empty(t, list)
+}
+
+func TestAutoBanMiddleware_ignores_app_statuses_by_default(t *testing.T) {
+	bans := NewBans(NewMemoryStore(), 1, time.Minute, time.Hour)
+	middleware := NewAutoBanMiddleware(slog.Default(), http.NotFoundHandler(), bans)
+
+	for range 3 {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+
+	list, _ := bans.List()
+	assert.Empty(t, list)
+}
+
+func TestAutoBanMiddleware_bans_the_trusted_client_address(t *testing.T) {
+	trusted, err := ParseCIDRs(DefaultTrustedProxies)
+	require.NoError(t, err)
+
+	bans := NewBans(NewMemoryStore(), 1, time.Minute, time.Hour)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RequestTagsFromContext(r.Context()).Set(TagBlocked, BlockedByCountry)
+		w.WriteHeader(http.StatusForbidden)
+	})
+	handler := NewClientAddressMiddleware(NewTrustedProxies(trusted), NewAutoBanMiddleware(slog.Default(), next, bans))
+
+	// An offender can't have someone else banned in their place
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("X-Forwarded-For", "192.0.2.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	list, _ := bans.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, "203.0.113.7", list[0].IP)
+}
+
+func TestParseBanStatuses(t *testing.T) {
+	statuses, err := ParseBanStatuses([]string{"401", "4XX", " 503 "})
+	require.NoError(t, err)
+	assert.Equal(t, BanStatuses{{Min: 401, Max: 401}, {Min: 400, Max: 499}, {Min: 503, Max: 503}}, statuses)
+	assert.Equal(t, "401,4xx,503", statuses.String())
+
+	assert.True(t, statuses.Contains(404))
+	assert.True(t, statuses.Contains(503))
+	assert.False(t, statuses.Contains(500))
+	assert.False(t, BanStatuses{}.Contains(404))
+
+	for _, value := range []string{"", "99", "600", "6xx", "4x", "not-found"} {
+		_, err := ParseBanStatuses([]string{value})
+		assert.ErrorIs(t, err, ErrInvalidBanStatus, value)
+	}
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+)
+
+const banOffenceKeyPrefix = "offences:"
+
+var ErrNotBanned = errors.New("address is not banned")
+
+// Ban is a client that has been refused too often, and is now refused
+// outright until it expires.
+type Ban struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	Offences  int64     `json:"offences"`
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Bans keeps track of clients that keep getting refused, in the manner of
+// fail2ban: a client with threshold offences within the window is banned for
+// the duration. Offences and bans are kept in the state store, so that bans
+// survive restarts, and are shared between instances using the same Redis.
+type Bans struct {
+	store          Store
+	threshold      int
+	window         time.Duration
+	duration       time.Duration
+	events         *EventDispatcher
+	getCurrentTime GetCurrentTime
+}
+
+func NewBans(store Store, threshold int, window, duration time.Duration) *Bans {
+	return &Bans{
+		store:          store,
+		threshold:      threshold,
+		window:         window,
+		duration:       duration,
+		getCurrentTime: time.Now,
+	}
+}
+
+// SetEvents publishes an event for each new ban.
+func (b *Bans) SetEvents(events *EventDispatcher) {
+	b.events = events
+}
+
+// Banned returns the ban on an address, if there is one.
+func (b *Bans) Banned(ip net.IP) (Ban, bool) {
+	value, ok := b.store.Get(StoreBucketBans, ip.String())
+	if !ok {
+		return Ban{}, false
+	}
+
+	var ban Ban
+	err := json.Unmarshal(value, &ban)
+	if err != nil {
+		slog.Error("Bans: unable to read ban", "ip", ip.String(), "error", err)
+		return Ban{}, false
+	}
+
+	return ban, true
+}
+
+// Offence records an offence by an address, banning it when it reaches the
+// threshold. It returns the ban, when there's a new one.
+func (b *Bans) Offence(ip net.IP, reason string) (Ban, bool) {
+	offences, err := b.store.Increment(StoreBucketCounters, banOffenceKeyPrefix+ip.String(), 1, b.window)
+	if err != nil {
+		slog.Error("Bans: unable to record offence", "ip", ip.String(), "error", err)
+		return Ban{}, false
+	}
+
+	if offences != int64(b.threshold) {
+		return Ban{}, false
+	}
+
+	now := b.getCurrentTime()
+	ban := Ban{
+		IP:        ip.String(),
+		Reason:    reason,
+		Offences:  offences,
+		BannedAt:  now,
+		ExpiresAt: now.Add(b.duration),
+	}
+
+	value, err := json.Marshal(ban)
+	if err == nil {
+		err = b.store.Set(StoreBucketBans, ban.IP, value, b.duration)
+	}
+	if err != nil {
+		slog.Error("Bans: unable to store ban", "ip", ban.IP, "error", err)
+		return Ban{}, false
+	}
+
+	slog.Info("Client banned", "ip", ban.IP, "offences", offences, "window", b.window.String(), "duration", b.duration.String(), "reason", reason)
+	b.events.Publish(EventClientBanned, map[string]any{
+		"ip":         ban.IP,
+		"reason":     ban.Reason,
+		"offences":   ban.Offences,
+		"expires_at": ban.ExpiresAt,
+	})
+
+	return ban, true
+}
+
+// Unban lifts the ban on an address, and forgets its offences.
+func (b *Bans) Unban(ip net.IP) error {
+	_, banned := b.Banned(ip)
+	if !banned {
+		return ErrNotBanned
+	}
+
+	err := b.store.Delete(StoreBucketBans, ip.String())
+	if err != nil {
+		return err
+	}
+
+	err = b.store.Delete(StoreBucketCounters, banOffenceKeyPrefix+ip.String())
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Client unbanned", "ip", ip.String())
+	return nil
+}
+
+// List returns the bans in effect, soonest to expire first.
+func (b *Bans) List() ([]Ban, error) {
+	items, err := b.store.List(StoreBucketBans)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list bans: %w", err)
+	}
+
+	bans := []Ban{}
+	for key, value := range items {
+		var ban Ban
+		err := json.Unmarshal(value, &ban)
+		if err != nil {
+			slog.Error("Bans: unable to read ban", "ip", key, "error", err)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	slices.SortFunc(bans, func(a, b Ban) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+
+	return bans, nil
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBans_ban_after_threshold(t *testing.T) {
+	bans := NewBans(NewMemoryStore(), 3, time.Minute, time.Hour)
+	ip := net.ParseIP("192.0.2.1")
+
+	_, banned := bans.Offence(ip, "status 404")
+	assert.False(t, banned)
+	_, banned = bans.Offence(ip, "status 404")
+	assert.False(t, banned)
+
+	_, banned = bans.Banned(ip)
+	assert.False(t, banned)
+
+	ban, banned := bans.Offence(ip, "blocked by country")
+	require.True(t, banned)
+	assert.Equal(t, "192.0.2.1", ban.IP)
+	assert.Equal(t, "blocked by country", ban.Reason)
+	assert.Equal(t, int64(3), ban.Offences)
+	assert.Equal(t, time.Hour, ban.ExpiresAt.Sub(ban.BannedAt))
+
+	found, banned := bans.Banned(ip)
+	assert.True(t, banned)
+	assert.Equal(t, ban.IP, found.IP)
+
+	_, banned = bans.Banned(net.ParseIP("192.0.2.2"))
+	assert.False(t, banned)
+}
+
+func TestBans_publishes_events(t *testing.T) {
+	events := NewEventDispatcher(nil, nil, "", 100, 0)
+	bans := NewBans(NewMemoryStore(), 1, time.Minute, time.Hour)
+	bans.SetEvents(events)
+
+	bans.Offence(net.ParseIP("192.0.2.1"), "status 404")
+
+	event := <-events.queue
+	assert.Equal(t, EventClientBanned, event.Type)
+	assert.Equal(t, "192.0.2.1", event.Data["ip"])
+	assert.Equal(t, "status 404", event.Data["reason"])
+}
+
+func TestBans_offences_expire_after_window(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.getCurrentTime = func() time.Time { return now }
+
+	bans := NewBans(store, 2, time.Minute, time.Hour)
+	ip := net.ParseIP("192.0.2.1")
+
+	bans.Offence(ip, "status 404")
+	now = now.Add(2 * time.Minute)
+
+	_, banned := bans.Offence(ip, "status 404")
+	assert.False(t, banned)
+}
+
+func TestBans_bans_expire(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.getCurrentTime = func() time.Time { return now }
+
+	bans := NewBans(store, 1, time.Minute, time.Hour)
+	ip := net.ParseIP("192.0.2.1")
+
+	bans.Offence(ip, "status 404")
+	_, banned := bans.Banned(ip)
+	assert.True(t, banned)
+
+	now = now.Add(time.Hour)
+	_, banned = bans.Banned(ip)
+	assert.False(t, banned)
+}
+
+func TestBans_unban(t *testing.T) {
+	bans := NewBans(NewMemoryStore(), 2, time.Minute, time.Hour)
+	ip := net.ParseIP("2001:db8::1")
+
+	assert.ErrorIs(t, bans.Unban(ip), ErrNotBanned)
+
+	bans.Offence(ip, "status 404")
+	bans.Offence(ip, "status 404")
+	require.NoError(t, bans.Unban(ip))
+
+	_, banned := bans.Banned(ip)
+	assert.False(t, banned)
+
+	_, banned = bans.Offence(ip, "status 404")
+	assert.False(t, banned, "offences are forgotten when unbanned")
+}
+
+func TestBans_list(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.getCurrentTime = func() time.Time { return now }
+
+	bans := NewBans(store, 1, time.Minute, time.Hour)
+	bans.getCurrentTime = store.getCurrentTime
+
+	bans.Offence(net.ParseIP("192.0.2.1"), "status 404")
+	now = now.Add(time.Minute)
+	bans.Offence(net.ParseIP("192.0.2.2"), "blocked by blocklist")
+
+	list, err := bans.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "192.0.2.1", list[0].IP)
+	assert.Equal(t, "192.0.2.2", list[1].IP)
+	assert.Equal(t, "blocked by blocklist", list[1].Reason)
+}
+
+func TestBans_survive_reopening_the_store(t *testing.T) {
+	path := t.TempDir() + "/state.db"
+	ip := net.ParseIP("192.0.2.1")
+
+	store, err := NewBoltStore(path)
+	require.NoError(t, err)
+	NewBans(store, 1, time.Minute, time.Hour).Offence(ip, "status 404")
+	require.NoError(t, store.Close())
+
+	store, err = NewBoltStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, banned := NewBans(store, 1, time.Minute, time.Hour).Banned(ip)
+	assert.True(t, banned)
+}
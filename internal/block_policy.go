@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// BlockPolicy decides what happens to a request from a blocked country.
+type BlockPolicy string
+
+const (
+	// BlockPolicyDeny rejects the request with a 403
+	BlockPolicyDeny BlockPolicy = "deny"
+	// BlockPolicyAllow lets the request through to the upstream as usual
+	BlockPolicyAllow BlockPolicy = "allow"
+	// BlockPolicyEmpty answers with an empty 204, without involving the upstream
+	BlockPolicyEmpty BlockPolicy = "empty"
+)
+
+var ErrInvalidBlockPolicy = errors.New("block policy must be one of deny, allow, or empty")
+
+func ParseBlockPolicy(value string) (BlockPolicy, error) {
+	policy := BlockPolicy(strings.ToLower(strings.TrimSpace(value)))
+
+	switch policy {
+	case BlockPolicyDeny, BlockPolicyAllow, BlockPolicyEmpty:
+		return policy, nil
+	case "":
+		return BlockPolicyDeny, nil
+	default:
+		return "", ErrInvalidBlockPolicy
+	}
+}
+
+// BlockPolicies lets OPTIONS and HEAD requests be treated differently from
+// others when they come from a blocked country. CORS preflights in particular
+// can arrive from an unexpected location when a CDN forwards them, and
+// refusing them breaks the real request that would follow.
+type BlockPolicies struct {
+	Options BlockPolicy
+	Head    BlockPolicy
+}
+
+func (p BlockPolicies) For(method string) BlockPolicy {
+	var policy BlockPolicy
+
+	switch method {
+	case http.MethodOptions:
+		policy = p.Options
+	case http.MethodHead:
+		policy = p.Head
+	}
+
+	if policy == "" {
+		return BlockPolicyDeny
+	}
+	return policy
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBlockPolicy(t *testing.T) {
+	for _, value := range []string{"deny", "allow", "empty", " Allow "} {
+		_, err := ParseBlockPolicy(value)
+		assert.NoError(t, err, value)
+	}
+
+	policy, err := ParseBlockPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, BlockPolicyDeny, policy)
+
+	_, err = ParseBlockPolicy("redirect")
+	assert.ErrorIs(t, err, ErrInvalidBlockPolicy)
+}
+
+func TestBlockPolicies_For(t *testing.T) {
+	policies := BlockPolicies{Options: BlockPolicyAllow, Head: BlockPolicyEmpty}
+
+	assert.Equal(t, BlockPolicyAllow, policies.For(http.MethodOptions))
+	assert.Equal(t, BlockPolicyEmpty, policies.For(http.MethodHead))
+	assert.Equal(t, BlockPolicyDeny, policies.For(http.MethodGet))
+	assert.Equal(t, BlockPolicyDeny, BlockPolicies{}.For(http.MethodOptions))
+}
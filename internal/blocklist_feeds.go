@@ -0,0 +1,420 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	blocklistFetchTimeout = 60 * time.Second
+	blocklistMaxSize      = 32 * MB
+)
+
+var (
+	ErrInvalidBlocklistFeed = errors.New("blocklist feed must be in the form name=url, with an http or https URL")
+
+	blocklistFeedName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+)
+
+// BlocklistFeed is a list of addresses to refuse requests from, published at a
+// URL, such as Spamhaus DROP, a FireHOL set, or an internal threat feed.
+type BlocklistFeed struct {
+	Name string
+	URL  *url.URL
+}
+
+// ParseBlocklistFeed parses a feed written as `name=url`, where the name is
+// made of lowercase letters, digits, dashes and underscores.
+func ParseBlocklistFeed(value string) (BlocklistFeed, error) {
+	name, rawURL, ok := strings.Cut(strings.TrimSpace(value), "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !ok || !blocklistFeedName.MatchString(name) {
+		return BlocklistFeed{}, ErrInvalidBlocklistFeed
+	}
+
+	feedURL, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (feedURL.Scheme != "http" && feedURL.Scheme != "https") || feedURL.Host == "" {
+		return BlocklistFeed{}, ErrInvalidBlocklistFeed
+	}
+
+	return BlocklistFeed{Name: name, URL: feedURL}, nil
+}
+
+// String formats the feed in the form that ParseBlocklistFeed reads, with any
+// credentials in the URL hidden.
+func (f BlocklistFeed) String() string {
+	return f.Name + "=" + f.URL.Redacted()
+}
+
+// ParseBlocklist reads the addresses in a blocklist. It understands the
+// common plain text formats: one address or CIDR range per line, with
+// comments starting with `#` or `;`, either on lines of their own or after the
+// entry (as in Spamhaus DROP), and with any further fields on the line
+// separated by whitespace or commas. Lines that don't start with an address
+// are skipped, and counted.
+func ParseBlocklist(r io.Reader) ([]*net.IPNet, int, error) {
+	networks := []*net.IPNet{}
+	skipped := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+
+		parsed, err := ParseCIDRs(fields[:1])
+		if err != nil {
+			skipped++
+			continue
+		}
+		networks = append(networks, parsed...)
+	}
+
+	return networks, skipped, scanner.Err()
+}
+
+// BlocklistFeeds keeps the blocklists in the policy up to date, downloading
+// each feed again every interval. Feeds are fetched conditionally, so that an
+// unchanged list costs the publisher next to nothing. When a feed can't be
+// fetched, the last list we had from it stays in effect.
+//
+// The lists are part of the policy, so replicas take them from their primary
+// rather than fetching them themselves.
+type BlocklistFeeds struct {
+	feeds    []BlocklistFeed
+	interval time.Duration
+	policies *PolicySource
+	stats    *BlocklistStats
+	events   *EventDispatcher
+	client   *http.Client
+
+	// Validators from the last successful fetch of each feed, for conditional
+	// requests. Only touched by Refresh.
+	validators map[string]blocklistValidators
+
+	refreshing sync.Mutex
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+type blocklistValidators struct {
+	etag         string
+	lastModified string
+}
+
+func NewBlocklistFeeds(feeds []BlocklistFeed, interval time.Duration, policies *PolicySource, stats *BlocklistStats) *BlocklistFeeds {
+	return &BlocklistFeeds{
+		feeds:      feeds,
+		interval:   interval,
+		policies:   policies,
+		stats:      stats,
+		client:     &http.Client{Timeout: blocklistFetchTimeout},
+		validators: map[string]blocklistValidators{},
+	}
+}
+
+// SetEvents publishes an event whenever a feed's list changes.
+func (f *BlocklistFeeds) SetEvents(events *EventDispatcher) {
+	f.events = events
+}
+
+// Start refreshes the feeds every interval in the background, until stopped.
+func (f *BlocklistFeeds) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				f.Refresh(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (f *BlocklistFeeds) Stop() error {
+	if f.cancel != nil {
+		f.cancel()
+		f.wg.Wait()
+	}
+	return nil
+}
+
+// Refresh fetches every feed, and puts those that changed into effect. It
+// returns the errors of any feeds that couldn't be fetched.
+func (f *BlocklistFeeds) Refresh(ctx context.Context) error {
+	f.refreshing.Lock()
+	defer f.refreshing.Unlock()
+
+	changed := map[string][]*net.IPNet{}
+	var errs []error
+
+	for _, feed := range f.feeds {
+		networks, err := f.fetch(ctx, feed)
+		if err != nil {
+			f.stats.failed(feed.Name, err)
+			slog.Warn("Blocklist: unable to fetch feed; keeping the last list", "feed", feed.Name, "url", feed.URL.Redacted(), "error", err, "last_updated", f.stats.lastUpdated(feed.Name))
+			errs = append(errs, fmt.Errorf("blocklist %s: %w", feed.Name, err))
+			continue
+		}
+
+		if networks != nil {
+			changed[feed.Name] = networks
+		}
+	}
+
+	if len(changed) > 0 {
+		f.policies.Update(func(policy Policy) Policy {
+			blocklists := maps.Clone(policy.Blocklists)
+			if blocklists == nil {
+				blocklists = map[string][]*net.IPNet{}
+			}
+			maps.Copy(blocklists, changed)
+
+			policy.Blocklists = blocklists
+			return policy
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// Private
+
+// fetch downloads a feed, returning nil networks when it hasn't changed since
+// we last fetched it.
+func (f *BlocklistFeeds) fetch(ctx context.Context, feed BlocklistFeed) ([]*net.IPNet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	validators := f.validators[feed.Name]
+	if validators.etag != "" {
+		req.Header.Set("If-None-Match", validators.etag)
+	}
+	if validators.lastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.lastModified)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		f.stats.unchanged(feed.Name)
+		slog.Debug("Blocklist: feed unchanged", "feed", feed.Name)
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	body := io.LimitReader(resp.Body, blocklistMaxSize+1)
+	counted := &countingReader{r: body}
+
+	networks, skipped, err := ParseBlocklist(counted)
+	if err != nil {
+		return nil, err
+	}
+	if counted.n > blocklistMaxSize {
+		return nil, fmt.Errorf("feed is larger than %d bytes", blocklistMaxSize)
+	}
+
+	f.validators[feed.Name] = blocklistValidators{
+		etag:         resp.Header.Get("Etag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	f.stats.updated(feed.Name, len(networks), skipped)
+	slog.Info("Blocklist: feed updated", "feed", feed.Name, "entries", len(networks), "skipped", skipped)
+	f.events.Publish(EventBlocklistUpdated, map[string]any{"feed": feed.Name, "entries": len(networks), "skipped": skipped})
+
+	return networks, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// BlocklistStats records how fresh each feed is, and how many requests it has
+// matched, so that a feed that has stopped updating or never matches anything
+// can be noticed.
+//
+// A nil *BlocklistStats is valid, and records nothing.
+type BlocklistStats struct {
+	sync.Mutex
+	feeds map[string]*blocklistFeedStats
+}
+
+type blocklistFeedStats struct {
+	entries     int
+	skipped     int
+	lastChecked time.Time
+	lastUpdated time.Time
+	lastError   string
+	matches     atomic.Uint64
+}
+
+// BlocklistFeedStatus is a snapshot of a feed's stats. LastChecked is when we
+// last heard from the feed successfully, whether or not it had changed, and
+// LastUpdated when its list last changed.
+type BlocklistFeedStatus struct {
+	Name        string    `json:"name"`
+	Entries     int       `json:"entries"`
+	Skipped     int       `json:"skipped"`
+	LastChecked time.Time `json:"last_checked"`
+	LastUpdated time.Time `json:"last_updated"`
+	LastError   string    `json:"last_error,omitempty"`
+	Matches     uint64    `json:"matches"`
+}
+
+func NewBlocklistStats() *BlocklistStats {
+	return &BlocklistStats{feeds: map[string]*blocklistFeedStats{}}
+}
+
+// Matched counts a request refused because of the named feed.
+func (s *BlocklistStats) Matched(name string) {
+	if s == nil {
+		return
+	}
+	s.feed(name).matches.Add(1)
+}
+
+// Snapshot returns the stats of each feed, ordered by name.
+func (s *BlocklistStats) Snapshot() []BlocklistFeedStatus {
+	if s == nil {
+		return []BlocklistFeedStatus{}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	statuses := []BlocklistFeedStatus{}
+	for _, name := range slices.Sorted(maps.Keys(s.feeds)) {
+		feed := s.feeds[name]
+		statuses = append(statuses, BlocklistFeedStatus{
+			Name:        name,
+			Entries:     feed.entries,
+			Skipped:     feed.skipped,
+			LastChecked: feed.lastChecked,
+			LastUpdated: feed.lastUpdated,
+			LastError:   feed.lastError,
+			Matches:     feed.matches.Load(),
+		})
+	}
+	return statuses
+}
+
+// Private
+
+func (s *BlocklistStats) feed(name string) *blocklistFeedStats {
+	s.Lock()
+	defer s.Unlock()
+
+	feed, ok := s.feeds[name]
+	if !ok {
+		feed = &blocklistFeedStats{}
+		s.feeds[name] = feed
+	}
+	return feed
+}
+
+func (s *BlocklistStats) updated(name string, entries, skipped int) {
+	if s == nil {
+		return
+	}
+
+	feed := s.feed(name)
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	feed.entries = entries
+	feed.skipped = skipped
+	feed.lastChecked = now
+	feed.lastUpdated = now
+	feed.lastError = ""
+}
+
+func (s *BlocklistStats) unchanged(name string) {
+	if s == nil {
+		return
+	}
+
+	feed := s.feed(name)
+
+	s.Lock()
+	defer s.Unlock()
+
+	feed.lastChecked = time.Now()
+	feed.lastError = ""
+}
+
+func (s *BlocklistStats) failed(name string, err error) {
+	if s == nil {
+		return
+	}
+
+	feed := s.feed(name)
+
+	s.Lock()
+	defer s.Unlock()
+
+	feed.lastError = err.Error()
+}
+
+func (s *BlocklistStats) lastUpdated(name string) time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+
+	feed := s.feed(name)
+
+	s.Lock()
+	defer s.Unlock()
+
+	return feed.lastUpdated
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlocklistFeed(t *testing.T) {
+	feed, err := ParseBlocklistFeed(" Drop = https://www.spamhaus.org/drop/drop.txt ")
+	require.NoError(t, err)
+	assert.Equal(t, "drop", feed.Name)
+	assert.Equal(t, "https://www.spamhaus.org/drop/drop.txt", feed.URL.String())
+
+	for _, value := range []string{
+		"https://www.spamhaus.org/drop/drop.txt",
+		"drop=",
+		"drop=/drop.txt",
+		"drop=file:///etc/drop.txt",
+		"spam haus=https://www.spamhaus.org/drop/drop.txt",
+		"=https://www.spamhaus.org/drop/drop.txt",
+	} {
+		_, err := ParseBlocklistFeed(value)
+		assert.ErrorIs(t, err, ErrInvalidBlocklistFeed, value)
+	}
+}
+
+func TestParseBlocklist(t *testing.T) {
+	list := strings.Join([]string{
+		"; Spamhaus DROP List 2026/10/16",
+		"1.10.16.0/20 ; SBL256894",
+		"# FireHOL",
+		"",
+		"203.0.113.7",
+		"  2001:db8::/32\tnetset",
+		"198.51.100.0/24,AS64496,example",
+		"not an address",
+	}, "\n")
+
+	networks, skipped, err := ParseBlocklist(strings.NewReader(list))
+	require.NoError(t, err)
+	assert.Equal(t, 1, skipped)
+
+	formatted := []string{}
+	for _, network := range networks {
+		formatted = append(formatted, network.String())
+	}
+	assert.Equal(t, []string{"1.10.16.0/20", "203.0.113.7/32", "2001:db8::/32", "198.51.100.0/24"}, formatted)
+}
+
+func TestBlocklistFeeds_refresh(t *testing.T) {
+	var requests, failing atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("203.0.113.0/24 ; SBL1\n"))
+	}))
+	defer server.Close()
+
+	feedURL, _ := url.Parse(server.URL + "/drop.txt")
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+	stats := NewBlocklistStats()
+	feeds := NewBlocklistFeeds([]BlocklistFeed{{Name: "drop", URL: feedURL}}, time.Hour, source, stats)
+
+	require.NoError(t, feeds.Refresh(context.Background()))
+
+	policy, etag := source.Current()
+	assert.Equal(t, []string{"CN"}, policy.BlockCountries)
+	require.Len(t, policy.Blocklists["drop"], 1)
+	assert.Equal(t, "203.0.113.0/24", policy.Blocklists["drop"][0].String())
+
+	status := stats.Snapshot()[0]
+	assert.Equal(t, 1, status.Entries)
+	assert.False(t, status.LastUpdated.IsZero())
+	lastUpdated := status.LastUpdated
+
+	require.NoError(t, feeds.Refresh(context.Background()))
+	_, unchangedETag := source.Current()
+	assert.Equal(t, etag, unchangedETag)
+	assert.Equal(t, lastUpdated, stats.Snapshot()[0].LastUpdated)
+
+	failing.Store(1)
+	assert.ErrorContains(t, feeds.Refresh(context.Background()), "unexpected response status 500")
+
+	policy, _ = source.Current()
+	assert.Len(t, policy.Blocklists["drop"], 1)
+	assert.Contains(t, stats.Snapshot()[0].LastError, "500")
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestBlocklistStats_nil_is_safe(t *testing.T) {
+	var stats *BlocklistStats
+
+	stats.Matched("drop")
+	stats.updated("drop", 1, 0)
+	assert.Empty(t, stats.Snapshot())
+}
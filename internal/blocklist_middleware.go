@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+)
+
+// NetworkSet finds which of a set of named lists of networks an address is
+// in. Lookups cost one map lookup per distinct prefix length in the set,
+// however many networks it holds.
+type NetworkSet struct {
+	prefixes  map[netip.Prefix]string
+	v4Lengths []int
+	v6Lengths []int
+}
+
+// NewNetworkSet builds a set from lists of networks, keyed by name. When a
+// network is in more than one list, the first name in alphabetical order is
+// the one reported.
+func NewNetworkSet(lists map[string][]*net.IPNet) *NetworkSet {
+	set := &NetworkSet{prefixes: map[netip.Prefix]string{}}
+	v4Lengths := map[int]bool{}
+	v6Lengths := map[int]bool{}
+
+	for _, name := range slices.Sorted(maps.Keys(lists)) {
+		for _, network := range lists[name] {
+			addr, ok := netip.AddrFromSlice(network.IP)
+			if !ok {
+				continue
+			}
+			addr = addr.Unmap()
+
+			bits, _ := network.Mask.Size()
+			if addr.Is4() && bits > 32 {
+				bits -= 96
+			}
+
+			prefix, err := addr.Prefix(bits)
+			if err != nil {
+				continue
+			}
+			if _, exists := set.prefixes[prefix]; exists {
+				continue
+			}
+
+			set.prefixes[prefix] = name
+			if addr.Is4() {
+				v4Lengths[bits] = true
+			} else {
+				v6Lengths[bits] = true
+			}
+		}
+	}
+
+	// Longest first, so that lookups find the most specific network.
+	set.v4Lengths = slices.Sorted(maps.Keys(v4Lengths))
+	set.v6Lengths = slices.Sorted(maps.Keys(v6Lengths))
+	slices.Reverse(set.v4Lengths)
+	slices.Reverse(set.v6Lengths)
+
+	return set
+}
+
+// Lookup returns the name of the list with the most specific network that
+// contains the address, if any.
+func (s *NetworkSet) Lookup(ip net.IP) (string, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", false
+	}
+	addr = addr.Unmap()
+
+	lengths := s.v6Lengths
+	if addr.Is4() {
+		lengths = s.v4Lengths
+	}
+
+	for _, bits := range lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if name, ok := s.prefixes[prefix]; ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// Contains reports whether any network in the set contains the address. A
+// nil set contains nothing.
+func (s *NetworkSet) Contains(ip net.IP) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.Lookup(ip)
+	return ok
+}
+
+// Len is the number of distinct networks in the set.
+func (s *NetworkSet) Len() int {
+	return len(s.prefixes)
+}
+
+// BlocklistMiddleware refuses requests from addresses on any of the
+// blocklists. Requests from local and internal addresses are always allowed.
+type BlocklistMiddleware struct {
+	logger      *slog.Logger
+	next        http.Handler
+	networks    *NetworkSet
+	stats       *BlocklistStats
+	blockedPage *PageTemplate
+}
+
+func NewBlocklistMiddleware(logger *slog.Logger, next http.Handler, blocklists map[string][]*net.IPNet, stats *BlocklistStats) *BlocklistMiddleware {
+	return &BlocklistMiddleware{
+		logger:   logger,
+		next:     next,
+		networks: NewNetworkSet(blocklists),
+		stats:    stats,
+	}
+}
+
+// SetBlockedPage sets the page served to blocked requests, in place of a
+// plain "Access denied".
+func (m *BlocklistMiddleware) SetBlockedPage(page *PageTemplate) {
+	m.blockedPage = page
+}
+
+func (m *BlocklistMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, ip := clientIP(r)
+	if ip == nil || isLocalOrInternalIP(ip) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	name, listed := m.networks.Lookup(ip)
+	if !listed {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	m.stats.Matched(name)
+	m.logger.InfoContext(r.Context(), "Request blocked - address on blocklist", "ip", host, "blocklist", name, "path", r.URL.Path)
+
+	m.writeBlocked(w, r, "blocklist_feed:"+name)
+}
+
+// Private
+
+func (m *BlocklistMiddleware) writeBlocked(w http.ResponseWriter, r *http.Request, rule string) {
+	tags := RequestTagsFromContext(r.Context())
+	tags.Set(TagBlocked, BlockedByBlocklist)
+	tags.Set(TagBlockedRule, rule)
+
+	if m.blockedPage != nil {
+		m.blockedPage.Render(w, r, http.StatusForbidden)
+		return
+	}
+
+	http.Error(w, "Access denied", http.StatusForbidden)
+}
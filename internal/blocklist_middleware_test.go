@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBlocklists(t testing.TB, lists map[string][]string) map[string][]*net.IPNet {
+	blocklists := map[string][]*net.IPNet{}
+	for name, values := range lists {
+		networks, err := ParseCIDRs(values)
+		require.NoError(t, err)
+		blocklists[name] = networks
+	}
+	return blocklists
+}
+
+func TestNetworkSet_lookup(t *testing.T) {
+	set := NewNetworkSet(testBlocklists(t, map[string][]string{
+		"drop":  {"203.0.113.0/24", "2001:db8::/32"},
+		"intel": {"203.0.113.7", "198.51.100.0/22"},
+	}))
+
+	assert.Equal(t, 4, set.Len())
+
+	for ip, expected := range map[string]string{
+		"203.0.113.1":         "drop",
+		"203.0.113.7":         "intel",
+		"198.51.103.255":      "intel",
+		"::ffff:198.51.100.1": "intel",
+		"2001:db8:1::1":       "drop",
+	} {
+		name, ok := set.Lookup(net.ParseIP(ip))
+		assert.True(t, ok, ip)
+		assert.Equal(t, expected, name, ip)
+	}
+
+	for _, ip := range []string{"198.51.104.1", "192.0.2.1", "2001:db9::1"} {
+		_, ok := set.Lookup(net.ParseIP(ip))
+		assert.False(t, ok, ip)
+	}
+}
+
+func TestNetworkSet_network_in_several_lists(t *testing.T) {
+	set := NewNetworkSet(testBlocklists(t, map[string][]string{
+		"firehol": {"203.0.113.0/24"},
+		"drop":    {"203.0.113.0/24"},
+	}))
+
+	name, ok := set.Lookup(net.ParseIP("203.0.113.1"))
+	assert.True(t, ok)
+	assert.Equal(t, "drop", name)
+	assert.Equal(t, 1, set.Len())
+}
+
+func TestNetworkSet_Contains(t *testing.T) {
+	set := NewNetworkSet(testBlocklists(t, map[string][]string{"drop": {"203.0.113.0/24"}}))
+
+	assert.True(t, set.Contains(net.ParseIP("203.0.113.1")))
+	assert.False(t, set.Contains(net.ParseIP("192.0.2.1")))
+
+	var empty *NetworkSet
+	assert.False(t, empty.Contains(net.ParseIP("203.0.113.1")))
+}
+
+func TestNetworkSet_lookups_do_not_allocate(t *testing.T) {
+	set := NewNetworkSet(map[string][]*net.IPNet{"drop": testManyNetworks(1000)})
+	found, missing := net.ParseIP("11.0.3.7"), net.ParseIP("192.0.2.1")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		set.Lookup(found)
+		set.Lookup(missing)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestBlocklistMiddleware(t *testing.T) {
+	stats := NewBlocklistStats()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	middleware := NewBlocklistMiddleware(slog.Default(), next, testBlocklists(t, map[string][]string{"drop": {"81.2.69.0/24"}}), stats)
+
+	serve := func(remoteAddr string) (*httptest.ResponseRecorder, *RequestTags) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		r, tags := WithRequestTags(r)
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		return w, tags
+	}
+
+	w, tags := serve("81.2.69.142:1234")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, BlockedByBlocklist, tags.Get(TagBlocked))
+
+	w, _ = serve("192.0.2.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = serve("10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, uint64(1), stats.Snapshot()[0].Matches)
+}
+
+func TestBlocklistMiddleware_behind_trusted_proxies(t *testing.T) {
+	trusted, err := ParseCIDRs(DefaultTrustedProxies)
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	middleware := NewBlocklistMiddleware(slog.Default(), next, testBlocklists(t, map[string][]string{"drop": {"81.2.69.0/24"}}), nil)
+	handler := NewClientAddressMiddleware(NewTrustedProxies(trusted), middleware)
+
+	serve := func(remoteAddr, forwardedFor string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// A listed client can't claim to be someone else
+	assert.Equal(t, http.StatusForbidden, serve("81.2.69.142:1234", "192.0.2.1"))
+	assert.Equal(t, http.StatusForbidden, serve("81.2.69.142:1234", "127.0.0.1"))
+	assert.Equal(t, http.StatusForbidden, serve("10.0.0.2:1234", "192.0.2.1, 81.2.69.142"))
+
+	// But is found when a trusted proxy passes the request on
+	assert.Equal(t, http.StatusForbidden, serve("10.0.0.2:1234", "81.2.69.142"))
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:1234", "192.0.2.1"))
+}
+
+func BenchmarkNetworkSet_Lookup(b *testing.B) {
+	set := NewNetworkSet(map[string][]*net.IPNet{"drop": testManyNetworks(50000)})
+	ip := net.ParseIP("192.0.2.1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		set.Lookup(ip)
+	}
+}
+
+// testManyNetworks makes a list of distinct networks the size of a large
+// feed: mostly /24s, with a single address and a /16 among every hundred.
+func testManyNetworks(count int) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, count)
+	for i := range count {
+		ip := net.IPv4(byte(11+i>>16), byte(i>>8), byte(i), 1).To4()
+		bits := 24
+		switch i % 100 {
+		case 0:
+			bits = 32
+		case 1:
+			bits = 16
+		}
+		networks = append(networks, &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, 32)), Mask: net.CIDRMask(bits, 32)})
+	}
+	return networks
+}
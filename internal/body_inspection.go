@@ -0,0 +1,318 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+const defaultBodyInspectionMaxSize = 16 * KB
+
+var ErrInvalidBodyRule = errors.New("body rule must be in the form path[@COUNTRY|COUNTRY...]:field=pattern[|pattern...]")
+
+// BodyRule blocks requests to matching paths whose body has a field matching
+// one of the rule's patterns, such as sign ups with an email address at a
+// disposable domain.
+//
+// Paths are matched as for cache rules. When the rule has countries, it only
+// applies to requests from them. Fields of JSON bodies are named by their path
+// through the document, like `user.email`, and those of forms by their name,
+// like `user[email]`. Patterns are matched against the field's value ignoring
+// case, and may use `*` as a wildcard.
+type BodyRule struct {
+	Path      string
+	Countries []string
+	Field     string
+	Patterns  []string
+}
+
+// ParseBodyRule parses a rule written as
+// `path[@COUNTRY|COUNTRY...]:field=pattern[|pattern...]`, for example
+// `/signup@RU|CN:user.email=*@mailinator.com|*@tempmail.com`.
+func ParseBodyRule(value string) (BodyRule, error) {
+	target, patterns, ok := strings.Cut(strings.TrimSpace(value), "=")
+	if !ok {
+		return BodyRule{}, ErrInvalidBodyRule
+	}
+
+	route, field, ok := strings.Cut(target, ":")
+	field = strings.TrimSpace(field)
+	if !ok || field == "" {
+		return BodyRule{}, ErrInvalidBodyRule
+	}
+
+	rulePath, countries, hasCountries := strings.Cut(strings.TrimSpace(route), "@")
+	if !strings.HasPrefix(rulePath, "/") {
+		return BodyRule{}, ErrInvalidBodyRule
+	}
+	if _, err := path.Match(rulePath, ""); err != nil {
+		return BodyRule{}, ErrInvalidBodyRule
+	}
+
+	rule := BodyRule{Path: rulePath, Field: field}
+
+	if hasCountries {
+		for _, country := range strings.Split(countries, "|") {
+			country = strings.ToUpper(strings.TrimSpace(country))
+			if country == "" {
+				return BodyRule{}, ErrInvalidBodyRule
+			}
+			rule.Countries = append(rule.Countries, country)
+		}
+	}
+
+	for _, pattern := range strings.Split(patterns, "|") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+			return BodyRule{}, ErrInvalidBodyRule
+		}
+		rule.Patterns = append(rule.Patterns, pattern)
+	}
+
+	return rule, nil
+}
+
+// String formats the rule in the form that ParseBodyRule reads.
+func (r BodyRule) String() string {
+	route := r.Path
+	if len(r.Countries) > 0 {
+		route += "@" + strings.Join(r.Countries, "|")
+	}
+
+	return route + ":" + r.Field + "=" + strings.Join(r.Patterns, "|")
+}
+
+type BodyRules []BodyRule
+
+// UsesCountries reports whether any of the rules depend on the country.
+func (rules BodyRules) UsesCountries() bool {
+	return slices.ContainsFunc(rules, func(rule BodyRule) bool {
+		return len(rule.Countries) > 0
+	})
+}
+
+// BodyInspectionMiddleware applies body rules to requests. Only requests that
+// a rule applies to are inspected; everything else is streamed on untouched,
+// so other routes see no extra latency.
+//
+// The body of an inspected request is read into memory before it's passed on,
+// so it's capped at maxSize. Larger bodies are refused rather than let through
+// uninspected, since otherwise padding a body would be enough to get around
+// the rules. For the same reason, bodies we can't read the fields of, because
+// they're compressed or aren't JSON or a URL-encoded form, are refused too.
+//
+// The country is read from the request tags, so this has to run after the
+// GeoIP stage.
+type BodyInspectionMiddleware struct {
+	logger      *slog.Logger
+	next        http.Handler
+	rules       BodyRules
+	maxSize     int
+	blockedPage *PageTemplate
+}
+
+func NewBodyInspectionMiddleware(logger *slog.Logger, next http.Handler, rules BodyRules, maxSize int) *BodyInspectionMiddleware {
+	return &BodyInspectionMiddleware{
+		logger:  logger,
+		next:    next,
+		rules:   rules,
+		maxSize: maxSize,
+	}
+}
+
+// SetBlockedPage sets the page served to blocked requests, in place of a
+// plain "Access denied".
+func (m *BodyInspectionMiddleware) SetBlockedPage(page *PageTemplate) {
+	m.blockedPage = page
+}
+
+func (m *BodyInspectionMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rules := m.rulesFor(r)
+	if len(rules) == 0 || r.Body == nil || r.Body == http.NoBody {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	parse := bodyFieldParser(r.Header.Get("Content-Type"))
+	if parse == nil || !unencodedBody(r.Header) {
+		m.writeUnsupported(w, r)
+		return
+	}
+
+	if r.ContentLength > int64(m.maxSize) {
+		m.writeTooLarge(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(m.maxSize)+1))
+	if err != nil {
+		m.logger.DebugContext(r.Context(), "Unable to read request body for inspection", "path", r.URL.Path, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if len(body) > m.maxSize {
+		m.writeTooLarge(w, r)
+		return
+	}
+
+	// Keep the original body to be closed as usual
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{bytes.NewReader(body), r.Body}
+
+	for _, rule := range rules {
+		if rule.matchesValues(parse(body, rule.Field)) {
+			host, _ := clientIP(r)
+			m.logger.InfoContext(r.Context(), "Request blocked - body rule matched", "rule", rule.String(), "path", r.URL.Path, "ip", host, "country", CountryFromContext(r.Context()))
+			m.writeBlocked(w, r, "body_rule:"+rule.String())
+			return
+		}
+	}
+
+	m.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (m *BodyInspectionMiddleware) rulesFor(r *http.Request) BodyRules {
+	var rules BodyRules
+	for _, rule := range m.rules {
+		if rule.appliesTo(r) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (m *BodyInspectionMiddleware) writeTooLarge(w http.ResponseWriter, r *http.Request) {
+	m.logger.InfoContext(r.Context(), "Request refused - body too large to inspect", "path", r.URL.Path, "content_length", r.ContentLength, "max_size", m.maxSize)
+	http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+}
+
+func (m *BodyInspectionMiddleware) writeUnsupported(w http.ResponseWriter, r *http.Request) {
+	m.logger.InfoContext(r.Context(), "Request refused - body can't be inspected", "path", r.URL.Path, "content_type", r.Header.Get("Content-Type"), "content_encoding", r.Header.Values("Content-Encoding"))
+	http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+}
+
+func (m *BodyInspectionMiddleware) writeBlocked(w http.ResponseWriter, r *http.Request, rule string) {
+	tags := RequestTagsFromContext(r.Context())
+	tags.Set(TagBlocked, BlockedByBodyRule)
+	tags.Set(TagBlockedRule, rule)
+
+	if m.blockedPage != nil {
+		m.blockedPage.Render(w, r, http.StatusForbidden)
+		return
+	}
+
+	http.Error(w, "Access denied", http.StatusForbidden)
+}
+
+func (r BodyRule) appliesTo(req *http.Request) bool {
+	if !matchPathPattern(r.Path, req.URL.Path) {
+		return false
+	}
+
+	if len(r.Countries) == 0 {
+		return true
+	}
+
+	country := CountryFromContext(req.Context())
+	return country != "" && slices.Contains(r.Countries, strings.ToUpper(country))
+}
+
+func (r BodyRule) matchesValues(values []string) bool {
+	for _, value := range values {
+		value = strings.ToLower(value)
+		for _, pattern := range r.Patterns {
+			if matched, _ := path.Match(pattern, value); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unencodedBody reports whether the body is sent as is, rather than
+// compressed or otherwise encoded.
+func unencodedBody(header http.Header) bool {
+	for _, value := range header.Values("Content-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.TrimSpace(encoding)
+			if encoding != "" && !strings.EqualFold(encoding, "identity") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bodyFieldParser returns a function that finds the values of a field in a
+// body of the given content type, or nil for content types we don't inspect.
+func bodyFieldParser(contentType string) func(body []byte, field string) []string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return jsonFieldValues
+	case mediaType == "application/x-www-form-urlencoded":
+		return formFieldValues
+	default:
+		return nil
+	}
+}
+
+func formFieldValues(body []byte, field string) []string {
+	values, _ := url.ParseQuery(string(body))
+	return values[field]
+}
+
+// jsonFieldValues follows a dotted path through a JSON document. Arrays along
+// the way are searched element by element, so `users.email` finds the email of
+// every user in a list.
+func jsonFieldValues(body []byte, field string) []string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil
+	}
+
+	return jsonValues(document, strings.Split(field, "."))
+}
+
+func jsonValues(value any, keys []string) []string {
+	if items, ok := value.([]any); ok {
+		values := []string{}
+		for _, item := range items {
+			values = append(values, jsonValues(item, keys)...)
+		}
+		return values
+	}
+
+	if len(keys) == 0 {
+		switch value := value.(type) {
+		case string:
+			return []string{value}
+		case json.Number:
+			return []string{value.String()}
+		default:
+			return nil
+		}
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return jsonValues(object[keys[0]], keys[1:])
+}
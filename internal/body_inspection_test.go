@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBodyRule(t *testing.T) {
+	rule, err := ParseBodyRule("/signup@ru|Cn:user.email=*@Mailinator.com|*@tempmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, BodyRule{Path: "/signup", Countries: []string{"RU", "CN"}, Field: "user.email", Patterns: []string{"*@mailinator.com", "*@tempmail.com"}}, rule)
+	assert.Equal(t, "/signup@RU|CN:user.email=*@mailinator.com|*@tempmail.com", rule.String())
+
+	rule, err = ParseBodyRule("/api/**:user[email]=*@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, BodyRule{Path: "/api/**", Field: "user[email]", Patterns: []string{"*@example.com"}}, rule)
+	assert.Equal(t, "/api/**:user[email]=*@example.com", rule.String())
+
+	for _, value := range []string{"", "/signup:email", "signup:email=x", "/signup=x", "/signup:=x", "/signup:email=", "/signup@:email=x", "/signup:email=a||b", "/signup:email=[", "/[:email=x"} {
+		_, err := ParseBodyRule(value)
+		assert.ErrorIs(t, err, ErrInvalidBodyRule, value)
+	}
+}
+
+func TestBodyRules_UsesCountries(t *testing.T) {
+	assert.False(t, BodyRules{{Path: "/signup", Field: "email", Patterns: []string{"*"}}}.UsesCountries())
+	assert.True(t, BodyRules{{Path: "/signup", Countries: []string{"RU"}, Field: "email", Patterns: []string{"*"}}}.UsesCountries())
+}
+
+func TestBodyInspectionMiddleware(t *testing.T) {
+	rules := BodyRules{
+		{Path: "/signup", Countries: []string{"RU", "CN"}, Field: "user.email", Patterns: []string{"*@mailinator.com"}},
+		{Path: "/signup", Field: "user[email]", Patterns: []string{"*@mailinator.com"}},
+		{Path: "/invite", Field: "guests.email", Patterns: []string{"*@tempmail.com"}},
+	}
+
+	var received string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	})
+	handler := NewBodyInspectionMiddleware(slog.Default(), next, rules, 1024)
+
+	post := func(path, country, contentType, body string) *httptest.ResponseRecorder {
+		received = ""
+		r, tags := WithRequestTags(httptest.NewRequest("POST", path, strings.NewReader(body)))
+		if country != "" {
+			tags.Set(TagCountry, country)
+		}
+		r.Header.Set("Content-Type", contentType)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	disposable := `{"user": {"email": "Someone@Mailinator.com"}}`
+
+	w := post("/signup", "RU", "application/json", disposable)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, received)
+
+	w = post("/signup", "GB", "application/json", disposable)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, disposable, received, "the body is passed on intact")
+
+	w = post("/signup", "", "application/json", disposable)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = post("/signup", "RU", "application/json; charset=utf-8", `{"user": {"email": "someone@example.com"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = post("/signup", "RU", "application/json", `{"user": "not json`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = post("/signup", "GB", "application/x-www-form-urlencoded", "user%5Bemail%5D=someone%40mailinator.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = post("/invite", "GB", "application/json", `{"guests": [{"email": "a@example.com"}, {"email": "b@tempmail.com"}]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = post("/login", "RU", "application/json", disposable)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestBodyInspectionMiddleware_refuses_bodies_over_the_size_cap(t *testing.T) {
+	rules := BodyRules{{Path: "/signup", Field: "email", Patterns: []string{"*@mailinator.com"}}}
+
+	var received string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	})
+	handler := NewBodyInspectionMiddleware(slog.Default(), next, rules, 64)
+
+	body := `{"email": "someone@example.com", "padding": "` + strings.Repeat("x", 64) + `"}`
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/signup", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Without a Content-Length, the body is only read up to the cap
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/signup", io.MultiReader(strings.NewReader(body)))
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Routes without rules aren't limited
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/upload", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, received)
+}
+
+func TestBodyInspectionMiddleware_refuses_bodies_it_cannot_inspect(t *testing.T) {
+	rules := BodyRules{{Path: "/signup", Countries: []string{"RU"}, Field: "email", Patterns: []string{"*@mailinator.com"}}}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewBodyInspectionMiddleware(slog.Default(), next, rules, 1024)
+
+	post := func(path, country, contentType, contentEncoding, body string) *httptest.ResponseRecorder {
+		r, tags := WithRequestTags(httptest.NewRequest("POST", path, strings.NewReader(body)))
+		tags.Set(TagCountry, country)
+		r.Header.Set("Content-Type", contentType)
+		if contentEncoding != "" {
+			r.Header.Set("Content-Encoding", contentEncoding)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(`{"email": "someone@mailinator.com"}`))
+	writer.Close()
+
+	w := post("/signup", "RU", "application/json", "gzip", compressed.String())
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = post("/signup", "RU", "multipart/form-data; boundary=x", "", "--x\r\nContent-Disposition: form-data; name=\"email\"\r\n\r\nsomeone@mailinator.com\r\n--x--\r\n")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = post("/signup", "RU", "text/plain", "", "email=someone%40mailinator.com")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = post("/signup", "RU", "application/json", "identity", `{"email": "someone@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Requests that no rule applies to are passed on, whatever their body
+	w = post("/signup", "GB", "application/json", "gzip", compressed.String())
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = post("/upload", "RU", "multipart/form-data; boundary=x", "", "--x--\r\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandler_body_rules_from_policy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.bodyInspectionMaxSize = 1024
+	policy := Policy{BodyRules: BodyRules{{Path: "/signup", Field: "email", Patterns: []string{"*@mailinator.com"}}}}
+	h := NewHandler(options.withPolicy(policy))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/signup", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, post(`{"email": "someone@mailinator.com"}`).Code)
+
+	w := post(`{"email": "someone@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"email": "someone@example.com"}`, w.Body.String())
+}
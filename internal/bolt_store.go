@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltStoreHeaderSize  = 8
+	boltStoreOpenTimeout = 5 * time.Second
+)
+
+// BoltStore keeps state in a bbolt database file, so that it survives a
+// restart. Each value is stored after its expiry time, and expired values are
+// removed in the background.
+type BoltStore struct {
+	db             *bolt.DB
+	getCurrentTime GetCurrentTime
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	// The timeout stops us waiting forever for the lock on a database that
+	// another instance has open
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltStoreOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &BoltStore{
+		db:             db,
+		getCurrentTime: time.Now,
+		cancel:         cancel,
+	}
+
+	s.prune()
+
+	s.wg.Add(1)
+	go s.pruneEvery(ctx, storePruneInterval)
+
+	return s, nil
+}
+
+func (s *BoltStore) Get(bucket, key string) ([]byte, bool) {
+	var value []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		stored, ok := s.decode(b.Get([]byte(key)))
+		if ok {
+			value = append([]byte{}, stored...)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Store: unable to read item", "bucket", bucket, "key", key, "error", err)
+		return nil, false
+	}
+
+	return value, value != nil
+}
+
+func (s *BoltStore) List(bucket string) (map[string][]byte, error) {
+	items := map[string][]byte{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(key, stored []byte) error {
+			value, ok := s.decode(stored)
+			if ok {
+				items[string(key)] = append([]byte{}, value...)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (s *BoltStore) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), encodeBoltStoreValue(storeExpiry(s.getCurrentTime(), ttl), value))
+	})
+}
+
+func (s *BoltStore) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) Increment(bucket, key string, delta int64, ttl time.Duration) (int64, error) {
+	var count int64
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		expiresAt := storeExpiry(s.getCurrentTime(), ttl)
+		stored := b.Get([]byte(key))
+
+		value, ok := s.decode(stored)
+		if ok {
+			count, err = decodeStoreCounter(value)
+			if err != nil {
+				return err
+			}
+			expiresAt = boltStoreExpiry(stored)
+		}
+
+		count += delta
+		return b.Put([]byte(key), encodeBoltStoreValue(expiresAt, encodeStoreCounter(count)))
+	})
+
+	return count, err
+}
+
+func (s *BoltStore) Close() error {
+	s.cancel()
+	s.wg.Wait()
+
+	return s.db.Close()
+}
+
+// Private
+
+func (s *BoltStore) pruneEvery(ctx context.Context, interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.prune()
+		}
+	}
+}
+
+func (s *BoltStore) prune() {
+	now := s.getCurrentTime()
+	removed := 0
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(_ []byte, b *bolt.Bucket) error {
+			expired := [][]byte{}
+			err := b.ForEach(func(key, stored []byte) error {
+				if boltStoreExpired(stored, now) {
+					expired = append(expired, append([]byte{}, key...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, key := range expired {
+				err = b.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			removed += len(expired)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Store: unable to remove expired items", "error", err)
+		return
+	}
+
+	if removed > 0 {
+		slog.Debug("Store: removed expired items", "count", removed)
+	}
+}
+
+// decode returns the value from a stored item, unless it has expired.
+func (s *BoltStore) decode(stored []byte) ([]byte, bool) {
+	if stored == nil || boltStoreExpired(stored, s.getCurrentTime()) {
+		return nil, false
+	}
+	return stored[boltStoreHeaderSize:], true
+}
+
+func encodeBoltStoreValue(expiresAt time.Time, value []byte) []byte {
+	var expiry uint64
+	if !expiresAt.IsZero() {
+		expiry = uint64(expiresAt.UnixNano())
+	}
+
+	stored := binary.BigEndian.AppendUint64(make([]byte, 0, boltStoreHeaderSize+len(value)), expiry)
+	return append(stored, value...)
+}
+
+func boltStoreExpiry(stored []byte) time.Time {
+	expiry := binary.BigEndian.Uint64(stored)
+	if expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(expiry))
+}
+
+func boltStoreExpired(stored []byte, now time.Time) bool {
+	if len(stored) < boltStoreHeaderSize {
+		return true
+	}
+
+	expiresAt := boltStoreExpiry(stored)
+	return !expiresAt.IsZero() && !expiresAt.After(now)
+}
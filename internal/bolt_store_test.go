@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, func(t *testing.T) (Store, func(time.Duration)) {
+		store, err := NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+		require.NoError(t, err)
+
+		now := time.Now()
+		store.getCurrentTime = func() time.Time { return now }
+
+		return store, func(d time.Duration) { now = now.Add(d) }
+	})
+}
+
+func TestBoltStore_items_survive_reopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.db")
+
+	store, err := NewBoltStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Set(StoreBucketBans, "key", []byte("banned"), time.Hour))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewBoltStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	value, ok := reopened.Get(StoreBucketBans, "key")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("banned"), value)
+}
+
+func TestBoltStore_prunes_expired_items(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.Set(StoreBucketBans, "a", []byte("1"), time.Millisecond))
+	require.NoError(t, store.Set(StoreBucketBans, "b", []byte("1"), 0))
+
+	time.Sleep(5 * time.Millisecond)
+	store.prune()
+
+	remaining := 0
+	store.db.View(func(tx *bolt.Tx) error {
+		remaining = tx.Bucket([]byte(StoreBucketBans)).Stats().KeyN
+		return nil
+	})
+	assert.Equal(t, 1, remaining)
+}
+
+func TestBoltStore_rejects_incrementing_non_counters(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.Set(StoreBucketCounters, "key", []byte("hello"), 0))
+
+	_, err = store.Increment(StoreBucketCounters, "key", 1, 0)
+	assert.ErrorIs(t, err, ErrStoreValueNotCounter)
+}
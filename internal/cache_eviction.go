@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+type CacheEviction string
+
+const (
+	CacheEvictionLRU CacheEviction = "lru"
+	CacheEvictionLFU CacheEviction = "lfu"
+)
+
+// The number of items sampled to choose one to evict. On average we'll evict
+// items in the worst 20%, which is good enough and is much faster than
+// scanning through them all.
+const cacheEvictionSampleSize = 5
+
+var ErrInvalidCacheEviction = errors.New("cache eviction must be one of lru or lfu")
+
+func ParseCacheEviction(value string) (CacheEviction, error) {
+	eviction := CacheEviction(strings.ToLower(strings.TrimSpace(value)))
+
+	switch eviction {
+	case CacheEvictionLRU, CacheEvictionLFU:
+		return eviction, nil
+	case "":
+		return CacheEvictionLRU, nil
+	default:
+		return "", ErrInvalidCacheEviction
+	}
+}
+
+// Private
+
+// prefers reports whether an item last used at aUsedAt, and used aUses times,
+// is a better choice to evict than one last used at bUsedAt and used bUses
+// times. Least frequently used falls back to least recently used for items
+// that have been used equally often.
+func (e CacheEviction) prefers(aUsedAt time.Time, aUses int, bUsedAt time.Time, bUses int) bool {
+	if e == CacheEvictionLFU && aUses != bUses {
+		return aUses < bUses
+	}
+	return aUsedAt.Before(bUsedAt)
+}
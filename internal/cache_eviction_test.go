@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCacheEviction(t *testing.T) {
+	eviction, err := ParseCacheEviction("LFU")
+	require.NoError(t, err)
+	assert.Equal(t, CacheEvictionLFU, eviction)
+
+	eviction, err = ParseCacheEviction("")
+	require.NoError(t, err)
+	assert.Equal(t, CacheEvictionLRU, eviction)
+
+	_, err = ParseCacheEviction("fifo")
+	assert.ErrorIs(t, err, ErrInvalidCacheEviction)
+}
+
+func TestCacheEviction_prefers(t *testing.T) {
+	earlier := time.Now()
+	later := earlier.Add(time.Minute)
+
+	assert.True(t, CacheEvictionLRU.prefers(earlier, 10, later, 1))
+	assert.False(t, CacheEvictionLRU.prefers(later, 1, earlier, 10))
+
+	assert.True(t, CacheEvictionLFU.prefers(later, 1, earlier, 10))
+	assert.False(t, CacheEvictionLFU.prefers(earlier, 10, later, 1))
+	assert.True(t, CacheEvictionLFU.prefers(earlier, 1, later, 1))
+}
@@ -1,34 +1,94 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"maps"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
 type CacheKey uint64
 
+type CacheBackend string
+
+const (
+	CacheBackendMemory CacheBackend = "memory"
+	CacheBackendDisk   CacheBackend = "disk"
+	CacheBackendRedis  CacheBackend = "redis"
+)
+
+var ErrInvalidCacheBackend = errors.New("cache backend must be one of memory, disk, or redis")
+
+func ParseCacheBackend(value string) (CacheBackend, error) {
+	backend := CacheBackend(strings.ToLower(strings.TrimSpace(value)))
+
+	switch backend {
+	case CacheBackendMemory, CacheBackendDisk, CacheBackendRedis:
+		return backend, nil
+	case "":
+		return CacheBackendMemory, nil
+	default:
+		return "", ErrInvalidCacheBackend
+	}
+}
+
+// Cache stores encoded responses. Implementations are safe for concurrent
+// use, and Close releases whatever they hold once we're done with them.
 type Cache interface {
 	Get(key CacheKey) ([]byte, bool)
 	Set(key CacheKey, value []byte, expiresAt time.Time)
+	Close() error
 }
 
 type CacheHandler struct {
-	cache       Cache
-	next        http.Handler
-	maxBodySize int
+	cache          Cache
+	next           http.Handler
+	maxBodySize    int
+	purger         *CachePurger
+	varyHeaders    []string
+	stats          *CacheStats
+	policy         *CachePolicy
+	revalidating   sync.Map
+	getCurrentTime GetCurrentTime
 }
 
 func NewCacheHandler(cache Cache, maxBodySize int, next http.Handler) *CacheHandler {
 	return &CacheHandler{
-		cache:       cache,
-		next:        next,
-		maxBodySize: maxBodySize,
+		cache:          cache,
+		next:           next,
+		maxBodySize:    maxBodySize,
+		getCurrentTime: time.Now,
 	}
 }
 
+// SetPurger makes the handler disregard cached responses that have since
+// been purged.
+func (h *CacheHandler) SetPurger(purger *CachePurger) {
+	h.purger = purger
+}
+
+// SetVaryHeaders sets request headers that cached responses always vary by,
+// in addition to those named by their Vary header.
+func (h *CacheHandler) SetVaryHeaders(names []string) {
+	h.varyHeaders = names
+}
+
+// SetStats makes the handler count how it serves requests.
+func (h *CacheHandler) SetStats(stats *CacheStats) {
+	h.stats = stats
+}
+
+// SetPolicy applies configured cache rules to responses.
+func (h *CacheHandler) SetPolicy(policy *CachePolicy) {
+	h.policy = policy
+}
+
 func (h *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	variant := NewVariant(r)
+	variant := h.newVariant(r)
 	response, key, found := h.fetchFromCache(r, variant)
 
 	if found {
@@ -38,37 +98,161 @@ func (h *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if found {
+	if found && h.purger.Purged(r, response) {
+		slog.Debug("Disregarding purged response", "path", r.URL.Path, "key", key)
+		found = false
+	}
+
+	tags := RequestTagsFromContext(r.Context())
+	now := h.getCurrentTime()
+
+	if found && response.Fresh(now) {
+		h.served(tags, "hit")
 		response.WriteCachedResponse(w, r)
 		return
 	}
 
+	if found && response.StaleFor(now) <= response.StaleWhileRevalidate {
+		slog.Debug("Serving stale response while revalidating", "path", r.URL.Path, "key", key)
+		h.served(tags, "stale")
+		response.WriteStaleResponse(w, r)
+		h.revalidate(r, key, response)
+		return
+	}
+
 	if !h.shouldCacheRequest(r) {
 		slog.Debug("Bypassing cache for request", "path", r.URL.Path, "method", r.Method)
 		w.Header().Set("X-Cache", "bypass")
+		h.served(tags, "bypass")
 		h.next.ServeHTTP(w, r)
 		return
 	}
 
+	if found {
+		h.served(tags, h.refresh(w, r, variant, key, response, now))
+		return
+	}
+
+	h.served(tags, "miss")
+	h.fetchAndStore(w, r, variant, key)
+}
+
+// Private
+
+func (h *CacheHandler) served(tags *RequestTags, status string) {
+	tags.Set(TagCacheStatus, status)
+	h.stats.Served(status)
+}
+
+func (h *CacheHandler) fetchAndStore(w http.ResponseWriter, r *http.Request, variant *Variant, key CacheKey) *CacheableResponse {
 	cr := NewCacheableResponse(w, h.maxBodySize)
+	cr.SetPolicy(h.policy, r.URL.Path)
 	h.next.ServeHTTP(cr, r)
 
 	cacheable, expires := cr.CacheStatus()
 	if cacheable {
-		variant.SetResponseHeader(cr.HttpHeader)
-		cr.VariantHeader = variant.VariantHeader()
+		h.store(r, variant, key, cr, expires)
+	}
 
-		encoded, err := cr.ToBuffer()
-		if err != nil {
-			slog.Error("Failed to encode response for caching", "path", r.URL.Path, "error", err)
-		} else {
-			h.cache.Set(key, encoded, expires)
-			slog.Debug("Added response to cache", "path", r.URL.Path, "key", key, "expires", expires, "size", len(encoded))
-		}
+	return cr
+}
+
+// refresh replaces an expired entry. When the entry has validators, the
+// upstream is asked to confirm it's unchanged rather than send it again, and
+// if it does the entry is served and kept. Within the entry's stale-if-error
+// window, it's also served in place of a server error. Returns the cache
+// status of the response.
+func (h *CacheHandler) refresh(w http.ResponseWriter, r *http.Request, variant *Variant, key CacheKey, cached CacheableResponse, now time.Time) string {
+	req, conditional := cached.ConditionalRequest(r)
+	if !conditional {
+		// Leave the client's own conditions for the upstream to answer
+		req = r
+	}
+	staleIfError := cached.StaleFor(now) <= cached.StaleIfError
+
+	iw := newInterceptingWriter(w, func(statusCode int) bool {
+		return (conditional && statusCode == http.StatusNotModified) || (staleIfError && statusCode >= http.StatusInternalServerError)
+	})
+	fetched := h.fetchAndStore(iw, req, variant, key)
+
+	switch {
+	case !iw.intercepted:
+		return "miss"
+	case iw.statusCode == http.StatusNotModified:
+		slog.Debug("Revalidated cached response", "path", r.URL.Path, "key", key)
+		h.storeRevalidated(r, variant, key, cached, fetched.HttpHeader)
+		cached.WriteRevalidatedResponse(w, r)
+		return "revalidated"
+	default:
+		slog.Info("Serving stale response after origin error", "path", r.URL.Path, "key", key, "status", iw.statusCode)
+		cached.WriteStaleResponse(w, r)
+		return "stale"
 	}
 }
 
-// Private
+// storeRevalidated keeps an entry that the upstream has confirmed is
+// unchanged, with its headers updated from the confirmation.
+func (h *CacheHandler) storeRevalidated(r *http.Request, variant *Variant, key CacheKey, cached CacheableResponse, header http.Header) {
+	cached.SetPolicy(h.policy, r.URL.Path)
+
+	cacheable, expires := cached.Revalidate(header)
+	if cacheable {
+		h.store(r, variant, key, &cached, expires)
+	}
+}
+
+func (h *CacheHandler) store(r *http.Request, variant *Variant, key CacheKey, cr *CacheableResponse, expires time.Time) {
+	variant.SetResponseHeader(cr.HttpHeader)
+	cr.VariantHeader = variant.VariantHeader()
+	cr.StoredAt = time.Now()
+	cr.ExpiresAt = expires
+	cr.StaleWhileRevalidate, cr.StaleIfError = cr.StaleWindows()
+
+	// Keep the entry around for as long as it may be served stale
+	retainUntil := expires.Add(max(cr.StaleWhileRevalidate, cr.StaleIfError))
+
+	encoded, err := cr.ToBuffer()
+	if err != nil {
+		slog.Error("Failed to encode response for caching", "path", r.URL.Path, "error", err)
+		return
+	}
+
+	h.cache.Set(key, encoded, retainUntil)
+	h.purger.Stored(retainUntil)
+	h.stats.Stored()
+	slog.Debug("Added response to cache", "path", r.URL.Path, "key", key, "expires", expires, "retain_until", retainUntil, "size", len(encoded))
+}
+
+// revalidate refreshes a stale entry in the background, conditionally where
+// the entry allows. Only one refresh of each entry runs at a time.
+func (h *CacheHandler) revalidate(r *http.Request, key CacheKey, cached CacheableResponse) {
+	_, running := h.revalidating.LoadOrStore(key, struct{}{})
+	if running {
+		return
+	}
+
+	// The refresh outlives the request, and its outcome shouldn't be
+	// attributed to it.
+	ctx := context.WithValue(context.WithoutCancel(r.Context()), requestTagsKey{}, NewRequestTags())
+	req, conditional := cached.ConditionalRequest(r.WithContext(ctx))
+
+	go func() {
+		defer h.revalidating.Delete(key)
+
+		variant := h.newVariant(req)
+		fetched := h.fetchAndStore(newDiscardResponseWriter(), req, variant, key)
+		if conditional && fetched.StatusCode == http.StatusNotModified {
+			h.storeRevalidated(req, variant, key, cached, fetched.HttpHeader)
+		}
+		slog.Debug("Revalidated stale response", "path", req.URL.Path, "key", key, "status", fetched.StatusCode)
+	}()
+}
+
+func (h *CacheHandler) newVariant(r *http.Request) *Variant {
+	variant := NewVariant(r)
+	variant.SetKeyHeaders(h.varyHeaders)
+	return variant
+}
 
 func (h *CacheHandler) fetchFromCache(r *http.Request, variant *Variant) (CacheableResponse, CacheKey, bool) {
 	key := variant.CacheKey()
@@ -94,3 +278,84 @@ func (h *CacheHandler) shouldCacheRequest(r *http.Request) bool {
 
 	return allowedMethod && !isUpgrade && !isRange
 }
+
+// interceptingWriter passes a response through unless its status is one to
+// intercept, in which case it's dropped so that a cached response can be
+// served instead.
+type interceptingWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	intercept   func(statusCode int) bool
+	statusCode  int
+	intercepted bool
+	wroteHeader bool
+}
+
+func newInterceptingWriter(w http.ResponseWriter, intercept func(statusCode int) bool) *interceptingWriter {
+	return &interceptingWriter{ResponseWriter: w, header: w.Header().Clone(), intercept: intercept}
+}
+
+func (w *interceptingWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *interceptingWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = statusCode
+
+	if w.intercept(statusCode) {
+		w.intercepted = true
+		return
+	}
+
+	clear(w.ResponseWriter.Header())
+	maps.Copy(w.ResponseWriter.Header(), w.header)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *interceptingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *interceptingWriter) Flush() {
+	if w.intercepted {
+		return
+	}
+
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (w *interceptingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// discardResponseWriter accepts a response and throws it away.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: http.Header{}}
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
@@ -2,13 +2,17 @@ package internal
 
 import (
 	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCacheHandler_caching(t *testing.T) {
@@ -166,6 +170,68 @@ func TestCacheHandler_vary_header(t *testing.T) {
 	assert.Equal(t, "hit", resp.Header().Get("X-Cache"))
 }
 
+func TestCacheHandler_vary_headers(t *testing.T) {
+	counter := 0
+
+	handler := NewCacheHandler(newTestCache(), 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counter++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		fmt.Fprintf(w, "Hello %s %d", r.Header.Get("X-GeoIP-Country"), counter)
+	}))
+	handler.SetVaryHeaders([]string{"X-GeoIP-Country"})
+
+	get := func(country string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-GeoIP-Country", country)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "Hello US 1", get("US"))
+	assert.Equal(t, "Hello GB 2", get("GB"))
+	assert.Equal(t, "Hello US 1", get("US"))
+	assert.Equal(t, "Hello GB 2", get("GB"))
+}
+
+func TestCacheHandler_policy(t *testing.T) {
+	counter := 0
+
+	handler := NewCacheHandler(newTestCache(), 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counter++
+		if r.URL.Path == "/admin" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		if r.URL.Path == "/login" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Header().Set("Set-Cookie", "session=1")
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "Hello %d", counter)
+	}))
+	handler.SetPolicy(NewCachePolicy([]CacheRule{
+		{Path: "/admin/**", Action: CacheRuleNever},
+		{Path: "/**", ContentType: "text/html", Action: CacheRuleCache},
+	}, time.Minute, true))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	assert.Equal(t, "Hello 1", get("/home").Body.String())
+	assert.Equal(t, "Hello 1", get("/home").Body.String())
+
+	assert.Equal(t, "Hello 2", get("/admin").Body.String())
+	assert.Equal(t, "Hello 3", get("/admin").Body.String())
+
+	w := get("/login")
+	assert.Equal(t, "Hello 4", w.Body.String())
+	assert.Equal(t, "session=1", w.Header().Get("Set-Cookie"))
+	assert.Equal(t, "Hello 5", get("/login").Body.String())
+}
+
 func TestCacheHandler_different_hosts(t *testing.T) {
 	cache := newTestCache()
 	handler := NewCacheHandler(cache, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -241,6 +307,254 @@ func TestCacheHandler_range_requests_are_not_cached(t *testing.T) {
 	assert.Equal(t, "bypass", w.Header().Get("X-Cache"))
 }
 
+func TestCacheHandler_serves_ranges_from_cached_responses(t *testing.T) {
+	cache := newTestCache()
+
+	handler := NewCacheHandler(cache, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Etag", `"v1"`)
+		w.Write([]byte("Hello, world"))
+	}))
+
+	doReq := func(header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		for name, value := range header {
+			r.Header.Set(name, value)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := doReq(nil)
+	assert.Equal(t, "miss", w.Header().Get("X-Cache"))
+
+	w = doReq(nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hit", w.Header().Get("X-Cache"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "Hello, world", w.Body.String())
+
+	w = doReq(map[string]string{"Range": "bytes=7-"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "hit", w.Header().Get("X-Cache"))
+	assert.Equal(t, "bytes 7-11/12", w.Header().Get("Content-Range"))
+	assert.Equal(t, "5", w.Header().Get("Content-Length"))
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, "world", w.Body.String())
+
+	w = doReq(map[string]string{"Range": "bytes=0-4", "If-Range": `"v1"`})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "Hello", w.Body.String())
+
+	w = doReq(map[string]string{"Range": "bytes=0-4", "If-Range": `"v0"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hello, world", w.Body.String())
+
+	w = doReq(map[string]string{"Range": "bytes=20-"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+}
+
+func TestCacheHandler_tags_requests_with_cache_status(t *testing.T) {
+	cache := newTestCache()
+
+	handler := NewCacheHandler(cache, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte("hello"))
+	}))
+
+	statusFor := func(method string) string {
+		r, tags := WithRequestTags(httptest.NewRequest(method, "/", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		return tags.Get(TagCacheStatus)
+	}
+
+	assert.Equal(t, "miss", statusFor("GET"))
+	assert.Equal(t, "hit", statusFor("GET"))
+	assert.Equal(t, "bypass", statusFor("POST"))
+}
+
+func TestCacheHandler_stale_while_revalidate(t *testing.T) {
+	var counter atomic.Int32
+
+	handler := NewCacheHandler(newTestCache(), 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60, stale-while-revalidate=30")
+		fmt.Fprintf(w, "Hello %d", counter.Add(1))
+	}))
+
+	get := func(at time.Duration) *httptest.ResponseRecorder {
+		handler.getCurrentTime = func() time.Time { return time.Now().Add(at) }
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+
+	w := get(0)
+	assert.Equal(t, "miss", w.Header().Get("X-Cache"))
+
+	w = get(70 * time.Second)
+	assert.Equal(t, "stale", w.Header().Get("X-Cache"))
+	assert.Equal(t, "Hello 1", w.Body.String())
+
+	require.Eventually(t, func() bool {
+		return get(0).Body.String() == "Hello 2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), counter.Load())
+
+	w = get(100 * time.Second)
+	assert.Equal(t, "miss", w.Header().Get("X-Cache"))
+	assert.Equal(t, "Hello 3", w.Body.String())
+}
+
+func TestCacheHandler_range_request_while_revalidating(t *testing.T) {
+	var counter atomic.Int32
+
+	handler := NewCacheHandler(newTestCache(), 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60, stale-while-revalidate=30")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(fmt.Sprintf("0123456789 %d", counter.Add(1))))
+	}))
+
+	get := func(at time.Duration, rangeHeader string) *httptest.ResponseRecorder {
+		handler.getCurrentTime = func() time.Time { return time.Now().Add(at) }
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	get(0, "")
+
+	w := get(70*time.Second, "bytes=0-1")
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "stale", w.Header().Get("X-Cache"))
+	assert.Equal(t, "01", w.Body.String())
+
+	// The refresh asks for, and stores, the whole response
+	require.Eventually(t, func() bool {
+		w := get(0, "")
+		return w.Code == http.StatusOK && w.Body.String() == "0123456789 2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCacheHandler_stale_if_error(t *testing.T) {
+	status := http.StatusOK
+
+	handler := NewCacheHandler(newTestCache(), 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60, stale-if-error=300")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "Status %d", status)
+	}))
+
+	get := func(at time.Duration) *httptest.ResponseRecorder {
+		handler.getCurrentTime = func() time.Time { return time.Now().Add(at) }
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+
+	get(0)
+	status = http.StatusBadGateway
+
+	w := get(70 * time.Second)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "stale", w.Header().Get("X-Cache"))
+	assert.Equal(t, "Status 200", w.Body.String())
+
+	w = get(400 * time.Second)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "Status 502", w.Body.String())
+
+	status = http.StatusCreated
+
+	w = get(70 * time.Second)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "miss", w.Header().Get("X-Cache"))
+	assert.Equal(t, "Status 201", w.Body.String())
+}
+
+func TestCacheHandler_conditional_revalidation(t *testing.T) {
+	requests := 0
+	var received http.Header
+
+	handler := NewCacheHandler(newTestCache(), 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		received = r.Header.Clone()
+
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("X-Revalidated", "true")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("Version 1"))
+	}))
+
+	get := func(at time.Duration, header http.Header) *httptest.ResponseRecorder {
+		handler.getCurrentTime = func() time.Time { return time.Now().Add(at) }
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		maps.Copy(r.Header, header)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(0, nil)
+	assert.Equal(t, "miss", w.Header().Get("X-Cache"))
+
+	// Once expired, the upstream is asked whether the entry has changed
+	w = get(70*time.Second, nil)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, `"v1"`, received.Get("If-None-Match"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "revalidated", w.Header().Get("X-Cache"))
+	assert.Equal(t, "Version 1", w.Body.String())
+
+	// The entry is kept, with the headers from the 304
+	w = get(0, nil)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, "hit", w.Header().Get("X-Cache"))
+	assert.Equal(t, "true", w.Header().Get("X-Revalidated"))
+
+	// The client's own conditions are answered from the entry
+	w = get(70*time.Second, http.Header{"If-None-Match": {`"v1"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "revalidated", w.Header().Get("X-Cache"))
+	assert.Empty(t, w.Body.String())
+}
+
+func TestCacheHandler_counts_stats(t *testing.T) {
+	status := http.StatusOK
+	stats := NewCacheStats()
+
+	handler := NewCacheHandler(newTestCache(), 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60, stale-if-error=300")
+		w.WriteHeader(status)
+	}))
+	handler.SetStats(stats)
+
+	serve := func(method string, at time.Duration) {
+		handler.getCurrentTime = func() time.Time { return time.Now().Add(at) }
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+
+	serve("GET", 0)
+	serve("GET", 0)
+	serve("GET", 0)
+	serve("POST", 0)
+
+	status = http.StatusBadGateway
+	serve("GET", 70*time.Second)
+
+	snapshot := stats.Snapshot()
+	assert.Equal(t, CacheStatsSnapshot{Hits: 2, Misses: 1, Stale: 1, Bypasses: 1, Stores: 1}, snapshot)
+	assert.Equal(t, 0.75, snapshot.HitRatio())
+}
+
 func BenchmarkCacheHandler_retrieving(b *testing.B) {
 	cache := NewMemoryCache(1*MB, 1*MB)
 
@@ -259,6 +573,7 @@ func BenchmarkCacheHandler_retrieving(b *testing.B) {
 // Mocks
 
 type testCache struct {
+	sync.Mutex
 	items map[CacheKey][]byte
 }
 
@@ -267,10 +582,20 @@ func newTestCache() *testCache {
 }
 
 func (t *testCache) Get(key CacheKey) ([]byte, bool) {
+	t.Lock()
+	defer t.Unlock()
+
 	item, found := t.items[key]
 	return item, found
 }
 
 func (t *testCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
 	t.items[key] = value
 }
+
+func (t *testCache) Close() error {
+	return nil
+}
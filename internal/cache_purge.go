@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	surrogateKeyHeader = "Surrogate-Key"
+
+	// How long purges are kept at least, to cover responses cached by other
+	// instances, or before a restart, whose expiry we haven't seen
+	cachePurgeMinRetention = 24 * time.Hour
+)
+
+// CachePurge selects cached responses to invalidate: those for an exact URL,
+// those under a path prefix, or those the upstream tagged with a surrogate
+// key. An empty Host matches every host.
+type CachePurge struct {
+	Host   string
+	Path   string
+	Query  string
+	Prefix bool
+	Tags   []string
+}
+
+type cachePurgeKind int
+
+const (
+	cachePurgeURL cachePurgeKind = iota
+	cachePurgePrefix
+	cachePurgeTag
+)
+
+// cachePurgeKey indexes purges by what they match, so that checking a
+// response takes a few lookups, however many purges there are. The value is
+// the URL, the path prefix, or the tag.
+type cachePurgeKey struct {
+	kind  cachePurgeKind
+	host  string
+	value string
+}
+
+type cachePurgeRecord struct {
+	purgedAt  time.Time
+	retainFor time.Time
+}
+
+// CachePurger remembers purges, and reports cached responses stored before a
+// matching purge as invalid. The keys of a cache can't be listed, so rather
+// than finding and deleting the responses up front, we skip over them when
+// they're next requested, and let them be replaced.
+//
+// A purge is kept until every response that was cached before it has
+// expired, and for a day at least. Purges are kept in memory, so with a
+// shared or persistent cache, each instance needs to be sent the purge, and a
+// restart forgets them. Repeating a purge replaces the earlier one, so only
+// the distinct purges are kept.
+type CachePurger struct {
+	sync.Mutex
+	records        map[cachePurgeKey]cachePurgeRecord
+	horizon        time.Time
+	getCurrentTime GetCurrentTime
+}
+
+func NewCachePurger() *CachePurger {
+	return &CachePurger{
+		records:        map[cachePurgeKey]cachePurgeRecord{},
+		getCurrentTime: time.Now,
+	}
+}
+
+func (p *CachePurger) Purge(purge CachePurge) {
+	p.Lock()
+	defer p.Unlock()
+
+	now := p.getCurrentTime()
+	p.prune(now)
+
+	retainFor := now.Add(cachePurgeMinRetention)
+	if p.horizon.After(retainFor) {
+		retainFor = p.horizon
+	}
+
+	for _, key := range purge.keys() {
+		p.records[key] = cachePurgeRecord{purgedAt: now, retainFor: retainFor}
+	}
+}
+
+// Stored notes that a response has been cached until expiresAt, so that we
+// know how long purges need to be kept.
+func (p *CachePurger) Stored(expiresAt time.Time) {
+	if p == nil {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if expiresAt.After(p.horizon) {
+		p.horizon = expiresAt
+	}
+}
+
+// Purged reports whether the cached response to r has been purged since it
+// was stored.
+func (p *CachePurger) Purged(r *http.Request, response CacheableResponse) bool {
+	if p == nil {
+		return false
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.records) == 0 {
+		return false
+	}
+
+	purgedSince := func(key cachePurgeKey) bool {
+		record, ok := p.records[key]
+		return ok && record.purgedAt.After(response.StoredAt)
+	}
+
+	url := cachePurgeURLValue(r.URL.Path, r.URL.Query().Encode())
+	tags := strings.Fields(response.HttpHeader.Get(surrogateKeyHeader))
+
+	for _, host := range []string{"", strings.ToLower(r.Host)} {
+		if purgedSince(cachePurgeKey{cachePurgeURL, host, url}) {
+			return true
+		}
+
+		for _, tag := range tags {
+			if purgedSince(cachePurgeKey{cachePurgeTag, host, tag}) {
+				return true
+			}
+		}
+
+		for i := range len(r.URL.Path) + 1 {
+			if purgedSince(cachePurgeKey{cachePurgePrefix, host, r.URL.Path[:i]}) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Private
+
+func (p *CachePurger) prune(now time.Time) {
+	maps.DeleteFunc(p.records, func(key cachePurgeKey, record cachePurgeRecord) bool {
+		return record.retainFor.Before(now)
+	})
+}
+
+// keys are the index entries for the purge. A purge by tag matches whatever
+// the path; one with several tags matches any of them.
+func (c CachePurge) keys() []cachePurgeKey {
+	host := strings.ToLower(c.Host)
+
+	if len(c.Tags) > 0 {
+		keys := make([]cachePurgeKey, len(c.Tags))
+		for i, tag := range c.Tags {
+			keys[i] = cachePurgeKey{cachePurgeTag, host, tag}
+		}
+		return keys
+	}
+
+	if c.Prefix {
+		return []cachePurgeKey{{cachePurgePrefix, host, c.Path}}
+	}
+
+	return []cachePurgeKey{{cachePurgeURL, host, cachePurgeURLValue(c.Path, c.Query)}}
+}
+
+func cachePurgeURLValue(path, query string) string {
+	return path + "?" + query
+}
+
+// CachePurgeMiddleware handles PURGE requests, authenticated with a bearer
+// token:
+//
+//   - `PURGE /path?query` purges that URL
+//   - `PURGE /path/*` purges everything under `/path/`
+//   - `PURGE /` with a `Surrogate-Key: a b` header purges responses tagged
+//     with either key
+//
+// Purges only apply to the host they were sent to. PURGE requests without the
+// token are refused.
+type CachePurgeMiddleware struct {
+	purger *CachePurger
+	token  string
+	next   http.Handler
+}
+
+func NewCachePurgeMiddleware(purger *CachePurger, token string, next http.Handler) *CachePurgeMiddleware {
+	return &CachePurgeMiddleware{
+		purger: purger,
+		token:  token,
+		next:   next,
+	}
+}
+
+func (h *CachePurgeMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PURGE" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if !h.authorized(r) {
+		slog.Info("Refusing unauthorized cache purge", "path", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	purge := CachePurge{Host: r.Host}
+
+	if tags := strings.Fields(r.Header.Get(surrogateKeyHeader)); len(tags) > 0 {
+		purge.Tags = tags
+	} else if prefix, ok := strings.CutSuffix(r.URL.Path, "*"); ok {
+		purge.Path = prefix
+		purge.Prefix = true
+	} else {
+		purge.Path = r.URL.Path
+		purge.Query = r.URL.Query().Encode()
+	}
+
+	h.purger.Purge(purge)
+	slog.Info("Purged cache", "host", purge.Host, "path", purge.Path, "prefix", purge.Prefix, "tags", purge.Tags)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Private
+
+func (h *CachePurgeMiddleware) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
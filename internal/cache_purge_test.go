@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachePurger_Purged(t *testing.T) {
+	storedAt := time.Now()
+	cached := CacheableResponse{HttpHeader: http.Header{"Surrogate-Key": {"products featured"}}, StoredAt: storedAt}
+
+	request := httptest.NewRequest("GET", "http://example.com/products/1?b=2&a=1", nil)
+
+	tests := map[string]struct {
+		purge    CachePurge
+		expected bool
+	}{
+		"exact url":            {CachePurge{Host: "example.com", Path: "/products/1", Query: "a=1&b=2"}, true},
+		"exact url, any host":  {CachePurge{Path: "/products/1", Query: "a=1&b=2"}, true},
+		"different query":      {CachePurge{Host: "example.com", Path: "/products/1"}, false},
+		"different host":       {CachePurge{Host: "other.com", Path: "/products/1", Query: "a=1&b=2"}, false},
+		"prefix":               {CachePurge{Host: "example.com", Path: "/products/", Prefix: true}, true},
+		"other prefix":         {CachePurge{Host: "example.com", Path: "/users/", Prefix: true}, false},
+		"tag":                  {CachePurge{Host: "example.com", Tags: []string{"users", "featured"}}, true},
+		"other tag":            {CachePurge{Host: "example.com", Tags: []string{"users"}}, false},
+		"host is insensitive":  {CachePurge{Host: "EXAMPLE.com", Path: "/", Prefix: true}, true},
+		"tag ignores the path": {CachePurge{Host: "example.com", Path: "/users/", Tags: []string{"products"}}, true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			purger := NewCachePurger()
+			purger.getCurrentTime = func() time.Time { return storedAt.Add(time.Second) }
+			purger.Purge(tc.purge)
+
+			assert.Equal(t, tc.expected, purger.Purged(request, cached))
+		})
+	}
+}
+
+func TestCachePurger_ignores_responses_stored_after_the_purge(t *testing.T) {
+	now := time.Now()
+	purger := NewCachePurger()
+	purger.getCurrentTime = func() time.Time { return now }
+	purger.Purge(CachePurge{Path: "/", Prefix: true})
+
+	request := httptest.NewRequest("GET", "/", nil)
+
+	assert.True(t, purger.Purged(request, CacheableResponse{HttpHeader: http.Header{}, StoredAt: now.Add(-time.Second)}))
+	assert.False(t, purger.Purged(request, CacheableResponse{HttpHeader: http.Header{}, StoredAt: now.Add(time.Second)}))
+}
+
+func TestCachePurger_forgets_purges_once_cached_responses_expire(t *testing.T) {
+	now := time.Now()
+	purger := NewCachePurger()
+	purger.getCurrentTime = func() time.Time { return now }
+
+	purger.Stored(now.Add(48 * time.Hour))
+	purger.Purge(CachePurge{Path: "/a"})
+
+	now = now.Add(25 * time.Hour)
+	purger.Purge(CachePurge{Path: "/b"})
+	assert.Len(t, purger.records, 2)
+
+	now = now.Add(24 * time.Hour)
+	purger.Purge(CachePurge{Path: "/c"})
+	assert.Len(t, purger.records, 2)
+	assert.Contains(t, purger.records, cachePurgeKey{cachePurgeURL, "", "/b?"})
+}
+
+func TestCachePurger_keeps_only_the_latest_of_a_repeated_purge(t *testing.T) {
+	now := time.Now()
+	purger := NewCachePurger()
+	purger.getCurrentTime = func() time.Time { return now }
+
+	request := httptest.NewRequest("GET", "http://example.com/products/1", nil)
+
+	for range 100 {
+		now = now.Add(time.Second)
+		purger.Purge(CachePurge{Host: "example.com", Path: "/products/", Prefix: true})
+	}
+	assert.Len(t, purger.records, 1)
+
+	assert.True(t, purger.Purged(request, CacheableResponse{HttpHeader: http.Header{}, StoredAt: now.Add(-time.Second)}))
+	assert.False(t, purger.Purged(request, CacheableResponse{HttpHeader: http.Header{}, StoredAt: now}))
+}
+
+func TestCachePurger_nil_is_never_purged(t *testing.T) {
+	var purger *CachePurger
+
+	purger.Stored(time.Now())
+	assert.False(t, purger.Purged(httptest.NewRequest("GET", "/", nil), CacheableResponse{}))
+}
+
+func TestCachePurgeMiddleware(t *testing.T) {
+	purger := NewCachePurger()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := NewCachePurgeMiddleware(purger, "secret", next)
+
+	purge := func(target, token string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PURGE", target, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("passes other methods through", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	})
+
+	t.Run("requires the token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, purge("http://example.com/", "", nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, purge("http://example.com/", "wrong", nil).Code)
+		assert.Empty(t, purger.records)
+	})
+
+	t.Run("purges a url", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, purge("http://example.com/a?x=1", "secret", nil).Code)
+		assert.Contains(t, purger.records, cachePurgeKey{cachePurgeURL, "example.com", "/a?x=1"})
+	})
+
+	t.Run("purges a prefix", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, purge("http://example.com/a/*", "secret", nil).Code)
+		assert.Contains(t, purger.records, cachePurgeKey{cachePurgePrefix, "example.com", "/a/"})
+	})
+
+	t.Run("purges tags", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, purge("http://example.com/", "secret", http.Header{"Surrogate-Key": {"a b"}}).Code)
+		assert.Contains(t, purger.records, cachePurgeKey{cachePurgeTag, "example.com", "a"})
+		assert.Contains(t, purger.records, cachePurgeKey{cachePurgeTag, "example.com", "b"})
+	})
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CacheRuleAction string
+
+const (
+	CacheRuleCache CacheRuleAction = "cache"
+	CacheRuleNever CacheRuleAction = "never"
+)
+
+var ErrInvalidCacheRule = errors.New("cache rule must be in the form path[@content-type]=cache[:ttl] or path[@content-type]=never")
+
+// CacheRule decides the cacheability of responses to matching paths, rather
+// than leaving it to the response's own headers.
+//
+// Paths are matched segment by segment as with path.Match, and a `**`
+// segment matches any number of segments, so `/assets/**` matches everything
+// under `/assets`. The content type is optional, and may be a wildcard such
+// as `image/*`.
+type CacheRule struct {
+	Path        string
+	ContentType string
+	Action      CacheRuleAction
+	TTL         time.Duration
+}
+
+// ParseCacheRule parses a rule written as `path[@content-type]=action`, where
+// the action is `never`, or `cache` with an optional TTL in seconds
+// (`cache:3600`).
+func ParseCacheRule(value string) (CacheRule, error) {
+	target, action, ok := strings.Cut(strings.TrimSpace(value), "=")
+	if !ok {
+		return CacheRule{}, ErrInvalidCacheRule
+	}
+
+	rulePath, contentType, _ := strings.Cut(strings.TrimSpace(target), "@")
+	if !strings.HasPrefix(rulePath, "/") {
+		return CacheRule{}, ErrInvalidCacheRule
+	}
+	if _, err := path.Match(rulePath, ""); err != nil {
+		return CacheRule{}, ErrInvalidCacheRule
+	}
+
+	rule := CacheRule{Path: rulePath, ContentType: strings.ToLower(strings.TrimSpace(contentType))}
+
+	name, ttl, hasTTL := strings.Cut(strings.ToLower(strings.TrimSpace(action)), ":")
+	switch CacheRuleAction(name) {
+	case CacheRuleNever:
+		if hasTTL {
+			return CacheRule{}, ErrInvalidCacheRule
+		}
+		rule.Action = CacheRuleNever
+	case CacheRuleCache:
+		rule.Action = CacheRuleCache
+		if hasTTL {
+			seconds, err := strconv.Atoi(ttl)
+			if err != nil || seconds <= 0 {
+				return CacheRule{}, ErrInvalidCacheRule
+			}
+			rule.TTL = time.Duration(seconds) * time.Second
+		}
+	default:
+		return CacheRule{}, ErrInvalidCacheRule
+	}
+
+	return rule, nil
+}
+
+// String formats the rule in the form that ParseCacheRule reads.
+func (r CacheRule) String() string {
+	target := r.Path
+	if r.ContentType != "" {
+		target += "@" + r.ContentType
+	}
+
+	action := string(r.Action)
+	if r.TTL > 0 {
+		action += ":" + strconv.Itoa(int(r.TTL/time.Second))
+	}
+
+	return target + "=" + action
+}
+
+func (r CacheRule) Matches(requestPath string, header http.Header) bool {
+	return matchPathPattern(r.Path, requestPath) && r.matchesContentType(header.Get("Content-Type"))
+}
+
+// CachePolicy applies configured rules on top of what responses say about
+// themselves. Rules are tried in order, and the first to match applies.
+//
+// A `never` rule keeps matching responses out of the cache regardless of their
+// headers. A `cache` rule caches matching responses that have no
+// Cache-Control header, for the rule's TTL or else the default; an explicit
+// Cache-Control from the upstream is always respected.
+//
+// A nil *CachePolicy is valid, and leaves every decision to the response.
+type CachePolicy struct {
+	rules         []CacheRule
+	defaultTTL    time.Duration
+	skipSetCookie bool
+}
+
+// NewCachePolicy creates a policy with the given rules. When skipSetCookie is
+// set, responses that set cookies are not cached, rather than being cached
+// without their cookies.
+func NewCachePolicy(rules []CacheRule, defaultTTL time.Duration, skipSetCookie bool) *CachePolicy {
+	return &CachePolicy{
+		rules:         rules,
+		defaultTTL:    defaultTTL,
+		skipSetCookie: skipSetCookie,
+	}
+}
+
+// Private
+
+// decide returns whether the policy overrides the response's own headers, and
+// if so whether to cache it and until when.
+func (p *CachePolicy) decide(requestPath string, header http.Header) (bool, bool, time.Time) {
+	if p == nil {
+		return false, false, time.Time{}
+	}
+
+	if p.skipSetCookie && header.Get("Set-Cookie") != "" {
+		return true, false, time.Time{}
+	}
+
+	for _, rule := range p.rules {
+		if !rule.Matches(requestPath, header) {
+			continue
+		}
+
+		if rule.Action == CacheRuleNever {
+			return true, false, time.Time{}
+		}
+
+		if header.Get("Cache-Control") != "" {
+			return false, false, time.Time{}
+		}
+
+		ttl := rule.TTL
+		if ttl == 0 {
+			ttl = p.defaultTTL
+		}
+		return true, true, time.Now().Add(ttl)
+	}
+
+	return false, false, time.Time{}
+}
+
+func (r CacheRule) matchesContentType(value string) bool {
+	if r.ContentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return false
+	}
+
+	prefix, ok := strings.CutSuffix(r.ContentType, "*")
+	if ok {
+		return strings.HasPrefix(mediaType, prefix)
+	}
+	return mediaType == r.ContentType
+}
+
+// matchPathPattern matches a path against a pattern a segment at a time,
+// letting a `**` segment stand for any number of segments.
+func matchPathPattern(pattern, requestPath string) bool {
+	return matchPathSegments(strings.Split(pattern, "/"), strings.Split(requestPath, "/"))
+}
+
+func matchPathSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchPathSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+
+		matched, err := path.Match(pattern[0], segments[0])
+		if err != nil || !matched {
+			return false
+		}
+
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCacheRule(t *testing.T) {
+	rule, err := ParseCacheRule(" /assets/**@Image/* = cache:3600 ")
+	require.NoError(t, err)
+	assert.Equal(t, CacheRule{Path: "/assets/**", ContentType: "image/*", Action: CacheRuleCache, TTL: time.Hour}, rule)
+	assert.Equal(t, "/assets/**@image/*=cache:3600", rule.String())
+
+	rule, err = ParseCacheRule("/admin/**=never")
+	require.NoError(t, err)
+	assert.Equal(t, CacheRule{Path: "/admin/**", Action: CacheRuleNever}, rule)
+	assert.Equal(t, "/admin/**=never", rule.String())
+
+	rule, err = ParseCacheRule("/*.json=cache")
+	require.NoError(t, err)
+	assert.Equal(t, CacheRule{Path: "/*.json", Action: CacheRuleCache}, rule)
+
+	for _, value := range []string{"", "/assets", "assets/**=cache", "/assets=keep", "/assets=cache:0", "/assets=cache:x", "/assets=never:60", "/[=cache"} {
+		_, err := ParseCacheRule(value)
+		assert.ErrorIs(t, err, ErrInvalidCacheRule, value)
+	}
+}
+
+func TestMatchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matches bool
+	}{
+		{"/admin/**", "/admin", true},
+		{"/admin/**", "/admin/users/1", true},
+		{"/admin/**", "/administrator", false},
+		{"/assets/*", "/assets/app.css", true},
+		{"/assets/*", "/assets/css/app.css", false},
+		{"/**/*.json", "/api/v1/users.json", true},
+		{"/**/*.json", "/users.json", true},
+		{"/**/*.json", "/users.xml", false},
+		{"/", "/", true},
+		{"/", "/home", false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.matches, matchPathPattern(tc.pattern, tc.path), "%s %s", tc.pattern, tc.path)
+	}
+}
+
+func TestCachePolicy_decide(t *testing.T) {
+	policy := NewCachePolicy([]CacheRule{
+		{Path: "/admin/**", Action: CacheRuleNever},
+		{Path: "/assets/**", ContentType: "image/*", Action: CacheRuleCache, TTL: time.Hour},
+		{Path: "/**", ContentType: "text/html", Action: CacheRuleCache},
+	}, time.Minute, true)
+
+	decide := func(path string, header http.Header) (bool, bool, time.Duration) {
+		overridden, cacheable, expires := policy.decide(path, header)
+		if expires.IsZero() {
+			return overridden, cacheable, 0
+		}
+		return overridden, cacheable, time.Until(expires).Round(time.Minute)
+	}
+
+	overridden, cacheable, _ := decide("/admin/users", http.Header{"Cache-Control": {"public, max-age=60"}})
+	assert.True(t, overridden)
+	assert.False(t, cacheable)
+
+	overridden, cacheable, ttl := decide("/assets/logo.png", http.Header{"Content-Type": {"image/png"}})
+	assert.True(t, overridden)
+	assert.True(t, cacheable)
+	assert.Equal(t, time.Hour, ttl)
+
+	_, _, ttl = decide("/about", http.Header{"Content-Type": {"text/html; charset=utf-8"}})
+	assert.Equal(t, time.Minute, ttl)
+
+	overridden, _, _ = decide("/assets/logo.png", http.Header{"Content-Type": {"image/png"}, "Cache-Control": {"private"}})
+	assert.False(t, overridden)
+
+	overridden, _, _ = decide("/assets/app.js", http.Header{"Content-Type": {"text/javascript"}})
+	assert.False(t, overridden)
+
+	overridden, cacheable, _ = decide("/assets/logo.png", http.Header{"Content-Type": {"image/png"}, "Set-Cookie": {"a=b"}})
+	assert.True(t, overridden)
+	assert.False(t, cacheable)
+
+	var none *CachePolicy
+	overridden, _, _ = none.decide("/admin", http.Header{})
+	assert.False(t, overridden)
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats counts what the cache does with requests, and what it holds, so
+// that we can tell whether it's earning its keep.
+//
+// A nil *CacheStats is valid, and counts nothing.
+type CacheStats struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	stale       atomic.Uint64
+	revalidated atomic.Uint64
+	bypasses    atomic.Uint64
+	stores      atomic.Uint64
+	evictions   atomic.Uint64
+	storedBytes atomic.Int64
+	entries     atomic.Int64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type CacheStatsSnapshot struct {
+	Hits        uint64
+	Misses      uint64
+	Stale       uint64
+	Revalidated uint64
+	Bypasses    uint64
+	Stores      uint64
+	Evictions   uint64
+	StoredBytes int64
+	Entries     int64
+}
+
+func NewCacheStats() *CacheStats {
+	return &CacheStats{}
+}
+
+// Served counts a request by its cache status: hit, miss, stale, revalidated
+// or bypass.
+func (s *CacheStats) Served(status string) {
+	if s == nil {
+		return
+	}
+
+	switch status {
+	case "hit":
+		s.hits.Add(1)
+	case "miss":
+		s.misses.Add(1)
+	case "stale":
+		s.stale.Add(1)
+	case "revalidated":
+		s.revalidated.Add(1)
+	case "bypass":
+		s.bypasses.Add(1)
+	}
+}
+
+func (s *CacheStats) Stored() {
+	if s != nil {
+		s.stores.Add(1)
+	}
+}
+
+func (s *CacheStats) Evicted() {
+	if s != nil {
+		s.evictions.Add(1)
+	}
+}
+
+// SetStored records the size of the cache's contents, and the number of items
+// it holds.
+func (s *CacheStats) SetStored(size, entries int) {
+	if s != nil {
+		s.storedBytes.Store(int64(size))
+		s.entries.Store(int64(entries))
+	}
+}
+
+func (s *CacheStats) Snapshot() CacheStatsSnapshot {
+	if s == nil {
+		return CacheStatsSnapshot{}
+	}
+
+	return CacheStatsSnapshot{
+		Hits:        s.hits.Load(),
+		Misses:      s.misses.Load(),
+		Stale:       s.stale.Load(),
+		Revalidated: s.revalidated.Load(),
+		Bypasses:    s.bypasses.Load(),
+		Stores:      s.stores.Load(),
+		Evictions:   s.evictions.Load(),
+		StoredBytes: s.storedBytes.Load(),
+		Entries:     s.entries.Load(),
+	}
+}
+
+// StartLogging logs a snapshot of the stats at each interval, until stopped.
+func (s *CacheStats) StartLogging(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Snapshot().log()
+			}
+		}
+	}()
+}
+
+func (s *CacheStats) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+	}
+}
+
+// HitRatio is the proportion of cacheable requests answered from the cache,
+// stale, revalidated or not. Bypassed requests never could be, so they don't
+// count.
+func (s CacheStatsSnapshot) HitRatio() float64 {
+	served := s.Hits + s.Stale + s.Revalidated
+	total := served + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(served) / float64(total)
+}
+
+// Private
+
+func (s CacheStatsSnapshot) log() {
+	slog.Info("Cache stats",
+		"hits", s.Hits,
+		"misses", s.Misses,
+		"stale", s.Stale,
+		"revalidated", s.Revalidated,
+		"bypasses", s.Bypasses,
+		"hit_ratio", s.HitRatio(),
+		"stores", s.Stores,
+		"evictions", s.Evictions,
+		"stored_bytes", s.StoredBytes,
+		"entries", s.Entries)
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStats_counts(t *testing.T) {
+	stats := NewCacheStats()
+
+	stats.Served("hit")
+	stats.Served("hit")
+	stats.Served("stale")
+	stats.Served("revalidated")
+	stats.Served("miss")
+	stats.Served("bypass")
+	stats.Served("unknown")
+	stats.Stored()
+	stats.Evicted()
+	stats.SetStored(512, 2)
+
+	assert.Equal(t, CacheStatsSnapshot{
+		Hits:        2,
+		Misses:      1,
+		Stale:       1,
+		Revalidated: 1,
+		Bypasses:    1,
+		Stores:      1,
+		Evictions:   1,
+		StoredBytes: 512,
+		Entries:     2,
+	}, stats.Snapshot())
+}
+
+func TestCacheStatsSnapshot_HitRatio(t *testing.T) {
+	assert.Equal(t, 0.0, CacheStatsSnapshot{}.HitRatio())
+	assert.Equal(t, 0.0, CacheStatsSnapshot{Bypasses: 10}.HitRatio())
+	assert.Equal(t, 0.5, CacheStatsSnapshot{Hits: 1, Stale: 1, Misses: 2, Bypasses: 10}.HitRatio())
+	assert.Equal(t, 0.75, CacheStatsSnapshot{Hits: 1, Revalidated: 2, Misses: 1}.HitRatio())
+}
+
+func TestCacheStats_nil_is_valid(t *testing.T) {
+	var stats *CacheStats
+
+	stats.Served("hit")
+	stats.Stored()
+	stats.Evicted()
+	stats.SetStored(1, 1)
+
+	assert.Equal(t, CacheStatsSnapshot{}, stats.Snapshot())
+}
+
+func TestCacheStats_logging_can_be_stopped(t *testing.T) {
+	stats := NewCacheStats()
+	stats.StartLogging(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	stats.Stop()
+}
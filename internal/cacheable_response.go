@@ -16,6 +16,9 @@ var (
 	noCacheExpt = regexp.MustCompile(`\bno-cache\b`)
 	sMaxAgeExp  = regexp.MustCompile(`\bs-max-age=(\d+)\b`)
 	maxAgeExp   = regexp.MustCompile(`\bmax-age=(\d+)\b`)
+
+	staleWhileRevalidateExp = regexp.MustCompile(`\bstale-while-revalidate=(\d+)\b`)
+	staleIfErrorExp         = regexp.MustCompile(`\bstale-if-error=(\d+)\b`)
 )
 
 type CacheableResponse struct {
@@ -23,10 +26,19 @@ type CacheableResponse struct {
 	HttpHeader    http.Header
 	Body          []byte
 	VariantHeader http.Header
+	StoredAt      time.Time
+
+	// ExpiresAt is when the response stops being fresh. It may still be
+	// served for a while after that, as allowed by the stale-* extensions.
+	ExpiresAt            time.Time
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
 
 	responseWriter http.ResponseWriter
 	stasher        *stashingWriter
 	headersWritten bool
+	policy         *CachePolicy
+	requestPath    string
 }
 
 func NewCacheableResponse(w http.ResponseWriter, maxBodyLength int) *CacheableResponse {
@@ -48,7 +60,9 @@ func CacheableResponseFromBuffer(b []byte) (CacheableResponse, error) {
 }
 
 func (c *CacheableResponse) ToBuffer() ([]byte, error) {
-	c.Body = c.stasher.Body()
+	if c.stasher != nil {
+		c.Body = c.stasher.Body()
+	}
 
 	var b bytes.Buffer
 	encoder := gob.NewEncoder(&b)
@@ -57,6 +71,13 @@ func (c *CacheableResponse) ToBuffer() ([]byte, error) {
 	return b.Bytes(), err
 }
 
+// SetPolicy applies configured cache rules to the response, which was given
+// for a request to requestPath.
+func (c *CacheableResponse) SetPolicy(policy *CachePolicy, requestPath string) {
+	c.policy = policy
+	c.requestPath = requestPath
+}
+
 func (c *CacheableResponse) Header() http.Header {
 	return c.HttpHeader
 }
@@ -71,7 +92,14 @@ func (c *CacheableResponse) Write(bytes []byte) (int, error) {
 func (c *CacheableResponse) WriteHeader(statusCode int) {
 	c.StatusCode = statusCode
 	c.scrubHeaders()
-	c.copyHeaders(c.responseWriter, false, c.StatusCode)
+
+	// There's no point holding on to the body of a response we already know
+	// we won't cache
+	if !c.mayBeCached() {
+		c.stasher.Discard()
+	}
+
+	c.copyHeaders(c.responseWriter, "miss", c.StatusCode)
 	c.headersWritten = true
 }
 
@@ -83,18 +111,23 @@ func (c *CacheableResponse) Flush() {
 }
 
 func (c *CacheableResponse) CacheStatus() (bool, time.Time) {
-	if c.stasher.Overflowed() {
+	if c.stasher != nil && c.stasher.Overflowed() {
 		return false, time.Time{}
 	}
 
-	if c.StatusCode < 200 || c.StatusCode > 399 || c.StatusCode == http.StatusNotModified {
+	if c.StatusCode < 200 || c.StatusCode > 399 || c.StatusCode == http.StatusNotModified || c.StatusCode == http.StatusPartialContent {
 		return false, time.Time{}
 	}
 
-	if strings.Contains(c.HttpHeader.Get("Vary"), "*") {
+	if strings.Contains(c.HttpHeader.Get("Vary"), "*") || isEventStreamResponse(c.HttpHeader) {
 		return false, time.Time{}
 	}
 
+	overridden, cacheable, expires := c.policy.decide(c.requestPath, c.HttpHeader)
+	if overridden {
+		return cacheable, expires
+	}
+
 	cc := c.HttpHeader.Get("Cache-Control")
 
 	if !publicExp.MatchString(cc) || noCacheExpt.MatchString(cc) {
@@ -117,43 +150,179 @@ func (c *CacheableResponse) CacheStatus() (bool, time.Time) {
 	return true, time.Now().Add(time.Duration(maxAge) * time.Second)
 }
 
+// StaleWindows returns how long after expiring the response may be served
+// while it's being revalidated, and while the origin is failing, according to
+// its stale-while-revalidate and stale-if-error directives (RFC 5861).
+func (c *CacheableResponse) StaleWindows() (time.Duration, time.Duration) {
+	cc := c.HttpHeader.Get("Cache-Control")
+	return cacheControlSeconds(staleWhileRevalidateExp, cc), cacheControlSeconds(staleIfErrorExp, cc)
+}
+
+// Fresh reports whether the response has not yet expired. Responses that
+// were stored without an expiry are fresh for as long as they are cached.
+func (c *CacheableResponse) Fresh(now time.Time) bool {
+	return c.ExpiresAt.IsZero() || now.Before(c.ExpiresAt)
+}
+
+// StaleFor reports how long the response has been expired.
+func (c *CacheableResponse) StaleFor(now time.Time) time.Duration {
+	if c.Fresh(now) {
+		return 0
+	}
+	return now.Sub(c.ExpiresAt)
+}
+
 func (c *CacheableResponse) WriteCachedResponse(w http.ResponseWriter, r *http.Request) {
+	c.writeStored(w, r, "hit")
+}
+
+// WriteStaleResponse serves a response that has expired, in place of a fresh
+// one.
+func (c *CacheableResponse) WriteStaleResponse(w http.ResponseWriter, r *http.Request) {
+	c.writeStored(w, r, "stale")
+}
+
+// WriteRevalidatedResponse serves a response that has expired, but which the
+// upstream has confirmed is unchanged.
+func (c *CacheableResponse) WriteRevalidatedResponse(w http.ResponseWriter, r *http.Request) {
+	c.writeStored(w, r, "revalidated")
+}
+
+// ConditionalRequest returns a copy of r for refreshing the response, with any
+// conditions or range the client set removed, so that it asks for the whole
+// response. When the response has an ETag or
+// Last-Modified date, the copy asks the upstream to answer with 304 Not
+// Modified if it's unchanged, and this reports true.
+func (c *CacheableResponse) ConditionalRequest(r *http.Request) (*http.Request, bool) {
+	req := r.Clone(r.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	req.Header.Del("Range")
+	req.Header.Del("If-Range")
+
+	etag := c.HttpHeader.Get("Etag")
+	lastModified := c.HttpHeader.Get("Last-Modified")
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	return req, etag != "" || lastModified != ""
+}
+
+// Revalidate updates the response with the headers of a 304 Not Modified that
+// confirmed it's unchanged, as RFC 9111 describes, and decides afresh whether
+// and until when it can be cached.
+func (c *CacheableResponse) Revalidate(header http.Header) (bool, time.Time) {
+	for name, values := range header {
+		if name != "Content-Length" && name != "X-Cache" {
+			c.HttpHeader[name] = values
+		}
+	}
+
+	c.scrubHeaders()
+	return c.CacheStatus()
+}
+
+// Private
+
+func (c *CacheableResponse) writeStored(w http.ResponseWriter, r *http.Request, cacheStatus string) {
 	if c.wasNotModified(r) {
-		c.copyHeaders(w, true, http.StatusNotModified)
+		c.copyHeaders(w, cacheStatus, http.StatusNotModified)
+	} else if c.servesRanges() && r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+		c.writeRanges(w, r, cacheStatus)
 	} else {
-		c.copyHeaders(w, true, c.StatusCode)
+		if c.servesRanges() {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		c.copyHeaders(w, cacheStatus, c.StatusCode)
 		io.Copy(w, bytes.NewReader(c.Body))
 	}
 }
 
-// Private
+// servesRanges reports whether Range requests can be answered from the
+// response, which needs the whole of a successful body.
+func (c *CacheableResponse) servesRanges() bool {
+	return c.StatusCode == http.StatusOK
+}
 
+// writeRanges answers a Range request with the parts of the body it asks for,
+// using http.ServeContent. That honours If-Range against the response's ETag
+// and Last-Modified date, serving the whole body when they don't match.
+func (c *CacheableResponse) writeRanges(w http.ResponseWriter, r *http.Request, cacheStatus string) {
+	for k, v := range c.HttpHeader {
+		// ServeContent sets the Content-Length for whatever part it serves
+		if k != surrogateKeyHeader && k != "Content-Length" {
+			w.Header()[k] = v
+		}
+	}
+
+	w.Header().Set("X-Cache", cacheStatus)
+
+	lastModified, _ := http.ParseTime(c.HttpHeader.Get("Last-Modified"))
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(c.Body))
+}
+
+func (c *CacheableResponse) mayBeCached() bool {
+	cacheable, _ := c.CacheStatus()
+	if !cacheable {
+		return false
+	}
+
+	contentLength, err := strconv.Atoi(c.HttpHeader.Get("Content-Length"))
+	return err != nil || contentLength <= c.stasher.limit
+}
+
+// wasNotModified evaluates the request's conditions against the response, as
+// RFC 9110 describes. If-Modified-Since only applies when there's no
+// If-None-Match.
 func (c *CacheableResponse) wasNotModified(r *http.Request) bool {
-	requestEtag := c.HttpHeader.Get("Etag")
-	if requestEtag == "" {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		return false
 	}
 
-	ifNoneMatch := strings.Split(r.Header.Get("If-None-Match"), ",")
-	for _, etag := range ifNoneMatch {
-		if strings.TrimSpace(etag) == requestEtag {
-			return true
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		responseEtag := c.HttpHeader.Get("Etag")
+		if responseEtag == "" {
+			return false
+		}
+
+		for _, etag := range strings.Split(ifNoneMatch, ",") {
+			etag = strings.TrimSpace(etag)
+			if etag == "*" || weakETag(etag) == weakETag(responseEtag) {
+				return true
+			}
 		}
+
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
 	}
 
-	return false
+	lastModified, err := http.ParseTime(c.HttpHeader.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ifModifiedSince)
 }
 
-func (c *CacheableResponse) copyHeaders(w http.ResponseWriter, wasHit bool, statusCode int) {
+func (c *CacheableResponse) copyHeaders(w http.ResponseWriter, cacheStatus string, statusCode int) {
 	for k, v := range c.HttpHeader {
-		w.Header()[k] = v
+		// Surrogate keys are for us, not the client
+		if k != surrogateKeyHeader {
+			w.Header()[k] = v
+		}
 	}
 
-	if wasHit {
-		w.Header().Set("X-Cache", "hit")
-	} else {
-		w.Header().Set("X-Cache", "miss")
-	}
+	w.Header().Set("X-Cache", cacheStatus)
 
 	w.WriteHeader(statusCode)
 }
@@ -166,6 +335,26 @@ func (c *CacheableResponse) scrubHeaders() {
 	}
 }
 
+// weakETag strips the weakness indicator from an ETag, since If-None-Match
+// compares them weakly.
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+func cacheControlSeconds(exp *regexp.Regexp, cc string) time.Duration {
+	matches := exp.FindStringSubmatch(cc)
+	if len(matches) != 2 {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(matches[1])
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
 type stashingWriter struct {
 	limit      int
 	dest       io.Writer
@@ -181,15 +370,24 @@ func NewStashingWriter(limit int, dest io.Writer) *stashingWriter {
 }
 
 func (w *stashingWriter) Write(p []byte) (int, error) {
-	if w.buffer.Len()+len(p) > w.limit {
-		w.overflowed = true
-	} else {
-		w.buffer.Write(p)
+	if !w.overflowed {
+		if w.buffer.Len()+len(p) > w.limit {
+			w.Discard()
+		} else {
+			w.buffer.Write(p)
+		}
 	}
 
 	return w.dest.Write(p)
 }
 
+// Discard stops stashing the body, and releases what has been stashed so far.
+// The rest of the body is streamed straight through.
+func (w *stashingWriter) Discard() {
+	w.overflowed = true
+	w.buffer = bytes.Buffer{}
+}
+
 func (w *stashingWriter) Body() []byte {
 	if w.overflowed {
 		return nil
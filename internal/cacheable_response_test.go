@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -93,6 +94,16 @@ func TestCacheableResponse_does_not_cache_304_responses(t *testing.T) {
 	assert.False(t, cacheable)
 }
 
+func TestCacheableResponse_does_not_cache_206_responses(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cr := NewCacheableResponse(rec, 1024)
+	cr.Header().Set("Cache-Control", "public, max-age=60")
+	cr.WriteHeader(http.StatusPartialContent)
+
+	cacheable, _ := cr.CacheStatus()
+	assert.False(t, cacheable)
+}
+
 func TestCacheableResponse_writes_response_to_writer(t *testing.T) {
 	w := httptest.NewRecorder()
 	cr := NewCacheableResponse(w, 1024)
@@ -173,6 +184,7 @@ func TestCacheableResponse_conditional_response(t *testing.T) {
 func TestCacheableResponse_conditional_response_none_match(t *testing.T) {
 	rec := httptest.NewRecorder()
 	cr := NewCacheableResponse(rec, 1024)
+	cr.Header().Set("Cache-Control", "public, max-age=60")
 	cr.Header().Set("Etag", "ffffffff")
 	cr.WriteHeader(http.StatusOK)
 	cr.Write([]byte("Hello World"))
@@ -193,6 +205,7 @@ func TestCacheableResponse_conditional_response_none_match(t *testing.T) {
 func TestCacheableResponse_conditional_response_no_etag_in_request(t *testing.T) {
 	rec := httptest.NewRecorder()
 	cr := NewCacheableResponse(rec, 1024)
+	cr.Header().Set("Cache-Control", "public, max-age=60")
 	cr.Header().Set("Etag", "ffffffff")
 	cr.WriteHeader(http.StatusOK)
 	cr.Write([]byte("Hello World"))
@@ -212,6 +225,7 @@ func TestCacheableResponse_conditional_response_no_etag_in_request(t *testing.T)
 func TestCacheableResponse_conditional_response_no_etag_in_response(t *testing.T) {
 	rec := httptest.NewRecorder()
 	cr := NewCacheableResponse(rec, 1024)
+	cr.Header().Set("Cache-Control", "public, max-age=60")
 	cr.WriteHeader(http.StatusOK)
 	cr.Write([]byte("Hello World"))
 
@@ -228,6 +242,111 @@ func TestCacheableResponse_conditional_response_no_etag_in_response(t *testing.T
 	assert.Equal(t, "hit", w.Header().Get("X-Cache"))
 }
 
+func TestCacheableResponse_conditional_response_weak_etags(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cr := NewCacheableResponse(rec, 1024)
+	cr.Header().Set("Etag", `W/"deadbeef"`)
+	cr.WriteHeader(http.StatusOK)
+	cr.Write([]byte("Hello World"))
+
+	cr.ToBuffer() // Ensure the body is saved
+
+	for _, ifNoneMatch := range []string{`"deadbeef"`, `W/"deadbeef"`, "*"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		cr.WriteCachedResponse(w, r)
+
+		assert.Equal(t, http.StatusNotModified, w.Code, ifNoneMatch)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("If-None-Match", "*")
+	cr.WriteCachedResponse(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCacheableResponse_conditional_response_if_modified_since(t *testing.T) {
+	lastModified := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	rec := httptest.NewRecorder()
+	cr := NewCacheableResponse(rec, 1024)
+	cr.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	cr.Header().Set("Etag", `"deadbeef"`)
+	cr.WriteHeader(http.StatusOK)
+	cr.Write([]byte("Hello World"))
+
+	cr.ToBuffer() // Ensure the body is saved
+
+	serve := func(header http.Header) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header = header
+		cr.WriteCachedResponse(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNotModified, serve(http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}}))
+	assert.Equal(t, http.StatusNotModified, serve(http.Header{"If-Modified-Since": {lastModified.Add(time.Hour).Format(http.TimeFormat)}}))
+	assert.Equal(t, http.StatusOK, serve(http.Header{"If-Modified-Since": {lastModified.Add(-time.Hour).Format(http.TimeFormat)}}))
+	assert.Equal(t, http.StatusOK, serve(http.Header{"If-Modified-Since": {"yesterday"}}))
+
+	// If-None-Match takes precedence
+	assert.Equal(t, http.StatusOK, serve(http.Header{
+		"If-None-Match":     {`"another"`},
+		"If-Modified-Since": {lastModified.Format(http.TimeFormat)},
+	}))
+}
+
+func TestCacheableResponse_conditional_request(t *testing.T) {
+	cr := CacheableResponse{HttpHeader: http.Header{}}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"client"`)
+	r.Header.Set("Range", "bytes=0-1")
+	r.Header.Set("If-Range", `"client"`)
+
+	req, conditional := cr.ConditionalRequest(r)
+	assert.False(t, conditional)
+	assert.Empty(t, req.Header.Get("If-None-Match"))
+	assert.Empty(t, req.Header.Get("Range"))
+	assert.Empty(t, req.Header.Get("If-Range"))
+	assert.Equal(t, `"client"`, r.Header.Get("If-None-Match"))
+
+	cr.HttpHeader.Set("Etag", `"deadbeef"`)
+	cr.HttpHeader.Set("Last-Modified", "Wed, 01 Jan 2025 12:00:00 GMT")
+
+	req, conditional = cr.ConditionalRequest(r)
+	assert.True(t, conditional)
+	assert.Equal(t, `"deadbeef"`, req.Header.Get("If-None-Match"))
+	assert.Equal(t, "Wed, 01 Jan 2025 12:00:00 GMT", req.Header.Get("If-Modified-Since"))
+}
+
+func TestCacheableResponse_revalidate(t *testing.T) {
+	cr := CacheableResponse{
+		StatusCode: http.StatusOK,
+		HttpHeader: http.Header{"Cache-Control": {"public, max-age=60"}, "Etag": {`"v1"`}, "Content-Length": {"11"}},
+		Body:       []byte("Hello World"),
+	}
+
+	cacheable, expires := cr.Revalidate(http.Header{
+		"Cache-Control":  {"public, max-age=120"},
+		"Content-Length": {"0"},
+		"Set-Cookie":     {"session=abc"},
+	})
+
+	assert.True(t, cacheable)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), expires, time.Second)
+	assert.Equal(t, "11", cr.HttpHeader.Get("Content-Length"))
+	assert.Equal(t, `"v1"`, cr.HttpHeader.Get("Etag"))
+	assert.Empty(t, cr.HttpHeader.Get("Set-Cookie"))
+
+	cacheable, _ = cr.Revalidate(http.Header{"Cache-Control": {"no-cache"}})
+	assert.False(t, cacheable)
+}
+
 func TestCacheableResponse_scrubs_cookies_from_cacheable_responses(t *testing.T) {
 	rec := httptest.NewRecorder()
 	cr := NewCacheableResponse(rec, 1024)
@@ -317,3 +436,47 @@ func TestStashingWriter_writing_over_limit_in_small_pieces(t *testing.T) {
 	assert.Nil(t, sw.Body())
 	assert.True(t, sw.Overflowed())
 }
+
+func TestStashingWriter_discard_releases_the_stashed_body(t *testing.T) {
+	writer := &bytes.Buffer{}
+	sw := NewStashingWriter(10, writer)
+
+	sw.Write([]byte("12345"))
+	sw.Discard()
+	sw.Write([]byte("67"))
+
+	assert.Equal(t, "1234567", writer.String())
+	assert.Nil(t, sw.Body())
+	assert.Equal(t, 0, sw.buffer.Cap())
+}
+
+func TestCacheableResponse_does_not_stash_uncacheable_bodies(t *testing.T) {
+	tests := map[string]http.Header{
+		"private":           {"Cache-Control": {"private, max-age=60"}},
+		"too large":         {"Cache-Control": {"public, max-age=60"}, "Content-Length": {"2048"}},
+		"without max-age":   {"Cache-Control": {"public"}},
+		"explicit no-cache": {"Cache-Control": {"public, no-cache, max-age=60"}},
+	}
+
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			cr := NewCacheableResponse(w, 1024)
+			for k, v := range header {
+				cr.Header()[k] = v
+			}
+			cr.WriteHeader(http.StatusOK)
+			cr.Write([]byte("hello"))
+
+			assert.Equal(t, "hello", w.Body.String())
+			assert.True(t, cr.stasher.Overflowed())
+		})
+	}
+
+	w := httptest.NewRecorder()
+	cr := NewCacheableResponse(w, 1024)
+	cr.Header().Set("Cache-Control", "public, max-age=60")
+	cr.Write([]byte("hello"))
+
+	assert.Equal(t, []byte("hello"), cr.stasher.Body())
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Middleware wraps a handler to add behaviour before and/or after it.
+type Middleware func(next http.Handler) http.Handler
+
+var (
+	ErrStageNotFound = errors.New("stage not found")
+	ErrStageExists   = errors.New("stage already exists")
+)
+
+type chainStage struct {
+	name       string
+	middleware Middleware
+}
+
+// Chain is an ordered list of named middleware stages, from the outermost
+// (the first to see a request) to the innermost. Stages can be inserted
+// relative to one another by name, so that callers can place their own
+// middleware at a specific point in the pipeline.
+//
+// A stage may have a nil middleware, which keeps its position available for
+// others to be inserted around, but contributes nothing to the handler.
+type Chain struct {
+	stages []chainStage
+}
+
+func NewChain() *Chain {
+	return &Chain{}
+}
+
+// Use appends a stage, making it the innermost one.
+func (c *Chain) Use(name string, middleware Middleware) error {
+	if c.index(name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrStageExists, name)
+	}
+
+	c.stages = append(c.stages, chainStage{name, middleware})
+	return nil
+}
+
+// InsertBefore adds a stage that sees requests before the existing stage.
+func (c *Chain) InsertBefore(existing, name string, middleware Middleware) error {
+	return c.insert(existing, 0, name, middleware)
+}
+
+// InsertAfter adds a stage that sees requests after the existing stage.
+func (c *Chain) InsertAfter(existing, name string, middleware Middleware) error {
+	return c.insert(existing, 1, name, middleware)
+}
+
+// Replace swaps the middleware used for an existing stage.
+func (c *Chain) Replace(name string, middleware Middleware) error {
+	index := c.index(name)
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrStageNotFound, name)
+	}
+
+	c.stages[index].middleware = middleware
+	return nil
+}
+
+func (c *Chain) Remove(name string) error {
+	index := c.index(name)
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrStageNotFound, name)
+	}
+
+	c.stages = slices.Delete(c.stages, index, index+1)
+	return nil
+}
+
+// Names lists the stages in order, including those without middleware.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.stages))
+	for i, stage := range c.stages {
+		names[i] = stage.name
+	}
+	return names
+}
+
+// Then builds the chain around the given handler.
+func (c *Chain) Then(handler http.Handler) http.Handler {
+	for i := len(c.stages) - 1; i >= 0; i-- {
+		if c.stages[i].middleware != nil {
+			handler = c.stages[i].middleware(handler)
+		}
+	}
+	return handler
+}
+
+// Private
+
+func (c *Chain) insert(existing string, offset int, name string, middleware Middleware) error {
+	if c.index(name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrStageExists, name)
+	}
+
+	index := c.index(existing)
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrStageNotFound, existing)
+	}
+
+	c.stages = slices.Insert(c.stages, index+offset, chainStage{name, middleware})
+	return nil
+}
+
+func (c *Chain) index(name string) int {
+	return slices.IndexFunc(c.stages, func(stage chainStage) bool {
+		return stage.name == name
+	})
+}
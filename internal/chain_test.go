@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain_builds_stages_in_order(t *testing.T) {
+	calls := []string{}
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	c := NewChain()
+	require.NoError(t, c.Use("first", record("first")))
+	require.NoError(t, c.Use("disabled", nil))
+	require.NoError(t, c.Use("last", record("last")))
+	require.NoError(t, c.InsertBefore("first", "before-first", record("before-first")))
+	require.NoError(t, c.InsertAfter("disabled", "after-disabled", record("after-disabled")))
+
+	assert.Equal(t, []string{"before-first", "first", "disabled", "after-disabled", "last"}, c.Names())
+
+	handler := c.Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, []string{"before-first", "first", "after-disabled", "last", "handler"}, calls)
+}
+
+func TestChain_errors(t *testing.T) {
+	c := NewChain()
+	require.NoError(t, c.Use("a", nil))
+
+	assert.ErrorIs(t, c.Use("a", nil), ErrStageExists)
+	assert.ErrorIs(t, c.InsertBefore("a", "a", nil), ErrStageExists)
+	assert.ErrorIs(t, c.InsertAfter("missing", "b", nil), ErrStageNotFound)
+	assert.ErrorIs(t, c.Replace("missing", nil), ErrStageNotFound)
+	assert.ErrorIs(t, c.Remove("missing"), ErrStageNotFound)
+
+	require.NoError(t, c.Remove("a"))
+	assert.Empty(t, c.Names())
+}
@@ -0,0 +1,417 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Where challenge pages submit their solutions
+	challengePath = "/.thruster/challenge"
+
+	challengeCookie = "_thruster_challenge"
+
+	// How long a client has to solve a challenge once it's been given one
+	challengeTimeout = 10 * time.Minute
+
+	challengeMaxFormSize   = 64 * KB
+	challengeVerifyTimeout = 10 * time.Second
+
+	// Leading zero bits of SHA-256 that solving a challenge takes. Each bit
+	// doubles the work.
+	challengeMinDifficulty = 1
+	challengeMaxDifficulty = 32
+
+	// How much of the client's address its tokens and cookies are bound to,
+	// so they survive it moving around its network but not being handed on
+	challengeIPv4PrefixBits = 24
+	challengeIPv6PrefixBits = 64
+)
+
+// Values of the challenge tag, for what became of a challenged request.
+const (
+	ChallengeIssued = "issued"
+	ChallengePassed = "passed"
+	ChallengeSolved = "solved"
+	ChallengeFailed = "failed"
+)
+
+// The form fields that CAPTCHA widgets put their responses in, for Cloudflare
+// Turnstile, hCaptcha and reCAPTCHA, along with one of our own for anything
+// else.
+var challengeCaptchaFields = []string{"captcha_response", "cf-turnstile-response", "h-captcha-response", "g-recaptcha-response"}
+
+var (
+	ErrInvalidChallengeDifficulty = errors.New("challenge difficulty must be between 1 and 32")
+	ErrInvalidChallengeCaptchaURL = errors.New("CAPTCHA verification URL must be an http or https URL")
+	ErrChallengeCaptchaSecret     = errors.New("CAPTCHA verification needs the secret to verify with")
+	ErrChallengeCaptchaPage       = errors.New("CAPTCHA verification needs a challenge page to show the CAPTCHA")
+	ErrChallengeCountryListed     = errors.New("is also allowed or blocked outright")
+)
+
+//go:embed challenge.html
+var challengePage string
+
+// ChallengeData is what a challenge page submits, as hidden fields, to have
+// its solution checked: a form posted to Action with the Token, the Return
+// path to go back to, and either the `nonce` that solves the proof of work, or
+// the response of a CAPTCHA widget when Captcha is set.
+type ChallengeData struct {
+	Action     string
+	Token      string
+	Return     string
+	Difficulty int
+	Captcha    bool
+}
+
+// Challenge puts clients in front of an interstitial page rather than
+// refusing them outright. By default the page has the browser do a proof of
+// work, finding a nonce that gives the SHA-256 of the token and nonce enough
+// leading zero bits, which costs a person a moment but makes large numbers of
+// requests expensive. With a CAPTCHA verification URL, the page is expected to
+// show a CAPTCHA widget instead, and its response is checked with the
+// provider.
+//
+// A client that passes is given a cookie, signed with the secret, that lets
+// it through until it expires. Without a secret, a random one is used, so the
+// cookies only last as long as the process.
+//
+// Tokens and cookies are both bound to the client's network and user agent,
+// so neither a solution nor a cookie can be shared with other clients. Each
+// token can only be solved once, too; the tokens already used are kept in
+// memory until they expire.
+type Challenge struct {
+	secret        []byte
+	difficulty    int
+	duration      time.Duration
+	cookieScope   *CookieScope
+	page          *PageTemplate
+	captchaURL    string
+	captchaSecret string
+	client        *http.Client
+	logger        *slog.Logger
+	now           func() time.Time
+
+	usedLock sync.Mutex
+	used     map[string]time.Time
+}
+
+// CheckChallengeDifficulty makes sure the difficulty is one that a browser can
+// manage, and that we can check.
+func CheckChallengeDifficulty(difficulty int) error {
+	if difficulty < challengeMinDifficulty || difficulty > challengeMaxDifficulty {
+		return ErrInvalidChallengeDifficulty
+	}
+	return nil
+}
+
+// CheckChallengeCountries makes sure that none of the countries challenged is
+// also allowed or blocked outright, which would leave it unclear which applies.
+func CheckChallengeCountries(challenge, allow, block []string) error {
+	for _, country := range challenge {
+		listed := func(other string) bool { return strings.EqualFold(other, country) }
+		if slices.ContainsFunc(allow, listed) || slices.ContainsFunc(block, listed) {
+			return fmt.Errorf("%s %w", country, ErrChallengeCountryListed)
+		}
+	}
+	return nil
+}
+
+// CheckChallengeCaptcha makes sure that, when CAPTCHA verification is used,
+// it has what it needs: a verification URL, its secret, and a page of our own
+// to show the CAPTCHA widget on, as the built-in page can't.
+func CheckChallengeCaptcha(verifyURL, secret, page string) error {
+	if verifyURL == "" {
+		return nil
+	}
+
+	parsed, err := url.Parse(verifyURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrInvalidChallengeCaptchaURL
+	}
+	if secret == "" {
+		return ErrChallengeCaptchaSecret
+	}
+	if _, err := os.Stat(page); err != nil {
+		return fmt.Errorf("%w: %w", ErrChallengeCaptchaPage, err)
+	}
+
+	return nil
+}
+
+func NewChallenge(secret string, difficulty int, duration time.Duration, pages *Pages, cookieScope *CookieScope) *Challenge {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	return &Challenge{
+		secret:      key,
+		difficulty:  difficulty,
+		duration:    duration,
+		cookieScope: cookieScope,
+		page:        pages.parse("challenge.html", challengePage),
+		client:      &http.Client{Timeout: challengeVerifyTimeout},
+		logger:      slog.Default(),
+		now:         time.Now,
+		used:        map[string]time.Time{},
+	}
+}
+
+// SetPage sets the page served to challenged clients, in place of our own
+// proof of work page.
+func (c *Challenge) SetPage(page *PageTemplate) {
+	if page != nil {
+		c.page = page
+	}
+}
+
+// SetCaptcha has solutions checked by posting the CAPTCHA response to the
+// provider's verification URL, as Turnstile, hCaptcha and reCAPTCHA accept,
+// in place of checking a proof of work.
+func (c *Challenge) SetCaptcha(verifyURL, secret string) {
+	c.captchaURL = verifyURL
+	c.captchaSecret = secret
+}
+
+// Passed reports whether the client has a valid cookie from solving a
+// challenge.
+func (c *Challenge) Passed(r *http.Request) bool {
+	cookie, err := r.Cookie(challengeCookie)
+	if err != nil {
+		return false
+	}
+
+	expiry, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !c.unexpired(expiry) {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(c.sign("challenge-pass", expiry+"."+challengeClient(r))))
+}
+
+// Issue serves the challenge page, with a new token, in response to the
+// request.
+func (c *Challenge) Issue(w http.ResponseWriter, r *http.Request) {
+	RequestTagsFromContext(r.Context()).Set(TagChallenge, ChallengeIssued)
+	c.issue(w, r, r.URL.RequestURI())
+}
+
+// Verify checks a solution submitted by a challenge page, giving the client a
+// cookie and sending it back to where it was going when the solution is
+// right, and a new challenge when it isn't.
+func (c *Challenge) Verify(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, challengeMaxFormSize)
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	returnTo := challengeReturnPath(r.PostForm.Get("return"))
+	tags := RequestTagsFromContext(r.Context())
+	host, _ := clientIP(r)
+
+	if !c.solved(r, host) {
+		c.logger.InfoContext(r.Context(), "Challenge failed", "ip", host)
+		tags.Set(TagChallenge, ChallengeFailed)
+		c.issue(w, r, returnTo)
+		return
+	}
+
+	c.logger.InfoContext(r.Context(), "Challenge solved", "ip", host)
+	tags.Set(TagChallenge, ChallengeSolved)
+	c.issueCookie(w, r)
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// Private
+
+func (c *Challenge) issue(w http.ResponseWriter, r *http.Request, returnTo string) {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	expiry := strconv.FormatInt(c.now().Add(challengeTimeout).Unix(), 10)
+	payload := expiry + "." + hex.EncodeToString(nonce)
+
+	data := c.page.pages.data(r, http.StatusForbidden)
+	data.Challenge = ChallengeData{
+		Action:     challengePath,
+		Token:      payload + "." + c.sign("challenge-token", payload+"."+challengeClient(r)),
+		Return:     returnTo,
+		Difficulty: c.difficulty,
+		Captcha:    c.captchaURL != "",
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	c.page.render(w, data)
+}
+
+func (c *Challenge) solved(r *http.Request, host string) bool {
+	token := r.PostForm.Get("token")
+
+	expiry, rest, _ := strings.Cut(token, ".")
+	nonce, signature, _ := strings.Cut(rest, ".")
+	if !c.unexpired(expiry) || !hmac.Equal([]byte(signature), []byte(c.sign("challenge-token", expiry+"."+nonce+"."+challengeClient(r)))) {
+		return false
+	}
+
+	if !c.answered(r, token, host) {
+		return false
+	}
+
+	return c.redeem(nonce, expiry)
+}
+
+func (c *Challenge) answered(r *http.Request, token, host string) bool {
+	if c.captchaURL != "" {
+		for _, field := range challengeCaptchaFields {
+			if response := r.PostForm.Get(field); response != "" {
+				return c.verifyCaptcha(r.Context(), response, host)
+			}
+		}
+		return false
+	}
+
+	return challengeWorkDone(token, r.PostForm.Get("nonce"), c.difficulty)
+}
+
+// redeem marks the token with the nonce as used, reporting whether it hadn't
+// been already.
+func (c *Challenge) redeem(nonce, expiry string) bool {
+	expires, _ := strconv.ParseInt(expiry, 10, 64)
+
+	c.usedLock.Lock()
+	defer c.usedLock.Unlock()
+
+	now := c.now()
+	maps.DeleteFunc(c.used, func(_ string, expires time.Time) bool {
+		return !now.Before(expires)
+	})
+
+	if _, ok := c.used[nonce]; ok {
+		return false
+	}
+	c.used[nonce] = time.Unix(expires, 0)
+	return true
+}
+
+func (c *Challenge) verifyCaptcha(ctx context.Context, response, remoteIP string) bool {
+	form := url.Values{"secret": {c.captchaSecret}, "response": {response}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.captchaURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Warn("Unable to verify CAPTCHA", "error", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, challengeMaxFormSize)).Decode(&result)
+	if err != nil {
+		c.logger.Warn("Unable to verify CAPTCHA", "status", resp.StatusCode, "error", err)
+		return false
+	}
+
+	return result.Success
+}
+
+func (c *Challenge) issueCookie(w http.ResponseWriter, r *http.Request) {
+	expires := c.now().Add(c.duration)
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+
+	cookie := &http.Cookie{
+		Name:     challengeCookie,
+		Value:    expiry + "." + c.sign("challenge-pass", expiry+"."+challengeClient(r)),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(c.duration.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if c.cookieScope != nil {
+		c.cookieScope.Apply(r, cookie)
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+func (c *Challenge) unexpired(expiry string) bool {
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	return err == nil && c.now().Unix() < expires
+}
+
+func (c *Challenge) sign(purpose, value string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(purpose + "." + value))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// challengeWorkDone reports whether the nonce solves the proof of work for the
+// token.
+func challengeWorkDone(token, nonce string, difficulty int) bool {
+	if len(nonce) == 0 || len(nonce) > 20 || strings.Trim(nonce, "0123456789") != "" {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(token + nonce))
+	return bits.LeadingZeros32(binary.BigEndian.Uint32(digest[:4])) >= difficulty
+}
+
+// challengeClient is what tokens and cookies are bound to: the client's
+// network and its user agent.
+func challengeClient(r *http.Request) string {
+	host, ip := clientIP(r)
+	if ip4 := ip.To4(); ip4 != nil {
+		host = ip4.Mask(net.CIDRMask(challengeIPv4PrefixBits, 32)).String()
+	} else if ip != nil {
+		host = ip.Mask(net.CIDRMask(challengeIPv6PrefixBits, 128)).String()
+	}
+
+	return host + " " + r.UserAgent()
+}
+
+// challengeReturnPath keeps the path a challenge returns to on this site.
+func challengeReturnPath(value string) string {
+	if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") || strings.HasPrefix(value, "/\\") {
+		return "/"
+	}
+	return value
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckChallengeDifficulty(t *testing.T) {
+	assert.NoError(t, CheckChallengeDifficulty(1))
+	assert.NoError(t, CheckChallengeDifficulty(32))
+	assert.ErrorIs(t, CheckChallengeDifficulty(0), ErrInvalidChallengeDifficulty)
+	assert.ErrorIs(t, CheckChallengeDifficulty(33), ErrInvalidChallengeDifficulty)
+}
+
+func TestCheckChallengeCountries(t *testing.T) {
+	assert.NoError(t, CheckChallengeCountries([]string{"IN"}, []string{"GB"}, nil))
+	assert.ErrorIs(t, CheckChallengeCountries([]string{"IN"}, []string{"in"}, nil), ErrChallengeCountryListed)
+	assert.ErrorIs(t, CheckChallengeCountries([]string{"IN"}, nil, []string{"IN"}), ErrChallengeCountryListed)
+}
+
+func TestCheckChallengeCaptcha(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "challenge.html")
+	require.NoError(t, os.WriteFile(page, nil, 0o644))
+
+	assert.NoError(t, CheckChallengeCaptcha("", "", ""))
+	assert.NoError(t, CheckChallengeCaptcha("https://hcaptcha.com/siteverify", "secret", page))
+	assert.ErrorIs(t, CheckChallengeCaptcha("hcaptcha.com/siteverify", "secret", page), ErrInvalidChallengeCaptchaURL)
+	assert.ErrorIs(t, CheckChallengeCaptcha("https://hcaptcha.com/siteverify", "", page), ErrChallengeCaptchaSecret)
+	assert.ErrorIs(t, CheckChallengeCaptcha("https://hcaptcha.com/siteverify", "secret", page+".missing"), ErrChallengeCaptchaPage)
+}
+
+func TestChallenge_proof_of_work(t *testing.T) {
+	challenge := NewChallenge("s3cret", 8, time.Hour, nil, NewCookieScope(CookieScopeRegistrable, nil))
+
+	r, tags := WithRequestTags(httptest.NewRequest("GET", "http://www.example.com/reports?year=2026", nil))
+	w := httptest.NewRecorder()
+	challenge.Issue(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Equal(t, ChallengeIssued, tags.Get(TagChallenge))
+	assert.Contains(t, w.Body.String(), `action="/.thruster/challenge"`)
+	assert.Regexp(t, `var difficulty = +8 *;`, w.Body.String())
+
+	token := challengePageField(t, w.Body.String(), "token")
+	assert.Equal(t, "/reports?year=2026", challengePageField(t, w.Body.String(), "return"))
+
+	t.Run("with the work done", func(t *testing.T) {
+		w, tags := submitChallenge(challenge, url.Values{"token": {token}, "nonce": {solveChallenge(token, 8)}, "return": {"/reports?year=2026"}})
+
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/reports?year=2026", w.Header().Get("Location"))
+		assert.Equal(t, ChallengeSolved, tags.Get(TagChallenge))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "_thruster_challenge", cookies[0].Name)
+		assert.Equal(t, "example.com", cookies[0].Domain)
+		assert.Equal(t, 3600, cookies[0].MaxAge)
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookies[0])
+		assert.True(t, challenge.Passed(r))
+
+		other := NewChallenge("other", 8, time.Hour, nil, nil)
+		assert.False(t, other.Passed(r))
+
+		challenge.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		defer func() { challenge.now = time.Now }()
+		assert.False(t, challenge.Passed(r))
+	})
+
+	t.Run("without the work done", func(t *testing.T) {
+		nonce, _ := strconv.Atoi(solveChallenge(token, 8))
+
+		for _, values := range []url.Values{
+			{"token": {token}, "nonce": {strconv.Itoa(nonce + 1)}},
+			{"token": {token}, "nonce": {""}},
+			{"token": {token + "x"}, "nonce": {strconv.Itoa(nonce)}},
+		} {
+			w, tags := submitChallenge(challenge, values)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Equal(t, ChallengeFailed, tags.Get(TagChallenge))
+			assert.Empty(t, w.Result().Cookies())
+		}
+	})
+
+	t.Run("after the token expires", func(t *testing.T) {
+		challenge.now = func() time.Time { return time.Now().Add(time.Hour) }
+		defer func() { challenge.now = time.Now }()
+
+		w, _ := submitChallenge(challenge, url.Values{"token": {token}, "nonce": {solveChallenge(token, 8)}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestChallenge_captcha(t *testing.T) {
+	var verified url.Values
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verified = r.PostForm
+		w.Write([]byte(`{"success": ` + strconv.FormatBool(r.PostForm.Get("response") == "good") + `}`))
+	}))
+	defer provider.Close()
+
+	challenge := NewChallenge("s3cret", 8, time.Hour, nil, nil)
+	challenge.SetCaptcha(provider.URL, "captcha-secret")
+
+	w := httptest.NewRecorder()
+	challenge.Issue(w, httptest.NewRequest("GET", "/", nil))
+	token := challengePageField(t, w.Body.String(), "token")
+
+	w, _ = submitChallenge(challenge, url.Values{"token": {token}, "h-captcha-response": {"bad"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The work alone isn't enough when a CAPTCHA is expected
+	w, _ = submitChallenge(challenge, url.Values{"token": {token}, "nonce": {solveChallenge(token, 8)}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, _ = submitChallenge(challenge, url.Values{"token": {token}, "cf-turnstile-response": {"good"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "captcha-secret", verified.Get("secret"))
+	assert.Equal(t, "192.0.2.1", verified.Get("remoteip"))
+}
+
+func TestChallenge_tokens_and_cookies_are_bound_to_the_client(t *testing.T) {
+	challenge := NewChallenge("s3cret", 8, time.Hour, nil, nil)
+
+	request := func(method, address, userAgent string, values url.Values) *http.Request {
+		r, _ := WithRequestTags(httptest.NewRequest(method, "/", strings.NewReader(values.Encode())))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("User-Agent", userAgent)
+		r.RemoteAddr = address + ":12345"
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	challenge.Issue(w, request("GET", "198.51.100.7", "Firefox", nil))
+	token := challengePageField(t, w.Body.String(), "token")
+	solution := url.Values{"token": {token}, "nonce": {solveChallenge(token, 8)}}
+
+	verify := func(address, userAgent string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		challenge.Verify(w, request("POST", address, userAgent, solution))
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, verify("203.0.113.7", "Firefox").Code, "another network")
+	assert.Equal(t, http.StatusForbidden, verify("198.51.100.7", "curl").Code, "another user agent")
+
+	w = verify("198.51.100.99", "Firefox")
+	assert.Equal(t, http.StatusSeeOther, w.Code, "the same network")
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	assert.Equal(t, http.StatusForbidden, verify("198.51.100.7", "Firefox").Code, "a token can only be used once")
+
+	passed := func(address, userAgent string) bool {
+		r := request("GET", address, userAgent, nil)
+		r.AddCookie(cookies[0])
+		return challenge.Passed(r)
+	}
+
+	assert.True(t, passed("198.51.100.7", "Firefox"))
+	assert.False(t, passed("203.0.113.7", "Firefox"))
+	assert.False(t, passed("198.51.100.7", "curl"))
+
+	// Used tokens are forgotten once they've expired
+	challenge.now = func() time.Time { return time.Now().Add(time.Hour) }
+	defer func() { challenge.now = time.Now }()
+	assert.True(t, challenge.redeem("0123456789abcdef", strconv.FormatInt(time.Now().Add(2*time.Hour).Unix(), 10)))
+	assert.Len(t, challenge.used, 1)
+}
+
+func TestChallenge_custom_page(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "challenge.html")
+	require.NoError(t, os.WriteFile(path, []byte(`{{.Country}} {{.Challenge.Action}} {{.Challenge.Captcha}}`), 0o644))
+
+	challenge := NewChallenge("s3cret", 8, time.Hour, nil, nil)
+	challenge.SetPage(NewPages("").LoadIfExists(path))
+	challenge.SetCaptcha("https://hcaptcha.com/siteverify", "secret")
+
+	r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+	tags.Set(TagCountry, "BR")
+	w := httptest.NewRecorder()
+	challenge.Issue(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "BR /.thruster/challenge true", w.Body.String())
+}
+
+func TestChallengeReturnPath(t *testing.T) {
+	tests := map[string]string{
+		"/reports?year=2026":    "/reports?year=2026",
+		"":                      "/",
+		"https://evil.example/": "/",
+		"//evil.example/":       "/",
+		"/\\evil.example/":      "/",
+	}
+
+	for value, expected := range tests {
+		assert.Equal(t, expected, challengeReturnPath(value), value)
+	}
+}
+
+func TestGeoIPMiddleware_challenge(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	challenge := NewChallenge("s3cret", 8, time.Hour, nil, nil)
+	middleware := NewGeoIPMiddleware(resolver, slog.Default(), next, nil, nil, BlockPolicies{})
+	middleware.SetChallenge(challenge, []string{"gb"})
+
+	serve := func(method, target string, body url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		var r *http.Request
+		if body != nil {
+			r = httptest.NewRequest(method, target, strings.NewReader(body.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(method, target, nil)
+		}
+		r.RemoteAddr = "81.2.69.142:12345" // GB
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("GET", "/account", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	token := challengePageField(t, w.Body.String(), "token")
+
+	assert.Equal(t, http.StatusOK, serve("OPTIONS", "/account", nil).Code)
+
+	w = serve("POST", "/.thruster/challenge", url.Values{"token": {token}, "nonce": {solveChallenge(token, 8)}, "return": {"/account"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	w = serve("GET", "/account", nil, cookies...)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+
+	// Other countries aren't challenged
+	r := httptest.NewRequest("GET", "/account", nil)
+	r.RemoteAddr = "216.160.83.56:12345" // US
+	w = httptest.NewRecorder()
+	middleware.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// Helpers
+
+var challengePageFieldPattern = regexp.MustCompile(`name="(\w+)" value="([^"]*)"`)
+
+func challengePageField(t *testing.T, page, name string) string {
+	t.Helper()
+
+	for _, match := range challengePageFieldPattern.FindAllStringSubmatch(page, -1) {
+		if match[1] == name {
+			value, err := url.QueryUnescape(strings.ReplaceAll(match[2], "&#43;", "+"))
+			require.NoError(t, err)
+			return strings.ReplaceAll(value, "&amp;", "&")
+		}
+	}
+
+	t.Fatalf("no %s field in the challenge page", name)
+	return ""
+}
+
+func submitChallenge(challenge *Challenge, values url.Values) (*httptest.ResponseRecorder, *RequestTags) {
+	r, tags := WithRequestTags(httptest.NewRequest("POST", "/.thruster/challenge", strings.NewReader(values.Encode())))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	challenge.Verify(w, r)
+	return w, tags
+}
+
+func solveChallenge(token string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		if challengeWorkDone(token, strconv.Itoa(nonce), difficulty) {
+			return strconv.Itoa(nonce)
+		}
+	}
+}
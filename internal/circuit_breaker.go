@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops us waiting on an origin that is down. After a run of
+// consecutive failures it opens, failing requests immediately. Once the
+// cooldown has passed it lets a single probe request through (half-open);
+// if that succeeds the circuit closes again, otherwise it re-opens.
+//
+// Failures are connection errors and 5xx responses. Each upstream in a pool
+// has a breaker of its own, so that one failing upstream doesn't stop
+// requests to the others.
+//
+// A nil *CircuitBreaker allows everything.
+type CircuitBreaker struct {
+	sync.Mutex
+	threshold      int
+	cooldown       time.Duration
+	state          circuitState
+	failures       int
+	openedAt       time.Time
+	probing        bool
+	upstream       string
+	events         *EventDispatcher
+	getCurrentTime GetCurrentTime
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:      threshold,
+		cooldown:       cooldown,
+		getCurrentTime: time.Now,
+	}
+}
+
+// SetEvents publishes an event whenever the circuit opens or closes again.
+func (b *CircuitBreaker) SetEvents(events *EventDispatcher) {
+	b.events = events
+}
+
+// Available reports whether Allow would let a request through now, without
+// counting as one.
+func (b *CircuitBreaker) Available() bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		return b.getCurrentTime().Sub(b.openedAt) >= b.cooldown
+	case circuitHalfOpen:
+		return !b.probing
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent. Every allowed request must be
+// followed by a call to one of Success, Failure, or Abandon; otherwise a
+// half-open circuit stays waiting on its probe.
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.getCurrentTime().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	if b.state != circuitClosed {
+		b.setState(circuitClosed)
+	}
+}
+
+func (b *CircuitBreaker) Failure() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+		b.openedAt = b.getCurrentTime()
+		b.setState(circuitOpen)
+	}
+}
+
+// Abandon accounts for an allowed request whose outcome tells us nothing
+// about the origin, such as one cancelled by the client.
+func (b *CircuitBreaker) Abandon() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.probing = false
+}
+
+// Private
+
+func (b *CircuitBreaker) setState(state circuitState) {
+	level := slog.LevelInfo
+	if state == circuitOpen {
+		level = slog.LevelWarn
+	}
+
+	slog.Log(context.Background(), level, "Circuit breaker changed state", "upstream", b.upstream, "from", b.state.String(), "to", state.String(), "failures", b.failures)
+
+	switch state {
+	case circuitOpen:
+		b.events.Publish(EventCircuitOpened, map[string]any{"upstream": b.upstream, "failures": b.failures, "cooldown": b.cooldown.Seconds()})
+	case circuitClosed:
+		b.events.Publish(EventCircuitClosed, map[string]any{"upstream": b.upstream})
+	}
+
+	b.state = state
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, 10*time.Second)
+	breaker.getCurrentTime = func() time.Time { return now }
+
+	assert.True(t, breaker.Allow())
+	breaker.Failure()
+	assert.True(t, breaker.Allow())
+	breaker.Failure()
+
+	assert.False(t, breaker.Allow(), "opens after consecutive failures")
+
+	now = now.Add(10 * time.Second)
+	assert.True(t, breaker.Allow(), "lets a probe through after the cooldown")
+	assert.False(t, breaker.Allow(), "only one probe at a time")
+	breaker.Failure()
+
+	assert.False(t, breaker.Allow(), "re-opens when the probe fails")
+
+	now = now.Add(10 * time.Second)
+	assert.True(t, breaker.Allow())
+	breaker.Success()
+
+	assert.True(t, breaker.Allow(), "closes when the probe succeeds")
+	assert.True(t, breaker.Allow())
+}
+
+func TestCircuitBreaker_successes_reset_the_failure_count(t *testing.T) {
+	breaker := NewCircuitBreaker(2, time.Minute)
+
+	breaker.Failure()
+	breaker.Success()
+	breaker.Failure()
+
+	assert.True(t, breaker.Allow())
+}
+
+func TestCircuitBreaker_abandoned_probes_can_be_retried(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Second)
+	breaker.getCurrentTime = func() time.Time { return now }
+
+	breaker.Failure()
+	now = now.Add(time.Second)
+
+	assert.True(t, breaker.Allow())
+	breaker.Abandon()
+	assert.True(t, breaker.Allow())
+}
+
+func TestCircuitBreaker_publishes_events(t *testing.T) {
+	now := time.Now()
+	events := NewEventDispatcher(nil, nil, "", 100, 0)
+	breaker := NewCircuitBreaker(1, time.Second)
+	breaker.getCurrentTime = func() time.Time { return now }
+	breaker.SetEvents(events)
+
+	breaker.Failure()
+	now = now.Add(time.Second)
+	breaker.Allow()
+	breaker.Success()
+
+	assert.Equal(t, EventCircuitOpened, (<-events.queue).Type)
+	assert.Equal(t, EventCircuitClosed, (<-events.queue).Type)
+	assert.Empty(t, events.queue)
+}
+
+func TestCircuitBreaker_nil_allows_everything(t *testing.T) {
+	var breaker *CircuitBreaker
+	breaker.Failure()
+
+	assert.True(t, breaker.Allow())
+}
+
+func TestProxyHandler_fails_fast_when_circuit_is_open(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	targetUrl, _ := url.Parse(upstream.URL)
+	upstream.Close()
+
+	pool := NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin)
+	pool.SetCircuitBreakers(1, time.Minute, nil)
+	h := newTestProxyHandler(pool, TargetProtocolHTTP1, UpstreamTimeouts{}, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	assert.False(t, pool.Upstreams()[0].CircuitBreaker().Allow())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestProxyHandler_counts_server_errors_as_failures(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+	targetUrl, _ := url.Parse(upstream.URL)
+
+	pool := NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin)
+	pool.SetCircuitBreakers(2, time.Minute, nil)
+	h := newTestProxyHandler(pool, TargetProtocolHTTP1, UpstreamTimeouts{}, nil)
+
+	codes := []int{}
+	for range 3 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		codes = append(codes, w.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusServiceUnavailable}, codes)
+}
+
+func TestProxyHandler_resolves_a_probe_that_panics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	targetUrl, _ := url.Parse(upstream.URL)
+
+	now := time.Now()
+	pool := NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin)
+	pool.SetCircuitBreakers(1, time.Second, nil)
+	breaker := pool.Upstreams()[0].CircuitBreaker()
+	breaker.getCurrentTime = func() time.Time { return now }
+	breaker.Failure()
+	now = now.Add(time.Second)
+
+	h := newTestProxyHandler(pool, TargetProtocolHTTP1, UpstreamTimeouts{}, nil)
+	h.proxy.Transport = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		panic(http.ErrAbortHandler)
+	})
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+
+	assert.True(t, breaker.Available(), "the probe is given up, so another can be sent")
+}
+
+func TestProxyHandler_opens_circuits_per_upstream(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer working.Close()
+
+	failingUrl, _ := url.Parse(failing.URL)
+	workingUrl, _ := url.Parse(working.URL)
+
+	pool := NewUpstreamPool([]*url.URL{failingUrl, workingUrl}, BalancingRoundRobin)
+	pool.SetCircuitBreakers(1, time.Minute, nil)
+	h := newTestProxyHandler(pool, TargetProtocolHTTP1, UpstreamTimeouts{}, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	for range 4 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "requests go to the upstream that's still working")
+	}
+
+	assert.False(t, pool.Upstreams()[0].CircuitBreaker().Available())
+	assert.True(t, pool.Upstreams()[1].CircuitBreaker().Available())
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultTrustedProxies are the loopback and private networks, where the
+// proxies in front of us usually are.
+var DefaultTrustedProxies = []string{
+	"127.0.0.0/8", "::1/128",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+}
+
+type clientAddressKey struct{}
+
+type clientAddress struct {
+	host string
+	ip   net.IP
+}
+
+// TrustedProxies are the networks of the proxies in front of us. Only they
+// are believed when they tell us, in X-Forwarded-For, who the client is.
+//
+// A nil *TrustedProxies trusts no one.
+type TrustedProxies struct {
+	networks *NetworkSet
+}
+
+func NewTrustedProxies(networks []*net.IPNet) *TrustedProxies {
+	return &TrustedProxies{networks: NewNetworkSet(map[string][]*net.IPNet{"trusted": networks})}
+}
+
+// ClientAddress finds the address of the client that made the request. That's
+// the peer's, unless the peer is a trusted proxy, in which case it's the
+// rightmost address in X-Forwarded-For that isn't one. Each proxy appends the
+// address it received the request from, so anything to the left of that was
+// written by the client, and could be anything.
+func (p *TrustedProxies) ClientAddress(r *http.Request) (string, net.IP) {
+	host, ip := peerAddress(r)
+	if p == nil {
+		return host, ip
+	}
+
+	hops := forwardedForHops(r.Header.Values("X-Forwarded-For"))
+	for i := len(hops) - 1; i >= 0 && ip != nil && p.networks.Contains(ip); i-- {
+		hopIP := net.ParseIP(hops[i])
+		if hopIP == nil {
+			// We can't tell who sent the request to the proxy, so the proxy is
+			// as close as we can get
+			break
+		}
+		host, ip = hops[i], hopIP
+	}
+
+	return host, ip
+}
+
+// Trusts reports whether the request came straight from a trusted proxy, so
+// that what it says about the request it received can be believed.
+func (p *TrustedProxies) Trusts(r *http.Request) bool {
+	_, ip := peerAddress(r)
+	return p != nil && ip != nil && p.networks.Contains(ip)
+}
+
+// ClientAddressMiddleware works out the client's address once, for every
+// later stage to use.
+type ClientAddressMiddleware struct {
+	proxies *TrustedProxies
+	next    http.Handler
+}
+
+func NewClientAddressMiddleware(proxies *TrustedProxies, next http.Handler) *ClientAddressMiddleware {
+	return &ClientAddressMiddleware{proxies: proxies, next: next}
+}
+
+func (m *ClientAddressMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, ip := m.proxies.ClientAddress(r)
+	ctx := context.WithValue(r.Context(), clientAddressKey{}, clientAddress{host: host, ip: ip})
+	m.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Private
+
+// clientIP returns the address of the client that made the request, as found
+// by the ClientAddressMiddleware. Without one, it's the peer's address, since
+// there's no knowing whether X-Forwarded-For can be believed.
+func clientIP(r *http.Request) (string, net.IP) {
+	if address, ok := r.Context().Value(clientAddressKey{}).(clientAddress); ok {
+		return address.host, address.ip
+	}
+	return peerAddress(r)
+}
+
+func peerAddress(r *http.Request) (string, net.IP) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr // Assume no port was present
+	}
+
+	return host, net.ParseIP(host)
+}
+
+func forwardedForHops(values []string) []string {
+	hops := []string{}
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
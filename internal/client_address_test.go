@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxies_ClientAddress(t *testing.T) {
+	networks, err := ParseCIDRs(DefaultTrustedProxies)
+	require.NoError(t, err)
+	proxies := NewTrustedProxies(networks)
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  []string
+		expectedHost  string
+		expectedValid bool
+	}{
+		{"no header", "203.0.113.7:1234", nil, "203.0.113.7", true},
+		{"header from an untrusted peer", "203.0.113.7:1234", []string{"127.0.0.1"}, "203.0.113.7", true},
+		{"header from a trusted proxy", "10.0.0.2:1234", []string{"203.0.113.7"}, "203.0.113.7", true},
+		{"rightmost untrusted hop", "10.0.0.2:1234", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7", true},
+		{"through several proxies", "10.0.0.2:1234", []string{"203.0.113.7, 192.168.1.1", "10.0.0.3"}, "203.0.113.7", true},
+		{"spoofed local address", "10.0.0.2:1234", []string{"127.0.0.1, 203.0.113.7"}, "203.0.113.7", true},
+		{"unreadable hop", "10.0.0.2:1234", []string{"203.0.113.7, garbage"}, "10.0.0.2", true},
+		{"only trusted hops", "10.0.0.2:1234", []string{"127.0.0.1"}, "127.0.0.1", true},
+		{"no port", "203.0.113.7", nil, "203.0.113.7", true},
+		{"unreadable peer", "@", []string{"203.0.113.7"}, "@", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			host, ip := proxies.ClientAddress(r)
+			assert.Equal(t, tt.expectedHost, host)
+			assert.Equal(t, tt.expectedValid, ip != nil)
+		})
+	}
+}
+
+func TestTrustedProxies_ClientAddress_trusting_no_one(t *testing.T) {
+	var proxies *TrustedProxies
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	host, ip := proxies.ClientAddress(r)
+	assert.Equal(t, "10.0.0.2", host)
+	assert.Equal(t, net.ParseIP("10.0.0.2"), ip)
+}
+
+func TestClientAddressMiddleware(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	var host string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _ = clientIP(r)
+	})
+	middleware := NewClientAddressMiddleware(NewTrustedProxies(networks), next)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	middleware.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "203.0.113.7", host)
+
+	// Without the middleware, there's no knowing who to believe
+	host, _ = clientIP(r)
+	assert.Equal(t, "10.0.0.2", host)
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientFingerprintMiddleware(t *testing.T) {
+	var received string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Client-Fingerprint")
+	})
+	handler := NewClientFingerprintMiddleware("secret", nil, next)
+
+	r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+	tags.Set(TagCountry, "GB")
+	r.Header.Set("X-Client-Fingerprint", "v=1;geo=US;sig=forged")
+	r.Header.Set("Accept", "*/*")
+	r.Header.Set("User-Agent", "curl/8.0")
+
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	payload, signature, ok := strings.Cut(received, ";sig=")
+	require.True(t, ok)
+	assert.Equal(t, "v=1;geo=GB;asn=-;tls=-;hdr=20001", payload)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(payload))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16]), signature)
+}
+
+func TestClientFingerprintMiddleware_signature_depends_on_secret(t *testing.T) {
+	fingerprint := func(secret string) string {
+		var received string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Get("X-Client-Fingerprint")
+		})
+		NewClientFingerprintMiddleware(secret, nil, next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		return received
+	}
+
+	assert.NotEqual(t, fingerprint("one"), fingerprint("two"))
+	assert.Equal(t, fingerprint("one"), fingerprint("one"))
+}
+
+func TestClientFingerprintMiddleware_tags_tls_fingerprint(t *testing.T) {
+	fingerprints := NewTLSFingerprints()
+	fingerprints.fingerprints.Store("192.0.2.1:1234", "1a2b3c4d5e6f")
+
+	var tlsTag, received string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tlsTag = RequestTagsFromContext(r.Context()).Get(TagTLSFingerprint)
+		received = r.Header.Get("X-Client-Fingerprint")
+	})
+	handler := NewClientFingerprintMiddleware("secret", fingerprints, next)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "1a2b3c4d5e6f", tlsTag)
+	assert.Contains(t, received, ";tls=1a2b3c4d5e6f;")
+}
+
+func TestClientFingerprintValue(t *testing.T) {
+	assert.Equal(t, "-", clientFingerprintValue(""))
+	assert.Equal(t, "a_b_c", clientFingerprintValue("a;b=c"))
+}
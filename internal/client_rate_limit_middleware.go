@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+type ClientRateLimitMiddleware struct {
+	limiter     *RateLimiter
+	logger      *slog.Logger
+	next        http.Handler
+	limit       RateLimit
+	exemptCIDRs *NetworkSet
+}
+
+// NewClientRateLimitMiddleware limits each client with a bucket in limiter.
+// Middleware built with the same limiter share their buckets, so a client's
+// allowance isn't refilled by rebuilding the middleware for a new policy.
+func NewClientRateLimitMiddleware(logger *slog.Logger, next http.Handler, limit RateLimit, exemptCIDRs []*net.IPNet, limiter *RateLimiter) *ClientRateLimitMiddleware {
+	return &ClientRateLimitMiddleware{
+		limiter:     limiter,
+		logger:      logger,
+		next:        next,
+		limit:       limit,
+		exemptCIDRs: NewNetworkSet(map[string][]*net.IPNet{"exempt": exemptCIDRs}),
+	}
+}
+
+func (m *ClientRateLimitMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, ip := clientIP(r)
+	if ip == nil || m.isExempt(ip) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	allowed, wait := m.limiter.Allow(ip.String(), m.limit)
+	if !allowed {
+		m.logger.InfoContext(r.Context(), "Request rate limited - client over limit",
+			"ip", host, "path", r.URL.Path, "rate", m.limit.Rate, "burst", m.limit.Burst)
+		writeTooManyRequests(w, r, wait, "rate_limit")
+		return
+	}
+
+	m.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (m *ClientRateLimitMiddleware) isExempt(ip net.IP) bool {
+	return isLocalOrInternalIP(ip) || m.exemptCIDRs.Contains(ip)
+}
+
+// ParseCIDRs parses a list of CIDR blocks. Bare addresses are accepted and
+// treated as a single-host network.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func ipInNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
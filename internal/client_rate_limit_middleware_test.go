@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRateLimitMiddleware(t *testing.T) {
+	exempt, err := ParseCIDRs([]string{"203.0.113.0/24"})
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewClientRateLimitMiddleware(slog.Default(), next, RateLimit{Rate: 1, Burst: 2}, exempt, NewRateLimiter(nil))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("limits each client independently", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("8.8.8.8:1000").Code)
+		assert.Equal(t, http.StatusOK, request("8.8.8.8:1001").Code)
+
+		w := request("8.8.8.8:1002")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		assert.Equal(t, http.StatusOK, request("8.8.4.4:1000").Code)
+	})
+
+	t.Run("exempts internal addresses", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, request("10.0.0.1:1000").Code)
+		}
+	})
+
+	t.Run("ignores X-Forwarded-For from untrusted peers", func(t *testing.T) {
+		spoofed := func(forwardedFor string) int {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "198.51.100.1:1000"
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+			handler.ServeHTTP(w, r)
+			return w.Code
+		}
+
+		assert.Equal(t, http.StatusOK, spoofed("192.0.2.1"))
+		assert.Equal(t, http.StatusOK, spoofed("127.0.0.1"))
+		assert.Equal(t, http.StatusTooManyRequests, spoofed("192.0.2.1, 192.0.2.2"))
+	})
+
+	t.Run("exempts allow-listed networks", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, request("203.0.113.7:1000").Code)
+		}
+	})
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "1.2.3.4", "2001:db8::/32"})
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "1.2.3.4/32", networks[1].String())
+	assert.Equal(t, "2001:db8::/32", networks[2].String())
+
+	_, err = ParseCIDRs([]string{"not-a-network"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,418 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzhttp"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+type CompressionEncoding string
+
+const (
+	CompressionEncodingBrotli CompressionEncoding = "br"
+	CompressionEncodingZstd   CompressionEncoding = "zstd"
+	CompressionEncodingGzip   CompressionEncoding = "gzip"
+)
+
+type CompressionLevel string
+
+const (
+	CompressionLevelFastest CompressionLevel = "fastest"
+	CompressionLevelDefault CompressionLevel = "default"
+	CompressionLevelBest    CompressionLevel = "best"
+)
+
+const (
+	defaultCompressionMinSize = 1024
+
+	// Browsers won't decode zstd with a window any larger than this
+	compressionZstdWindowSize = 8 * MB
+)
+
+var (
+	defaultCompressionEncodings = []CompressionEncoding{CompressionEncodingBrotli, CompressionEncodingZstd, CompressionEncodingGzip}
+
+	ErrInvalidCompressionEncoding = errors.New("compression encoding must be br, zstd or gzip")
+	ErrInvalidCompressionLevel    = errors.New("compression level must be fastest, default or best")
+)
+
+func ParseCompressionEncodings(values []string) ([]CompressionEncoding, error) {
+	encodings := []CompressionEncoding{}
+	for _, value := range values {
+		encoding := CompressionEncoding(strings.ToLower(strings.TrimSpace(value)))
+
+		switch encoding {
+		case CompressionEncodingBrotli, CompressionEncodingZstd, CompressionEncodingGzip:
+			if !slices.Contains(encodings, encoding) {
+				encodings = append(encodings, encoding)
+			}
+		default:
+			return nil, ErrInvalidCompressionEncoding
+		}
+	}
+
+	return encodings, nil
+}
+
+func ParseCompressionLevel(value string) (CompressionLevel, error) {
+	level := CompressionLevel(strings.ToLower(strings.TrimSpace(value)))
+
+	switch level {
+	case CompressionLevelFastest, CompressionLevelDefault, CompressionLevelBest:
+		return level, nil
+	case "":
+		return CompressionLevelDefault, nil
+	default:
+		return "", ErrInvalidCompressionLevel
+	}
+}
+
+// CompressionSettings tune how responses are compressed.
+//
+// Encodings are listed in order of preference, which decides between those
+// that a client accepts equally. Responses smaller than MinSize aren't worth
+// compressing, and are sent as they are. ContentTypes limits compression to
+// the given media types, which may be wildcards such as `text/*`; when it's
+// empty, everything other than media and archive formats that are already
+// compressed is.
+type CompressionSettings struct {
+	Encodings    []CompressionEncoding
+	Level        CompressionLevel
+	MinSize      int
+	ContentTypes []string
+}
+
+// CompressionMiddleware compresses responses with the best of the configured
+// encodings that the client accepts. Responses that the upstream has already
+// encoded, and partial responses, are passed through as they are.
+type CompressionMiddleware struct {
+	settings CompressionSettings
+	encoders map[CompressionEncoding]*sync.Pool
+	next     http.Handler
+}
+
+func NewCompressionMiddleware(settings CompressionSettings, next http.Handler) *CompressionMiddleware {
+	if len(settings.Encodings) == 0 {
+		settings.Encodings = defaultCompressionEncodings
+	}
+	if settings.Level == "" {
+		settings.Level = CompressionLevelDefault
+	}
+
+	encoders := map[CompressionEncoding]*sync.Pool{}
+	for _, encoding := range settings.Encodings {
+		encoders[encoding] = &sync.Pool{New: func() any {
+			return newCompressionEncoder(encoding, settings.Level)
+		}}
+	}
+
+	return &CompressionMiddleware{
+		settings: settings,
+		encoders: encoders,
+		next:     next,
+	}
+}
+
+func (m *CompressionMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding, ok := m.negotiate(r)
+	if !ok {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	cw := &compressionWriter{ResponseWriter: w, middleware: m, encoding: encoding}
+	defer cw.Close()
+
+	m.next.ServeHTTP(cw, r)
+}
+
+// Private
+
+// negotiate picks the encoding the client prefers, by quality value, among
+// those that we support. HEAD requests get no encoding, since they have no
+// body to compress.
+func (m *CompressionMiddleware) negotiate(r *http.Request) (CompressionEncoding, bool) {
+	if r.Method == http.MethodHead {
+		return "", false
+	}
+
+	qualities := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+
+	var best CompressionEncoding
+	bestQuality := 0.0
+	for _, encoding := range m.settings.Encodings {
+		quality, ok := qualities[string(encoding)]
+		if !ok {
+			quality = qualities["*"]
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+
+	return best, bestQuality > 0
+}
+
+func (m *CompressionMiddleware) compressesContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	// Event streams are flushed an event at a time, which gives compression
+	// little to work with
+	if mediaType == "text/event-stream" {
+		return false
+	}
+
+	if len(m.settings.ContentTypes) == 0 {
+		return gzhttp.DefaultContentTypeFilter(mediaType)
+	}
+
+	return slices.ContainsFunc(m.settings.ContentTypes, func(pattern string) bool {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			return strings.HasPrefix(mediaType, prefix+"/")
+		}
+		return pattern == "*" || pattern == mediaType
+	})
+}
+
+type compressionEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func newCompressionEncoder(encoding CompressionEncoding, level CompressionLevel) compressionEncoder {
+	switch encoding {
+	case CompressionEncodingBrotli:
+		levels := map[CompressionLevel]int{CompressionLevelFastest: 1, CompressionLevelDefault: 5, CompressionLevelBest: brotli.BestCompression}
+		return brotli.NewWriterLevel(io.Discard, levels[level])
+
+	case CompressionEncodingZstd:
+		levels := map[CompressionLevel]zstd.EncoderLevel{CompressionLevelFastest: zstd.SpeedFastest, CompressionLevelDefault: zstd.SpeedDefault, CompressionLevelBest: zstd.SpeedBestCompression}
+		encoder, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(levels[level]), zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(compressionZstdWindowSize))
+		return encoder
+
+	default:
+		levels := map[CompressionLevel]int{CompressionLevelFastest: gzip.BestSpeed, CompressionLevelDefault: gzip.DefaultCompression, CompressionLevelBest: gzip.BestCompression}
+		encoder, _ := gzip.NewWriterLevel(io.Discard, levels[level])
+		return encoder
+	}
+}
+
+// compressionWriter holds back the start of a response until there's enough
+// of it to decide whether it's worth compressing.
+type compressionWriter struct {
+	http.ResponseWriter
+	middleware  *CompressionMiddleware
+	encoding    CompressionEncoding
+	statusCode  int
+	buffer      []byte
+	encoder     compressionEncoder
+	passthrough bool
+}
+
+func (w *compressionWriter) WriteHeader(statusCode int) {
+	if statusCode >= 100 && statusCode < 200 {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *compressionWriter) Write(b []byte) (int, error) {
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buffer = append(w.buffer, b...)
+
+	minSize := w.middleware.settings.MinSize
+	if len(w.buffer) >= minSize || w.contentLength() >= minSize {
+		err := w.start(false)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (w *compressionWriter) Flush() {
+	if w.encoder == nil && !w.passthrough && len(w.buffer) > 0 {
+		w.start(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressionWriter) Close() error {
+	if w.encoder == nil && !w.passthrough {
+		err := w.start(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Close()
+	w.encoder.Reset(io.Discard)
+	w.middleware.encoders[w.encoding].Put(w.encoder)
+	w.encoder = nil
+
+	return err
+}
+
+func (w *compressionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start sends the headers, and whatever we've buffered, either compressed or
+// not. Once the response is complete, it has to reach the minimum size to be
+// compressed.
+func (w *compressionWriter) start(complete bool) error {
+	if w.compressible(complete) {
+		w.startEncoding()
+	} else {
+		w.passthrough = true
+		w.writeHeader()
+	}
+
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buffer)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer)
+	}
+
+	w.buffer = nil
+	return err
+}
+
+func (w *compressionWriter) compressible(complete bool) bool {
+	header := w.Header()
+
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	switch w.statusCode {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+
+	if len(w.buffer) == 0 {
+		return false
+	}
+
+	minSize := w.middleware.settings.MinSize
+	if complete && len(w.buffer) < minSize && w.contentLength() < minSize {
+		return false
+	}
+
+	// Work out the type the same way that net/http would, so that it's known
+	// before we change the body
+	contentType, ok := header["Content-Type"]
+	if !ok {
+		contentType = []string{http.DetectContentType(w.buffer)}
+		header["Content-Type"] = contentType
+	}
+
+	return len(contentType) > 0 && w.middleware.compressesContentType(contentType[0])
+}
+
+func (w *compressionWriter) startEncoding() {
+	header := w.Header()
+	header.Set("Content-Encoding", string(w.encoding))
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+
+	// The compressed body is no longer byte-for-byte the one the ETag
+	// identified, but it is equivalent
+	if etag := header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("Etag", "W/"+etag)
+	}
+
+	w.writeHeader()
+
+	w.encoder = w.middleware.encoders[w.encoding].Get().(compressionEncoder)
+	w.encoder.Reset(w.ResponseWriter)
+}
+
+func (w *compressionWriter) writeHeader() {
+	if w.statusCode != 0 {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+}
+
+func (w *compressionWriter) contentLength() int {
+	length, err := strconv.Atoi(w.Header().Get("Content-Length"))
+	if err != nil {
+		return -1
+	}
+	return length
+}
+
+func compressionEncodingNames(encodings []CompressionEncoding) []string {
+	names := []string{}
+	for _, encoding := range encodings {
+		names = append(names, string(encoding))
+	}
+	return names
+}
+
+// parseAcceptEncoding returns the quality value of each coding in an
+// Accept-Encoding header. Codings without one have a quality of 1.
+func parseAcceptEncoding(value string) map[string]float64 {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(value, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				quality = parsed
+			}
+		}
+
+		qualities[coding] = quality
+	}
+
+	return qualities
+}
@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var compressionTestBody = strings.Repeat("Lorem ipsum dolor sit amet. ", 100)
+
+func compressionTestHandler(settings CompressionSettings, header http.Header, status int, body string) http.Handler {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte(body))
+	})
+
+	return NewCompressionMiddleware(settings, next)
+}
+
+func compressionTestRequest(handler http.Handler, method, acceptEncoding string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func decompressTestBody(t *testing.T, encoding string, body io.Reader) string {
+	var reader io.Reader
+	switch encoding {
+	case "br":
+		reader = brotli.NewReader(body)
+	case "zstd":
+		decoder, err := zstd.NewReader(body)
+		require.NoError(t, err)
+		defer decoder.Close()
+		reader = decoder
+	case "gzip":
+		decoder, err := gzip.NewReader(body)
+		require.NoError(t, err)
+		reader = decoder
+	default:
+		reader = body
+	}
+
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestCompressionMiddleware_encodings(t *testing.T) {
+	handler := compressionTestHandler(CompressionSettings{}, nil, 0, compressionTestBody)
+
+	for _, encoding := range []string{"br", "zstd", "gzip"} {
+		t.Run(encoding, func(t *testing.T) {
+			w := compressionTestRequest(handler, "GET", encoding)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+			assert.Less(t, w.Body.Len(), len(compressionTestBody))
+			assert.Equal(t, compressionTestBody, decompressTestBody(t, encoding, w.Body))
+		})
+	}
+}
+
+func TestCompressionMiddleware_levels(t *testing.T) {
+	for _, level := range []CompressionLevel{CompressionLevelFastest, CompressionLevelDefault, CompressionLevelBest} {
+		handler := compressionTestHandler(CompressionSettings{Level: level}, nil, 0, compressionTestBody)
+
+		for _, encoding := range []string{"br", "zstd", "gzip"} {
+			w := compressionTestRequest(handler, "GET", encoding)
+			assert.Equal(t, compressionTestBody, decompressTestBody(t, encoding, w.Body), "%s at %s", encoding, level)
+		}
+	}
+}
+
+func TestCompressionMiddleware_negotiation(t *testing.T) {
+	handler := compressionTestHandler(CompressionSettings{}, nil, 0, compressionTestBody)
+
+	tests := map[string]string{
+		"gzip, deflate, br, zstd": "br",
+		"gzip, zstd":              "zstd",
+		"br;q=0.5, gzip":          "gzip",
+		"br;q=0, zstd;q=0, gzip":  "gzip",
+		"*":                       "br",
+		"gzip;q=0.1, *;q=0.5":     "br",
+		"deflate":                 "",
+		"identity":                "",
+		"":                        "",
+	}
+
+	for acceptEncoding, expected := range tests {
+		w := compressionTestRequest(handler, "GET", acceptEncoding)
+		assert.Equal(t, expected, w.Header().Get("Content-Encoding"), acceptEncoding)
+	}
+}
+
+func TestCompressionMiddleware_prefers_encodings_in_configured_order(t *testing.T) {
+	handler := compressionTestHandler(CompressionSettings{Encodings: []CompressionEncoding{CompressionEncodingGzip, CompressionEncodingZstd}}, nil, 0, compressionTestBody)
+
+	assert.Equal(t, "gzip", compressionTestRequest(handler, "GET", "br, zstd, gzip").Header().Get("Content-Encoding"))
+	assert.Equal(t, "zstd", compressionTestRequest(handler, "GET", "br, zstd").Header().Get("Content-Encoding"))
+	assert.Equal(t, "", compressionTestRequest(handler, "GET", "br").Header().Get("Content-Encoding"))
+}
+
+func TestCompressionMiddleware_min_size(t *testing.T) {
+	small := strings.Repeat("a", 100)
+
+	handler := compressionTestHandler(CompressionSettings{MinSize: 1024}, nil, 0, small)
+	w := compressionTestRequest(handler, "GET", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, small, w.Body.String())
+
+	handler = compressionTestHandler(CompressionSettings{MinSize: 50}, nil, 0, small)
+	w = compressionTestRequest(handler, "GET", "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, small, decompressTestBody(t, "gzip", w.Body))
+}
+
+func TestCompressionMiddleware_content_types(t *testing.T) {
+	settings := CompressionSettings{ContentTypes: []string{"text/*", "application/json"}}
+
+	compressed := func(contentType string) bool {
+		handler := compressionTestHandler(settings, http.Header{"Content-Type": {contentType}}, 0, compressionTestBody)
+		return compressionTestRequest(handler, "GET", "gzip").Header().Get("Content-Encoding") == "gzip"
+	}
+
+	assert.True(t, compressed("text/html; charset=utf-8"))
+	assert.True(t, compressed("text/css"))
+	assert.True(t, compressed("application/json"))
+	assert.False(t, compressed("application/javascript"))
+	assert.False(t, compressed("text/event-stream"))
+	assert.False(t, compressed("not a content type"))
+}
+
+func TestCompressionMiddleware_default_content_types(t *testing.T) {
+	compressed := func(contentType string) bool {
+		handler := compressionTestHandler(CompressionSettings{}, http.Header{"Content-Type": {contentType}}, 0, compressionTestBody)
+		return compressionTestRequest(handler, "GET", "gzip").Header().Get("Content-Encoding") == "gzip"
+	}
+
+	assert.True(t, compressed("text/html"))
+	assert.True(t, compressed("application/javascript"))
+	assert.False(t, compressed("image/jpeg"))
+	assert.False(t, compressed("application/zip"))
+	assert.False(t, compressed("text/event-stream"))
+}
+
+func TestCompressionMiddleware_passes_through_encoded_and_partial_responses(t *testing.T) {
+	handler := compressionTestHandler(CompressionSettings{}, http.Header{"Content-Encoding": {"br"}}, 0, "already compressed")
+	w := compressionTestRequest(handler, "GET", "gzip")
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "already compressed", w.Body.String())
+
+	handler = compressionTestHandler(CompressionSettings{}, http.Header{"Content-Range": {"bytes 0-9/100"}}, http.StatusPartialContent, compressionTestBody[:10])
+	w = compressionTestRequest(handler, "GET", "gzip")
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, compressionTestBody[:10], w.Body.String())
+}
+
+func TestCompressionMiddleware_does_not_compress_head_requests(t *testing.T) {
+	handler := compressionTestHandler(CompressionSettings{}, nil, 0, compressionTestBody)
+
+	w := compressionTestRequest(handler, "HEAD", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+}
+
+func TestCompressionMiddleware_adjusts_headers(t *testing.T) {
+	header := http.Header{
+		"Content-Length": {"2800"},
+		"Accept-Ranges":  {"bytes"},
+		"Etag":           {`"abc"`},
+	}
+	handler := compressionTestHandler(CompressionSettings{}, header, http.StatusCreated, compressionTestBody)
+
+	w := compressionTestRequest(handler, "GET", "gzip")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, `W/"abc"`, w.Header().Get("Etag"))
+}
+
+func TestCompressionMiddleware_flushes_partial_responses(t *testing.T) {
+	flushed := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-flushed
+		w.Write([]byte(" second"))
+	})
+	server := httptest.NewServer(NewCompressionMiddleware(CompressionSettings{MinSize: 1024}, next))
+	defer server.Close()
+
+	r, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "zstd")
+
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "zstd", resp.Header.Get("Content-Encoding"))
+
+	decoder, err := zstd.NewReader(resp.Body)
+	require.NoError(t, err)
+	defer decoder.Close()
+
+	first := make([]byte, 5)
+	_, err = io.ReadFull(decoder, first)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(first))
+
+	close(flushed)
+
+	rest, err := io.ReadAll(decoder)
+	require.NoError(t, err)
+	assert.Equal(t, " second", string(rest))
+}
+
+func TestParseCompressionEncodings(t *testing.T) {
+	encodings, err := ParseCompressionEncodings([]string{"ZSTD", " gzip ", "zstd"})
+	require.NoError(t, err)
+	assert.Equal(t, []CompressionEncoding{CompressionEncodingZstd, CompressionEncodingGzip}, encodings)
+
+	_, err = ParseCompressionEncodings([]string{"gzip", "deflate"})
+	assert.ErrorIs(t, err, ErrInvalidCompressionEncoding)
+}
+
+func TestParseCompressionLevel(t *testing.T) {
+	level, err := ParseCompressionLevel("Best")
+	require.NoError(t, err)
+	assert.Equal(t, CompressionLevelBest, level)
+
+	level, err = ParseCompressionLevel("")
+	require.NoError(t, err)
+	assert.Equal(t, CompressionLevelDefault, level)
+
+	_, err = ParseCompressionLevel("9")
+	assert.ErrorIs(t, err, ErrInvalidCompressionLevel)
+}
@@ -1,13 +1,21 @@
 package internal
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/acme"
 )
 
@@ -19,99 +27,461 @@ const (
 
 	defaultTargetPort = 3000
 
+	defaultUpstreamConnectTimeout = 30 * time.Second
+	defaultUpstreamRetryAttempts  = 1
+	defaultUpstreamRetryBackoffMs = 100
+
+	defaultCircuitBreakerCooldown = 10 * time.Second
+
+	defaultHealthCheckInterval           = 5 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckHealthyThreshold   = 2
+	defaultHealthCheckUnhealthyThreshold = 3
+
 	defaultCacheSize             = 64 * MB
 	defaultMaxCacheItemSizeBytes = 1 * MB
-	defaultMaxRequestBody        = 0
+	defaultCacheRedisPrefix      = "thruster:cache:"
+	defaultCacheRuleTTL          = 60 * time.Second
+
+	defaultLowMemoryCacheSize             = 8 * MB
+	defaultLowMemoryMaxCacheItemSizeBytes = 256 * KB
+	defaultLowMemoryBudget                = 16 * MB
+	defaultMaxRequestBody                 = 0
 
 	defaultACMEDirectoryURL = acme.LetsEncryptURL
 	defaultStoragePath      = "./storage/thruster"
+	defaultStaticFilesRoot  = "public"
 	defaultBadGatewayPage   = "./public/502.html"
+	defaultBlockedPage      = "./public/403.html"
+	defaultMaintenancePage  = "./public/503.html"
+	defaultChallengePage    = "./public/challenge.html"
+
+	defaultHttpPort              = 80
+	defaultHttpsPort             = 443
+	defaultHttpIdleTimeout       = 60 * time.Second
+	defaultHttpReadTimeout       = 30 * time.Second
+	defaultHttpReadHeaderTimeout = 10 * time.Second
+	defaultHttpWriteTimeout      = 30 * time.Second
+	defaultHttpMaxHeaderBytes    = 64 * KB
+	defaultHttpMaxConnections    = 10_000
+
+	defaultMaintenanceRetryAfter = 300 * time.Second
+
+	defaultBlocklistRefreshInterval = time.Hour
+
+	defaultBanWindow   = 10 * time.Minute
+	defaultBanDuration = time.Hour
+
+	defaultWebhookBatchSize     = 100
+	defaultWebhookFlushInterval = 5 * time.Second
+
+	defaultHealthPath = "/healthz"
+	defaultReadyPath  = "/readyz"
+
+	defaultStartupDatabaseTimeout = 10 * time.Second
+	defaultStartupUpstreamTimeout = 60 * time.Second
 
-	defaultHttpPort         = 80
-	defaultHttpsPort        = 443
-	defaultHttpIdleTimeout  = 60 * time.Second
-	defaultHttpReadTimeout  = 30 * time.Second
-	defaultHttpWriteTimeout = 30 * time.Second
+	defaultShutdownDrainTimeout = 30 * time.Second
+
+	defaultConfigChangeLogSize = 20
+
+	defaultRiskTagScore   = 25
+	defaultRiskBlockScore = 100
 
 	defaultLogLevel    = slog.LevelInfo
 	defaultLogRequests = true
 
+	defaultAccessLogMaxSize   = 100 * MB
+	defaultAccessLogMaxFiles  = 5
+	defaultAccessLogGeoFields = true
+
 	defaultGeoIP2Enabled = false
+
+	defaultGeoIP2UpgradeSampleSize        = 1000
+	defaultGeoIP2UpgradeSuspiciousPercent = 5
+
+	defaultCountryStatsEnabled = true
+
+	defaultGeoBypassDuration = 7 * 24 * time.Hour
+
+	defaultChallengeDifficulty = 16
+	defaultChallengeDuration   = 24 * time.Hour
+)
+
+var (
+	ErrInvalidConfigValue     = errors.New("invalid setting")
+	ErrMissingConfigFlagValue = errors.New("--config needs the path of a config file")
 )
 
 type Config struct {
-	TargetPort      int
-	UpstreamCommand string
-	UpstreamArgs    []string
+	ConfigFile string
+
+	TargetPort        int
+	TargetProtocol    TargetProtocol
+	TargetURLs        []*url.URL
+	TargetHost        string
+	LoadBalancing     BalancingPolicy
+	StickySessions    StickySessionPolicy
+	HealthCheck       HealthCheck
+	UpstreamTimeouts  UpstreamTimeouts
+	UpstreamRetry     RetryPolicy
+	UpstreamTLS       UpstreamTLS
+	UpstreamTLSConfig *tls.Config
+
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+	UpstreamStatsInterval   time.Duration
+	UpstreamLeakThreshold   time.Duration
+	UpstreamCommand         string
+	UpstreamArgs            []string
 
-	CacheSizeBytes         int
-	MaxCacheItemSizeBytes  int
-	XSendfileEnabled       bool
-	GzipCompressionEnabled bool
-	MaxRequestBody         int
+	LowMemoryMode     bool
+	MemoryBudgetBytes int
+
+	CacheBackend          CacheBackend
+	CacheSizeBytes        int
+	CacheMaxEntries       int
+	CacheEviction         CacheEviction
+	MaxCacheItemSizeBytes int
+	CacheDiskPath         string
+	CacheRedisURL         string
+	CacheRedisPrefix      string
+	CachePurgeToken       string
+	CacheVaryHeaders      []string
+	CacheStatsInterval    time.Duration
+	CacheRules            []CacheRule
+	CacheDefaultTTL       time.Duration
+	CacheSkipSetCookie    bool
+	XSendfileEnabled      bool
+	StaticFilesRoot       string
+	StaticFilesPaths      []string
+	CompressionEnabled    bool
+	Compression           CompressionSettings
+	MaxRequestBody        int
+	IdempotencyWindow     time.Duration
 
 	TLSDomains       []string
 	ACMEDirectoryURL string
 	EAB_KID          string
 	EAB_HMACKey      string
 	StoragePath      string
+	StateStore       StoreBackend
+	StateStorePath   string
+	StateRedisURL    string
 	BadGatewayPage   string
+	BlockedPage      string
+	MaintenancePage  string
+	ChallengePage    string
+	PageLocalesPath  string
+	SupportURL       string
+
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubDomains bool
+	HSTSPreload           bool
+	HTTP3Enabled          bool
+
+	HttpPort              int
+	HttpsPort             int
+	HttpIdleTimeout       time.Duration
+	HttpReadTimeout       time.Duration
+	HttpReadHeaderTimeout time.Duration
+	HttpWriteTimeout      time.Duration
+	HttpWriteIdleTimeout  time.Duration
+	HttpMaxHeaderBytes    int
+	HttpMaxConnections    int
+
+	ForwardHeaders         bool
+	TrustedProxies         []*net.IPNet
+	ForwardedHeaderEnabled bool
+
+	HeaderRules     HeaderRules
+	HeaderRulesFile string
+
+	RequestIDEnabled bool
+	RequestIDHeader  string
 
-	HttpPort         int
-	HttpsPort        int
-	HttpIdleTimeout  time.Duration
-	HttpReadTimeout  time.Duration
-	HttpWriteTimeout time.Duration
+	HealthPath string
+	ReadyPath  string
 
-	ForwardHeaders bool
+	StartupDatabaseTimeout time.Duration
+	StartupUpstreamTimeout time.Duration
+	WaitForUpstream        bool
+	StartupFailClosed      bool
+
+	ShutdownDrainTimeout time.Duration
+	InitEnabled          bool
+
+	AdminGRPCAddress string
+	AdminAddress     string
+	AdminToken       string
+	AdminDebug       bool
+	ReplicaOf        *url.URL
+
+	ConfigChangeLogSize int
 
 	LogLevel    slog.Level
 	LogRequests bool
 
-	GeoIP2Enabled  bool
-	AllowCountries []string
-	BlockCountries []string
+	AccessLogFormat         AccessLogFormat
+	AccessLogPath           string
+	AccessLogMaxSize        int
+	AccessLogRotateInterval time.Duration
+	AccessLogMaxFiles       int
+	AccessLogGeoFields      bool
+
+	AuditLog AuditLogDestination
+
+	GeoIP2Enabled                  bool
+	GeoIP2Provider                 GeoProvider
+	GeoIP2DatabasePaths            []string
+	GeoIP2UpgradeSampleSize        int
+	GeoIP2UpgradeSuspiciousPercent int
+	CountryStatsEnabled            bool
+	AllowCountries                 []string
+	BlockCountries                 []string
+	BlockedOptionsPolicy           BlockPolicy
+	BlockedHeadPolicy              BlockPolicy
+	GeoBypassTokens                []string
+	GeoBypassDuration              time.Duration
+
+	ChallengeCountries        []string
+	ChallengeSecret           string
+	ChallengeDifficulty       int
+	ChallengeDuration         time.Duration
+	ChallengeCaptchaVerifyURL string
+	ChallengeCaptchaSecret    string
+
+	CountryRateLimits       map[string]RateLimit
+	DefaultCountryRateLimit RateLimit
+
+	FeatureHeaders []FeatureHeader
+	GeoHeaders     GeoHeaders
+
+	ClientFingerprintSecret string
+
+	RiskScores     RiskScores
+	RiskThresholds RiskThresholds
+
+	BodyRules             BodyRules
+	BodyInspectionMaxSize int
+
+	Geofences Geofences
+
+	MaintenanceWindows    MaintenanceWindows
+	MaintenanceFile       string
+	MaintenanceAllowCIDRs []*net.IPNet
+	MaintenanceRetryAfter time.Duration
+
+	CookieScope   CookieScopeMode
+	CookieDomains []string
+
+	ClientRateLimit      RateLimit
+	RateLimitExemptCIDRs []*net.IPNet
+
+	BlocklistFeeds           []BlocklistFeed
+	BlocklistRefreshInterval time.Duration
+
+	BanThreshold int
+	BanWindow    time.Duration
+	BanDuration  time.Duration
+	BanStatuses  BanStatuses
+
+	WebhookURLs          []*url.URL
+	WebhookEvents        []string
+	WebhookSecret        string
+	WebhookBatchSize     int
+	WebhookFlushInterval time.Duration
 }
 
+// NewConfig reads the configuration for running the upstream command given
+// in os.Args, which may be preceded by `--config <file>` to name the config
+// file in place of CONFIG_FILE.
 func NewConfig() (*Config, error) {
-	if len(os.Args) < 2 {
+	configFile, args, err := parseConfigFlag(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
 		return nil, errors.New("missing upstream command")
 	}
 
+	return newConfig(configFile, args[0], args[1:])
+}
+
+// newConfig reads the configuration from the config file and the
+// environment, for running the given upstream command. When no config file
+// is given, it's the one named by CONFIG_FILE, if any.
+func newConfig(configFile string, upstreamCommand string, upstreamArgs []string) (*Config, error) {
+	// The config file can't name another, so it's only found in the
+	// environment
+	if configFile == "" {
+		configFile, _ = findEnvVar("CONFIG_FILE")
+	}
+	env, err := newConfigEnv(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONFIG_FILE: %w", err)
+	}
+
 	logLevel := defaultLogLevel
-	if getEnvBool("DEBUG", false) {
+	if env.getBool("DEBUG", false) {
 		logLevel = slog.LevelDebug
 	}
 
+	lowMemoryMode := env.getBool("LOW_MEMORY_MODE", false)
+	cacheSize, maxCacheItemSize, memoryBudget := defaultCacheSize, defaultMaxCacheItemSizeBytes, 0
+	if lowMemoryMode {
+		cacheSize, maxCacheItemSize, memoryBudget = defaultLowMemoryCacheSize, defaultLowMemoryMaxCacheItemSizeBytes, defaultLowMemoryBudget
+	}
+
 	config := &Config{
-		TargetPort:      getEnvInt("TARGET_PORT", defaultTargetPort),
-		UpstreamCommand: os.Args[1],
-		UpstreamArgs:    os.Args[2:],
-
-		CacheSizeBytes:         getEnvInt("CACHE_SIZE", defaultCacheSize),
-		MaxCacheItemSizeBytes:  getEnvInt("MAX_CACHE_ITEM_SIZE", defaultMaxCacheItemSizeBytes),
-		XSendfileEnabled:       getEnvBool("X_SENDFILE_ENABLED", true),
-		GzipCompressionEnabled: getEnvBool("GZIP_COMPRESSION_ENABLED", true),
-		MaxRequestBody:         getEnvInt("MAX_REQUEST_BODY", defaultMaxRequestBody),
-
-		TLSDomains:       getEnvStrings("TLS_DOMAIN", []string{}),
-		ACMEDirectoryURL: getEnvString("ACME_DIRECTORY", defaultACMEDirectoryURL),
-		EAB_KID:          getEnvString("EAB_KID", ""),
-		EAB_HMACKey:      getEnvString("EAB_HMAC_KEY", ""),
-		StoragePath:      getEnvString("STORAGE_PATH", defaultStoragePath),
-		BadGatewayPage:   getEnvString("BAD_GATEWAY_PAGE", defaultBadGatewayPage),
-
-		HttpPort:         getEnvInt("HTTP_PORT", defaultHttpPort),
-		HttpsPort:        getEnvInt("HTTPS_PORT", defaultHttpsPort),
-		HttpIdleTimeout:  getEnvDuration("HTTP_IDLE_TIMEOUT", defaultHttpIdleTimeout),
-		HttpReadTimeout:  getEnvDuration("HTTP_READ_TIMEOUT", defaultHttpReadTimeout),
-		HttpWriteTimeout: getEnvDuration("HTTP_WRITE_TIMEOUT", defaultHttpWriteTimeout),
+		ConfigFile: configFile,
+		TargetPort: env.getInt("TARGET_PORT", defaultTargetPort),
+		TargetHost: env.getString("TARGET_HOST", ""),
+		UpstreamTimeouts: UpstreamTimeouts{
+			Connect: env.getDuration("UPSTREAM_CONNECT_TIMEOUT", defaultUpstreamConnectTimeout),
+			Read:    env.getDuration("UPSTREAM_READ_TIMEOUT", 0),
+			Write:   env.getDuration("UPSTREAM_WRITE_TIMEOUT", 0),
+		},
+		UpstreamRetry: RetryPolicy{
+			MaxAttempts: env.getInt("UPSTREAM_RETRY_ATTEMPTS", defaultUpstreamRetryAttempts),
+			Backoff:     time.Duration(env.getInt("UPSTREAM_RETRY_BACKOFF_MS", defaultUpstreamRetryBackoffMs)) * time.Millisecond,
+		},
+		CircuitBreakerThreshold: env.getInt("CIRCUIT_BREAKER_THRESHOLD", 0),
+		CircuitBreakerCooldown:  env.getDuration("CIRCUIT_BREAKER_COOLDOWN", defaultCircuitBreakerCooldown),
+		UpstreamStatsInterval:   env.getDuration("UPSTREAM_STATS_INTERVAL", 0),
+		UpstreamLeakThreshold:   env.getDuration("UPSTREAM_LEAK_THRESHOLD", defaultUpstreamLeakThreshold),
+		StickySessions: StickySessionPolicy{
+			Enabled: env.getBool("STICKY_SESSIONS", false),
+			Cookie:  env.getString("STICKY_SESSION_COOKIE", defaultStickySessionCookie),
+			TTL:     env.getDuration("STICKY_SESSION_TTL", 0),
+		},
+		HealthCheck: HealthCheck{
+			Path:               env.getString("HEALTH_CHECK_PATH", ""),
+			Interval:           env.getDuration("HEALTH_CHECK_INTERVAL", defaultHealthCheckInterval),
+			Timeout:            env.getDuration("HEALTH_CHECK_TIMEOUT", defaultHealthCheckTimeout),
+			HealthyThreshold:   env.getInt("HEALTH_CHECK_HEALTHY_THRESHOLD", defaultHealthCheckHealthyThreshold),
+			UnhealthyThreshold: env.getInt("HEALTH_CHECK_UNHEALTHY_THRESHOLD", defaultHealthCheckUnhealthyThreshold),
+		},
+		UpstreamCommand: upstreamCommand,
+		UpstreamArgs:    upstreamArgs,
+
+		LowMemoryMode:     lowMemoryMode,
+		MemoryBudgetBytes: env.getInt("MEMORY_BUDGET", memoryBudget),
+
+		CacheSizeBytes:        env.getInt("CACHE_SIZE", cacheSize),
+		CacheMaxEntries:       env.getInt("CACHE_MAX_ENTRIES", 0),
+		MaxCacheItemSizeBytes: env.getInt("MAX_CACHE_ITEM_SIZE", maxCacheItemSize),
+		CacheDiskPath:         env.getString("CACHE_DISK_PATH", ""),
+		CacheRedisURL:         env.getString("CACHE_REDIS_URL", ""),
+		CacheRedisPrefix:      env.getString("CACHE_REDIS_PREFIX", defaultCacheRedisPrefix),
+		CachePurgeToken:       env.getString("CACHE_PURGE_TOKEN", ""),
+		CacheVaryHeaders:      env.getStrings("CACHE_VARY_HEADERS", []string{}),
+		StaticFilesRoot:       env.getString("STATIC_FILES_ROOT", defaultStaticFilesRoot),
+		StaticFilesPaths:      env.getStrings("STATIC_FILES_PATHS", []string{}),
+		CacheStatsInterval:    env.getDuration("CACHE_STATS_INTERVAL", 0),
+		CacheDefaultTTL:       env.getDuration("CACHE_DEFAULT_TTL", defaultCacheRuleTTL),
+		CacheSkipSetCookie:    env.getBool("CACHE_SKIP_SET_COOKIE", false),
+		XSendfileEnabled:      env.getBool("X_SENDFILE_ENABLED", true),
+		CompressionEnabled:    env.getBool("COMPRESSION_ENABLED", env.getBool("GZIP_COMPRESSION_ENABLED", true)),
+		MaxRequestBody:        env.getInt("MAX_REQUEST_BODY", defaultMaxRequestBody),
+		IdempotencyWindow:     env.getDuration("IDEMPOTENCY_WINDOW", 0),
+
+		TLSDomains:       env.getStrings("TLS_DOMAIN", []string{}),
+		ACMEDirectoryURL: env.getString("ACME_DIRECTORY", defaultACMEDirectoryURL),
+		EAB_KID:          env.getString("EAB_KID", ""),
+		EAB_HMACKey:      env.getString("EAB_HMAC_KEY", ""),
+		StoragePath:      env.getString("STORAGE_PATH", defaultStoragePath),
+		StateStorePath:   env.getString("STATE_STORE_PATH", ""),
+		StateRedisURL:    env.getString("STATE_REDIS_URL", ""),
+		BadGatewayPage:   env.getString("BAD_GATEWAY_PAGE", defaultBadGatewayPage),
+		BlockedPage:      env.getString("BLOCKED_PAGE", defaultBlockedPage),
+		MaintenancePage:  env.getString("MAINTENANCE_PAGE", defaultMaintenancePage),
+		ChallengePage:    env.getString("CHALLENGE_PAGE", defaultChallengePage),
+		PageLocalesPath:  env.getString("PAGE_LOCALES_PATH", ""),
+		SupportURL:       env.getString("SUPPORT_URL", ""),
+
+		HSTSMaxAge:            env.getDuration("HSTS_MAX_AGE", 0),
+		HSTSIncludeSubDomains: env.getBool("HSTS_INCLUDE_SUBDOMAINS", false),
+		HSTSPreload:           env.getBool("HSTS_PRELOAD", false),
+		HTTP3Enabled:          env.getBool("HTTP3_ENABLED", false),
+
+		HttpPort:              env.getInt("HTTP_PORT", defaultHttpPort),
+		HttpsPort:             env.getInt("HTTPS_PORT", defaultHttpsPort),
+		HttpIdleTimeout:       env.getDuration("HTTP_IDLE_TIMEOUT", defaultHttpIdleTimeout),
+		HttpReadTimeout:       env.getDuration("HTTP_READ_TIMEOUT", defaultHttpReadTimeout),
+		HttpReadHeaderTimeout: env.getDuration("HTTP_READ_HEADER_TIMEOUT", defaultHttpReadHeaderTimeout),
+		HttpWriteTimeout:      env.getDuration("HTTP_WRITE_TIMEOUT", defaultHttpWriteTimeout),
+		HttpWriteIdleTimeout:  env.getDuration("HTTP_WRITE_IDLE_TIMEOUT", 0),
+		HttpMaxHeaderBytes:    env.getInt("HTTP_MAX_HEADER_BYTES", defaultHttpMaxHeaderBytes),
+		HttpMaxConnections:    env.getInt("HTTP_MAX_CONNECTIONS", defaultHttpMaxConnections),
+
+		HealthPath: env.getString("HEALTH_PATH", defaultHealthPath),
+		ReadyPath:  env.getString("READY_PATH", defaultReadyPath),
+
+		StartupDatabaseTimeout: env.getDuration("STARTUP_DATABASE_TIMEOUT", defaultStartupDatabaseTimeout),
+		StartupUpstreamTimeout: env.getDuration("STARTUP_UPSTREAM_TIMEOUT", defaultStartupUpstreamTimeout),
+		WaitForUpstream:        env.getBool("WAIT_FOR_UPSTREAM", false),
+		StartupFailClosed:      env.getBool("STARTUP_FAIL_CLOSED", false),
+
+		ShutdownDrainTimeout: env.getDuration("SHUTDOWN_DRAIN_TIMEOUT", defaultShutdownDrainTimeout),
+		InitEnabled:          env.getBool("INIT_ENABLED", true),
+
+		AdminGRPCAddress: env.getString("ADMIN_GRPC_ADDRESS", ""),
+		AdminAddress:     env.getString("ADMIN_ADDRESS", ""),
+		AdminToken:       env.getString("ADMIN_TOKEN", ""),
+		AdminDebug:       env.getBool("ADMIN_DEBUG", false),
+
+		ConfigChangeLogSize: env.getInt("CONFIG_CHANGE_LOG_SIZE", defaultConfigChangeLogSize),
 
 		LogLevel:    logLevel,
-		LogRequests: getEnvBool("LOG_REQUESTS", defaultLogRequests),
+		LogRequests: env.getBool("LOG_REQUESTS", defaultLogRequests),
+
+		AccessLogPath:           env.getString("ACCESS_LOG_PATH", ""),
+		AccessLogMaxSize:        env.getInt("ACCESS_LOG_MAX_SIZE", defaultAccessLogMaxSize),
+		AccessLogRotateInterval: env.getDuration("ACCESS_LOG_ROTATE_INTERVAL", 0),
+		AccessLogMaxFiles:       env.getInt("ACCESS_LOG_MAX_FILES", defaultAccessLogMaxFiles),
+		AccessLogGeoFields:      env.getBool("ACCESS_LOG_GEO_FIELDS", defaultAccessLogGeoFields),
+
+		GeoIP2UpgradeSampleSize:        env.getInt("GEOIP2_UPGRADE_SAMPLE_SIZE", defaultGeoIP2UpgradeSampleSize),
+		GeoIP2UpgradeSuspiciousPercent: env.getInt("GEOIP2_UPGRADE_SUSPICIOUS_PERCENT", defaultGeoIP2UpgradeSuspiciousPercent),
+		CountryStatsEnabled:            env.getBool("COUNTRY_STATS_ENABLED", defaultCountryStatsEnabled),
+
+		AllowCountries: env.getStrings("ALLOW_COUNTRIES", []string{}),
+		BlockCountries: env.getStrings("BLOCK_COUNTRIES", []string{}),
+
+		GeoBypassTokens:   env.getStrings("GEO_BYPASS_TOKENS", []string{}),
+		GeoBypassDuration: env.getDuration("GEO_BYPASS_DURATION", defaultGeoBypassDuration),
+
+		ChallengeCountries:        env.getStrings("CHALLENGE_COUNTRIES", []string{}),
+		ChallengeSecret:           env.getString("CHALLENGE_SECRET", ""),
+		ChallengeDifficulty:       env.getInt("CHALLENGE_DIFFICULTY", defaultChallengeDifficulty),
+		ChallengeDuration:         env.getDuration("CHALLENGE_DURATION", defaultChallengeDuration),
+		ChallengeCaptchaVerifyURL: env.getString("CHALLENGE_CAPTCHA_VERIFY_URL", ""),
+		ChallengeCaptchaSecret:    env.getString("CHALLENGE_CAPTCHA_SECRET", ""),
+
+		ClientFingerprintSecret: env.getString("CLIENT_FINGERPRINT_SECRET", ""),
+
+		RiskThresholds: RiskThresholds{
+			Tag:   env.getInt("RISK_TAG_SCORE", defaultRiskTagScore),
+			Block: env.getInt("RISK_BLOCK_SCORE", defaultRiskBlockScore),
+		},
+
+		BodyInspectionMaxSize: env.getInt("BODY_INSPECTION_MAX_SIZE", defaultBodyInspectionMaxSize),
+
+		BlocklistRefreshInterval: env.getDuration("BLOCKLIST_REFRESH_INTERVAL", defaultBlocklistRefreshInterval),
+
+		BanThreshold: env.getInt("BAN_THRESHOLD", 0),
+		BanWindow:    env.getDuration("BAN_WINDOW", defaultBanWindow),
+		BanDuration:  env.getDuration("BAN_DURATION", defaultBanDuration),
 
-		AllowCountries: getEnvStrings("ALLOW_COUNTRIES", []string{}),
-		BlockCountries: getEnvStrings("BLOCK_COUNTRIES", []string{}),
+		WebhookSecret:        env.getString("WEBHOOK_SECRET", ""),
+		WebhookBatchSize:     env.getInt("WEBHOOK_BATCH_SIZE", defaultWebhookBatchSize),
+		WebhookFlushInterval: env.getDuration("WEBHOOK_FLUSH_INTERVAL", defaultWebhookFlushInterval),
+
+		MaintenanceFile:       env.getString("MAINTENANCE_FILE", ""),
+		MaintenanceRetryAfter: env.getDuration("MAINTENANCE_RETRY_AFTER", defaultMaintenanceRetryAfter),
+
+		CookieDomains: env.getStrings("COOKIE_DOMAINS", []string{}),
 	}
 
 	// Validate that only one of ALLOW_COUNTRIES or BLOCK_COUNTRIES is set
@@ -119,34 +489,556 @@ func NewConfig() (*Config, error) {
 		return nil, errors.New("only one of ALLOW_COUNTRIES or BLOCK_COUNTRIES can be set, not both")
 	}
 
-	// Auto-enable GeoIP2 if country filtering is configured
-	config.GeoIP2Enabled = len(config.AllowCountries) > 0 || len(config.BlockCountries) > 0
+	err = CheckChallengeCountries(config.ChallengeCountries, config.AllowCountries, config.BlockCountries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CHALLENGE_COUNTRIES: %w", err)
+	}
+
+	err = CheckChallengeDifficulty(config.ChallengeDifficulty)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CHALLENGE_DIFFICULTY: %w", err)
+	}
+
+	err = CheckChallengeCaptcha(config.ChallengeCaptchaVerifyURL, config.ChallengeCaptchaSecret, config.ChallengePage)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CHALLENGE_CAPTCHA_VERIFY_URL: %w", err)
+	}
+
+	config.GeoIP2Provider, err = ParseGeoProvider(env.getString("GEOIP2_PROVIDER", string(GeoProviderMaxMind)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEOIP2_PROVIDER: %w", err)
+	}
+
+	config.GeoIP2DatabasePaths = env.getStrings("GEOIP2_DATABASE_PATH", []string{})
+	err = CheckGeoIP2DatabasePaths(config.GeoIP2DatabasePaths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEOIP2_DATABASE_PATH: %w", err)
+	}
+
+	config.BlockedOptionsPolicy, err = ParseBlockPolicy(env.getString("BLOCKED_OPTIONS_POLICY", string(BlockPolicyDeny)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BLOCKED_OPTIONS_POLICY: %w", err)
+	}
+
+	config.BlockedHeadPolicy, err = ParseBlockPolicy(env.getString("BLOCKED_HEAD_POLICY", string(BlockPolicyDeny)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BLOCKED_HEAD_POLICY: %w", err)
+	}
+
+	config.TargetProtocol, err = ParseTargetProtocol(env.getString("TARGET_PROTOCOL", string(TargetProtocolHTTP1)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_PROTOCOL: %w", err)
+	}
+
+	config.TargetURLs, err = ParseTargetURLs(env.getStrings("TARGET_URLS", []string{}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_URLS: %w", err)
+	}
+
+	config.UpstreamTLS = UpstreamTLS{
+		CertFile:           env.getString("UPSTREAM_TLS_CERT", ""),
+		KeyFile:            env.getString("UPSTREAM_TLS_KEY", ""),
+		CAFile:             env.getString("UPSTREAM_TLS_CA", ""),
+		ServerName:         env.getString("UPSTREAM_TLS_SERVER_NAME", ""),
+		InsecureSkipVerify: env.getBool("UPSTREAM_TLS_INSECURE_SKIP_VERIFY", false),
+	}
+	config.UpstreamTLSConfig, err = config.UpstreamTLS.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream TLS settings: %w", err)
+	}
+
+	config.CacheBackend, err = ParseCacheBackend(env.getString("CACHE_BACKEND", string(CacheBackendMemory)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CACHE_BACKEND: %w", err)
+	}
+
+	config.CacheEviction, err = ParseCacheEviction(env.getString("CACHE_EVICTION", string(CacheEvictionLRU)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CACHE_EVICTION: %w", err)
+	}
+
+	if config.CacheBackend == CacheBackendRedis {
+		_, err = redis.ParseURL(config.CacheRedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CACHE_REDIS_URL: %w", err)
+		}
+	}
+
+	if config.CacheDiskPath == "" {
+		config.CacheDiskPath = filepath.Join(config.StoragePath, "cache")
+	}
+
+	config.Compression = CompressionSettings{
+		MinSize:      env.getInt("COMPRESSION_MIN_SIZE", defaultCompressionMinSize),
+		ContentTypes: env.getStrings("COMPRESSION_CONTENT_TYPES", []string{}),
+	}
+
+	config.Compression.Encodings, err = ParseCompressionEncodings(env.getStrings("COMPRESSION_ENCODINGS", compressionEncodingNames(defaultCompressionEncodings)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid COMPRESSION_ENCODINGS: %w", err)
+	}
+
+	config.Compression.Level, err = ParseCompressionLevel(env.getString("COMPRESSION_LEVEL", string(CompressionLevelDefault)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid COMPRESSION_LEVEL: %w", err)
+	}
+
+	config.AuditLog, err = ParseAuditLogDestination(env.getString("AUDIT_LOG", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUDIT_LOG: %w", err)
+	}
+
+	config.AccessLogFormat, err = ParseAccessLogFormat(env.getString("ACCESS_LOG_FORMAT", string(AccessLogFormatDefault)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT: %w", err)
+	}
+
+	config.StateStore, err = ParseStoreBackend(env.getString("STATE_STORE", string(StoreBackendBolt)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STATE_STORE: %w", err)
+	}
+
+	if config.StateStore == StoreBackendRedis {
+		_, err = redis.ParseURL(config.StateRedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATE_REDIS_URL: %w", err)
+		}
+	}
+
+	if config.StateStorePath == "" {
+		config.StateStorePath = filepath.Join(config.StoragePath, "state.db")
+	}
+
+	config.CookieScope, err = ParseCookieScopeMode(env.getString("COOKIE_SCOPE", string(CookieScopeRegistrable)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid COOKIE_SCOPE: %w", err)
+	}
+
+	config.LoadBalancing, err = ParseBalancingPolicy(env.getString("LOAD_BALANCING", string(BalancingRoundRobin)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOAD_BALANCING: %w", err)
+	}
+
+	err = CheckStickySessionCookie(config.StickySessions.Cookie)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STICKY_SESSION_COOKIE: %w", err)
+	}
+
+	config.CountryRateLimits, err = parseCountryRateLimits(env.getStrings("COUNTRY_RATE_LIMITS", []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	if value := env.getString("COUNTRY_RATE_LIMIT_DEFAULT", ""); value != "" {
+		config.DefaultCountryRateLimit, err = ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid COUNTRY_RATE_LIMIT_DEFAULT: %w", err)
+		}
+	}
+
+	if value := env.getString("RATE_LIMIT", ""); value != "" {
+		config.ClientRateLimit, err = ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT: %w", err)
+		}
+	}
+
+	config.RateLimitExemptCIDRs, err = ParseCIDRs(env.getStrings("RATE_LIMIT_EXEMPT_CIDRS", []string{}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_EXEMPT_CIDRS: %w", err)
+	}
+
+	config.FeatureHeaders, err = parseFeatureHeaders(env.getStrings("FEATURE_HEADERS", []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	config.GeoHeaders, err = ParseGeoHeaders(env.getStrings("GEO_HEADERS", []string{string(GeoFieldCountry)}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEO_HEADERS: %w", err)
+	}
+
+	err = CheckGeoBypassTokens(config.GeoBypassTokens)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEO_BYPASS_TOKENS: %w", err)
+	}
+
+	config.CacheRules, err = parseCacheRules(env.getStrings("CACHE_RULES", []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	config.RiskScores, err = parseRiskScores(env.getStrings("RISK_SCORES", []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	config.Geofences, err = parseGeofences(env.getStrings("GEOFENCES", []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	config.BodyRules, err = parseBodyRules(env.getStrings("BODY_RULES", []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	config.HeaderRulesFile = env.getString("HEADER_RULES_FILE", "")
+	config.HeaderRules, err = parseHeaderRules(env.getStrings("HEADER_RULES", []string{}), config.HeaderRulesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config.MaintenanceWindows, err = parseMaintenanceWindows(env.getStrings("MAINTENANCE_WINDOWS", []string{}))
+	if err != nil {
+		return nil, err
+	}
+
+	config.BlocklistFeeds, err = parseBlocklistFeeds(env.getStrings("BLOCKLIST_FEEDS", []string{}))
+	if err != nil {
+		return nil, err
+	}
+	if len(config.BlocklistFeeds) > 0 && config.BlocklistRefreshInterval <= 0 {
+		return nil, errors.New("BLOCKLIST_REFRESH_INTERVAL must be positive")
+	}
+
+	if config.BanThreshold > 0 && (config.BanWindow <= 0 || config.BanDuration <= 0) {
+		return nil, errors.New("BAN_WINDOW and BAN_DURATION must be positive")
+	}
+
+	config.BanStatuses, err = ParseBanStatuses(env.getStrings("BAN_STATUSES", []string{}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BAN_STATUSES: %w", err)
+	}
+
+	config.WebhookURLs, err = ParseWebhookURLs(env.getStrings("WEBHOOK_URLS", []string{}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_URLS: %w", err)
+	}
+	config.WebhookEvents, err = ParseEventTypes(env.getStrings("WEBHOOK_EVENTS", []string{}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_EVENTS: %w", err)
+	}
+	if len(config.WebhookURLs) > 0 && (config.WebhookBatchSize <= 0 || config.WebhookFlushInterval <= 0) {
+		return nil, errors.New("WEBHOOK_BATCH_SIZE and WEBHOOK_FLUSH_INTERVAL must be positive")
+	}
+
+	config.MaintenanceAllowCIDRs, err = ParseCIDRs(env.getStrings("MAINTENANCE_ALLOW_CIDRS", []string{}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAINTENANCE_ALLOW_CIDRS: %w", err)
+	}
+
+	if value := env.getString("REPLICA_OF", ""); value != "" {
+		config.ReplicaOf, err = parseReplicaOf(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REPLICA_OF: %w", err)
+		}
+	}
+
+	// Auto-enable GeoIP2 if country filtering or challenges, rate limiting, feature headers, chosen geo headers, country risk scores, country body rules or maintenance windows are configured.
+	// Replicas enable it too, since they can't know in advance whether their primary's policy will need it.
+	config.GeoIP2Enabled = len(config.AllowCountries) > 0 || len(config.BlockCountries) > 0 || len(config.ChallengeCountries) > 0 || config.HasCountryRateLimits() || len(config.FeatureHeaders) > 0 || (len(config.GeoHeaders) > 0 && !slices.Equal(config.GeoHeaders, DefaultGeoHeaders)) || config.RiskScores.Uses(TagCountry) || config.BodyRules.UsesCountries() || len(config.Geofences) > 0 || len(config.MaintenanceWindows) > 0 || config.ReplicaOf != nil
+
+	config.ForwardHeaders = env.getBool("FORWARD_HEADERS", !config.HasTLS())
+	config.ForwardedHeaderEnabled = env.getBool("FORWARDED_HEADER_ENABLED", false)
+
+	config.TrustedProxies, err = ParseCIDRs(env.getStrings("TRUSTED_PROXIES", DefaultTrustedProxies))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	config.RequestIDEnabled = env.getBool("REQUEST_ID_ENABLED", true)
+	config.RequestIDHeader = http.CanonicalHeaderKey(env.getString("REQUEST_ID_HEADER", defaultRequestIDHeader))
 
-	config.ForwardHeaders = getEnvBool("FORWARD_HEADERS", !config.HasTLS())
+	err = env.err()
+	if err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
 
+// parseConfigFlag takes a leading `--config <file>` or `--config=<file>` from
+// the arguments.
+func parseConfigFlag(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", args, nil
+	}
+
+	flag := strings.TrimLeft(args[0], "-")
+	switch {
+	case args[0] == flag:
+		return "", args, nil
+	case flag == "config":
+		if len(args) < 2 {
+			return "", nil, ErrMissingConfigFlagValue
+		}
+		return args[1], args[2:], nil
+	case strings.HasPrefix(flag, "config="):
+		return strings.TrimPrefix(flag, "config="), args[1:], nil
+	default:
+		return "", args, nil
+	}
+}
+
 func (c *Config) HasTLS() bool {
 	return len(c.TLSDomains) > 0
 }
 
-func findEnv(key string) (string, bool) {
-	value, ok := os.LookupEnv(ENV_PREFIX + key)
+// UsesStateStore reports whether anything keeps its state in the STATE_STORE.
+func (c *Config) UsesStateStore() bool {
+	return c.IdempotencyWindow > 0 || c.BanThreshold > 0
+}
+
+func (c *Config) HasCountryRateLimits() bool {
+	return len(c.CountryRateLimits) > 0 || c.DefaultCountryRateLimit.Enabled()
+}
+
+func parseReplicaOf(value string) (*url.URL, error) {
+	primary, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if (primary.Scheme != "http" && primary.Scheme != "https") || primary.Host == "" {
+		return nil, errors.New("expected the http or https URL of the primary's admin server")
+	}
+	return primary, nil
+}
+
+func parseCountryRateLimits(items []string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+
+	for _, item := range items {
+		country, value, ok := strings.Cut(item, "=")
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !ok || country == "" {
+			return nil, fmt.Errorf("invalid COUNTRY_RATE_LIMITS entry %q: expected COUNTRY=rps[:burst]", item)
+		}
+
+		limit, err := ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid COUNTRY_RATE_LIMITS entry %q: %w", item, err)
+		}
+
+		limits[country] = limit
+	}
+
+	return limits, nil
+}
+
+func parseCacheRules(items []string) ([]CacheRule, error) {
+	rules := []CacheRule{}
+
+	for _, item := range items {
+		rule, err := ParseCacheRule(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CACHE_RULES entry %q: %w", item, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseFeatureHeaders(items []string) ([]FeatureHeader, error) {
+	headers := []FeatureHeader{}
+
+	for _, item := range items {
+		header, err := ParseFeatureHeader(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FEATURE_HEADERS entry %q: %w", item, err)
+		}
+		headers = append(headers, header)
+	}
+
+	return headers, nil
+}
+
+func parseRiskScores(items []string) (RiskScores, error) {
+	scores := RiskScores{}
+
+	for _, item := range items {
+		score, err := ParseRiskScore(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RISK_SCORES entry %q: %w", item, err)
+		}
+		scores = append(scores, score)
+	}
+
+	return scores, nil
+}
+
+func parseMaintenanceWindows(items []string) (MaintenanceWindows, error) {
+	windows := MaintenanceWindows{}
+
+	for _, item := range items {
+		window, err := ParseMaintenanceWindow(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAINTENANCE_WINDOWS entry %q: %w", item, err)
+		}
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+func parseBlocklistFeeds(items []string) ([]BlocklistFeed, error) {
+	feeds := []BlocklistFeed{}
+	names := map[string]bool{}
+
+	for _, item := range items {
+		feed, err := ParseBlocklistFeed(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BLOCKLIST_FEEDS entry %q: %w", item, err)
+		}
+		if names[feed.Name] {
+			return nil, fmt.Errorf("invalid BLOCKLIST_FEEDS entry %q: feed %s is listed more than once", item, feed.Name)
+		}
+		names[feed.Name] = true
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, nil
+}
+
+func parseGeofences(items []string) (Geofences, error) {
+	fences := Geofences{}
+
+	for _, item := range items {
+		fence, err := ParseGeofence(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GEOFENCES entry %q: %w", item, err)
+		}
+		fences = append(fences, fence)
+	}
+
+	return fences, nil
+}
+
+// parseHeaderRules reads the rules from the list, followed by any in the
+// file, which can hold values with commas in them.
+func parseHeaderRules(items []string, file string) (HeaderRules, error) {
+	rules := HeaderRules{}
+
+	for _, item := range items {
+		rule, err := ParseHeaderRule(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEADER_RULES entry %q: %w", item, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEADER_RULES_FILE: %w", err)
+		}
+		defer f.Close()
+
+		fileRules, err := ReadHeaderRules(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEADER_RULES_FILE: %w", err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	return rules, nil
+}
+
+func parseBodyRules(items []string) (BodyRules, error) {
+	rules := BodyRules{}
+
+	for _, item := range items {
+		rule, err := ParseBodyRule(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BODY_RULES entry %q: %w", item, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// configEnv reads settings from the environment and from the config file, if
+// there is one, with the environment taking precedence. Values that can't be
+// parsed are noted rather than replaced by their defaults, so that they can
+// all be reported once the configuration has been read, except for those in
+// unprefixed environment variables, which are only logged.
+type configEnv struct {
+	path   string
+	file   map[string]string
+	read   map[string]bool
+	errors []error
+}
+
+func newConfigEnv(path string) (*configEnv, error) {
+	file, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &configEnv{path: path, file: file, read: map[string]bool{}}, nil
+}
+
+// err reports the values that couldn't be parsed, and the settings in the
+// config file that were never read, which are most likely misspelled.
+func (e *configEnv) err() error {
+	errs := e.errors
+
+	unknown := []string{}
+	for key := range e.file {
+		if !e.read[strings.TrimPrefix(key, ENV_PREFIX)] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		errs = append(errs, fmt.Errorf("%w in %s: %s", ErrUnknownConfigFileSetting, e.path, strings.Join(unknown, ", ")))
+	}
+
+	return errors.Join(errs...)
+}
+
+// find looks for the setting in the environment, and then in the config
+// file, preferring the prefixed form of its name in each.
+func (e *configEnv) find(key string) (string, bool) {
+	value, _, ok := e.lookup(key)
+	return value, ok
+}
+
+// lookup is find, also saying whether the value has to be valid: those from
+// the unprefixed environment variables don't, since names like DEBUG or PORT
+// are as likely to be meant for something else running alongside.
+func (e *configEnv) lookup(key string) (string, bool, bool) {
+	if e.read != nil {
+		e.read[key] = true
+	}
+
+	value, prefixed, ok := lookupEnvVar(key)
 	if ok {
-		return value, true
+		return value, prefixed, true
 	}
 
-	value, ok = os.LookupEnv(key)
+	value, ok = e.file[ENV_PREFIX+key]
 	if ok {
-		return value, true
+		return value, true, true
 	}
 
-	return "", false
+	value, ok = e.file[key]
+	return value, true, ok
 }
 
-func getEnvString(key, defaultValue string) string {
-	value, ok := findEnv(key)
+func (e *configEnv) invalid(key, value, expected string, strict bool) {
+	if !strict {
+		slog.Warn("Ignoring invalid value in the environment; using the default", "name", key, "value", value, "expected", expected)
+		return
+	}
+
+	e.errors = append(e.errors, fmt.Errorf("%w: %s is %q, but must be %s", ErrInvalidConfigValue, key, value, expected))
+}
+
+func (e *configEnv) getString(key, defaultValue string) string {
+	value, ok := e.find(key)
 	if ok {
 		return value
 	}
@@ -154,8 +1046,8 @@ func getEnvString(key, defaultValue string) string {
 	return defaultValue
 }
 
-func getEnvStrings(key string, defaultValue []string) []string {
-	value, ok := findEnv(key)
+func (e *configEnv) getStrings(key string, defaultValue []string) []string {
+	value, ok := e.find(key)
 	if ok {
 		items := strings.Split(value, ",")
 		result := []string{}
@@ -173,44 +1065,83 @@ func getEnvStrings(key string, defaultValue []string) []string {
 	return defaultValue
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	value, ok := findEnv(key)
+func (e *configEnv) getInt(key string, defaultValue int) int {
+	value, strict, ok := e.lookup(key)
 	if !ok {
 		return defaultValue
 	}
 
-	intValue, err := strconv.Atoi(value)
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
 	if err != nil {
+		e.invalid(key, value, "a whole number", strict)
 		return defaultValue
 	}
 
 	return intValue
 }
 
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	value, ok := findEnv(key)
+func (e *configEnv) getFloat(key string, defaultValue float64) float64 {
+	value, strict, ok := e.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+
+	floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		e.invalid(key, value, "a number", strict)
+		return defaultValue
+	}
+
+	return floatValue
+}
+
+func (e *configEnv) getDuration(key string, defaultValue time.Duration) time.Duration {
+	value, strict, ok := e.lookup(key)
 	if !ok {
 		return defaultValue
 	}
 
-	intValue, err := strconv.Atoi(value)
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
 	if err != nil {
+		e.invalid(key, value, "a whole number of seconds", strict)
 		return defaultValue
 	}
 
 	return time.Duration(intValue) * time.Second
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	value, ok := findEnv(key)
+func (e *configEnv) getBool(key string, defaultValue bool) bool {
+	value, strict, ok := e.lookup(key)
 	if !ok {
 		return defaultValue
 	}
 
-	boolValue, err := strconv.ParseBool(value)
+	boolValue, err := strconv.ParseBool(strings.TrimSpace(value))
 	if err != nil {
+		e.invalid(key, value, "true or false", strict)
 		return defaultValue
 	}
 
 	return boolValue
 }
+
+func findEnvVar(key string) (string, bool) {
+	value, _, ok := lookupEnvVar(key)
+	return value, ok
+}
+
+// lookupEnvVar finds the environment variable for the setting, and whether
+// it was the prefixed form of its name.
+func lookupEnvVar(key string) (string, bool, bool) {
+	value, ok := os.LookupEnv(ENV_PREFIX + key)
+	if ok {
+		return value, true, true
+	}
+
+	value, ok = os.LookupEnv(key)
+	if ok {
+		return value, false, true
+	}
+
+	return "", false, false
+}
@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const configChangesFileName = "config_changes.json"
+
+type StateChangeKind string
+
+const (
+	StateChangeKindOption StateChangeKind = "option"
+	StateChangeKindRule   StateChangeKind = "rule"
+)
+
+type StateChangeAction string
+
+const (
+	StateChangeAdded    StateChangeAction = "added"
+	StateChangeRemoved  StateChangeAction = "removed"
+	StateChangeModified StateChangeAction = "modified"
+)
+
+type StateChange struct {
+	Kind   StateChangeKind   `json:"kind"`
+	Action StateChangeAction `json:"action"`
+	Key    string            `json:"key"`
+	Old    string            `json:"old,omitempty"`
+	New    string            `json:"new,omitempty"`
+}
+
+// StateDiff is the set of changes between two states, ordered by kind and
+// key.
+type StateDiff struct {
+	At      time.Time     `json:"at"`
+	Changes []StateChange `json:"changes"`
+}
+
+func DiffStates(from, to State) []StateChange {
+	changes := diffStateEntries(StateChangeKindOption, from.Options, to.Options)
+	return append(changes, diffStateEntries(StateChangeKindRule, from.Rules, to.Rules)...)
+}
+
+func (d StateDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+func (d StateDiff) Count(action StateChangeAction) int {
+	count := 0
+	for _, change := range d.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// ConfigChangeLog keeps the most recent changes to the effective state.
+//
+// The log is stored alongside the last state it saw, so that changes made
+// between runs are picked up when the next run records its state.
+type ConfigChangeLog struct {
+	sync.Mutex
+	path           string
+	limit          int
+	state          *State
+	changes        []StateDiff
+	getCurrentTime GetCurrentTime
+}
+
+type configChangeLogFile struct {
+	State   *State      `json:"state"`
+	Changes []StateDiff `json:"changes"`
+}
+
+func NewConfigChangeLog(path string, limit int) *ConfigChangeLog {
+	l := &ConfigChangeLog{
+		path:           path,
+		limit:          limit,
+		getCurrentTime: time.Now,
+	}
+
+	err := l.load()
+	if err != nil {
+		slog.Error("Unable to read configuration change log", "path", path, "error", err)
+	}
+
+	return l
+}
+
+// Record compares the state with the one last recorded, logging and keeping
+// any differences. Nothing is reported for the first state recorded.
+func (l *ConfigChangeLog) Record(state State) StateDiff {
+	l.Lock()
+	defer l.Unlock()
+
+	diff := StateDiff{At: l.getCurrentTime().UTC()}
+	if l.state != nil {
+		diff.Changes = DiffStates(*l.state, state)
+	}
+	l.state = &state
+
+	if !diff.Empty() {
+		logStateDiff(diff)
+
+		l.changes = append([]StateDiff{diff}, l.changes...)
+		if len(l.changes) > l.limit {
+			l.changes = l.changes[:l.limit]
+		}
+	}
+
+	err := l.save()
+	if err != nil {
+		slog.Error("Unable to write configuration change log", "path", l.path, "error", err)
+	}
+
+	return diff
+}
+
+// Changes returns the retained diffs, most recent first.
+func (l *ConfigChangeLog) Changes() []StateDiff {
+	l.Lock()
+	defer l.Unlock()
+
+	return slices.Clone(l.changes)
+}
+
+// Private
+
+func (l *ConfigChangeLog) load() error {
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file configChangeLogFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+
+	l.state = file.State
+	l.changes = file.Changes
+	if len(l.changes) > l.limit {
+		l.changes = l.changes[:l.limit]
+	}
+
+	return nil
+}
+
+func (l *ConfigChangeLog) save() error {
+	data, err := json.MarshalIndent(configChangeLogFile{State: l.state, Changes: l.changes}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(l.path)
+	err = os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, configChangesFileName+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), l.path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+func diffStateEntries(kind StateChangeKind, from, to map[string]string) []StateChange {
+	changes := []StateChange{}
+
+	for key, old := range from {
+		value, ok := to[key]
+		switch {
+		case !ok:
+			changes = append(changes, StateChange{Kind: kind, Action: StateChangeRemoved, Key: key, Old: old})
+		case value != old:
+			changes = append(changes, StateChange{Kind: kind, Action: StateChangeModified, Key: key, Old: old, New: value})
+		}
+	}
+
+	for key, value := range to {
+		if _, ok := from[key]; !ok {
+			changes = append(changes, StateChange{Kind: kind, Action: StateChangeAdded, Key: key, New: value})
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b StateChange) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return changes
+}
+
+func logStateDiff(diff StateDiff) {
+	slog.Info("Configuration changed",
+		"added", diff.Count(StateChangeAdded),
+		"removed", diff.Count(StateChangeRemoved),
+		"modified", diff.Count(StateChangeModified))
+
+	for _, change := range diff.Changes {
+		slog.Info("Configuration change", "kind", change.Kind, "action", change.Action, "key", change.Key, "old", change.Old, "new", change.New)
+	}
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffStates(t *testing.T) {
+	from := State{
+		Options: map[string]string{"HTTP_PORT": "80", "DEBUG": "false"},
+		Rules:   map[string]string{"block_country:CN": "block", "country_rate_limit:BR": "5:5"},
+	}
+	to := State{
+		Options: map[string]string{"HTTP_PORT": "8080", "DEBUG": "false"},
+		Rules:   map[string]string{"block_country:RU": "block", "country_rate_limit:BR": "1:1"},
+	}
+
+	assert.Equal(t, []StateChange{
+		{Kind: StateChangeKindOption, Action: StateChangeModified, Key: "HTTP_PORT", Old: "80", New: "8080"},
+		{Kind: StateChangeKindRule, Action: StateChangeRemoved, Key: "block_country:CN", Old: "block"},
+		{Kind: StateChangeKindRule, Action: StateChangeAdded, Key: "block_country:RU", New: "block"},
+		{Kind: StateChangeKindRule, Action: StateChangeModified, Key: "country_rate_limit:BR", Old: "5:5", New: "1:1"},
+	}, DiffStates(from, to))
+
+	assert.Empty(t, DiffStates(to, to))
+}
+
+func TestConfigChangeLog_records_changes(t *testing.T) {
+	log := NewConfigChangeLog(filepath.Join(t.TempDir(), configChangesFileName), 10)
+
+	diff := log.Record(State{Rules: map[string]string{"block_country:CN": "block"}})
+	assert.True(t, diff.Empty())
+
+	diff = log.Record(State{Rules: map[string]string{"block_country:CN": "block"}})
+	assert.True(t, diff.Empty())
+
+	diff = log.Record(State{Rules: map[string]string{"block_country:RU": "block"}})
+	assert.Equal(t, 1, diff.Count(StateChangeAdded))
+	assert.Equal(t, 1, diff.Count(StateChangeRemoved))
+
+	changes := log.Changes()
+	require.Len(t, changes, 1)
+	assert.Equal(t, diff, changes[0])
+}
+
+func TestConfigChangeLog_persists_between_runs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", configChangesFileName)
+
+	NewConfigChangeLog(path, 10).Record(State{Options: map[string]string{"HTTP_PORT": "80"}})
+
+	log := NewConfigChangeLog(path, 10)
+	diff := log.Record(State{Options: map[string]string{"HTTP_PORT": "8080"}})
+
+	assert.Equal(t, []StateChange{
+		{Kind: StateChangeKindOption, Action: StateChangeModified, Key: "HTTP_PORT", Old: "80", New: "8080"},
+	}, diff.Changes)
+
+	changes := NewConfigChangeLog(path, 10).Changes()
+	require.Len(t, changes, 1)
+	assert.Equal(t, diff.Changes, changes[0].Changes)
+}
+
+func TestConfigChangeLog_keeps_most_recent_changes(t *testing.T) {
+	log := NewConfigChangeLog(filepath.Join(t.TempDir(), configChangesFileName), 2)
+
+	for _, port := range []string{"1", "2", "3", "4"} {
+		log.Record(State{Options: map[string]string{"HTTP_PORT": port}})
+	}
+
+	changes := log.Changes()
+	require.Len(t, changes, 2)
+	assert.Equal(t, "4", changes[0].Changes[0].New)
+	assert.Equal(t, "3", changes[1].Changes[0].New)
+}
+
+func TestConfigChangeLog_ignores_unreadable_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configChangesFileName)
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+
+	log := NewConfigChangeLog(path, 10)
+	assert.True(t, log.Record(State{Options: map[string]string{"HTTP_PORT": "80"}}).Empty())
+	assert.Empty(t, log.Changes())
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidConfigFileLine    = errors.New("config file lines must be in the form KEY=value")
+	ErrInvalidConfigFileYAML    = errors.New("YAML config files must be a mapping of settings to values or lists of values")
+	ErrInvalidConfigFileTOML    = errors.New("TOML config files must be a table of settings to values or arrays of values")
+	ErrUnknownConfigFileSetting = errors.New("unknown setting")
+)
+
+// ReadConfigFile reads settings in the same form as environment variables,
+// one `KEY=value` per line, with or without the THRUSTER_ prefix. Values may
+// be wrapped in single or double quotes. Blank lines and those starting with
+// `#` are skipped.
+func ReadConfigFile(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validConfigFileKey(key) {
+			return nil, fmt.Errorf("line %d: %w", line, ErrInvalidConfigFileLine)
+		}
+
+		values[key] = unquoteConfigFileValue(strings.TrimSpace(value))
+	}
+
+	return values, scanner.Err()
+}
+
+// ReadYAMLConfigFile reads settings from a YAML mapping, keyed by the names
+// of their environment variables in either case, such as `http_port`.
+// Mappings within it group settings that share a prefix, so that
+// `upstream: {connect_timeout: 5}` sets UPSTREAM_CONNECT_TIMEOUT. Lists are
+// read as the comma-separated values the environment variables take.
+func ReadYAMLConfigFile(r io.Reader) (map[string]string, error) {
+	var document yaml.Node
+	err := yaml.NewDecoder(r).Decode(&document)
+	if errors.Is(err, io.EOF) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	err = readYAMLConfigMapping(document.Content[0], "", values)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// ReadTOMLConfigFile reads settings from a TOML document in the same way as
+// ReadYAMLConfigFile: tables group settings that share a prefix, and arrays
+// are read as comma-separated values.
+func ReadTOMLConfigFile(r io.Reader) (map[string]string, error) {
+	document := map[string]any{}
+	_, err := toml.NewDecoder(r).Decode(&document)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	err = readTOMLConfigTable(document, "", values)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Private
+
+// loadConfigFile reads the settings in the file, as YAML or TOML when it's
+// named as such, and otherwise as lines of `KEY=value`.
+func loadConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return map[string]string{}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return ReadYAMLConfigFile(file)
+	case ".toml":
+		return ReadTOMLConfigFile(file)
+	default:
+		return ReadConfigFile(file)
+	}
+}
+
+func readYAMLConfigMapping(node *yaml.Node, prefix string, values map[string]string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: %w", node.Line, ErrInvalidConfigFileYAML)
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		key := configFileKey(prefix, keyNode.Value)
+		if keyNode.Kind != yaml.ScalarNode || !validConfigFileKey(key) {
+			return fmt.Errorf("line %d: %w", keyNode.Line, ErrInvalidConfigFileYAML)
+		}
+
+		switch valueNode.Kind {
+		case yaml.MappingNode:
+			err := readYAMLConfigMapping(valueNode, key+"_", values)
+			if err != nil {
+				return err
+			}
+		case yaml.SequenceNode:
+			items := []string{}
+			for _, item := range valueNode.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: %w", item.Line, ErrInvalidConfigFileYAML)
+				}
+				items = append(items, item.Value)
+			}
+			values[key] = strings.Join(items, ",")
+		case yaml.ScalarNode:
+			if valueNode.Tag == "!!null" {
+				values[key] = ""
+			} else {
+				values[key] = valueNode.Value
+			}
+		default:
+			return fmt.Errorf("line %d: %w", valueNode.Line, ErrInvalidConfigFileYAML)
+		}
+	}
+
+	return nil
+}
+
+func readTOMLConfigTable(table map[string]any, prefix string, values map[string]string) error {
+	for name, value := range table {
+		key := configFileKey(prefix, name)
+		if !validConfigFileKey(key) {
+			return fmt.Errorf("%s: %w", name, ErrInvalidConfigFileTOML)
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			err := readTOMLConfigTable(value, key+"_", values)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := []string{}
+			for _, item := range value {
+				text, ok := tomlConfigValue(item)
+				if !ok {
+					return fmt.Errorf("%s: %w", key, ErrInvalidConfigFileTOML)
+				}
+				items = append(items, text)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			text, ok := tomlConfigValue(value)
+			if !ok {
+				return fmt.Errorf("%s: %w", key, ErrInvalidConfigFileTOML)
+			}
+			values[key] = text
+		}
+	}
+
+	return nil
+}
+
+func tomlConfigValue(value any) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case int64:
+		return strconv.FormatInt(value, 10), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	default:
+		return "", false
+	}
+}
+
+// configFileKey names a setting read from a YAML or TOML file in the form of
+// its environment variable, so that `connect-timeout` under `upstream` is
+// UPSTREAM_CONNECT_TIMEOUT.
+func configFileKey(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func validConfigFileKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func unquoteConfigFileValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	values, err := ReadConfigFile(strings.NewReader(`
+# Countries
+THRUSTER_BLOCK_COUNTRIES=CN,RU
+  RATE_LIMIT = 100/60
+
+BLOCKED_PAGE="./public/blocked page.html"
+SUPPORT_URL='https://example.com/help?a=b'
+TARGET_URLS=
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"THRUSTER_BLOCK_COUNTRIES": "CN,RU",
+		"RATE_LIMIT":               "100/60",
+		"BLOCKED_PAGE":             "./public/blocked page.html",
+		"SUPPORT_URL":              "https://example.com/help?a=b",
+		"TARGET_URLS":              "",
+	}, values)
+}
+
+func TestReadConfigFile_invalid_lines(t *testing.T) {
+	for _, content := range []string{"BLOCK_COUNTRIES", "=CN", "BLOCK COUNTRIES=CN", "export BLOCK_COUNTRIES=CN"} {
+		_, err := ReadConfigFile(strings.NewReader("# Rules\n" + content))
+		assert.ErrorIs(t, err, ErrInvalidConfigFileLine, content)
+		assert.ErrorContains(t, err, "line 2", content)
+	}
+}
+
+func TestReadYAMLConfigFile(t *testing.T) {
+	values, err := ReadYAMLConfigFile(strings.NewReader(`
+# Countries
+thruster_block_countries: [CN, RU]
+rate_limit: "100:60"
+blocked_page: ./public/blocked page.html
+target_urls:
+upstream:
+  connect_timeout: 5
+  retry-attempts: 2
+  tls:
+    insecure_skip_verify: true
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"THRUSTER_BLOCK_COUNTRIES":          "CN,RU",
+		"RATE_LIMIT":                        "100:60",
+		"BLOCKED_PAGE":                      "./public/blocked page.html",
+		"TARGET_URLS":                       "",
+		"UPSTREAM_CONNECT_TIMEOUT":          "5",
+		"UPSTREAM_RETRY_ATTEMPTS":           "2",
+		"UPSTREAM_TLS_INSECURE_SKIP_VERIFY": "true",
+	}, values)
+
+	values, err = ReadYAMLConfigFile(strings.NewReader("# Nothing yet\n"))
+	require.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestReadYAMLConfigFile_invalid(t *testing.T) {
+	for _, content := range []string{"- CN\n- RU", "block countries: CN", "geofences:\n  - {name: office}"} {
+		_, err := ReadYAMLConfigFile(strings.NewReader(content))
+		assert.ErrorIs(t, err, ErrInvalidConfigFileYAML, content)
+	}
+
+	_, err := ReadYAMLConfigFile(strings.NewReader("block_countries: [CN"))
+	assert.Error(t, err)
+}
+
+func TestReadTOMLConfigFile(t *testing.T) {
+	values, err := ReadTOMLConfigFile(strings.NewReader(`
+# Countries
+thruster_block_countries = ["CN", "RU"]
+rate_limit = "100:60"
+blocked_page = "./public/blocked page.html"
+debug = true
+
+[upstream]
+connect_timeout = 5
+retry-attempts = 2
+
+[upstream.tls]
+insecure_skip_verify = true
+
+[chaos]
+lookup_error_rate = 0.25
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"THRUSTER_BLOCK_COUNTRIES":          "CN,RU",
+		"RATE_LIMIT":                        "100:60",
+		"BLOCKED_PAGE":                      "./public/blocked page.html",
+		"DEBUG":                             "true",
+		"UPSTREAM_CONNECT_TIMEOUT":          "5",
+		"UPSTREAM_RETRY_ATTEMPTS":           "2",
+		"UPSTREAM_TLS_INSECURE_SKIP_VERIFY": "true",
+		"CHAOS_LOOKUP_ERROR_RATE":           "0.25",
+	}, values)
+
+	values, err = ReadTOMLConfigFile(strings.NewReader("# Nothing yet\n"))
+	require.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestReadTOMLConfigFile_invalid(t *testing.T) {
+	for _, content := range []string{`"block countries" = "CN"`, "geofences = [{name = \"office\"}]", "since = 2026-10-16"} {
+		_, err := ReadTOMLConfigFile(strings.NewReader(content))
+		assert.ErrorIs(t, err, ErrInvalidConfigFileTOML, content)
+	}
+
+	_, err := ReadTOMLConfigFile(strings.NewReader("block_countries = [\"CN\""))
+	assert.Error(t, err)
+}
+
+func TestConfigEnv_prefers_the_environment(t *testing.T) {
+	usingEnvVar(t, "THRUSTER_RATE_LIMIT", "10:60")
+
+	env := &configEnv{file: map[string]string{"RATE_LIMIT": "100:60", "THRUSTER_HTTP_PORT": "8080", "HTTP_PORT": "9090"}}
+
+	value, _ := env.find("RATE_LIMIT")
+	assert.Equal(t, "10:60", value)
+
+	value, _ = env.find("HTTP_PORT")
+	assert.Equal(t, "8080", value)
+
+	_, found := env.find("HTTPS_PORT")
+	assert.False(t, found)
+}
+
+func TestConfigEnv_reports_invalid_values_and_unknown_settings(t *testing.T) {
+	env := &configEnv{
+		path: "thruster.yml",
+		file: map[string]string{"HTTP_PORT": "eighty", "DEBUG": "maybe", "HTTP_PROT": "80", "THRUSTER_TIMEOUT": "5"},
+		read: map[string]bool{},
+	}
+
+	assert.Equal(t, 80, env.getInt("HTTP_PORT", 80))
+	assert.False(t, env.getBool("DEBUG", false))
+
+	err := env.err()
+	assert.ErrorIs(t, err, ErrInvalidConfigValue)
+	assert.ErrorContains(t, err, `HTTP_PORT is "eighty", but must be a whole number`)
+	assert.ErrorContains(t, err, `DEBUG is "maybe", but must be true or false`)
+	assert.ErrorIs(t, err, ErrUnknownConfigFileSetting)
+	assert.ErrorContains(t, err, "unknown setting in thruster.yml: HTTP_PROT, THRUSTER_TIMEOUT")
+}
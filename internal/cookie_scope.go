@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+type CookieScopeMode string
+
+const (
+	CookieScopeRegistrable CookieScopeMode = "registrable"
+	CookieScopeHost        CookieScopeMode = "host"
+)
+
+var ErrInvalidCookieScopeMode = errors.New("cookie scope must be one of registrable or host")
+
+func ParseCookieScopeMode(value string) (CookieScopeMode, error) {
+	mode := CookieScopeMode(strings.ToLower(strings.TrimSpace(value)))
+
+	switch mode {
+	case CookieScopeRegistrable, CookieScopeHost:
+		return mode, nil
+	case "":
+		return CookieScopeRegistrable, nil
+	default:
+		return "", ErrInvalidCookieScopeMode
+	}
+}
+
+// CookieScope decides the domain of the cookies we issue, such as those that
+// record a passed challenge or a bypass.
+//
+// A request for a host under one of the configured domains gets a cookie for
+// that domain, which lets a deployment serving several sites scope each one
+// explicitly. Otherwise, in registrable mode, the cookie is shared by the
+// host's registrable domain, as determined by the Public Suffix List, so that
+// it works across `www` and the apex without leaking to other tenants of a
+// shared suffix like `herokuapp.com`. In host mode the cookie only goes back
+// to the host that set it.
+type CookieScope struct {
+	mode    CookieScopeMode
+	domains []string
+}
+
+func NewCookieScope(mode CookieScopeMode, domains []string) *CookieScope {
+	normalized := []string{}
+	for _, domain := range domains {
+		normalized = append(normalized, normalizeCookieHost(domain))
+	}
+
+	// Check the most specific domains first
+	slices.SortFunc(normalized, func(a, b string) int {
+		return len(b) - len(a)
+	})
+
+	return &CookieScope{
+		mode:    mode,
+		domains: normalized,
+	}
+}
+
+// Domain returns the Domain attribute for a cookie issued in response to a
+// request for host. An empty result means the cookie should be host-only.
+func (s *CookieScope) Domain(host string) string {
+	host = normalizeCookieHost(host)
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+
+	for _, domain := range s.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain
+		}
+	}
+
+	if s.mode == CookieScopeHost {
+		return ""
+	}
+
+	// Hosts that are themselves public suffixes, or have no suffix at all
+	// (like localhost), can only be given host-only cookies.
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return ""
+	}
+
+	return domain
+}
+
+// Apply scopes the cookie to suit the request it is being issued for.
+func (s *CookieScope) Apply(r *http.Request, cookie *http.Cookie) {
+	cookie.Domain = s.Domain(r.Host)
+}
+
+// Private
+
+func normalizeCookieHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	host = strings.TrimPrefix(strings.TrimSpace(host), ".")
+	host = strings.TrimSuffix(host, ".")
+	host = strings.Trim(host, "[]")
+
+	return strings.ToLower(host)
+}
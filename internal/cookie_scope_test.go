@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCookieScopeMode(t *testing.T) {
+	mode, err := ParseCookieScopeMode("")
+	require.NoError(t, err)
+	assert.Equal(t, CookieScopeRegistrable, mode)
+
+	mode, err = ParseCookieScopeMode(" Host ")
+	require.NoError(t, err)
+	assert.Equal(t, CookieScopeHost, mode)
+
+	_, err = ParseCookieScopeMode("subdomain")
+	assert.ErrorIs(t, err, ErrInvalidCookieScopeMode)
+}
+
+func TestCookieScope_registrable(t *testing.T) {
+	scope := NewCookieScope(CookieScopeRegistrable, nil)
+
+	tests := map[string]string{
+		"example.com":              "example.com",
+		"www.example.com":          "example.com",
+		"WWW.Example.com:8443":     "example.com",
+		"www.example.co.uk":        "example.co.uk",
+		"example.com.":             "example.com",
+		"tenant.herokuapp.com":     "tenant.herokuapp.com",
+		"app.tenant.herokuapp.com": "tenant.herokuapp.com",
+		"herokuapp.com":            "",
+		"co.uk":                    "",
+		"localhost":                "",
+		"localhost:3000":           "",
+		"192.168.1.10":             "",
+		"[::1]:443":                "",
+		"":                         "",
+	}
+
+	for host, expected := range tests {
+		assert.Equal(t, expected, scope.Domain(host), host)
+	}
+}
+
+func TestCookieScope_host(t *testing.T) {
+	scope := NewCookieScope(CookieScopeHost, nil)
+
+	assert.Equal(t, "", scope.Domain("www.example.com"))
+	assert.Equal(t, "", scope.Domain("example.com"))
+}
+
+func TestCookieScope_configured_domains(t *testing.T) {
+	scope := NewCookieScope(CookieScopeHost, []string{".Example.com", "shop.example.com", "example.org"})
+
+	assert.Equal(t, "example.com", scope.Domain("www.example.com"))
+	assert.Equal(t, "shop.example.com", scope.Domain("eu.shop.example.com"))
+	assert.Equal(t, "example.org", scope.Domain("example.org"))
+	assert.Equal(t, "", scope.Domain("notexample.com"))
+	assert.Equal(t, "", scope.Domain("example.net"))
+}
+
+func TestCookieScope_Apply(t *testing.T) {
+	scope := NewCookieScope(CookieScopeRegistrable, nil)
+
+	r := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	cookie := &http.Cookie{Name: "pass", Value: "1"}
+	scope.Apply(r, cookie)
+
+	assert.Equal(t, "example.com", cookie.Domain)
+}
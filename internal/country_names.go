@@ -0,0 +1,84 @@
+package internal
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+const defaultCountryNameLanguage = "en"
+
+// The names of the ISO 3166-1 countries in a selection of languages, one
+// country per line, with a header line naming the language of each column.
+// Generated from the Debian iso-codes data.
+//
+//go:embed country_names.tsv
+var countryNamesData string
+
+var loadCountryNames = sync.OnceValue(func() map[string]map[string]string {
+	lines := strings.Split(strings.TrimSpace(countryNamesData), "\n")
+	languages := strings.Split(lines[0], "\t")[1:]
+
+	names := map[string]map[string]string{}
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+
+		localized := map[string]string{}
+		for i, name := range fields[1:] {
+			localized[languages[i]] = name
+		}
+		names[fields[0]] = localized
+	}
+
+	return names
+})
+
+// CountryName returns the English name of the country with the given ISO
+// 3166-1 alpha-2 code, or the code itself if it isn't one we know.
+func CountryName(code string) string {
+	return LocalizedCountryName(code, defaultCountryNameLanguage)
+}
+
+// LocalizedCountryName returns the name of a country in the given language,
+// such as `de` or `pt-BR`. Regional languages we don't have fall back to their
+// base language, and languages we don't have at all fall back to English.
+func LocalizedCountryName(code, language string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	localized, ok := loadCountryNames()[code]
+	if !ok {
+		return code
+	}
+
+	language = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+	if name, ok := localized[language]; ok {
+		return name
+	}
+
+	base, _, _ := strings.Cut(language, "-")
+	if name, ok := localized[base]; ok {
+		return name
+	}
+
+	return localized[defaultCountryNameLanguage]
+}
+
+// CountryFlag returns the flag emoji for a country code, which is the pair of
+// regional indicator symbols corresponding to its letters. Anything that
+// isn't a two-letter code has no flag.
+func CountryFlag(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 2 {
+		return ""
+	}
+
+	flag := make([]rune, 0, 2)
+	for _, letter := range code {
+		if letter < 'A' || letter > 'Z' {
+			return ""
+		}
+		flag = append(flag, '🇦'+(letter-'A'))
+	}
+
+	return string(flag)
+}
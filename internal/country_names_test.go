@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountryName(t *testing.T) {
+	assert.Equal(t, "United Kingdom", CountryName("GB"))
+	assert.Equal(t, "Côte d'Ivoire", CountryName(" ci "))
+	assert.Equal(t, "ZZ", CountryName("zz"))
+}
+
+func TestLocalizedCountryName(t *testing.T) {
+	assert.Equal(t, "Deutschland", LocalizedCountryName("DE", "de"))
+	assert.Equal(t, "Deutschland", LocalizedCountryName("DE", "de-AT"))
+	assert.Equal(t, "Alemanha", LocalizedCountryName("DE", "pt_BR"))
+	assert.Equal(t, "德國", LocalizedCountryName("DE", "zh-TW"))
+	assert.Equal(t, "Germany", LocalizedCountryName("DE", "xx"))
+	assert.Equal(t, "Germany", LocalizedCountryName("DE", ""))
+	assert.Equal(t, "ZZ", LocalizedCountryName("ZZ", "de"))
+}
+
+func TestLocalizedCountryName_covers_every_language(t *testing.T) {
+	for code, localized := range loadCountryNames() {
+		assert.Len(t, code, 2)
+		assert.Len(t, localized, 17, code)
+		for language, name := range localized {
+			assert.NotEmpty(t, name, "%s in %s", code, language)
+		}
+	}
+}
+
+func TestCountryFlag(t *testing.T) {
+	assert.Equal(t, "🇬🇧", CountryFlag("GB"))
+	assert.Equal(t, "🇯🇵", CountryFlag("jp"))
+	assert.Equal(t, "", CountryFlag(""))
+	assert.Equal(t, "", CountryFlag("GBR"))
+	assert.Equal(t, "", CountryFlag("1A"))
+}
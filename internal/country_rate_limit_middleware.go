@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CountryRateLimitMiddleware struct {
+	limiter      *RateLimiter
+	logger       *slog.Logger
+	next         http.Handler
+	limits       map[string]RateLimit
+	defaultLimit RateLimit
+}
+
+// NewCountryRateLimitMiddleware limits each country with a bucket in limiter,
+// which, as for NewClientRateLimitMiddleware, may be shared.
+func NewCountryRateLimitMiddleware(logger *slog.Logger, next http.Handler, limits map[string]RateLimit, defaultLimit RateLimit, limiter *RateLimiter) *CountryRateLimitMiddleware {
+	normalized := map[string]RateLimit{}
+	for country, limit := range limits {
+		normalized[strings.ToUpper(country)] = limit
+	}
+
+	return &CountryRateLimitMiddleware{
+		limiter:      limiter,
+		logger:       logger,
+		next:         next,
+		limits:       normalized,
+		defaultLimit: defaultLimit,
+	}
+}
+
+func (m *CountryRateLimitMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	country := strings.ToUpper(CountryFromContext(r.Context()))
+	if country == "" {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	limit := m.limitFor(country)
+	if !limit.Enabled() {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	allowed, wait := m.limiter.Allow(country, limit)
+	if !allowed {
+		m.logger.InfoContext(r.Context(), "Request rate limited - country over limit",
+			"country", country, "path", r.URL.Path, "rate", limit.Rate, "burst", limit.Burst)
+		writeTooManyRequests(w, r, wait, m.ruleFor(country))
+		return
+	}
+
+	m.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (m *CountryRateLimitMiddleware) limitFor(country string) RateLimit {
+	limit, ok := m.limits[country]
+	if ok {
+		return limit
+	}
+	return m.defaultLimit
+}
+
+func (m *CountryRateLimitMiddleware) ruleFor(country string) string {
+	if _, ok := m.limits[country]; ok {
+		return "country_rate_limit:" + country
+	}
+	return "country_rate_limit:*"
+}
+
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, rule string) {
+	tags := RequestTagsFromContext(r.Context())
+	tags.Set(TagBlocked, BlockedByRateLimit)
+	tags.Set(TagBlockedRule, rule)
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
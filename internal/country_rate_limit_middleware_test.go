@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountryRateLimitMiddleware(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	limits := map[string]RateLimit{"gb": {Rate: 1, Burst: 1}}
+	limiter := NewCountryRateLimitMiddleware(slog.Default(), next, limits, RateLimit{Rate: 1, Burst: 2}, NewRateLimiter(nil))
+	handler := NewGeoIPMiddleware(resolver, slog.Default(), limiter, nil, nil, BlockPolicies{})
+
+	request := func(ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("limits a configured country", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("81.2.69.142").Code)
+
+		w := request("2.125.160.216")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("applies the default limit to other countries", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("8.8.8.8").Code)
+		assert.Equal(t, http.StatusOK, request("8.8.8.8").Code)
+		assert.Equal(t, http.StatusTooManyRequests, request("8.8.8.8").Code)
+	})
+
+	t.Run("does not limit requests without a country", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, request("127.0.0.1").Code)
+		}
+	})
+}
+
+func TestCountryRateLimitMiddleware_without_default_limit(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	limits := map[string]RateLimit{"CN": {Rate: 1, Burst: 1}}
+	handler := NewCountryRateLimitMiddleware(slog.Default(), next, limits, RateLimit{}, NewRateLimiter(nil))
+
+	assert.Equal(t, RateLimit{}, handler.limitFor("US"))
+	assert.Equal(t, RateLimit{Rate: 1, Burst: 1}, handler.limitFor("CN"))
+}
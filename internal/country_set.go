@@ -0,0 +1,32 @@
+package internal
+
+import "strings"
+
+// CountrySet is a set of ISO country codes, built once with the handler so
+// that checking a request's country is a single lookup, however many
+// countries are listed, and allocates nothing.
+type CountrySet map[string]struct{}
+
+func NewCountrySet(countries []string) CountrySet {
+	set := make(CountrySet, len(countries))
+	for _, country := range countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if country != "" {
+			set[country] = struct{}{}
+		}
+	}
+	return set
+}
+
+// Contains reports whether the country is in the set, ignoring case. An
+// unknown, empty country is never in it.
+func (s CountrySet) Contains(country string) bool {
+	if len(s) == 0 || country == "" {
+		return false
+	}
+
+	// Codes are almost always upper case already, in which case ToUpper
+	// returns them as they are, without copying
+	_, ok := s[strings.ToUpper(country)]
+	return ok
+}
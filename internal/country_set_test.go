@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountrySet(t *testing.T) {
+	set := NewCountrySet([]string{"US", "gb", " ca ", ""})
+
+	assert.Len(t, set, 3)
+	assert.True(t, set.Contains("US"))
+	assert.True(t, set.Contains("us"))
+	assert.True(t, set.Contains("GB"))
+	assert.True(t, set.Contains("CA"))
+	assert.False(t, set.Contains("FR"))
+	assert.False(t, set.Contains(""))
+}
+
+func TestCountrySet_empty(t *testing.T) {
+	assert.False(t, NewCountrySet(nil).Contains("US"))
+	assert.False(t, CountrySet(nil).Contains("US"))
+}
+
+func TestCountrySet_lookups_do_not_allocate(t *testing.T) {
+	set := NewCountrySet([]string{"US", "GB", "CA"})
+
+	allocs := testing.AllocsPerRun(100, func() {
+		set.Contains("GB")
+		set.Contains("FR")
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkCountrySet_Contains(b *testing.B) {
+	countries := []string{}
+	for first := 'A'; first <= 'Z'; first++ {
+		for second := 'A'; second <= 'Z'; second++ {
+			countries = append(countries, string([]rune{first, second}))
+		}
+	}
+	set := NewCountrySet(countries)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		set.Contains("GB")
+	}
+}
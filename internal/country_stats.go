@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The name that requests from clients we couldn't locate are counted under.
+const countryStatsUnknown = "unknown"
+
+// CountryStats counts the requests from each country: how many were allowed
+// and blocked, the bytes they carried each way, and the classes of status
+// they were answered with. It's meant for answering where traffic is coming
+// from, rather than as a full record of it, so the counts are kept in memory
+// only, from when they were last reset.
+//
+// A nil *CountryStats is valid, and counts nothing.
+type CountryStats struct {
+	sync.Mutex
+	since     time.Time
+	countries map[string]*countryStats
+}
+
+type countryStats struct {
+	allowed  uint64
+	blocked  uint64
+	bytesIn  uint64
+	bytesOut uint64
+	statuses [5]uint64
+}
+
+// CountryStatsSnapshot is the stats of each country since Since, ordered by
+// the number of requests, busiest first.
+type CountryStatsSnapshot struct {
+	Since     time.Time            `json:"since"`
+	Countries []CountryStatsStatus `json:"countries"`
+}
+
+type CountryStatsStatus struct {
+	Country  string            `json:"country"`
+	Requests uint64            `json:"requests"`
+	Allowed  uint64            `json:"allowed"`
+	Blocked  uint64            `json:"blocked"`
+	BytesIn  uint64            `json:"bytes_in"`
+	BytesOut uint64            `json:"bytes_out"`
+	Statuses map[string]uint64 `json:"status"`
+}
+
+func NewCountryStats() *CountryStats {
+	return &CountryStats{
+		since:     time.Now(),
+		countries: map[string]*countryStats{},
+	}
+}
+
+// Record counts a request from the given country, which is empty when the
+// client couldn't be located.
+func (s *CountryStats) Record(country string, blocked bool, status int, bytesIn, bytesOut int64) {
+	if s == nil {
+		return
+	}
+	if country == "" {
+		country = countryStatsUnknown
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	stats, ok := s.countries[country]
+	if !ok {
+		stats = &countryStats{}
+		s.countries[country] = stats
+	}
+
+	if blocked {
+		stats.blocked++
+	} else {
+		stats.allowed++
+	}
+	stats.bytesIn += uint64(max(bytesIn, 0))
+	stats.bytesOut += uint64(max(bytesOut, 0))
+
+	if class := status / 100; class >= 1 && class <= 5 {
+		stats.statuses[class-1]++
+	}
+}
+
+// Snapshot returns the stats counted so far.
+func (s *CountryStats) Snapshot() CountryStatsSnapshot {
+	if s == nil {
+		return CountryStatsSnapshot{Countries: []CountryStatsStatus{}}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.snapshot()
+}
+
+// Reset starts the counts again from nothing, returning the stats as they
+// were just before.
+func (s *CountryStats) Reset() CountryStatsSnapshot {
+	if s == nil {
+		return CountryStatsSnapshot{Countries: []CountryStatsStatus{}}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	snapshot := s.snapshot()
+	s.since = time.Now()
+	s.countries = map[string]*countryStats{}
+
+	return snapshot
+}
+
+// Private
+
+func (s *CountryStats) snapshot() CountryStatsSnapshot {
+	countries := []CountryStatsStatus{}
+	for country, stats := range s.countries {
+		statuses := map[string]uint64{}
+		for i, count := range stats.statuses {
+			if count > 0 {
+				statuses[strconv.Itoa(i+1)+"xx"] = count
+			}
+		}
+
+		countries = append(countries, CountryStatsStatus{
+			Country:  country,
+			Requests: stats.allowed + stats.blocked,
+			Allowed:  stats.allowed,
+			Blocked:  stats.blocked,
+			BytesIn:  stats.bytesIn,
+			BytesOut: stats.bytesOut,
+			Statuses: statuses,
+		})
+	}
+
+	slices.SortFunc(countries, func(a, b CountryStatsStatus) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(a.Country, b.Country))
+	})
+
+	return CountryStatsSnapshot{Since: s.since, Countries: countries}
+}
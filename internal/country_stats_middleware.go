@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"net/http"
+)
+
+// CountryStatsMiddleware records each request in the per-country stats, once
+// the later stages have located the client and decided what to do with it.
+type CountryStatsMiddleware struct {
+	stats *CountryStats
+	next  http.Handler
+}
+
+func NewCountryStatsMiddleware(stats *CountryStats, next http.Handler) *CountryStatsMiddleware {
+	return &CountryStatsMiddleware{
+		stats: stats,
+		next:  next,
+	}
+}
+
+func (m *CountryStatsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, tags := WithRequestTags(r)
+	writer := newResponseWriter(w)
+
+	m.next.ServeHTTP(writer, r)
+
+	m.stats.Record(tags.Get(TagCountry), tags.Get(TagBlocked) != "", writer.statusCode, r.ContentLength, writer.bytesWritten)
+}
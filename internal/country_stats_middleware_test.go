@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountryStatsMiddleware(t *testing.T) {
+	stats := NewCountryStats()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tags := RequestTagsFromContext(r.Context())
+		if r.URL.Path == "/unknown" {
+			w.Write([]byte("?"))
+			return
+		}
+
+		tags.Set(TagCountry, "RU")
+		if r.URL.Path == "/admin" {
+			tags.Set(TagBlocked, BlockedByCountry)
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	middleware := NewCountryStatsMiddleware(stats, next)
+
+	serve := func(method, path, body string) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		middleware.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve("POST", "/", "hello")
+	serve("GET", "/admin", "")
+	serve("GET", "/unknown", "")
+
+	assert.Equal(t, []CountryStatsStatus{
+		{Country: "RU", Requests: 2, Allowed: 1, Blocked: 1, BytesIn: 5, BytesOut: 16, Statuses: map[string]uint64{"2xx": 1, "4xx": 1}},
+		{Country: "unknown", Requests: 1, Allowed: 1, BytesOut: 1, Statuses: map[string]uint64{"2xx": 1}},
+	}, stats.Snapshot().Countries)
+}
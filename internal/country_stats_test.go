@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountryStats(t *testing.T) {
+	stats := NewCountryStats()
+
+	stats.Record("GB", false, http.StatusOK, 0, 100)
+	stats.Record("GB", false, http.StatusNotFound, 20, 10)
+	stats.Record("GB", true, http.StatusForbidden, -1, 5)
+	stats.Record("SE", false, http.StatusFound, 0, 0)
+	stats.Record("", false, http.StatusBadGateway, 0, 50)
+	stats.Record("", true, http.StatusTooManyRequests, 0, 0)
+
+	snapshot := stats.Snapshot()
+	assert.WithinDuration(t, time.Now(), snapshot.Since, time.Minute)
+	assert.Equal(t, []CountryStatsStatus{
+		{Country: "GB", Requests: 3, Allowed: 2, Blocked: 1, BytesIn: 20, BytesOut: 115, Statuses: map[string]uint64{"2xx": 1, "4xx": 2}},
+		{Country: "unknown", Requests: 2, Allowed: 1, Blocked: 1, BytesOut: 50, Statuses: map[string]uint64{"4xx": 1, "5xx": 1}},
+		{Country: "SE", Requests: 1, Allowed: 1, Statuses: map[string]uint64{"3xx": 1}},
+	}, snapshot.Countries)
+}
+
+func TestCountryStats_Reset(t *testing.T) {
+	stats := NewCountryStats()
+	stats.Record("GB", false, http.StatusOK, 0, 100)
+	before := stats.Snapshot().Since
+
+	snapshot := stats.Reset()
+	require.Len(t, snapshot.Countries, 1)
+	assert.Equal(t, "GB", snapshot.Countries[0].Country)
+	assert.Equal(t, before, snapshot.Since)
+
+	snapshot = stats.Snapshot()
+	assert.Empty(t, snapshot.Countries)
+	assert.False(t, snapshot.Since.Before(before))
+}
+
+func TestCountryStats_nil(t *testing.T) {
+	var stats *CountryStats
+
+	stats.Record("GB", false, http.StatusOK, 0, 100)
+	assert.Empty(t, stats.Snapshot().Countries)
+	assert.Empty(t, stats.Reset().Countries)
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"sync"
+)
+
+const decisionWatcherQueueSize = 1000
+
+// Decisions passes each request we refuse on to those watching, such as
+// clients of the admin gRPC API. A watcher that falls behind misses records,
+// rather than holding up the requests being refused.
+//
+// A nil *Decisions is valid, and passes nothing on.
+type Decisions struct {
+	sync.Mutex
+	watchers map[chan AuditRecord]struct{}
+}
+
+func NewDecisions() *Decisions {
+	return &Decisions{watchers: map[chan AuditRecord]struct{}{}}
+}
+
+// Publish passes a record on to everyone watching.
+func (d *Decisions) Publish(record AuditRecord) {
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for watcher := range d.watchers {
+		select {
+		case watcher <- record:
+		default:
+		}
+	}
+}
+
+// Watch returns a channel of the records published from now on, and a
+// function to stop watching, which closes it.
+func (d *Decisions) Watch() (<-chan AuditRecord, func()) {
+	watcher := make(chan AuditRecord, decisionWatcherQueueSize)
+
+	d.Lock()
+	d.watchers[watcher] = struct{}{}
+	d.Unlock()
+
+	stop := func() {
+		d.Lock()
+		defer d.Unlock()
+
+		if _, ok := d.watchers[watcher]; ok {
+			delete(d.watchers, watcher)
+			close(watcher)
+		}
+	}
+
+	return watcher, stop
+}
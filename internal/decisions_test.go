@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisions(t *testing.T) {
+	decisions := NewDecisions()
+
+	first, stopFirst := decisions.Watch()
+	second, stopSecond := decisions.Watch()
+	defer stopSecond()
+
+	decisions.Publish(AuditRecord{IP: "192.0.2.1"})
+	assert.Equal(t, "192.0.2.1", (<-first).IP)
+	assert.Equal(t, "192.0.2.1", (<-second).IP)
+
+	stopFirst()
+	stopFirst()
+
+	decisions.Publish(AuditRecord{IP: "192.0.2.2"})
+	_, open := <-first
+	assert.False(t, open)
+	assert.Equal(t, "192.0.2.2", (<-second).IP)
+}
+
+func TestDecisions_drops_records_for_watchers_that_fall_behind(t *testing.T) {
+	decisions := NewDecisions()
+
+	records, stop := decisions.Watch()
+	defer stop()
+
+	for range decisionWatcherQueueSize + 10 {
+		decisions.Publish(AuditRecord{IP: "192.0.2.1"})
+	}
+
+	assert.Len(t, records, decisionWatcherQueueSize)
+}
+
+func TestDecisions_nil(t *testing.T) {
+	var decisions *Decisions
+	decisions.Publish(AuditRecord{IP: "192.0.2.1"})
+}
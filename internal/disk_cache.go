@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	diskCacheHeaderSize = 8
+	diskCacheTempPrefix = ".tmp-"
+)
+
+type diskCacheEntry struct {
+	index          int
+	size           int
+	expiresAt      time.Time
+	lastAccessedAt time.Time
+	accesses       int
+}
+
+// DiskCache keeps each item in its own file, so that the cache survives a
+// restart. Each file holds the item's expiry followed by its value. An index
+// of the files is kept in memory to stay within capacity, and is rebuilt from
+// the directory when the cache is opened.
+type DiskCache struct {
+	sync.Mutex
+	path           string
+	capacity       int
+	maxItemSize    int
+	maxEntries     int
+	eviction       CacheEviction
+	size           int
+	keys           []CacheKey
+	items          map[CacheKey]*diskCacheEntry
+	stats          *CacheStats
+	getCurrentTime GetCurrentTime
+}
+
+func NewDiskCache(path string, capacity, maxItemSize int) (*DiskCache, error) {
+	err := os.MkdirAll(path, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		path:           path,
+		capacity:       capacity,
+		maxItemSize:    maxItemSize,
+		eviction:       CacheEvictionLRU,
+		items:          map[CacheKey]*diskCacheEntry{},
+		getCurrentTime: time.Now,
+	}
+
+	err = c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SetStats makes the cache report its evictions and size.
+func (c *DiskCache) SetStats(stats *CacheStats) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stats = stats
+	c.stats.SetStored(c.size, len(c.keys))
+}
+
+// SetMaxEntries limits the number of items in the cache, regardless of their
+// size, evicting items to make room. Zero means no limit.
+func (c *DiskCache) SetMaxEntries(maxEntries int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.maxEntries = maxEntries
+	for c.maxEntries > 0 && len(c.keys) > c.maxEntries {
+		c.evictItem()
+	}
+}
+
+// SetEviction sets how the cache chooses items to evict.
+func (c *DiskCache) SetEviction(eviction CacheEviction) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.eviction = eviction
+}
+
+func (c *DiskCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
+	itemSize := len(value)
+	if itemSize > c.maxItemSize || itemSize > c.capacity {
+		slog.Debug("Cache: item is too large to store", "len", itemSize)
+		return
+	}
+
+	// Write the file aside first, so that readers never see a partial item
+	temp, err := c.writeTemp(value, expiresAt)
+	if err != nil {
+		slog.Error("Cache: unable to write item to disk", "key", key, "error", err)
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(key)
+
+	limit := c.capacity - itemSize
+	for c.size > limit {
+		slog.Debug("Cache: evicting item to make space", "current_size", c.size, "need_size", limit)
+		c.evictItem()
+	}
+
+	for c.maxEntries > 0 && len(c.keys) >= c.maxEntries {
+		slog.Debug("Cache: evicting item to make room", "entries", len(c.keys), "max_entries", c.maxEntries)
+		c.evictItem()
+	}
+
+	err = os.Rename(temp, c.filePath(key))
+	if err != nil {
+		os.Remove(temp)
+		slog.Error("Cache: unable to write item to disk", "key", key, "error", err)
+		return
+	}
+
+	c.add(key, &diskCacheEntry{size: itemSize, expiresAt: expiresAt, lastAccessedAt: c.getCurrentTime()})
+
+	slog.Debug("Cache: added item", "key", key, "size", itemSize, "expires_at", expiresAt)
+}
+
+func (c *DiskCache) Get(key CacheKey) ([]byte, bool) {
+	c.Lock()
+	now := c.getCurrentTime()
+	item, ok := c.items[key]
+	if ok && !item.expiresAt.Before(now) {
+		item.lastAccessedAt = now
+		item.accesses++
+	}
+	c.Unlock()
+
+	if !ok || item.expiresAt.Before(now) {
+		return nil, false
+	}
+
+	// The item may be evicted while we read it, in which case it's a miss
+	content, err := os.ReadFile(c.filePath(key))
+	if err != nil || len(content) < diskCacheHeaderSize {
+		return nil, false
+	}
+
+	return content[diskCacheHeaderSize:], true
+}
+
+// Close leaves the items on disk, for the next time the cache is opened.
+func (c *DiskCache) Close() error {
+	return nil
+}
+
+// Private
+
+func (c *DiskCache) load() error {
+	entries, err := os.ReadDir(c.path)
+	if err != nil {
+		return err
+	}
+
+	now := c.getCurrentTime()
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(c.path, name)
+
+		if strings.HasPrefix(name, diskCacheTempPrefix) {
+			os.Remove(path)
+			continue
+		}
+
+		key, err := strconv.ParseUint(name, 16, 64)
+		if err != nil || entry.IsDir() {
+			continue
+		}
+
+		expiresAt, size, err := readDiskCacheHeader(path)
+		if err != nil || expiresAt.Before(now) || size > c.maxItemSize || c.size+size > c.capacity {
+			os.Remove(path)
+			continue
+		}
+
+		c.add(CacheKey(key), &diskCacheEntry{size: size, expiresAt: expiresAt, lastAccessedAt: now})
+	}
+
+	slog.Debug("Cache: loaded items from disk", "path", c.path, "items", len(c.keys), "size", c.size)
+	return nil
+}
+
+func (c *DiskCache) writeTemp(value []byte, expiresAt time.Time) (string, error) {
+	file, err := os.CreateTemp(c.path, diskCacheTempPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	header := make([]byte, diskCacheHeaderSize)
+	binary.BigEndian.PutUint64(header, uint64(expiresAt.UnixNano()))
+
+	_, err = file.Write(header)
+	if err == nil {
+		_, err = file.Write(value)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+func (c *DiskCache) filePath(key CacheKey) string {
+	return filepath.Join(c.path, fmt.Sprintf("%016x", uint64(key)))
+}
+
+func (c *DiskCache) add(key CacheKey, entry *diskCacheEntry) {
+	entry.index = len(c.keys)
+	c.keys = append(c.keys, key)
+	c.items[key] = entry
+	c.size += entry.size
+	c.stats.SetStored(c.size, len(c.keys))
+}
+
+func (c *DiskCache) remove(key CacheKey) {
+	entry, ok := c.items[key]
+	if !ok {
+		return
+	}
+
+	last := c.keys[len(c.keys)-1]
+	c.keys[entry.index] = last
+	c.items[last].index = entry.index
+	c.keys = c.keys[:len(c.keys)-1]
+
+	c.size -= entry.size
+	delete(c.items, key)
+	os.Remove(c.filePath(key))
+	c.stats.SetStored(c.size, len(c.keys))
+}
+
+// evictItem picks an item to evict in the same way as the memory cache: the
+// one the eviction policy likes least out of a small random sample, or the
+// first expired item found.
+func (c *DiskCache) evictItem() {
+	var evictKey CacheKey
+	var evict *diskCacheEntry
+
+	now := c.getCurrentTime()
+
+	for i := 0; i < cacheEvictionSampleSize; i++ {
+		key := c.keys[rand.Intn(len(c.keys))]
+		v := c.items[key]
+
+		if v.expiresAt.Before(now) {
+			evictKey = key
+			break
+		}
+
+		if evict == nil || c.eviction.prefers(v.lastAccessedAt, v.accesses, evict.lastAccessedAt, evict.accesses) {
+			evict = v
+			evictKey = key
+		}
+	}
+
+	c.remove(evictKey)
+	c.stats.Evicted()
+}
+
+func readDiskCacheHeader(path string) (time.Time, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	header := make([]byte, diskCacheHeaderSize)
+	_, err = io.ReadFull(file, header)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+	return expiresAt, int(info.Size()) - diskCacheHeaderSize, nil
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCache_storing_and_retrieving(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 32*KB, 1*KB)
+	require.NoError(t, err)
+
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+
+	c.Set(1, []byte("hello world"), time.Now().Add(1*time.Hour))
+
+	value, ok := c.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("hello world"), value)
+
+	c.Set(1, []byte("replaced"), time.Now().Add(1*time.Hour))
+
+	value, ok = c.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("replaced"), value)
+	assert.Equal(t, len("replaced"), c.size)
+}
+
+func TestDiskCache_items_survive_reopening(t *testing.T) {
+	path := t.TempDir()
+
+	c, err := NewDiskCache(path, 32*KB, 1*KB)
+	require.NoError(t, err)
+	c.Set(1, []byte("persisted"), time.Now().Add(1*time.Hour))
+	c.Set(2, []byte("expired"), time.Now().Add(50*time.Millisecond))
+	require.NoError(t, c.Close())
+
+	time.Sleep(100 * time.Millisecond)
+
+	c, err = NewDiskCache(path, 32*KB, 1*KB)
+	require.NoError(t, err)
+
+	value, ok := c.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("persisted"), value)
+
+	_, ok = c.Get(2)
+	assert.False(t, ok)
+	assert.NoFileExists(t, c.filePath(2))
+}
+
+func TestDiskCache_ignores_unrelated_and_partial_files(t *testing.T) {
+	path := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(path, "README"), []byte("hi"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(path, diskCacheTempPrefix+"123"), []byte("partial"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "0000000000000001"), []byte("abc"), 0600))
+
+	c, err := NewDiskCache(path, 32*KB, 1*KB)
+	require.NoError(t, err)
+
+	assert.Empty(t, c.keys)
+	assert.FileExists(t, filepath.Join(path, "README"))
+	assert.NoFileExists(t, filepath.Join(path, diskCacheTempPrefix+"123"))
+}
+
+func TestDiskCache_expiry(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 32*KB, 1*KB)
+	require.NoError(t, err)
+
+	c.Set(1, []byte("hello world"), time.Now().Add(-1*time.Second))
+
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+}
+
+func TestDiskCache_items_are_evicted_to_make_space(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 3*KB, 1*KB)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		c.Set(CacheKey(i), make([]byte, 1*KB), time.Now().Add(1*time.Hour))
+	}
+
+	assert.Equal(t, 3, len(c.keys))
+	assert.Equal(t, 3*KB, c.size)
+
+	files, err := os.ReadDir(c.path)
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+}
+
+func TestDiskCache_reports_stats(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 3*KB, 1*KB)
+	require.NoError(t, err)
+
+	stats := NewCacheStats()
+	c.SetStats(stats)
+
+	for i := 0; i < 5; i++ {
+		c.Set(CacheKey(i), make([]byte, 1*KB), time.Now().Add(1*time.Hour))
+	}
+
+	assert.Equal(t, uint64(2), stats.Snapshot().Evictions)
+	assert.Equal(t, int64(3*KB), stats.Snapshot().StoredBytes)
+
+	reopened, err := NewDiskCache(dir, 3*KB, 1*KB)
+	require.NoError(t, err)
+
+	reopenedStats := NewCacheStats()
+	reopened.SetStats(reopenedStats)
+	assert.Equal(t, int64(3*KB), reopenedStats.Snapshot().StoredBytes)
+	assert.Equal(t, int64(3), reopenedStats.Snapshot().Entries)
+}
+
+func TestDiskCache_items_are_evicted_to_stay_within_max_entries(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 1*MB, 1*KB)
+	require.NoError(t, err)
+	c.SetMaxEntries(3)
+
+	for i := 0; i < 5; i++ {
+		c.Set(CacheKey(i), []byte("value"), time.Now().Add(1*time.Hour))
+	}
+	assert.Len(t, c.keys, 3)
+
+	// A smaller limit applies to the items already on disk
+	reopened, err := NewDiskCache(dir, 1*MB, 1*KB)
+	require.NoError(t, err)
+	reopened.SetMaxEntries(2)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestDiskCache_does_not_store_items_over_item_limit(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 32*KB, 1*KB)
+	require.NoError(t, err)
+
+	c.Set(1, make([]byte, 2*KB), time.Now().Add(1*time.Hour))
+
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+}
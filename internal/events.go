@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of event sent to webhooks.
+const (
+	EventRequestBlocked   = "request_blocked"
+	EventClientBanned     = "client_banned"
+	EventDatabaseLoaded   = "database_loaded"
+	EventBlocklistUpdated = "blocklist_updated"
+	EventCircuitOpened    = "circuit_opened"
+	EventCircuitClosed    = "circuit_closed"
+)
+
+var EventTypes = []string{
+	EventRequestBlocked,
+	EventClientBanned,
+	EventDatabaseLoaded,
+	EventBlocklistUpdated,
+	EventCircuitOpened,
+	EventCircuitClosed,
+}
+
+const (
+	eventQueueSize        = 10000
+	eventWebhookTimeout   = 10 * time.Second
+	eventWebhookAttempts  = 4
+	eventWebhookBackoff   = time.Second
+	eventSignatureHeader  = "X-Thruster-Signature"
+	eventStopFlushTimeout = 5 * time.Second
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("webhook must be an http or https URL")
+	ErrInvalidEventType  = fmt.Errorf("event type must be one of %s", strings.Join(EventTypes, ", "))
+)
+
+// Event is something that happened that others may want to hear about, such
+// as a client being banned.
+type Event struct {
+	Type string         `json:"type"`
+	Time time.Time      `json:"time"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+type eventBatch struct {
+	Events []Event `json:"events"`
+}
+
+func ParseWebhookURLs(values []string) ([]*url.URL, error) {
+	urls := []*url.URL{}
+
+	for _, value := range values {
+		webhookURL, err := url.Parse(value)
+		if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidWebhookURL, value)
+		}
+		urls = append(urls, webhookURL)
+	}
+
+	return urls, nil
+}
+
+func ParseEventTypes(values []string) ([]string, error) {
+	types := []string{}
+
+	for _, value := range values {
+		eventType := strings.ToLower(strings.TrimSpace(value))
+		if !slices.Contains(EventTypes, eventType) {
+			return nil, fmt.Errorf("%w, not %q", ErrInvalidEventType, value)
+		}
+		types = append(types, eventType)
+	}
+
+	return types, nil
+}
+
+// EventDispatcher sends events to webhooks in the background, so that security
+// tooling can be told about them without having to follow the logs. Events are
+// collected into batches of up to batchSize, sent at least every
+// flushInterval, and retried with backoff when a webhook fails.
+//
+// Publishing never blocks. When events arrive faster than the webhooks can
+// take them, and the queue fills up, further events are dropped, and the
+// number dropped is logged.
+//
+// A nil *EventDispatcher is valid, and sends nothing.
+type EventDispatcher struct {
+	urls          []*url.URL
+	types         []string
+	secret        string
+	batchSize     int
+	flushInterval time.Duration
+	backoff       time.Duration
+	client        *http.Client
+
+	queue   chan Event
+	dropped atomic.Uint64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEventDispatcher returns a dispatcher sending events of the given types to
+// the webhooks, or every type when none are given. When there's a secret, the
+// body of each request is signed with it.
+func NewEventDispatcher(urls []*url.URL, types []string, secret string, batchSize int, flushInterval time.Duration) *EventDispatcher {
+	return &EventDispatcher{
+		urls:          urls,
+		types:         types,
+		secret:        secret,
+		batchSize:     max(batchSize, 1),
+		flushInterval: flushInterval,
+		backoff:       eventWebhookBackoff,
+		client:        &http.Client{Timeout: eventWebhookTimeout},
+		queue:         make(chan Event, eventQueueSize),
+	}
+}
+
+// Publish queues an event to be sent.
+func (d *EventDispatcher) Publish(eventType string, data map[string]any) {
+	if d == nil || (len(d.types) > 0 && !slices.Contains(d.types, eventType)) {
+		return
+	}
+
+	select {
+	case d.queue <- Event{Type: eventType, Time: time.Now().UTC(), Data: data}:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// Start begins sending events in the background, until stopped.
+func (d *EventDispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	d.wg.Add(1)
+	go d.run(ctx)
+
+	slog.Info("Sending events to webhooks", "webhooks", len(d.urls), "batch_size", d.batchSize, "flush_interval", d.flushInterval.String())
+}
+
+// Stop sends any events still queued, and stops.
+func (d *EventDispatcher) Stop() error {
+	if d.cancel != nil {
+		d.cancel()
+		d.wg.Wait()
+	}
+	return nil
+}
+
+// Private
+
+func (d *EventDispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+
+	batch := []Event{}
+	flush := func(ctx context.Context) {
+		d.reportDropped()
+		if len(batch) > 0 {
+			d.send(ctx, batch)
+			batch = []Event{}
+		}
+	}
+
+	for {
+		select {
+		case event := <-d.queue:
+			batch = append(batch, event)
+			if len(batch) >= d.batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			d.drain(&batch)
+
+			ctx, cancel := context.WithTimeout(context.Background(), eventStopFlushTimeout)
+			defer cancel()
+			for len(batch) > 0 {
+				size := min(len(batch), d.batchSize)
+				d.send(ctx, batch[:size])
+				batch = batch[size:]
+			}
+			d.reportDropped()
+			return
+		}
+	}
+}
+
+func (d *EventDispatcher) drain(batch *[]Event) {
+	for {
+		select {
+		case event := <-d.queue:
+			*batch = append(*batch, event)
+		default:
+			return
+		}
+	}
+}
+
+func (d *EventDispatcher) reportDropped() {
+	if dropped := d.dropped.Swap(0); dropped > 0 {
+		slog.Warn("Webhook: event queue full; events dropped", "count", dropped)
+	}
+}
+
+func (d *EventDispatcher) send(ctx context.Context, batch []Event) {
+	body, err := json.Marshal(eventBatch{Events: batch})
+	if err != nil {
+		slog.Error("Webhook: unable to encode events", "error", err)
+		return
+	}
+
+	for _, webhookURL := range d.urls {
+		err := d.deliver(ctx, webhookURL, body)
+		if err != nil {
+			slog.Warn("Webhook: unable to send events; dropping them", "url", webhookURL.Redacted(), "events", len(batch), "error", err)
+		}
+	}
+}
+
+// deliver posts the body to a webhook, retrying with exponential backoff when
+// it can't be reached, or answers with an error that may not last.
+func (d *EventDispatcher) deliver(ctx context.Context, webhookURL *url.URL, body []byte) error {
+	var err error
+	backoff := d.backoff
+
+	for attempt := 1; attempt <= eventWebhookAttempts; attempt++ {
+		var retry bool
+		retry, err = d.post(ctx, webhookURL, body)
+		if err == nil || !retry || attempt == eventWebhookAttempts {
+			return err
+		}
+
+		slog.Debug("Webhook: retrying", "url", webhookURL.Redacted(), "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
+
+func (d *EventDispatcher) post(ctx context.Context, webhookURL *url.URL, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Thruster")
+	if d.secret != "" {
+		req.Header.Set(eventSignatureHeader, "sha256="+signEvents(d.secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+}
+
+// signEvents returns the hex HMAC-SHA256 of a body, for webhooks to check
+// that events came from us.
+func signEvents(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
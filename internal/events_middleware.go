@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"net/http"
+)
+
+// The request tags included in blocked request events, by their names there.
+var eventRequestTags = map[string]string{
+	"country":    TagCountry,
+	"rule":       TagBlockedRule,
+	"asn":        TagASN,
+	"risk_score": TagRiskScore,
+	"request_id": TagRequestID,
+}
+
+// EventsMiddleware publishes an event for every request refused by a later
+// stage, with what we know about the client and why it was refused.
+type EventsMiddleware struct {
+	events *EventDispatcher
+	next   http.Handler
+}
+
+func NewEventsMiddleware(events *EventDispatcher, next http.Handler) *EventsMiddleware {
+	return &EventsMiddleware{
+		events: events,
+		next:   next,
+	}
+}
+
+func (m *EventsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, tags := WithRequestTags(r)
+	writer := newResponseWriter(w)
+
+	m.next.ServeHTTP(writer, r)
+
+	reason := tags.Get(TagBlocked)
+	if reason == "" {
+		return
+	}
+
+	host, _ := clientIP(r)
+	data := map[string]any{
+		"ip":     host,
+		"method": r.Method,
+		"host":   r.Host,
+		"path":   r.URL.Path,
+		"status": writer.statusCode,
+		"reason": reason,
+	}
+	for key, tag := range eventRequestTags {
+		if value := tags.Get(tag); value != "" {
+			data[key] = value
+		}
+	}
+
+	m.events.Publish(EventRequestBlocked, data)
+}
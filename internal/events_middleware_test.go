@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsMiddleware(t *testing.T) {
+	dispatcher := NewEventDispatcher(nil, nil, "", 100, 0)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tags := RequestTagsFromContext(r.Context())
+		tags.Set(TagCountry, "RU")
+		if r.URL.Path == "/admin" {
+			tags.Set(TagBlocked, BlockedByCountry)
+			tags.Set(TagBlockedRule, "block_country:RU")
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	middleware := NewEventsMiddleware(dispatcher, next)
+
+	serve := func(path string) {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		middleware.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve("/")
+	serve("/admin")
+
+	require.Len(t, dispatcher.queue, 1)
+	event := <-dispatcher.queue
+
+	assert.Equal(t, EventRequestBlocked, event.Type)
+	assert.Equal(t, map[string]any{
+		"ip":      "192.0.2.1",
+		"method":  "GET",
+		"host":    "example.com",
+		"path":    "/admin",
+		"status":  http.StatusForbidden,
+		"reason":  BlockedByCountry,
+		"country": "RU",
+		"rule":    "block_country:RU",
+	}, event.Data)
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testWebhook struct {
+	sync.Mutex
+	server     *httptest.Server
+	batches    [][]Event
+	signatures []string
+	statuses   []int
+}
+
+func newTestWebhook(t *testing.T, statuses ...int) *testWebhook {
+	webhook := &testWebhook{statuses: statuses}
+	webhook.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhook.Lock()
+		defer webhook.Unlock()
+
+		if len(webhook.statuses) > 0 {
+			status := webhook.statuses[0]
+			webhook.statuses = webhook.statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		var batch eventBatch
+		json.Unmarshal(body, &batch)
+
+		webhook.batches = append(webhook.batches, batch.Events)
+		webhook.signatures = append(webhook.signatures, r.Header.Get(eventSignatureHeader))
+	}))
+	t.Cleanup(webhook.server.Close)
+
+	return webhook
+}
+
+func (w *testWebhook) url() *url.URL {
+	u, _ := url.Parse(w.server.URL + "/events")
+	return u
+}
+
+func (w *testWebhook) received() [][]Event {
+	w.Lock()
+	defer w.Unlock()
+	return append([][]Event{}, w.batches...)
+}
+
+func TestParseWebhookURLs(t *testing.T) {
+	urls, err := ParseWebhookURLs([]string{"https://soc.example.com/hooks/thruster"})
+	require.NoError(t, err)
+	assert.Equal(t, "soc.example.com", urls[0].Host)
+
+	for _, value := range []string{"soc.example.com", "ftp://soc.example.com", "https://"} {
+		_, err := ParseWebhookURLs([]string{value})
+		assert.ErrorIs(t, err, ErrInvalidWebhookURL, value)
+	}
+}
+
+func TestParseEventTypes(t *testing.T) {
+	types, err := ParseEventTypes([]string{"client_banned", " Circuit_Opened"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{EventClientBanned, EventCircuitOpened}, types)
+
+	_, err = ParseEventTypes([]string{"request_allowed"})
+	assert.ErrorIs(t, err, ErrInvalidEventType)
+}
+
+func TestEventDispatcher_sends_events_in_batches(t *testing.T) {
+	webhook := newTestWebhook(t)
+	dispatcher := NewEventDispatcher([]*url.URL{webhook.url()}, nil, "", 2, time.Hour)
+	dispatcher.Start()
+
+	dispatcher.Publish(EventClientBanned, map[string]any{"ip": "192.0.2.1"})
+	dispatcher.Publish(EventClientBanned, map[string]any{"ip": "192.0.2.2"})
+	dispatcher.Publish(EventCircuitOpened, nil)
+
+	assert.Eventually(t, func() bool { return len(webhook.received()) == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, dispatcher.Stop())
+
+	batches := webhook.received()
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Equal(t, EventClientBanned, batches[0][0].Type)
+	assert.Equal(t, "192.0.2.1", batches[0][0].Data["ip"])
+	assert.False(t, batches[0][0].Time.IsZero())
+	assert.Equal(t, EventCircuitOpened, batches[1][0].Type)
+}
+
+func TestEventDispatcher_flushes_every_interval(t *testing.T) {
+	webhook := newTestWebhook(t)
+	dispatcher := NewEventDispatcher([]*url.URL{webhook.url()}, nil, "", 100, 10*time.Millisecond)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	dispatcher.Publish(EventClientBanned, nil)
+
+	assert.Eventually(t, func() bool { return len(webhook.received()) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestEventDispatcher_only_sends_chosen_types(t *testing.T) {
+	webhook := newTestWebhook(t)
+	dispatcher := NewEventDispatcher([]*url.URL{webhook.url()}, []string{EventClientBanned}, "", 100, time.Hour)
+	dispatcher.Start()
+
+	dispatcher.Publish(EventRequestBlocked, nil)
+	dispatcher.Publish(EventClientBanned, nil)
+	require.NoError(t, dispatcher.Stop())
+
+	batches := webhook.received()
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 1)
+	assert.Equal(t, EventClientBanned, batches[0][0].Type)
+}
+
+func TestEventDispatcher_signs_requests(t *testing.T) {
+	webhook := newTestWebhook(t)
+	dispatcher := NewEventDispatcher([]*url.URL{webhook.url()}, nil, "s3cret", 100, time.Hour)
+	dispatcher.Start()
+
+	dispatcher.Publish(EventClientBanned, nil)
+	require.NoError(t, dispatcher.Stop())
+
+	webhook.Lock()
+	defer webhook.Unlock()
+	require.Len(t, webhook.signatures, 1)
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, webhook.signatures[0])
+}
+
+func TestEventDispatcher_retries_failures_that_may_pass(t *testing.T) {
+	webhook := newTestWebhook(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	dispatcher := NewEventDispatcher([]*url.URL{webhook.url()}, nil, "", 100, time.Hour)
+	dispatcher.backoff = time.Millisecond
+	dispatcher.Start()
+
+	dispatcher.Publish(EventClientBanned, nil)
+	require.NoError(t, dispatcher.Stop())
+
+	assert.Len(t, webhook.received(), 1)
+}
+
+func TestEventDispatcher_does_not_retry_rejected_events(t *testing.T) {
+	webhook := newTestWebhook(t, http.StatusBadRequest, http.StatusOK)
+	dispatcher := NewEventDispatcher([]*url.URL{webhook.url()}, nil, "", 100, time.Hour)
+	dispatcher.backoff = time.Millisecond
+	dispatcher.Start()
+
+	dispatcher.Publish(EventClientBanned, nil)
+	require.NoError(t, dispatcher.Stop())
+
+	assert.Empty(t, webhook.received())
+}
+
+func TestEventDispatcher_drops_events_when_queue_is_full(t *testing.T) {
+	dispatcher := NewEventDispatcher(nil, nil, "", 100, time.Hour)
+
+	for range eventQueueSize + 5 {
+		dispatcher.Publish(EventRequestBlocked, nil)
+	}
+
+	assert.Equal(t, uint64(5), dispatcher.dropped.Load())
+}
+
+func TestEventDispatcher_nil_is_safe(t *testing.T) {
+	var dispatcher *EventDispatcher
+	dispatcher.Publish(EventClientBanned, nil)
+}
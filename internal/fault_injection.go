@@ -0,0 +1,107 @@
+//go:build chaos
+
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Fault injection is only compiled into builds made with `-tags chaos`. It
+// lets operators check that their fail-open/fail-closed, timeout, and retry
+// settings behave as intended when dependencies misbehave.
+
+var ErrInjectedFault = errors.New("injected fault")
+
+type faultInjection struct {
+	lookupErrorRate   float64
+	upstreamDelay     time.Duration
+	upstreamDelayRate float64
+	partialWriteRate  float64
+}
+
+var activeFaults = readFaultInjection()
+
+// readFaultInjection reads the faults to inject from the environment only, as
+// they're set up before any config file is read.
+func readFaultInjection() faultInjection {
+	env := &configEnv{}
+	faults := faultInjection{
+		lookupErrorRate:   env.getFloat("CHAOS_LOOKUP_ERROR_RATE", 0),
+		upstreamDelay:     time.Duration(env.getInt("CHAOS_UPSTREAM_DELAY_MS", 0)) * time.Millisecond,
+		upstreamDelayRate: env.getFloat("CHAOS_UPSTREAM_DELAY_RATE", 1),
+		partialWriteRate:  env.getFloat("CHAOS_PARTIAL_WRITE_RATE", 0),
+	}
+
+	err := env.err()
+	if err != nil {
+		slog.Error("Invalid fault injection settings; using the defaults for them", "error", err)
+	}
+
+	return faults
+}
+
+// injectLookupFault returns an error in place of a database lookup at the
+// configured rate.
+func injectLookupFault() error {
+	if chance(activeFaults.lookupErrorRate) {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+// wrapUpstreamFaults wraps the upstream handler so that responses can be
+// delayed, or cut off part way through the body.
+func wrapUpstreamFaults(next http.Handler) http.Handler {
+	slog.Warn("Fault injection is enabled",
+		"lookup_error_rate", activeFaults.lookupErrorRate,
+		"upstream_delay", activeFaults.upstreamDelay,
+		"upstream_delay_rate", activeFaults.upstreamDelayRate,
+		"partial_write_rate", activeFaults.partialWriteRate)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if activeFaults.upstreamDelay > 0 && chance(activeFaults.upstreamDelayRate) {
+			select {
+			case <-time.After(activeFaults.upstreamDelay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if chance(activeFaults.partialWriteRate) {
+			w = &partialWriter{ResponseWriter: w}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// partialWriter passes through the first chunk of the body and then aborts
+// the response, as though the upstream connection had dropped.
+type partialWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *partialWriter) Write(b []byte) (int, error) {
+	if w.written {
+		panic(http.ErrAbortHandler)
+	}
+
+	w.written = true
+	return w.ResponseWriter.Write(b[:len(b)/2])
+}
+
+func (w *partialWriter) Flush() {
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
@@ -0,0 +1,13 @@
+//go:build !chaos
+
+package internal
+
+import "net/http"
+
+func injectLookupFault() error {
+	return nil
+}
+
+func wrapUpstreamFaults(next http.Handler) http.Handler {
+	return next
+}
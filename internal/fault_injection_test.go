@@ -0,0 +1,67 @@
+//go:build chaos
+
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultInjection_lookup_errors(t *testing.T) {
+	usingFaults(t, faultInjection{lookupErrorRate: 1})
+
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	middleware := NewGeoIPMiddleware(resolver, slog.Default(), next, []string{"US"}, nil, BlockPolicies{})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "81.2.69.142:1234"
+	middleware.ServeHTTP(w, r)
+
+	// Lookup failures are currently treated as fail-open
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestFaultInjection_upstream_delay(t *testing.T) {
+	usingFaults(t, faultInjection{upstreamDelay: 50 * time.Millisecond, upstreamDelayRate: 1})
+
+	handler := wrapUpstreamFaults(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	started := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond)
+}
+
+func TestFaultInjection_partial_writes(t *testing.T) {
+	usingFaults(t, faultInjection{partialWriteRate: 1})
+
+	handler := wrapUpstreamFaults(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World!"))
+		w.Write([]byte("More content"))
+	}))
+
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	})
+	assert.Equal(t, "Hello ", w.Body.String())
+}
+
+// Helpers
+
+func usingFaults(t *testing.T, faults faultInjection) {
+	old := activeFaults
+	activeFaults = faults
+
+	t.Cleanup(func() {
+		activeFaults = old
+	})
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const featureHeaderAnyCountry = "*"
+
+var ErrInvalidFeatureHeader = errors.New("feature header must be in the form Name=value@COUNTRY[|COUNTRY...][:percent]")
+
+// FeatureHeader is a request header added for a share of the traffic from
+// certain countries, so that the upstream can roll a feature out gradually by
+// location.
+type FeatureHeader struct {
+	Name      string
+	Value     string
+	Countries []string
+	Percent   float64
+}
+
+// ParseFeatureHeader parses `Name=value@COUNTRY[|COUNTRY...][:percent]`, for
+// example `X-Feature-NewCheckout=1@CA:10`. A country of `*` matches every
+// country, and the percentage defaults to 100.
+func ParseFeatureHeader(value string) (FeatureHeader, error) {
+	name, rest, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return FeatureHeader{}, ErrInvalidFeatureHeader
+	}
+
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return FeatureHeader{}, ErrInvalidFeatureHeader
+	}
+
+	headerValue := strings.TrimSpace(rest[:at])
+	countriesValue, percentValue, hasPercent := strings.Cut(rest[at+1:], ":")
+
+	countries := []string{}
+	for _, country := range strings.Split(countriesValue, "|") {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if country != "" {
+			countries = append(countries, country)
+		}
+	}
+	if len(countries) == 0 {
+		return FeatureHeader{}, ErrInvalidFeatureHeader
+	}
+
+	percent := 100.0
+	if hasPercent {
+		var err error
+		percent, err = strconv.ParseFloat(strings.TrimSpace(percentValue), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return FeatureHeader{}, ErrInvalidFeatureHeader
+		}
+	}
+
+	return FeatureHeader{
+		Name:      http.CanonicalHeaderKey(name),
+		Value:     headerValue,
+		Countries: countries,
+		Percent:   percent,
+	}, nil
+}
+
+// String formats the header in the form that ParseFeatureHeader reads.
+func (f FeatureHeader) String() string {
+	return f.Name + "=" + f.Value + "@" + strings.Join(f.Countries, "|") + ":" + strconv.FormatFloat(f.Percent, 'f', -1, 64)
+}
+
+func (f FeatureHeader) appliesTo(country, client string) bool {
+	if !slices.Contains(f.Countries, featureHeaderAnyCountry) && !slices.Contains(f.Countries, country) {
+		return false
+	}
+
+	return featureBucket(f.Name, client) < f.Percent
+}
+
+// featureBucket places a client at a stable point between 0 and 100 for each
+// feature, so that a client keeps the same variant from one request to the
+// next, and the clients chosen for one feature aren't the same as for another.
+func featureBucket(feature, client string) float64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(feature + "\x00" + client))
+
+	return float64(hash.Sum64()%10000) / 100
+}
+
+// FeatureHeadersMiddleware sets the configured feature headers on requests
+// that qualify for them. Any values the client sent for those headers are
+// removed, so that features can only be turned on by us.
+type FeatureHeadersMiddleware struct {
+	headers []FeatureHeader
+	next    http.Handler
+}
+
+func NewFeatureHeadersMiddleware(headers []FeatureHeader, next http.Handler) *FeatureHeadersMiddleware {
+	return &FeatureHeadersMiddleware{
+		headers: headers,
+		next:    next,
+	}
+}
+
+func (h *FeatureHeadersMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, header := range h.headers {
+		r.Header.Del(header.Name)
+	}
+
+	country := CountryFromContext(r.Context())
+	client, _ := clientIP(r)
+
+	for _, header := range h.headers {
+		if r.Header.Get(header.Name) == "" && header.appliesTo(country, client) {
+			r.Header.Set(header.Name, header.Value)
+		}
+	}
+
+	h.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFeatureHeader(t *testing.T) {
+	header, err := ParseFeatureHeader("x-feature-newcheckout=1@ca:10")
+	require.NoError(t, err)
+	assert.Equal(t, FeatureHeader{Name: "X-Feature-Newcheckout", Value: "1", Countries: []string{"CA"}, Percent: 10}, header)
+
+	header, err = ParseFeatureHeader("X-Beta=a=b@US|gb")
+	require.NoError(t, err)
+	assert.Equal(t, FeatureHeader{Name: "X-Beta", Value: "a=b", Countries: []string{"US", "GB"}, Percent: 100}, header)
+
+	header, err = ParseFeatureHeader("X-Beta=on@*:0.5")
+	require.NoError(t, err)
+	assert.Equal(t, FeatureHeader{Name: "X-Beta", Value: "on", Countries: []string{"*"}, Percent: 0.5}, header)
+
+	for _, value := range []string{"", "X-Beta", "X-Beta=on", "=on@US", "X-Beta=on@", "X-Beta=on@US:abc", "X-Beta=on@US:101", "X-Beta=on@US:-1"} {
+		_, err := ParseFeatureHeader(value)
+		assert.ErrorIs(t, err, ErrInvalidFeatureHeader, value)
+	}
+}
+
+func TestFeatureHeadersMiddleware(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	request := func(headers []FeatureHeader, ip string, requestHeaders http.Header) http.Header {
+		var received http.Header
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+		})
+
+		handler := NewGeoIPMiddleware(resolver, slog.Default(), NewFeatureHeadersMiddleware(headers, next), nil, nil, BlockPolicies{})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		for name, values := range requestHeaders {
+			r.Header[name] = values
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		return received
+	}
+
+	t.Run("sets headers for matching countries", func(t *testing.T) {
+		headers := []FeatureHeader{
+			{Name: "X-Feature-Gb", Value: "1", Countries: []string{"GB"}, Percent: 100},
+			{Name: "X-Feature-Us", Value: "1", Countries: []string{"US"}, Percent: 100},
+			{Name: "X-Feature-All", Value: "yes", Countries: []string{"*"}, Percent: 100},
+		}
+
+		received := request(headers, "81.2.69.142", nil)
+		assert.Equal(t, "1", received.Get("X-Feature-Gb"))
+		assert.Empty(t, received.Get("X-Feature-Us"))
+		assert.Equal(t, "yes", received.Get("X-Feature-All"))
+	})
+
+	t.Run("removes values sent by the client", func(t *testing.T) {
+		headers := []FeatureHeader{{Name: "X-Feature-Us", Value: "1", Countries: []string{"US"}, Percent: 100}}
+
+		received := request(headers, "81.2.69.142", http.Header{"X-Feature-Us": {"1"}})
+		assert.Empty(t, received.Get("X-Feature-Us"))
+	})
+
+	t.Run("sets headers for a stable share of clients", func(t *testing.T) {
+		headers := []FeatureHeader{{Name: "X-Feature-Half", Value: "1", Countries: []string{"*"}, Percent: 50}}
+
+		enabled := 0
+		for i := range 200 {
+			ip := fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)
+
+			first := request(headers, ip, nil).Get("X-Feature-Half")
+			assert.Equal(t, first, request(headers, ip, nil).Get("X-Feature-Half"))
+			if first != "" {
+				enabled++
+			}
+		}
+
+		assert.InDelta(t, 100, enabled, 30)
+	})
+
+	t.Run("sets no headers at zero percent", func(t *testing.T) {
+		headers := []FeatureHeader{{Name: "X-Feature-None", Value: "1", Countries: []string{"*"}, Percent: 0}}
+
+		assert.Empty(t, request(headers, "81.2.69.142", nil).Get("X-Feature-None"))
+	})
+}
+
+func TestFeatureHeader_String(t *testing.T) {
+	header, err := ParseFeatureHeader("X-Beta=on@us|gb:12.5")
+	require.NoError(t, err)
+
+	assert.Equal(t, "X-Beta=on@US|GB:12.5", header.String())
+
+	parsed, err := ParseFeatureHeader(header.String())
+	require.NoError(t, err)
+	assert.Equal(t, header, parsed)
+}
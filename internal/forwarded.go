@@ -0,0 +1,344 @@
+package internal
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+)
+
+// setXForwarded sets the X-Forwarded-For, -Host, -Proto and -Port headers of
+// the request to the upstream, and with forwarded, an RFC 7239 Forwarded
+// header too.
+//
+// With forwardHeaders, the values sent by a trusted proxy in front of us are
+// carried on, but only once they're known to be well formed: the addresses of
+// X-Forwarded-For that aren't IPs are dropped, as are other headers with
+// values that aren't what they should be, and we use our own in their place.
+// Without forwardHeaders, or when the request came from anyone else, only our
+// own values are sent.
+func setXForwarded(r *httputil.ProxyRequest, proxies *TrustedProxies, forwardHeaders, forwarded bool) {
+	forwardHeaders = forwardHeaders && proxies.Trusts(r.In)
+
+	var prior []string
+	if forwardHeaders {
+		prior = sanitizeForwardedFor(r.In.Header.Values("X-Forwarded-For"))
+	}
+	r.Out.Header.Del("X-Forwarded-For")
+	if len(prior) > 0 {
+		r.Out.Header.Set("X-Forwarded-For", strings.Join(prior, ", "))
+	}
+
+	r.SetXForwarded()
+	r.Out.Header.Set("X-Forwarded-Port", requestPort(r.In))
+
+	if forwardHeaders {
+		if host := sanitizeForwardedHost(firstListValue(r.In.Header.Get("X-Forwarded-Host"))); host != "" {
+			r.Out.Header.Set("X-Forwarded-Host", host)
+		}
+		if proto := sanitizeForwardedProto(firstListValue(r.In.Header.Get("X-Forwarded-Proto"))); proto != "" {
+			r.Out.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if port := sanitizeForwardedPort(firstListValue(r.In.Header.Get("X-Forwarded-Port"))); port != "" {
+			r.Out.Header.Set("X-Forwarded-Port", port)
+		}
+	}
+
+	r.Out.Header.Del("Forwarded")
+	if forwarded {
+		elements := []string{}
+		if forwardHeaders {
+			elements = priorForwardedElements(r.In.Header, prior)
+		}
+		elements = append(elements, forwardedElement(r.In))
+
+		r.Out.Header.Set("Forwarded", strings.Join(elements, ", "))
+	}
+}
+
+// priorForwardedElements are the well-formed elements of the request's
+// Forwarded header. When it has none, they're made from the X-Forwarded-
+// headers, so that both describe the same hops.
+func priorForwardedElements(header http.Header, prior []string) []string {
+	elements := sanitizeForwarded(header.Values("Forwarded"))
+	if len(elements) > 0 {
+		return elements
+	}
+
+	for i, address := range prior {
+		pairs := []string{"for=" + forwardedValue(forwardedNode(address))}
+
+		// The X-Forwarded-Host and -Proto describe the request as the first
+		// proxy received it
+		if i == 0 {
+			if host := sanitizeForwardedHost(firstListValue(header.Get("X-Forwarded-Host"))); host != "" {
+				pairs = append(pairs, "host="+forwardedValue(host))
+			}
+			if proto := sanitizeForwardedProto(firstListValue(header.Get("X-Forwarded-Proto"))); proto != "" {
+				pairs = append(pairs, "proto="+proto)
+			}
+		}
+
+		elements = append(elements, strings.Join(pairs, ";"))
+	}
+
+	return elements
+}
+
+// forwardedElement describes the request as we received it.
+func forwardedElement(r *http.Request) string {
+	pairs := []string{}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil {
+		pairs = append(pairs, "for="+forwardedValue(forwardedNode(host)))
+	}
+	if sanitizeForwardedHost(r.Host) != "" {
+		pairs = append(pairs, "host="+forwardedValue(r.Host))
+	}
+	pairs = append(pairs, "proto="+requestScheme(r))
+
+	return strings.Join(pairs, ";")
+}
+
+// sanitizeForwardedFor returns the addresses of X-Forwarded-For headers that
+// are IPs, leaving out anything else.
+func sanitizeForwardedFor(values []string) []string {
+	addresses := []string{}
+	for _, value := range values {
+		for _, address := range strings.Split(value, ",") {
+			ip := net.ParseIP(strings.TrimSpace(address))
+			if ip != nil {
+				addresses = append(addresses, ip.String())
+			}
+		}
+	}
+	return addresses
+}
+
+func sanitizeForwardedProto(value string) string {
+	value = strings.ToLower(value)
+	if value == "http" || value == "https" {
+		return value
+	}
+	return ""
+}
+
+func sanitizeForwardedPort(value string) string {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
+
+// sanitizeForwardedHost returns the host, with its port if any, if it's one
+// that could appear in a Host header.
+func sanitizeForwardedHost(value string) string {
+	if value == "" || len(value) > 255 {
+		return ""
+	}
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = value, ""
+	}
+	if port != "" && sanitizeForwardedPort(port) == "" {
+		return ""
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil && !strings.HasPrefix(value, "[") {
+			return ""
+		}
+		return value
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return ""
+		}
+	}
+	if host == "" {
+		return ""
+	}
+	return value
+}
+
+// sanitizeForwarded returns the elements of Forwarded headers in which each
+// parameter is one of for, by, host or proto, with a value of the right form.
+// Elements are written out again in a consistent form, with lowercase names
+// and values quoted only as needed.
+func sanitizeForwarded(values []string) []string {
+	elements := []string{}
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			if element = sanitizeForwardedElement(element); element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return elements
+}
+
+func sanitizeForwardedElement(element string) string {
+	pairs := []string{}
+	seen := map[string]bool{}
+
+	for _, pair := range splitQuoted(element, ';') {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = unquoteForwardedValue(strings.TrimSpace(value))
+		if !ok || seen[name] {
+			return ""
+		}
+		seen[name] = true
+
+		switch name {
+		case "for", "by":
+			if !validForwardedNode(value) {
+				return ""
+			}
+		case "host":
+			if sanitizeForwardedHost(value) == "" {
+				return ""
+			}
+		case "proto":
+			value = sanitizeForwardedProto(value)
+			if value == "" {
+				return ""
+			}
+		default:
+			return ""
+		}
+
+		pairs = append(pairs, name+"="+forwardedValue(value))
+	}
+
+	return strings.Join(pairs, ";")
+}
+
+// validForwardedNode reports whether the value is a node as RFC 7239 has
+// them: an IPv4 address, a bracketed IPv6 address, `unknown`, or an
+// obfuscated identifier starting with an underscore, optionally followed by
+// a port, which may be obfuscated too.
+func validForwardedNode(value string) bool {
+	name, port := value, ""
+	if strings.HasPrefix(value, "[") {
+		end := strings.Index(value, "]")
+		if end < 0 {
+			return false
+		}
+		name, port = value[:end+1], value[end+1:]
+	} else if i := strings.LastIndex(value, ":"); i >= 0 {
+		name, port = value[:i], value[i:]
+	}
+
+	if port != "" {
+		port, ok := strings.CutPrefix(port, ":")
+		if !ok || (sanitizeForwardedPort(port) == "" && !validObfuscatedIdentifier(port)) {
+			return false
+		}
+	}
+
+	switch {
+	case name == "unknown":
+		return true
+	case strings.HasPrefix(name, "["):
+		ip := net.ParseIP(strings.Trim(name, "[]"))
+		return ip != nil && ip.To4() == nil
+	case validObfuscatedIdentifier(name):
+		return true
+	default:
+		ip := net.ParseIP(name)
+		return ip != nil && ip.To4() != nil
+	}
+}
+
+func validObfuscatedIdentifier(value string) bool {
+	if len(value) < 2 || value[0] != '_' {
+		return false
+	}
+	for _, c := range value[1:] {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardedNode writes an address as a Forwarded node, which puts IPv6
+// addresses in brackets.
+func forwardedNode(address string) string {
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+	return address
+}
+
+// forwardedValue quotes a value that isn't a token, such as an address with
+// a port or an IPv6 address.
+func forwardedValue(value string) string {
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+func unquoteForwardedValue(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+		value = strings.ReplaceAll(value, `\"`, `"`)
+		value = strings.ReplaceAll(value, `\\`, `\`)
+	}
+	return value
+}
+
+// splitQuoted splits the value at each separator that isn't inside a quoted
+// string.
+func splitQuoted(value string, separator byte) []string {
+	parts := []string{}
+	quoted, escaped, start := false, false, 0
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && value[i] == '\\':
+			escaped = true
+		case value[i] == '"':
+			quoted = !quoted
+		case !quoted && value[i] == separator:
+			parts = append(parts, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+func firstListValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestPort is the port the client connected to, from the Host header when
+// it has one, or else the default for the scheme.
+func requestPort(r *http.Request) string {
+	if _, port, err := net.SplitHostPort(r.Host); err == nil && port != "" {
+		return port
+	}
+	if r.TLS != nil {
+		return "443"
+	}
+	return "80"
+}
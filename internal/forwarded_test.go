@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetXForwarded(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"1.2.3.0/24"})
+	require.NoError(t, err)
+	proxies := NewTrustedProxies(trusted)
+
+	proxied := func(forwardHeaders, forwarded bool, header http.Header) http.Header {
+		in := httptest.NewRequest("GET", "http://example.org/", nil)
+		in.RemoteAddr = "1.2.3.4:1234"
+		for name, values := range header {
+			in.Header[name] = values
+		}
+
+		out := in.Clone(in.Context())
+		for _, name := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+			out.Header.Del(name)
+		}
+
+		setXForwarded(&httputil.ProxyRequest{In: in, Out: out}, proxies, forwardHeaders, forwarded)
+		return out.Header
+	}
+
+	t.Run("sets our own values", func(t *testing.T) {
+		header := proxied(false, false, http.Header{
+			"X-Forwarded-For":   {"4.3.2.1"},
+			"X-Forwarded-Port":  {"8443"},
+			"X-Forwarded-Proto": {"https"},
+		})
+
+		assert.Equal(t, "1.2.3.4", header.Get("X-Forwarded-For"))
+		assert.Equal(t, "example.org", header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "http", header.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "80", header.Get("X-Forwarded-Port"))
+		assert.Empty(t, header.Values("Forwarded"))
+	})
+
+	t.Run("carries on well-formed values when forwarding", func(t *testing.T) {
+		header := proxied(true, false, http.Header{
+			"X-Forwarded-For":   {"4.3.2.1, 2001:db8::1", "10.0.0.1"},
+			"X-Forwarded-Host":  {"other.example.com:8443"},
+			"X-Forwarded-Proto": {"HTTPS"},
+			"X-Forwarded-Port":  {"8443"},
+		})
+
+		assert.Equal(t, "4.3.2.1, 2001:db8::1, 10.0.0.1, 1.2.3.4", header.Get("X-Forwarded-For"))
+		assert.Equal(t, "other.example.com:8443", header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "https", header.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "8443", header.Get("X-Forwarded-Port"))
+	})
+
+	t.Run("sets our own values when forwarding for an untrusted peer", func(t *testing.T) {
+		untrusted := proxies
+		proxies = nil
+		defer func() { proxies = untrusted }()
+
+		header := proxied(true, true, http.Header{
+			"X-Forwarded-For":   {"4.3.2.1"},
+			"X-Forwarded-Host":  {"other.example.com"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Port":  {"8443"},
+			"Forwarded":         {"for=4.3.2.1;proto=https"},
+		})
+
+		assert.Equal(t, "1.2.3.4", header.Get("X-Forwarded-For"))
+		assert.Equal(t, "example.org", header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "http", header.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "80", header.Get("X-Forwarded-Port"))
+		assert.Equal(t, "for=1.2.3.4;host=example.org;proto=http", header.Get("Forwarded"))
+	})
+
+	t.Run("replaces malformed values when forwarding", func(t *testing.T) {
+		header := proxied(true, false, http.Header{
+			"X-Forwarded-For":   {"4.3.2.1, <script>, evil.example.com"},
+			"X-Forwarded-Host":  {"evil.example.com/path"},
+			"X-Forwarded-Proto": {"javascript"},
+			"X-Forwarded-Port":  {"99999"},
+		})
+
+		assert.Equal(t, "4.3.2.1, 1.2.3.4", header.Get("X-Forwarded-For"))
+		assert.Equal(t, "example.org", header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "http", header.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "80", header.Get("X-Forwarded-Port"))
+	})
+
+	t.Run("sends a Forwarded header", func(t *testing.T) {
+		header := proxied(false, true, http.Header{"Forwarded": {"for=4.3.2.1"}})
+
+		assert.Equal(t, "for=1.2.3.4;host=example.org;proto=http", header.Get("Forwarded"))
+	})
+
+	t.Run("carries on well-formed Forwarded elements when forwarding", func(t *testing.T) {
+		header := proxied(true, true, http.Header{
+			"Forwarded": {`For="[2001:db8::1]:4711";Proto=https, for=_hidden;by=unknown`, `for=evil;secret=1, for=4.3.2.1;host="<script>"`},
+		})
+
+		assert.Equal(t, `for="[2001:db8::1]:4711";proto=https, for=_hidden;by=unknown, for=1.2.3.4;host=example.org;proto=http`, header.Get("Forwarded"))
+	})
+
+	t.Run("makes Forwarded elements from the X-Forwarded- headers when forwarding", func(t *testing.T) {
+		header := proxied(true, true, http.Header{
+			"X-Forwarded-For":   {"4.3.2.1, 2001:db8::1"},
+			"X-Forwarded-Host":  {"other.example.com"},
+			"X-Forwarded-Proto": {"https"},
+		})
+
+		assert.Equal(t, `for=4.3.2.1;host=other.example.com;proto=https, for="[2001:db8::1]", for=1.2.3.4;host=example.org;proto=http`, header.Get("Forwarded"))
+	})
+}
+
+func TestSanitizeForwardedHost(t *testing.T) {
+	for _, value := range []string{"example.com", "example.com:8080", "127.0.0.1", "[::1]:3000", "my_host"} {
+		assert.Equal(t, value, sanitizeForwardedHost(value), value)
+	}
+
+	for _, value := range []string{"", "::1", "example.com:0", "example.com:http", "exa mple.com", "example.com/path", "a\"b"} {
+		assert.Empty(t, sanitizeForwardedHost(value), value)
+	}
+}
+
+func TestValidForwardedNode(t *testing.T) {
+	for _, value := range []string{"192.0.2.43", "192.0.2.43:47011", "[2001:db8:cafe::17]", "[2001:db8:cafe::17]:4711", "unknown", "_hidden", "_SEVKISEK:_port"} {
+		assert.True(t, validForwardedNode(value), value)
+	}
+
+	for _, value := range []string{"", "2001:db8:cafe::17", "[192.0.2.43]", "example.com", "192.0.2.43:", "_", "[2001:db8::1"} {
+		assert.False(t, validForwardedNode(value), value)
+	}
+}
+
+func TestRequestPort(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.org:8080/", nil)
+	assert.Equal(t, "8080", requestPort(r))
+
+	r = httptest.NewRequest("GET", "http://example.org/", nil)
+	assert.Equal(t, "80", requestPort(r))
+
+	r.TLS = &tls.ConnectionState{}
+	assert.Equal(t, "443", requestPort(r))
+}
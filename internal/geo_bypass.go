@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	geoBypassHeader     = "X-Geo-Bypass"
+	geoBypassQueryParam = "geo_bypass"
+	geoBypassCookie     = "_thruster_geo_bypass"
+
+	// Tokens are compared in constant time, but a short one could still be
+	// guessed
+	geoBypassTokenMinLength = 16
+)
+
+// Values of the geo-bypass tag, for how a client got past country blocking.
+const (
+	GeoBypassByToken  = "token"
+	GeoBypassByCookie = "cookie"
+)
+
+var ErrGeoBypassTokenTooShort = errors.New("geo bypass tokens must be at least 16 characters")
+
+// CheckGeoBypassTokens makes sure each of the tokens is long enough to be
+// secret.
+func CheckGeoBypassTokens(tokens []string) error {
+	for _, token := range tokens {
+		if len(token) < geoBypassTokenMinLength {
+			return ErrGeoBypassTokenTooShort
+		}
+	}
+	return nil
+}
+
+// GeoBypass lets chosen people through country blocking, such as staff who are
+// travelling. They're given one of the tokens, to send in an X-Geo-Bypass
+// header or a `geo_bypass` query parameter. The first request with a valid
+// token is answered with a cookie that carries the bypass from then on, so
+// that a link with the token in it is enough for a browser.
+//
+// The cookie holds its expiry, signed with the token it was issued for, so
+// removing a token also revokes the cookies issued for it.
+type GeoBypass struct {
+	tokens      [][]byte
+	duration    time.Duration
+	cookieScope *CookieScope
+	now         func() time.Time
+}
+
+func NewGeoBypass(tokens []string, duration time.Duration, cookieScope *CookieScope) *GeoBypass {
+	keys := [][]byte{}
+	for _, token := range tokens {
+		keys = append(keys, []byte(token))
+	}
+
+	return &GeoBypass{
+		tokens:      keys,
+		duration:    duration,
+		cookieScope: cookieScope,
+		now:         time.Now,
+	}
+}
+
+// Check takes any token out of the request, so that it isn't passed on to the
+// upstream, and returns how the client is allowed to bypass country blocking,
+// if at all. A valid token is answered with a bypass cookie.
+func (b *GeoBypass) Check(w http.ResponseWriter, r *http.Request) (*http.Request, string) {
+	if b == nil {
+		return r, ""
+	}
+
+	token := r.Header.Get(geoBypassHeader)
+	r.Header.Del(geoBypassHeader)
+
+	if r.URL.Query().Has(geoBypassQueryParam) {
+		if token == "" {
+			token = r.URL.Query().Get(geoBypassQueryParam)
+		}
+		r = b.withoutQueryParam(r)
+	}
+
+	if key := b.match(token); key != nil {
+		b.issueCookie(w, r, key)
+		return r, GeoBypassByToken
+	}
+
+	if cookie, err := r.Cookie(geoBypassCookie); err == nil && b.validCookie(cookie.Value) {
+		return r, GeoBypassByCookie
+	}
+
+	return r, ""
+}
+
+// Private
+
+func (b *GeoBypass) match(token string) []byte {
+	if token == "" {
+		return nil
+	}
+
+	for _, key := range b.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), key) == 1 {
+			return key
+		}
+	}
+	return nil
+}
+
+func (b *GeoBypass) withoutQueryParam(r *http.Request) *http.Request {
+	query := r.URL.Query()
+	query.Del(geoBypassQueryParam)
+
+	u := *r.URL
+	u.RawQuery = query.Encode()
+
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	r.RequestURI = u.RequestURI()
+
+	return r
+}
+
+func (b *GeoBypass) issueCookie(w http.ResponseWriter, r *http.Request, key []byte) {
+	expires := b.now().Add(b.duration)
+	value := strconv.FormatInt(expires.Unix(), 10)
+
+	cookie := &http.Cookie{
+		Name:     geoBypassCookie,
+		Value:    value + "." + b.sign(key, value),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(b.duration.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if b.cookieScope != nil {
+		b.cookieScope.Apply(r, cookie)
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+func (b *GeoBypass) validCookie(value string) bool {
+	expiry, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || b.now().Unix() >= expires {
+		return false
+	}
+
+	for _, key := range b.tokens {
+		if hmac.Equal([]byte(signature), []byte(b.sign(key, expiry))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *GeoBypass) sign(key []byte, expiry string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("geo-bypass." + expiry))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
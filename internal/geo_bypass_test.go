@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGeoBypassToken = "staff-travel-0123456789"
+
+func TestCheckGeoBypassTokens(t *testing.T) {
+	assert.NoError(t, CheckGeoBypassTokens(nil))
+	assert.NoError(t, CheckGeoBypassTokens([]string{testGeoBypassToken}))
+	assert.ErrorIs(t, CheckGeoBypassTokens([]string{testGeoBypassToken, "letmein"}), ErrGeoBypassTokenTooShort)
+}
+
+func TestGeoBypass_Check(t *testing.T) {
+	bypass := NewGeoBypass([]string{"auditor-0123456789", testGeoBypassToken}, time.Hour, NewCookieScope(CookieScopeRegistrable, nil))
+
+	check := func(r *http.Request) (*http.Request, string, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		r, by := bypass.Check(w, r)
+		return r, by, w
+	}
+
+	t.Run("with a token in the header", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://www.example.com/", nil)
+		r.Header.Set("X-Geo-Bypass", testGeoBypassToken)
+
+		r, by, w := check(r)
+		assert.Equal(t, GeoBypassByToken, by)
+		assert.Empty(t, r.Header.Get("X-Geo-Bypass"))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "_thruster_geo_bypass", cookies[0].Name)
+		assert.Equal(t, "example.com", cookies[0].Domain)
+		assert.Equal(t, 3600, cookies[0].MaxAge)
+		assert.True(t, cookies[0].HttpOnly)
+
+		r = httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookies[0])
+		_, by, w = check(r)
+		assert.Equal(t, GeoBypassByCookie, by)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("with a token in the query", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/reports?geo_bypass="+testGeoBypassToken+"&year=2026", nil)
+
+		r, by, w := check(r)
+		assert.Equal(t, GeoBypassByToken, by)
+		assert.Equal(t, "year=2026", r.URL.RawQuery)
+		assert.Equal(t, "/reports?year=2026", r.RequestURI)
+		assert.Len(t, w.Result().Cookies(), 1)
+	})
+
+	t.Run("with a wrong token", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/?geo_bypass=staff-travel-9876543210", nil)
+
+		r, by, w := check(r)
+		assert.Empty(t, by)
+		assert.Empty(t, r.URL.RawQuery)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("with a forged or expired cookie", func(t *testing.T) {
+		for _, value := range []string{"", "4102444800", "4102444800.forged", "1.x"} {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(&http.Cookie{Name: "_thruster_geo_bypass", Value: value})
+
+			_, by, _ := check(r)
+			assert.Empty(t, by, value)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Geo-Bypass", testGeoBypassToken)
+		_, _, w := check(r)
+		cookie := w.Result().Cookies()[0]
+
+		bypass.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		defer func() { bypass.now = time.Now }()
+
+		r = httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		_, by, _ := check(r)
+		assert.Empty(t, by)
+	})
+
+	t.Run("after the token is removed", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Geo-Bypass", testGeoBypassToken)
+		_, _, w := check(r)
+		cookie := w.Result().Cookies()[0]
+
+		revoked := NewGeoBypass([]string{"auditor-0123456789"}, time.Hour, nil)
+		r = httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		_, by := revoked.Check(httptest.NewRecorder(), r)
+		assert.Empty(t, by)
+	})
+}
+
+func TestGeoIPMiddleware_bypass(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	var received *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	})
+	middleware := NewGeoIPMiddleware(resolver, slog.Default(), next, nil, []string{"GB"}, BlockPolicies{})
+	middleware.SetBypass(NewGeoBypass([]string{testGeoBypassToken}, time.Hour, nil))
+
+	serve := func(path string) (*httptest.ResponseRecorder, *RequestTags) {
+		r, tags := WithRequestTags(httptest.NewRequest("GET", path, nil))
+		r.RemoteAddr = "81.2.69.142:12345" // GB
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		return w, tags
+	}
+
+	w, tags := serve("/")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, BlockedByCountry, tags.Get(TagBlocked))
+
+	received = nil
+	w, tags = serve("/?geo_bypass=" + testGeoBypassToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, GeoBypassByToken, tags.Get(TagGeoBypass))
+	assert.Empty(t, tags.Get(TagBlocked))
+	require.NotNil(t, received)
+	assert.Empty(t, received.URL.RawQuery)
+	assert.Equal(t, "GB", received.Header.Get("X-GeoIP-Country"))
+}
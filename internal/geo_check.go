@@ -0,0 +1,398 @@
+package internal
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidIP = errors.New("not a valid IP address")
+
+// GeoCheckResult explains what the filtering rules would do with a request
+// from an address.
+type GeoCheckResult struct {
+	IP       net.IP
+	Internal bool
+	Country  string
+	City     string
+	ASN      string
+
+	// Latitude and Longitude are where the client is, when Located is set,
+	// which needs a City database.
+	Latitude  float64
+	Longitude float64
+	Located   bool
+
+	// Blocked is set when the request would be refused, with Status being the
+	// response it would get and Rule the setting responsible.
+	Blocked bool
+	Status  int
+	Rule    string
+
+	// Notes are rules that apply to the request without refusing it, such as
+	// rate limits.
+	Notes []string
+}
+
+// GeoChecker runs addresses past the filtering rules of a configuration, as
+// the GeoIP-dependent stages of the handler chain would, without having to
+// send a request from them.
+type GeoChecker struct {
+	resolver      *GeoResolver
+	policy        Policy
+	blockPolicies BlockPolicies
+	windows       MaintenanceWindows
+	now           func() time.Time
+}
+
+func NewGeoChecker(resolver *GeoResolver, config *Config) *GeoChecker {
+	return &GeoChecker{
+		resolver:      resolver,
+		policy:        PolicyFromConfig(config),
+		blockPolicies: BlockPolicies{Options: config.BlockedOptionsPolicy, Head: config.BlockedHeadPolicy},
+		windows:       config.MaintenanceWindows,
+		now:           time.Now,
+	}
+}
+
+// Check explains what would happen to a request with the given method and
+// User-Agent from the address. The rules are checked in the order the handler
+// chain applies them, so the rule reported is the one that would refuse the
+// request first.
+func (c *GeoChecker) Check(ip net.IP, method, userAgent string) (GeoCheckResult, error) {
+	result := GeoCheckResult{IP: ip, Status: http.StatusOK}
+
+	if isLocalOrInternalIP(ip) {
+		result.Internal = true
+		result.Notes = append(result.Notes, "local and internal addresses are never filtered")
+		return result, nil
+	}
+
+	err := c.locate(&result)
+	if err != nil {
+		return result, err
+	}
+
+	if result.Country == "" {
+		result.Notes = append(result.Notes, "no country found, so country rules don't apply")
+	}
+
+	c.checkCountries(&result, method)
+	if !result.Blocked {
+		c.checkGeofences(&result)
+	}
+	if !result.Blocked {
+		c.checkMaintenance(&result)
+	}
+	if !result.Blocked {
+		c.checkRiskScore(&result, method, userAgent)
+	}
+	c.noteRateLimits(&result)
+	c.noteBodyRules(&result)
+
+	return result, nil
+}
+
+// Write prints the result in a form for people to read.
+func (r GeoCheckResult) Write(w io.Writer) {
+	fmt.Fprintf(w, "IP:      %s\n", r.IP)
+	fmt.Fprintf(w, "Country: %s\n", geoCheckValue(geoCheckCountry(r.Country)))
+	fmt.Fprintf(w, "City:    %s\n", geoCheckValue(r.City))
+	fmt.Fprintf(w, "ASN:     %s\n", geoCheckValue(r.ASN))
+
+	if r.Blocked {
+		fmt.Fprintf(w, "Result:  blocked (%d %s) by %s\n", r.Status, http.StatusText(r.Status), r.Rule)
+	} else {
+		fmt.Fprintf(w, "Result:  allowed\n")
+	}
+
+	for _, note := range r.Notes {
+		fmt.Fprintf(w, "Note:    %s\n", note)
+	}
+}
+
+// RunGeoCommand implements `thrust geo check [flags] IP...`, which explains
+// what the filtering rules in the current environment would do with requests
+// from each address.
+func RunGeoCommand(args []string) int {
+	if len(args) < 1 || args[0] != "check" {
+		printGeoUsage(os.Stderr)
+		return 1
+	}
+
+	flags := flag.NewFlagSet("geo check", flag.ContinueOnError)
+	method := flags.String("method", http.MethodGet, "method of the request to check")
+	userAgent := flags.String("user-agent", "", "User-Agent of the request to check, for risk scores")
+	configFile := flags.String("config", "", "config file to read, instead of CONFIG_FILE")
+
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		printGeoUsage(os.Stderr)
+		return 1
+	}
+
+	config, err := newConfig(*configFile, "", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	paths := config.GeoIP2DatabasePaths
+	if len(paths) == 0 {
+		paths = FindGeoIP2Databases(config.GeoIP2Provider)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: no GeoIP2 database found\n")
+		return 1
+	}
+
+	resolver, err := OpenGeoResolver(config.GeoIP2Provider, paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", err)
+	}
+	if resolver == nil {
+		return 1
+	}
+	defer resolver.Close()
+
+	checker := NewGeoChecker(resolver, config)
+
+	for i, value := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s: %s\n", value, ErrInvalidIP)
+			return 1
+		}
+
+		result, err := checker.Check(ip, strings.ToUpper(*method), *userAgent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s: %s\n", value, err)
+			return 1
+		}
+
+		result.Write(os.Stdout)
+	}
+
+	return 0
+}
+
+// Private
+
+// locate fills in what the databases know of the address. Country databases
+// have neither the city nor the coordinates, and only ASN databases have the
+// ASN.
+func (c *GeoChecker) locate(result *GeoCheckResult) error {
+	info, err := c.resolver.Lookup(result.IP)
+	if err != nil {
+		return err
+	}
+
+	result.Country = info.Country
+	result.City = info.City
+	result.Latitude = info.Latitude
+	result.Longitude = info.Longitude
+	result.Located = info.Located
+	if info.ASN != 0 {
+		result.ASN = strings.TrimSpace(info.ASNTag() + " " + info.ASOrganization)
+	}
+
+	return nil
+}
+
+func (c *GeoChecker) checkCountries(result *GeoCheckResult, method string) {
+	if result.Country == "" {
+		return
+	}
+
+	if slices.ContainsFunc(c.policy.ChallengeCountries, geoCheckCountryMatches(result.Country)) {
+		if method != http.MethodOptions {
+			result.Notes = append(result.Notes, "would be challenged by CHALLENGE_COUNTRIES ("+result.Country+" is listed), and let through once the challenge is solved")
+		}
+		return
+	}
+
+	var rule string
+	switch {
+	case len(c.policy.AllowCountries) > 0:
+		if !slices.ContainsFunc(c.policy.AllowCountries, geoCheckCountryMatches(result.Country)) {
+			rule = "ALLOW_COUNTRIES=" + strings.Join(c.policy.AllowCountries, ",") + " (" + result.Country + " isn't listed)"
+		}
+	case slices.ContainsFunc(c.policy.BlockCountries, geoCheckCountryMatches(result.Country)):
+		rule = "BLOCK_COUNTRIES (" + result.Country + " is listed)"
+	}
+
+	if rule == "" {
+		return
+	}
+
+	switch c.blockPolicies.For(method) {
+	case BlockPolicyAllow:
+		result.Notes = append(result.Notes, "would be blocked by "+rule+", but "+geoCheckPolicySetting(method)+"=allow lets "+method+" requests through")
+	case BlockPolicyEmpty:
+		c.block(result, http.StatusNoContent, rule+", with "+geoCheckPolicySetting(method)+"=empty")
+	default:
+		c.block(result, http.StatusForbidden, rule)
+	}
+}
+
+// checkGeofences applies the fences that cover every request. Those for
+// particular paths are only noted, as they depend on the request.
+func (c *GeoChecker) checkGeofences(result *GeoCheckResult) {
+	fences := Geofences{}
+	for _, fence := range c.policy.Geofences {
+		if fence.Path != "" {
+			result.Notes = append(result.Notes, "requests to "+fence.Path+" are subject to GEOFENCES="+fence.String())
+			continue
+		}
+		fences = append(fences, fence)
+	}
+
+	fence, blocked := fences.Check("", result.Latitude, result.Longitude, result.Located)
+	if !blocked {
+		return
+	}
+
+	switch {
+	case !result.Located:
+		c.block(result, http.StatusForbidden, "GEOFENCES="+fence.String()+" (location unknown)")
+	case fence.Action == GeofenceBlock:
+		c.block(result, http.StatusForbidden, "GEOFENCES="+fence.String()+" ("+geoCheckCoordinates(result)+" is inside)")
+	default:
+		c.block(result, http.StatusForbidden, "GEOFENCES="+fence.String()+" ("+geoCheckCoordinates(result)+" is outside)")
+	}
+}
+
+func (c *GeoChecker) checkMaintenance(result *GeoCheckResult) {
+	now := c.now()
+
+	// As with the maintenance stage, the longest of any overlapping windows
+	// is the one that counts
+	var longest time.Duration
+	for _, window := range c.windows {
+		if !slices.ContainsFunc(window.Countries, geoCheckCountryMatches(result.Country)) {
+			continue
+		}
+
+		if remaining := window.Schedule.Remaining(now); remaining > longest {
+			longest = remaining
+			c.block(result, http.StatusServiceUnavailable, "MAINTENANCE_WINDOWS="+window.String()+" (for another "+remaining.Round(time.Second).String()+")")
+		}
+	}
+}
+
+func (c *GeoChecker) checkRiskScore(result *GeoCheckResult, method, userAgent string) {
+	if len(c.policy.RiskScores) == 0 {
+		return
+	}
+
+	r := &http.Request{Method: method, Header: http.Header{}}
+	r.Header.Set("User-Agent", userAgent)
+
+	tags := NewRequestTags()
+	if result.Country != "" {
+		tags.Set(TagCountry, result.Country)
+	}
+
+	var total int
+	var matched []string
+	for _, score := range c.policy.RiskScores {
+		if score.matches(r, tags) {
+			total += score.Weight
+			matched = append(matched, score.String())
+		}
+	}
+
+	summary := "risk score " + strconv.Itoa(total)
+	if len(matched) > 0 {
+		summary += " from " + strings.Join(matched, ", ")
+	}
+
+	switch c.policy.RiskThresholds.actionFor(total) {
+	case RiskActionBlock:
+		c.block(result, http.StatusForbidden, "RISK_BLOCK_SCORE="+strconv.Itoa(c.policy.RiskThresholds.Block)+" ("+summary+")")
+	case RiskActionTag:
+		result.Notes = append(result.Notes, summary+" reaches RISK_TAG_SCORE="+strconv.Itoa(c.policy.RiskThresholds.Tag)+", so the app is sent an "+riskScoreHeader+" header")
+	default:
+		result.Notes = append(result.Notes, summary)
+	}
+}
+
+func (c *GeoChecker) noteRateLimits(result *GeoCheckResult) {
+	if c.policy.ClientRateLimit.Enabled() && !ipInNetworks(result.IP, c.policy.RateLimitExemptCIDRs) {
+		result.Notes = append(result.Notes, "limited to "+c.policy.ClientRateLimit.String()+" per client by RATE_LIMIT")
+	}
+
+	if result.Country == "" {
+		return
+	}
+
+	if limit, ok := c.policy.CountryRateLimits[strings.ToUpper(result.Country)]; ok {
+		result.Notes = append(result.Notes, "limited to "+limit.String()+" for "+result.Country+" by COUNTRY_RATE_LIMITS")
+	} else if c.policy.DefaultCountryRateLimit.Enabled() {
+		result.Notes = append(result.Notes, "limited to "+c.policy.DefaultCountryRateLimit.String()+" for "+result.Country+" by DEFAULT_COUNTRY_RATE_LIMIT")
+	}
+}
+
+func (c *GeoChecker) noteBodyRules(result *GeoCheckResult) {
+	for _, rule := range c.policy.BodyRules {
+		if len(rule.Countries) > 0 && slices.ContainsFunc(rule.Countries, geoCheckCountryMatches(result.Country)) {
+			result.Notes = append(result.Notes, "request bodies are inspected by BODY_RULES="+rule.String())
+		}
+	}
+}
+
+func (c *GeoChecker) block(result *GeoCheckResult, status int, rule string) {
+	result.Blocked = true
+	result.Status = status
+	result.Rule = rule
+}
+
+func geoCheckCoordinates(result *GeoCheckResult) string {
+	return formatCoordinate(result.Latitude) + " " + formatCoordinate(result.Longitude)
+}
+
+func geoCheckCountryMatches(country string) func(string) bool {
+	return func(candidate string) bool {
+		return country != "" && strings.EqualFold(candidate, country)
+	}
+}
+
+func geoCheckPolicySetting(method string) string {
+	if method == http.MethodHead {
+		return "BLOCKED_HEAD_POLICY"
+	}
+	return "BLOCKED_OPTIONS_POLICY"
+}
+
+func geoCheckCountry(code string) string {
+	if code == "" {
+		return ""
+	}
+	return code + " (" + CountryName(code) + ")"
+}
+
+func geoCheckValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func printGeoUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: thrust geo check [-method METHOD] [-user-agent USER_AGENT] IP...")
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const geoCheckGBAddress = "81.2.69.142"
+
+func TestGeoChecker_country_rules(t *testing.T) {
+	checker := newTestGeoChecker(t, &Config{BlockCountries: []string{"gb"}, BlockedOptionsPolicy: BlockPolicyAllow, BlockedHeadPolicy: BlockPolicyEmpty})
+
+	result := checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.Equal(t, "GB", result.Country)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, http.StatusForbidden, result.Status)
+	assert.Equal(t, "BLOCK_COUNTRIES (GB is listed)", result.Rule)
+
+	result = checkAddress(t, checker, geoCheckGBAddress, http.MethodHead)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, http.StatusNoContent, result.Status)
+
+	result = checkAddress(t, checker, geoCheckGBAddress, http.MethodOptions)
+	assert.False(t, result.Blocked)
+	assert.Contains(t, result.Notes[0], "BLOCKED_OPTIONS_POLICY=allow")
+
+	checker = newTestGeoChecker(t, &Config{AllowCountries: []string{"US", "CA"}})
+	result = checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "ALLOW_COUNTRIES=US,CA (GB isn't listed)", result.Rule)
+
+	checker = newTestGeoChecker(t, &Config{AllowCountries: []string{"GB"}})
+	result = checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.False(t, result.Blocked)
+}
+
+func TestGeoChecker_challenge_countries(t *testing.T) {
+	checker := newTestGeoChecker(t, &Config{AllowCountries: []string{"US"}, ChallengeCountries: []string{"GB"}})
+
+	result := checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.False(t, result.Blocked)
+	assert.Equal(t, []string{"would be challenged by CHALLENGE_COUNTRIES (GB is listed), and let through once the challenge is solved"}, result.Notes)
+
+	result = checkAddress(t, checker, geoCheckGBAddress, http.MethodOptions)
+	assert.False(t, result.Blocked)
+	assert.Empty(t, result.Notes)
+}
+
+func TestGeoChecker_internal_addresses_are_never_filtered(t *testing.T) {
+	checker := newTestGeoChecker(t, &Config{AllowCountries: []string{"US"}})
+
+	result := checkAddress(t, checker, "192.168.1.10", http.MethodGet)
+	assert.True(t, result.Internal)
+	assert.False(t, result.Blocked)
+	assert.Empty(t, result.Country)
+}
+
+func TestGeoChecker_maintenance_windows(t *testing.T) {
+	checker := newTestGeoChecker(t, &Config{MaintenanceWindows: MaintenanceWindows{
+		{Countries: []string{"GB"}, Schedule: Schedule{Start: 2 * time.Hour, End: 4 * time.Hour}},
+	}})
+	checker.now = func() time.Time { return time.Date(2024, 3, 9, 3, 0, 0, 0, time.UTC) }
+
+	result := checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, http.StatusServiceUnavailable, result.Status)
+	assert.Equal(t, "MAINTENANCE_WINDOWS=GB@02:00-04:00 (for another 1h0m0s)", result.Rule)
+
+	checker.now = func() time.Time { return time.Date(2024, 3, 9, 5, 0, 0, 0, time.UTC) }
+	result = checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.False(t, result.Blocked)
+}
+
+func TestGeoChecker_risk_scores(t *testing.T) {
+	config := &Config{
+		RiskScores:     RiskScores{{Signal: TagCountry, Value: "GB", HasValue: true, Weight: 60}, {Signal: RiskSignalUserAgent, Value: "curl", HasValue: true, Weight: 50}},
+		RiskThresholds: RiskThresholds{Tag: 50, Block: 100},
+	}
+	checker := newTestGeoChecker(t, config)
+
+	result, err := checker.Check(net.ParseIP(geoCheckGBAddress), http.MethodGet, "Mozilla/5.0")
+	require.NoError(t, err)
+	assert.False(t, result.Blocked)
+	assert.Contains(t, result.Notes, "risk score 60 from country:GB=60 reaches RISK_TAG_SCORE=50, so the app is sent an X-Risk-Score header")
+
+	result, err = checker.Check(net.ParseIP(geoCheckGBAddress), http.MethodGet, "curl/8.0")
+	require.NoError(t, err)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "RISK_BLOCK_SCORE=100 (risk score 110 from country:GB=60, user_agent:curl=50)", result.Rule)
+}
+
+func TestGeoChecker_notes_rate_limits_and_body_rules(t *testing.T) {
+	checker := newTestGeoChecker(t, &Config{
+		ClientRateLimit:   RateLimit{Rate: 10, Burst: 20},
+		CountryRateLimits: map[string]RateLimit{"GB": {Rate: 5, Burst: 5}},
+		BodyRules:         BodyRules{{Path: "/signup", Countries: []string{"GB"}, Field: "email", Patterns: []string{"*@example.com"}}},
+	})
+
+	result := checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.False(t, result.Blocked)
+	assert.Equal(t, []string{
+		"limited to 10:20 per client by RATE_LIMIT",
+		"limited to 5:5 for GB by COUNTRY_RATE_LIMITS",
+		"request bodies are inspected by BODY_RULES=/signup@GB:email=*@example.com",
+	}, result.Notes)
+}
+
+func TestGeoChecker_geofences(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-City-Test.mmdb")
+
+	checker := NewGeoChecker(resolver, &Config{Geofences: parseTestGeofences(t,
+		"allow 51.5074 -0.1278 50km",
+		"/live/*:block 49.9 -8.2 60.9 1.8",
+	)})
+
+	result := checkAddress(t, checker, geoCheckGBAddress, http.MethodGet)
+	assert.Equal(t, "London", result.City)
+	assert.True(t, result.Located)
+	assert.False(t, result.Blocked)
+	assert.Equal(t, []string{"requests to /live/* are subject to GEOFENCES=/live/*:block 49.9 -8.2 60.9 1.8"}, result.Notes)
+
+	result = checkAddress(t, checker, "89.160.20.115", http.MethodGet)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "GEOFENCES=allow 51.5074 -0.1278 50km (58.4167 15.6167 is outside)", result.Rule)
+
+	result = checkAddress(t, checker, "67.43.156.1", http.MethodGet)
+	assert.False(t, result.Located)
+	assert.Equal(t, "GEOFENCES=allow 51.5074 -0.1278 50km (location unknown)", result.Rule)
+}
+
+func TestGeoCheckResult_Write(t *testing.T) {
+	result := GeoCheckResult{
+		IP:      net.ParseIP(geoCheckGBAddress),
+		Country: "GB",
+		Blocked: true,
+		Status:  http.StatusForbidden,
+		Rule:    "BLOCK_COUNTRIES (GB is listed)",
+		Notes:   []string{"limited to 5:5 for GB by COUNTRY_RATE_LIMITS"},
+	}
+
+	var out bytes.Buffer
+	result.Write(&out)
+
+	assert.Equal(t, `IP:      81.2.69.142
+Country: GB (United Kingdom)
+City:    -
+ASN:     -
+Result:  blocked (403 Forbidden) by BLOCK_COUNTRIES (GB is listed)
+Note:    limited to 5:5 for GB by COUNTRY_RATE_LIMITS
+`, out.String())
+}
+
+// Helpers
+
+func newTestGeoChecker(t *testing.T, config *Config) *GeoChecker {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	return NewGeoChecker(resolver, config)
+}
+
+func checkAddress(t *testing.T, checker *GeoChecker, address, method string) GeoCheckResult {
+	result, err := checker.Check(net.ParseIP(address), method, "")
+	require.NoError(t, err)
+	return result
+}
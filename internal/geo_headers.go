@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// GeoField is something the databases may know of a client, which can be
+// passed on to the upstream in a request header.
+type GeoField string
+
+const (
+	GeoFieldCountry    GeoField = "country"
+	GeoFieldContinent  GeoField = "continent"
+	GeoFieldRegion     GeoField = "region"
+	GeoFieldCity       GeoField = "city"
+	GeoFieldPostalCode GeoField = "postal_code"
+	GeoFieldLatitude   GeoField = "latitude"
+	GeoFieldLongitude  GeoField = "longitude"
+	GeoFieldTimeZone   GeoField = "timezone"
+	GeoFieldASN        GeoField = "asn"
+	GeoFieldOrg        GeoField = "org"
+)
+
+// The header each field is sent in, unless another is named
+var geoFieldHeaders = map[GeoField]string{
+	GeoFieldCountry:    "X-GeoIP-Country",
+	GeoFieldContinent:  "X-GeoIP-Continent",
+	GeoFieldRegion:     "X-GeoIP-Region",
+	GeoFieldCity:       "X-GeoIP-City",
+	GeoFieldPostalCode: "X-GeoIP-Postal-Code",
+	GeoFieldLatitude:   "X-GeoIP-Latitude",
+	GeoFieldLongitude:  "X-GeoIP-Longitude",
+	GeoFieldTimeZone:   "X-GeoIP-Timezone",
+	GeoFieldASN:        "X-GeoIP-ASN",
+	GeoFieldOrg:        "X-GeoIP-Org",
+}
+
+var (
+	ErrInvalidGeoHeader   = errors.New("geo header must be in the form field[=Header-Name], where field is one of country, continent, region, city, postal_code, latitude, longitude, timezone, asn or org")
+	ErrDuplicateGeoHeader = errors.New("geo header is named more than once")
+)
+
+// DefaultGeoHeaders passes on just the country, as X-GeoIP-Country.
+var DefaultGeoHeaders = GeoHeaders{{Field: GeoFieldCountry, Name: http.CanonicalHeaderKey(geoFieldHeaders[GeoFieldCountry])}}
+
+// GeoHeader passes a field on to the upstream in a request header.
+type GeoHeader struct {
+	Field GeoField
+	Name  string
+}
+
+// ParseGeoHeader parses `field[=Header-Name]`, such as `country=CF-IPCountry`.
+// Without a name, the field's usual X-GeoIP- header is used.
+func ParseGeoHeader(value string) (GeoHeader, error) {
+	field, name, named := strings.Cut(value, "=")
+	header := GeoHeader{Field: GeoField(strings.ToLower(strings.TrimSpace(field)))}
+
+	defaultName, ok := geoFieldHeaders[header.Field]
+	if !ok {
+		return GeoHeader{}, ErrInvalidGeoHeader
+	}
+
+	if named {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t:") {
+			return GeoHeader{}, ErrInvalidGeoHeader
+		}
+	} else {
+		name = defaultName
+	}
+	header.Name = http.CanonicalHeaderKey(name)
+
+	return header, nil
+}
+
+// String formats the header in the form that ParseGeoHeader reads.
+func (h GeoHeader) String() string {
+	return string(h.Field) + "=" + h.Name
+}
+
+// GeoHeaders are the fields passed on to the upstream.
+type GeoHeaders []GeoHeader
+
+// ParseGeoHeaders parses each of the values, making sure no header is named
+// twice.
+func ParseGeoHeaders(values []string) (GeoHeaders, error) {
+	headers := GeoHeaders{}
+	names := []string{}
+
+	for _, value := range values {
+		header, err := ParseGeoHeader(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w, not %q", err, value)
+		}
+		if slices.Contains(names, header.Name) {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateGeoHeader, header.Name)
+		}
+
+		names = append(names, header.Name)
+		headers = append(headers, header)
+	}
+
+	return headers, nil
+}
+
+// String formats the headers in the form that ParseGeoHeaders reads, joined
+// by commas.
+func (h GeoHeaders) String() string {
+	values := make([]string, len(h))
+	for i, header := range h {
+		values[i] = header.String()
+	}
+	return strings.Join(values, ",")
+}
+
+// Apply sets the headers for the fields that are known of the client.
+func (h GeoHeaders) Apply(header http.Header, info GeoInfo) {
+	for _, geoHeader := range h {
+		value := geoHeader.Field.value(info)
+		if value != "" {
+			header.Set(geoHeader.Name, value)
+		}
+	}
+}
+
+// Strip removes the headers, so that clients can't supply their own.
+func (h GeoHeaders) Strip(header http.Header) {
+	for _, geoHeader := range h {
+		header.Del(geoHeader.Name)
+	}
+}
+
+// Private
+
+func (f GeoField) value(info GeoInfo) string {
+	switch f {
+	case GeoFieldCountry:
+		return info.Country
+	case GeoFieldContinent:
+		return info.Continent
+	case GeoFieldRegion:
+		return info.Region
+	case GeoFieldCity:
+		return info.City
+	case GeoFieldPostalCode:
+		return info.PostalCode
+	case GeoFieldLatitude:
+		if info.Located {
+			return strconv.FormatFloat(info.Latitude, 'f', -1, 64)
+		}
+	case GeoFieldLongitude:
+		if info.Located {
+			return strconv.FormatFloat(info.Longitude, 'f', -1, 64)
+		}
+	case GeoFieldTimeZone:
+		return info.TimeZone
+	case GeoFieldASN:
+		if info.ASN != 0 {
+			return strconv.FormatUint(uint64(info.ASN), 10)
+		}
+	case GeoFieldOrg:
+		return info.ASOrganization
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGeoHeader(t *testing.T) {
+	tests := map[string]GeoHeader{
+		"country":                {Field: GeoFieldCountry, Name: "X-Geoip-Country"},
+		"Country=CF-IPCountry":   {Field: GeoFieldCountry, Name: "Cf-Ipcountry"},
+		" postal_code ":          {Field: GeoFieldPostalCode, Name: "X-Geoip-Postal-Code"},
+		"latitude = X-Latitude ": {Field: GeoFieldLatitude, Name: "X-Latitude"},
+		"asn":                    {Field: GeoFieldASN, Name: "X-Geoip-Asn"},
+	}
+
+	for value, expected := range tests {
+		header, err := ParseGeoHeader(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, header, value)
+	}
+
+	for _, value := range []string{"", "zip", "country=", "country=X Country", "city=X-City:"} {
+		_, err := ParseGeoHeader(value)
+		assert.ErrorIs(t, err, ErrInvalidGeoHeader, value)
+	}
+}
+
+func TestParseGeoHeaders(t *testing.T) {
+	headers, err := ParseGeoHeaders([]string{"country=CF-IPCountry", "city", "timezone=CF-Timezone"})
+	require.NoError(t, err)
+	assert.Equal(t, GeoHeaders{
+		{Field: GeoFieldCountry, Name: "Cf-Ipcountry"},
+		{Field: GeoFieldCity, Name: "X-Geoip-City"},
+		{Field: GeoFieldTimeZone, Name: "Cf-Timezone"},
+	}, headers)
+
+	_, err = ParseGeoHeaders([]string{"country=X-Place", "city=x-place"})
+	assert.ErrorIs(t, err, ErrDuplicateGeoHeader)
+
+	_, err = ParseGeoHeaders([]string{"country", "city=X-GeoIP-Country"})
+	assert.ErrorIs(t, err, ErrDuplicateGeoHeader)
+
+	_, err = ParseGeoHeaders([]string{"country", "zip"})
+	assert.ErrorIs(t, err, ErrInvalidGeoHeader)
+	assert.ErrorContains(t, err, `"zip"`)
+}
+
+func TestGeoHeaders_Apply(t *testing.T) {
+	headers, err := ParseGeoHeaders([]string{"country", "continent", "region", "city", "postal_code", "latitude", "longitude", "timezone", "asn", "org"})
+	require.NoError(t, err)
+
+	header := http.Header{}
+	headers.Apply(header, GeoInfo{
+		Country:        "GB",
+		Continent:      "EU",
+		Region:         "England",
+		City:           "London",
+		PostalCode:     "EC2V",
+		TimeZone:       "Europe/London",
+		Latitude:       51.5142,
+		Longitude:      -0.0931,
+		Located:        true,
+		ASN:            20712,
+		ASOrganization: "Andrews & Arnold Ltd",
+	})
+
+	assert.Equal(t, http.Header{
+		"X-Geoip-Country":     {"GB"},
+		"X-Geoip-Continent":   {"EU"},
+		"X-Geoip-Region":      {"England"},
+		"X-Geoip-City":        {"London"},
+		"X-Geoip-Postal-Code": {"EC2V"},
+		"X-Geoip-Latitude":    {"51.5142"},
+		"X-Geoip-Longitude":   {"-0.0931"},
+		"X-Geoip-Timezone":    {"Europe/London"},
+		"X-Geoip-Asn":         {"20712"},
+		"X-Geoip-Org":         {"Andrews & Arnold Ltd"},
+	}, header)
+
+	// Fields that aren't known are left out
+	header = http.Header{}
+	headers.Apply(header, GeoInfo{Country: "BT"})
+	assert.Equal(t, http.Header{"X-Geoip-Country": {"BT"}}, header)
+}
+
+func TestGeoIPMiddleware_geo_headers(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-City-Test.mmdb", "GeoLite2-ASN-Test.mmdb")
+
+	var received http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	})
+
+	headers, err := ParseGeoHeaders([]string{"country=CF-IPCountry", "city=CF-IPCity", "latitude=CF-IPLatitude", "asn"})
+	require.NoError(t, err)
+
+	middleware := NewGeoIPMiddleware(resolver, slog.Default(), next, nil, nil, BlockPolicies{})
+	middleware.SetGeoHeaders(headers)
+
+	request := func(remoteAddr string, header http.Header) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("sets the chosen headers", func(t *testing.T) {
+		request("81.2.69.142:1234", nil)
+
+		assert.Equal(t, "GB", received.Get("CF-IPCountry"))
+		assert.Equal(t, "London", received.Get("CF-IPCity"))
+		assert.Equal(t, "51.5142", received.Get("CF-IPLatitude"))
+		assert.Equal(t, "20712", received.Get("X-GeoIP-ASN"))
+		assert.Empty(t, received.Get("X-GeoIP-Country"))
+	})
+
+	t.Run("replaces headers sent by the client", func(t *testing.T) {
+		request("67.43.156.1:1234", http.Header{"Cf-Ipcountry": {"US"}, "Cf-Ipcity": {"Seattle"}})
+
+		assert.Equal(t, "BT", received.Get("CF-IPCountry"))
+		assert.Empty(t, received.Values("CF-IPCity"))
+		assert.Empty(t, received.Values("CF-IPLatitude"))
+	})
+
+	t.Run("removes headers sent by local clients", func(t *testing.T) {
+		request("127.0.0.1:1234", http.Header{"Cf-Ipcountry": {"US"}})
+
+		assert.Empty(t, received.Values("CF-IPCountry"))
+	})
+}
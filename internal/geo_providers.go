@@ -0,0 +1,326 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoProvider is a vendor of address databases, whose format and layout we
+// know how to read.
+type GeoProvider string
+
+const (
+	// GeoProviderMaxMind reads GeoIP2 and GeoLite2 databases.
+	GeoProviderMaxMind GeoProvider = "maxmind"
+	// GeoProviderDBIP reads DB-IP databases in MMDB format, which follow
+	// MaxMind's layout.
+	GeoProviderDBIP GeoProvider = "dbip"
+	// GeoProviderIPinfo reads IPinfo databases in MMDB format.
+	GeoProviderIPinfo GeoProvider = "ipinfo"
+	// GeoProviderIP2Location reads IP2Location BIN databases.
+	GeoProviderIP2Location GeoProvider = "ip2location"
+)
+
+var GeoProviders = []GeoProvider{GeoProviderMaxMind, GeoProviderDBIP, GeoProviderIPinfo, GeoProviderIP2Location}
+
+var (
+	ErrInvalidGeoProvider  = errors.New("GeoIP provider must be one of maxmind, dbip, ipinfo or ip2location")
+	ErrUnsupportedDatabase = errors.New("not a Country, City or ASN database")
+)
+
+func ParseGeoProvider(value string) (GeoProvider, error) {
+	provider := GeoProvider(strings.ToLower(strings.TrimSpace(value)))
+	for _, known := range GeoProviders {
+		if provider == known {
+			return provider, nil
+		}
+	}
+	return "", fmt.Errorf("%w, not %q", ErrInvalidGeoProvider, value)
+}
+
+// Private
+
+// databaseNames are the names the provider's databases are published under,
+// as glob patterns, in order of precedence.
+func (p GeoProvider) databaseNames() []string {
+	switch p {
+	case GeoProviderDBIP:
+		return []string{"dbip-location*.mmdb", "dbip-city*.mmdb", "dbip-country*.mmdb", "dbip-isp*.mmdb", "dbip-asn*.mmdb"}
+	case GeoProviderIPinfo:
+		return []string{"standard_location.mmdb", "location.mmdb", "ipinfo_lite.mmdb", "country_asn.mmdb", "country.mmdb", "asn.mmdb"}
+	case GeoProviderIP2Location:
+		return []string{"IP2LOCATION-*.BIN"}
+	default:
+		return []string{"GeoIP2-City.mmdb", "GeoLite2-City.mmdb", "GeoIP2-Country.mmdb", "GeoLite2-Country.mmdb", "GeoIP2-ISP.mmdb", "GeoLite2-ASN.mmdb"}
+	}
+}
+
+func (p GeoProvider) open(path string) (GeoDatabase, error) {
+	switch p {
+	case GeoProviderIP2Location:
+		return openIP2LocationDatabase(path)
+	case GeoProviderIPinfo:
+		return openIPinfoDatabase(path)
+	default:
+		return openMaxMindDatabase(path, p)
+	}
+}
+
+// mmdbDatabase is a database in the MaxMind DB format, which vendors other
+// than MaxMind use too, each with a layout of their own.
+type mmdbDatabase struct {
+	info   GeoDatabaseInfo
+	reader *maxminddb.Reader
+}
+
+func openMMDB(path string, provider GeoProvider) (mmdbDatabase, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return mmdbDatabase{}, err
+	}
+
+	return mmdbDatabase{
+		info: GeoDatabaseInfo{
+			Path:         path,
+			Provider:     provider,
+			DatabaseType: reader.Metadata.DatabaseType,
+			BuildDate:    time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC(),
+		},
+		reader: reader,
+	}, nil
+}
+
+func (d *mmdbDatabase) Info() GeoDatabaseInfo {
+	return d.info
+}
+
+func (d *mmdbDatabase) Close() error {
+	return d.reader.Close()
+}
+
+// maxMindDatabase reads databases in MaxMind's layout, which DB-IP follows
+// too.
+type maxMindDatabase struct {
+	mmdbDatabase
+}
+
+type maxMindRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+		TimeZone  string  `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+
+	// ASN databases have these at the top, and Enterprise ones in traits
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+	Traits                       struct {
+		AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+		AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+	} `maxminddb:"traits"`
+}
+
+func openMaxMindDatabase(path string, provider GeoProvider) (GeoDatabase, error) {
+	database, err := openMMDB(path, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, ok := maxMindDatabaseKind(database.info.DatabaseType)
+	if !ok {
+		database.Close()
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDatabase, database.info.DatabaseType)
+	}
+	database.info.Kind = kind
+
+	return &maxMindDatabase{database}, nil
+}
+
+func (d *maxMindDatabase) Lookup(ip net.IP, info *GeoInfo) error {
+	var record maxMindRecord
+	err := d.reader.Lookup(ip, &record)
+	if err != nil {
+		return err
+	}
+
+	found := GeoInfo{
+		Country:        record.Country.IsoCode,
+		Continent:      record.Continent.Code,
+		City:           record.City.Names["en"],
+		PostalCode:     record.Postal.Code,
+		TimeZone:       record.Location.TimeZone,
+		ASN:            record.AutonomousSystemNumber,
+		ASOrganization: record.AutonomousSystemOrganization,
+	}
+	// The largest subdivision, such as a state or a country of the UK
+	if len(record.Subdivisions) > 0 {
+		found.Region = record.Subdivisions[0].Names["en"]
+	}
+	if found.ASN == 0 {
+		found.ASN = record.Traits.AutonomousSystemNumber
+		found.ASOrganization = record.Traits.AutonomousSystemOrganization
+	}
+
+	// The database has no coordinates for some addresses, which it gives as
+	// 0, 0, out in the Gulf of Guinea
+	if record.Location.Latitude != 0 || record.Location.Longitude != 0 {
+		found.Latitude = record.Location.Latitude
+		found.Longitude = record.Location.Longitude
+		found.Located = true
+	}
+
+	info.merge(found)
+	return nil
+}
+
+func maxMindDatabaseKind(databaseType string) (GeoDatabaseKind, bool) {
+	switch {
+	case strings.Contains(databaseType, "ASN"), strings.Contains(databaseType, "ISP") && !strings.Contains(databaseType, "Enterprise"):
+		return GeoDatabaseASN, true
+	case strings.Contains(databaseType, "City"), strings.Contains(databaseType, "Enterprise"), strings.Contains(databaseType, "Location"):
+		return GeoDatabaseCity, true
+	case strings.Contains(databaseType, "Country"):
+		return GeoDatabaseCountry, true
+	default:
+		return "", false
+	}
+}
+
+// ipinfoDatabase reads IPinfo's layout, in which fields are flat, mostly
+// strings, and named differently from one product to the next.
+type ipinfoDatabase struct {
+	mmdbDatabase
+}
+
+type ipinfoRecord struct {
+	Country       string `maxminddb:"country"`
+	CountryCode   string `maxminddb:"country_code"`
+	ContinentCode string `maxminddb:"continent_code"`
+	Region        string `maxminddb:"region"`
+	City          string `maxminddb:"city"`
+	PostalCode    string `maxminddb:"postal_code"`
+	TimeZone      string `maxminddb:"timezone"`
+	Latitude      any    `maxminddb:"lat"`
+	Longitude     any    `maxminddb:"lng"`
+	ASN           any    `maxminddb:"asn"`
+	Name          string `maxminddb:"name"`
+	ASName        string `maxminddb:"as_name"`
+}
+
+func openIPinfoDatabase(path string) (GeoDatabase, error) {
+	database, err := openMMDB(path, GeoProviderIPinfo)
+	if err != nil {
+		return nil, err
+	}
+	database.info.Kind = ipinfoDatabaseKind(strings.ToLower(database.info.DatabaseType + " " + path))
+
+	return &ipinfoDatabase{database}, nil
+}
+
+func (d *ipinfoDatabase) Lookup(ip net.IP, info *GeoInfo) error {
+	var record ipinfoRecord
+	err := d.reader.Lookup(ip, &record)
+	if err != nil {
+		return err
+	}
+
+	// Products with country_code give the country's name as country
+	found := GeoInfo{
+		Country:    record.CountryCode,
+		Continent:  record.ContinentCode,
+		Region:     record.Region,
+		City:       record.City,
+		PostalCode: record.PostalCode,
+		TimeZone:   record.TimeZone,
+	}
+	if found.Country == "" && len(record.Country) == 2 {
+		found.Country = record.Country
+	}
+
+	latitude, latitudeOK := ipinfoFloat(record.Latitude)
+	longitude, longitudeOK := ipinfoFloat(record.Longitude)
+	if latitudeOK && longitudeOK && (latitude != 0 || longitude != 0) {
+		found.Latitude = latitude
+		found.Longitude = longitude
+		found.Located = true
+	}
+
+	found.ASN = ipinfoASN(record.ASN)
+	if found.ASN != 0 {
+		found.ASOrganization = record.ASName
+		if found.ASOrganization == "" {
+			found.ASOrganization = record.Name
+		}
+	}
+
+	info.merge(found)
+	return nil
+}
+
+func ipinfoDatabaseKind(name string) GeoDatabaseKind {
+	switch {
+	case strings.Contains(name, "location"), strings.Contains(name, "city"):
+		return GeoDatabaseCity
+	case strings.Contains(name, "country"), strings.Contains(name, "lite"):
+		return GeoDatabaseCountry
+	case strings.Contains(name, "asn"):
+		return GeoDatabaseASN
+	default:
+		return GeoDatabaseCountry
+	}
+}
+
+func ipinfoFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// ipinfoASN reads an ASN given as a string like "AS15169", or as a number.
+func ipinfoASN(value any) uint {
+	switch v := value.(type) {
+	case string:
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(v), "AS"), 10, 32)
+		if err != nil {
+			return 0
+		}
+		return uint(asn)
+	case uint64:
+		return uint(v)
+	case uint32:
+		return uint(v)
+	case uint16:
+		return uint(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGeoProvider(t *testing.T) {
+	for value, expected := range map[string]GeoProvider{
+		"maxmind":      GeoProviderMaxMind,
+		"dbip":         GeoProviderDBIP,
+		" IPinfo ":     GeoProviderIPinfo,
+		"IP2Location":  GeoProviderIP2Location,
+		"ip2location ": GeoProviderIP2Location,
+	} {
+		provider, err := ParseGeoProvider(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, provider, value)
+	}
+
+	for _, value := range []string{"", "geoip2", "db-ip"} {
+		_, err := ParseGeoProvider(value)
+		assert.ErrorIs(t, err, ErrInvalidGeoProvider, value)
+	}
+}
+
+func TestGeoProvider_dbip(t *testing.T) {
+	resolver := openTestGeoResolverFor(t, GeoProviderDBIP, "dbip-city-lite-test.mmdb")
+
+	info, err := resolver.Lookup(net.ParseIP("89.160.20.115"))
+	require.NoError(t, err)
+	assert.Equal(t, "SE", info.Country)
+	assert.Equal(t, "Linköping", info.City)
+	assert.True(t, info.Located)
+	assert.InDelta(t, 58.41086, info.Latitude, 1e-6)
+	assert.InDelta(t, 15.62157, info.Longitude, 1e-6)
+
+	info, err = resolver.Lookup(net.ParseIP("1.1.1.1"))
+	require.NoError(t, err)
+	assert.Equal(t, GeoInfo{}, info)
+
+	database := resolver.Databases()[0]
+	assert.Equal(t, GeoProviderDBIP, database.Provider)
+	assert.Equal(t, GeoDatabaseCity, database.Kind)
+	assert.Equal(t, "DBIP-City-Lite", database.DatabaseType)
+	assert.True(t, resolver.CanLocate())
+}
+
+func TestGeoProvider_ipinfo(t *testing.T) {
+	resolver := openTestGeoResolverFor(t, GeoProviderIPinfo, "ipinfo-location-test.mmdb", "ipinfo-lite-test.mmdb")
+
+	info, err := resolver.Lookup(net.ParseIP("81.2.69.142"))
+	require.NoError(t, err)
+	assert.Equal(t, "GB", info.Country)
+	assert.Equal(t, "EU", info.Continent)
+	assert.Equal(t, "England", info.Region)
+	assert.Equal(t, "London", info.City)
+	assert.Equal(t, "EC1A", info.PostalCode)
+	assert.Equal(t, "Europe/London", info.TimeZone)
+	assert.True(t, info.Located)
+	assert.InDelta(t, 51.50853, info.Latitude, 1e-6)
+	assert.InDelta(t, -0.12574, info.Longitude, 1e-6)
+	assert.Equal(t, uint(20712), info.ASN)
+	assert.Equal(t, "Andrews & Arnold Ltd", info.ASOrganization)
+
+	// Only the Lite database knows this one, and gives the country's name as
+	// country, which isn't taken for its code
+	info, err = resolver.Lookup(net.ParseIP("2001:218::1"))
+	require.NoError(t, err)
+	assert.Equal(t, "JP", info.Country)
+	assert.False(t, info.Located)
+	assert.Equal(t, uint(2914), info.ASN)
+
+	databases := resolver.Databases()
+	require.Len(t, databases, 2)
+	assert.Equal(t, GeoDatabaseCity, databases[0].Kind)
+	assert.Equal(t, GeoDatabaseCountry, databases[1].Kind)
+}
+
+func TestGeoProvider_wrong_database(t *testing.T) {
+	resolver, err := OpenGeoResolver(GeoProviderMaxMind, []string{fixturePath("IP2LOCATION-LITE-DB5-TEST.BIN")})
+	assert.Error(t, err)
+	assert.Nil(t, resolver)
+
+	resolver, err = OpenGeoResolver(GeoProviderIP2Location, []string{fixturePath("GeoLite2-Country.mmdb")})
+	assert.ErrorIs(t, err, ErrInvalidIP2LocationDatabase)
+	assert.Nil(t, resolver)
+}
+
+func TestFindGeoIP2Databases_providers(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Discovery goes by name, and only opening the databases reads them
+	for _, name := range []string{"dbip-city-lite-2026-09.mmdb", "dbip-city-lite-2026-10.mmdb", "IP2LOCATION-LITE-DB5.BIN"} {
+		require.NoError(t, os.WriteFile(name, nil, 0o644))
+	}
+
+	for provider, name := range map[GeoProvider]string{
+		GeoProviderDBIP:        "dbip-city-lite-2026-10.mmdb",
+		GeoProviderIP2Location: "IP2LOCATION-LITE-DB5.BIN",
+	} {
+		expected, err := filepath.Abs(name)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{expected}, FindGeoIP2Databases(provider), provider)
+	}
+
+	assert.Empty(t, FindGeoIP2Databases(GeoProviderIPinfo))
+}
+
+func TestMaxMindDatabaseKind(t *testing.T) {
+	tests := map[string]GeoDatabaseKind{
+		"GeoLite2-Country":                    GeoDatabaseCountry,
+		"GeoIP2-Country":                      GeoDatabaseCountry,
+		"DBIP-Country-Lite":                   GeoDatabaseCountry,
+		"GeoLite2-City":                       GeoDatabaseCity,
+		"GeoIP2-Precision-City":               GeoDatabaseCity,
+		"GeoIP2-Enterprise":                   GeoDatabaseCity,
+		"DBIP-City-Lite":                      GeoDatabaseCity,
+		"DBIP-Location (compat=City)":         GeoDatabaseCity,
+		"DBIP-ISP (compat=Enterprise)":        GeoDatabaseCity,
+		"GeoLite2-ASN":                        GeoDatabaseASN,
+		"DBIP-ASN-Lite (compat=GeoLite2-ASN)": GeoDatabaseASN,
+		"GeoIP2-ISP":                          GeoDatabaseASN,
+	}
+
+	for databaseType, expected := range tests {
+		kind, ok := maxMindDatabaseKind(databaseType)
+		assert.True(t, ok, databaseType)
+		assert.Equal(t, expected, kind, databaseType)
+	}
+
+	_, ok := maxMindDatabaseKind("GeoIP2-Anonymous-IP")
+	assert.False(t, ok)
+}
+
+func TestIPinfoDatabaseKind(t *testing.T) {
+	tests := map[string]GeoDatabaseKind{
+		"ipinfo standard_location.mmdb": GeoDatabaseCity,
+		"ipinfo location.mmdb":          GeoDatabaseCity,
+		"ipinfo ipinfo_lite.mmdb":       GeoDatabaseCountry,
+		"ipinfo country_asn.mmdb":       GeoDatabaseCountry,
+		"ipinfo country.mmdb":           GeoDatabaseCountry,
+		"ipinfo asn.mmdb":               GeoDatabaseASN,
+	}
+
+	for name, expected := range tests {
+		assert.Equal(t, expected, ipinfoDatabaseKind(name), name)
+	}
+}
+
+// Helpers
+
+func openTestGeoResolverFor(t testing.TB, provider GeoProvider, names ...string) *GeoResolver {
+	t.Helper()
+
+	paths := []string{}
+	for _, name := range names {
+		paths = append(paths, fixturePath(name))
+	}
+
+	resolver, err := OpenGeoResolver(provider, paths)
+	require.NoError(t, err)
+	t.Cleanup(func() { resolver.Close() })
+
+	return resolver
+}
@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GeoInfo is what the databases know of an address, merged into one. Fields
+// are empty when no database knows them.
+type GeoInfo struct {
+	Country    string
+	Continent  string
+	Region     string
+	City       string
+	PostalCode string
+	TimeZone   string
+
+	// Latitude and Longitude are where the address is, when Located is set.
+	// Only City databases have them.
+	Latitude  float64
+	Longitude float64
+	Located   bool
+
+	ASN            uint
+	ASOrganization string
+}
+
+// ASNTag formats the ASN as it's written in request tags, like "AS15169", or
+// returns an empty string when it's unknown.
+func (i GeoInfo) ASNTag() string {
+	if i.ASN == 0 {
+		return ""
+	}
+	return "AS" + strconv.FormatUint(uint64(i.ASN), 10)
+}
+
+// merge fills in the fields of info that are empty from other.
+func (i *GeoInfo) merge(other GeoInfo) {
+	if i.Country == "" {
+		i.Country = other.Country
+	}
+	if i.Continent == "" {
+		i.Continent = other.Continent
+	}
+	if i.Region == "" {
+		i.Region = other.Region
+	}
+	if i.City == "" {
+		i.City = other.City
+	}
+	if i.PostalCode == "" {
+		i.PostalCode = other.PostalCode
+	}
+	if i.TimeZone == "" {
+		i.TimeZone = other.TimeZone
+	}
+	if !i.Located && other.Located {
+		i.Latitude = other.Latitude
+		i.Longitude = other.Longitude
+		i.Located = true
+	}
+	if i.ASN == 0 && other.ASN != 0 {
+		i.ASN = other.ASN
+		i.ASOrganization = other.ASOrganization
+	}
+}
+
+// GeoDatabaseKind is what a database knows of addresses. City databases know
+// the country too.
+type GeoDatabaseKind string
+
+const (
+	GeoDatabaseCountry GeoDatabaseKind = "country"
+	GeoDatabaseCity    GeoDatabaseKind = "city"
+	GeoDatabaseASN     GeoDatabaseKind = "asn"
+)
+
+// GeoDatabase is a database of addresses in one vendor's format.
+type GeoDatabase interface {
+	// Lookup fills in the fields of info that the database knows, and that
+	// no earlier database did.
+	Lookup(ip net.IP, info *GeoInfo) error
+	Info() GeoDatabaseInfo
+	Close() error
+}
+
+// GeoDatabaseInfo describes a database.
+type GeoDatabaseInfo struct {
+	Path         string          `json:"path"`
+	Provider     GeoProvider     `json:"provider"`
+	Kind         GeoDatabaseKind `json:"kind"`
+	DatabaseType string          `json:"database_type"`
+	BuildDate    time.Time       `json:"build_date"`
+}
+
+// GeoResolver looks addresses up in any number of databases at once, such as
+// a Country or City database alongside an ASN one, and merges what they know.
+// When more than one database knows a field, the first one opened wins.
+type GeoResolver struct {
+	provider  GeoProvider
+	databases []GeoDatabase
+}
+
+// OpenGeoResolver opens the provider's databases at the paths, all at once.
+// Databases that can't be opened are left out, so that the others can still
+// be used, with their errors returned alongside the resolver. When none can be
+// opened, the resolver is nil.
+func OpenGeoResolver(provider GeoProvider, paths []string) (*GeoResolver, error) {
+	databases := make([]GeoDatabase, len(paths))
+	errs := make([]error, len(paths))
+
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			database, err := provider.open(path)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", path, err)
+				return
+			}
+			databases[i] = database
+		}()
+	}
+	wg.Wait()
+
+	resolver := &GeoResolver{provider: provider}
+	for _, database := range databases {
+		if database != nil {
+			resolver.databases = append(resolver.databases, database)
+		}
+	}
+
+	if len(resolver.databases) == 0 {
+		resolver = nil
+	}
+
+	return resolver, errors.Join(errs...)
+}
+
+// Lookup returns what the databases know of the address. It fails only when
+// every database does.
+func (r *GeoResolver) Lookup(ip net.IP) (GeoInfo, error) {
+	err := injectLookupFault()
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	var info GeoInfo
+	var errs []error
+	for _, database := range r.databases {
+		err := database.Lookup(ip, &info)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(r.databases) {
+		return GeoInfo{}, errors.Join(errs...)
+	}
+	return info, nil
+}
+
+// CanLocate reports whether any of the databases have coordinates.
+func (r *GeoResolver) CanLocate() bool {
+	return r.has(GeoDatabaseCity)
+}
+
+// HasCountries reports whether any of the databases have countries.
+func (r *GeoResolver) HasCountries() bool {
+	return r.has(GeoDatabaseCountry) || r.has(GeoDatabaseCity)
+}
+
+// Provider is the vendor of the databases.
+func (r *GeoResolver) Provider() GeoProvider {
+	return r.provider
+}
+
+// Databases describes the databases open, in the order they're consulted.
+func (r *GeoResolver) Databases() []GeoDatabaseInfo {
+	infos := []GeoDatabaseInfo{}
+	for _, database := range r.databases {
+		infos = append(infos, database.Info())
+	}
+	return infos
+}
+
+func (r *GeoResolver) Close() error {
+	var errs []error
+	for _, database := range r.databases {
+		errs = append(errs, database.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// GeoInfoFromContext returns what the GeoIP middleware learned of the
+// request's client, if it looked the client up.
+func GeoInfoFromContext(ctx context.Context) (GeoInfo, bool) {
+	info, ok := ctx.Value(geoInfoKey{}).(GeoInfo)
+	return info, ok
+}
+
+var ErrGeoIP2DatabaseNotFound = errors.New("GeoIP2 database not found")
+
+// OpenGeoIP2Databases opens the provider's databases at the paths given, or
+// when none are, those found in the usual locations.
+//
+// Databases given by path must all open, or an error is returned. Failing to
+// find or open the others is not fatal, as we can still proxy requests without
+// filtering them, so this logs a warning and returns nil instead.
+func OpenGeoIP2Databases(provider GeoProvider, paths []string) (*GeoResolver, error) {
+	explicit := len(paths) > 0
+	if !explicit {
+		paths = FindGeoIP2Databases(provider)
+	}
+	if len(paths) == 0 {
+		slog.Warn("No GeoIP2 database found. NOT loading the GeoIP2 middleware for IP filtering.", "provider", provider, "locations", geoIP2DatabaseDirs, "names", provider.databaseNames())
+		return nil, nil
+	}
+
+	resolver, err := OpenGeoResolver(provider, paths)
+	if err != nil && explicit {
+		if resolver != nil {
+			resolver.Close()
+		}
+		return nil, fmt.Errorf("failed to open GeoIP2 database: %w", err)
+	}
+	if err != nil {
+		slog.Warn("Failed to open GeoIP2 database", "error", err)
+	}
+	if resolver == nil {
+		slog.Warn("NOT loading the GeoIP2 middleware for IP filtering.")
+		return nil, nil
+	}
+
+	for _, database := range resolver.Databases() {
+		slog.Info("Loaded GeoIP2 database", "path", database.Path, "provider", database.Provider, "kind", database.Kind, "database_type", database.DatabaseType)
+	}
+	return resolver, nil
+}
+
+// CheckGeoIP2DatabasePaths ensures that there's a file at each of the paths,
+// before going to the trouble of opening them.
+func CheckGeoIP2DatabasePaths(paths []string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w at %s", ErrGeoIP2DatabaseNotFound, path)
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%w at %s, which is a directory", ErrGeoIP2DatabaseNotFound, path)
+		}
+	}
+	return nil
+}
+
+// FindGeoIP2Databases returns the provider's databases found in the usual
+// locations, City databases first, then Country, then ASN, taking the first
+// location of each. Where a name matches more than one file, as with the dated
+// names DB-IP uses, the last in order is taken, which is the newest.
+func FindGeoIP2Databases(provider GeoProvider) []string {
+	paths := []string{}
+
+	for _, name := range provider.databaseNames() {
+		for _, dir := range geoIP2DatabaseDirs {
+			matches, err := filepath.Glob(filepath.Join(dir, name))
+			if err != nil || len(matches) == 0 {
+				continue
+			}
+
+			path, err := filepath.Abs(slices.Max(matches))
+			if err != nil {
+				continue
+			}
+			if !slices.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+			break
+		}
+	}
+
+	return paths
+}
+
+// Private
+
+type geoInfoKey struct{}
+
+var geoIP2DatabaseDirs = []string{".", "./data", "./storage"}
+
+func withGeoInfo(ctx context.Context, info GeoInfo) context.Context {
+	return context.WithValue(ctx, geoInfoKey{}, info)
+}
+
+func (r *GeoResolver) has(kind GeoDatabaseKind) bool {
+	for _, database := range r.databases {
+		if database.Info().Kind == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	_ "embed"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoResolver_Lookup(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-City-Test.mmdb", "GeoLite2-ASN-Test.mmdb")
+
+	info, err := resolver.Lookup(net.ParseIP("81.2.69.142"))
+	require.NoError(t, err)
+	assert.Equal(t, GeoInfo{
+		Country:        "GB",
+		Continent:      "EU",
+		Region:         "England",
+		City:           "London",
+		PostalCode:     "EC2V",
+		TimeZone:       "Europe/London",
+		Latitude:       51.5142,
+		Longitude:      -0.0931,
+		Located:        true,
+		ASN:            20712,
+		ASOrganization: "Andrews & Arnold Ltd",
+	}, info)
+	assert.Equal(t, "AS20712", info.ASNTag())
+
+	info, err = resolver.Lookup(net.ParseIP("2001:218::1"))
+	require.NoError(t, err)
+	assert.Equal(t, "JP", info.Country)
+	assert.Equal(t, uint(2914), info.ASN)
+
+	info, err = resolver.Lookup(net.ParseIP("67.43.156.1"))
+	require.NoError(t, err)
+	assert.Equal(t, "BT", info.Country)
+	assert.False(t, info.Located)
+	assert.Empty(t, info.ASNTag())
+}
+
+func TestGeoResolver_Lookup_country_database(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb", "GeoLite2-ASN-Test.mmdb")
+
+	info, err := resolver.Lookup(net.ParseIP("81.2.69.142"))
+	require.NoError(t, err)
+	assert.Equal(t, "GB", info.Country)
+	assert.Empty(t, info.City)
+	assert.False(t, info.Located)
+	assert.Equal(t, uint(20712), info.ASN)
+
+	assert.False(t, resolver.CanLocate())
+}
+
+func TestGeoResolver_first_database_wins(t *testing.T) {
+	// The test City database puts 89.160.20.112/28 in Sweden, as does the
+	// Country one, but only the City database knows the city
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb", "GeoLite2-City-Test.mmdb")
+
+	info, err := resolver.Lookup(net.ParseIP("89.160.20.115"))
+	require.NoError(t, err)
+	assert.Equal(t, "SE", info.Country)
+	assert.Equal(t, "Linköping", info.City)
+	assert.True(t, info.Located)
+	assert.True(t, resolver.CanLocate())
+}
+
+func TestOpenGeoResolver_missing_databases(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+
+	resolver, err := OpenGeoResolver(GeoProviderMaxMind, []string{missing, fixturePath("GeoLite2-ASN-Test.mmdb")})
+	assert.ErrorContains(t, err, missing)
+	require.NotNil(t, resolver)
+	defer resolver.Close()
+
+	info, err := resolver.Lookup(net.ParseIP("81.2.69.142"))
+	require.NoError(t, err)
+	assert.Empty(t, info.Country)
+	assert.Equal(t, uint(20712), info.ASN)
+	assert.False(t, resolver.HasCountries())
+
+	resolver, err = OpenGeoResolver(GeoProviderMaxMind, []string{missing})
+	assert.Error(t, err)
+	assert.Nil(t, resolver)
+}
+
+func TestGeoResolver_Databases(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-City-Test.mmdb", "GeoLite2-ASN-Test.mmdb")
+
+	databases := resolver.Databases()
+	require.Len(t, databases, 2)
+	assert.Equal(t, fixturePath("GeoLite2-City-Test.mmdb"), databases[0].Path)
+	assert.Equal(t, "GeoIP2-City", databases[0].DatabaseType)
+	assert.Equal(t, "GeoLite2-ASN", databases[1].DatabaseType)
+	assert.Equal(t, GeoProviderMaxMind, databases[0].Provider)
+	assert.Equal(t, GeoDatabaseCity, databases[0].Kind)
+	assert.Equal(t, GeoDatabaseASN, databases[1].Kind)
+	assert.False(t, databases[1].BuildDate.IsZero())
+
+	assert.True(t, resolver.HasCountries())
+}
+
+// Helpers
+
+// testGeoIP2Database is a small Country database, to use where a test needs
+// one somewhere other than the fixtures directory, such as when discovering
+// databases from another working directory. It knows only a handful of
+// networks: 81.2.69.0/24 in GB, 89.160.20.0/24 in SE, 216.160.83.0/24 in US,
+// 67.43.156.0/24 in BT and 2001:218::/32 in JP.
+//
+//go:embed fixtures/GeoLite2-Country-Test.mmdb
+var testGeoIP2Database []byte
+
+// usingTestGeoIP2Database writes the test database to path, and returns the
+// path made absolute.
+func usingTestGeoIP2Database(t *testing.T, path string) string {
+	t.Helper()
+
+	path, err := filepath.Abs(path)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, testGeoIP2Database, 0o644))
+
+	return path
+}
+
+func openTestGeoResolver(t testing.TB, names ...string) *GeoResolver {
+	t.Helper()
+
+	return openTestGeoResolverFor(t, GeoProviderMaxMind, names...)
+}
@@ -0,0 +1,347 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const earthRadiusKm = 6371.0
+
+var ErrInvalidGeofence = errors.New("geofence must be in the form [path:]allow|block LAT LON RADIUS, or [path:]allow|block SOUTH WEST NORTH EAST")
+
+type GeofenceAction string
+
+const (
+	GeofenceAllow GeofenceAction = "allow"
+	GeofenceBlock GeofenceAction = "block"
+)
+
+// GeoArea is a region of the Earth's surface that a location can be in.
+type GeoArea interface {
+	Contains(latitude, longitude float64) bool
+	String() string
+}
+
+// GeoCircle is the area within a distance of a point, measured along the
+// surface of the Earth.
+type GeoCircle struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+func (c GeoCircle) Contains(latitude, longitude float64) bool {
+	return greatCircleDistanceKm(c.Latitude, c.Longitude, latitude, longitude) <= c.RadiusKm
+}
+
+func (c GeoCircle) String() string {
+	return formatCoordinate(c.Latitude) + " " + formatCoordinate(c.Longitude) + " " + formatCoordinate(c.RadiusKm) + "km"
+}
+
+// GeoBox is the area between two lines of latitude and two of longitude. A box
+// whose west edge is east of its east edge crosses the antimeridian.
+type GeoBox struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+func (b GeoBox) Contains(latitude, longitude float64) bool {
+	if latitude < b.South || latitude > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return longitude >= b.West && longitude <= b.East
+	}
+	return longitude >= b.West || longitude <= b.East
+}
+
+func (b GeoBox) String() string {
+	return formatCoordinate(b.South) + " " + formatCoordinate(b.West) + " " + formatCoordinate(b.North) + " " + formatCoordinate(b.East)
+}
+
+// Geofence allows or blocks requests by where the client is, using the
+// coordinates from a City database, for when countries are too coarse, such
+// as for content licensed for a region.
+//
+// When there are allow fences for a path, clients must be inside at least one
+// of them, and clients that can't be located are refused. Clients inside a
+// block fence are refused. Paths are matched as for cache rules, and a fence
+// with no path applies to every request.
+type Geofence struct {
+	Path   string
+	Action GeofenceAction
+	Area   GeoArea
+}
+
+// ParseGeofence parses a fence written as `[path:]allow|block LAT LON RADIUS`
+// for a circle, with the radius in `km`, `mi` or `m`, or as
+// `[path:]allow|block SOUTH WEST NORTH EAST` for a box. For example,
+// `/live/*:allow 51.5074 -0.1278 50km`.
+func ParseGeofence(value string) (Geofence, error) {
+	value = strings.TrimSpace(value)
+
+	var fence Geofence
+	if strings.HasPrefix(value, "/") {
+		fencePath, rest, ok := strings.Cut(value, ":")
+		if !ok {
+			return Geofence{}, ErrInvalidGeofence
+		}
+		if _, err := path.Match(fencePath, ""); err != nil {
+			return Geofence{}, ErrInvalidGeofence
+		}
+		fence.Path = fencePath
+		value = rest
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return Geofence{}, ErrInvalidGeofence
+	}
+
+	fence.Action = GeofenceAction(strings.ToLower(fields[0]))
+	if fence.Action != GeofenceAllow && fence.Action != GeofenceBlock {
+		return Geofence{}, ErrInvalidGeofence
+	}
+
+	var err error
+	switch len(fields) {
+	case 4:
+		fence.Area, err = parseGeoCircle(fields[1:])
+	case 5:
+		fence.Area, err = parseGeoBox(fields[1:])
+	default:
+		err = ErrInvalidGeofence
+	}
+	if err != nil {
+		return Geofence{}, err
+	}
+
+	return fence, nil
+}
+
+// String formats the fence in the form that ParseGeofence reads.
+func (f Geofence) String() string {
+	value := string(f.Action) + " " + f.Area.String()
+	if f.Path != "" {
+		value = f.Path + ":" + value
+	}
+	return value
+}
+
+type Geofences []Geofence
+
+// Check returns the fence that refuses a request for the path from a client at
+// the location, if any. The location is unknown when located is false.
+func (fences Geofences) Check(requestPath string, latitude, longitude float64, located bool) (Geofence, bool) {
+	var allows Geofences
+
+	for _, fence := range fences {
+		if !fence.appliesTo(requestPath) {
+			continue
+		}
+
+		switch fence.Action {
+		case GeofenceBlock:
+			if located && fence.Area.Contains(latitude, longitude) {
+				return fence, true
+			}
+		case GeofenceAllow:
+			if located && fence.Area.Contains(latitude, longitude) {
+				return Geofence{}, false
+			}
+			allows = append(allows, fence)
+		}
+	}
+
+	if len(allows) > 0 {
+		return allows[0], true
+	}
+	return Geofence{}, false
+}
+
+// appliesToAny reports whether any of the fences apply to the path, and so
+// the client has to be located.
+func (fences Geofences) appliesToAny(requestPath string) bool {
+	for _, fence := range fences {
+		if fence.appliesTo(requestPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// GeofenceMiddleware refuses requests from outside the allowed areas, or inside
+// blocked ones. It needs a GeoIP2 City (or Enterprise) database, since Country
+// databases have no coordinates; without one, no client can be located.
+type GeofenceMiddleware struct {
+	resolver    *GeoResolver
+	logger      *slog.Logger
+	next        http.Handler
+	fences      Geofences
+	blockedPage *PageTemplate
+}
+
+func NewGeofenceMiddleware(resolver *GeoResolver, logger *slog.Logger, next http.Handler, fences Geofences) *GeofenceMiddleware {
+	return &GeofenceMiddleware{
+		resolver: resolver,
+		logger:   logger,
+		next:     next,
+		fences:   fences,
+	}
+}
+
+// SetBlockedPage sets the page served to blocked requests, in place of a
+// plain "Access denied".
+func (m *GeofenceMiddleware) SetBlockedPage(page *PageTemplate) {
+	m.blockedPage = page
+}
+
+func (m *GeofenceMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, ip := clientIP(r)
+	if ip == nil || isLocalOrInternalIP(ip) || !m.fences.appliesToAny(r.URL.Path) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	latitude, longitude, located := m.locate(r, ip)
+
+	fence, blocked := m.fences.Check(r.URL.Path, latitude, longitude, located)
+	if !blocked {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	if located {
+		m.logger.InfoContext(r.Context(), "Request blocked - geofence", "fence", fence.String(), "ip", host, "path", r.URL.Path, "latitude", latitude, "longitude", longitude)
+	} else {
+		m.logger.InfoContext(r.Context(), "Request blocked - geofence, client location unknown", "fence", fence.String(), "ip", host, "path", r.URL.Path)
+	}
+
+	m.writeBlocked(w, r, "geofence:"+fence.String())
+}
+
+// Private
+
+// locate uses what the GeoIP middleware found, when it has run, rather than
+// looking the client up again.
+func (m *GeofenceMiddleware) locate(r *http.Request, ip net.IP) (float64, float64, bool) {
+	info, ok := GeoInfoFromContext(r.Context())
+	if !ok {
+		var err error
+		info, err = m.resolver.Lookup(ip)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+
+	return info.Latitude, info.Longitude, info.Located
+}
+
+func (m *GeofenceMiddleware) writeBlocked(w http.ResponseWriter, r *http.Request, rule string) {
+	tags := RequestTagsFromContext(r.Context())
+	tags.Set(TagBlocked, BlockedByGeofence)
+	tags.Set(TagBlockedRule, rule)
+
+	if m.blockedPage != nil {
+		m.blockedPage.Render(w, r, http.StatusForbidden)
+		return
+	}
+
+	http.Error(w, "Access denied", http.StatusForbidden)
+}
+
+func (f Geofence) appliesTo(requestPath string) bool {
+	return f.Path == "" || matchPathPattern(f.Path, requestPath)
+}
+
+func parseGeoCircle(fields []string) (GeoCircle, error) {
+	latitude, longitude, ok := parseCoordinates(fields[0], fields[1])
+	if !ok {
+		return GeoCircle{}, ErrInvalidGeofence
+	}
+
+	radius, ok := parseDistanceKm(fields[2])
+	if !ok {
+		return GeoCircle{}, ErrInvalidGeofence
+	}
+
+	return GeoCircle{Latitude: latitude, Longitude: longitude, RadiusKm: radius}, nil
+}
+
+func parseGeoBox(fields []string) (GeoBox, error) {
+	south, west, ok := parseCoordinates(fields[0], fields[1])
+	if !ok {
+		return GeoBox{}, ErrInvalidGeofence
+	}
+
+	north, east, ok := parseCoordinates(fields[2], fields[3])
+	if !ok || north < south {
+		return GeoBox{}, ErrInvalidGeofence
+	}
+
+	return GeoBox{South: south, West: west, North: north, East: east}, nil
+}
+
+func parseCoordinates(latitudeValue, longitudeValue string) (float64, float64, bool) {
+	latitude, err := strconv.ParseFloat(latitudeValue, 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return 0, 0, false
+	}
+
+	longitude, err := strconv.ParseFloat(longitudeValue, 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return 0, 0, false
+	}
+
+	return latitude, longitude, true
+}
+
+func parseDistanceKm(value string) (float64, bool) {
+	value = strings.ToLower(value)
+
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(value, "km"):
+		value = strings.TrimSuffix(value, "km")
+	case strings.HasSuffix(value, "mi"):
+		value = strings.TrimSuffix(value, "mi")
+		scale = 1.609344
+	case strings.HasSuffix(value, "m"):
+		value = strings.TrimSuffix(value, "m")
+		scale = 0.001
+	default:
+		return 0, false
+	}
+
+	distance, err := strconv.ParseFloat(value, 64)
+	if err != nil || distance <= 0 || math.IsInf(distance, 0) {
+		return 0, false
+	}
+
+	return distance * scale, true
+}
+
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// greatCircleDistanceKm is the distance between two points by the haversine
+// formula, which is accurate to within about half a percent, as it takes the
+// Earth to be a sphere.
+func greatCircleDistanceKm(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	phi1 := latitude1 * math.Pi / 180
+	phi2 := latitude2 * math.Pi / 180
+	deltaPhi := (latitude2 - latitude1) * math.Pi / 180
+	deltaLambda := (longitude2 - longitude1) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
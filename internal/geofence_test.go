@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGeofence(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Geofence
+	}{
+		{"allow 51.5074 -0.1278 50km", Geofence{Action: GeofenceAllow, Area: GeoCircle{51.5074, -0.1278, 50}}},
+		{"BLOCK 51.5074 -0.1278 10mi", Geofence{Action: GeofenceBlock, Area: GeoCircle{51.5074, -0.1278, 16.09344}}},
+		{"block 51.5074 -0.1278 500m", Geofence{Action: GeofenceBlock, Area: GeoCircle{51.5074, -0.1278, 0.5}}},
+		{"/live/*:allow 51.5074 -0.1278 50km", Geofence{Path: "/live/*", Action: GeofenceAllow, Area: GeoCircle{51.5074, -0.1278, 50}}},
+		{"allow 49.9 -8.2 60.9 1.8", Geofence{Action: GeofenceAllow, Area: GeoBox{49.9, -8.2, 60.9, 1.8}}},
+		{" /nz:block -47.3 166.4 -34.4 -178.5 ", Geofence{Path: "/nz", Action: GeofenceBlock, Area: GeoBox{-47.3, 166.4, -34.4, -178.5}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			fence, err := ParseGeofence(tc.value)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected.Path, fence.Path)
+			assert.Equal(t, tc.expected.Action, fence.Action)
+			assert.InDeltaMapValues(t, areaValues(tc.expected.Area), areaValues(fence.Area), 1e-9)
+		})
+	}
+}
+
+func TestParseGeofence_invalid(t *testing.T) {
+	values := []string{
+		"",
+		"allow",
+		"permit 51.5 -0.1 50km",
+		"allow 51.5 -0.1",
+		"allow 51.5 -0.1 50",
+		"allow 51.5 -0.1 50ft",
+		"allow 51.5 -0.1 -50km",
+		"allow 51.5 -0.1 0km",
+		"allow 91 0 50km",
+		"allow 0 181 50km",
+		"allow north west 50km",
+		"allow 60 0 50 1",
+		"allow 50 0 60 1 2",
+		"/live allow 51.5 -0.1 50km",
+		"/[live:allow 51.5 -0.1 50km",
+	}
+
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			_, err := ParseGeofence(value)
+			assert.ErrorIs(t, err, ErrInvalidGeofence)
+		})
+	}
+}
+
+func TestGeofence_String(t *testing.T) {
+	for _, value := range []string{
+		"allow 51.5074 -0.1278 50km",
+		"/live/*:block 49.9 -8.2 60.9 1.8",
+	} {
+		fence, err := ParseGeofence(value)
+		require.NoError(t, err)
+		assert.Equal(t, value, fence.String())
+
+		reparsed, err := ParseGeofence(fence.String())
+		require.NoError(t, err)
+		assert.Equal(t, fence, reparsed)
+	}
+
+	fence, err := ParseGeofence("allow 51.5074 -0.1278 1500m")
+	require.NoError(t, err)
+	assert.Equal(t, "allow 51.5074 -0.1278 1.5km", fence.String())
+}
+
+func TestGeoCircle_Contains(t *testing.T) {
+	london := GeoCircle{Latitude: 51.5074, Longitude: -0.1278, RadiusKm: 50}
+
+	assert.True(t, london.Contains(51.5074, -0.1278))
+	assert.True(t, london.Contains(51.5142, -0.0931))    // The City, about 2.5km away
+	assert.True(t, london.Contains(51.3762, -0.0982))    // Croydon, about 15km away
+	assert.False(t, london.Contains(51.7520, -1.2577))   // Oxford, about 82km away
+	assert.False(t, london.Contains(48.8566, 2.3522))    // Paris, about 344km away
+	assert.False(t, london.Contains(-51.5074, 179.8722)) // The other side of the world
+}
+
+func TestGeoBox_Contains(t *testing.T) {
+	uk := GeoBox{South: 49.9, West: -8.2, North: 60.9, East: 1.8}
+
+	assert.True(t, uk.Contains(51.5074, -0.1278))
+	assert.True(t, uk.Contains(49.9, -8.2))
+	assert.False(t, uk.Contains(48.8566, 2.3522))
+	assert.False(t, uk.Contains(61, 0))
+
+	pacific := GeoBox{South: -47.3, West: 166.4, North: -34.4, East: -178.5}
+
+	assert.True(t, pacific.Contains(-41.2865, 174.7762))
+	assert.True(t, pacific.Contains(-44, -179))
+	assert.False(t, pacific.Contains(-41.2865, 0))
+	assert.False(t, pacific.Contains(-33.8688, 151.2093))
+}
+
+func TestGeofences_Check(t *testing.T) {
+	fences := parseTestGeofences(t,
+		"/live/*:allow 51.5074 -0.1278 50km",
+		"/live/*:allow 58.4108 15.6214 25km",
+		"block 47.2513 -122.3149 5km",
+	)
+
+	london := [2]float64{51.5142, -0.0931}
+	linkoping := [2]float64{58.4167, 15.6167}
+	paris := [2]float64{48.8566, 2.3522}
+	milton := [2]float64{47.2513, -122.3149}
+
+	t.Run("inside an allow fence", func(t *testing.T) {
+		_, blocked := fences.Check("/live/match", london[0], london[1], true)
+		assert.False(t, blocked)
+
+		_, blocked = fences.Check("/live/match", linkoping[0], linkoping[1], true)
+		assert.False(t, blocked)
+	})
+
+	t.Run("outside every allow fence", func(t *testing.T) {
+		fence, blocked := fences.Check("/live/match", paris[0], paris[1], true)
+		assert.True(t, blocked)
+		assert.Equal(t, fences[0], fence)
+	})
+
+	t.Run("allow fences for another path", func(t *testing.T) {
+		_, blocked := fences.Check("/replays/match", paris[0], paris[1], true)
+		assert.False(t, blocked)
+	})
+
+	t.Run("inside a block fence", func(t *testing.T) {
+		fence, blocked := fences.Check("/replays/match", milton[0], milton[1], true)
+		assert.True(t, blocked)
+		assert.Equal(t, fences[2], fence)
+	})
+
+	t.Run("client that can't be located", func(t *testing.T) {
+		_, blocked := fences.Check("/live/match", 0, 0, false)
+		assert.True(t, blocked)
+
+		_, blocked = fences.Check("/replays/match", 0, 0, false)
+		assert.False(t, blocked)
+	})
+}
+
+func TestGeofenceMiddleware(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-City-Test.mmdb")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	fences := parseTestGeofences(t,
+		"/live/*:allow 51.5074 -0.1278 50km",
+		"block 47.2513 -122.3149 5km",
+	)
+	middleware := NewGeofenceMiddleware(resolver, slog.Default(), next, fences)
+
+	request := func(path, remoteAddr string) (*httptest.ResponseRecorder, *RequestTags) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		req, tags := WithRequestTags(req)
+
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec, tags
+	}
+
+	t.Run("allows clients inside an allow fence", func(t *testing.T) {
+		rec, _ := request("/live/match", "81.2.69.142:1234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("refuses clients outside the allow fences", func(t *testing.T) {
+		rec, tags := request("/live/match", "89.160.20.115:1234")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, BlockedByGeofence, tags.Get(TagBlocked))
+
+		rec, _ = request("/replays/match", "89.160.20.115:1234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("refuses clients inside a block fence", func(t *testing.T) {
+		rec, tags := request("/replays/match", "216.160.83.57:1234")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, BlockedByGeofence, tags.Get(TagBlocked))
+	})
+
+	t.Run("refuses clients that can't be located where allow fences apply", func(t *testing.T) {
+		rec, _ := request("/live/match", "67.43.156.1:1234")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec, _ = request("/live/match", "1.1.1.1:1234")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec, _ = request("/replays/match", "67.43.156.1:1234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("allows local and internal clients", func(t *testing.T) {
+		rec, _ := request("/live/match", "127.0.0.1:1234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec, _ = request("/live/match", "10.0.0.1:1234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func TestGeofenceMiddleware_country_database(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	middleware := NewGeofenceMiddleware(resolver, slog.Default(), next, parseTestGeofences(t, "allow 51.5074 -0.1278 50km"))
+
+	// Country databases have no coordinates, so no client can be located
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "81.2.69.142:1234"
+	rec := httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+// Helpers
+
+func parseTestGeofences(t *testing.T, values ...string) Geofences {
+	t.Helper()
+
+	fences := Geofences{}
+	for _, value := range values {
+		fence, err := ParseGeofence(value)
+		require.NoError(t, err)
+		fences = append(fences, fence)
+	}
+	return fences
+}
+
+func areaValues(area GeoArea) map[string]float64 {
+	switch a := area.(type) {
+	case GeoCircle:
+		return map[string]float64{"latitude": a.Latitude, "longitude": a.Longitude, "radius": a.RadiusKm}
+	case GeoBox:
+		return map[string]float64{"south": a.South, "west": a.West, "north": a.North, "east": a.East}
+	}
+	return nil
+}
@@ -1,47 +1,90 @@
 package internal
 
 import (
+	"context"
 	"log/slog"
 	"net"
 	"net/http"
-	"path/filepath"
 	"strings"
-
-	"github.com/oschwald/geoip2-golang"
 )
 
 type GeoIPMiddleware struct {
-	reader         *geoip2.Reader
+	resolver       *GeoResolver
 	logger         *slog.Logger
 	next           http.Handler
 	allowCountries []string
 	blockCountries []string
+	allowSet       CountrySet
+	blockSet       CountrySet
+	blockPolicies  BlockPolicies
+	blockedPage    *PageTemplate
+	recentClients  *RecentClients
+	geoHeaders     GeoHeaders
+	bypass         *GeoBypass
+
+	challengeCountries []string
+	challengeSet       CountrySet
+	challenge          *Challenge
 }
 
-func NewGeoIPMiddleware(reader *geoip2.Reader, logger *slog.Logger, next http.Handler, allowCountries, blockCountries []string) *GeoIPMiddleware {
+func NewGeoIPMiddleware(resolver *GeoResolver, logger *slog.Logger, next http.Handler, allowCountries, blockCountries []string, blockPolicies BlockPolicies) *GeoIPMiddleware {
 	return &GeoIPMiddleware{
-		reader:         reader,
+		resolver:       resolver,
 		logger:         logger,
 		next:           next,
 		allowCountries: allowCountries,
 		blockCountries: blockCountries,
+		allowSet:       NewCountrySet(allowCountries),
+		blockSet:       NewCountrySet(blockCountries),
+		blockPolicies:  blockPolicies,
+		geoHeaders:     DefaultGeoHeaders,
 	}
 }
 
+// SetBlockedPage sets the page served to blocked requests, in place of a
+// plain "Access denied".
+func (m *GeoIPMiddleware) SetBlockedPage(page *PageTemplate) {
+	m.blockedPage = page
+}
+
+// SetRecentClients keeps a sample of the clients we locate, for simulating
+// database upgrades.
+func (m *GeoIPMiddleware) SetRecentClients(recentClients *RecentClients) {
+	m.recentClients = recentClients
+}
+
+// SetGeoHeaders sets the fields passed on to the upstream, in place of just
+// the country.
+func (m *GeoIPMiddleware) SetGeoHeaders(geoHeaders GeoHeaders) {
+	m.geoHeaders = geoHeaders
+}
+
+// SetBypass lets clients with a bypass token or cookie through country
+// blocking.
+func (m *GeoIPMiddleware) SetBypass(bypass *GeoBypass) {
+	m.bypass = bypass
+}
+
+// SetChallenge has requests from the given countries challenged, rather than
+// allowed or blocked.
+func (m *GeoIPMiddleware) SetChallenge(challenge *Challenge, countries []string) {
+	m.challenge = challenge
+	m.challengeCountries = countries
+	m.challengeSet = NewCountrySet(countries)
+}
+
 func (m *GeoIPMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Extract IP address from request
-	remoteAddr := r.Header.Get("X-Forwarded-For")
-	if remoteAddr == "" {
-		remoteAddr = r.RemoteAddr
-	}
+	// Whatever the client sent in our headers isn't to be trusted
+	m.geoHeaders.Strip(r.Header)
 
-	// Parse IP address (remove port if present)
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		host = remoteAddr // Assume no port was present
+	r, bypass := m.bypass.Check(w, r)
+
+	if m.challenge != nil && r.URL.Path == challengePath && r.Method == http.MethodPost {
+		m.challenge.Verify(w, r)
+		return
 	}
 
-	ip := net.ParseIP(host)
+	host, ip := clientIP(r)
 	if ip != nil {
 		// Always allow localhost and internal IP ranges
 		if isLocalOrInternalIP(ip) {
@@ -49,75 +92,113 @@ func (m *GeoIPMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Look up country information
-		country, err := m.reader.Country(ip)
+		// Look up what the databases know of the client
+		info, err := m.resolver.Lookup(ip)
 		if err == nil {
-			countryCode := country.Country.IsoCode
+			countryCode := info.Country
+			m.recentClients.Add(ip)
+
+			// Keep what we found for later stages, and tag the request with it
+			// before filtering, so that it's known to the block page and the
+			// request log either way
+			r = r.WithContext(withGeoInfo(r.Context(), info))
+			if countryCode != "" || info.ASN != 0 {
+				var tags *RequestTags
+				r, tags = WithRequestTags(r)
+				if countryCode != "" {
+					tags.Set(TagCountry, countryCode)
+				}
+				if info.ASN != 0 {
+					tags.Set(TagASN, info.ASNTag())
+				}
+			}
 
 			// Check country filtering rules
-			if len(m.allowCountries) > 0 {
-				// If allow list is configured, only allow requests from those countries
-				allowed := false
-				for _, allowedCountry := range m.allowCountries {
-					if strings.EqualFold(countryCode, allowedCountry) {
-						allowed = true
-						break
-					}
-				}
-				if !allowed {
-					m.logger.Info("Request blocked - country not in allow list",
-						"country", countryCode, "ip", host, "allowed_countries", m.allowCountries)
-					http.Error(w, "Access denied", http.StatusForbidden)
+			if m.challenged(countryCode) {
+				switch {
+				case r.Method == http.MethodOptions:
+					// Preflights can't carry the cookie, so could never pass
+				case m.challenge.Passed(r):
+					RequestTagsFromContext(r.Context()).Set(TagChallenge, ChallengePassed)
+				case m.bypassing(r, bypass, countryCode, host):
+				default:
+					m.logger.InfoContext(r.Context(), "Request challenged - country in challenge list",
+						"country", countryCode, "ip", host, "challenge_countries", m.challengeCountries, "method", r.Method)
+					m.challenge.Issue(w, r)
 					return
 				}
-			} else if len(m.blockCountries) > 0 {
-				// If block list is configured, block requests from those countries
-				for _, blockedCountry := range m.blockCountries {
-					if strings.EqualFold(countryCode, blockedCountry) {
-						m.logger.Info("Request blocked - country in block list",
-							"country", countryCode, "ip", host, "blocked_countries", m.blockCountries)
-						http.Error(w, "Access denied", http.StatusForbidden)
+			} else if len(m.allowCountries) > 0 {
+				// If allow list is configured, only allow requests from those countries
+				if !m.allowSet.Contains(countryCode) && !m.bypassing(r, bypass, countryCode, host) {
+					policy := m.blockPolicies.For(r.Method)
+					m.logger.InfoContext(r.Context(), "Request blocked - country not in allow list",
+						"country", countryCode, "ip", host, "allowed_countries", m.allowCountries, "method", r.Method, "policy", policy)
+					if policy != BlockPolicyAllow {
+						m.writeBlocked(w, r, policy, "allow_countries")
 						return
 					}
 				}
+			} else if m.blockSet.Contains(countryCode) && !m.bypassing(r, bypass, countryCode, host) {
+				// If block list is configured, block requests from those countries
+				policy := m.blockPolicies.For(r.Method)
+				m.logger.InfoContext(r.Context(), "Request blocked - country in block list",
+					"country", countryCode, "ip", host, "blocked_countries", m.blockCountries, "method", r.Method, "policy", policy)
+				if policy != BlockPolicyAllow {
+					m.writeBlocked(w, r, policy, "block_country:"+strings.ToUpper(countryCode))
+					return
+				}
 			}
 
-			// Add GeoIP information to request context via headers
-			// This allows downstream middleware to access the information
-			if countryCode != "" {
-				r.Header.Set("X-GeoIP-Country", countryCode)
-			}
+			// Pass what we know on to the upstream too
+			m.geoHeaders.Apply(r.Header, info)
 		}
 	}
 	m.next.ServeHTTP(w, r)
 }
 
-func (m *GeoIPMiddleware) Close() error {
-	if m.reader != nil {
-		return m.reader.Close()
+// challenged reports whether requests from the country are to be challenged.
+func (m *GeoIPMiddleware) challenged(countryCode string) bool {
+	return m.challenge != nil && m.challengeSet.Contains(countryCode)
+}
+
+// bypassing lets a request that would be blocked through when the client has
+// a bypass, recording that it did.
+func (m *GeoIPMiddleware) bypassing(r *http.Request, bypass, countryCode, host string) bool {
+	if bypass == "" {
+		return false
 	}
-	return nil
+
+	RequestTagsFromContext(r.Context()).Set(TagGeoBypass, bypass)
+	m.logger.InfoContext(r.Context(), "Request allowed - geo bypass",
+		"country", countryCode, "ip", host, "bypass", bypass)
+
+	return true
 }
 
-// Helper function to find GeoIP2 database file
-func FindGeoIP2Database() string {
-	// Common paths where GeoIP2 databases might be located
-	possiblePaths := []string{
-		"./GeoLite2-Country.mmdb",
-		"./data/GeoLite2-Country.mmdb",
-		"./storage/GeoLite2-Country.mmdb",
-		"./fixtures/GeoLite2-Country.mmdb", // <-- This one is for testing.
+// writeBlocked responds to a request from a blocked country, according to the
+// policy for its method.
+func (m *GeoIPMiddleware) writeBlocked(w http.ResponseWriter, r *http.Request, policy BlockPolicy, rule string) {
+	tags := RequestTagsFromContext(r.Context())
+	tags.Set(TagBlocked, BlockedByCountry)
+	tags.Set(TagBlockedRule, rule)
+
+	if policy == BlockPolicyEmpty {
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
 
-	for _, path := range possiblePaths {
-		if absPath, err := filepath.Abs(path); err == nil {
-			if absPath != "" {
-				return absPath
-			}
-		}
+	if m.blockedPage != nil {
+		m.blockedPage.Render(w, r, http.StatusForbidden)
+		return
 	}
 
-	return ""
+	http.Error(w, "Access denied", http.StatusForbidden)
+}
+
+// CountryFromContext returns the ISO country code resolved for the request by
+// the GeoIP middleware, or an empty string if none was resolved.
+func CountryFromContext(ctx context.Context) string {
+	return RequestTagsFromContext(ctx).Get(TagCountry)
 }
 
 // isLocalOrInternalIP checks if an IP address is localhost or from internal/private ranges
@@ -5,10 +5,12 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/oschwald/geoip2-golang"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGeoIPMiddleware_ServeHTTP(t *testing.T) {
@@ -25,9 +27,8 @@ func TestGeoIPMiddleware_ServeHTTP(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	dbPath := FindGeoIP2Database()
-	reader, _ := geoip2.Open(dbPath)
-	middleware := NewGeoIPMiddleware(reader, logger, nextHandler, []string{"US"}, []string{})
+	resolver := openTestGeoResolver(t, "GeoLite2-Country-Test.mmdb")
+	middleware := NewGeoIPMiddleware(resolver, logger, nextHandler, []string{"US"}, []string{}, BlockPolicies{})
 
 	t.Run("handles localhost request", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/test", nil)
@@ -53,18 +54,164 @@ func TestGeoIPMiddleware_ServeHTTP(t *testing.T) {
 		assert.Empty(t, rec.Header().Get("Test-Country"))
 	})
 
-	t.Run("handles request with X-Forwarded-For header", func(t *testing.T) {
+	t.Run("ignores X-Forwarded-For from an untrusted peer", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/test", nil)
 		req.Header.Set("X-Forwarded-For", "127.0.0.1")
-		req.RemoteAddr = "10.0.0.1:12345" // Internal IP
+		req.RemoteAddr = "81.2.69.142:12345" // GB
 
 		rec := httptest.NewRecorder()
 		middleware.ServeHTTP(rec, req)
 
-		assert.Equal(t, http.StatusOK, rec.Code)
-		// Should use X-Forwarded-For IP (localhost) which bypasses GeoIP
-		assert.Empty(t, rec.Header().Get("Test-Country"))
+		// The claim to be local is ignored, so the request is filtered by country
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func TestGeoIPMiddleware_block_policies(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	policies := BlockPolicies{Options: BlockPolicyAllow, Head: BlockPolicyEmpty}
+
+	statusFor := func(middleware http.Handler, method string) int {
+		req := httptest.NewRequest(method, "/test", nil)
+		req.RemoteAddr = "81.2.69.142:12345" // GB
+
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for name, middleware := range map[string]http.Handler{
+		"allow list": NewGeoIPMiddleware(resolver, slog.Default(), next, []string{"US"}, nil, policies),
+		"block list": NewGeoIPMiddleware(resolver, slog.Default(), next, nil, []string{"GB"}, policies),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusForbidden, statusFor(middleware, http.MethodGet))
+			assert.Equal(t, http.StatusOK, statusFor(middleware, http.MethodOptions))
+			assert.Equal(t, http.StatusNoContent, statusFor(middleware, http.MethodHead))
+		})
+	}
+}
+
+func TestGeoIPMiddleware_country_lists_ignore_case(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	statusFor := func(allowCountries, blockCountries []string) int {
+		middleware := NewGeoIPMiddleware(resolver, slog.Default(), http.NotFoundHandler(), allowCountries, blockCountries, BlockPolicies{})
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "81.2.69.142:12345" // GB
+		req, tags := WithRequestTags(req)
+
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+
+		if rec.Code == http.StatusForbidden {
+			assert.Equal(t, "block_country:GB", tags.Get(TagBlockedRule))
+		}
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, statusFor([]string{"us", "gb"}, nil))
+	assert.Equal(t, http.StatusForbidden, statusFor(nil, []string{"fr", "gb"}))
+	assert.Equal(t, http.StatusNotFound, statusFor(nil, []string{"fr"}))
+}
+
+func TestGeoIPMiddleware_blocked_page(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	path := filepath.Join(t.TempDir(), "403.html")
+	require.NoError(t, os.WriteFile(path, []byte(`Not available in {{country_name .Country}}`), 0o644))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
 	})
+	middleware := NewGeoIPMiddleware(resolver, slog.Default(), next, nil, []string{"GB"}, BlockPolicies{})
+	middleware.SetBlockedPage(NewPages("").LoadIfExists(path))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "81.2.69.142:12345" // GB
+	rec := httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "text/html", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "Not available in United Kingdom", rec.Body.String())
+}
+
+func TestGeoIPMiddleware_records_recent_clients(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	recentClients := NewRecentClients(10)
+	middleware := NewGeoIPMiddleware(resolver, slog.Default(), http.NotFoundHandler(), nil, nil, BlockPolicies{})
+	middleware.SetRecentClients(recentClients)
+
+	for _, remoteAddr := range []string{"81.2.69.142:1234", "127.0.0.1:1234"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, []net.IP{net.ParseIP("81.2.69.142")}, recentClients.Sample())
+}
+
+func TestGeoIPMiddleware_shares_what_it_finds(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-City-Test.mmdb", "GeoLite2-ASN-Test.mmdb")
+
+	var info GeoInfo
+	var found bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, found = GeoInfoFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	middleware := NewGeoIPMiddleware(resolver, slog.Default(), next, nil, nil, BlockPolicies{})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "81.2.69.142:1234"
+	req, tags := WithRequestTags(req)
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.True(t, found)
+	assert.Equal(t, "London", info.City)
+	assert.Equal(t, "GB", tags.Get(TagCountry))
+	assert.Equal(t, "AS20712", tags.Get(TagASN))
+}
+
+func BenchmarkGeoIPMiddleware(b *testing.B) {
+	resolver := openTestGeoResolver(b, "GeoLite2-Country.mmdb")
+	logger := slog.New(slog.DiscardHandler)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	countries := []string{}
+	for first := 'A'; first <= 'Z'; first++ {
+		for second := 'A'; second <= 'Z'; second++ {
+			if code := string([]rune{first, second}); code != "GB" {
+				countries = append(countries, code)
+			}
+		}
+	}
+
+	for name, middleware := range map[string]http.Handler{
+		"allowed": NewGeoIPMiddleware(resolver, logger, next, nil, countries, BlockPolicies{}),
+		"blocked": NewGeoIPMiddleware(resolver, logger, next, countries, nil, BlockPolicies{}),
+	} {
+		b.Run(name, func(b *testing.B) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "81.2.69.142:12345" // GB
+			rec := httptest.NewRecorder()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				rec.Body.Reset()
+				middleware.ServeHTTP(rec, req)
+			}
+		})
+	}
 }
 
 func TestIsLocalOrInternalIP(t *testing.T) {
@@ -95,12 +242,76 @@ func TestIsLocalOrInternalIP(t *testing.T) {
 	}
 }
 
-func TestFindGeoIP2Database(t *testing.T) {
-	// This test just ensures the function doesn't panic
-	// In a real environment, it would find actual database files
-	result := FindGeoIP2Database()
-	// Result could be empty string if no databases found, which is fine
-	assert.IsType(t, "", result)
+func TestFindGeoIP2Databases(t *testing.T) {
+	t.Chdir(t.TempDir())
+	assert.Empty(t, FindGeoIP2Databases(GeoProviderMaxMind))
+
+	country := usingTestGeoIP2Database(t, "data/GeoLite2-Country.mmdb")
+	city := usingTestGeoIP2Database(t, "storage/GeoLite2-City.mmdb")
+	usingTestGeoIP2Database(t, "storage/GeoLite2-Country.mmdb")
+
+	assert.Equal(t, []string{city, country}, FindGeoIP2Databases(GeoProviderMaxMind))
+}
+
+func TestOpenGeoIP2Databases(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	t.Run("found in the usual locations", func(t *testing.T) {
+		resolver, err := OpenGeoIP2Databases(GeoProviderMaxMind, nil)
+		require.NoError(t, err)
+		assert.Nil(t, resolver)
+
+		require.NoError(t, os.WriteFile("GeoLite2-City.mmdb", []byte("not a database"), 0o644))
+		t.Cleanup(func() { os.Remove("GeoLite2-City.mmdb") })
+
+		resolver, err = OpenGeoIP2Databases(GeoProviderMaxMind, nil)
+		require.NoError(t, err)
+		assert.Nil(t, resolver)
+
+		usingTestGeoIP2Database(t, "GeoLite2-Country.mmdb")
+
+		resolver, err = OpenGeoIP2Databases(GeoProviderMaxMind, nil)
+		require.NoError(t, err)
+		require.NotNil(t, resolver)
+		defer resolver.Close()
+
+		assert.Len(t, resolver.Databases(), 1)
+	})
+
+	t.Run("given by path", func(t *testing.T) {
+		path := usingTestGeoIP2Database(t, "custom/countries.mmdb")
+
+		resolver, err := OpenGeoIP2Databases(GeoProviderMaxMind, []string{path})
+		require.NoError(t, err)
+		require.NotNil(t, resolver)
+		defer resolver.Close()
+
+		info, err := resolver.Lookup(net.ParseIP("81.2.69.142"))
+		require.NoError(t, err)
+		assert.Equal(t, "GB", info.Country)
+
+		invalid := filepath.Join(t.TempDir(), "invalid.mmdb")
+		require.NoError(t, os.WriteFile(invalid, []byte("not a database"), 0o644))
+
+		resolver, err = OpenGeoIP2Databases(GeoProviderMaxMind, []string{path, invalid})
+		assert.ErrorContains(t, err, invalid)
+		assert.Nil(t, resolver)
+	})
+}
+
+func TestCheckGeoIP2DatabasePaths(t *testing.T) {
+	path := usingTestGeoIP2Database(t, filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb"))
+	assert.NoError(t, CheckGeoIP2DatabasePaths([]string{path}))
+	assert.NoError(t, CheckGeoIP2DatabasePaths(nil))
+
+	missing := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	err := CheckGeoIP2DatabasePaths([]string{path, missing})
+	assert.ErrorIs(t, err, ErrGeoIP2DatabaseNotFound)
+	assert.ErrorContains(t, err, missing)
+
+	err = CheckGeoIP2DatabasePaths([]string{t.TempDir()})
+	assert.ErrorIs(t, err, ErrGeoIP2DatabaseNotFound)
+	assert.ErrorContains(t, err, "directory")
 }
 
 // Helper function for testing
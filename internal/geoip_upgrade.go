@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+)
+
+const geoIP2UpgradeTopChanges = 10
+
+// RecentClients keeps the most recently seen distinct client IPs, as a sample
+// of real traffic to try a new GeoIP2 database against.
+//
+// A nil *RecentClients is valid, and keeps nothing.
+type RecentClients struct {
+	sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func NewRecentClients(size int) *RecentClients {
+	return &RecentClients{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+func (c *RecentClients) Add(ip net.IP) {
+	if c == nil || c.size <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	key := ip.String()
+	if element, ok := c.items[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(ip)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(net.IP).String())
+	}
+}
+
+// Sample returns the IPs, most recent first.
+func (c *RecentClients) Sample() []net.IP {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	ips := make([]net.IP, 0, c.order.Len())
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		ips = append(ips, element.Value.(net.IP))
+	}
+	return ips
+}
+
+// GeoIP2CountryReader looks up the country of an IP, as *GeoResolver does.
+type GeoIP2CountryReader interface {
+	Lookup(ip net.IP) (GeoInfo, error)
+}
+
+type GeoIP2CountryChange struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int    `json:"count"`
+}
+
+// GeoIP2UpgradeReport describes how a sample of clients would be located
+// differently by a new database. Clients that neither database can locate are
+// counted as unchanged.
+type GeoIP2UpgradeReport struct {
+	Sampled        int                   `json:"sampled"`
+	Changed        int                   `json:"changed"`
+	ChangedPercent float64               `json:"changed_percent"`
+	TopChanges     []GeoIP2CountryChange `json:"top_changes"`
+	Suspicious     bool                  `json:"suspicious"`
+}
+
+// SimulateGeoIP2Upgrade looks up each IP in both the current and the
+// candidate database, and reports on the countries that would change. The
+// upgrade is suspicious when more than suspiciousPercent of them would.
+func SimulateGeoIP2Upgrade(current, candidate GeoIP2CountryReader, ips []net.IP, suspiciousPercent float64) GeoIP2UpgradeReport {
+	report := GeoIP2UpgradeReport{Sampled: len(ips), TopChanges: []GeoIP2CountryChange{}}
+	changes := map[[2]string]int{}
+
+	for _, ip := range ips {
+		from := geoIP2CountryCode(current, ip)
+		to := geoIP2CountryCode(candidate, ip)
+
+		if from != to {
+			report.Changed++
+			changes[[2]string{from, to}]++
+		}
+	}
+
+	for change, count := range changes {
+		report.TopChanges = append(report.TopChanges, GeoIP2CountryChange{From: change[0], To: change[1], Count: count})
+	}
+	sort.Slice(report.TopChanges, func(i, j int) bool {
+		a, b := report.TopChanges[i], report.TopChanges[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.From+a.To < b.From+b.To
+	})
+	if len(report.TopChanges) > geoIP2UpgradeTopChanges {
+		report.TopChanges = report.TopChanges[:geoIP2UpgradeTopChanges]
+	}
+
+	if report.Sampled > 0 {
+		report.ChangedPercent = 100 * float64(report.Changed) / float64(report.Sampled)
+	}
+	report.Suspicious = report.ChangedPercent > suspiciousPercent
+
+	return report
+}
+
+// Log records the outcome of a simulation, as a warning when it's suspicious.
+func (r GeoIP2UpgradeReport) Log(path string) {
+	level := slog.LevelInfo
+	if r.Suspicious {
+		level = slog.LevelWarn
+	}
+
+	slog.Log(context.Background(), level, "GeoIP2 upgrade simulation", "path", path, "sampled", r.Sampled, "changed", r.Changed,
+		"changed_percent", r.ChangedPercent, "suspicious", r.Suspicious, "top_changes", r.TopChanges)
+}
+
+// Private
+
+func geoIP2CountryCode(reader GeoIP2CountryReader, ip net.IP) string {
+	info, err := reader.Lookup(ip)
+	if err != nil {
+		return ""
+	}
+	return info.Country
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCountryReader map[string]string
+
+func (r testCountryReader) Lookup(ip net.IP) (GeoInfo, error) {
+	code, ok := r[ip.String()]
+	if !ok {
+		return GeoInfo{}, errors.New("not found")
+	}
+
+	return GeoInfo{Country: code}, nil
+}
+
+func TestRecentClients(t *testing.T) {
+	clients := NewRecentClients(2)
+
+	clients.Add(net.ParseIP("192.0.2.1"))
+	clients.Add(net.ParseIP("192.0.2.2"))
+	clients.Add(net.ParseIP("192.0.2.1"))
+	clients.Add(net.ParseIP("192.0.2.3"))
+
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.3"), net.ParseIP("192.0.2.1")}, clients.Sample())
+}
+
+func TestRecentClients_nil(t *testing.T) {
+	var clients *RecentClients
+
+	clients.Add(net.ParseIP("192.0.2.1"))
+	assert.Empty(t, clients.Sample())
+}
+
+func TestSimulateGeoIP2Upgrade(t *testing.T) {
+	current := testCountryReader{"192.0.2.1": "GB", "192.0.2.2": "GB", "192.0.2.3": "US", "192.0.2.4": "FR"}
+	candidate := testCountryReader{"192.0.2.1": "GB", "192.0.2.2": "IE", "192.0.2.3": "CA", "192.0.2.5": "DE"}
+
+	ips := []net.IP{}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.6"} {
+		ips = append(ips, net.ParseIP(ip))
+	}
+
+	report := SimulateGeoIP2Upgrade(current, candidate, ips, 50)
+
+	assert.Equal(t, 5, report.Sampled)
+	assert.Equal(t, 3, report.Changed)
+	assert.InDelta(t, 60.0, report.ChangedPercent, 0.001)
+	assert.True(t, report.Suspicious)
+	assert.Equal(t, []GeoIP2CountryChange{
+		{From: "FR", To: "", Count: 1},
+		{From: "GB", To: "IE", Count: 1},
+		{From: "US", To: "CA", Count: 1},
+	}, report.TopChanges)
+
+	report = SimulateGeoIP2Upgrade(current, candidate, ips, 75)
+	assert.False(t, report.Suspicious)
+}
+
+func TestSimulateGeoIP2Upgrade_same_database(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	report := SimulateGeoIP2Upgrade(resolver, resolver, []net.IP{net.ParseIP("81.2.69.142")}, 5)
+
+	assert.Equal(t, 1, report.Sampled)
+	assert.Equal(t, 0, report.Changed)
+	assert.Empty(t, report.TopChanges)
+	assert.False(t, report.Suspicious)
+}
@@ -2,57 +2,328 @@ package internal
 
 import (
 	"log/slog"
+	"net"
 	"net/http"
-	"net/url"
-
-	"github.com/klauspost/compress/gzhttp"
-	"github.com/oschwald/geoip2-golang"
+	"time"
 )
 
 type HandlerOptions struct {
 	badGatewayPage           string
+	blockedPage              string
+	maintenancePage          string
+	pages                    *Pages
 	cache                    Cache
 	maxCacheableResponseBody int
+	cachePurgeToken          string
+	cachePurger              *CachePurger
+	cacheVaryHeaders         []string
+	cacheStats               *CacheStats
+	upstreamStats            *UpstreamStats
+	upstreamTransport        *http.Transport
+	cachePolicy              *CachePolicy
+	store                    Store
 	maxRequestBody           int
-	targetUrl                *url.URL
+	idempotencyWindow        time.Duration
+	writeIdleTimeout         time.Duration
+	upstreams                *UpstreamPool
+	targetHost               string
+	upstreamRetry            RetryPolicy
 	xSendfileEnabled         bool
-	gzipCompressionEnabled   bool
+	compressionEnabled       bool
+	compression              CompressionSettings
 	forwardHeaders           bool
+	trustedProxies           []*net.IPNet
+	forwardedHeader          bool
+	stickySessions           StickySessionPolicy
 	logRequests              bool
-	geoIP2Enabled            bool
+	requestIDHeader          string
+	accessLog                *AccessLog
+	auditLog                 *AuditLog
+	decisions                *Decisions
+	geoResolver              *GeoResolver
+	recentClients            *RecentClients
 	allowCountries           []string
 	blockCountries           []string
+	challengeCountries       []string
+	challenge                *Challenge
+	blockPolicies            BlockPolicies
+	geoBypassTokens          []string
+	geoBypassDuration        time.Duration
+	geoHeaders               GeoHeaders
+	countryStats             *CountryStats
+	countryRateLimits        map[string]RateLimit
+	defaultCountryRateLimit  RateLimit
+	countryRateLimiter       *RateLimiter
+	featureHeaders           []FeatureHeader
+	headerRules              HeaderRules
+	riskScores               RiskScores
+	riskThresholds           RiskThresholds
+	bodyRules                BodyRules
+	geofences                Geofences
+	blocklists               map[string][]*net.IPNet
+	blocklistStats           *BlocklistStats
+	bans                     *Bans
+	banStatuses              BanStatuses
+	events                   *EventDispatcher
+	bodyInspectionMaxSize    int
+	maintenanceWindows       MaintenanceWindows
+	maintenanceMode          *MaintenanceMode
+	maintenanceAllowCIDRs    []*net.IPNet
+	clientFingerprintSecret  string
+	tlsFingerprints          *TLSFingerprints
+	cookieScope              *CookieScope
+	clientRateLimit          RateLimit
+	clientRateLimiter        *RateLimiter
+	staticFilesRoot          string
+	staticFilesPaths         []string
+	rateLimitExemptCIDRs     []*net.IPNet
+	startupGate              *Startup
+	healthPath               string
+	readyPath                string
+	readinessChecks          []ReadinessCheck
 }
 
-func NewHandler(options HandlerOptions) http.Handler {
-	handler := NewProxyHandler(options.targetUrl, options.badGatewayPage, options.forwardHeaders)
-	handler = NewCacheHandler(options.cache, options.maxCacheableResponseBody, handler)
-	handler = NewSendfileHandler(options.xSendfileEnabled, handler)
-	handler = NewRequestStartMiddleware(handler)
+// Stages of the handler chain, from outermost to innermost.
+const (
+	StageHealth            = "health"
+	StageClientAddress     = "client_address"
+	StageRequestTags       = "request_tags"
+	StageRequestID         = "request_id"
+	StageEvents            = "events"
+	StageAudit             = "audit"
+	StageCountryStats      = "country_stats"
+	StageLogging           = "logging"
+	StageResponseHeaders   = "response_headers"
+	StageWriteDeadline     = "write_deadline"
+	StageStartupGate       = "startup_gate"
+	StageAutoBan           = "auto_ban"
+	StageClientRateLimit   = "client_rate_limit"
+	StageStaticFiles       = "static_files"
+	StageBlocklist         = "blocklist"
+	StageGeoIP             = "geoip"
+	StageGeofence          = "geofence"
+	StageMaintenance       = "maintenance"
+	StageClientFingerprint = "client_fingerprint"
+	StageRiskScore         = "risk_score"
+	StageBodyInspection    = "body_inspection"
+	StageCountryRateLimit  = "country_rate_limit"
+	StageFeatureHeaders    = "feature_headers"
+	StageRequestHeaders    = "request_headers"
+	StageStreaming         = "streaming"
+	StageMaxRequestBody    = "max_request_body"
+	StageCompression       = "compression"
+	StageIdempotency       = "idempotency"
+	StageRequestStart      = "request_start"
+	StageSendfile          = "sendfile"
+	StageCachePurge        = "cache_purge"
+	StageCache             = "cache"
+	StageFaults            = "faults"
+)
 
-	if options.gzipCompressionEnabled {
-		handler = gzhttp.GzipHandler(handler)
+// NewHandler builds the middleware chain and the proxy behind it. A handler is
+// built for each policy that comes into effect, so anything that has to last
+// longer, such as the upstream transport, the rate limiters' buckets, and the
+// cache purges, is made once and passed in with the options.
+func NewHandler(options HandlerOptions) http.Handler {
+	proxy := NewProxyHandler(options.upstreams, options.upstreamTransport, options.upstreamRetry, options.badGatewayPage, options.pages, options.forwardHeaders)
+	proxy.SetHost(options.targetHost)
+	proxy.SetForwarded(options.forwardedHeader)
+	proxy.SetTrustedProxies(NewTrustedProxies(options.trustedProxies))
+	if options.stickySessions.Enabled && len(options.upstreams.Upstreams()) > 1 {
+		proxy.SetStickySessions(NewStickySessions(options.stickySessions.Cookie, options.stickySessions.TTL, options.cookieScope))
 	}
+	proxy.SetUpstreamStats(options.upstreamStats)
 
-	if options.maxRequestBody > 0 {
-		handler = http.MaxBytesHandler(handler, int64(options.maxRequestBody))
-	}
+	return NewHandlerChain(options).Then(proxy)
+}
+
+// NewHandlerChain returns the chain of middleware that requests pass through
+// on their way to the proxy. Every stage is present even when its feature is
+// disabled, so that custom middleware can always be positioned relative to it.
+func NewHandlerChain(options HandlerOptions) *Chain {
+	chain := NewChain()
+	blockedPage := options.pages.LoadIfExists(options.blockedPage)
+
+	// Probes are answered before anything else, so that they're never filtered,
+	// and don't fill the request log.
+	chain.Use(StageHealth, enabledMiddleware(options.healthPath != "" || options.readyPath != "", func(next http.Handler) http.Handler {
+		return NewHealthMiddleware(options.healthPath, options.readyPath, options.readinessChecks, next)
+	}))
+
+	// Everything after this knows the client by the address it finds, which
+	// is only taken from X-Forwarded-For when a trusted proxy sent it.
+	trustedProxies := NewTrustedProxies(options.trustedProxies)
+	chain.Use(StageClientAddress, func(next http.Handler) http.Handler {
+		return NewClientAddressMiddleware(trustedProxies, next)
+	})
+
+	chain.Use(StageRequestTags, NewRequestTagsMiddleware)
+
+	chain.Use(StageRequestID, enabledMiddleware(options.requestIDHeader != "", func(next http.Handler) http.Handler {
+		return NewRequestIDMiddleware(options.requestIDHeader, options.forwardHeaders, next)
+	}))
+
+	chain.Use(StageEvents, enabledMiddleware(options.events != nil, func(next http.Handler) http.Handler {
+		return NewEventsMiddleware(options.events, next)
+	}))
 
-	if options.geoIP2Enabled {
-		// Find GeoIP2 database automatically
-		dbPath := FindGeoIP2Database()
-		reader, err := geoip2.Open(dbPath)
-		if err != nil {
-			slog.Default().Warn("Failed to open GeoIP2 database. NOT loading the GeoIP2 middleware for IP filtering.", "path", dbPath, "error", err)
-		} else {
-			slog.Default().Info("Loaded GeoIP2 country database & GeoIP2 middleware for IP filtering.")
-			handler = NewGeoIPMiddleware(reader, slog.Default(), handler, options.allowCountries, options.blockCountries)
+	chain.Use(StageAudit, enabledMiddleware(options.auditLog != nil || options.decisions != nil, func(next http.Handler) http.Handler {
+		return NewAuditMiddleware(options.auditLog, options.decisions, next)
+	}))
+
+	chain.Use(StageCountryStats, enabledMiddleware(options.countryStats != nil, func(next http.Handler) http.Handler {
+		return NewCountryStatsMiddleware(options.countryStats, next)
+	}))
+
+	chain.Use(StageLogging, enabledMiddleware(options.logRequests, func(next http.Handler) http.Handler {
+		middleware := NewLoggingMiddleware(slog.Default(), next)
+		middleware.SetAccessLog(options.accessLog)
+		return middleware
+	}))
+
+	responseHeaderRules := options.headerRules.For(HeaderRuleResponse)
+	chain.Use(StageResponseHeaders, enabledMiddleware(len(responseHeaderRules) > 0, func(next http.Handler) http.Handler {
+		return NewResponseHeadersMiddleware(responseHeaderRules, next)
+	}))
+
+	chain.Use(StageWriteDeadline, unlessUpgrade(enabledMiddleware(options.writeIdleTimeout > 0, func(next http.Handler) http.Handler {
+		return NewWriteDeadlineMiddleware(options.writeIdleTimeout, next)
+	})))
+
+	chain.Use(StageStartupGate, enabledMiddleware(options.startupGate != nil, func(next http.Handler) http.Handler {
+		return NewStartupGateMiddleware(options.startupGate, next)
+	}))
+
+	// Bans are checked ahead of the other filters, which count towards them.
+	chain.Use(StageAutoBan, enabledMiddleware(options.bans != nil, func(next http.Handler) http.Handler {
+		middleware := NewAutoBanMiddleware(slog.Default(), next, options.bans)
+		middleware.SetStatuses(options.banStatuses)
+		middleware.SetBlockedPage(blockedPage)
+		return middleware
+	}))
+
+	chain.Use(StageClientRateLimit, enabledMiddleware(options.clientRateLimit.Enabled(), func(next http.Handler) http.Handler {
+		return NewClientRateLimitMiddleware(slog.Default(), next, options.clientRateLimit, options.rateLimitExemptCIDRs, options.clientRateLimiter)
+	}))
+
+	// Static files are served ahead of the GeoIP stage, since they're the same
+	// for everyone, and there's nothing there to protect.
+	chain.Use(StageStaticFiles, enabledMiddleware(len(options.staticFilesPaths) > 0, func(next http.Handler) http.Handler {
+		return NewStaticFilesMiddleware(options.staticFilesRoot, options.staticFilesPaths, next)
+	}))
+
+	chain.Use(StageBlocklist, enabledMiddleware(len(options.blocklists) > 0, func(next http.Handler) http.Handler {
+		middleware := NewBlocklistMiddleware(slog.Default(), next, options.blocklists, options.blocklistStats)
+		middleware.SetBlockedPage(blockedPage)
+		return middleware
+	}))
+
+	chain.Use(StageGeoIP, enabledMiddleware(options.geoResolver != nil, func(next http.Handler) http.Handler {
+		middleware := NewGeoIPMiddleware(options.geoResolver, slog.Default(), next, options.allowCountries, options.blockCountries, options.blockPolicies)
+		middleware.SetBlockedPage(blockedPage)
+		middleware.SetRecentClients(options.recentClients)
+		middleware.SetGeoHeaders(options.geoHeaders)
+		if len(options.geoBypassTokens) > 0 {
+			middleware.SetBypass(NewGeoBypass(options.geoBypassTokens, options.geoBypassDuration, options.cookieScope))
 		}
-	}
+		if len(options.challengeCountries) > 0 {
+			middleware.SetChallenge(options.challenge, options.challengeCountries)
+		}
+		return middleware
+	}))
 
-	if options.logRequests {
-		handler = NewLoggingMiddleware(slog.Default(), handler)
-	}
+	chain.Use(StageGeofence, enabledMiddleware(options.geoResolver != nil && len(options.geofences) > 0, func(next http.Handler) http.Handler {
+		middleware := NewGeofenceMiddleware(options.geoResolver, slog.Default(), next, options.geofences)
+		middleware.SetBlockedPage(blockedPage)
+		return middleware
+	}))
+
+	chain.Use(StageMaintenance, enabledMiddleware(len(options.maintenanceWindows) > 0 || options.maintenanceMode != nil, func(next http.Handler) http.Handler {
+		middleware := NewMaintenanceMiddleware(slog.Default(), next, options.maintenanceWindows)
+		middleware.SetMode(options.maintenanceMode)
+		middleware.SetAllowedNetworks(options.maintenanceAllowCIDRs)
+		middleware.SetPage(options.pages.LoadIfExists(options.maintenancePage))
+		return middleware
+	}))
+
+	chain.Use(StageClientFingerprint, enabledMiddleware(options.clientFingerprintSecret != "", func(next http.Handler) http.Handler {
+		return NewClientFingerprintMiddleware(options.clientFingerprintSecret, options.tlsFingerprints, next)
+	}))
+
+	chain.Use(StageRiskScore, enabledMiddleware(len(options.riskScores) > 0, func(next http.Handler) http.Handler {
+		middleware := NewRiskScoreMiddleware(slog.Default(), next, options.riskScores, options.riskThresholds)
+		middleware.SetBlockedPage(blockedPage)
+		return middleware
+	}))
+
+	chain.Use(StageBodyInspection, enabledMiddleware(len(options.bodyRules) > 0, func(next http.Handler) http.Handler {
+		middleware := NewBodyInspectionMiddleware(slog.Default(), next, options.bodyRules, options.bodyInspectionMaxSize)
+		middleware.SetBlockedPage(blockedPage)
+		return middleware
+	}))
+
+	// The rate limiter sits inside the GeoIP middleware so that it can reuse
+	// the country it has already resolved for the request.
+	countryRateLimited := options.geoResolver != nil && (len(options.countryRateLimits) > 0 || options.defaultCountryRateLimit.Enabled())
+	chain.Use(StageCountryRateLimit, enabledMiddleware(countryRateLimited, func(next http.Handler) http.Handler {
+		return NewCountryRateLimitMiddleware(slog.Default(), next, options.countryRateLimits, options.defaultCountryRateLimit, options.countryRateLimiter)
+	}))
+
+	chain.Use(StageFeatureHeaders, enabledMiddleware(len(options.featureHeaders) > 0, func(next http.Handler) http.Handler {
+		return NewFeatureHeadersMiddleware(options.featureHeaders, next)
+	}))
+
+	requestHeaderRules := options.headerRules.For(HeaderRuleRequest)
+	chain.Use(StageRequestHeaders, enabledMiddleware(len(requestHeaderRules) > 0, func(next http.Handler) http.Handler {
+		return NewRequestHeadersMiddleware(requestHeaderRules, next)
+	}))
+
+	// WebSockets are proxied as-is, since once upgraded, the connection is no
+	// longer HTTP. Event streams go through the later stages like any other
+	// response, which pass them on as they're written, and don't store them.
+	chain.Use(StageStreaming, NewStreamingMiddleware)
+
+	chain.Use(StageMaxRequestBody, unlessUpgrade(enabledMiddleware(options.maxRequestBody > 0, func(next http.Handler) http.Handler {
+		return http.MaxBytesHandler(next, int64(options.maxRequestBody))
+	})))
 
-	return handler
+	chain.Use(StageCompression, unlessUpgrade(enabledMiddleware(options.compressionEnabled, func(next http.Handler) http.Handler {
+		return NewCompressionMiddleware(options.compression, next)
+	})))
+
+	chain.Use(StageIdempotency, unlessUpgrade(enabledMiddleware(options.idempotencyWindow > 0, func(next http.Handler) http.Handler {
+		return NewIdempotencyMiddleware(options.store, options.idempotencyWindow, options.maxCacheableResponseBody, next)
+	})))
+
+	chain.Use(StageRequestStart, NewRequestStartMiddleware)
+
+	chain.Use(StageSendfile, func(next http.Handler) http.Handler {
+		return NewSendfileHandler(options.xSendfileEnabled, next)
+	})
+
+	chain.Use(StageCachePurge, enabledMiddleware(options.cachePurger != nil, func(next http.Handler) http.Handler {
+		return NewCachePurgeMiddleware(options.cachePurger, options.cachePurgeToken, next)
+	}))
+
+	chain.Use(StageCache, unlessUpgrade(func(next http.Handler) http.Handler {
+		handler := NewCacheHandler(options.cache, options.maxCacheableResponseBody, next)
+		handler.SetPurger(options.cachePurger)
+		handler.SetVaryHeaders(options.cacheVaryHeaders)
+		handler.SetStats(options.cacheStats)
+		handler.SetPolicy(options.cachePolicy)
+		return handler
+	}))
+
+	chain.Use(StageFaults, wrapUpstreamFaults)
+
+	return chain
+}
+
+// Private
+
+func enabledMiddleware(enabled bool, middleware Middleware) Middleware {
+	if !enabled {
+		return nil
+	}
+	return middleware
 }
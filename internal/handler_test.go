@@ -1,8 +1,11 @@
 package internal
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -10,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandlerGzipCompression_when_proxying(t *testing.T) {
@@ -44,7 +48,7 @@ func TestNotHandlerGzipCompression_when_disabled(t *testing.T) {
 	defer upstream.Close()
 
 	options := handlerOptions(upstream.URL)
-	options.gzipCompressionEnabled = false
+	options.compressionEnabled = false
 	h := NewHandler(options)
 
 	w := httptest.NewRecorder()
@@ -199,7 +203,9 @@ func TestHandlerAppendInboundXFFHeaderWhenProxying(t *testing.T) {
 	}))
 	defer upstream.Close()
 
-	h := NewHandler(handlerOptions(upstream.URL))
+	options := handlerOptions(upstream.URL)
+	options.trustedProxies = []*net.IPNet{{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+	h := NewHandler(options)
 
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "http://example.org", nil)
@@ -232,7 +238,30 @@ func TestHandlerXForwardedHeadersForwardsExistingHeadersWhenForwardingEnabled(t
 	}))
 	defer upstream.Close()
 
-	h := NewHandler(handlerOptions(upstream.URL))
+	options := handlerOptions(upstream.URL)
+	options.trustedProxies = []*net.IPNet{{IP: net.IPv4(1, 2, 3, 4), Mask: net.CIDRMask(32, 32)}}
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.org", nil)
+	r.Header.Set("X-Forwarded-For", "4.3.2.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "other.example.com")
+	r.RemoteAddr = "1.2.3.4:1234"
+	h.ServeHTTP(w, r)
+}
+
+func TestHandlerXForwardedHeadersDropsExistingHeadersFromUntrustedPeers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1.2.3.4", r.Header.Get("X-Forwarded-For"))
+		assert.Equal(t, "example.org", r.Header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "http", r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.trustedProxies = []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+	h := NewHandler(options)
 
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "http://example.org", nil)
@@ -264,6 +293,26 @@ func TestHandlerXForwardedHeadersDropsExistingHeadersWhenForwardingNotEnabled(t
 	h.ServeHTTP(w, r)
 }
 
+func TestHandlerForwardedHeaderWhenEnabled(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "for=4.3.2.1;proto=https, for=1.2.3.4;host=example.org;proto=http", r.Header.Get("Forwarded"))
+		assert.Equal(t, "4.3.2.1, 1.2.3.4", r.Header.Get("X-Forwarded-For"))
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.forwardedHeader = true
+	options.trustedProxies = []*net.IPNet{{IP: net.IPv4(1, 2, 3, 4), Mask: net.CIDRMask(32, 32)}}
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.org", nil)
+	r.Header.Set("Forwarded", "for=4.3.2.1;proto=https, for=not-an-address")
+	r.Header.Set("X-Forwarded-For", "4.3.2.1")
+	r.RemoteAddr = "1.2.3.4:1234"
+	h.ServeHTTP(w, r)
+}
+
 func TestHandlerAddsXRequestStartHeader(t *testing.T) {
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("X-Request-Start")
@@ -301,16 +350,242 @@ func TestHandlerAllowsFlushingTheResponseBody(t *testing.T) {
 	assert.True(t, w.Flushed)
 }
 
+func TestHandlerClientRateLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.clientRateLimit = RateLimit{Rate: 1, Burst: 1}
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "8.8.8.8:1234"
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestHandlerChainAllowsCustomMiddleware(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "yes", r.Header.Get("X-Custom"))
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	chain := NewHandlerChain(options)
+
+	err := chain.InsertBefore(StageGeoIP, "custom", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-Custom", "yes")
+			next.ServeHTTP(w, r)
+		})
+	})
+	assert.NoError(t, err)
+
+	h := chain.Then(NewProxyHandler(options.upstreams, options.upstreamTransport, options.upstreamRetry, options.badGatewayPage, options.pages, options.forwardHeaders))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandlerWebSocketUpgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "websocket", r.Header.Get("Upgrade"))
+
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", "websocket")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo: " + line)
+		rw.Flush()
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.maxRequestBody = 10
+	server := httptest.NewServer(NewHandler(options))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET /cable HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nAccept-Encoding: gzip\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	fmt.Fprint(conn, "hello, this is longer than the body limit\n")
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello, this is longer than the body limit\n", line)
+}
+
+func TestHandlerEventStream(t *testing.T) {
+	release := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+
+	server := httptest.NewServer(NewHandler(handlerOptions(upstream.URL)))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "miss", resp.Header.Get("X-Cache"))
+
+	// The first event arrives while the upstream is still holding the stream open
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: first\n", line)
+
+	close(release)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "\ndata: second\n\n", string(body))
+
+	// Whatever its Cache-Control says, a stream isn't stored to be replayed
+	resp, err = http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "miss", resp.Header.Get("X-Cache"))
+}
+
+func TestHandlerEventStreamRequestsKeepTheBodyLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.maxRequestBody = 10
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(bytes.Repeat([]byte("a"), 1000)))
+	r.Header.Set("Accept", "text/event-stream")
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestHandlerLoadBalancesAcrossUpstreams(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+
+	first := newUpstream("first")
+	defer first.Close()
+	second := newUpstream("second")
+	defer second.Close()
+
+	targets, err := ParseTargetURLs([]string{first.URL, second.URL})
+	require.NoError(t, err)
+
+	options := handlerOptions(first.URL)
+	options.upstreams = NewUpstreamPool(targets, BalancingRoundRobin)
+	h := NewHandler(options)
+
+	bodies := []string{}
+	for range 4 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		bodies = append(bodies, w.Body.String())
+	}
+
+	assert.Equal(t, []string{"first", "second", "first", "second"}, bodies)
+}
+
+func TestHandlerCachePurge(t *testing.T) {
+	version := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Surrogate-Key", "products")
+		fmt.Fprintf(w, "version %d", version)
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.cachePurgeToken = "secret"
+	options.cachePurger = NewCachePurger()
+	h := NewHandler(options)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/products/1", nil))
+		return w
+	}
+
+	assert.Equal(t, "version 1", get().Body.String())
+
+	w := get()
+	assert.Equal(t, "version 1", w.Body.String())
+	assert.Equal(t, "hit", w.Header().Get("X-Cache"))
+	assert.Empty(t, w.Header().Get("Surrogate-Key"))
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("PURGE", "http://example.com/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Surrogate-Key", "products")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = get()
+	assert.Equal(t, "version 2", w.Body.String())
+	assert.Equal(t, "miss", w.Header().Get("X-Cache"))
+	assert.Equal(t, "version 2", get().Body.String())
+}
+
 // Helpers
 
 func handlerOptions(targetUrl string) HandlerOptions {
-	url, _ := url.Parse(targetUrl)
+	target, _ := url.Parse(targetUrl)
+	upstreams := NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin)
 
 	return HandlerOptions{
 		cache:                    NewMemoryCache(defaultCacheSize, defaultMaxCacheItemSizeBytes),
-		targetUrl:                url,
+		upstreams:                upstreams,
+		upstreamTransport:        NewUpstreamTransport(upstreams, TargetProtocolHTTP1, UpstreamTimeouts{}, nil, nil),
+		clientRateLimiter:        NewRateLimiter(nil),
+		countryRateLimiter:       NewRateLimiter(nil),
 		xSendfileEnabled:         true,
-		gzipCompressionEnabled:   true,
+		compressionEnabled:       true,
 		maxCacheableResponseBody: 1024,
 		badGatewayPage:           "",
 		forwardHeaders:           true,
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// HeaderRuleDirection says whether a rule rewrites the request sent to the
+// upstream, or the response sent to the client.
+type HeaderRuleDirection string
+
+const (
+	HeaderRuleRequest  HeaderRuleDirection = "request"
+	HeaderRuleResponse HeaderRuleDirection = "response"
+)
+
+type HeaderRuleAction string
+
+const (
+	HeaderRuleSet    HeaderRuleAction = "set"
+	HeaderRuleAdd    HeaderRuleAction = "add"
+	HeaderRuleRemove HeaderRuleAction = "remove"
+	HeaderRuleRename HeaderRuleAction = "rename"
+)
+
+var ErrInvalidHeaderRule = errors.New("header rule must be in the form [path:]request|response set|add Name value, [path:]request|response remove Name, or [path:]request|response rename Name New-Name")
+
+// HeaderRule rewrites a header of requests to matching paths, or of the
+// responses to them. Paths are matched as in cache rules, and a rule without
+// a path applies to every request.
+type HeaderRule struct {
+	Path      string
+	Direction HeaderRuleDirection
+	Action    HeaderRuleAction
+	Name      string
+	Value     string
+}
+
+// ParseHeaderRule parses `[path:]direction action Name [value]`, such as
+// `response remove X-Powered-By` or `/admin/**:response set X-Frame-Options
+// DENY`. The value of `set` and `add` is the rest of the rule, spaces and
+// all, and the value of `rename` is the header's new name.
+func ParseHeaderRule(value string) (HeaderRule, error) {
+	value = strings.TrimSpace(value)
+
+	var rule HeaderRule
+	if strings.HasPrefix(value, "/") {
+		rulePath, rest, ok := strings.Cut(value, ":")
+		if !ok {
+			return HeaderRule{}, ErrInvalidHeaderRule
+		}
+		if _, err := path.Match(rulePath, ""); err != nil {
+			return HeaderRule{}, ErrInvalidHeaderRule
+		}
+		rule.Path = rulePath
+		value = rest
+	}
+
+	direction, value := cutField(value)
+	action, value := cutField(value)
+	name, value := cutField(value)
+
+	rule.Direction = HeaderRuleDirection(strings.ToLower(direction))
+	if rule.Direction != HeaderRuleRequest && rule.Direction != HeaderRuleResponse {
+		return HeaderRule{}, ErrInvalidHeaderRule
+	}
+	if !validHeaderName(name) {
+		return HeaderRule{}, ErrInvalidHeaderRule
+	}
+	rule.Name = http.CanonicalHeaderKey(name)
+
+	rule.Action = HeaderRuleAction(strings.ToLower(action))
+	switch rule.Action {
+	case HeaderRuleSet, HeaderRuleAdd:
+		if value == "" {
+			return HeaderRule{}, ErrInvalidHeaderRule
+		}
+		rule.Value = value
+	case HeaderRuleRemove:
+		if value != "" {
+			return HeaderRule{}, ErrInvalidHeaderRule
+		}
+	case HeaderRuleRename:
+		if !validHeaderName(value) {
+			return HeaderRule{}, ErrInvalidHeaderRule
+		}
+		rule.Value = http.CanonicalHeaderKey(value)
+	default:
+		return HeaderRule{}, ErrInvalidHeaderRule
+	}
+
+	return rule, nil
+}
+
+// String formats the rule in the form that ParseHeaderRule reads.
+func (r HeaderRule) String() string {
+	value := string(r.Direction) + " " + string(r.Action) + " " + r.Name
+	if r.Value != "" {
+		value += " " + r.Value
+	}
+	if r.Path != "" {
+		value = r.Path + ":" + value
+	}
+	return value
+}
+
+// Apply rewrites the header, when the request path matches.
+func (r HeaderRule) Apply(requestPath string, header http.Header) {
+	if r.Path != "" && !matchPathPattern(r.Path, requestPath) {
+		return
+	}
+
+	switch r.Action {
+	case HeaderRuleSet:
+		header.Set(r.Name, r.Value)
+	case HeaderRuleAdd:
+		header.Add(r.Name, r.Value)
+	case HeaderRuleRemove:
+		header.Del(r.Name)
+	case HeaderRuleRename:
+		values := header.Values(r.Name)
+		if len(values) > 0 {
+			header.Del(r.Name)
+			header[r.Value] = values
+		}
+	}
+}
+
+// HeaderRules are applied in order, so a later rule sees the headers as the
+// earlier ones left them.
+type HeaderRules []HeaderRule
+
+// ReadHeaderRules reads rules one per line, for values that can't be given
+// in a comma-separated list, like a Content-Security-Policy. Blank lines and
+// those starting with `#` are skipped.
+func ReadHeaderRules(r io.Reader) (HeaderRules, error) {
+	rules := HeaderRules{}
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule, err := ParseHeaderRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// For returns the rules that apply in the given direction.
+func (h HeaderRules) For(direction HeaderRuleDirection) HeaderRules {
+	rules := HeaderRules{}
+	for _, rule := range h {
+		if rule.Direction == direction {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Apply applies each of the rules in turn.
+func (h HeaderRules) Apply(requestPath string, header http.Header) {
+	for _, rule := range h {
+		rule.Apply(requestPath, header)
+	}
+}
+
+// RequestHeadersMiddleware rewrites the headers of requests on their way to
+// the upstream.
+type RequestHeadersMiddleware struct {
+	rules HeaderRules
+	next  http.Handler
+}
+
+func NewRequestHeadersMiddleware(rules HeaderRules, next http.Handler) *RequestHeadersMiddleware {
+	return &RequestHeadersMiddleware{
+		rules: rules,
+		next:  next,
+	}
+}
+
+func (h *RequestHeadersMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.rules.Apply(r.URL.Path, r.Header)
+	h.next.ServeHTTP(w, r)
+}
+
+// ResponseHeadersMiddleware rewrites the headers of responses as they're sent
+// to the client, whether they came from the upstream or from us, such as
+// error and block pages.
+type ResponseHeadersMiddleware struct {
+	rules HeaderRules
+	next  http.Handler
+}
+
+func NewResponseHeadersMiddleware(rules HeaderRules, next http.Handler) *ResponseHeadersMiddleware {
+	return &ResponseHeadersMiddleware{
+		rules: rules,
+		next:  next,
+	}
+}
+
+func (h *ResponseHeadersMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writer := &headerRewriteWriter{ResponseWriter: w, rules: h.rules, path: r.URL.Path}
+	h.next.ServeHTTP(writer, r)
+
+	// Responses without a body are written by the server once we return
+	writer.rewrite()
+}
+
+type headerRewriteWriter struct {
+	http.ResponseWriter
+	rules     HeaderRules
+	path      string
+	rewritten bool
+}
+
+func (w *headerRewriteWriter) WriteHeader(statusCode int) {
+	// Informational responses, like 103 Early Hints, go out with whatever
+	// headers are set so far, and are left as they are
+	if statusCode >= http.StatusOK || statusCode == http.StatusSwitchingProtocols {
+		w.rewrite()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headerRewriteWriter) Write(b []byte) (int, error) {
+	w.rewrite()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerRewriteWriter) Flush() {
+	w.rewrite()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headerRewriteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Private
+
+func (w *headerRewriteWriter) rewrite() {
+	if !w.rewritten {
+		w.rewritten = true
+		w.rules.Apply(w.path, w.Header())
+	}
+}
+
+// cutField splits off the first whitespace-separated field of the value,
+// returning it along with the rest, trimmed.
+func cutField(value string) (string, string) {
+	value = strings.TrimSpace(value)
+	if i := strings.IndexAny(value, " \t"); i >= 0 {
+		return value[:i], strings.TrimSpace(value[i:])
+	}
+	return value, ""
+}
+
+func validHeaderName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t:,;\"()<>@[]{}/?=\\")
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeaderRule(t *testing.T) {
+	tests := map[string]HeaderRule{
+		"response remove x-powered-by": {Direction: HeaderRuleResponse, Action: HeaderRuleRemove, Name: "X-Powered-By"},
+		"Response SET X-Frame-Options DENY": {
+			Direction: HeaderRuleResponse, Action: HeaderRuleSet, Name: "X-Frame-Options", Value: "DENY",
+		},
+		"response set Content-Security-Policy default-src 'self';  img-src *": {
+			Direction: HeaderRuleResponse, Action: HeaderRuleSet, Name: "Content-Security-Policy", Value: "default-src 'self';  img-src *",
+		},
+		"request add Via thruster": {Direction: HeaderRuleRequest, Action: HeaderRuleAdd, Name: "Via", Value: "thruster"},
+		" /admin/**:request rename x-user x-admin-user ": {
+			Path: "/admin/**", Direction: HeaderRuleRequest, Action: HeaderRuleRename, Name: "X-User", Value: "X-Admin-User",
+		},
+	}
+
+	for value, expected := range tests {
+		rule, err := ParseHeaderRule(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, rule, value)
+	}
+
+	invalid := []string{
+		"",
+		"response",
+		"response remove",
+		"upstream remove Server",
+		"response drop Server",
+		"response set Server",
+		"response remove Server now",
+		"response rename Server",
+		"response rename Server Bad:Name",
+		"response set Bad:Name value",
+		"/admin request remove Cookie",
+		"/[:response remove Server",
+	}
+	for _, value := range invalid {
+		_, err := ParseHeaderRule(value)
+		assert.ErrorIs(t, err, ErrInvalidHeaderRule, value)
+	}
+}
+
+func TestHeaderRule_String(t *testing.T) {
+	for _, value := range []string{
+		"response remove Server",
+		"/assets/**:response set Cache-Control public, max-age=31536000",
+		"request rename X-User X-Admin-User",
+	} {
+		rule, err := ParseHeaderRule(value)
+		require.NoError(t, err)
+		assert.Equal(t, value, rule.String())
+	}
+}
+
+func TestHeaderRules_Apply(t *testing.T) {
+	rules, err := ReadHeaderRules(strings.NewReader(`
+# Applied in order
+response remove Server
+response rename X-Runtime X-App-Runtime
+response add Vary Accept-Language
+/admin/**:response set X-Frame-Options DENY
+`))
+	require.NoError(t, err)
+
+	header := http.Header{
+		"Server":    {"Puma"},
+		"X-Runtime": {"0.012"},
+		"Vary":      {"Accept"},
+	}
+	rules.Apply("/admin/users", header)
+
+	assert.Equal(t, http.Header{
+		"X-App-Runtime":   {"0.012"},
+		"Vary":            {"Accept", "Accept-Language"},
+		"X-Frame-Options": {"DENY"},
+	}, header)
+
+	header = http.Header{}
+	rules.Apply("/", header)
+	assert.Equal(t, http.Header{"Vary": {"Accept-Language"}}, header)
+}
+
+func TestReadHeaderRules_reports_the_line(t *testing.T) {
+	_, err := ReadHeaderRules(strings.NewReader("response remove Server\n\nresponse set Server\n"))
+	assert.ErrorIs(t, err, ErrInvalidHeaderRule)
+	assert.ErrorContains(t, err, "line 3")
+}
+
+func TestHeaderRules_For(t *testing.T) {
+	rules := HeaderRules{
+		{Direction: HeaderRuleResponse, Action: HeaderRuleRemove, Name: "Server"},
+		{Direction: HeaderRuleRequest, Action: HeaderRuleRemove, Name: "Cookie"},
+	}
+
+	assert.Equal(t, HeaderRules{rules[1]}, rules.For(HeaderRuleRequest))
+	assert.Equal(t, HeaderRules{rules[0]}, rules.For(HeaderRuleResponse))
+}
+
+func TestRequestHeadersMiddleware(t *testing.T) {
+	var received http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	})
+
+	rules := HeaderRules{
+		{Direction: HeaderRuleRequest, Action: HeaderRuleRemove, Name: "X-Debug"},
+		{Path: "/api/**", Direction: HeaderRuleRequest, Action: HeaderRuleRename, Name: "X-Client-Id", Value: "X-Api-Client"},
+	}
+	middleware := NewRequestHeadersMiddleware(rules, next)
+
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Client-Id", "abc")
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Empty(t, received.Values("X-Debug"))
+	assert.Empty(t, received.Values("X-Client-Id"))
+	assert.Equal(t, "abc", received.Get("X-Api-Client"))
+
+	req = httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Set("X-Client-Id", "abc")
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "abc", received.Get("X-Client-Id"))
+}
+
+func TestResponseHeadersMiddleware(t *testing.T) {
+	rules := HeaderRules{
+		{Direction: HeaderRuleResponse, Action: HeaderRuleRemove, Name: "Server"},
+		{Direction: HeaderRuleResponse, Action: HeaderRuleSet, Name: "X-Content-Type-Options", Value: "nosniff"},
+	}
+
+	t.Run("rewrites headers set before writing the status", func(t *testing.T) {
+		middleware := NewResponseHeadersMiddleware(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "Puma")
+			w.Header().Set("X-Content-Type-Options", "sniff")
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Values("Server"))
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	})
+
+	t.Run("rewrites headers when the body is written without a status", func(t *testing.T) {
+		middleware := NewResponseHeadersMiddleware(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "Puma")
+			w.Write([]byte("hello"))
+		}))
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, "hello", w.Body.String())
+		assert.Empty(t, w.Header().Values("Server"))
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	})
+
+	t.Run("rewrites headers of empty responses", func(t *testing.T) {
+		middleware := NewResponseHeadersMiddleware(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "Puma")
+		}))
+
+		server := httptest.NewServer(middleware)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Empty(t, resp.Header.Values("Server"))
+		assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+	})
+
+	t.Run("leaves informational responses alone", func(t *testing.T) {
+		var early http.Header
+		middleware := NewResponseHeadersMiddleware(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</app.css>; rel=preload")
+			w.WriteHeader(http.StatusEarlyHints)
+			early = w.Header().Clone()
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Empty(t, early.Values("X-Content-Type-Options"))
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	})
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const readinessCheckTimeout = 2 * time.Second
+
+var (
+	ErrStartupPending     = errors.New("startup phases still running")
+	ErrGeoIP2NotLoaded    = errors.New("GeoIP2 database not loaded")
+	ErrNoHealthyUpstreams = errors.New("no healthy upstreams")
+)
+
+// ReadinessCheck is something that has to be in order before we're ready to
+// serve traffic. Check returns why not, when it isn't.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthMiddleware answers health and readiness probes itself, ahead of the
+// filtering stages, so that probes from load balancers and orchestrators are
+// never blocked or rate limited, and don't reach the app.
+//
+// The health path answers 200 as long as the process is up. The readiness
+// path runs each check, and answers 200 when all of them pass or 503 when any
+// fail, with a line per check in the body saying how it went.
+type HealthMiddleware struct {
+	healthPath string
+	readyPath  string
+	checks     []ReadinessCheck
+	next       http.Handler
+}
+
+func NewHealthMiddleware(healthPath, readyPath string, checks []ReadinessCheck, next http.Handler) *HealthMiddleware {
+	return &HealthMiddleware{
+		healthPath: healthPath,
+		readyPath:  readyPath,
+		checks:     checks,
+		next:       next,
+	}
+}
+
+func (m *HealthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	switch {
+	case m.healthPath != "" && r.URL.Path == m.healthPath:
+		m.writeStatus(w, http.StatusOK, "ok\n")
+	case m.readyPath != "" && r.URL.Path == m.readyPath:
+		m.serveReadiness(w, r)
+	default:
+		m.next.ServeHTTP(w, r)
+	}
+}
+
+// Private
+
+func (m *HealthMiddleware) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	var body strings.Builder
+	ready := true
+
+	for _, check := range m.checks {
+		err := check.Check(ctx)
+		if err != nil {
+			ready = false
+			fmt.Fprintf(&body, "[-]%s failed: %s\n", check.Name, err)
+		} else {
+			fmt.Fprintf(&body, "[+]%s ok\n", check.Name)
+		}
+	}
+
+	if !ready {
+		body.WriteString("not ready\n")
+		m.writeStatus(w, http.StatusServiceUnavailable, body.String())
+		return
+	}
+
+	body.WriteString("ready\n")
+	m.writeStatus(w, http.StatusOK, body.String())
+}
+
+func (m *HealthMiddleware) writeStatus(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// startupReadiness is ready once every deferred startup phase has finished.
+func startupReadiness(startup *Startup) ReadinessCheck {
+	return ReadinessCheck{Name: "startup", Check: func(ctx context.Context) error {
+		if startup != nil && !startup.Warm() {
+			return ErrStartupPending
+		}
+		return nil
+	}}
+}
+
+// geoIP2Readiness is ready when the GeoIP2 database is loaded, if it's needed.
+func geoIP2Readiness(enabled, loaded bool) ReadinessCheck {
+	return ReadinessCheck{Name: "geoip", Check: func(ctx context.Context) error {
+		if enabled && !loaded {
+			return ErrGeoIP2NotLoaded
+		}
+		return nil
+	}}
+}
+
+// upstreamReadiness is ready when at least one upstream can take requests.
+// With health checks running we go by their verdict; otherwise we try
+// connecting to each upstream until one accepts.
+func upstreamReadiness(upstreams *UpstreamPool, healthChecked bool) ReadinessCheck {
+	return ReadinessCheck{Name: "upstream", Check: func(ctx context.Context) error {
+		if healthChecked {
+			if len(upstreams.healthyUpstreams()) == 0 {
+				return ErrNoHealthyUpstreams
+			}
+			return nil
+		}
+
+		var dialer net.Dialer
+		var err error
+		for _, upstream := range upstreams.Upstreams() {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, upstreamNetwork(upstream.URL.Scheme), upstreamAddress(upstream.URL))
+			if err == nil {
+				return conn.Close()
+			}
+		}
+		return err
+	}}
+}
+
+// cacheReadiness is ready when the cache backend is reachable. Only caches
+// that depend on another service can be unreachable.
+func cacheReadiness(cache Cache) ReadinessCheck {
+	return ReadinessCheck{Name: "cache", Check: func(ctx context.Context) error {
+		if pinger, ok := cache.(interface{ Ping(context.Context) error }); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	}}
+}
+
+func upstreamNetwork(scheme string) string {
+	if scheme == "unix" {
+		return "unix"
+	}
+	return "tcp"
+}
+
+func upstreamAddress(target *url.URL) string {
+	if target.Scheme == "unix" {
+		return target.Path
+	}
+
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(target.Hostname(), port)
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthMiddleware_health(t *testing.T) {
+	middleware := NewHealthMiddleware("/healthz", "/readyz", nil, healthTestApp())
+
+	w := serveHealthRequest(middleware, "GET", "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok\n", w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	w = serveHealthRequest(middleware, "POST", "/healthz")
+	assert.Equal(t, "app", w.Body.String())
+
+	w = serveHealthRequest(middleware, "GET", "/")
+	assert.Equal(t, "app", w.Body.String())
+}
+
+func TestHealthMiddleware_readiness(t *testing.T) {
+	var cacheErr error
+	checks := []ReadinessCheck{
+		{Name: "startup", Check: func(ctx context.Context) error { return nil }},
+		{Name: "cache", Check: func(ctx context.Context) error { return cacheErr }},
+	}
+	middleware := NewHealthMiddleware("/healthz", "/readyz", checks, healthTestApp())
+
+	w := serveHealthRequest(middleware, "GET", "/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[+]startup ok\n[+]cache ok\nready\n", w.Body.String())
+
+	cacheErr = errors.New("connection refused")
+
+	w = serveHealthRequest(middleware, "GET", "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "[+]startup ok\n[-]cache failed: connection refused\nnot ready\n", w.Body.String())
+
+	w = serveHealthRequest(middleware, "GET", "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHealthMiddleware_disabled_paths_reach_the_app(t *testing.T) {
+	middleware := NewHealthMiddleware("", "/ready", nil, healthTestApp())
+
+	assert.Equal(t, "app", serveHealthRequest(middleware, "GET", "/healthz").Body.String())
+	assert.Equal(t, "app", serveHealthRequest(middleware, "GET", "/readyz").Body.String())
+	assert.Equal(t, "ready\n", serveHealthRequest(middleware, "GET", "/ready").Body.String())
+}
+
+func TestHealthMiddleware_probes_are_not_filtered(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	upstream := httptest.NewServer(healthTestApp())
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.geoResolver = resolver
+	options.blockCountries = []string{"GB"}
+	options.healthPath = "/healthz"
+	options.readyPath = "/readyz"
+	handler := NewHandler(options)
+
+	request := func(path string) int {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "81.2.69.142:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("/"))
+	assert.Equal(t, http.StatusOK, request("/healthz"))
+	assert.Equal(t, http.StatusOK, request("/readyz"))
+}
+
+func TestReadinessChecks(t *testing.T) {
+	ctx := context.Background()
+
+	startup := NewStartup()
+	startup.pending.Add(1)
+	assert.ErrorIs(t, startupReadiness(startup).Check(ctx), ErrStartupPending)
+	startup.pending.Add(-1)
+	assert.NoError(t, startupReadiness(startup).Check(ctx))
+
+	assert.ErrorIs(t, geoIP2Readiness(true, false).Check(ctx), ErrGeoIP2NotLoaded)
+	assert.NoError(t, geoIP2Readiness(true, true).Check(ctx))
+	assert.NoError(t, geoIP2Readiness(false, false).Check(ctx))
+
+	assert.NoError(t, cacheReadiness(NewMemoryCache(defaultCacheSize, defaultMaxCacheItemSizeBytes)).Check(ctx))
+
+	redis := miniredis.RunT(t)
+	cache, err := NewRedisCache("redis://"+redis.Addr(), "", defaultMaxCacheItemSizeBytes)
+	require.NoError(t, err)
+	assert.NoError(t, cacheReadiness(cache).Check(ctx))
+	redis.Close()
+	assert.Error(t, cacheReadiness(cache).Check(ctx))
+}
+
+func TestReadinessChecks_upstream(t *testing.T) {
+	ctx := context.Background()
+
+	upstream := httptest.NewServer(healthTestApp())
+	target, _ := url.Parse(upstream.URL)
+	pool := NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin)
+
+	assert.NoError(t, upstreamReadiness(pool, false).Check(ctx))
+	upstream.Close()
+	assert.Error(t, upstreamReadiness(pool, false).Check(ctx))
+
+	assert.NoError(t, upstreamReadiness(pool, true).Check(ctx))
+	pool.Upstreams()[0].healthy.Store(false)
+	assert.ErrorIs(t, upstreamReadiness(pool, true).Check(ctx), ErrNoHealthyUpstreams)
+}
+
+// Helpers
+
+func healthTestApp() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("app"))
+	})
+}
+
+func serveHealthRequest(handler http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const healthcheckTimeout = 3 * time.Second
+
+var ErrNotServing = errors.New("not serving")
+
+// RunHealthcheckCommand implements `thrust healthcheck`, which checks on the
+// proxy running alongside it with the same environment, and exits with 0 if
+// it's healthy or 1 if not. It's meant for Docker's HEALTHCHECK.
+//
+// When the admin gRPC server is enabled its overall health is used, which
+// takes into account both startup and the health of the upstreams. Otherwise
+// the proxy is healthy when its HTTP port accepts connections.
+func RunHealthcheckCommand(args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	configFile := flags.String("config", "", "config file to read, instead of CONFIG_FILE")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 1
+	}
+
+	config, err := newConfig(*configFile, "", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthcheckTimeout)
+	defer cancel()
+
+	err = Healthcheck(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "UNHEALTHY: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Healthcheck checks on the proxy running with the given configuration.
+func Healthcheck(ctx context.Context, config *Config) error {
+	if config.AdminGRPCAddress != "" {
+		return healthcheckGRPC(ctx, localAddress(config.AdminGRPCAddress))
+	}
+
+	return healthcheckListener(ctx, localAddress(":"+strconv.Itoa(config.HttpPort)))
+}
+
+// Private
+
+func healthcheckGRPC(ctx context.Context, address string) error {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return fmt.Errorf("admin gRPC server at %s: %w", address, err)
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("admin gRPC server at %s reports %s: %w", address, resp.Status, ErrNotServing)
+	}
+
+	return nil
+}
+
+func healthcheckListener(ctx context.Context, address string) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// localAddress turns a listening address into one we can connect to, since
+// listeners on every interface are also listening on the loopback one.
+func localAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	ip := net.ParseIP(host)
+	if host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port)
+}
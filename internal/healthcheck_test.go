@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthcheck_listener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	assert.NoError(t, Healthcheck(context.Background(), &Config{HttpPort: port}))
+
+	listener.Close()
+	assert.Error(t, Healthcheck(context.Background(), &Config{HttpPort: port}))
+}
+
+func TestHealthcheck_admin_grpc(t *testing.T) {
+	target, _ := url.Parse("http://localhost:3000")
+	pool := NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin)
+
+	server := NewAdminGRPCServer("127.0.0.1:0", "", NewPolicySource(Policy{}), pool, nil)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	config := &Config{AdminGRPCAddress: server.Addr().String()}
+	assert.NoError(t, Healthcheck(context.Background(), config))
+
+	pool.Upstreams()[0].healthy.Store(false)
+	server.updateHealth()
+
+	assert.ErrorIs(t, Healthcheck(context.Background(), config), ErrNotServing)
+}
+
+func TestLocalAddress(t *testing.T) {
+	assert.Equal(t, "localhost:80", localAddress(":80"))
+	assert.Equal(t, "localhost:9090", localAddress("0.0.0.0:9090"))
+	assert.Equal(t, "localhost:9090", localAddress("[::]:9090"))
+	assert.Equal(t, "10.0.0.1:9090", localAddress("10.0.0.1:9090"))
+	assert.Equal(t, "localhost:8080", localAddress("localhost:8080"))
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HSTSMiddleware adds a Strict-Transport-Security header to responses served
+// over TLS. Browsers ignore the header on plain HTTP responses, so we don't
+// send it there.
+type HSTSMiddleware struct {
+	value string
+	next  http.Handler
+}
+
+func NewHSTSMiddleware(maxAge time.Duration, includeSubDomains, preload bool, next http.Handler) *HSTSMiddleware {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if includeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+
+	return &HSTSMiddleware{
+		value: value,
+		next:  next,
+	}
+}
+
+func (m *HSTSMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS != nil {
+		w.Header().Set("Strict-Transport-Security", m.value)
+	}
+
+	m.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHSTSMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("sets the header on TLS requests", func(t *testing.T) {
+		middleware := NewHSTSMiddleware(365*24*time.Hour, true, false, next)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		r.TLS = &tls.ConnectionState{}
+		middleware.ServeHTTP(w, r)
+
+		assert.Equal(t, "max-age=31536000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+	})
+
+	t.Run("supports preload", func(t *testing.T) {
+		middleware := NewHSTSMiddleware(time.Hour, false, true, next)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		r.TLS = &tls.ConnectionState{}
+		middleware.ServeHTTP(w, r)
+
+		assert.Equal(t, "max-age=3600; preload", w.Header().Get("Strict-Transport-Security"))
+	})
+
+	t.Run("does not set the header on plain HTTP requests", func(t *testing.T) {
+		middleware := NewHSTSMiddleware(time.Hour, true, false, next)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		middleware.ServeHTTP(w, r)
+
+		assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+	})
+}
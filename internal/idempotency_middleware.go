@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// IdempotencyMiddleware lets clients safely retry unsafe requests. When a
+// request carries an Idempotency-Key that we've seen within the window, the
+// stored response to the original is replayed rather than sending the request
+// to the upstream a second time.
+//
+// Keys are scoped to the request's method, path, and credentials, so one
+// client can't retrieve another's response by reusing its key.
+//
+// Only responses that the upstream completed are stored. Server errors, and
+// errors the proxy generated because the upstream couldn't be reached, are
+// passed on without being remembered, so that the client's retry is tried
+// again.
+//
+// Responses are kept in the state store rather than the HTTP cache, so that
+// they aren't evicted to make room for cached pages while they're needed.
+type IdempotencyMiddleware struct {
+	store       Store
+	window      time.Duration
+	maxBodySize int
+	next        http.Handler
+
+	inFlightLock sync.Mutex
+	inFlight     map[string]bool
+}
+
+func NewIdempotencyMiddleware(store Store, window time.Duration, maxBodySize int, next http.Handler) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{
+		store:       store,
+		window:      window,
+		maxBodySize: maxBodySize,
+		next:        next,
+		inFlight:    map[string]bool{},
+	}
+}
+
+func (h *IdempotencyMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if idempotencyKey == "" || isSafeMethod(r.Method) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	key := h.storeKey(r, idempotencyKey)
+
+	if stored, ok := h.store.Get(StoreBucketIdempotency, key); ok {
+		response, err := idempotentResponseFromBuffer(stored)
+		if err == nil {
+			slog.Debug("Replaying response for idempotent request", "path", r.URL.Path, "key", idempotencyKey)
+			response.write(w)
+			return
+		}
+		slog.Error("Failed to decode stored idempotent response", "path", r.URL.Path, "error", err)
+	}
+
+	if !h.begin(key) {
+		// The original is still being processed, so we have nothing to replay yet
+		http.Error(w, "A request with this Idempotency-Key is already in progress", http.StatusConflict)
+		return
+	}
+	defer h.end(key)
+
+	r, tags := WithRequestTags(r)
+	recorder := newIdempotentResponseRecorder(w, h.maxBodySize)
+	h.next.ServeHTTP(recorder, r)
+
+	if recorder.statusCode >= 500 || tags.Get(TagUpstreamError) != "" {
+		// Failures are usually passing, so a retry should get another chance
+		// rather than the same error for the rest of the window
+		slog.Debug("Not storing failed response for idempotent request", "path", r.URL.Path, "status", recorder.statusCode)
+		return
+	}
+	if recorder.stasher.Overflowed() {
+		slog.Debug("Response too large to store for idempotent request", "path", r.URL.Path, "key", idempotencyKey)
+		return
+	}
+	if isEventStreamResponse(recorder.response().Header) {
+		// What was sent is gone; a retry needs a stream of its own
+		return
+	}
+
+	encoded, err := recorder.response().toBuffer()
+	if err != nil {
+		slog.Error("Failed to encode idempotent response", "path", r.URL.Path, "error", err)
+		return
+	}
+
+	err = h.store.Set(StoreBucketIdempotency, key, encoded, h.window)
+	if err != nil {
+		slog.Error("Failed to store idempotent response", "path", r.URL.Path, "error", err)
+	}
+}
+
+// Private
+
+// storeKey is what keeps one client's responses from another's, so it's a
+// SHA-256 of the fields rather than anything quicker that a client could
+// steer into a collision. Each field is prefixed by its length, so that no
+// two different requests hash the same input.
+func (h *IdempotencyMiddleware) storeKey(r *http.Request, idempotencyKey string) string {
+	hash := sha256.New()
+	for _, field := range []string{idempotencyKey, r.Method, r.Host + r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get("Cookie")} {
+		hash.Write(binary.BigEndian.AppendUint64(nil, uint64(len(field))))
+		hash.Write([]byte(field))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (h *IdempotencyMiddleware) begin(key string) bool {
+	h.inFlightLock.Lock()
+	defer h.inFlightLock.Unlock()
+
+	if h.inFlight[key] {
+		return false
+	}
+
+	h.inFlight[key] = true
+	return true
+}
+
+func (h *IdempotencyMiddleware) end(key string) {
+	h.inFlightLock.Lock()
+	defer h.inFlightLock.Unlock()
+
+	delete(h.inFlight, key)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodTrace
+}
+
+type idempotentResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func idempotentResponseFromBuffer(b []byte) (idempotentResponse, error) {
+	var response idempotentResponse
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&response)
+	return response, err
+}
+
+func (r idempotentResponse) toBuffer() ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(r)
+	return b.Bytes(), err
+}
+
+func (r idempotentResponse) write(w http.ResponseWriter) {
+	for k, v := range r.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+
+	w.WriteHeader(r.StatusCode)
+	w.Write(r.Body)
+}
+
+type idempotentResponseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header
+	stasher    *stashingWriter
+}
+
+func newIdempotentResponseRecorder(w http.ResponseWriter, maxBodySize int) *idempotentResponseRecorder {
+	return &idempotentResponseRecorder{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		stasher:        NewStashingWriter(maxBodySize, w),
+	}
+}
+
+func (r *idempotentResponseRecorder) WriteHeader(statusCode int) {
+	if r.header == nil {
+		r.statusCode = statusCode
+		r.header = r.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *idempotentResponseRecorder) Write(b []byte) (int, error) {
+	if r.header == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.stasher.Write(b)
+}
+
+func (r *idempotentResponseRecorder) Flush() {
+	flusher, ok := r.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+func (r *idempotentResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *idempotentResponseRecorder) response() idempotentResponse {
+	header := r.header
+	if header == nil {
+		header = r.Header().Clone()
+	}
+
+	return idempotentResponse{
+		StatusCode: r.statusCode,
+		Header:     header,
+		Body:       r.stasher.Body(),
+	}
+}
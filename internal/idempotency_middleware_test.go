@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware_replays_retried_requests(t *testing.T) {
+	calls := 0
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	send := func(key, path, auth string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, strings.NewReader("order"))
+		r.Header.Set("Idempotency-Key", key)
+		r.Header.Set("Authorization", auth)
+		middleware.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send("abc", "/orders", "alice")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+	w = send("abc", "/orders", "alice")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/orders/1", w.Header().Get("Location"))
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "created", w.Body.String())
+	assert.Equal(t, 1, calls)
+
+	send("xyz", "/orders", "alice")
+	send("abc", "/payments", "alice")
+	send("abc", "/orders", "bob")
+	assert.Equal(t, 4, calls)
+}
+
+func TestIdempotencyMiddleware_keeps_responses_to_each_client_apart(t *testing.T) {
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("order for " + r.Header.Get("Authorization")))
+	}))
+
+	send := func(auth string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/orders", strings.NewReader("order"))
+		r.Header.Set("Idempotency-Key", "abc")
+		r.Header.Set("Authorization", auth)
+		middleware.ServeHTTP(w, r)
+		return w
+	}
+
+	send("Bearer alice")
+
+	w := send("Bearer bob")
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "order for Bearer bob", w.Body.String())
+
+	w = send("Bearer alice")
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "order for Bearer alice", w.Body.String())
+}
+
+func TestIdempotencyMiddleware_ignores_requests_without_key_or_safe_methods(t *testing.T) {
+	calls := 0
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	for range 2 {
+		middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		middleware.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	assert.Equal(t, 4, calls)
+}
+
+func TestIdempotencyMiddleware_rejects_concurrent_duplicates(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	request := func() *http.Request {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		return r
+	}
+
+	done := make(chan struct{})
+	go func() {
+		middleware.ServeHTTP(httptest.NewRecorder(), request())
+		close(done)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	middleware.ServeHTTP(w, request())
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	close(release)
+	<-done
+}
+
+func TestIdempotencyMiddleware_does_not_store_oversized_responses(t *testing.T) {
+	calls := 0
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 4, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("too large to store"))
+	}))
+
+	for range 2 {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		assert.Equal(t, "too large to store", w.Body.String())
+	}
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyMiddleware_does_not_store_event_streams(t *testing.T) {
+	calls := 0
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: progress\n\n"))
+	}))
+
+	for range 2 {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	}
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyMiddleware_does_not_store_server_errors(t *testing.T) {
+	calls := 0
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	codes := []int{}
+	for range 3 {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusServiceUnavailable, http.StatusCreated, http.StatusCreated}, codes)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyMiddleware_does_not_store_proxy_errors(t *testing.T) {
+	calls := 0
+	middleware := NewIdempotencyMiddleware(NewMemoryStore(), time.Minute, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		RequestTagsFromContext(r.Context()).Set(TagUpstreamError, string(UpstreamErrorRefused))
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	for range 2 {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	}
+
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package internal
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Signals that we pass on to the upstream process, in addition to SIGINT and
+// SIGTERM, which we relay as part of shutting down. Servers such as Puma use
+// them for restarts.
+var initRelayedSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2}
+
+// InitProcess does the work of an init process, for when we're PID 1, as we
+// are when we're a container's entrypoint. Processes orphaned by the upstream
+// are reparented to us, and we reap them when they exit so that they don't
+// linger as zombies. Signals meant for the upstream are passed on to it, since
+// as PID 1 we'd otherwise ignore them.
+//
+// The upstream process itself is left to be waited for as usual.
+type InitProcess struct {
+	upstream *UpstreamProcess
+	signals  chan os.Signal
+	done     chan struct{}
+}
+
+func NewInitProcess(upstream *UpstreamProcess) *InitProcess {
+	return &InitProcess{
+		upstream: upstream,
+		signals:  make(chan os.Signal, 16),
+		done:     make(chan struct{}),
+	}
+}
+
+// RunningAsInit reports whether we're PID 1.
+func RunningAsInit() bool {
+	return os.Getpid() == 1
+}
+
+func (p *InitProcess) Start() {
+	signal.Notify(p.signals, append([]os.Signal{syscall.SIGCHLD}, initRelayedSignals...)...)
+	go p.run()
+
+	slog.Info("Running as init process; reaping orphaned processes")
+}
+
+func (p *InitProcess) Stop() error {
+	signal.Stop(p.signals)
+	close(p.done)
+
+	// Catch anything that exited since the last signal
+	p.reap()
+	return nil
+}
+
+// Private
+
+func (p *InitProcess) run() {
+	for {
+		select {
+		case sig := <-p.signals:
+			if sig == syscall.SIGCHLD {
+				p.reap()
+			} else {
+				slog.Info("Relaying signal to upstream process", "signal", sig.String())
+				p.upstream.Signal(sig)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *InitProcess) reap() {
+	for _, pid := range zombieChildren(os.Getpid(), p.upstream.Pid()) {
+		var status syscall.WaitStatus
+		reaped, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+		if err == nil && reaped == pid {
+			slog.Debug("Reaped orphaned process", "pid", pid, "status", status.ExitStatus())
+		}
+	}
+}
+
+// zombieChildren lists the children of a process that have exited but not yet
+// been waited for, other than the one to leave alone.
+//
+// Reaping by pid, rather than waiting for any child, means we never take the
+// exit status of the upstream process from those waiting for it.
+func zombieChildren(parent, except int) []int {
+	paths, _ := filepath.Glob("/proc/[0-9]*/stat")
+
+	pids := []int{}
+	for _, path := range paths {
+		stat, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		pid, state, ppid, ok := parseProcStat(stat)
+		if ok && ppid == parent && state == 'Z' && pid != except {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+// parseProcStat reads the pid, state and parent pid from a /proc/<pid>/stat
+// file, which looks like `123 (name) Z 1 ...`. The name may itself contain
+// spaces and parentheses, so it's skipped by looking for the last `)`.
+func parseProcStat(stat []byte) (pid int, state byte, ppid int, ok bool) {
+	open := bytes.IndexByte(stat, '(')
+	end := bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return 0, 0, 0, false
+	}
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(stat[:open])))
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	fields := bytes.Fields(stat[end+1:])
+	if len(fields) < 2 || len(fields[0]) != 1 {
+		return 0, 0, 0, false
+	}
+
+	ppid, err = strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	return pid, fields[0][0], ppid, true
+}
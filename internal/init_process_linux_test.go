@@ -0,0 +1,57 @@
+//go:build linux
+
+package internal
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcStat(t *testing.T) {
+	pid, state, ppid, ok := parseProcStat([]byte("123 (my (odd) name) Z 1 123 123 0 -1"))
+	require.True(t, ok)
+	assert.Equal(t, 123, pid)
+	assert.Equal(t, byte('Z'), state)
+	assert.Equal(t, 1, ppid)
+
+	for _, stat := range []string{"", "123 name Z 1", "abc (name) Z 1", "123 (name) Z"} {
+		_, _, _, ok := parseProcStat([]byte(stat))
+		assert.False(t, ok, stat)
+	}
+}
+
+func TestZombieChildren(t *testing.T) {
+	keep := startExitingChild(t)
+	reap := startExitingChild(t)
+
+	require.Eventually(t, func() bool {
+		return len(zombieChildren(os.Getpid(), 0)) >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	zombies := zombieChildren(os.Getpid(), keep)
+	assert.Contains(t, zombies, reap)
+	assert.NotContains(t, zombies, keep)
+
+	upstream := NewUpstreamProcess("true")
+	upstream.cmd.Process, _ = os.FindProcess(keep)
+	NewInitProcess(upstream).reap()
+
+	assert.NotContains(t, zombieChildren(os.Getpid(), 0), reap)
+
+	var status syscall.WaitStatus
+	_, err := syscall.Wait4(keep, &status, 0, nil)
+	assert.NoError(t, err)
+}
+
+// startExitingChild starts a process that exits straight away, and isn't
+// waited for.
+func startExitingChild(t *testing.T) int {
+	pid, err := syscall.ForkExec("/bin/sh", []string{"sh", "-c", "exit 0"}, &syscall.ProcAttr{})
+	require.NoError(t, err)
+	return pid
+}
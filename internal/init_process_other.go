@@ -0,0 +1,21 @@
+//go:build !linux
+
+package internal
+
+// InitProcess only has work to do on Linux, where we may be a container's
+// PID 1.
+type InitProcess struct{}
+
+func NewInitProcess(upstream *UpstreamProcess) *InitProcess {
+	return &InitProcess{}
+}
+
+func RunningAsInit() bool {
+	return false
+}
+
+func (p *InitProcess) Start() {}
+
+func (p *InitProcess) Stop() error {
+	return nil
+}
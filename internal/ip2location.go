@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	ip2locationHeaderSize = 64
+	ip2locationTypes      = 26
+	ip2locationProduct    = 1
+)
+
+var ErrInvalidIP2LocationDatabase = errors.New("not an IP2Location BIN database")
+
+// Where each field is in the rows of each type of database, as a column
+// number counting the start of the range as 1, or 0 when the type doesn't
+// have it. These are the same for IPv4 and IPv6.
+var (
+	ip2locationCountryColumn    = [ip2locationTypes + 1]uint8{0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}
+	ip2locationRegionColumn     = [ip2locationTypes + 1]uint8{0, 0, 0, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}
+	ip2locationCityColumn       = [ip2locationTypes + 1]uint8{0, 0, 0, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4}
+	ip2locationLatitudeColumn   = [ip2locationTypes + 1]uint8{0, 0, 0, 0, 0, 5, 5, 0, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5}
+	ip2locationLongitudeColumn  = [ip2locationTypes + 1]uint8{0, 0, 0, 0, 0, 6, 6, 0, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6}
+	ip2locationPostalCodeColumn = [ip2locationTypes + 1]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 7, 7, 7, 7, 0, 7, 7, 7, 0, 7, 0, 7, 7, 7, 0, 7, 7, 7}
+	ip2locationTimeZoneColumn   = [ip2locationTypes + 1]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 8, 8, 7, 8, 8, 8, 7, 8, 0, 8, 8, 8, 0, 8, 8, 8}
+)
+
+// ip2locationDatabase reads IP2Location's BIN format, in which the ranges of
+// addresses are sorted rows of fixed size, found by binary search, with their
+// text fields kept apart and pointed to. The file is read as needed rather
+// than loaded, as the larger databases run to hundreds of megabytes.
+//
+// Offsets in the header and rows count from 1, but those of text fields from
+// 0.
+type ip2locationDatabase struct {
+	info    GeoDatabaseInfo
+	file    *os.File
+	dbType  uint8
+	columns uint32
+	ipv4    ip2locationSection
+	ipv6    ip2locationSection
+}
+
+type ip2locationSection struct {
+	count uint32
+	base  uint32
+	index uint32
+}
+
+func openIP2LocationDatabase(path string) (GeoDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ip2locationDatabase{file: file}
+	err = d.readHeader(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *ip2locationDatabase) Info() GeoDatabaseInfo {
+	return d.info
+}
+
+func (d *ip2locationDatabase) Close() error {
+	return d.file.Close()
+}
+
+func (d *ip2locationDatabase) Lookup(ip net.IP, info *GeoInfo) error {
+	row, found, err := d.find(ip)
+	if err != nil || !found {
+		return err
+	}
+
+	var result GeoInfo
+
+	for _, field := range []struct {
+		columns *[ip2locationTypes + 1]uint8
+		value   *string
+	}{
+		{&ip2locationCountryColumn, &result.Country},
+		{&ip2locationRegionColumn, &result.Region},
+		{&ip2locationCityColumn, &result.City},
+		{&ip2locationPostalCodeColumn, &result.PostalCode},
+		{&ip2locationTimeZoneColumn, &result.TimeZone},
+	} {
+		if column := field.columns[d.dbType]; column > 0 {
+			*field.value, err = d.stringAt(d.column(row, column))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if column := ip2locationLatitudeColumn[d.dbType]; column > 0 {
+		latitude := ip2locationFloat(d.column(row, column))
+		longitude := ip2locationFloat(d.column(row, ip2locationLongitudeColumn[d.dbType]))
+		if latitude != 0 || longitude != 0 {
+			result.Latitude = latitude
+			result.Longitude = longitude
+			result.Located = true
+		}
+	}
+
+	info.merge(result)
+	return nil
+}
+
+// Private
+
+func (d *ip2locationDatabase) readHeader(path string) error {
+	header := make([]byte, ip2locationHeaderSize)
+	_, err := d.file.ReadAt(header, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidIP2LocationDatabase, err)
+	}
+
+	d.dbType = header[0]
+	d.columns = uint32(header[1])
+	year, month, day := int(header[2]), time.Month(header[3]), int(header[4])
+	d.ipv4 = ip2locationSection{
+		count: binary.LittleEndian.Uint32(header[5:]),
+		base:  binary.LittleEndian.Uint32(header[9:]),
+		index: binary.LittleEndian.Uint32(header[21:]),
+	}
+	d.ipv6 = ip2locationSection{
+		count: binary.LittleEndian.Uint32(header[13:]),
+		base:  binary.LittleEndian.Uint32(header[17:]),
+		index: binary.LittleEndian.Uint32(header[25:]),
+	}
+	product := header[29]
+
+	// Databases from before 2021 have no product code. Others, such as
+	// IP2Proxy, share the format but not the layout.
+	if d.dbType == 0 || d.dbType > ip2locationTypes || d.columns < 2 || (product != ip2locationProduct && (product != 0 || year > 20)) {
+		return ErrInvalidIP2LocationDatabase
+	}
+
+	// The rows have to be wide enough to hold every field we read from them
+	if d.columns < uint32(ip2locationLastColumn(d.dbType)) {
+		return ErrInvalidIP2LocationDatabase
+	}
+
+	kind := GeoDatabaseCountry
+	if ip2locationLatitudeColumn[d.dbType] > 0 {
+		kind = GeoDatabaseCity
+	}
+
+	d.info = GeoDatabaseInfo{
+		Path:         path,
+		Provider:     GeoProviderIP2Location,
+		Kind:         kind,
+		DatabaseType: "IP2Location DB" + strconv.Itoa(int(d.dbType)),
+		BuildDate:    time.Date(2000+year, month, day, 0, 0, 0, 0, time.UTC),
+	}
+	return nil
+}
+
+// find returns the fields of the row whose range holds the address, after
+// the start of the range.
+func (d *ip2locationDatabase) find(ip net.IP) ([]byte, bool, error) {
+	var section ip2locationSection
+	var ipSize uint32
+	var hi, lo uint64
+	var indexKey uint32
+
+	if v4 := ip.To4(); v4 != nil {
+		section, ipSize = d.ipv4, 4
+		lo = uint64(binary.BigEndian.Uint32(v4))
+		if lo == math.MaxUint32 {
+			lo--
+		}
+		indexKey = uint32(lo >> 16)
+	} else if v6 := ip.To16(); v6 != nil {
+		section, ipSize = d.ipv6, 16
+		hi = binary.BigEndian.Uint64(v6[:8])
+		lo = binary.BigEndian.Uint64(v6[8:])
+		if hi == math.MaxUint64 && lo == math.MaxUint64 {
+			lo--
+		}
+		indexKey = uint32(hi >> 48)
+	} else {
+		return nil, false, ErrInvalidIP
+	}
+
+	if section.count == 0 {
+		return nil, false, nil
+	}
+
+	rowSize := ipSize + (d.columns-1)*4
+	low, high := uint32(0), section.count
+	if section.index > 0 {
+		var err error
+		low, err = d.uint32At(section.index + indexKey*8)
+		if err != nil {
+			return nil, false, err
+		}
+		high, err = d.uint32At(section.index + indexKey*8 + 4)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	for low <= high {
+		mid := low + (high-low)/2
+		offset := section.base + mid*rowSize
+
+		fromHi, fromLo, err := d.ipAt(offset, ipSize)
+		if err != nil {
+			return nil, false, err
+		}
+		toHi, toLo, err := d.ipAt(offset+rowSize, ipSize)
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch {
+		case ip2locationLess(hi, lo, fromHi, fromLo):
+			if mid == 0 {
+				return nil, false, nil
+			}
+			high = mid - 1
+		case !ip2locationLess(hi, lo, toHi, toLo):
+			low = mid + 1
+		default:
+			row := make([]byte, rowSize-ipSize)
+			_, err := d.file.ReadAt(row, int64(offset+ipSize)-1)
+			return row, err == nil, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+// ip2locationLastColumn is the highest column that we read from the rows of
+// the type of database.
+func ip2locationLastColumn(dbType uint8) uint8 {
+	return max(
+		ip2locationCountryColumn[dbType],
+		ip2locationRegionColumn[dbType],
+		ip2locationCityColumn[dbType],
+		ip2locationLatitudeColumn[dbType],
+		ip2locationLongitudeColumn[dbType],
+		ip2locationPostalCodeColumn[dbType],
+		ip2locationTimeZoneColumn[dbType],
+	)
+}
+
+func (d *ip2locationDatabase) column(row []byte, column uint8) uint32 {
+	return binary.LittleEndian.Uint32(row[(int(column)-2)*4:])
+}
+
+func (d *ip2locationDatabase) uint32At(offset uint32) (uint32, error) {
+	data := make([]byte, 4)
+	_, err := d.file.ReadAt(data, int64(offset)-1)
+	return binary.LittleEndian.Uint32(data), err
+}
+
+// ipAt reads the start of a range, which is little endian.
+func (d *ip2locationDatabase) ipAt(offset, size uint32) (uint64, uint64, error) {
+	data := make([]byte, size)
+	_, err := d.file.ReadAt(data, int64(offset)-1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if size == 4 {
+		return 0, uint64(binary.LittleEndian.Uint32(data)), nil
+	}
+	return binary.LittleEndian.Uint64(data[8:]), binary.LittleEndian.Uint64(data[:8]), nil
+}
+
+// stringAt reads a text field, which starts with its length. IP2Location
+// writes "-" for what it doesn't know.
+func (d *ip2locationDatabase) stringAt(offset uint32) (string, error) {
+	length := make([]byte, 1)
+	_, err := d.file.ReadAt(length, int64(offset))
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, length[0])
+	_, err = d.file.ReadAt(data, int64(offset)+1)
+	if err != nil {
+		return "", err
+	}
+
+	if string(data) == "-" {
+		return "", nil
+	}
+	return string(data), nil
+}
+
+func ip2locationLess(hi1, lo1, hi2, lo2 uint64) bool {
+	return hi1 < hi2 || (hi1 == hi2 && lo1 < lo2)
+}
+
+// ip2locationFloat reads a coordinate, which is stored with single precision,
+// as the shortest decimal that reads back the same, so that 51.50853 doesn't
+// become 51.508529663085938.
+func ip2locationFloat(bits uint32) float64 {
+	value, _ := strconv.ParseFloat(strconv.FormatFloat(float64(math.Float32frombits(bits)), 'f', -1, 32), 64)
+	return value
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIP2LocationDatabase_Lookup(t *testing.T) {
+	resolver := openTestGeoResolverFor(t, GeoProviderIP2Location, "IP2LOCATION-LITE-DB5-TEST.BIN")
+
+	info, err := resolver.Lookup(net.ParseIP("81.2.69.142"))
+	require.NoError(t, err)
+	assert.Equal(t, "GB", info.Country)
+	assert.Equal(t, "England", info.Region)
+	assert.Equal(t, "London", info.City)
+	assert.Empty(t, info.PostalCode)
+	assert.True(t, info.Located)
+	assert.Equal(t, 51.50853, info.Latitude)
+	assert.Equal(t, -0.12574, info.Longitude)
+
+	info, err = resolver.Lookup(net.ParseIP("216.160.83.255"))
+	require.NoError(t, err)
+	assert.Equal(t, "US", info.Country)
+	assert.Equal(t, "Milton", info.City)
+
+	info, err = resolver.Lookup(net.ParseIP("::ffff:89.160.20.115"))
+	require.NoError(t, err)
+	assert.Equal(t, "SE", info.Country)
+}
+
+func TestIP2LocationDatabase_Lookup_unknown(t *testing.T) {
+	resolver := openTestGeoResolverFor(t, GeoProviderIP2Location, "IP2LOCATION-LITE-DB5-TEST.BIN")
+
+	for _, ip := range []string{"0.0.0.0", "81.2.70.0", "216.160.84.1", "255.255.255.255", "2001:218::1"} {
+		info, err := resolver.Lookup(net.ParseIP(ip))
+		require.NoError(t, err, ip)
+		assert.Equal(t, GeoInfo{}, info, ip)
+	}
+}
+
+func TestIP2LocationDatabase_Info(t *testing.T) {
+	resolver := openTestGeoResolverFor(t, GeoProviderIP2Location, "IP2LOCATION-LITE-DB5-TEST.BIN")
+
+	database := resolver.Databases()[0]
+	assert.Equal(t, GeoProviderIP2Location, database.Provider)
+	assert.Equal(t, GeoDatabaseCity, database.Kind)
+	assert.Equal(t, "IP2Location DB5", database.DatabaseType)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), database.BuildDate)
+	assert.True(t, resolver.CanLocate())
+}
+
+func TestIP2LocationDatabase_invalid(t *testing.T) {
+	data, err := os.ReadFile(fixturePath("IP2LOCATION-LITE-DB5-TEST.BIN"))
+	require.NoError(t, err)
+
+	// IP2Proxy databases share the format, with a product code of their own
+	proxy := append([]byte{}, data...)
+	proxy[29] = 2
+
+	// A DB5 has latitude and longitude in columns 5 and 6
+	narrow := append([]byte{}, data...)
+	narrow[1] = 2
+
+	for name, content := range map[string][]byte{
+		"empty":  {},
+		"short":  data[:10],
+		"proxy":  proxy,
+		"narrow": narrow,
+	} {
+		path := filepath.Join(t.TempDir(), name+".BIN")
+		require.NoError(t, os.WriteFile(path, content, 0o644))
+
+		_, err := openIP2LocationDatabase(path)
+		assert.ErrorIs(t, err, ErrInvalidIP2LocationDatabase, name)
+	}
+}
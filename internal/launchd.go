@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var launchdPlistTemplate = template.Must(template.New("plist").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{ xml .Label }}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Arguments }}
+		<string>{{ xml . }}</string>
+{{- end }}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{ xml .Dir }}</string>
+{{- if .Environment }}
+	<key>EnvironmentVariables</key>
+	<dict>
+{{- range .Environment }}
+		<key>{{ xml .Name }}</key>
+		<string>{{ xml .Value }}</string>
+{{- end }}
+	</dict>
+{{- end }}
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+</dict>
+</plist>
+`))
+
+type launchdEnvironmentVariable struct {
+	Name  string
+	Value string
+}
+
+// WriteLaunchdPlist writes a launchd job definition that runs the proxy with
+// the given upstream command. Any THRUSTER_ variables in the current
+// environment are carried over into the job.
+func WriteLaunchdPlist(w io.Writer, options SystemServiceOptions) error {
+	return launchdPlistTemplate.Execute(w, struct {
+		Label       string
+		Dir         string
+		Arguments   []string
+		Environment []launchdEnvironmentVariable
+	}{
+		Label:       options.Name,
+		Dir:         options.Dir,
+		Arguments:   append([]string{options.Executable}, options.Command...),
+		Environment: launchdEnvironment(os.Environ()),
+	})
+}
+
+// Private
+
+func launchdEnvironment(environ []string) []launchdEnvironmentVariable {
+	result := []launchdEnvironmentVariable{}
+
+	for _, item := range environ {
+		name, value, ok := strings.Cut(item, "=")
+		if ok && strings.HasPrefix(name, ENV_PREFIX) {
+			result = append(result, launchdEnvironmentVariable{name, value})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func xmlEscape(value string) (string, error) {
+	var b strings.Builder
+	err := xml.EscapeText(&b, []byte(value))
+	return b.String(), err
+}
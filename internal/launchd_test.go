@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLaunchdPlist(t *testing.T) {
+	usingEnvVar(t, "THRUSTER_TLS_DOMAIN", "example.com")
+	usingEnvVar(t, "UNRELATED_VARIABLE", "ignored")
+
+	var b bytes.Buffer
+	err := WriteLaunchdPlist(&b, SystemServiceOptions{
+		Name:       "com.example.thruster",
+		Dir:        "/srv/app",
+		Executable: "/usr/local/bin/thrust",
+		Command:    []string{"bin/rails", "server", "-b", "a&b"},
+	})
+	require.NoError(t, err)
+
+	plist := b.String()
+	assert.Contains(t, plist, "<string>com.example.thruster</string>")
+	assert.Contains(t, plist, "<string>/usr/local/bin/thrust</string>\n\t\t<string>bin/rails</string>")
+	assert.Contains(t, plist, "<string>a&amp;b</string>")
+	assert.Contains(t, plist, "<key>WorkingDirectory</key>\n\t<string>/srv/app</string>")
+	assert.Contains(t, plist, "<key>THRUSTER_TLS_DOMAIN</key>\n\t\t<string>example.com</string>")
+	assert.NotContains(t, plist, "UNRELATED_VARIABLE")
+
+	var parsed struct{}
+	assert.NoError(t, xml.Unmarshal(b.Bytes(), &parsed))
+}
+
+func TestParseSystemServiceOptions(t *testing.T) {
+	options, err := parseSystemServiceOptions("install", []string{"-name", "myapp", "-dir", "/srv/app", "bin/rails", "server"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "myapp", options.Name)
+	assert.Equal(t, "/srv/app", options.Dir)
+	assert.Equal(t, []string{"bin/rails", "server"}, options.Command)
+
+	_, err = parseSystemServiceOptions("install", []string{"-name", "myapp"})
+	assert.Error(t, err)
+
+	options, err = parseSystemServiceOptions("uninstall", []string{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultSystemServiceName, options.Name)
+
+	options, err = parseSystemServiceOptions("install", []string{"-config", "/etc/thruster.yml", "bin/rails", "server"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--config", "/etc/thruster.yml", "bin/rails", "server"}, options.Command)
+
+	_, err = parseSystemServiceOptions("install", []string{"-config", "/etc/thruster.yml"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+)
+
+type lifecycleResource struct {
+	name  string
+	close func() error
+}
+
+type upstreamExit struct {
+	code int
+	err  error
+}
+
+// Lifecycle runs the service from the point it has started until it exits.
+//
+// When asked to stop, whether by SIGINT, SIGTERM or a call to Stop, it shuts
+// down in an order that lets requests finish: the listeners are closed first,
+// and requests already underway have until the drain timeout to complete.
+// Only then is the upstream process told to exit. Finally, the resources the
+// requests were using are closed.
+//
+// SIGHUP, or a call to Reload, runs the registered reload function instead,
+// and carries on running.
+type Lifecycle struct {
+	drainTimeout time.Duration
+	signals      chan os.Signal
+	reloads      chan os.Signal
+	reload       func()
+	resources    []lifecycleResource
+	abandoned    bool
+}
+
+func NewLifecycle(drainTimeout time.Duration) *Lifecycle {
+	return &Lifecycle{
+		drainTimeout: drainTimeout,
+		signals:      make(chan os.Signal, 1),
+		reloads:      make(chan os.Signal, 1),
+	}
+}
+
+// Notify starts catching shutdown signals, and SIGHUP when there's a reload
+// function. Signals caught before Run is called are held until it is.
+func (l *Lifecycle) Notify() {
+	signal.Notify(l.signals, syscall.SIGINT, syscall.SIGTERM)
+	if l.reload != nil {
+		signal.Notify(l.reloads, syscall.SIGHUP)
+	}
+}
+
+// Stop begins a shutdown, as though we had received SIGTERM.
+func (l *Lifecycle) Stop() {
+	select {
+	case l.signals <- syscall.SIGTERM:
+	default:
+	}
+}
+
+// Reload asks for a reload, as though we had received SIGHUP.
+func (l *Lifecycle) Reload() {
+	select {
+	case l.reloads <- syscall.SIGHUP:
+	default:
+	}
+}
+
+// OnReload registers the function that reloads the configuration. It's run
+// on the same goroutine as Run, so never at the same time as a shutdown.
+func (l *Lifecycle) OnReload(reload func()) {
+	l.reload = reload
+}
+
+// OnShutdown registers a resource to close once requests have drained.
+// Resources are closed in the reverse order they were registered.
+func (l *Lifecycle) OnShutdown(name string, close func() error) {
+	l.resources = append(l.resources, lifecycleResource{name: name, close: close})
+}
+
+// Run blocks until either the upstream process exits or a shutdown is
+// requested, and returns the upstream's exit code once it has stopped.
+func (l *Lifecycle) Run(server *Server, upstream *UpstreamProcess) (int, error) {
+	exited := make(chan upstreamExit, 1)
+	go func() {
+		code, err := upstream.Wait()
+		exited <- upstreamExit{code: code, err: err}
+	}()
+
+	for {
+		select {
+		case <-l.reloads:
+			if l.reload != nil {
+				l.reload()
+			}
+
+		case sig := <-l.signals:
+			slog.Info("Shutting down", "signal", sig.String(), "drain_timeout", l.drainTimeout)
+			l.drain(server)
+
+			slog.Info("Relaying signal to upstream process", "signal", sig.String())
+			if err := upstream.Signal(sig); err != nil {
+				upstream.Stop()
+			}
+
+			result := <-exited
+			return result.code, result.err
+
+		case result := <-exited:
+			l.drain(server)
+			return result.code, result.err
+		}
+	}
+}
+
+// Close stops catching signals and closes the registered resources. If
+// requests were still running when the drain timed out, the resources are
+// left open rather than pulled out from under them.
+func (l *Lifecycle) Close() {
+	signal.Stop(l.signals)
+	signal.Stop(l.reloads)
+
+	if l.abandoned {
+		slog.Warn("Requests still in flight; leaving resources open")
+		return
+	}
+
+	for _, resource := range slices.Backward(l.resources) {
+		err := resource.close()
+		if err != nil {
+			slog.Error("Failed to close resource", "resource", resource.name, "error", err)
+		}
+	}
+	l.resources = nil
+}
+
+// Private
+
+func (l *Lifecycle) drain(server *Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.drainTimeout)
+	defer cancel()
+
+	// A second signal means we shouldn't wait any longer
+	go func() {
+		select {
+		case sig := <-l.signals:
+			slog.Warn("Abandoning drain", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("Requests did not finish draining", "error", err)
+		l.abandoned = true
+	}
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle_drains_requests_before_stopping_upstream(t *testing.T) {
+	started := make(chan struct{})
+	server, url := startLifecycleTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+
+	upstream := startLifecycleTestUpstream(t)
+	lifecycle := NewLifecycle(time.Second)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		responses <- resp
+	}()
+
+	<-started
+	lifecycle.Stop()
+
+	exitCode, err := lifecycle.Run(server, upstream)
+	require.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+
+	resp := <-responses
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = http.Get(url)
+	assert.Error(t, err, "no longer accepting connections")
+}
+
+func TestLifecycle_closes_resources_in_reverse_order(t *testing.T) {
+	server, _ := startLifecycleTestServer(t, http.NotFoundHandler())
+	lifecycle := NewLifecycle(time.Second)
+
+	closed := []string{}
+	lifecycle.OnShutdown("first", func() error { closed = append(closed, "first"); return nil })
+	lifecycle.OnShutdown("second", func() error { closed = append(closed, "second"); return nil })
+
+	exitCode, err := lifecycle.Run(server, startUpstreamProcess(t, "sh", "-c", "exit 3"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, exitCode)
+
+	lifecycle.Close()
+	assert.Equal(t, []string{"second", "first"}, closed)
+}
+
+func TestLifecycle_leaves_resources_open_when_drain_times_out(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	started := make(chan struct{})
+	server, url := startLifecycleTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	lifecycle := NewLifecycle(50 * time.Millisecond)
+
+	closed := false
+	lifecycle.OnShutdown("resource", func() error { closed = true; return nil })
+
+	go http.Get(url)
+	<-started
+	lifecycle.Stop()
+
+	_, err := lifecycle.Run(server, startLifecycleTestUpstream(t))
+	require.NoError(t, err)
+
+	lifecycle.Close()
+	assert.False(t, closed)
+}
+
+func TestLifecycle_reloads_and_keeps_running(t *testing.T) {
+	server, url := startLifecycleTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	lifecycle := NewLifecycle(time.Second)
+
+	reloads := make(chan struct{})
+	lifecycle.OnReload(func() { reloads <- struct{}{} })
+
+	upstream := startLifecycleTestUpstream(t)
+
+	exited := make(chan int)
+	go func() {
+		exitCode, err := lifecycle.Run(server, upstream)
+		assert.NoError(t, err)
+		exited <- exitCode
+	}()
+
+	lifecycle.Reload()
+	<-reloads
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	lifecycle.Stop()
+	assert.Equal(t, 0, <-exited, "the upstream was stopped cleanly")
+}
+
+// Helpers
+
+func startLifecycleTestServer(t *testing.T, handler http.Handler) (*Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := NewServer(&Config{}, handler)
+	server.httpServer = &http.Server{Handler: handler}
+	go server.httpServer.Serve(listener)
+
+	return server, "http://" + listener.Addr().String()
+}
+
+// startLifecycleTestUpstream starts an upstream that exits cleanly on SIGTERM.
+// It returns once the upstream is ready for the signal, since one that
+// arrives before the trap is set kills the shell instead.
+func startLifecycleTestUpstream(t *testing.T) *UpstreamProcess {
+	ready := filepath.Join(t.TempDir(), "ready")
+	upstream := startUpstreamProcess(t, "sh", "-c", "trap 'exit 0' TERM; touch \"$0\"; while true; do sleep 0.01; done", ready)
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(ready)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return upstream
+}
+
+func startUpstreamProcess(t *testing.T, command string, args ...string) *UpstreamProcess {
+	upstream := NewUpstreamProcess(command, args...)
+	require.NoError(t, upstream.Start())
+	return upstream
+}
@@ -10,8 +10,9 @@ import (
 )
 
 type LoggingMiddleware struct {
-	logger *slog.Logger
-	next   http.Handler
+	logger    *slog.Logger
+	accessLog *AccessLog
+	next      http.Handler
 }
 
 func NewLoggingMiddleware(logger *slog.Logger, next http.Handler) *LoggingMiddleware {
@@ -21,35 +22,50 @@ func NewLoggingMiddleware(logger *slog.Logger, next http.Handler) *LoggingMiddle
 	}
 }
 
+// SetAccessLog sends request lines to the access log, rather than the logger.
+func (h *LoggingMiddleware) SetAccessLog(accessLog *AccessLog) {
+	h.accessLog = accessLog
+}
+
 func (h *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, tags := WithRequestTags(r)
 	writer := newResponseWriter(w)
 
 	started := time.Now()
 	h.next.ServeHTTP(writer, r)
 	elapsed := time.Since(started)
 
-	userAgent := r.Header.Get("User-Agent")
-	reqContent := r.Header.Get("Content-Type")
-	respContent := writer.Header().Get("Content-Type")
-	cache := writer.Header().Get("X-Cache")
-	remoteAddr := r.Header.Get("X-Forwarded-For")
-	if remoteAddr == "" {
-		remoteAddr = r.RemoteAddr
+	cache := tags.Get(TagCacheStatus)
+	if cache == "" {
+		cache = writer.Header().Get("X-Cache")
+	}
+	remoteAddr, _ := clientIP(r)
+
+	entry := AccessLogEntry{
+		Time:              started,
+		Duration:          elapsed,
+		RemoteAddr:        remoteAddr,
+		Method:            r.Method,
+		Path:              r.URL.Path,
+		Query:             r.URL.RawQuery,
+		Proto:             r.Proto,
+		Status:            writer.statusCode,
+		ReqContentLength:  r.ContentLength,
+		ReqContentType:    r.Header.Get("Content-Type"),
+		RespContentLength: writer.bytesWritten,
+		RespContentType:   writer.Header().Get("Content-Type"),
+		Referer:           r.Header.Get("Referer"),
+		UserAgent:         r.Header.Get("User-Agent"),
+		Cache:             cache,
+		Tags:              tags.All(),
 	}
 
-	h.logger.Info("Request",
-		"path", r.URL.Path,
-		"status", writer.statusCode,
-		"dur", elapsed.Milliseconds(),
-		"method", r.Method,
-		"req_content_length", r.ContentLength,
-		"req_content_type", reqContent,
-		"resp_content_length", writer.bytesWritten,
-		"resp_content_type", respContent,
-		"remote_addr", remoteAddr,
-		"user_agent", userAgent,
-		"cache", cache,
-		"query", r.URL.RawQuery)
+	if h.accessLog != nil {
+		h.accessLog.Write(entry)
+		return
+	}
+
+	logAccessLogEntry(h.logger, entry)
 }
 
 type responseWriter struct {
@@ -75,6 +91,10 @@ func (r *responseWriter) Write(b []byte) (int, error) {
 	return bytesWritten, err
 }
 
+func (r *responseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func (r *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hijacker, ok := r.ResponseWriter.(http.Hijacker)
 	if !ok {
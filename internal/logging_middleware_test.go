@@ -19,33 +19,40 @@ func TestMiddleware_LoggingMiddleware(t *testing.T) {
 	logger := slog.New(slog.NewJSONHandler(out, nil))
 	middleware := NewLoggingMiddleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Cache", "miss")
+		RequestTagsFromContext(r.Context()).Set(TagCountry, "GB")
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusCreated)
 		fmt.Fprintln(w, "goodbye")
 	}))
 
+	trusted, err := ParseCIDRs(DefaultTrustedProxies)
+	require.NoError(t, err)
+	handler := NewClientAddressMiddleware(NewTrustedProxies(trusted), middleware)
+
 	req := httptest.NewRequest("POST", "/somepath?q=ok", bytes.NewReader([]byte("hello")))
+	req.RemoteAddr = "10.0.0.1:1234"
 	req.Header.Set("X-Forwarded-For", "192.168.1.1")
 	req.Header.Set("User-Agent", "Robot/1")
 	req.Header.Set("Content-Type", "application/json")
 
-	middleware.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
 
 	logline := struct {
-		Path              string `json:"path"`
-		Method            string `json:"method"`
-		Status            int    `json:"status"`
-		RemoteAddr        string `json:"remote_addr"`
-		UserAgent         string `json:"user_agent"`
-		ReqContentLength  int64  `json:"req_content_length"`
-		ReqContentType    string `json:"req_content_type"`
-		RespContentLength int64  `json:"resp_content_length"`
-		RespContentType   string `json:"resp_content_type"`
-		Query             string `json:"query"`
-		Cache             string `json:"cache"`
+		Path              string            `json:"path"`
+		Method            string            `json:"method"`
+		Status            int               `json:"status"`
+		RemoteAddr        string            `json:"remote_addr"`
+		UserAgent         string            `json:"user_agent"`
+		ReqContentLength  int64             `json:"req_content_length"`
+		ReqContentType    string            `json:"req_content_type"`
+		RespContentLength int64             `json:"resp_content_length"`
+		RespContentType   string            `json:"resp_content_type"`
+		Query             string            `json:"query"`
+		Cache             string            `json:"cache"`
+		Tags              map[string]string `json:"tags"`
 	}{}
 
-	err := json.NewDecoder(strings.NewReader(out.String())).Decode(&logline)
+	err = json.NewDecoder(strings.NewReader(out.String())).Decode(&logline)
 	require.NoError(t, err)
 
 	assert.Equal(t, "/somepath", logline.Path)
@@ -59,4 +66,43 @@ func TestMiddleware_LoggingMiddleware(t *testing.T) {
 	assert.Equal(t, int64(5), logline.ReqContentLength)
 	assert.Equal(t, int64(8), logline.RespContentLength)
 	assert.Equal(t, "miss", logline.Cache)
+	assert.Equal(t, map[string]string{TagCountry: "GB"}, logline.Tags)
+}
+
+func TestMiddleware_LoggingMiddleware_with_access_log(t *testing.T) {
+	out := &strings.Builder{}
+	middleware := NewLoggingMiddleware(slog.Default(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RequestTagsFromContext(r.Context()).Set(TagCountry, "RU")
+		RequestTagsFromContext(r.Context()).Set(TagBlocked, BlockedByCountry)
+		http.Error(w, "Access denied", http.StatusForbidden)
+	}))
+	middleware.SetAccessLog(NewAccessLog(AccessLogFormatCombined, out, true))
+
+	req := httptest.NewRequest("GET", "/somepath?q=ok", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", "Robot/1")
+
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Regexp(t, `^192\.168\.1\.1 - - \[.+\] "GET /somepath\?q=ok HTTP/1\.1" 403 14 "https://example\.com/" "Robot/1" "RU" "-" "country"\n$`, out.String())
+}
+
+func TestMiddleware_LoggingMiddleware_ignores_forwarded_for_from_untrusted_peers(t *testing.T) {
+	out := &strings.Builder{}
+	middleware := NewLoggingMiddleware(slog.Default(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	middleware.SetAccessLog(NewAccessLog(AccessLogFormatCombined, out, true))
+
+	trusted, err := ParseCIDRs(DefaultTrustedProxies)
+	require.NoError(t, err)
+	handler := NewClientAddressMiddleware(NewTrustedProxies(trusted), middleware)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "81.2.69.142:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Regexp(t, `^81\.2\.69\.142 - - `, out.String())
+	assert.NotContains(t, out.String(), "203.0.113.9")
 }
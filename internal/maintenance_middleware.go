@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMaintenanceWindow = errors.New("maintenance window must be in the form COUNTRY[|COUNTRY...]@schedule")
+
+// MaintenanceWindow shows the maintenance page to requests from some countries
+// while its schedule is active, so that work can be done during each region's
+// quietest hours while everyone else carries on using the app.
+type MaintenanceWindow struct {
+	Countries []string
+	Schedule  Schedule
+}
+
+// ParseMaintenanceWindow parses a window written as
+// `COUNTRY[|COUNTRY...]@schedule`, with the schedule as for ParseSchedule:
+// for example `JP|KR|AU@Sat 02:00-04:00 Asia/Tokyo`.
+func ParseMaintenanceWindow(value string) (MaintenanceWindow, error) {
+	countries, schedule, ok := strings.Cut(strings.TrimSpace(value), "@")
+	if !ok {
+		return MaintenanceWindow{}, ErrInvalidMaintenanceWindow
+	}
+
+	window := MaintenanceWindow{}
+
+	for _, country := range strings.Split(countries, "|") {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if country == "" {
+			return MaintenanceWindow{}, ErrInvalidMaintenanceWindow
+		}
+		window.Countries = append(window.Countries, country)
+	}
+
+	var err error
+	window.Schedule, err = ParseSchedule(schedule)
+	if err != nil {
+		return MaintenanceWindow{}, err
+	}
+
+	return window, nil
+}
+
+// String formats the window in the form that ParseMaintenanceWindow reads.
+func (w MaintenanceWindow) String() string {
+	return strings.Join(w.Countries, "|") + "@" + w.Schedule.String()
+}
+
+type MaintenanceWindows []MaintenanceWindow
+
+// MaintenanceMiddleware answers requests from countries in a maintenance
+// window with a 503, and a Retry-After of when the window closes. Requests
+// whose country isn't known are let through. While maintenance mode is on,
+// every request is answered that way instead.
+//
+// Requests from local and internal addresses, and from the allowed networks,
+// always reach the app, so that it can be checked on during maintenance.
+//
+// The country is read from the request tags, so this has to run after the
+// GeoIP stage.
+type MaintenanceMiddleware struct {
+	logger          *slog.Logger
+	next            http.Handler
+	windows         MaintenanceWindows
+	mode            *MaintenanceMode
+	allowedNetworks *NetworkSet
+	page            *PageTemplate
+	now             func() time.Time
+}
+
+func NewMaintenanceMiddleware(logger *slog.Logger, next http.Handler, windows MaintenanceWindows) *MaintenanceMiddleware {
+	return &MaintenanceMiddleware{
+		logger:  logger,
+		next:    next,
+		windows: windows,
+		now:     time.Now,
+	}
+}
+
+// SetMode takes every request into account of maintenance mode, as well as
+// the windows.
+func (m *MaintenanceMiddleware) SetMode(mode *MaintenanceMode) {
+	m.mode = mode
+}
+
+// SetAllowedNetworks lets requests from the networks through during
+// maintenance.
+func (m *MaintenanceMiddleware) SetAllowedNetworks(networks []*net.IPNet) {
+	m.allowedNetworks = NewNetworkSet(map[string][]*net.IPNet{"allowed": networks})
+}
+
+// SetPage sets the page served during maintenance, in place of a plain
+// "Down for maintenance".
+func (m *MaintenanceMiddleware) SetPage(page *PageTemplate) {
+	m.page = page
+}
+
+func (m *MaintenanceMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.allowed(r) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	country := strings.ToUpper(CountryFromContext(r.Context()))
+	remaining := m.remaining(country)
+
+	if m.mode.Enabled() {
+		remaining = max(remaining, m.mode.RetryAfter())
+		m.logger.DebugContext(r.Context(), "Request refused - maintenance mode", "path", r.URL.Path, "retry_after", remaining.String())
+	} else if remaining > 0 {
+		m.logger.DebugContext(r.Context(), "Request refused - maintenance window", "path", r.URL.Path, "country", country, "remaining", remaining.String())
+	} else {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	if m.page != nil {
+		m.page.Render(w, r, http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Error(w, "Down for maintenance", http.StatusServiceUnavailable)
+}
+
+// Private
+
+func (m *MaintenanceMiddleware) allowed(r *http.Request) bool {
+	_, ip := clientIP(r)
+	return ip != nil && (isLocalOrInternalIP(ip) || m.allowedNetworks.Contains(ip))
+}
+
+// remaining is how long the country's maintenance lasts, taking the longest
+// of any overlapping windows.
+func (m *MaintenanceMiddleware) remaining(country string) time.Duration {
+	if country == "" {
+		return 0
+	}
+
+	now := m.now()
+
+	var remaining time.Duration
+	for _, window := range m.windows {
+		if slices.Contains(window.Countries, country) {
+			remaining = max(remaining, window.Schedule.Remaining(now))
+		}
+	}
+	return remaining
+}
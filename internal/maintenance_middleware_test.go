@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	window, err := ParseMaintenanceWindow("jp|KR@Sun 02:00-04:00 Asia/Tokyo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"JP", "KR"}, window.Countries)
+	assert.Equal(t, []time.Weekday{time.Sunday}, window.Schedule.Days)
+	assert.Equal(t, "JP|KR@Sun 02:00-04:00 Asia/Tokyo", window.String())
+
+	_, err = ParseMaintenanceWindow("02:00-04:00")
+	assert.ErrorIs(t, err, ErrInvalidMaintenanceWindow)
+
+	_, err = ParseMaintenanceWindow("JP|@02:00-04:00")
+	assert.ErrorIs(t, err, ErrInvalidMaintenanceWindow)
+
+	_, err = ParseMaintenanceWindow("JP@02:00")
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+}
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	windows := MaintenanceWindows{
+		{Countries: []string{"JP", "AU"}, Schedule: Schedule{Start: 2 * time.Hour, End: 4 * time.Hour}},
+		{Countries: []string{"AU"}, Schedule: Schedule{Start: 3 * time.Hour, End: 5 * time.Hour}},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("live"))
+	})
+	middleware := NewMaintenanceMiddleware(slog.Default(), next, windows)
+
+	request := func(country string, now time.Time) *httptest.ResponseRecorder {
+		middleware.now = func() time.Time { return now }
+
+		r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+		if country != "" {
+			tags.Set(TagCountry, country)
+		}
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		return w
+	}
+
+	midnight := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	w := request("JP", midnight.Add(3*time.Hour+30*time.Minute))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1800", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Down for maintenance")
+
+	w = request("AU", midnight.Add(3*time.Hour+30*time.Minute))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5400", w.Header().Get("Retry-After"), "the longest overlapping window")
+
+	w = request("GB", midnight.Add(3*time.Hour))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "live", w.Body.String())
+
+	w = request("", midnight.Add(3*time.Hour))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request("JP", midnight.Add(4*time.Hour))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+}
+
+func TestMaintenanceMiddleware_maintenance_mode(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("live"))
+	})
+	mode := NewMaintenanceMode(5 * time.Minute)
+
+	middleware := NewMaintenanceMiddleware(slog.Default(), next, MaintenanceWindows{})
+	middleware.SetMode(mode)
+	middleware.SetAllowedNetworks([]*net.IPNet{{IP: net.ParseIP("203.0.113.0"), Mask: net.CIDRMask(24, 32)}})
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("198.51.100.7:1234").Code)
+
+	mode.Enable(0)
+
+	w := request("198.51.100.7:1234")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "300", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, request("203.0.113.9:1234").Code, "allowed network")
+	assert.Equal(t, http.StatusOK, request("10.0.0.5:1234").Code, "internal address")
+
+	mode.Disable()
+	assert.Equal(t, http.StatusOK, request("198.51.100.7:1234").Code)
+}
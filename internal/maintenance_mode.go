@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maintenanceFilePollInterval = time.Second
+
+// MaintenanceMode takes the whole app down for maintenance while it's on. It
+// can be turned on through the admin API, or by creating the maintenance file,
+// and stays on while either says so.
+//
+// A nil *MaintenanceMode is valid, and is never on.
+type MaintenanceMode struct {
+	manual     atomic.Bool
+	file       atomic.Bool
+	retryAfter atomic.Int64
+
+	defaultRetryAfter time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// MaintenanceModeStatus is the state of maintenance mode, and what turned it
+// on.
+type MaintenanceModeStatus struct {
+	Enabled    bool `json:"enabled"`
+	Manual     bool `json:"manual"`
+	File       bool `json:"file"`
+	RetryAfter int  `json:"retry_after"`
+}
+
+func NewMaintenanceMode(defaultRetryAfter time.Duration) *MaintenanceMode {
+	m := &MaintenanceMode{defaultRetryAfter: defaultRetryAfter}
+	m.retryAfter.Store(int64(defaultRetryAfter))
+	return m
+}
+
+// Enabled reports whether maintenance mode is on.
+func (m *MaintenanceMode) Enabled() bool {
+	if m == nil {
+		return false
+	}
+	return m.manual.Load() || m.file.Load()
+}
+
+// RetryAfter is how long clients are asked to wait before trying again.
+func (m *MaintenanceMode) RetryAfter() time.Duration {
+	if m == nil {
+		return 0
+	}
+	return time.Duration(m.retryAfter.Load())
+}
+
+// Enable turns maintenance mode on, asking clients to retry after the given
+// time, or the default when it's zero.
+func (m *MaintenanceMode) Enable(retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = m.defaultRetryAfter
+	}
+	m.retryAfter.Store(int64(retryAfter))
+
+	if !m.manual.Swap(true) {
+		slog.Info("Maintenance mode enabled", "source", "admin", "retry_after", retryAfter.String())
+	}
+}
+
+// Disable turns off maintenance mode that was turned on by Enable. It stays
+// on while the maintenance file exists.
+func (m *MaintenanceMode) Disable() {
+	m.retryAfter.Store(int64(m.defaultRetryAfter))
+
+	if m.manual.Swap(false) {
+		slog.Info("Maintenance mode disabled", "source", "admin")
+	}
+}
+
+func (m *MaintenanceMode) Status() MaintenanceModeStatus {
+	if m == nil {
+		return MaintenanceModeStatus{}
+	}
+
+	return MaintenanceModeStatus{
+		Enabled:    m.Enabled(),
+		Manual:     m.manual.Load(),
+		File:       m.file.Load(),
+		RetryAfter: int(m.RetryAfter().Seconds()),
+	}
+}
+
+// WatchFile turns maintenance mode on whenever a file exists at the path,
+// checking for it every interval until stopped. Deploy scripts can then
+// `touch` the file to start maintenance, and remove it to end it.
+func (m *MaintenanceMode) WatchFile(path string, interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	m.checkFile(path)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.checkFile(path)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *MaintenanceMode) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+		m.wg.Wait()
+	}
+}
+
+// Private
+
+func (m *MaintenanceMode) checkFile(path string) {
+	_, err := os.Stat(path)
+	exists := err == nil
+
+	if m.file.Swap(exists) != exists {
+		if exists {
+			slog.Info("Maintenance mode enabled", "source", "file", "path", path)
+		} else {
+			slog.Info("Maintenance mode disabled", "source", "file", "path", path)
+		}
+	}
+}
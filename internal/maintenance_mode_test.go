@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceMode_enable_and_disable(t *testing.T) {
+	mode := NewMaintenanceMode(5 * time.Minute)
+	assert.False(t, mode.Enabled())
+	assert.Equal(t, 5*time.Minute, mode.RetryAfter())
+
+	mode.Enable(10 * time.Minute)
+	assert.True(t, mode.Enabled())
+	assert.Equal(t, 10*time.Minute, mode.RetryAfter())
+	assert.Equal(t, MaintenanceModeStatus{Enabled: true, Manual: true, RetryAfter: 600}, mode.Status())
+
+	mode.Disable()
+	assert.False(t, mode.Enabled())
+	assert.Equal(t, 5*time.Minute, mode.RetryAfter())
+
+	mode.Enable(0)
+	assert.Equal(t, 5*time.Minute, mode.RetryAfter())
+}
+
+func TestMaintenanceMode_watches_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance.txt")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+
+	mode := NewMaintenanceMode(time.Minute)
+	mode.WatchFile(path, 10*time.Millisecond)
+	defer mode.Stop()
+
+	assert.True(t, mode.Enabled(), "checked as soon as watching starts")
+
+	mode.Disable()
+	assert.True(t, mode.Enabled(), "the file keeps it on")
+
+	require.NoError(t, os.Remove(path))
+	assert.Eventually(t, func() bool { return !mode.Enabled() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, MaintenanceModeStatus{RetryAfter: 60}, mode.Status())
+}
+
+func TestMaintenanceMode_nil_is_never_enabled(t *testing.T) {
+	var mode *MaintenanceMode
+	assert.False(t, mode.Enabled())
+	assert.Zero(t, mode.RetryAfter())
+	assert.Equal(t, MaintenanceModeStatus{}, mode.Status())
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"maps"
+	"sync"
+)
+
+const (
+	MemoryBudgetComponentCache       = "cache"
+	MemoryBudgetComponentRateLimiter = "rate_limiter"
+)
+
+// MemoryBudget caps the combined size of our in-memory structures. Each
+// structure reserves space before growing and releases it when shrinking, so
+// that the total stays under a single limit regardless of which one is busy.
+//
+// A nil budget is valid, and places no limit on anything.
+type MemoryBudget struct {
+	sync.Mutex
+	limit int
+	used  int
+	usage map[string]int
+}
+
+func NewMemoryBudget(limit int) *MemoryBudget {
+	return &MemoryBudget{
+		limit: limit,
+		usage: map[string]int{},
+	}
+}
+
+// Reserve claims size bytes for component, returning false if that would take
+// the budget over its limit.
+func (b *MemoryBudget) Reserve(component string, size int) bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.used+size > b.limit {
+		return false
+	}
+
+	b.used += size
+	b.usage[component] += size
+	return true
+}
+
+func (b *MemoryBudget) Release(component string, size int) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.used -= size
+	b.usage[component] -= size
+}
+
+func (b *MemoryBudget) Limit() int {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+func (b *MemoryBudget) Used() int {
+	if b == nil {
+		return 0
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	return b.used
+}
+
+// Usage returns the number of bytes currently reserved by each component.
+func (b *MemoryBudget) Usage() map[string]int {
+	if b == nil {
+		return map[string]int{}
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	return maps.Clone(b.usage)
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudget_reserve_and_release(t *testing.T) {
+	b := NewMemoryBudget(100)
+
+	assert.True(t, b.Reserve("a", 60))
+	assert.True(t, b.Reserve("b", 40))
+	assert.False(t, b.Reserve("a", 1))
+
+	assert.Equal(t, 100, b.Used())
+	assert.Equal(t, map[string]int{"a": 60, "b": 40}, b.Usage())
+
+	b.Release("a", 30)
+	assert.True(t, b.Reserve("b", 30))
+	assert.Equal(t, map[string]int{"a": 30, "b": 70}, b.Usage())
+}
+
+func TestMemoryBudget_nil_budget_is_unlimited(t *testing.T) {
+	var b *MemoryBudget
+
+	assert.True(t, b.Reserve("a", 1*MB))
+	b.Release("a", 1*MB)
+	assert.Equal(t, 0, b.Used())
+	assert.Empty(t, b.Usage())
+}
@@ -11,6 +11,7 @@ type GetCurrentTime func() time.Time
 
 type MemoryCacheEntry struct {
 	lastAccessedAt time.Time
+	accesses       int
 	expiresAt      time.Time
 	value          []byte
 }
@@ -22,9 +23,13 @@ type MemoryCache struct {
 	sync.Mutex
 	capacity       int
 	maxItemSize    int
+	maxEntries     int
+	eviction       CacheEviction
 	size           int
 	keys           MemoryCacheKeyList
 	items          MemoryCacheEntryMap
+	budget         *MemoryBudget
+	stats          *CacheStats
 	getCurrentTime GetCurrentTime
 }
 
@@ -32,6 +37,7 @@ func NewMemoryCache(capacity, maxItemSize int) *MemoryCache {
 	return &MemoryCache{
 		capacity:       capacity,
 		maxItemSize:    maxItemSize,
+		eviction:       CacheEvictionLRU,
 		size:           0,
 		keys:           MemoryCacheKeyList{},
 		items:          MemoryCacheEntryMap{},
@@ -39,12 +45,51 @@ func NewMemoryCache(capacity, maxItemSize int) *MemoryCache {
 	}
 }
 
+// SetMemoryBudget makes the cache account for its contents against a shared
+// budget, evicting items when the budget is exhausted.
+func (c *MemoryCache) SetMemoryBudget(budget *MemoryBudget) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.budget = budget
+	c.budget.Reserve(MemoryBudgetComponentCache, c.size)
+}
+
+// SetStats makes the cache report its evictions and size.
+func (c *MemoryCache) SetStats(stats *CacheStats) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stats = stats
+	c.stats.SetStored(c.size, len(c.keys))
+}
+
+// SetMaxEntries limits the number of items in the cache, regardless of their
+// size, evicting items to make room. Zero means no limit.
+func (c *MemoryCache) SetMaxEntries(maxEntries int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.maxEntries = maxEntries
+	for c.maxEntries > 0 && len(c.keys) > c.maxEntries {
+		c.evictItem()
+	}
+}
+
+// SetEviction sets how the cache chooses items to evict.
+func (c *MemoryCache) SetEviction(eviction CacheEviction) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.eviction = eviction
+}
+
 func (c *MemoryCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
 	c.Lock()
 	defer c.Unlock()
 
 	itemSize := len(value)
-	if itemSize > c.maxItemSize || itemSize > c.capacity {
+	if itemSize > c.maxItemSize || itemSize > c.capacity || (c.budget != nil && itemSize > c.budget.Limit()) {
 		slog.Debug("Cache: item is too large to store", "len", itemSize)
 		return
 	}
@@ -52,12 +97,26 @@ func (c *MemoryCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
 	limit := c.capacity - itemSize
 	for c.size > limit {
 		slog.Debug("Cache: evicting item to make space", "current_size", c.size, "need_size", limit)
-		c.evictOldestItem()
+		c.evictItem()
+	}
+
+	for c.maxEntries > 0 && len(c.keys) >= c.maxEntries && c.items[key] == nil {
+		slog.Debug("Cache: evicting item to make room", "entries", len(c.keys), "max_entries", c.maxEntries)
+		c.evictItem()
+	}
+
+	for !c.budget.Reserve(MemoryBudgetComponentCache, itemSize) {
+		if len(c.keys) == 0 {
+			slog.Debug("Cache: memory budget exhausted", "len", itemSize)
+			return
+		}
+		c.evictItem()
 	}
 
 	existingItem, ok := c.items[key]
 	if ok {
 		c.size -= len(existingItem.value)
+		c.budget.Release(MemoryBudgetComponentCache, len(existingItem.value))
 	} else {
 		c.keys = append(c.keys, key)
 	}
@@ -69,6 +128,7 @@ func (c *MemoryCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
 	}
 
 	c.size += itemSize
+	c.stats.SetStored(c.size, len(c.keys))
 
 	slog.Debug("Cache: added item", "key", key, "size", itemSize, "expires_at", expiresAt)
 }
@@ -85,43 +145,61 @@ func (c *MemoryCache) Get(key CacheKey) ([]byte, bool) {
 	}
 
 	item.lastAccessedAt = now
+	item.accesses++
 	return item.value, true
 }
 
-func (c *MemoryCache) evictOldestItem() {
-	var oldestKey CacheKey
-	var oldestIndex int
-	var oldest time.Time
+// Close empties the cache, returning the memory it held to the budget.
+func (c *MemoryCache) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.budget.Release(MemoryBudgetComponentCache, c.size)
+	c.size = 0
+	c.keys = MemoryCacheKeyList{}
+	c.items = MemoryCacheEntryMap{}
+	c.stats.SetStored(c.size, len(c.keys))
+
+	return nil
+}
+
+func (c *MemoryCache) evictItem() {
+	var evictKey CacheKey
+	var evictIndex int
+	var evict *MemoryCacheEntry
 
 	now := c.getCurrentTime()
 
-	// Pick 5 random items and evict the oldest one, On average we'll evict items
-	// in the oldest 20%, which is good enough and is much faster than scanning
-	// through them all.
+	// Pick a few random items and evict the one the eviction policy likes
+	// least.
 	//
 	// If we find an expired item while looking, that's a better choice to evict,
 	// so we can choose it immediately.
-	for i := 0; i < 5; i++ {
+	for i := 0; i < cacheEvictionSampleSize; i++ {
 		index := rand.Intn(len(c.keys))
 		key := c.keys[index]
 		v := c.items[key]
 
 		if v.expiresAt.Before(now) {
-			oldestKey = key
-			oldestIndex = index
+			evictKey = key
+			evictIndex = index
 			break
 		}
 
-		if v.lastAccessedAt.Before(oldest) || oldest.IsZero() {
-			oldest = v.lastAccessedAt
-			oldestKey = key
-			oldestIndex = index
+		if evict == nil || c.eviction.prefers(v.lastAccessedAt, v.accesses, evict.lastAccessedAt, evict.accesses) {
+			evict = v
+			evictKey = key
+			evictIndex = index
 		}
 	}
 
-	c.keys[oldestIndex] = c.keys[len(c.keys)-1]
+	c.keys[evictIndex] = c.keys[len(c.keys)-1]
 	c.keys = c.keys[:len(c.keys)-1]
 
-	c.size -= len(c.items[oldestKey].value)
-	delete(c.items, oldestKey)
+	c.size -= len(c.items[evictKey].value)
+	c.budget.Release(MemoryBudgetComponentCache, len(c.items[evictKey].value))
+	delete(c.items, evictKey)
+
+	c.stats.Evicted()
+	c.stats.SetStored(c.size, len(c.keys))
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMemoryCache_store_and_retrieve(t *testing.T) {
@@ -88,6 +89,42 @@ func TestMemoryCache_items_are_evicted_to_make_space(t *testing.T) {
 	assert.Equal(t, maxCacheSize, c.size)
 }
 
+func TestMemoryCache_reports_stats(t *testing.T) {
+	stats := NewCacheStats()
+	c := NewMemoryCache(3*KB, 1*KB)
+	c.SetStats(stats)
+
+	for i := 0; i < 5; i++ {
+		c.Set(CacheKey(i), make([]byte, 1*KB), time.Now().Add(1*time.Hour))
+	}
+
+	assert.Equal(t, uint64(2), stats.Snapshot().Evictions)
+	assert.Equal(t, int64(3*KB), stats.Snapshot().StoredBytes)
+	assert.Equal(t, int64(3), stats.Snapshot().Entries)
+
+	c.Close()
+	assert.Equal(t, int64(0), stats.Snapshot().StoredBytes)
+	assert.Equal(t, int64(0), stats.Snapshot().Entries)
+}
+
+func TestMemoryCache_items_are_evicted_to_stay_within_max_entries(t *testing.T) {
+	c := NewMemoryCache(1*MB, 1*KB)
+	c.SetMaxEntries(3)
+
+	for i := CacheKey(0); i < 10; i++ {
+		c.Set(i, []byte("value"), time.Now().Add(1*time.Hour))
+	}
+	assert.Len(t, c.items, 3)
+
+	// Replacing an item doesn't need room for another
+	c.Set(c.keys[0], []byte("new value"), time.Now().Add(1*time.Hour))
+	assert.Len(t, c.items, 3)
+
+	c.SetMaxEntries(1)
+	assert.Len(t, c.items, 1)
+	assert.Len(t, c.keys, 1)
+}
+
 func TestMemoryCache_does_not_store_items_over_item_limit(t *testing.T) {
 	c := NewMemoryCache(50*KB, 3*KB)
 
@@ -119,3 +156,34 @@ func BenchmarkCache_populating_large_objects(b *testing.B) {
 		c.Get(i)
 	}
 }
+
+func TestMemoryCache_evicts_items_to_stay_within_memory_budget(t *testing.T) {
+	budget := NewMemoryBudget(3 * KB)
+	c := NewMemoryCache(32*MB, 1*MB)
+	c.SetMemoryBudget(budget)
+
+	for i := 0; i < 5; i++ {
+		c.Set(CacheKey(i), make([]byte, 1*KB), time.Now().Add(1*time.Hour))
+	}
+
+	assert.Equal(t, 3, len(c.keys))
+	assert.Equal(t, 3*KB, budget.Usage()[MemoryBudgetComponentCache])
+
+	c.Set(CacheKey(10), make([]byte, 4*KB), time.Now().Add(1*time.Hour))
+	_, ok := c.Get(CacheKey(10))
+	assert.False(t, ok)
+	assert.Equal(t, 3, len(c.keys))
+}
+
+func TestMemoryCache_close_releases_items(t *testing.T) {
+	budget := NewMemoryBudget(3 * KB)
+	c := NewMemoryCache(32*MB, 1*MB)
+	c.SetMemoryBudget(budget)
+
+	c.Set(CacheKey(1), make([]byte, 1*KB), time.Now().Add(1*time.Hour))
+	require.NoError(t, c.Close())
+
+	_, ok := c.Get(CacheKey(1))
+	assert.False(t, ok)
+	assert.Equal(t, 0, budget.Usage()[MemoryBudgetComponentCache])
+}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const defaultPageLanguage = "en"
+
+// Pages holds what's shared between the HTML pages we serve in place of an
+// upstream response, such as block and bad gateway pages.
+//
+// Pages are html/template templates. Files beside a page whose names start
+// with an underscore are parsed along with it, to be included as partials
+// (`{{template "_footer.html" .}}`). Text wrapped in `{{.T "..."}}` is
+// translated using the locale files, which are JSON objects mapping English
+// text to its translation, named for their language (`de.json`).
+//
+// A nil *Pages is valid, and has no translations or support link.
+type Pages struct {
+	locales    map[string]map[string]string
+	supportURL string
+}
+
+func NewPages(supportURL string) *Pages {
+	return &Pages{
+		locales:    map[string]map[string]string{},
+		supportURL: supportURL,
+	}
+}
+
+// LoadLocales reads the translations in dir.
+func (p *Pages) LoadLocales(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var translations map[string]string
+		err = json.Unmarshal(data, &translations)
+		if err != nil {
+			return err
+		}
+
+		language := strings.ToLower(strings.TrimSuffix(filepath.Base(path), ".json"))
+		p.locales[language] = translations
+	}
+
+	return nil
+}
+
+// Load parses the page at path, along with its partials.
+func (p *Pages) Load(path string) (*PageTemplate, error) {
+	name := filepath.Base(path)
+
+	tmpl, err := template.New(name).Funcs(pageFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	partials, err := filepath.Glob(filepath.Join(filepath.Dir(path), "_*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(partials) > 0 {
+		tmpl, err = tmpl.ParseFiles(partials...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &PageTemplate{pages: p, name: name, template: tmpl}, nil
+}
+
+// LoadIfExists is like Load, but quietly returns nil if there is no page at
+// path. Pages that can't be parsed are logged and ignored.
+func (p *Pages) LoadIfExists(path string) *PageTemplate {
+	if path == "" {
+		return nil
+	}
+
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	page, err := p.Load(path)
+	if err != nil {
+		slog.Error("Unable to parse page", "path", path, "error", err)
+		return nil
+	}
+
+	return page
+}
+
+// PageTemplate is a page ready to be rendered for a request.
+type PageTemplate struct {
+	pages    *Pages
+	name     string
+	template *template.Template
+}
+
+// PageData is what a page is rendered with.
+type PageData struct {
+	StatusCode int
+	Country    string
+	Host       string
+	Path       string
+	Language   string
+	SupportURL string
+
+	// Challenge is what a challenge page needs to have its solution checked.
+	Challenge ChallengeData
+
+	translations map[string]string
+}
+
+// T translates text into the language of the page, when there is a
+// translation for it.
+func (d PageData) T(text string) string {
+	translated, ok := d.translations[text]
+	if !ok {
+		return text
+	}
+	return translated
+}
+
+func (t *PageTemplate) Render(w http.ResponseWriter, r *http.Request, statusCode int) {
+	t.render(w, t.pages.data(r, statusCode))
+}
+
+// Private
+
+// parse makes a page of our own from the template text.
+func (p *Pages) parse(name, text string) *PageTemplate {
+	tmpl := template.Must(template.New(name).Funcs(pageFuncs).Parse(text))
+	return &PageTemplate{pages: p, name: name, template: tmpl}
+}
+
+func (t *PageTemplate) render(w http.ResponseWriter, data PageData) {
+	var buf bytes.Buffer
+	err := t.template.ExecuteTemplate(&buf, t.name, data)
+	if err != nil {
+		slog.Error("Unable to render page", "page", t.name, "error", err)
+		http.Error(w, http.StatusText(data.StatusCode), data.StatusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(data.StatusCode)
+	w.Write(buf.Bytes())
+}
+
+var pageFuncs = template.FuncMap{
+	"country_name": pageCountryName,
+	"country_flag": CountryFlag,
+	"status_text":  http.StatusText,
+	"qr_code":      qrCodeDataURL,
+}
+
+func (p *Pages) data(r *http.Request, statusCode int) PageData {
+	data := PageData{
+		StatusCode: statusCode,
+		Country:    CountryFromContext(r.Context()),
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Language:   defaultPageLanguage,
+	}
+
+	if p != nil {
+		data.SupportURL = p.supportURL
+		data.Language = p.negotiateLanguage(r.Header.Get("Accept-Language"))
+		data.translations = p.locales[data.Language]
+	}
+
+	return data
+}
+
+// negotiateLanguage picks the language the client most prefers out of those
+// we have translations for.
+func (p *Pages) negotiateLanguage(acceptLanguage string) string {
+	type preference struct {
+		language string
+		quality  float64
+	}
+
+	preferences := []preference{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		language, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+
+		value, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if language != "" && quality > 0 {
+			preferences = append(preferences, preference{strings.ToLower(language), quality})
+		}
+	}
+
+	slices.SortStableFunc(preferences, func(a, b preference) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	for _, preference := range preferences {
+		if _, ok := p.locales[preference.language]; ok {
+			return preference.language
+		}
+
+		base, _, _ := strings.Cut(preference.language, "-")
+		if _, ok := p.locales[base]; ok {
+			return base
+		}
+	}
+
+	return defaultPageLanguage
+}
+
+// pageCountryName names a country in English, or in the language given, as
+// in `{{country_name .Country .Language}}`.
+func pageCountryName(code string, language ...string) string {
+	if len(language) > 0 {
+		return LocalizedCountryName(code, language[0])
+	}
+	return CountryName(code)
+}
+
+func qrCodeDataURL(text string) (template.URL, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())), nil
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPages_render(t *testing.T) {
+	dir := t.TempDir()
+	writePageFile(t, dir, "403.html", `{{template "_header.html" .}}<p>{{.StatusCode}} {{status_text .StatusCode}} for {{.Host}}{{.Path}}</p>`)
+	writePageFile(t, dir, "_header.html", `<h1>{{.T "Access denied"}}</h1>`)
+
+	page, err := NewPages("").Load(filepath.Join(dir, "403.html"))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	page.Render(w, httptest.NewRequest("GET", "http://example.com/secret", nil), http.StatusForbidden)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+	assert.Equal(t, "<h1>Access denied</h1><p>403 Forbidden for example.com/secret</p>", w.Body.String())
+}
+
+func TestPages_translations(t *testing.T) {
+	dir := t.TempDir()
+	writePageFile(t, dir, "403.html", `{{.Language}}: {{.T "Access denied"}}`)
+
+	locales := t.TempDir()
+	writePageFile(t, locales, "de.json", `{"Access denied": "Zugriff verweigert"}`)
+	writePageFile(t, locales, "fr.json", `{"Access denied": "Accès refusé"}`)
+
+	pages := NewPages("")
+	require.NoError(t, pages.LoadLocales(locales))
+
+	page, err := pages.Load(filepath.Join(dir, "403.html"))
+	require.NoError(t, err)
+
+	render := func(acceptLanguage string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		page.Render(w, r, http.StatusForbidden)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "en: Access denied", render(""))
+	assert.Equal(t, "de: Zugriff verweigert", render("de-AT"))
+	assert.Equal(t, "fr: Accès refusé", render("es, de;q=0.5, fr;q=0.8"))
+	assert.Equal(t, "en: Access denied", render("es, de;q=0"))
+}
+
+func TestPages_country_functions(t *testing.T) {
+	dir := t.TempDir()
+	writePageFile(t, dir, "403.html", `{{country_flag .Country}} {{country_name .Country}} / {{country_name .Country .Language}}`)
+
+	locales := t.TempDir()
+	writePageFile(t, locales, "de.json", `{}`)
+
+	pages := NewPages("")
+	require.NoError(t, pages.LoadLocales(locales))
+
+	page, err := pages.Load(filepath.Join(dir, "403.html"))
+	require.NoError(t, err)
+
+	r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+	r.Header.Set("Accept-Language", "de")
+	tags.Set(TagCountry, "GB")
+
+	w := httptest.NewRecorder()
+	page.Render(w, r, http.StatusForbidden)
+
+	assert.Equal(t, "🇬🇧 United Kingdom / Vereinigtes Königreich", w.Body.String())
+}
+
+func TestPages_support_link_qr_code(t *testing.T) {
+	dir := t.TempDir()
+	writePageFile(t, dir, "403.html", `<a href="{{.SupportURL}}"><img src="{{qr_code .SupportURL}}"></a>`)
+
+	page, err := NewPages("https://example.com/support").Load(filepath.Join(dir, "403.html"))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	page.Render(w, httptest.NewRequest("GET", "/", nil), http.StatusForbidden)
+
+	assert.Contains(t, w.Body.String(), `<a href="https://example.com/support">`)
+	assert.Contains(t, w.Body.String(), `<img src="data:image/png;base64,`)
+}
+
+func TestPages_render_failure(t *testing.T) {
+	dir := t.TempDir()
+	writePageFile(t, dir, "502.html", `{{template "_missing.html" .}}`)
+
+	page, err := NewPages("").Load(filepath.Join(dir, "502.html"))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	page.Render(w, httptest.NewRequest("GET", "/", nil), http.StatusBadGateway)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "Bad Gateway", strings.TrimSpace(w.Body.String()))
+}
+
+func TestPages_LoadIfExists(t *testing.T) {
+	dir := t.TempDir()
+	writePageFile(t, dir, "invalid.html", `{{if}}`)
+
+	var pages *Pages
+	assert.Nil(t, pages.LoadIfExists(""))
+	assert.Nil(t, pages.LoadIfExists(filepath.Join(dir, "missing.html")))
+	assert.Nil(t, pages.LoadIfExists(filepath.Join(dir, "invalid.html")))
+}
+
+// Helpers
+
+func writePageFile(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
@@ -0,0 +1,412 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrPolicyChanged     = errors.New("the policy has changed since it was read")
+	ErrPolicyNeedsGeoIP2 = errors.New("its rules need GeoIP2, which is only enabled when starting")
+)
+
+// Policy is the part of the configuration that decides which requests are let
+// through: the countries to allow, block and challenge, rate limits, risk scores, body
+// rules and blocklists. It's what replicas take from their primary.
+type Policy struct {
+	AllowCountries          []string
+	BlockCountries          []string
+	ChallengeCountries      []string
+	CountryRateLimits       map[string]RateLimit
+	DefaultCountryRateLimit RateLimit
+	ClientRateLimit         RateLimit
+	RateLimitExemptCIDRs    []*net.IPNet
+	RiskScores              RiskScores
+	RiskThresholds          RiskThresholds
+	BodyRules               BodyRules
+	Blocklists              map[string][]*net.IPNet
+	Geofences               Geofences
+}
+
+// policyDocument is how a policy is written as JSON, with each rule in the
+// same form as its environment variable.
+type policyDocument struct {
+	AllowCountries          []string            `json:"allow_countries"`
+	BlockCountries          []string            `json:"block_countries"`
+	ChallengeCountries      []string            `json:"challenge_countries"`
+	CountryRateLimits       map[string]string   `json:"country_rate_limits"`
+	DefaultCountryRateLimit string              `json:"default_country_rate_limit"`
+	ClientRateLimit         string              `json:"client_rate_limit"`
+	RateLimitExemptCIDRs    []string            `json:"rate_limit_exempt_cidrs"`
+	RiskScores              []string            `json:"risk_scores"`
+	RiskTagScore            int                 `json:"risk_tag_score"`
+	RiskBlockScore          int                 `json:"risk_block_score"`
+	BodyRules               []string            `json:"body_rules"`
+	Blocklists              map[string][]string `json:"blocklists"`
+	Geofences               []string            `json:"geofences"`
+}
+
+func PolicyFromConfig(c *Config) Policy {
+	return Policy{
+		AllowCountries:          c.AllowCountries,
+		BlockCountries:          c.BlockCountries,
+		ChallengeCountries:      c.ChallengeCountries,
+		CountryRateLimits:       c.CountryRateLimits,
+		DefaultCountryRateLimit: c.DefaultCountryRateLimit,
+		ClientRateLimit:         c.ClientRateLimit,
+		RateLimitExemptCIDRs:    c.RateLimitExemptCIDRs,
+		RiskScores:              c.RiskScores,
+		RiskThresholds:          c.RiskThresholds,
+		BodyRules:               c.BodyRules,
+		Geofences:               c.Geofences,
+	}
+}
+
+// NeedsGeoIP2 reports whether any of the policy's rules depend on knowing the
+// client's location.
+func (p Policy) NeedsGeoIP2() bool {
+	return len(p.AllowCountries) > 0 || len(p.BlockCountries) > 0 || len(p.ChallengeCountries) > 0 ||
+		len(p.CountryRateLimits) > 0 || p.DefaultCountryRateLimit.Enabled() ||
+		p.RiskScores.Uses(TagCountry) || p.BodyRules.UsesCountries() || len(p.Geofences) > 0
+}
+
+func (p Policy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newPolicyDocument(p))
+}
+
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	var doc policyDocument
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+
+	policy, err := doc.policy()
+	if err != nil {
+		return err
+	}
+
+	*p = policy
+	return nil
+}
+
+func newPolicyDocument(p Policy) policyDocument {
+	doc := policyDocument{
+		AllowCountries:          append([]string{}, p.AllowCountries...),
+		BlockCountries:          append([]string{}, p.BlockCountries...),
+		ChallengeCountries:      append([]string{}, p.ChallengeCountries...),
+		CountryRateLimits:       map[string]string{},
+		DefaultCountryRateLimit: stateRateLimit(p.DefaultCountryRateLimit),
+		ClientRateLimit:         stateRateLimit(p.ClientRateLimit),
+		RateLimitExemptCIDRs:    []string{},
+		RiskScores:              []string{},
+		RiskTagScore:            p.RiskThresholds.Tag,
+		RiskBlockScore:          p.RiskThresholds.Block,
+		BodyRules:               []string{},
+		Blocklists:              map[string][]string{},
+		Geofences:               []string{},
+	}
+
+	for country, limit := range p.CountryRateLimits {
+		doc.CountryRateLimits[country] = limit.String()
+	}
+	for _, cidr := range p.RateLimitExemptCIDRs {
+		doc.RateLimitExemptCIDRs = append(doc.RateLimitExemptCIDRs, cidr.String())
+	}
+	for _, score := range p.RiskScores {
+		doc.RiskScores = append(doc.RiskScores, score.String())
+	}
+	for _, rule := range p.BodyRules {
+		doc.BodyRules = append(doc.BodyRules, rule.String())
+	}
+	for _, fence := range p.Geofences {
+		doc.Geofences = append(doc.Geofences, fence.String())
+	}
+	for name, networks := range p.Blocklists {
+		doc.Blocklists[name] = []string{}
+		for _, network := range networks {
+			doc.Blocklists[name] = append(doc.Blocklists[name], network.String())
+		}
+	}
+
+	return doc
+}
+
+// policy reads the rules of the document, checking each of them.
+func (doc policyDocument) policy() (Policy, error) {
+	var err error
+
+	policy := Policy{
+		AllowCountries:     doc.AllowCountries,
+		BlockCountries:     doc.BlockCountries,
+		ChallengeCountries: doc.ChallengeCountries,
+		CountryRateLimits:  map[string]RateLimit{},
+		RiskThresholds:     RiskThresholds{Tag: doc.RiskTagScore, Block: doc.RiskBlockScore},
+	}
+
+	if len(policy.AllowCountries) > 0 && len(policy.BlockCountries) > 0 {
+		return Policy{}, errors.New("only one of allow or block countries can be set, not both")
+	}
+
+	err = CheckChallengeCountries(policy.ChallengeCountries, policy.AllowCountries, policy.BlockCountries)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid challenge countries: %w", err)
+	}
+
+	for country, value := range doc.CountryRateLimits {
+		policy.CountryRateLimits[strings.ToUpper(country)], err = ParseRateLimit(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid country rate limit %q: %w", country, err)
+		}
+	}
+
+	policy.DefaultCountryRateLimit, err = parseOptionalRateLimit(doc.DefaultCountryRateLimit)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid default country rate limit: %w", err)
+	}
+
+	policy.ClientRateLimit, err = parseOptionalRateLimit(doc.ClientRateLimit)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid client rate limit: %w", err)
+	}
+
+	policy.RateLimitExemptCIDRs, err = ParseCIDRs(doc.RateLimitExemptCIDRs)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	for _, value := range doc.RiskScores {
+		score, err := ParseRiskScore(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid risk score %q: %w", value, err)
+		}
+		policy.RiskScores = append(policy.RiskScores, score)
+	}
+
+	for _, value := range doc.BodyRules {
+		rule, err := ParseBodyRule(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid body rule %q: %w", value, err)
+		}
+		policy.BodyRules = append(policy.BodyRules, rule)
+	}
+
+	for _, value := range doc.Geofences {
+		fence, err := ParseGeofence(value)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid geofence %q: %w", value, err)
+		}
+		policy.Geofences = append(policy.Geofences, fence)
+	}
+
+	for name, values := range doc.Blocklists {
+		networks, err := ParseCIDRs(values)
+		if err != nil {
+			return Policy{}, fmt.Errorf("invalid blocklist %q: %w", name, err)
+		}
+		if policy.Blocklists == nil {
+			policy.Blocklists = map[string][]*net.IPNet{}
+		}
+		policy.Blocklists[name] = networks
+	}
+
+	return policy, nil
+}
+
+// PolicySource holds the policy in effect, and lets those interested wait for
+// it to change. Each policy is identified by an ETag derived from its content,
+// so that the same policy always has the same ETag, wherever it's held.
+type PolicySource struct {
+	sync.Mutex
+	policy  Policy
+	etag    string
+	changed chan struct{}
+}
+
+func NewPolicySource(policy Policy) *PolicySource {
+	return &PolicySource{
+		policy:  policy,
+		etag:    policyETag(policy),
+		changed: make(chan struct{}),
+	}
+}
+
+// Current returns the policy in effect, and its ETag.
+func (s *PolicySource) Current() (Policy, string) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.policy, s.etag
+}
+
+// Set puts a policy into effect, and reports whether it differs from the
+// previous one.
+func (s *PolicySource) Set(policy Policy) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.set(policy)
+}
+
+// Update puts into effect the policy returned by the function, given the
+// policy in effect, and reports whether it differs. The function mustn't
+// modify the policy it's given, which others may be using.
+func (s *PolicySource) Update(update func(Policy) Policy) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.set(update(s.policy))
+}
+
+// Apply puts a policy given through the admin APIs into effect, keeping the
+// blocklists in effect, which come from the feeds. Given an ETag, the policy
+// is only changed while the one in effect still has it, returning
+// ErrPolicyChanged otherwise. Either way, the policy in effect is returned.
+func (s *PolicySource) Apply(policy Policy, ifMatch string) (Policy, string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if ifMatch != "" && ifMatch != s.etag {
+		return s.policy, s.etag, ErrPolicyChanged
+	}
+
+	policy.Blocklists = s.policy.Blocklists
+	s.set(policy)
+
+	return s.policy, s.etag, nil
+}
+
+// Wait returns the policy in effect once its ETag is different to the given
+// one, or the current policy when the context ends first.
+func (s *PolicySource) Wait(ctx context.Context, etag string) (Policy, string) {
+	for {
+		s.Lock()
+		policy, current, changed := s.policy, s.etag, s.changed
+		s.Unlock()
+
+		if current != etag {
+			return policy, current
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return policy, current
+		}
+	}
+}
+
+type policyHandlerBuild struct {
+	etag    string
+	handler http.Handler
+}
+
+// PolicyHandler serves requests with a handler built for the policy in effect,
+// building a new one whenever the policy changes. Requests that are underway
+// finish with the handler they started with.
+type PolicyHandler struct {
+	sync.Mutex
+	source  *PolicySource
+	build   func(Policy) http.Handler
+	current atomic.Pointer[policyHandlerBuild]
+}
+
+func NewPolicyHandler(source *PolicySource, build func(Policy) http.Handler) *PolicyHandler {
+	return &PolicyHandler{
+		source: source,
+		build:  build,
+	}
+}
+
+func (h *PolicyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler().ServeHTTP(w, r)
+}
+
+// Rebuild replaces the function that builds handlers, for when something
+// other than the policy has changed. The next request builds a new handler,
+// while requests underway finish with the one they started with.
+func (h *PolicyHandler) Rebuild(build func(Policy) http.Handler) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.build = build
+	h.current.Store(nil)
+}
+
+// Private
+
+// set puts a policy into effect, with the source already locked.
+func (s *PolicySource) set(policy Policy) bool {
+	etag := policyETag(policy)
+	if etag == s.etag {
+		return false
+	}
+
+	s.policy = policy
+	s.etag = etag
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	return true
+}
+
+func (h *PolicyHandler) handler() http.Handler {
+	policy, etag := h.source.Current()
+
+	current := h.current.Load()
+	if current != nil && current.etag == etag {
+		return current.handler
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	// Another request may have built it while we waited
+	current = h.current.Load()
+	if current == nil || current.etag != etag {
+		current = &policyHandlerBuild{etag: etag, handler: h.build(policy)}
+		h.current.Store(current)
+	}
+
+	return current.handler
+}
+
+// withPolicy returns a copy of the options that applies the given policy.
+func (o HandlerOptions) withPolicy(policy Policy) HandlerOptions {
+	o.allowCountries = policy.AllowCountries
+	o.blockCountries = policy.BlockCountries
+	o.challengeCountries = policy.ChallengeCountries
+	o.countryRateLimits = policy.CountryRateLimits
+	o.defaultCountryRateLimit = policy.DefaultCountryRateLimit
+	o.clientRateLimit = policy.ClientRateLimit
+	o.rateLimitExemptCIDRs = policy.RateLimitExemptCIDRs
+	o.riskScores = policy.RiskScores
+	o.riskThresholds = policy.RiskThresholds
+	o.bodyRules = policy.BodyRules
+	o.blocklists = policy.Blocklists
+	o.geofences = policy.Geofences
+	return o
+}
+
+func policyETag(policy Policy) string {
+	data, _ := json.Marshal(policy)
+	digest := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(digest[:])[:16] + `"`
+}
+
+func parseOptionalRateLimit(value string) (RateLimit, error) {
+	if value == "" {
+		return RateLimit{}, nil
+	}
+	return ParseRateLimit(value)
+}
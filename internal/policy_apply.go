@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const applyPolicyTimeout = 30 * time.Second
+
+var ErrAdminAddressNotSet = errors.New("ADMIN_ADDRESS is not set; give the admin server's address with -address")
+
+// RunApplyCommand puts the policy in a file, or on stdin when the file is
+// `-`, into effect on a running proxy, through its admin API. The admin
+// address and token come from the configuration, as for the proxy itself.
+func RunApplyCommand(args []string) int {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	address := flags.String("address", "", "address of the admin server, instead of ADMIN_ADDRESS")
+	ifMatch := flags.String("if-match", "", "only apply while the policy in effect has this ETag")
+	configFile := flags.String("config", "", "config file to read, instead of CONFIG_FILE")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 1
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: thrust apply [-config file] [-address host:port] [-if-match etag] <policy.json|->")
+		return 1
+	}
+
+	config, err := newConfig(*configFile, "", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+	if *address == "" {
+		*address = config.AdminAddress
+	}
+
+	document, err := readPolicyDocument(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), applyPolicyTimeout)
+	defer cancel()
+
+	etag, err := ApplyPolicy(ctx, *address, config.AdminToken, *ifMatch, document)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Applied policy %s\n", etag)
+	return 0
+}
+
+// ApplyPolicy sends the policy document to the admin server at address,
+// returning the ETag of the policy in effect once it's been applied. The
+// document is checked before it's sent, so that mistakes are reported
+// without needing a round trip.
+func ApplyPolicy(ctx context.Context, address, token, ifMatch string, document []byte) (string, error) {
+	if address == "" {
+		return "", ErrAdminAddressNotSet
+	}
+
+	var policy Policy
+	err := json.Unmarshal(document, &policy)
+	if err != nil {
+		return "", fmt.Errorf("invalid policy: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://"+localAddress(address)+"/policy", bytes.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("admin server at %s: %s: %s", address, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp.Header.Get("Etag"), nil
+}
+
+// Private
+
+func readPolicyDocument(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+	_, etag := source.Current()
+
+	server := NewAdminServer("127.0.0.1:0", "s3cret", source)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	address := server.Addr().String()
+	document := []byte(`{"client_rate_limit":"10:20"}`)
+
+	_, err := ApplyPolicy(context.Background(), address, "wrong", "", document)
+	assert.ErrorContains(t, err, "401")
+
+	_, err = ApplyPolicy(context.Background(), address, "s3cret", "stale", document)
+	assert.ErrorContains(t, err, "The policy has changed since it was read")
+
+	applied, err := ApplyPolicy(context.Background(), address, "s3cret", etag, document)
+	require.NoError(t, err)
+
+	policy, current := source.Current()
+	assert.Equal(t, current, applied)
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 20}, policy.ClientRateLimit)
+}
+
+func TestApplyPolicy_checks_the_policy_before_sending_it(t *testing.T) {
+	_, err := ApplyPolicy(context.Background(), "127.0.0.1:1", "", "", []byte(`{"client_rate_limit":"fast"}`))
+	assert.ErrorIs(t, err, ErrInvalidRateLimit)
+
+	_, err = ApplyPolicy(context.Background(), "", "", "", []byte(`{}`))
+	assert.ErrorIs(t, err, ErrAdminAddressNotSet)
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	policyReplicaWait       = 30 * time.Second
+	policyReplicaMinBackoff = time.Second
+	policyReplicaMaxBackoff = 30 * time.Second
+)
+
+// PolicyReplica keeps a policy source in step with a primary's, by long-polling
+// the primary's admin server for changes. Changes on the primary take effect
+// as soon as the poll returns.
+//
+// Until it first hears from the primary, the replica keeps the policy it was
+// started with. If the primary becomes unreachable, the replica keeps the last
+// policy it received, and retries with increasing delays.
+type PolicyReplica struct {
+	primary  *url.URL
+	token    string
+	policies *PolicySource
+	client   *http.Client
+	wait     time.Duration
+
+	// The ETag of the primary's policy, when we've heard from it
+	etag string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPolicyReplica(primary *url.URL, token string, policies *PolicySource) *PolicyReplica {
+	return &PolicyReplica{
+		primary:  primary,
+		token:    token,
+		policies: policies,
+		client:   &http.Client{Timeout: policyReplicaWait + 10*time.Second},
+		wait:     policyReplicaWait,
+	}
+}
+
+// Start begins following the primary in the background.
+func (r *PolicyReplica) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	slog.Info("Following primary for policy", "primary", r.primary.Redacted())
+
+	r.wg.Add(1)
+	go r.follow(ctx)
+}
+
+func (r *PolicyReplica) Stop() error {
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
+
+// Private
+
+func (r *PolicyReplica) follow(ctx context.Context) {
+	defer r.wg.Done()
+
+	backoff := policyReplicaMinBackoff
+
+	for ctx.Err() == nil {
+		err := r.poll(ctx)
+		if err == nil {
+			backoff = policyReplicaMinBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("Unable to fetch policy from primary", "primary", r.primary.Redacted(), "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, policyReplicaMaxBackoff)
+	}
+}
+
+// poll waits for the primary's policy to differ from ours, and puts it into
+// effect when it does.
+func (r *PolicyReplica) poll(ctx context.Context) error {
+	etag := r.etag
+	if etag == "" {
+		_, etag = r.policies.Current()
+	}
+
+	endpoint := r.primary.JoinPath("policy")
+	endpoint.RawQuery = url.Values{"wait": {strconv.Itoa(int(r.wait / time.Second))}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("If-None-Match", etag)
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	var policy Policy
+	err = json.NewDecoder(resp.Body).Decode(&policy)
+	if err != nil {
+		return fmt.Errorf("invalid policy: %w", err)
+	}
+
+	r.etag = resp.Header.Get("Etag")
+	if r.policies.Set(policy) {
+		slog.Info("Updated policy from primary", "primary", r.primary.Redacted(), "etag", r.etag)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyReplica_follows_primary(t *testing.T) {
+	primaryPolicies := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+	primary := NewAdminServer("127.0.0.1:0", "s3cret", primaryPolicies)
+	require.NoError(t, primary.Start())
+	defer primary.Stop()
+
+	primaryURL, _ := url.Parse("http://" + primary.Addr().String())
+	policies := NewPolicySource(Policy{})
+
+	replica := NewPolicyReplica(primaryURL, "s3cret", policies)
+	replica.wait = time.Second
+	replica.Start()
+	defer replica.Stop()
+
+	blockedCountries := func() []string {
+		policy, _ := policies.Current()
+		return policy.BlockCountries
+	}
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"CN"}, blockedCountries())
+	}, time.Second, 10*time.Millisecond)
+
+	primaryPolicies.Set(Policy{BlockCountries: []string{"RU"}})
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"RU"}, blockedCountries())
+	}, time.Second, 10*time.Millisecond)
+
+	_, primaryETag := primaryPolicies.Current()
+	_, etag := policies.Current()
+	assert.Equal(t, primaryETag, etag)
+}
+
+func TestPolicyReplica_keeps_policy_when_primary_fails(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+
+	primaryURL, _ := url.Parse(primary.URL)
+	policies := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+	_, etag := policies.Current()
+
+	replica := NewPolicyReplica(primaryURL, "", policies)
+	assert.Error(t, replica.poll(t.Context()))
+
+	_, current := policies.Current()
+	assert.Equal(t, etag, current)
+}
+
+func TestPolicyReplica_rejects_invalid_policy(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"risk_scores": ["nonsense"]}`))
+	}))
+	defer primary.Close()
+
+	primaryURL, _ := url.Parse(primary.URL)
+	policies := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+
+	replica := NewPolicyReplica(primaryURL, "", policies)
+	assert.ErrorContains(t, replica.poll(t.Context()), "invalid policy")
+
+	policy, _ := policies.Current()
+	assert.Equal(t, []string{"CN"}, policy.BlockCountries)
+}
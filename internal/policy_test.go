@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPolicy(t *testing.T) Policy {
+	cidrs, err := ParseCIDRs([]string{"10.0.0.0/8", "192.0.2.1"})
+	require.NoError(t, err)
+
+	drop, err := ParseCIDRs([]string{"198.51.100.0/24"})
+	require.NoError(t, err)
+
+	return Policy{
+		BlockCountries:          []string{"CN", "RU"},
+		ChallengeCountries:      []string{"BR"},
+		CountryRateLimits:       map[string]RateLimit{"US": {Rate: 10, Burst: 20}},
+		DefaultCountryRateLimit: RateLimit{Rate: 5, Burst: 5},
+		RateLimitExemptCIDRs:    cidrs,
+		RiskScores:              RiskScores{{Signal: RiskSignalUserAgent, Value: "curl", HasValue: true, Weight: 30}},
+		RiskThresholds:          RiskThresholds{Tag: 25, Block: 100},
+		BodyRules:               BodyRules{{Path: "/signup", Countries: []string{"RU"}, Field: "email", Patterns: []string{"*@example.com"}}},
+		Blocklists:              map[string][]*net.IPNet{"drop": drop},
+		Geofences:               Geofences{{Path: "/live/*", Action: GeofenceAllow, Area: GeoCircle{Latitude: 51.5074, Longitude: -0.1278, RadiusKm: 50}}},
+	}
+}
+
+func TestPolicy_json_round_trip(t *testing.T) {
+	policy := testPolicy(t)
+
+	data, err := json.Marshal(policy)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"allow_countries": [],
+		"block_countries": ["CN", "RU"],
+		"challenge_countries": ["BR"],
+		"country_rate_limits": {"US": "10:20"},
+		"default_country_rate_limit": "5:5",
+		"client_rate_limit": "",
+		"rate_limit_exempt_cidrs": ["10.0.0.0/8", "192.0.2.1/32"],
+		"risk_scores": ["user_agent:curl=30"],
+		"risk_tag_score": 25,
+		"risk_block_score": 100,
+		"body_rules": ["/signup@RU:email=*@example.com"],
+		"blocklists": {"drop": ["198.51.100.0/24"]},
+		"geofences": ["/live/*:allow 51.5074 -0.1278 50km"]
+	}`, string(data))
+
+	var decoded Policy
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	assert.Equal(t, policy.BlockCountries, decoded.BlockCountries)
+	assert.Equal(t, policy.ChallengeCountries, decoded.ChallengeCountries)
+	assert.Equal(t, policy.CountryRateLimits, decoded.CountryRateLimits)
+	assert.Equal(t, policy.DefaultCountryRateLimit, decoded.DefaultCountryRateLimit)
+	assert.False(t, decoded.ClientRateLimit.Enabled())
+	assert.Equal(t, policy.RiskScores, decoded.RiskScores)
+	assert.Equal(t, policy.RiskThresholds, decoded.RiskThresholds)
+	assert.Equal(t, policy.BodyRules, decoded.BodyRules)
+	assert.Equal(t, policy.Blocklists, decoded.Blocklists)
+	assert.Equal(t, policy.Geofences, decoded.Geofences)
+	assert.Equal(t, policyETag(policy), policyETag(decoded))
+}
+
+func TestPolicy_invalid_json(t *testing.T) {
+	for _, data := range []string{
+		`{"country_rate_limits": {"US": "fast"}}`,
+		`{"client_rate_limit": "0"}`,
+		`{"rate_limit_exempt_cidrs": ["not a cidr"]}`,
+		`{"risk_scores": ["country:CN"]}`,
+		`{"body_rules": ["/signup:email"]}`,
+		`{"allow_countries": ["GB"], "block_countries": ["CN"]}`,
+		`{"block_countries": ["CN"], "challenge_countries": ["cn"]}`,
+		`{"blocklists": {"drop": ["not a cidr"]}}`,
+		`{"geofences": ["allow 91 0 50km"]}`,
+	} {
+		var policy Policy
+		assert.Error(t, json.Unmarshal([]byte(data), &policy), data)
+	}
+}
+
+func TestPolicySource_set_and_wait(t *testing.T) {
+	source := NewPolicySource(Policy{})
+	_, initial := source.Current()
+
+	assert.False(t, source.Set(Policy{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, etag := source.Wait(ctx, initial)
+	assert.Equal(t, initial, etag)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		source.Set(Policy{BlockCountries: []string{"CN"}})
+	}()
+
+	policy, etag := source.Wait(context.Background(), initial)
+	assert.NotEqual(t, initial, etag)
+	assert.Equal(t, []string{"CN"}, policy.BlockCountries)
+}
+
+func TestPolicySource_update(t *testing.T) {
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+
+	changed := source.Update(func(policy Policy) Policy {
+		policy.BlockCountries = append([]string{}, policy.BlockCountries...)
+		policy.BlockCountries = append(policy.BlockCountries, "RU")
+		return policy
+	})
+	assert.True(t, changed)
+
+	policy, _ := source.Current()
+	assert.Equal(t, []string{"CN", "RU"}, policy.BlockCountries)
+
+	assert.False(t, source.Update(func(policy Policy) Policy { return policy }))
+}
+
+func TestPolicyHandler_rebuilds_when_policy_changes(t *testing.T) {
+	source := NewPolicySource(Policy{})
+
+	builds := 0
+	handler := NewPolicyHandler(source, func(policy Policy) http.Handler {
+		builds++
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(policy.BlockCountries[0]))
+		})
+	})
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+
+	source.Set(Policy{BlockCountries: []string{"CN"}})
+	assert.Equal(t, "CN", get())
+	assert.Equal(t, "CN", get())
+	assert.Equal(t, 1, builds)
+
+	source.Set(Policy{BlockCountries: []string{"RU"}})
+	assert.Equal(t, "RU", get())
+	assert.Equal(t, 2, builds)
+}
+
+func TestPolicyHandler_Rebuild(t *testing.T) {
+	source := NewPolicySource(Policy{BlockCountries: []string{"CN"}})
+
+	build := func(prefix string) func(Policy) http.Handler {
+		return func(policy Policy) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(prefix + policy.BlockCountries[0]))
+			})
+		}
+	}
+	handler := NewPolicyHandler(source, build("before:"))
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "before:CN", get())
+
+	handler.Rebuild(build("after:"))
+	assert.Equal(t, "after:CN", get())
+
+	source.Set(Policy{BlockCountries: []string{"RU"}})
+	assert.Equal(t, "after:RU", get())
+}
+
+func TestPolicyHandler_applies_policy_to_handler_chain(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	source := NewPolicySource(Policy{})
+	options := handlerOptions(upstream.URL)
+	handler := NewPolicyHandler(source, func(policy Policy) http.Handler {
+		return NewHandler(options.withPolicy(policy))
+	})
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", "curl/8.0")
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get())
+
+	source.Set(Policy{
+		RiskScores:     RiskScores{{Signal: RiskSignalUserAgent, Value: "curl", HasValue: true, Weight: 100}},
+		RiskThresholds: RiskThresholds{Block: 100},
+	})
+	assert.Equal(t, http.StatusForbidden, get())
+}
+
+func TestPolicyHandler_keeps_state_across_policy_changes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	stats := NewUpstreamStats(time.Minute)
+	options := handlerOptions(upstream.URL)
+	options.upstreamTransport = NewUpstreamTransport(options.upstreams, TargetProtocolHTTP1, UpstreamTimeouts{}, nil, stats)
+	options.upstreamStats = stats
+
+	source := NewPolicySource(Policy{ClientRateLimit: RateLimit{Rate: 0.01, Burst: 2}})
+	handler := NewPolicyHandler(source, func(policy Policy) http.Handler {
+		return NewHandler(options.withPolicy(policy))
+	})
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get())
+
+	source.Set(Policy{ClientRateLimit: RateLimit{Rate: 0.01, Burst: 2}, BlockCountries: []string{"CN"}})
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, http.StatusTooManyRequests, get(), "the client's allowance isn't refilled by the change")
+
+	assert.Equal(t, uint64(1), stats.Snapshot().Opened, "connections to the upstream are reused")
+}
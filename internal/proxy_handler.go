@@ -1,67 +1,229 @@
 package internal
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
-	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
-func NewProxyHandler(targetUrl *url.URL, badGatewayPage string, forwardHeaders bool) http.Handler {
-	return &httputil.ReverseProxy{
+type TargetProtocol string
+
+const (
+	TargetProtocolHTTP1 TargetProtocol = "http1"
+	TargetProtocolH2    TargetProtocol = "h2"
+	TargetProtocolH2C   TargetProtocol = "h2c"
+)
+
+var ErrInvalidTargetProtocol = errors.New("target protocol must be one of http1, h2, or h2c")
+
+func ParseTargetProtocol(value string) (TargetProtocol, error) {
+	protocol := TargetProtocol(strings.ToLower(strings.TrimSpace(value)))
+
+	switch protocol {
+	case TargetProtocolHTTP1, TargetProtocolH2, TargetProtocolH2C:
+		return protocol, nil
+	case "":
+		return TargetProtocolHTTP1, nil
+	default:
+		return "", ErrInvalidTargetProtocol
+	}
+}
+
+// Scheme is the URL scheme used to reach an upstream speaking the protocol.
+func (p TargetProtocol) Scheme() string {
+	if p == TargetProtocolH2 {
+		return "https"
+	}
+	return "http"
+}
+
+type upstreamKey struct{}
+
+type circuitOutcomeKey struct{}
+
+// circuitOutcome reports how a request to an upstream went to the upstream's
+// circuit breaker. Only the first report counts, so that one made when the
+// request is over can't be confused with another request's probe.
+type circuitOutcome struct {
+	breaker *CircuitBreaker
+	once    sync.Once
+}
+
+func (o *circuitOutcome) report(result func(*CircuitBreaker)) {
+	if o != nil {
+		o.once.Do(func() { result(o.breaker) })
+	}
+}
+
+func circuitOutcomeFromContext(ctx context.Context) *circuitOutcome {
+	outcome, _ := ctx.Value(circuitOutcomeKey{}).(*circuitOutcome)
+	return outcome
+}
+
+// ProxyHandler forwards requests to one of the upstreams in its pool.
+type ProxyHandler struct {
+	upstreams    *UpstreamPool
+	proxy        *httputil.ReverseProxy
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error)
+	host         string
+	transport    *http.Transport
+	stats        *UpstreamStats
+	forwarded    bool
+	proxies      *TrustedProxies
+	sticky       *StickySessions
+}
+
+func NewProxyHandler(upstreams *UpstreamPool, transport *http.Transport, retry RetryPolicy, badGatewayPage string, pages *Pages, forwardHeaders bool) *ProxyHandler {
+	h := &ProxyHandler{
+		upstreams:    upstreams,
+		errorHandler: ProxyErrorHandler(pages, badGatewayPage),
+		transport:    transport,
+	}
+
+	h.proxy = &httputil.ReverseProxy{
 		Rewrite: func(r *httputil.ProxyRequest) {
-			r.SetURL(targetUrl)
+			upstream := r.In.Context().Value(upstreamKey{}).(*Upstream)
+			r.SetURL(upstream.target)
 			r.Out.Host = r.In.Host
-			setXForwarded(r, forwardHeaders)
+			if h.host != "" {
+				r.Out.Host = h.host
+			}
+			setXForwarded(r, h.proxies, forwardHeaders, h.forwarded)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			outcome := circuitOutcomeFromContext(resp.Request.Context())
+			if resp.StatusCode >= 500 {
+				outcome.report((*CircuitBreaker).Failure)
+				RequestTagsFromContext(resp.Request.Context()).Set(TagUpstreamError, string(UpstreamErrorServer))
+			} else {
+				outcome.report((*CircuitBreaker).Success)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			outcome := circuitOutcomeFromContext(r.Context())
+			if errors.Is(err, context.Canceled) || isRequestEntityTooLarge(err) {
+				outcome.report((*CircuitBreaker).Abandon)
+			} else {
+				outcome.report((*CircuitBreaker).Failure)
+			}
+			h.errorHandler(w, r, err)
 		},
-		ErrorHandler: ProxyErrorHandler(badGatewayPage),
-		Transport:    createProxyTransport(),
 	}
+
+	h.proxy.Transport = newRetryTransport(retry, roundTripperFunc(h.roundTrip))
+
+	return h
+}
+
+// SetHost overrides the Host header sent upstream, which otherwise is the one
+// the client sent.
+func (h *ProxyHandler) SetHost(host string) {
+	h.host = host
+}
+
+// SetForwarded has an RFC 7239 Forwarded header sent upstream, alongside the
+// X-Forwarded- headers.
+func (h *ProxyHandler) SetForwarded(forwarded bool) {
+	h.forwarded = forwarded
 }
 
-func ProxyErrorHandler(badGatewayPage string) func(w http.ResponseWriter, r *http.Request, err error) {
-	content, err := os.ReadFile(badGatewayPage)
-	if err != nil {
+// SetTrustedProxies sets the proxies whose forwarding headers are carried on
+// to the upstream. Those from anyone else are replaced with our own.
+func (h *ProxyHandler) SetTrustedProxies(proxies *TrustedProxies) {
+	h.proxies = proxies
+}
+
+// SetStickySessions keeps clients on the same upstream from one request to
+// the next.
+func (h *ProxyHandler) SetStickySessions(sticky *StickySessions) {
+	h.sticky = sticky
+}
+
+// SetUpstreamStats counts the handler's reuse of connections to its
+// upstreams, and watches for the response bodies it fails to close. The
+// connections themselves are counted by the transport, when it's made by
+// NewUpstreamTransport with the same stats.
+func (h *ProxyHandler) SetUpstreamStats(stats *UpstreamStats) {
+	h.stats = stats
+}
+
+func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream := h.sticky.Acquire(h.upstreams, w, r)
+	defer h.upstreams.Release(upstream)
+
+	if !upstream.breaker.Allow() {
+		h.errorHandler(w, r, ErrCircuitOpen)
+		return
+	}
+
+	// A request that ends without an outcome, such as by a panic, mustn't
+	// leave a half-open circuit waiting forever on its probe
+	outcome := &circuitOutcome{breaker: upstream.breaker}
+	defer outcome.report((*CircuitBreaker).Abandon)
+
+	ctx := context.WithValue(r.Context(), upstreamKey{}, upstream)
+	ctx = context.WithValue(ctx, circuitOutcomeKey{}, outcome)
+	h.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (h *ProxyHandler) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := h.transport.RoundTrip(h.stats.trackRequest(req))
+	h.stats.trackResponse(resp)
+	return resp, err
+}
+
+// ProxyErrorHandler responds to requests that couldn't be proxied. The page
+// served depends on the class of error: for example, with a badGatewayPage of
+// `502.html`, a timeout is answered with `502-timeout.html` when that exists,
+// falling back to `502.html` when it doesn't. Pages are templates, rendered
+// as described for Pages.
+func ProxyErrorHandler(pages *Pages, badGatewayPage string) func(w http.ResponseWriter, r *http.Request, err error) {
+	page := pages.LoadIfExists(badGatewayPage)
+	if page == nil {
 		slog.Debug("No custom 502 page found", "path", badGatewayPage)
-		content = nil
 	}
 
-	return func(w http.ResponseWriter, r *http.Request, err error) {
-		slog.Info("Unable to proxy request", "path", r.URL.Path, "error", err)
+	classPages := map[UpstreamErrorClass]*PageTemplate{}
+	for _, class := range upstreamErrorClasses {
+		classPages[class] = page
+
+		path := upstreamErrorPagePath(badGatewayPage, class)
+		if classPage := pages.LoadIfExists(path); classPage != nil {
+			slog.Debug("Using custom error page", "class", class, "path", path)
+			classPages[class] = classPage
+		}
+	}
 
+	return func(w http.ResponseWriter, r *http.Request, err error) {
 		if isRequestEntityTooLarge(err) {
+			slog.InfoContext(r.Context(), "Unable to proxy request", "path", r.URL.Path, "error", err)
 			w.WriteHeader(http.StatusRequestEntityTooLarge)
 			return
 		}
 
-		if content != nil {
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusBadGateway)
-			w.Write(content)
+		class := ClassifyUpstreamError(err)
+		RequestTagsFromContext(r.Context()).Set(TagUpstreamError, string(class))
+		slog.InfoContext(r.Context(), "Unable to proxy request", "path", r.URL.Path, "class", class, "error", err)
+
+		page := classPages[class]
+		if page != nil {
+			page.Render(w, r, class.StatusCode())
 		} else {
-			w.WriteHeader(http.StatusBadGateway)
+			w.WriteHeader(class.StatusCode())
 		}
 	}
 }
 
-func setXForwarded(r *httputil.ProxyRequest, forwardHeaders bool) {
-	if forwardHeaders {
-		r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
-	}
-
-	r.SetXForwarded()
-
-	if forwardHeaders {
-		// Preserve original headers if we had them
-		if r.In.Header.Get("X-Forwarded-Host") != "" {
-			r.Out.Header.Set("X-Forwarded-Host", r.In.Header.Get("X-Forwarded-Host"))
-		}
-		if r.In.Header.Get("X-Forwarded-Proto") != "" {
-			r.Out.Header.Set("X-Forwarded-Proto", r.In.Header.Get("X-Forwarded-Proto"))
-		}
-	}
+func upstreamErrorPagePath(badGatewayPage string, class UpstreamErrorClass) string {
+	extension := filepath.Ext(badGatewayPage)
+	return strings.TrimSuffix(badGatewayPage, extension) + "-" + string(class) + extension
 }
 
 func isRequestEntityTooLarge(err error) bool {
@@ -69,7 +231,17 @@ func isRequestEntityTooLarge(err error) bool {
 	return errors.As(err, &maxBytesError)
 }
 
-func createProxyTransport() *http.Transport {
+// NewUpstreamTransport makes the transport that requests are sent to the
+// upstreams with, counting its connections in stats. One transport is shared
+// by every handler built for the service, so that connections to the
+// upstreams outlive changes to the policy.
+func NewUpstreamTransport(upstreams *UpstreamPool, targetProtocol TargetProtocol, timeouts UpstreamTimeouts, tlsConfig *tls.Config, stats *UpstreamStats) *http.Transport {
+	transport := createProxyTransport(targetProtocol, timeouts, tlsConfig, upstreams)
+	transport.DialContext = stats.trackDial(transport.DialContext)
+	return transport
+}
+
+func createProxyTransport(targetProtocol TargetProtocol, timeouts UpstreamTimeouts, tlsConfig *tls.Config, upstreams *UpstreamPool) *http.Transport {
 	// The default transport requests compressed responses even if the client
 	// didn't. If it receives a compressed response but the client wants
 	// uncompressed, the transport decompresses the response transparently.
@@ -90,6 +262,24 @@ func createProxyTransport() *http.Transport {
 
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.DisableCompression = true
+	transport.DialContext = upstreamDialer(timeouts, upstreams)
+	transport.ResponseHeaderTimeout = timeouts.Read
+
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	// gRPC and other streaming backends need HTTP/2 all the way through, so
+	// when the upstream speaks it we talk to it exclusively that way, rather
+	// than letting the transport settle on HTTP/1.1.
+	switch targetProtocol {
+	case TargetProtocolH2:
+		transport.Protocols = &http.Protocols{}
+		transport.Protocols.SetHTTP2(true)
+	case TargetProtocolH2C:
+		transport.Protocols = &http.Protocols{}
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
 
 	return transport
 }
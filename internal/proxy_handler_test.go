@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler_h2c_upstream(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("X-Upstream-Proto", r.Proto)
+		w.Write([]byte("ok"))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	upstream.Config.Protocols = &http.Protocols{}
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	defer upstream.Close()
+
+	targetUrl, _ := url.Parse(upstream.URL)
+	h := newTestProxyHandler(NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin), TargetProtocolH2C, UpstreamTimeouts{}, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", nil)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HTTP/2.0", w.Header().Get("X-Upstream-Proto"))
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Equal(t, "0", w.Result().Trailer.Get("Grpc-Status"))
+}
+
+func TestProxyHandler_h2_upstream(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Proto", r.Proto)
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	targetUrl, _ := url.Parse(upstream.URL)
+	h := newTestProxyHandler(NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin), TargetProtocolH2, UpstreamTimeouts{}, nil)
+
+	// Trust the test server's certificate
+	transport := h.transport
+	transport.TLSClientConfig = upstream.Client().Transport.(*http.Transport).TLSClientConfig
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HTTP/2.0", w.Header().Get("X-Upstream-Proto"))
+}
+
+func TestProxyHandler_http1_upstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Proto", r.Proto)
+	}))
+	defer upstream.Close()
+
+	targetUrl, _ := url.Parse(upstream.URL)
+	h := newTestProxyHandler(NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin), TargetProtocolHTTP1, UpstreamTimeouts{}, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, "HTTP/1.1", w.Header().Get("X-Upstream-Proto"))
+}
+
+func TestParseTargetProtocol(t *testing.T) {
+	for value, expected := range map[string]TargetProtocol{"": TargetProtocolHTTP1, "http1": TargetProtocolHTTP1, "H2": TargetProtocolH2, " h2c ": TargetProtocolH2C} {
+		protocol, err := ParseTargetProtocol(value)
+		require.NoError(t, err)
+		assert.Equal(t, expected, protocol)
+	}
+
+	_, err := ParseTargetProtocol("spdy")
+	assert.ErrorIs(t, err, ErrInvalidTargetProtocol)
+
+	assert.Equal(t, "https", TargetProtocolH2.Scheme())
+	assert.Equal(t, "http", TargetProtocolH2C.Scheme())
+}
+
+func TestProxyHandler_unix_socket_upstream(t *testing.T) {
+	dir, err := os.MkdirTemp("", "thruster")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "app.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Host", r.Host)
+		w.Write([]byte("hello from the socket"))
+	}))
+	upstream.Listener = listener
+	upstream.Start()
+	defer upstream.Close()
+
+	targets, err := ParseTargetURLs([]string{"unix://" + socket})
+	require.NoError(t, err)
+
+	h := newTestProxyHandler(NewUpstreamPool(targets, BalancingRoundRobin), TargetProtocolHTTP1, UpstreamTimeouts{}, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello from the socket", w.Body.String())
+	assert.Equal(t, "example.com", w.Header().Get("X-Upstream-Host"))
+
+	h.SetHost("app.internal")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+	assert.Equal(t, "app.internal", w.Header().Get("X-Upstream-Host"))
+}
+
+// Helpers
+
+func newTestProxyHandler(upstreams *UpstreamPool, targetProtocol TargetProtocol, timeouts UpstreamTimeouts, tlsConfig *tls.Config) *ProxyHandler {
+	return NewProxyHandler(upstreams, NewUpstreamTransport(upstreams, targetProtocol, timeouts, tlsConfig, nil), RetryPolicy{}, "", nil, true)
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimiterPruneInterval = time.Minute
+
+	// Approximate memory used by a bucket, excluding its key
+	tokenBucketSize = 64
+)
+
+var ErrInvalidRateLimit = errors.New("rate limit must be in the form rps[:burst]")
+
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// String formats the limit in the form that ParseRateLimit reads.
+func (l RateLimit) String() string {
+	return strconv.FormatFloat(l.Rate, 'f', -1, 64) + ":" + strconv.Itoa(l.Burst)
+}
+
+// ParseRateLimit parses a limit written as `rps[:burst]`. When the burst is
+// omitted it defaults to the rate, rounded up.
+func ParseRateLimit(value string) (RateLimit, error) {
+	rateValue, burstValue, hasBurst := strings.Cut(strings.TrimSpace(value), ":")
+
+	rate, err := strconv.ParseFloat(rateValue, 64)
+	if err != nil || rate <= 0 {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+
+	burst := int(math.Ceil(rate))
+	if hasBurst {
+		burst, err = strconv.Atoi(burstValue)
+		if err != nil || burst < 1 {
+			return RateLimit{}, ErrInvalidRateLimit
+		}
+	}
+
+	return RateLimit{Rate: rate, Burst: burst}, nil
+}
+
+type tokenBucket struct {
+	limit     RateLimit
+	tokens    float64
+	updatedAt time.Time
+}
+
+// RateLimiter is a set of token buckets, one per key. Buckets are created on
+// demand and dropped once they have refilled, so idle keys cost nothing.
+type RateLimiter struct {
+	sync.Mutex
+	buckets        map[string]*tokenBucket
+	budget         *MemoryBudget
+	prunedAt       time.Time
+	getCurrentTime GetCurrentTime
+}
+
+func NewRateLimiter(budget *MemoryBudget) *RateLimiter {
+	return &RateLimiter{
+		buckets:        map[string]*tokenBucket{},
+		budget:         budget,
+		getCurrentTime: time.Now,
+	}
+}
+
+// Allow takes a token from the bucket for key. When none are available it
+// returns false, along with how long the caller should wait before retrying.
+func (l *RateLimiter) Allow(key string, limit RateLimit) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.getCurrentTime()
+	l.pruneIfNeeded(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if !l.reserveBucket(key) {
+			// With no memory left to track the key we have to let it through
+			return true, 0
+		}
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updatedAt: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.limit = limit
+	bucket.refill(now)
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// Private
+
+func (l *RateLimiter) pruneIfNeeded(now time.Time) {
+	if now.Sub(l.prunedAt) < rateLimiterPruneInterval {
+		return
+	}
+
+	for key, bucket := range l.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= float64(bucket.limit.Burst) {
+			l.deleteBucket(key)
+		}
+	}
+
+	l.prunedAt = now
+}
+
+// reserveBucket accounts for a new bucket in the memory budget. When the
+// budget is exhausted we make room by dropping arbitrary existing buckets,
+// which at worst gives those clients a fresh allowance.
+func (l *RateLimiter) reserveBucket(key string) bool {
+	for !l.budget.Reserve(MemoryBudgetComponentRateLimiter, tokenBucketSize+len(key)) {
+		if len(l.buckets) == 0 {
+			slog.Debug("Rate limiter: memory budget exhausted", "key", key)
+			return false
+		}
+
+		for existing := range l.buckets {
+			l.deleteBucket(existing)
+			break
+		}
+	}
+
+	return true
+}
+
+func (l *RateLimiter) deleteBucket(key string) {
+	delete(l.buckets, key)
+	l.budget.Release(MemoryBudgetComponentRateLimiter, tokenBucketSize+len(key))
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.updatedAt = now
+	}
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_allows_up_to_burst(t *testing.T) {
+	l := NewRateLimiter(nil)
+	now := time.Date(2023, 1, 22, 17, 30, 0, 0, time.UTC)
+	l.getCurrentTime = func() time.Time { return now }
+
+	limit := RateLimit{Rate: 1, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := l.Allow("a", limit)
+		assert.True(t, allowed)
+	}
+
+	allowed, wait := l.Allow("a", limit)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+}
+
+func TestRateLimiter_refills_over_time(t *testing.T) {
+	l := NewRateLimiter(nil)
+	now := time.Date(2023, 1, 22, 17, 30, 0, 0, time.UTC)
+	l.getCurrentTime = func() time.Time { return now }
+
+	limit := RateLimit{Rate: 2, Burst: 1}
+
+	allowed, _ := l.Allow("a", limit)
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("a", limit)
+	assert.False(t, allowed)
+
+	l.getCurrentTime = func() time.Time { return now.Add(500 * time.Millisecond) }
+
+	allowed, _ = l.Allow("a", limit)
+	assert.True(t, allowed)
+}
+
+func TestRateLimiter_keys_are_independent(t *testing.T) {
+	l := NewRateLimiter(nil)
+	limit := RateLimit{Rate: 1, Burst: 1}
+
+	allowed, _ := l.Allow("a", limit)
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("b", limit)
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("a", limit)
+	assert.False(t, allowed)
+}
+
+func TestRateLimiter_prunes_refilled_buckets(t *testing.T) {
+	l := NewRateLimiter(nil)
+	now := time.Date(2023, 1, 22, 17, 30, 0, 0, time.UTC)
+	l.getCurrentTime = func() time.Time { return now }
+
+	l.Allow("a", RateLimit{Rate: 1, Burst: 5})
+	l.Allow("b", RateLimit{Rate: 1, Burst: 5})
+	assert.Len(t, l.buckets, 2)
+
+	l.getCurrentTime = func() time.Time { return now.Add(2 * rateLimiterPruneInterval) }
+	l.Allow("c", RateLimit{Rate: 1, Burst: 5})
+
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("5:10")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 5, Burst: 10}, limit)
+
+	limit, err = ParseRateLimit("0.5")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 0.5, Burst: 1}, limit)
+
+	for _, value := range []string{"", "abc", "0", "-1", "5:0", "5:x"} {
+		_, err = ParseRateLimit(value)
+		assert.ErrorIs(t, err, ErrInvalidRateLimit, value)
+	}
+}
+
+func TestRateLimiter_stays_within_memory_budget(t *testing.T) {
+	budget := NewMemoryBudget(3 * (tokenBucketSize + 1))
+	l := NewRateLimiter(budget)
+	limit := RateLimit{Rate: 1, Burst: 1}
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		allowed, _ := l.Allow(key, limit)
+		assert.True(t, allowed)
+	}
+
+	assert.Len(t, l.buckets, 3)
+	assert.Equal(t, 3*(tokenBucketSize+1), budget.Usage()[MemoryBudgetComponentRateLimiter])
+}
+
+func TestRateLimit_String(t *testing.T) {
+	limit, err := ParseRateLimit("0.25:3")
+	require.NoError(t, err)
+
+	assert.Equal(t, "0.25:3", limit.String())
+
+	parsed, err := ParseRateLimit(limit.String())
+	require.NoError(t, err)
+	assert.Equal(t, limit, parsed)
+}
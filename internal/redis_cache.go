@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A cache lookup is only worthwhile if it's quick, so rather than hold up
+// requests when Redis is struggling, we give up early and treat it as a miss.
+const redisCacheTimeout = 250 * time.Millisecond
+
+// RedisCache keeps items in Redis, so that several instances can share one
+// warm cache. Redis expires the items itself, and evicts them according to
+// its own memory policy, so unlike the other caches this has no capacity of
+// its own.
+type RedisCache struct {
+	client      *redis.Client
+	prefix      string
+	maxItemSize int
+}
+
+func NewRedisCache(url, prefix string, maxItemSize int) (*RedisCache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	options.MaxRetries = -1
+
+	return &RedisCache{
+		client:      redis.NewClient(options),
+		prefix:      prefix,
+		maxItemSize: maxItemSize,
+	}, nil
+}
+
+func (c *RedisCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
+	if len(value) > c.maxItemSize {
+		slog.Debug("Cache: item is too large to store", "len", len(value))
+		return
+	}
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisCacheTimeout)
+	defer cancel()
+
+	err := c.client.Set(ctx, c.redisKey(key), value, ttl).Err()
+	if err != nil {
+		slog.Error("Cache: unable to store item in Redis", "key", key, "error", err)
+		return
+	}
+
+	slog.Debug("Cache: added item", "key", key, "size", len(value), "expires_at", expiresAt)
+}
+
+// Get treats Redis being unavailable as a miss, so that requests are still
+// served, just without the benefit of the cache.
+func (c *RedisCache) Get(key CacheKey) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCacheTimeout)
+	defer cancel()
+
+	value, err := c.client.Get(ctx, c.redisKey(key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("Cache: unable to fetch item from Redis", "key", key, "error", err)
+		}
+		return nil, false
+	}
+
+	return value, true
+}
+
+// Ping checks that Redis is reachable.
+func (c *RedisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// Private
+
+func (c *RedisCache) redisKey(key CacheKey) string {
+	return fmt.Sprintf("%s%016x", c.prefix, uint64(key))
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCache_storing_and_retrieving(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	c, err := NewRedisCache("redis://"+server.Addr(), "test:", 1*KB)
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+
+	c.Set(1, []byte("hello world"), time.Now().Add(1*time.Hour))
+
+	value, ok := c.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("hello world"), value)
+	assert.True(t, server.Exists("test:0000000000000001"))
+}
+
+func TestRedisCache_items_expire(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	c, err := NewRedisCache("redis://"+server.Addr(), "test:", 1*KB)
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Set(1, []byte("hello world"), time.Now().Add(1*time.Minute))
+	c.Set(2, []byte("already expired"), time.Now().Add(-1*time.Second))
+
+	_, ok := c.Get(2)
+	assert.False(t, ok)
+
+	server.FastForward(2 * time.Minute)
+
+	_, ok = c.Get(1)
+	assert.False(t, ok)
+}
+
+func TestRedisCache_does_not_store_items_over_item_limit(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	c, err := NewRedisCache("redis://"+server.Addr(), "test:", 1*KB)
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Set(1, make([]byte, 2*KB), time.Now().Add(1*time.Hour))
+
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+}
+
+func TestRedisCache_treats_unavailable_server_as_miss(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	c, err := NewRedisCache("redis://"+server.Addr(), "test:", 1*KB)
+	require.NoError(t, err)
+	defer c.Close()
+
+	server.Close()
+
+	c.Set(1, []byte("hello world"), time.Now().Add(1*time.Hour))
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+}
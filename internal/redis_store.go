@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisStorePrefix  = "thruster:state:"
+	redisStoreTimeout = time.Second
+)
+
+// RedisStore keeps state in Redis, so that it's shared between instances.
+// Redis expires the items itself.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(url string) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisStore{
+		client: redis.NewClient(options),
+		prefix: redisStorePrefix,
+	}, nil
+}
+
+// Get treats Redis being unavailable as the item being missing.
+func (s *RedisStore) Get(bucket, key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisStoreTimeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, s.redisKey(bucket, key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			slog.Error("Store: unable to fetch item from Redis", "bucket", bucket, "key", key, "error", err)
+		}
+		return nil, false
+	}
+
+	return value, true
+}
+
+// List finds the bucket's keys with SCAN, so as not to hold up Redis when
+// there are a lot of them.
+func (s *RedisStore) List(bucket string) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisStoreTimeout)
+	defer cancel()
+
+	prefix := s.redisKey(bucket, "")
+	items := map[string][]byte{}
+
+	iter := s.client.Scan(ctx, 0, prefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		value, err := s.client.Get(ctx, iter.Val()).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items[strings.TrimPrefix(iter.Val(), prefix)] = value
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (s *RedisStore) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisStoreTimeout)
+	defer cancel()
+
+	return s.client.Set(ctx, s.redisKey(bucket, key), value, max(ttl, 0)).Err()
+}
+
+func (s *RedisStore) Delete(bucket, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisStoreTimeout)
+	defer cancel()
+
+	return s.client.Del(ctx, s.redisKey(bucket, key)).Err()
+}
+
+// Increment keeps counters as Redis integers, so they can be inspected and
+// changed with the usual Redis commands.
+func (s *RedisStore) Increment(bucket, key string, delta int64, ttl time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisStoreTimeout)
+	defer cancel()
+
+	redisKey := s.redisKey(bucket, key)
+
+	count, err := s.client.IncrBy(ctx, redisKey, delta).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// The counter was created by this increment, so its TTL starts now
+	if count == delta && ttl > 0 {
+		err = s.client.Expire(ctx, redisKey, ttl).Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+// Private
+
+func (s *RedisStore) redisKey(bucket, key string) string {
+	return s.prefix + bucket + ":" + key
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStore(t *testing.T) {
+	testStore(t, func(t *testing.T) (Store, func(time.Duration)) {
+		server := miniredis.RunT(t)
+
+		store, err := NewRedisStore("redis://" + server.Addr())
+		require.NoError(t, err)
+
+		return store, server.FastForward
+	})
+}
+
+func TestRedisStore_keys_are_prefixed_by_bucket(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	store, err := NewRedisStore("redis://" + server.Addr())
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, store.Set(StoreBucketBans, "1.2.3.4", []byte("banned"), time.Hour))
+	_, err = store.Increment(StoreBucketCounters, "hits", 5, 0)
+	require.NoError(t, err)
+
+	assert.True(t, server.Exists("thruster:state:bans:1.2.3.4"))
+
+	count, err := server.Get("thruster:state:counters:hits")
+	require.NoError(t, err)
+	assert.Equal(t, "5", count)
+}
+
+func TestRedisStore_unavailable_is_a_miss(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	store, err := NewRedisStore("redis://" + server.Addr())
+	require.NoError(t, err)
+	defer store.Close()
+
+	server.Close()
+
+	_, ok := store.Get(StoreBucketBans, "key")
+	assert.False(t, ok)
+	assert.Error(t, store.Set(StoreBucketBans, "key", []byte("banned"), 0))
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+var ErrReloadNeedsGeoIP2 = errors.New("reloaded rules need GeoIP2 databases, which are only opened when starting; restart to use them")
+
+// reload reads the configuration again, from the config file and the
+// environment, and puts into effect the parts that can change while we're
+// running: the policy, the pages, the feature header, header, cache and
+// maintenance rules, and the list of upstreams. Handlers are rebuilt for the
+// requests that follow, while the listeners, and the requests already
+// underway, carry on undisturbed. Other changes are logged as needing a
+// restart.
+//
+// When the configuration can't be read, or has rules that need GeoIP2 when
+// no databases were opened at startup, the one in effect is kept.
+func (s *Service) reload() {
+	slog.Info("Reloading configuration", "config_file", s.config.ConfigFile)
+
+	err := s.applyReload()
+	if err != nil {
+		slog.Error("Unable to reload configuration; keeping the current one", "error", err)
+	}
+}
+
+func (s *Service) applyReload() error {
+	next, err := newConfig(s.config.ConfigFile, s.config.UpstreamCommand, s.config.UpstreamArgs)
+	if err != nil {
+		return err
+	}
+
+	config := reloadedConfig(s.config, next)
+	if next.GeoIP2Enabled && !config.GeoIP2Enabled {
+		return ErrReloadNeedsGeoIP2
+	}
+	for _, change := range DiffStates(StateFromConfig(config), StateFromConfig(next)) {
+		slog.Warn("Configuration change needs a restart to take effect", "kind", change.Kind, "key", change.Key)
+	}
+	s.config = config
+
+	s.upstreams.SetTargets(s.targetUrls())
+
+	// A replica's policy comes from its primary
+	if config.ReplicaOf == nil {
+		s.policies.Update(func(current Policy) Policy {
+			policy := PolicyFromConfig(config)
+			policy.Blocklists = current.Blocklists
+			return policy
+		})
+	}
+
+	s.options.blockedPage = config.BlockedPage
+	s.options.badGatewayPage = config.BadGatewayPage
+	s.options.maintenancePage = config.MaintenancePage
+	s.options.featureHeaders = config.FeatureHeaders
+	s.options.headerRules = config.HeaderRules
+	s.options.cachePolicy = s.cachePolicy()
+	s.options.maintenanceWindows = config.MaintenanceWindows
+	s.options.maintenanceAllowCIDRs = config.MaintenanceAllowCIDRs
+
+	// Pages are read as handlers are built, so this picks up changes to
+	// their contents too
+	s.handler.Rebuild(buildHandler(s.options))
+
+	s.recordConfigChanges()
+	return nil
+}
+
+// reloadedConfig is a copy of the current config, with the settings that
+// reload puts into effect taken from the next.
+func reloadedConfig(current, next *Config) *Config {
+	config := *current
+
+	config.TargetURLs = next.TargetURLs
+
+	config.AllowCountries = next.AllowCountries
+	config.BlockCountries = next.BlockCountries
+	config.ChallengeCountries = next.ChallengeCountries
+	config.CountryRateLimits = next.CountryRateLimits
+	config.DefaultCountryRateLimit = next.DefaultCountryRateLimit
+	config.ClientRateLimit = next.ClientRateLimit
+	config.RateLimitExemptCIDRs = next.RateLimitExemptCIDRs
+	config.RiskScores = next.RiskScores
+	config.RiskThresholds = next.RiskThresholds
+	config.BodyRules = next.BodyRules
+	config.Geofences = next.Geofences
+
+	config.BlockedPage = next.BlockedPage
+	config.BadGatewayPage = next.BadGatewayPage
+	config.MaintenancePage = next.MaintenancePage
+
+	config.FeatureHeaders = next.FeatureHeaders
+	config.HeaderRules = next.HeaderRules
+	config.HeaderRulesFile = next.HeaderRulesFile
+	config.CacheRules = next.CacheRules
+	config.MaintenanceWindows = next.MaintenanceWindows
+	config.MaintenanceAllowCIDRs = next.MaintenanceAllowCIDRs
+
+	return &config
+}
+
+// buildHandler builds handlers with the options, for each policy in turn.
+func buildHandler(options HandlerOptions) func(Policy) http.Handler {
+	return func(policy Policy) http.Handler {
+		return NewHandler(options.withPolicy(policy))
+	}
+}
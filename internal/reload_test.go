@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_reload(t *testing.T) {
+	upstreams := []string{}
+	for _, name := range []string{"first", "second"} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer upstream.Close()
+
+		upstreams = append(upstreams, upstream.URL)
+	}
+
+	file := filepath.Join(t.TempDir(), "thruster.env")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(file, []byte("CONFIG_CHANGE_LOG_SIZE=0\n"+content), 0o644))
+	}
+	writeConfig("TARGET_URLS=" + upstreams[0] + "\n")
+	usingEnvVar(t, "CONFIG_FILE", file)
+
+	config, err := newConfig("", "echo", nil)
+	require.NoError(t, err)
+
+	service := NewService(config)
+	service.upstreams = NewUpstreamPool(service.targetUrls(), config.LoadBalancing)
+	service.options = handlerOptions(upstreams[0])
+	service.options.upstreams = service.upstreams
+	service.handler = NewPolicyHandler(service.policies, buildHandler(service.options))
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		service.handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+		return w
+	}
+
+	w := get()
+	assert.Equal(t, "first", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Reloaded"))
+
+	writeConfig("TARGET_URLS=" + upstreams[1] + "\nRATE_LIMIT=0.01:1\nHEADER_RULES=response set X-Reloaded yes\nHTTP_PORT=8081\n")
+	service.reload()
+
+	w = get()
+	assert.Equal(t, "second", w.Body.String())
+	assert.Equal(t, "yes", w.Header().Get("X-Reloaded"))
+	assert.Equal(t, http.StatusTooManyRequests, get().Code)
+
+	policy, _ := service.policies.Current()
+	assert.Equal(t, RateLimit{Rate: 0.01, Burst: 1}, policy.ClientRateLimit)
+	assert.Equal(t, config.HttpPort, service.config.HttpPort, "leaves settings that need a restart")
+
+	writeConfig("RATE_LIMIT=often\n")
+	service.reload()
+
+	policy, _ = service.policies.Current()
+	assert.Equal(t, RateLimit{Rate: 0.01, Burst: 1}, policy.ClientRateLimit, "keeps the configuration when it's invalid")
+	assert.Equal(t, upstreams[1], service.upstreams.Upstreams()[0].URL.String())
+
+	writeConfig("TARGET_URLS=" + upstreams[0] + "\nBLOCK_COUNTRIES=RU\n")
+	assert.ErrorIs(t, service.applyReload(), ErrReloadNeedsGeoIP2)
+
+	policy, _ = service.policies.Current()
+	assert.Empty(t, policy.BlockCountries, "keeps the configuration when its rules can't be enforced")
+	assert.Equal(t, RateLimit{Rate: 0.01, Burst: 1}, policy.ClientRateLimit)
+	assert.Equal(t, upstreams[1], service.upstreams.Upstreams()[0].URL.String())
+}
+
+func TestService_reload_keeps_blocklists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "thruster.env")
+	require.NoError(t, os.WriteFile(file, []byte("CONFIG_CHANGE_LOG_SIZE=0\nBLOCK_COUNTRIES=CN\n"), 0o644))
+	usingEnvVar(t, "CONFIG_FILE", file)
+
+	config, err := newConfig("", "echo", nil)
+	require.NoError(t, err)
+
+	service := NewService(config)
+	service.upstreams = NewUpstreamPool(service.targetUrls(), config.LoadBalancing)
+	service.options = handlerOptions("http://localhost:3000")
+	service.handler = NewPolicyHandler(service.policies, buildHandler(service.options))
+
+	networks, err := ParseCIDRs([]string{"198.51.100.0/24"})
+	require.NoError(t, err)
+	service.policies.Update(func(policy Policy) Policy {
+		policy.Blocklists = map[string][]*net.IPNet{"feed": networks}
+		return policy
+	})
+
+	require.NoError(t, os.WriteFile(file, []byte("CONFIG_CHANGE_LOG_SIZE=0\nBLOCK_COUNTRIES=CN,RU\n"), 0o644))
+	service.reload()
+
+	policy, _ := service.policies.Current()
+	assert.Equal(t, []string{"CN", "RU"}, policy.BlockCountries)
+	assert.Equal(t, networks, policy.Blocklists["feed"])
+}
+
+func TestReloadedConfig(t *testing.T) {
+	current := &Config{HttpPort: 80, BlockedPage: "./public/403.html", BlockCountries: []string{"CN"}}
+	next := &Config{HttpPort: 8080, BlockedPage: "./public/blocked.html", BlockCountries: []string{"RU"}}
+
+	config := reloadedConfig(current, next)
+
+	assert.Equal(t, 80, config.HttpPort)
+	assert.Equal(t, "./public/blocked.html", config.BlockedPage)
+	assert.Equal(t, []string{"RU"}, config.BlockCountries)
+	assert.Equal(t, "./public/403.html", current.BlockedPage)
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+
+	maxRequestIDLength = 200
+)
+
+// RequestIDMiddleware gives each request an ID, so that it can be followed
+// through our logs, the app's and those of any proxy in front of us. The ID is
+// sent upstream and back to the client in the request ID header, kept in the
+// request's tags, and added to everything logged with the request's context.
+//
+// An ID that arrives with the request is kept when we trust the proxy in front
+// of us to have set it, as long as it's a reasonable length and printable.
+// Otherwise we make a new one.
+type RequestIDMiddleware struct {
+	header        string
+	trustIncoming bool
+	next          http.Handler
+}
+
+func NewRequestIDMiddleware(header string, trustIncoming bool, next http.Handler) *RequestIDMiddleware {
+	return &RequestIDMiddleware{
+		header:        header,
+		trustIncoming: trustIncoming,
+		next:          next,
+	}
+}
+
+func (m *RequestIDMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(m.header)
+	if !m.trustIncoming || !validRequestID(id) {
+		id = rand.Text()
+	}
+
+	r, tags := WithRequestTags(r)
+	tags.Set(TagRequestID, id)
+
+	r.Header.Set(m.header, id)
+	w.Header().Set(m.header, id)
+
+	m.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+}
+
+// RequestIDFromContext returns the ID of the request whose context this is, or
+// an empty string if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDLogHandler adds the request ID to records logged with a request's
+// context, such as by `logger.InfoContext(r.Context(), ...)`.
+type RequestIDLogHandler struct {
+	slog.Handler
+}
+
+func NewRequestIDLogHandler(handler slog.Handler) *RequestIDLogHandler {
+	return &RequestIDLogHandler{Handler: handler}
+}
+
+func (h *RequestIDLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *RequestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &RequestIDLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *RequestIDLogHandler) WithGroup(name string) slog.Handler {
+	return &RequestIDLogHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// Private
+
+type requestIDKey struct{}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var upstreamID, contextID, tagID string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get("X-Request-ID")
+		contextID = RequestIDFromContext(r.Context())
+		tagID = RequestTagsFromContext(r.Context()).Get(TagRequestID)
+	})
+
+	request := func(trustIncoming bool, incoming string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if incoming != "" {
+			r.Header.Set("X-Request-ID", incoming)
+		}
+
+		w := httptest.NewRecorder()
+		NewRequestIDMiddleware("X-Request-ID", trustIncoming, next).ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(false, "")
+	id := w.Header().Get("X-Request-ID")
+	assert.Len(t, id, 26)
+	assert.Equal(t, id, upstreamID)
+	assert.Equal(t, id, contextID)
+	assert.Equal(t, id, tagID)
+
+	assert.NotEqual(t, id, request(false, "").Header().Get("X-Request-ID"))
+
+	w = request(true, "from-the-load-balancer")
+	assert.Equal(t, "from-the-load-balancer", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "from-the-load-balancer", upstreamID)
+
+	w = request(false, "from-the-client")
+	assert.NotEqual(t, "from-the-client", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, w.Header().Get("X-Request-ID"), upstreamID)
+
+	for _, invalid := range []string{"has spaces", "line\nbreak", strings.Repeat("x", 201)} {
+		w = request(true, invalid)
+		assert.NotEqual(t, invalid, w.Header().Get("X-Request-ID"))
+		assert.Len(t, w.Header().Get("X-Request-ID"), 26)
+	}
+}
+
+func TestRequestIDLogHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(NewRequestIDLogHandler(slog.NewJSONHandler(out, nil))).With("component", "test")
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc123")
+	logger.InfoContext(ctx, "Request blocked")
+	logger.Info("Unrelated")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "abc123", line["request_id"])
+	assert.Equal(t, "test", line["component"])
+
+	var unrelated map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &unrelated))
+	assert.NotContains(t, unrelated, "request_id")
+}
+
+func TestHandler_request_id(t *testing.T) {
+	var upstreamID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get("X-Request-ID")
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.requestIDHeader = "X-Request-ID"
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.NotEmpty(t, upstreamID)
+	assert.Equal(t, upstreamID, w.Header().Get("X-Request-ID"))
+}
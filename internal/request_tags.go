@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"sync"
+)
+
+// Well-known request tags. Middleware may also set tags of their own.
+const (
+	TagCountry        = "country"
+	TagASN            = "asn"
+	TagCacheStatus    = "cache-status"
+	TagStream         = "stream"
+	TagUpstreamError  = "upstream-error"
+	TagRiskScore      = "risk-score"
+	TagRiskAction     = "risk-action"
+	TagBlocked        = "blocked"
+	TagBlockedRule    = "blocked-rule"
+	TagTLSFingerprint = "tls-fingerprint"
+	TagRequestID      = "request-id"
+	TagGeoBypass      = "geo-bypass"
+	TagChallenge      = "challenge"
+)
+
+// Values of the blocked tag, for what refused the request. The blocked-rule
+// tag says which rule it was, named as in the exported state where it can be,
+// such as `block_country:CN`.
+const (
+	BlockedByCountry   = "country"
+	BlockedByRateLimit = "rate-limit"
+	BlockedByRiskScore = "risk-score"
+	BlockedByBodyRule  = "body-rule"
+	BlockedByBlocklist = "blocklist"
+	BlockedByBan       = "ban"
+	BlockedByGeofence  = "geofence"
+)
+
+type requestTagsKey struct{}
+
+// RequestTags is a set of facts learned about a request as it passes through
+// the middleware chain. Any middleware can add to it, and any later stage, or
+// an earlier one once the request has been handled, can read it back.
+//
+// A nil *RequestTags is valid: reads return nothing and writes are dropped.
+type RequestTags struct {
+	sync.RWMutex
+	values map[string]string
+}
+
+func NewRequestTags() *RequestTags {
+	return &RequestTags{values: map[string]string{}}
+}
+
+func (t *RequestTags) Set(name, value string) {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.values[name] = value
+}
+
+func (t *RequestTags) Get(name string) string {
+	if t == nil {
+		return ""
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.values[name]
+}
+
+// All returns a copy of the tags that have been set.
+func (t *RequestTags) All() map[string]string {
+	if t == nil {
+		return map[string]string{}
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+
+	return maps.Clone(t.values)
+}
+
+// RequestTagsFromContext returns the tags attached to a request's context, or
+// nil if there are none.
+func RequestTagsFromContext(ctx context.Context) *RequestTags {
+	tags, _ := ctx.Value(requestTagsKey{}).(*RequestTags)
+	return tags
+}
+
+// WithRequestTags makes sure the request carries a tag set, returning the
+// request to pass on along with its tags.
+func WithRequestTags(r *http.Request) (*http.Request, *RequestTags) {
+	tags := RequestTagsFromContext(r.Context())
+	if tags != nil {
+		return r, tags
+	}
+
+	tags = NewRequestTags()
+	return r.WithContext(context.WithValue(r.Context(), requestTagsKey{}, tags)), tags
+}
+
+type RequestTagsMiddleware struct {
+	next http.Handler
+}
+
+func NewRequestTagsMiddleware(next http.Handler) http.Handler {
+	return &RequestTagsMiddleware{next: next}
+}
+
+func (h *RequestTagsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, _ = WithRequestTags(r)
+	h.next.ServeHTTP(w, r)
+}
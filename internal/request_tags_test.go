@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTags(t *testing.T) {
+	tags := NewRequestTags()
+	tags.Set(TagCountry, "GB")
+	tags.Set(TagASN, "AS20712")
+
+	assert.Equal(t, "GB", tags.Get(TagCountry))
+	assert.Equal(t, "", tags.Get(TagRiskScore))
+	assert.Equal(t, map[string]string{TagCountry: "GB", TagASN: "AS20712"}, tags.All())
+
+	all := tags.All()
+	all[TagCountry] = "US"
+	assert.Equal(t, "GB", tags.Get(TagCountry))
+}
+
+func TestRequestTags_nil_is_empty(t *testing.T) {
+	var tags *RequestTags
+	tags.Set(TagCountry, "GB")
+
+	assert.Equal(t, "", tags.Get(TagCountry))
+	assert.Empty(t, tags.All())
+}
+
+func TestRequestTagsMiddleware(t *testing.T) {
+	var seen *RequestTags
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestTagsFromContext(r.Context())
+		seen.Set(TagCacheStatus, "hit")
+	})
+
+	outer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, tags := WithRequestTags(r)
+		inner.ServeHTTP(w, r)
+
+		assert.Equal(t, "hit", tags.Get(TagCacheStatus))
+	})
+
+	NewRequestTagsMiddleware(outer).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.NotNil(t, seen)
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RiskSignalUserAgent scores the User-Agent header. Every other signal is the
+// name of a request tag, such as `country`.
+const RiskSignalUserAgent = "user_agent"
+
+const riskScoreHeader = "X-Risk-Score"
+
+type RiskAction string
+
+const (
+	RiskActionAllow RiskAction = "allow"
+	RiskActionTag   RiskAction = "tag"
+	RiskActionBlock RiskAction = "block"
+)
+
+var ErrInvalidRiskScore = errors.New("risk score must be in the form signal[:value]=weight")
+
+// RiskScore is the weight a signal adds to a request's risk score when it
+// matches.
+//
+// With no value, the signal matches whenever it's present. An empty value
+// (`user_agent:=25`) matches when the signal is absent. Otherwise tags must
+// equal the value, and the User-Agent must contain it, ignoring case.
+type RiskScore struct {
+	Signal   string
+	Value    string
+	HasValue bool
+	Weight   int
+}
+
+// ParseRiskScore parses a score written as `signal[:value]=weight`, for
+// example `country:CN=40` or `user_agent:curl=20`. Weights may be negative, to
+// vouch for a signal rather than count against it.
+func ParseRiskScore(value string) (RiskScore, error) {
+	target, weightValue, ok := strings.Cut(value, "=")
+	if !ok {
+		return RiskScore{}, ErrInvalidRiskScore
+	}
+
+	signal, signalValue, hasValue := strings.Cut(strings.TrimSpace(target), ":")
+	signal = strings.ToLower(strings.TrimSpace(signal))
+	if signal == "" {
+		return RiskScore{}, ErrInvalidRiskScore
+	}
+
+	weight, err := strconv.Atoi(strings.TrimSpace(weightValue))
+	if err != nil {
+		return RiskScore{}, ErrInvalidRiskScore
+	}
+
+	return RiskScore{
+		Signal:   signal,
+		Value:    strings.TrimSpace(signalValue),
+		HasValue: hasValue,
+		Weight:   weight,
+	}, nil
+}
+
+// Key identifies what the score matches, without its weight.
+func (s RiskScore) Key() string {
+	if s.HasValue {
+		return s.Signal + ":" + s.Value
+	}
+	return s.Signal
+}
+
+// String formats the score in the form that ParseRiskScore reads.
+func (s RiskScore) String() string {
+	return s.Key() + "=" + strconv.Itoa(s.Weight)
+}
+
+func (s RiskScore) matches(r *http.Request, tags *RequestTags) bool {
+	var value string
+	if s.Signal == RiskSignalUserAgent {
+		value = r.Header.Get("User-Agent")
+	} else {
+		value = tags.Get(s.Signal)
+	}
+
+	switch {
+	case !s.HasValue:
+		return value != ""
+	case s.Value == "":
+		return value == ""
+	case s.Signal == RiskSignalUserAgent:
+		return strings.Contains(strings.ToLower(value), strings.ToLower(s.Value))
+	default:
+		return strings.EqualFold(value, s.Value)
+	}
+}
+
+type RiskScores []RiskScore
+
+// Uses reports whether any of the scores depend on the given signal.
+func (s RiskScores) Uses(signal string) bool {
+	for _, score := range s {
+		if score.Signal == signal {
+			return true
+		}
+	}
+	return false
+}
+
+// RiskThresholds are the scores at which a request is acted on. A threshold of
+// zero turns its action off.
+type RiskThresholds struct {
+	Tag   int
+	Block int
+}
+
+func (t RiskThresholds) actionFor(score int) RiskAction {
+	switch {
+	case t.Block > 0 && score >= t.Block:
+		return RiskActionBlock
+	case t.Tag > 0 && score >= t.Tag:
+		return RiskActionTag
+	default:
+		return RiskActionAllow
+	}
+}
+
+// RiskScoreMiddleware adds up the weights of the signals that match a request,
+// and acts on the total. Requests that reach the tag threshold are passed on
+// with an X-Risk-Score header, so that the upstream can treat them with
+// suspicion; those that reach the block threshold are refused. The score is
+// recorded as a request tag in either case.
+//
+// Signals are read from the request tags, so this has to run after the stages
+// that set them.
+type RiskScoreMiddleware struct {
+	logger      *slog.Logger
+	next        http.Handler
+	scores      RiskScores
+	thresholds  RiskThresholds
+	blockedPage *PageTemplate
+}
+
+func NewRiskScoreMiddleware(logger *slog.Logger, next http.Handler, scores RiskScores, thresholds RiskThresholds) *RiskScoreMiddleware {
+	return &RiskScoreMiddleware{
+		logger:     logger,
+		next:       next,
+		scores:     scores,
+		thresholds: thresholds,
+	}
+}
+
+// SetBlockedPage sets the page served to blocked requests, in place of a
+// plain "Access denied".
+func (m *RiskScoreMiddleware) SetBlockedPage(page *PageTemplate) {
+	m.blockedPage = page
+}
+
+func (m *RiskScoreMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Header.Del(riskScoreHeader)
+
+	r, tags := WithRequestTags(r)
+	score := m.score(r, tags)
+	action := m.thresholds.actionFor(score)
+
+	tags.Set(TagRiskScore, strconv.Itoa(score))
+	tags.Set(TagRiskAction, string(action))
+
+	switch action {
+	case RiskActionBlock:
+		host, _ := clientIP(r)
+		m.logger.InfoContext(r.Context(), "Request blocked - risk score over threshold", "score", score, "threshold", m.thresholds.Block, "ip", host)
+		m.writeBlocked(w, r, "risk_block_score:"+strconv.Itoa(m.thresholds.Block))
+		return
+	case RiskActionTag:
+		r.Header.Set(riskScoreHeader, strconv.Itoa(score))
+	}
+
+	m.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (m *RiskScoreMiddleware) score(r *http.Request, tags *RequestTags) int {
+	total := 0
+	for _, score := range m.scores {
+		if score.matches(r, tags) {
+			total += score.Weight
+		}
+	}
+	return total
+}
+
+func (m *RiskScoreMiddleware) writeBlocked(w http.ResponseWriter, r *http.Request, rule string) {
+	tags := RequestTagsFromContext(r.Context())
+	tags.Set(TagBlocked, BlockedByRiskScore)
+	tags.Set(TagBlockedRule, rule)
+
+	if m.blockedPage != nil {
+		m.blockedPage.Render(w, r, http.StatusForbidden)
+		return
+	}
+
+	http.Error(w, "Access denied", http.StatusForbidden)
+}
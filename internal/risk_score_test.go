@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRiskScore(t *testing.T) {
+	score, err := ParseRiskScore("Country:GB=40")
+	require.NoError(t, err)
+	assert.Equal(t, RiskScore{Signal: "country", Value: "GB", HasValue: true, Weight: 40}, score)
+	assert.Equal(t, "country:GB=40", score.String())
+
+	score, err = ParseRiskScore("user_agent:=25")
+	require.NoError(t, err)
+	assert.Equal(t, RiskScore{Signal: "user_agent", HasValue: true, Weight: 25}, score)
+	assert.Equal(t, "user_agent:=25", score.String())
+
+	score, err = ParseRiskScore("geo-bypass=-10")
+	require.NoError(t, err)
+	assert.Equal(t, RiskScore{Signal: "geo-bypass", Weight: -10}, score)
+	assert.Equal(t, "geo-bypass=-10", score.String())
+
+	for _, value := range []string{"", "country:GB", "=10", ":GB=10", "country:GB=high"} {
+		_, err := ParseRiskScore(value)
+		assert.ErrorIs(t, err, ErrInvalidRiskScore, value)
+	}
+}
+
+func TestRiskScores_Uses(t *testing.T) {
+	scores := RiskScores{{Signal: "user_agent", Weight: 10}, {Signal: "country", Value: "GB", HasValue: true, Weight: 10}}
+
+	assert.True(t, scores.Uses(TagCountry))
+	assert.False(t, scores.Uses(TagASN))
+}
+
+func TestRiskScoreMiddleware(t *testing.T) {
+	scores := RiskScores{
+		{Signal: RiskSignalUserAgent, Value: "curl", HasValue: true, Weight: 30},
+		{Signal: RiskSignalUserAgent, HasValue: true, Weight: 50},
+		{Signal: TagTLSFingerprint, Value: "1a2b3c4d5e6f", HasValue: true, Weight: 40},
+		{Signal: TagASN, Value: "hosting", HasValue: true, Weight: 20},
+	}
+	thresholds := RiskThresholds{Tag: 25, Block: 60}
+
+	request := func(userAgent string, requestTags map[string]string) (*httptest.ResponseRecorder, http.Header, *RequestTags) {
+		var received http.Header
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+		})
+		handler := NewRiskScoreMiddleware(slog.Default(), next, scores, thresholds)
+
+		r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+		r.Header.Set("User-Agent", userAgent)
+		r.Header.Set("X-Risk-Score", "0")
+		for name, value := range requestTags {
+			tags.Set(name, value)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w, received, tags
+	}
+
+	t.Run("allows requests under the tag threshold", func(t *testing.T) {
+		w, received, tags := request("Mozilla/5.0", map[string]string{TagASN: "hosting"})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, received.Get("X-Risk-Score"))
+		assert.Equal(t, "20", tags.Get(TagRiskScore))
+		assert.Equal(t, "allow", tags.Get(TagRiskAction))
+	})
+
+	t.Run("tags requests over the tag threshold", func(t *testing.T) {
+		w, received, tags := request("CURL/8.0", nil)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "30", received.Get("X-Risk-Score"))
+		assert.Equal(t, "tag", tags.Get(TagRiskAction))
+	})
+
+	t.Run("blocks requests over the block threshold", func(t *testing.T) {
+		w, received, tags := request("curl/8.0", map[string]string{TagTLSFingerprint: "1a2b3c4d5e6f", TagASN: "Hosting"})
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, received)
+		assert.Equal(t, "90", tags.Get(TagRiskScore))
+		assert.Equal(t, "block", tags.Get(TagRiskAction))
+	})
+
+	t.Run("scores a missing user agent", func(t *testing.T) {
+		_, received, tags := request("", nil)
+
+		assert.Equal(t, "50", tags.Get(TagRiskScore))
+		assert.Equal(t, "50", received.Get("X-Risk-Score"))
+	})
+}
+
+func TestRiskScoreMiddleware_scores_countries(t *testing.T) {
+	resolver := openTestGeoResolver(t, "GeoLite2-Country.mmdb")
+
+	scores := RiskScores{{Signal: TagCountry, Value: "GB", HasValue: true, Weight: 100}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewGeoIPMiddleware(resolver, slog.Default(), NewRiskScoreMiddleware(slog.Default(), next, scores, RiskThresholds{Block: 100}), nil, nil, BlockPolicies{})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "81.2.69.142:1234"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Access denied")
+}
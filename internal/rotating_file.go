@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotatingFile is a file that's appended to, and moved aside once it gets
+// too large or too old. Rotated files are numbered, with `.1` the most
+// recent, and only the newest maxFiles are kept.
+//
+// A maxSize or interval of zero turns off rotation by size or time.
+// Rotation by time happens at multiples of the interval, in UTC, so an
+// interval of 24h rotates at midnight.
+type RotatingFile struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	interval time.Duration
+	maxFiles int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+}
+
+func NewRotatingFile(path string, maxSize int64, interval time.Duration, maxFiles int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		interval: interval,
+		maxFiles: maxFiles,
+		now:      time.Now,
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.due(len(p)) {
+		err := f.rotate()
+		if err != nil {
+			slog.Error("Unable to rotate file", "path", f.path, "error", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Private
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = f.now()
+
+	// An existing file dates from when it was last written to
+	if f.size > 0 {
+		f.openedAt = info.ModTime()
+	}
+
+	return nil
+}
+
+func (f *RotatingFile) due(size int) bool {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(size) > f.maxSize {
+		return true
+	}
+
+	if f.interval > 0 && !f.now().Truncate(f.interval).Equal(f.openedAt.Truncate(f.interval)) {
+		return true
+	}
+
+	return false
+}
+
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if f.maxFiles > 0 {
+		os.Remove(f.rotatedPath(f.maxFiles))
+		for i := f.maxFiles - 1; i > 0; i-- {
+			os.Rename(f.rotatedPath(i), f.rotatedPath(i+1))
+		}
+		err = os.Rename(f.path, f.rotatedPath(1))
+	} else {
+		err = os.Remove(f.path)
+	}
+
+	// Keep writing either way, even if it has to be to the same file
+	openErr := f.open()
+	if openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (f *RotatingFile) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
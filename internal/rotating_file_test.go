@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile_rotates_by_size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+
+	f, err := NewRotatingFile(path, 10, 0, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, "four\nfive\n", readRotatingFileTestFile(t, path))
+	assert.Equal(t, "three\n", readRotatingFileTestFile(t, path+".1"))
+	assert.Equal(t, "one\ntwo\n", readRotatingFileTestFile(t, path+".2"))
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestRotatingFile_rotates_by_time(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	now := time.Date(2024, 3, 5, 23, 59, 0, 0, time.UTC)
+
+	f, err := NewRotatingFile(path, 0, 24*time.Hour, 1)
+	require.NoError(t, err)
+	defer f.Close()
+	f.now = func() time.Time { return now }
+	f.openedAt = now
+
+	f.Write([]byte("monday\n"))
+	now = now.Add(2 * time.Minute)
+	f.Write([]byte("tuesday\n"))
+	f.Write([]byte("still tuesday\n"))
+
+	assert.Equal(t, "tuesday\nstill tuesday\n", readRotatingFileTestFile(t, path))
+	assert.Equal(t, "monday\n", readRotatingFileTestFile(t, path+".1"))
+}
+
+func TestRotatingFile_without_kept_files(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	f, err := NewRotatingFile(path, 5, 0, 0)
+	require.NoError(t, err)
+	defer f.Close()
+
+	f.Write([]byte("one\n"))
+	f.Write([]byte("two\n"))
+
+	assert.Equal(t, "two\n", readRotatingFileTestFile(t, path))
+	assert.NoFileExists(t, path+".1")
+}
+
+func TestRotatingFile_appends_to_an_existing_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(path, []byte("before\n"), 0644))
+
+	f, err := NewRotatingFile(path, 100, 0, 1)
+	require.NoError(t, err)
+	f.Write([]byte("after\n"))
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, "before\nafter\n", readRotatingFileTestFile(t, path))
+
+	_, err = f.Write([]byte("closed\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func readRotatingFileTestFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	// Schedules name their time zones, which needs to work even where the
+	// system has no zone database, as in minimal container images.
+	_ "time/tzdata"
+)
+
+var ErrInvalidSchedule = errors.New("schedule must be in the form [DAY|DAY-DAY...] HH:MM-HH:MM [TIME_ZONE]")
+
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule is a window of time that recurs each day, or on particular days of
+// the week, in a given time zone.
+//
+// A window that ends earlier in the day than it starts runs past midnight, and
+// belongs to the day it starts on: `Fri 22:00-02:00` runs from Friday night
+// into Saturday morning.
+type Schedule struct {
+	Days     []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// ParseSchedule parses a schedule written as
+// `[DAY|DAY-DAY...] HH:MM-HH:MM [TIME_ZONE]`, such as `02:00-04:00`,
+// `Sat|Sun 01:00-05:00 Asia/Tokyo` or `Mon-Fri 22:00-06:00 Europe/London`.
+// Without days it applies every day, and without a time zone it's in UTC.
+func ParseSchedule(value string) (Schedule, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return Schedule{}, ErrInvalidSchedule
+	}
+
+	schedule := Schedule{Location: time.UTC}
+
+	if !strings.Contains(fields[0], ":") {
+		days, err := parseScheduleDays(fields[0])
+		if err != nil {
+			return Schedule{}, err
+		}
+		schedule.Days = days
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 || len(fields) > 2 {
+		return Schedule{}, ErrInvalidSchedule
+	}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return Schedule{}, ErrInvalidSchedule
+	}
+
+	var err error
+	schedule.Start, err = parseScheduleTime(start)
+	if err != nil {
+		return Schedule{}, err
+	}
+	schedule.End, err = parseScheduleTime(end)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if schedule.Start == schedule.End || schedule.Start == 24*time.Hour {
+		return Schedule{}, ErrInvalidSchedule
+	}
+
+	if len(fields) == 2 {
+		schedule.Location, err = time.LoadLocation(fields[1])
+		if err != nil {
+			return Schedule{}, fmt.Errorf("%w: %w", ErrInvalidSchedule, err)
+		}
+	}
+
+	return schedule, nil
+}
+
+// String formats the schedule in the form that ParseSchedule reads.
+func (s Schedule) String() string {
+	var parts []string
+
+	if len(s.Days) > 0 {
+		days := make([]string, len(s.Days))
+		for i, day := range s.Days {
+			days[i] = day.String()[:3]
+		}
+		parts = append(parts, strings.Join(days, "|"))
+	}
+
+	parts = append(parts, formatScheduleTime(s.Start)+"-"+formatScheduleTime(s.End))
+
+	if s.location() != time.UTC {
+		parts = append(parts, s.location().String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Active reports whether the window is open at the given time.
+func (s Schedule) Active(now time.Time) bool {
+	return s.Remaining(now) > 0
+}
+
+// Remaining is how long the window stays open from the given time, or zero
+// when it isn't open.
+func (s Schedule) Remaining(now time.Time) time.Duration {
+	now = now.In(s.location())
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	today := now.Weekday()
+	yesterday := (today + 6) % 7
+
+	if s.Start < s.End {
+		if s.onDay(today) && clock >= s.Start && clock < s.End {
+			return s.End - clock
+		}
+		return 0
+	}
+
+	// The window runs past midnight
+	if s.onDay(today) && clock >= s.Start {
+		return 24*time.Hour - clock + s.End
+	}
+	if s.onDay(yesterday) && clock < s.End {
+		return s.End - clock
+	}
+	return 0
+}
+
+// Private
+
+func (s Schedule) onDay(day time.Weekday) bool {
+	return len(s.Days) == 0 || slices.Contains(s.Days, day)
+}
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.UTC
+	}
+	return s.Location
+}
+
+func parseScheduleDays(value string) ([]time.Weekday, error) {
+	var days []time.Weekday
+
+	for _, item := range strings.Split(value, "|") {
+		first, last, isRange := strings.Cut(item, "-")
+		if !isRange {
+			last = first
+		}
+
+		from, ok := parseScheduleDay(first)
+		if !ok {
+			return nil, ErrInvalidSchedule
+		}
+		to, ok := parseScheduleDay(last)
+		if !ok {
+			return nil, ErrInvalidSchedule
+		}
+
+		for day := from; ; day = (day + 1) % 7 {
+			if !slices.Contains(days, day) {
+				days = append(days, day)
+			}
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parseScheduleDay(value string) (time.Weekday, bool) {
+	value = strings.ToLower(value)
+	if len(value) < 3 {
+		return 0, false
+	}
+
+	index := slices.Index(scheduleDays, value[:3])
+	if index < 0 || !strings.HasPrefix(strings.ToLower(time.Weekday(index).String()), value) {
+		return 0, false
+	}
+	return time.Weekday(index), true
+}
+
+func parseScheduleTime(value string) (time.Duration, error) {
+	var hours, minutes int
+	_, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes)
+	if err != nil || len(value) != 5 || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, ErrInvalidSchedule
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+func formatScheduleTime(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("02:00-04:30")
+	require.NoError(t, err)
+	assert.Equal(t, Schedule{Start: 2 * time.Hour, End: 4*time.Hour + 30*time.Minute, Location: time.UTC}, schedule)
+	assert.Equal(t, "02:00-04:30", schedule.String())
+
+	schedule, err = ParseSchedule("sat|Sunday 22:00-24:00 Asia/Tokyo")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Saturday, time.Sunday}, schedule.Days)
+	assert.Equal(t, "Asia/Tokyo", schedule.Location.String())
+	assert.Equal(t, "Sat|Sun 22:00-24:00 Asia/Tokyo", schedule.String())
+
+	schedule, err = ParseSchedule("Fri-Mon 22:00-06:00")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday}, schedule.Days)
+
+	for _, value := range []string{"", "Sat", "02:00", "2:00-4:00", "02:00-02:00", "24:00-02:00", "02:00-25:00", "02:60-03:00", "Xyz 02:00-04:00", "Sa 02:00-04:00", "02:00-04:00 Nowhere/Special", "Sat 02:00-04:00 UTC extra"} {
+		_, err := ParseSchedule(value)
+		assert.ErrorIs(t, err, ErrInvalidSchedule, value)
+	}
+}
+
+func TestSchedule_Remaining(t *testing.T) {
+	schedule, err := ParseSchedule("Sat 02:00-04:00 Asia/Tokyo")
+	require.NoError(t, err)
+
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	saturday := time.Date(2024, 3, 9, 0, 0, 0, 0, tokyo)
+
+	assert.Equal(t, time.Duration(0), schedule.Remaining(saturday.Add(1*time.Hour+59*time.Minute)))
+	assert.Equal(t, 2*time.Hour, schedule.Remaining(saturday.Add(2*time.Hour)))
+	assert.Equal(t, 30*time.Minute, schedule.Remaining(saturday.Add(3*time.Hour+30*time.Minute)))
+	assert.Equal(t, time.Duration(0), schedule.Remaining(saturday.Add(4*time.Hour)))
+	assert.Equal(t, time.Duration(0), schedule.Remaining(saturday.Add(-21*time.Hour)), "on Friday")
+
+	// The same moment seen from elsewhere
+	assert.True(t, schedule.Active(time.Date(2024, 3, 8, 17, 30, 0, 0, time.UTC)))
+}
+
+func TestSchedule_Remaining_past_midnight(t *testing.T) {
+	schedule, err := ParseSchedule("Fri 22:00-02:00")
+	require.NoError(t, err)
+
+	friday := time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)
+
+	assert.False(t, schedule.Active(friday.Add(1*time.Hour)), "early Friday belongs to Thursday's window")
+	assert.Equal(t, 4*time.Hour, schedule.Remaining(friday.Add(22*time.Hour)))
+	assert.Equal(t, 1*time.Hour, schedule.Remaining(friday.Add(25*time.Hour)))
+	assert.False(t, schedule.Active(friday.Add(26*time.Hour)))
+	assert.False(t, schedule.Active(friday.Add(46*time.Hour)), "on Saturday night")
+}
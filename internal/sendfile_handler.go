@@ -90,16 +90,38 @@ func (w *sendfileWriter) sendingFilename() string {
 	return w.w.Header().Get("X-Sendfile")
 }
 
+// serveFile sends the file with http.ServeContent, which answers Range and
+// conditional requests with partial content or 304 Not Modified as needed.
 func (w *sendfileWriter) serveFile(filename string) {
 	slog.Debug("X-Sendfile sending file", "path", filename)
 
-	w.setContentLength(filename)
-	http.ServeFile(w.w, w.r, filename)
+	file, err := os.Open(filename)
+	if err != nil {
+		w.serveFileError(filename, err)
+		return
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil || fi.IsDir() {
+		w.serveFileError(filename, os.ErrNotExist)
+		return
+	}
+
+	w.setContentLength(fi.Size())
+	http.ServeContent(w.w, w.r, fi.Name(), fi.ModTime(), file)
+}
+
+func (w *sendfileWriter) serveFileError(filename string, err error) {
+	slog.Debug("X-Sendfile unable to send file", "path", filename, "error", err)
+
+	w.w.Header().Del("Content-Length")
+	http.Error(w.w, "404 page not found", http.StatusNotFound)
 }
 
-func (w *sendfileWriter) setContentLength(filename string) {
-	// In most cases, `http.ServeFile` will set this for us. However, it will not
-	// set it if the response also has a `Content-Encoding`.
+func (w *sendfileWriter) setContentLength(size int64) {
+	// In most cases, `http.ServeContent` will set this for us. However, it will
+	// not set it if the response also has a `Content-Encoding`.
 	// (https://github.com/golang/go/commit/fdc21f3eafe94490e55e0bf018490b3aa9ba2383)
 	//
 	// If we don't set (or at least clear) the header in that case, we'll pass
@@ -109,11 +131,13 @@ func (w *sendfileWriter) setContentLength(filename string) {
 	// In particular, this happens when Rails is serving a gzipped asset via
 	// `X-Sendfile`, which it does using `Content-Encoding: gzip` and
 	// `Content-Length: 0`.
+	//
+	// The size only holds for the whole file, so a Range request is left for
+	// `http.ServeContent` to size, or to send without a length.
 
-	fi, err := os.Stat(filename)
-	if err != nil {
-		w.w.Header().Del("Content-Length")
+	if w.r.Header.Get("Range") == "" {
+		w.w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	} else {
-		w.w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		w.w.Header().Del("Content-Length")
 	}
 }
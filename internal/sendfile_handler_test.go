@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -51,6 +52,51 @@ func TestSendfileHandler_sends_correct_content_length_when_content_encoding_pres
 	assert.Equal(t, strconv.FormatInt(fixtureLength("image.jpg"), 10), w.Header().Get("Content-Length"))
 }
 
+func TestSendfileHandler_serves_ranges(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("X-Sendfile", fixturePath("image.jpg"))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	h := NewSendfileHandler(true, http.HandlerFunc(upstream))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Range", "bytes=2-5")
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "4", w.Header().Get("Content-Length"))
+	assert.Equal(t, fmt.Sprintf("bytes 2-5/%d", fixtureLength("image.jpg")), w.Header().Get("Content-Range"))
+	assert.Equal(t, fixtureContent("image.jpg")[2:6], w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Range", "bytes=999999999-")
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+}
+
+func TestSendfileHandler_when_file_missing(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Header().Set("X-Sendfile", fixturePath("missing.jpg"))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	h := NewSendfileHandler(true, http.HandlerFunc(upstream))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotEqual(t, "100", w.Header().Get("Content-Length"))
+}
+
 func TestSendFileHandler_when_no_x_sendfile_present(t *testing.T) {
 	upstream := func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "X-Sendfile", r.Header.Get("X-Sendfile-Type"))
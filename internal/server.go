@@ -3,21 +3,27 @@ package internal
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/netutil"
 )
 
 type Server struct {
-	config      *Config
-	handler     http.Handler
-	httpServer  *http.Server
-	httpsServer *http.Server
+	config          *Config
+	handler         http.Handler
+	tlsFingerprints *TLSFingerprints
+	httpServer      *http.Server
+	httpsServer     *http.Server
+	http3Server     *http3.Server
 }
 
 func NewServer(config *Config, handler http.Handler) *Server {
@@ -27,7 +33,15 @@ func NewServer(config *Config, handler http.Handler) *Server {
 	}
 }
 
-func (s *Server) Start() {
+// SetTLSFingerprints makes the HTTPS server record the fingerprints of its
+// connections' ClientHellos.
+func (s *Server) SetTLSFingerprints(fingerprints *TLSFingerprints) {
+	s.tlsFingerprints = fingerprints
+}
+
+// Start binds the listeners and begins serving in the background. Binding
+// happens up front so that an unavailable port is reported as an error.
+func (s *Server) Start() error {
 	httpAddress := fmt.Sprintf(":%d", s.config.HttpPort)
 	httpsAddress := fmt.Sprintf(":%d", s.config.HttpsPort)
 
@@ -38,35 +52,109 @@ func (s *Server) Start() {
 		s.httpServer.Handler = manager.HTTPHandler(http.HandlerFunc(httpRedirectHandler))
 
 		s.httpsServer = s.defaultHttpServer(httpsAddress)
-		s.httpsServer.TLSConfig = manager.TLSConfig()
-		s.httpsServer.Handler = s.handler
-
-		go s.httpServer.ListenAndServe()
-		go s.httpsServer.ListenAndServeTLS("", "")
-
-		slog.Info("Server started", "http", httpAddress, "https", httpsAddress, "tls_domain", s.config.TLSDomains)
+		s.httpsServer.TLSConfig = s.tlsFingerprints.WrapTLSConfig(manager.TLSConfig())
+		s.httpsServer.ConnState = s.tlsFingerprints.ConnState
+		s.httpsServer.Handler = s.httpsHandler()
+
+		httpListener, err := s.listen(httpAddress)
+		if err != nil {
+			return err
+		}
+
+		httpsListener, err := s.listen(httpsAddress)
+		if err != nil {
+			httpListener.Close()
+			return err
+		}
+
+		if s.config.HTTP3Enabled {
+			s.http3Server = &http3.Server{
+				Addr:      httpsAddress,
+				TLSConfig: http3.ConfigureTLSConfig(manager.TLSConfig()),
+				Handler:   s.httpsHandler(),
+			}
+			s.httpsServer.Handler = NewAltSvcMiddleware(s.config.HttpsPort, s.httpsServer.Handler)
+
+			http3Listener, err := net.ListenPacket("udp", httpsAddress)
+			if err != nil {
+				httpListener.Close()
+				httpsListener.Close()
+				return err
+			}
+
+			go s.http3Server.Serve(http3Listener)
+		}
+
+		go s.httpServer.Serve(httpListener)
+		go s.httpsServer.ServeTLS(httpsListener, "", "")
+
+		slog.Info("Server started", "http", httpAddress, "https", httpsAddress, "http3", s.config.HTTP3Enabled, "tls_domain", s.config.TLSDomains)
 	} else {
 		s.httpsServer = nil
+		s.http3Server = nil
 		s.httpServer = s.defaultHttpServer(httpAddress)
 		s.httpServer.Handler = s.handler
 
-		go s.httpServer.ListenAndServe()
+		httpListener, err := s.listen(httpAddress)
+		if err != nil {
+			return err
+		}
+
+		go s.httpServer.Serve(httpListener)
 
 		slog.Info("Server started", "http", httpAddress)
 	}
+
+	return nil
 }
 
 func (s *Server) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
+
+	s.Shutdown(ctx)
+}
+
+// Shutdown stops accepting connections, and then waits for the requests in
+// progress to finish, or for ctx to be done. The listeners are all shut down
+// at once, so that none go on accepting while we wait for another.
+func (s *Server) Shutdown(ctx context.Context) error {
 	defer slog.Info("Server stopped")
 
 	slog.Info("Server stopping")
 
-	s.httpServer.Shutdown(ctx)
+	shutdowns := []func(context.Context) error{}
+	if s.httpServer != nil {
+		shutdowns = append(shutdowns, s.httpServer.Shutdown)
+	}
 	if s.httpsServer != nil {
-		s.httpsServer.Shutdown(ctx)
+		shutdowns = append(shutdowns, s.httpsServer.Shutdown)
+	}
+	if s.http3Server != nil {
+		shutdowns = append(shutdowns, s.http3Server.Shutdown)
+	}
+
+	errs := make([]error, len(shutdowns))
+
+	var wg sync.WaitGroup
+	for i, shutdown := range shutdowns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = shutdown(ctx)
+		}()
 	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (s *Server) httpsHandler() http.Handler {
+	if s.config.HSTSMaxAge <= 0 {
+		return s.handler
+	}
+
+	return NewHSTSMiddleware(s.config.HSTSMaxAge, s.config.HSTSIncludeSubDomains, s.config.HSTSPreload, s.handler)
 }
 
 func (s *Server) certManager() *autocert.Manager {
@@ -103,13 +191,31 @@ func (s *Server) externalAccountBinding() *acme.ExternalAccountBinding {
 
 func (s *Server) defaultHttpServer(addr string) *http.Server {
 	return &http.Server{
-		Addr:         addr,
-		IdleTimeout:  s.config.HttpIdleTimeout,
-		ReadTimeout:  s.config.HttpReadTimeout,
-		WriteTimeout: s.config.HttpWriteTimeout,
+		Addr:              addr,
+		IdleTimeout:       s.config.HttpIdleTimeout,
+		ReadTimeout:       s.config.HttpReadTimeout,
+		ReadHeaderTimeout: s.config.HttpReadHeaderTimeout,
+		WriteTimeout:      s.config.HttpWriteTimeout,
+		MaxHeaderBytes:    s.config.HttpMaxHeaderBytes,
 	}
 }
 
+// listen binds a TCP listener, which accepts no more than the configured
+// number of connections at once. Further connections wait in the backlog
+// until one closes.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.HttpMaxConnections > 0 {
+		listener = netutil.LimitListener(listener, s.config.HttpMaxConnections)
+	}
+
+	return listener, nil
+}
+
 func httpRedirectHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "close")
 
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestServer_certManager(t *testing.T) {
+	config := &Config{
+		TLSDomains:       []string{"example.com", "www.example.com"},
+		ACMEDirectoryURL: "https://acme.example.com/directory",
+		StoragePath:      t.TempDir(),
+	}
+
+	manager := NewServer(config, nil).certManager()
+
+	t.Run("only requests certificates for the configured domains", func(t *testing.T) {
+		assert.NoError(t, manager.HostPolicy(context.Background(), "example.com"))
+		assert.NoError(t, manager.HostPolicy(context.Background(), "www.example.com"))
+		assert.Error(t, manager.HostPolicy(context.Background(), "other.example.com"))
+	})
+
+	t.Run("stores certificates in the storage path", func(t *testing.T) {
+		assert.Equal(t, autocert.DirCache(config.StoragePath), manager.Cache)
+		assert.Equal(t, config.ACMEDirectoryURL, manager.Client.DirectoryURL)
+	})
+
+	t.Run("answers TLS-ALPN challenges", func(t *testing.T) {
+		assert.Contains(t, manager.TLSConfig().NextProtos, acme.ALPNProto)
+	})
+}
+
+func TestServer_externalAccountBinding(t *testing.T) {
+	config := &Config{EAB_KID: "kid", EAB_HMACKey: "c2VjcmV0"}
+	binding := NewServer(config, nil).externalAccountBinding()
+
+	assert.Equal(t, "kid", binding.KID)
+	assert.Equal(t, []byte("secret"), binding.Key)
+
+	config = &Config{EAB_KID: "kid", EAB_HMACKey: "not base64!"}
+	assert.Nil(t, NewServer(config, nil).externalAccountBinding())
+}
+
+func TestServer_httpRedirectHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com:80/path?q=1", nil)
+	httpRedirectHandler(w, r)
+
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "https://example.com/path?q=1", w.Header().Get("Location"))
+}
+
+func TestServer_httpsHandler_adds_hsts_when_configured(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	server := NewServer(&Config{}, handler)
+	assert.IsType(t, http.HandlerFunc(nil), server.httpsHandler())
+
+	server = NewServer(&Config{HSTSMaxAge: time.Hour}, handler)
+	assert.IsType(t, &HSTSMiddleware{}, server.httpsHandler())
+}
+
+func TestServer_defaultHttpServer_applies_limits(t *testing.T) {
+	config := &Config{
+		HttpIdleTimeout:       time.Minute,
+		HttpReadTimeout:       30 * time.Second,
+		HttpReadHeaderTimeout: 5 * time.Second,
+		HttpWriteTimeout:      20 * time.Second,
+		HttpMaxHeaderBytes:    8 * KB,
+	}
+
+	server := NewServer(config, nil).defaultHttpServer(":80")
+
+	assert.Equal(t, time.Minute, server.IdleTimeout)
+	assert.Equal(t, 30*time.Second, server.ReadTimeout)
+	assert.Equal(t, 5*time.Second, server.ReadHeaderTimeout)
+	assert.Equal(t, 20*time.Second, server.WriteTimeout)
+	assert.Equal(t, 8*KB, server.MaxHeaderBytes)
+}
+
+func TestServer_closes_connections_that_are_slow_to_send_headers(t *testing.T) {
+	server := NewServer(&Config{HttpReadHeaderTimeout: 100 * time.Millisecond}, nil).defaultHttpServer("")
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = bufio.NewReader(conn).ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServer_listen_limits_connections(t *testing.T) {
+	server := NewServer(&Config{HttpMaxConnections: 1}, nil)
+
+	listener, err := server.listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	first, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	conn := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("accepted a connection over the limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	conn.Close()
+	select {
+	case conn = <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't accept a connection once another closed")
+	}
+}
+
+func TestServer_refuses_oversized_headers(t *testing.T) {
+	server := NewServer(&Config{HttpMaxHeaderBytes: 1 * KB}, nil).defaultHttpServer("")
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	req, err := http.NewRequest("GET", "http://"+listener.Addr().String()+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Padding", strings.Repeat("a", 16*KB))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+}
@@ -1,67 +1,612 @@
 package internal
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/url"
 	"os"
+	"path/filepath"
 )
 
 type Service struct {
-	config *Config
+	config          *Config
+	upstream        *UpstreamProcess
+	upstreamStarted bool
+	upstreams       *UpstreamPool
+	lifecycle       *Lifecycle
+	handler         *PolicyHandler
+	options         HandlerOptions
+	tlsFingerprints *TLSFingerprints
+	policies        *PolicySource
+	recentClients   *RecentClients
+	maintenanceMode *MaintenanceMode
+	blocklistStats  *BlocklistStats
+	countryStats    *CountryStats
+	decisions       *Decisions
+	events          *EventDispatcher
+	configChanges   *ConfigChangeLog
 }
 
 func NewService(config *Config) *Service {
-	return &Service{
-		config: config,
+	service := &Service{
+		config:    config,
+		lifecycle: NewLifecycle(config.ShutdownDrainTimeout),
+		policies:  NewPolicySource(PolicyFromConfig(config)),
 	}
+	service.lifecycle.OnReload(service.reload)
+
+	if config.MaintenanceFile != "" || config.AdminAddress != "" {
+		service.maintenanceMode = NewMaintenanceMode(config.MaintenanceRetryAfter)
+	}
+
+	if len(config.WebhookURLs) > 0 {
+		service.events = NewEventDispatcher(config.WebhookURLs, config.WebhookEvents, config.WebhookSecret, config.WebhookBatchSize, config.WebhookFlushInterval)
+	}
+
+	if len(config.BlocklistFeeds) > 0 {
+		service.blocklistStats = NewBlocklistStats()
+	}
+
+	if config.GeoIP2Enabled && config.CountryStatsEnabled && (config.AdminAddress != "" || config.AdminGRPCAddress != "") {
+		service.countryStats = NewCountryStats()
+	}
+
+	if config.AdminGRPCAddress != "" {
+		service.decisions = NewDecisions()
+	}
+
+	if config.GeoIP2Enabled && config.GeoIP2UpgradeSampleSize > 0 {
+		service.recentClients = NewRecentClients(config.GeoIP2UpgradeSampleSize)
+	}
+
+	if config.ClientFingerprintSecret != "" {
+		service.tlsFingerprints = NewTLSFingerprints()
+	}
+
+	return service
 }
 
+// Run brings the service up in a fixed sequence of phases (databases, then
+// the upstream process, then listeners) and blocks until the upstream exits,
+// or until we're asked to shut down.
 func (s *Service) Run() int {
-	handlerOptions := HandlerOptions{
-		cache:                    s.cache(),
-		targetUrl:                s.targetUrl(),
+	var geoResolver *GeoResolver
+	var server *Server
+	var startup *Startup
+
+	s.lifecycle.Notify()
+	defer s.lifecycle.Close()
+
+	s.recordConfigChanges()
+
+	// Events are sent until the very end, so that nothing is missed while
+	// shutting down
+	if s.events != nil {
+		s.events.Start()
+		s.lifecycle.OnShutdown("events", s.events.Stop)
+	}
+
+	s.upstream = NewUpstreamProcess(s.config.UpstreamCommand, s.config.UpstreamArgs...)
+	s.upstreams = NewUpstreamPool(s.targetUrls(), s.config.LoadBalancing)
+	if s.config.CircuitBreakerThreshold > 0 {
+		s.upstreams.SetCircuitBreakers(s.config.CircuitBreakerThreshold, s.config.CircuitBreakerCooldown, s.events)
+	}
+	s.lifecycle.OnShutdown("health_checks", func() error {
+		s.upstreams.Stop()
+		return nil
+	})
+
+	phases := []StartupPhase{
+		{
+			Name:     "databases",
+			Required: len(s.config.GeoIP2DatabasePaths) > 0,
+			Timeout:  s.config.StartupDatabaseTimeout,
+			Run: func(ctx context.Context) error {
+				if !s.config.GeoIP2Enabled {
+					return nil
+				}
+
+				resolver, err := OpenGeoIP2Databases(s.config.GeoIP2Provider, s.config.GeoIP2DatabasePaths)
+				if err != nil {
+					return err
+				}
+				if ctx.Err() != nil {
+					if resolver != nil {
+						resolver.Close()
+					}
+					return ctx.Err()
+				}
+
+				geoResolver = resolver
+				if resolver != nil {
+					s.lifecycle.OnShutdown("geoip", resolver.Close)
+
+					if len(s.config.Geofences) > 0 && !resolver.CanLocate() {
+						slog.Warn("GEOFENCES needs a City database to locate clients; without one, allow fences refuse everyone")
+					}
+
+					for _, database := range resolver.Databases() {
+						s.events.Publish(EventDatabaseLoaded, map[string]any{
+							"path":          database.Path,
+							"database_type": database.DatabaseType,
+							"build_date":    database.BuildDate,
+						})
+					}
+				}
+				return nil
+			},
+		},
+		s.blocklistsPhase(),
+		{
+			Name:     "upstream",
+			Required: true,
+			Run: func(ctx context.Context) error {
+				return s.startUpstream()
+			},
+		},
+	}
+
+	if s.config.WaitForUpstream {
+		phases = append(phases, StartupPhase{
+			Name:    "upstream_health",
+			Timeout: s.config.StartupUpstreamTimeout,
+			Run: func(ctx context.Context) error {
+				return waitForUpstreams(ctx, s.targetUrls())
+			},
+		})
+	}
+
+	phases = append(phases, StartupPhase{
+		Name:     "listeners",
+		Required: true,
+		Run: func(ctx context.Context) error {
+			if s.config.HealthCheck.Enabled() {
+				s.upstreams.StartHealthChecks(s.config.HealthCheck, createProxyTransport(s.config.TargetProtocol, s.config.UpstreamTimeouts, s.config.UpstreamTLSConfig, s.upstreams))
+			}
+
+			options := s.handlerOptions(geoResolver, startup)
+			s.options = options
+			s.handler = NewPolicyHandler(s.policies, buildHandler(options))
+
+			server = NewServer(s.config, s.handler)
+			server.SetTLSFingerprints(s.tlsFingerprints)
+			err := server.Start()
+			if err != nil {
+				return err
+			}
+
+			if s.config.AdminGRPCAddress != "" {
+				admin := NewAdminGRPCServer(s.config.AdminGRPCAddress, s.config.AdminToken, s.policies, s.upstreams, startup)
+				admin.SetGeoIP2(geoResolver)
+				admin.SetBans(options.bans)
+				admin.SetCountryStats(s.countryStats)
+				admin.SetBlocklistStats(s.blocklistStats)
+				admin.SetDecisions(s.decisions)
+				if s.config.ReplicaOf != nil {
+					admin.SetReplica()
+				}
+				err = admin.Start()
+				if err != nil {
+					server.Stop()
+					return err
+				}
+				s.lifecycle.OnShutdown("admin_grpc", admin.Stop)
+			}
+
+			if s.config.AdminAddress != "" {
+				admin := NewAdminServer(s.config.AdminAddress, s.config.AdminToken, s.policies)
+				admin.SetGeoIP2(geoResolver, s.recentClients, float64(s.config.GeoIP2UpgradeSuspiciousPercent))
+				admin.SetMaintenanceMode(s.maintenanceMode)
+				admin.SetBlocklistStats(s.blocklistStats)
+				admin.SetCountryStats(s.countryStats)
+				admin.SetBans(options.bans)
+				admin.SetConfigChanges(s.configChanges)
+				if s.config.ReplicaOf != nil {
+					admin.SetReplica()
+				}
+				if s.config.AdminDebug {
+					admin.SetDebug(options.cacheStats)
+				}
+				err = admin.Start()
+				if err != nil {
+					server.Stop()
+					return err
+				}
+				s.lifecycle.OnShutdown("admin", admin.Stop)
+			}
+
+			if s.config.ReplicaOf != nil {
+				replica := NewPolicyReplica(s.config.ReplicaOf, s.config.AdminToken, s.policies)
+				replica.Start()
+				s.lifecycle.OnShutdown("replica", replica.Stop)
+			}
+
+			return nil
+		},
+	})
+
+	startup = NewStartup(phases...)
+	err := startup.Run(context.Background())
+	if err != nil {
+		if s.upstreamStarted {
+			s.upstream.Stop()
+			s.upstream.Wait()
+		}
+		return 1
+	}
+
+	exitCode, err := s.lifecycle.Run(server, s.upstream)
+	if err != nil {
+		slog.Error("Wrapped process failed", "command", s.config.UpstreamCommand, "args", s.config.UpstreamArgs, "error", err)
+		return 1
+	}
+
+	return exitCode
+}
+
+// Stop begins a graceful shutdown, which in turn causes Run to return.
+func (s *Service) Stop() {
+	s.lifecycle.Stop()
+}
+
+// Private
+
+func (s *Service) handlerOptions(geoResolver *GeoResolver, startup *Startup) HandlerOptions {
+	budget := s.memoryBudget()
+	stats := s.cacheStats()
+	upstreamStats := s.upstreamStats()
+
+	options := HandlerOptions{
+		cache:                    s.cache(budget, stats),
+		cacheStats:               stats,
+		upstreamStats:            upstreamStats,
+		upstreamTransport:        NewUpstreamTransport(s.upstreams, s.config.TargetProtocol, s.config.UpstreamTimeouts, s.config.UpstreamTLSConfig, upstreamStats),
+		cachePolicy:              s.cachePolicy(),
+		store:                    s.store(),
+		upstreams:                s.upstreams,
+		targetHost:               s.config.TargetHost,
+		upstreamRetry:            s.config.UpstreamRetry,
 		xSendfileEnabled:         s.config.XSendfileEnabled,
-		gzipCompressionEnabled:   s.config.GzipCompressionEnabled,
+		staticFilesRoot:          s.config.StaticFilesRoot,
+		staticFilesPaths:         s.config.StaticFilesPaths,
+		compressionEnabled:       s.config.CompressionEnabled,
+		compression:              s.config.Compression,
 		maxCacheableResponseBody: s.config.MaxCacheItemSizeBytes,
+		cachePurgeToken:          s.config.CachePurgeToken,
+		cacheVaryHeaders:         s.config.CacheVaryHeaders,
 		maxRequestBody:           s.config.MaxRequestBody,
+		idempotencyWindow:        s.config.IdempotencyWindow,
+		writeIdleTimeout:         s.config.HttpWriteIdleTimeout,
 		badGatewayPage:           s.config.BadGatewayPage,
+		blockedPage:              s.config.BlockedPage,
+		maintenancePage:          s.config.MaintenancePage,
+		pages:                    s.pages(),
 		forwardHeaders:           s.config.ForwardHeaders,
+		trustedProxies:           s.config.TrustedProxies,
+		forwardedHeader:          s.config.ForwardedHeaderEnabled,
+		stickySessions:           s.config.StickySessions,
 		logRequests:              s.config.LogRequests,
-		geoIP2Enabled:            s.config.GeoIP2Enabled,
-		allowCountries:           s.config.AllowCountries,
-		blockCountries:           s.config.BlockCountries,
+		requestIDHeader:          s.requestIDHeader(),
+		accessLog:                s.accessLog(),
+		auditLog:                 s.auditLog(),
+		decisions:                s.decisions,
+		geoResolver:              geoResolver,
+		recentClients:            s.recentClients,
+		blockPolicies:            BlockPolicies{Options: s.config.BlockedOptionsPolicy, Head: s.config.BlockedHeadPolicy},
+		geoHeaders:               s.config.GeoHeaders,
+		geoBypassTokens:          s.config.GeoBypassTokens,
+		geoBypassDuration:        s.config.GeoBypassDuration,
+		countryStats:             s.countryStats,
+		featureHeaders:           s.config.FeatureHeaders,
+		headerRules:              s.config.HeaderRules,
+		bodyInspectionMaxSize:    s.config.BodyInspectionMaxSize,
+		blocklistStats:           s.blocklistStats,
+		maintenanceWindows:       s.config.MaintenanceWindows,
+		maintenanceMode:          s.maintenance(),
+		maintenanceAllowCIDRs:    s.config.MaintenanceAllowCIDRs,
+		clientFingerprintSecret:  s.config.ClientFingerprintSecret,
+		tlsFingerprints:          s.tlsFingerprints,
+		events:                   s.events,
+		cookieScope:              NewCookieScope(s.config.CookieScope, s.config.CookieDomains),
+		clientRateLimiter:        NewRateLimiter(budget),
+		countryRateLimiter:       NewRateLimiter(budget),
+	}
+
+	if s.config.CachePurgeToken != "" {
+		options.cachePurger = NewCachePurger()
+	}
+
+	if s.config.StartupFailClosed {
+		options.startupGate = startup
+	}
+
+	options.challenge = NewChallenge(s.config.ChallengeSecret, s.config.ChallengeDifficulty, s.config.ChallengeDuration, options.pages, options.cookieScope)
+	options.challenge.SetPage(options.pages.LoadIfExists(s.config.ChallengePage))
+	if s.config.ChallengeCaptchaVerifyURL != "" {
+		options.challenge.SetCaptcha(s.config.ChallengeCaptchaVerifyURL, s.config.ChallengeCaptchaSecret)
+	}
+
+	if s.config.BanThreshold > 0 {
+		options.bans = NewBans(options.store, s.config.BanThreshold, s.config.BanWindow, s.config.BanDuration)
+		options.banStatuses = s.config.BanStatuses
+		options.bans.SetEvents(s.events)
+	}
+
+	options.healthPath = s.config.HealthPath
+	options.readyPath = s.config.ReadyPath
+	options.readinessChecks = []ReadinessCheck{
+		startupReadiness(startup),
+		geoIP2Readiness(s.config.GeoIP2Enabled, geoResolver != nil),
+		upstreamReadiness(s.upstreams, s.config.HealthCheck.Enabled()),
+		cacheReadiness(options.cache),
+	}
+
+	return options
+}
+
+func (s *Service) requestIDHeader() string {
+	if !s.config.RequestIDEnabled {
+		return ""
+	}
+	return s.config.RequestIDHeader
+}
+
+// accessLog opens the access log, when one is configured. Without one, requests
+// are logged along with everything else.
+func (s *Service) accessLog() *AccessLog {
+	if !s.config.LogRequests {
+		return nil
+	}
+	if s.config.AccessLogPath == "" && s.config.AccessLogFormat == AccessLogFormatDefault {
+		return nil
+	}
+
+	var out io.Writer = os.Stdout
+	if s.config.AccessLogPath != "" {
+		file, err := NewRotatingFile(s.config.AccessLogPath, int64(s.config.AccessLogMaxSize), s.config.AccessLogRotateInterval, s.config.AccessLogMaxFiles)
+		if err != nil {
+			slog.Error("Unable to open access log; logging requests to stdout instead", "path", s.config.AccessLogPath, "error", err)
+		} else {
+			s.lifecycle.OnShutdown("access_log", file.Close)
+			out = file
+		}
 	}
 
-	handler := NewHandler(handlerOptions)
-	server := NewServer(s.config, handler)
-	upstream := NewUpstreamProcess(s.config.UpstreamCommand, s.config.UpstreamArgs...)
+	return NewAccessLog(s.config.AccessLogFormat, out, s.config.AccessLogGeoFields)
+}
+
+// auditLog opens the audit log, when one is configured.
+func (s *Service) auditLog() *AuditLog {
+	if !s.config.AuditLog.Enabled() {
+		return nil
+	}
+
+	out, err := s.config.AuditLog.Open()
+	if err != nil {
+		slog.Error("Unable to open audit log", "destination", s.config.AuditLog.String(), "error", err)
+		return nil
+	}
+	s.lifecycle.OnShutdown("audit_log", out.Close)
 
-	server.Start()
-	defer server.Stop()
+	return NewAuditLog(out)
+}
 
+func (s *Service) startUpstream() error {
 	s.setEnvironment()
 
-	exitCode, err := upstream.Run()
+	err := s.upstream.Start()
 	if err != nil {
 		slog.Error("Failed to start wrapped process", "command", s.config.UpstreamCommand, "args", s.config.UpstreamArgs, "error", err)
-		return 1
+		return err
 	}
 
-	return exitCode
+	s.upstreamStarted = true
+
+	if s.config.InitEnabled && RunningAsInit() {
+		initProcess := NewInitProcess(s.upstream)
+		initProcess.Start()
+		s.lifecycle.OnShutdown("init", initProcess.Stop)
+	}
+
+	return nil
 }
 
-// Private
+func (s *Service) cache(budget *MemoryBudget, stats *CacheStats) Cache {
+	var cache Cache
+	var err error
+
+	switch s.config.CacheBackend {
+	case CacheBackendDisk:
+		var diskCache *DiskCache
+		diskCache, err = NewDiskCache(s.config.CacheDiskPath, s.config.CacheSizeBytes, s.config.MaxCacheItemSizeBytes)
+		if err == nil {
+			diskCache.SetMaxEntries(s.config.CacheMaxEntries)
+			diskCache.SetEviction(s.config.CacheEviction)
+			diskCache.SetStats(stats)
+			cache = diskCache
+		}
+	case CacheBackendRedis:
+		cache, err = NewRedisCache(s.config.CacheRedisURL, s.config.CacheRedisPrefix, s.config.MaxCacheItemSizeBytes)
+	}
 
-func (s *Service) cache() Cache {
-	return NewMemoryCache(s.config.CacheSizeBytes, s.config.MaxCacheItemSizeBytes)
+	if err != nil {
+		slog.Error("Unable to open cache; using memory instead", "backend", s.config.CacheBackend, "error", err)
+		cache = nil
+	}
+
+	if cache == nil {
+		memoryCache := NewMemoryCache(s.config.CacheSizeBytes, s.config.MaxCacheItemSizeBytes)
+		memoryCache.SetMemoryBudget(budget)
+		memoryCache.SetMaxEntries(s.config.CacheMaxEntries)
+		memoryCache.SetEviction(s.config.CacheEviction)
+		memoryCache.SetStats(stats)
+		cache = memoryCache
+	}
+
+	s.lifecycle.OnShutdown("cache", cache.Close)
+	return cache
+}
+
+// store opens the state store. As with the cache, if it can't be opened we
+// carry on with one in memory rather than refuse to start.
+// store opens the STATE_STORE, but only when something keeps its state there.
+// Otherwise memory will do, and a bolt file is left for another instance to
+// open.
+func (s *Service) store() Store {
+	var store Store
+	var err error
+
+	backend := s.config.StateStore
+	if !s.config.UsesStateStore() {
+		backend = StoreBackendMemory
+	}
+
+	switch backend {
+	case StoreBackendBolt:
+		store, err = NewBoltStore(s.config.StateStorePath)
+	case StoreBackendRedis:
+		store, err = NewRedisStore(s.config.StateRedisURL)
+	}
+
+	if err != nil {
+		slog.Error("Unable to open state store; using memory instead", "backend", s.config.StateStore, "error", err)
+		store = nil
+	}
+
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	s.lifecycle.OnShutdown("store", store.Close)
+	return store
+}
+
+// recordConfigChanges logs how the configuration differs from the previous
+// run, and keeps a record of it in the storage path.
+func (s *Service) recordConfigChanges() {
+	if s.config.ConfigChangeLogSize <= 0 {
+		return
+	}
+
+	if s.configChanges == nil {
+		path := filepath.Join(s.config.StoragePath, configChangesFileName)
+		s.configChanges = NewConfigChangeLog(path, s.config.ConfigChangeLogSize)
+	}
+
+	s.configChanges.Record(StateFromConfig(s.config))
+}
+
+func (s *Service) pages() *Pages {
+	pages := NewPages(s.config.SupportURL)
+
+	if s.config.PageLocalesPath != "" {
+		err := pages.LoadLocales(s.config.PageLocalesPath)
+		if err != nil {
+			slog.Error("Unable to load page translations", "path", s.config.PageLocalesPath, "error", err)
+		}
+	}
+
+	return pages
+}
+
+func (s *Service) cachePolicy() *CachePolicy {
+	if len(s.config.CacheRules) == 0 && !s.config.CacheSkipSetCookie {
+		return nil
+	}
+	return NewCachePolicy(s.config.CacheRules, s.config.CacheDefaultTTL, s.config.CacheSkipSetCookie)
+}
+
+func (s *Service) cacheStats() *CacheStats {
+	stats := NewCacheStats()
+
+	if s.config.CacheStatsInterval > 0 {
+		stats.StartLogging(s.config.CacheStatsInterval)
+		s.lifecycle.OnShutdown("cache_stats", func() error {
+			stats.Stop()
+			return nil
+		})
+	}
+
+	return stats
+}
+
+func (s *Service) upstreamStats() *UpstreamStats {
+	stats := NewUpstreamStats(s.config.UpstreamLeakThreshold)
+
+	if s.config.UpstreamStatsInterval > 0 {
+		stats.StartLogging(s.config.UpstreamStatsInterval)
+		s.lifecycle.OnShutdown("upstream_stats", func() error {
+			stats.Stop()
+			return nil
+		})
+	}
+
+	return stats
+}
+
+// blocklistsPhase fetches the blocklist feeds, and keeps them up to date from
+// then on. It's deferred, as the feeds can be slow to fetch. Replicas skip it,
+// as they take the lists from their primary along with the rest of the policy.
+func (s *Service) blocklistsPhase() StartupPhase {
+	return StartupPhase{
+		Name:     "blocklists",
+		Timeout:  s.config.StartupDatabaseTimeout,
+		Deferred: true,
+		Run: func(ctx context.Context) error {
+			if len(s.config.BlocklistFeeds) == 0 || s.config.ReplicaOf != nil {
+				return nil
+			}
+
+			feeds := NewBlocklistFeeds(s.config.BlocklistFeeds, s.config.BlocklistRefreshInterval, s.policies, s.blocklistStats)
+			feeds.SetEvents(s.events)
+			err := feeds.Refresh(ctx)
+
+			feeds.Start()
+			s.lifecycle.OnShutdown("blocklists", feeds.Stop)
+
+			return err
+		},
+	}
+}
+
+// maintenance starts watching for the maintenance file, when there is one.
+func (s *Service) maintenance() *MaintenanceMode {
+	if s.config.MaintenanceFile != "" {
+		s.maintenanceMode.WatchFile(s.config.MaintenanceFile, maintenanceFilePollInterval)
+		s.lifecycle.OnShutdown("maintenance_file", func() error {
+			s.maintenanceMode.Stop()
+			return nil
+		})
+	}
+
+	return s.maintenanceMode
+}
+
+func (s *Service) memoryBudget() *MemoryBudget {
+	if s.config.MemoryBudgetBytes <= 0 {
+		return nil
+	}
+
+	slog.Info("Limiting in-memory structures to memory budget", "budget", s.config.MemoryBudgetBytes, "low_memory_mode", s.config.LowMemoryMode)
+	return NewMemoryBudget(s.config.MemoryBudgetBytes)
 }
 
 func (s *Service) targetUrl() *url.URL {
-	url, _ := url.Parse(fmt.Sprintf("http://localhost:%d", s.config.TargetPort))
+	url, _ := url.Parse(fmt.Sprintf("%s://localhost:%d", s.config.TargetProtocol.Scheme(), s.config.TargetPort))
 	return url
 }
 
+// targetUrls lists the upstreams to proxy to. Unless others are configured,
+// that's just the process we're running.
+func (s *Service) targetUrls() []*url.URL {
+	if len(s.config.TargetURLs) > 0 {
+		return s.config.TargetURLs
+	}
+	return []*url.URL{s.targetUrl()}
+}
+
 func (s *Service) setEnvironment() {
 	// Set PORT to be inherited by the upstream process.
 	os.Setenv("PORT", fmt.Sprintf("%d", s.config.TargetPort))
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_returns_upstream_exit_code(t *testing.T) {
+	service := NewService(testServiceConfig("sh", "-c", "exit 3"))
+	assert.Equal(t, 3, service.Run())
+}
+
+func TestService_fails_when_upstream_cannot_start(t *testing.T) {
+	service := NewService(testServiceConfig("/nonexistent/command"))
+	assert.Equal(t, 1, service.Run())
+}
+
+func TestService_waits_for_upstream_health(t *testing.T) {
+	config := testServiceConfig("sh", "-c", "trap 'exit 0' TERM; while true; do sleep 0.01; done")
+	config.WaitForUpstream = true
+	config.StartupUpstreamTimeout = 50 * time.Millisecond
+
+	// The upstream never listens, but the health phase is optional, so we
+	// still start up and run until the upstream exits.
+	service := NewService(config)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		service.Stop()
+	}()
+
+	assert.Equal(t, 0, service.Run())
+}
+
+func TestService_only_opens_the_state_store_when_used(t *testing.T) {
+	config := testServiceConfig("true")
+	config.StateStore = StoreBackendBolt
+	config.StateStorePath = filepath.Join(t.TempDir(), "state.db")
+
+	store := NewService(config).store()
+	defer store.Close()
+	assert.IsType(t, &MemoryStore{}, store)
+	assert.NoFileExists(t, config.StateStorePath)
+
+	config.IdempotencyWindow = time.Minute
+
+	store = NewService(config).store()
+	defer store.Close()
+	assert.IsType(t, &BoltStore{}, store)
+	assert.FileExists(t, config.StateStorePath)
+}
+
+// Helpers
+
+func testServiceConfig(command string, args ...string) *Config {
+	return &Config{
+		TargetPort:             0,
+		UpstreamCommand:        command,
+		UpstreamArgs:           args,
+		CacheSizeBytes:         defaultCacheSize,
+		MaxCacheItemSizeBytes:  defaultMaxCacheItemSizeBytes,
+		HttpPort:               0,
+		StartupDatabaseTimeout: defaultStartupDatabaseTimeout,
+	}
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const upstreamHealthPollInterval = 100 * time.Millisecond
+
+type StartupPhaseFunc func(ctx context.Context) error
+
+// StartupPhase is one step in bringing the service up. Phases run in the order
+// they are given; a failing required phase aborts startup, while a failing
+// optional one is logged and skipped.
+//
+// Deferred phases are started in order but not waited for, which allows
+// listeners to come up before slow, optional dependencies are warm.
+type StartupPhase struct {
+	Name     string
+	Timeout  time.Duration
+	Required bool
+	Deferred bool
+	Run      StartupPhaseFunc
+}
+
+type Startup struct {
+	phases  []StartupPhase
+	pending atomic.Int32
+}
+
+func NewStartup(phases ...StartupPhase) *Startup {
+	return &Startup{phases: phases}
+}
+
+func (s *Startup) Run(ctx context.Context) error {
+	for _, phase := range s.phases {
+		if phase.Deferred {
+			s.pending.Add(1)
+			go func() {
+				defer s.pending.Add(-1)
+				s.runPhase(ctx, phase)
+			}()
+			continue
+		}
+
+		err := s.runPhase(ctx, phase)
+		if err != nil && phase.Required {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Warm reports whether every deferred phase has finished.
+func (s *Startup) Warm() bool {
+	return s.pending.Load() == 0
+}
+
+// Private
+
+func (s *Startup) runPhase(ctx context.Context, phase StartupPhase) error {
+	started := time.Now()
+	slog.Debug("Startup: phase starting", "phase", phase.Name)
+
+	if phase.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, phase.Timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- phase.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("startup phase %s timed out after %s", phase.Name, phase.Timeout)
+	}
+
+	if err != nil {
+		if phase.Required {
+			slog.Error("Startup: phase failed", "phase", phase.Name, "error", err)
+		} else {
+			slog.Warn("Startup: optional phase failed", "phase", phase.Name, "error", err)
+		}
+		return err
+	}
+
+	slog.Info("Startup: phase complete", "phase", phase.Name, "dur", time.Since(started).Milliseconds())
+	return nil
+}
+
+// StartupGateMiddleware refuses requests until all deferred startup phases
+// are complete, so that we fail closed rather than serving traffic that
+// hasn't been through every check.
+type StartupGateMiddleware struct {
+	startup *Startup
+	next    http.Handler
+}
+
+func NewStartupGateMiddleware(startup *Startup, next http.Handler) *StartupGateMiddleware {
+	return &StartupGateMiddleware{
+		startup: startup,
+		next:    next,
+	}
+}
+
+func (m *StartupGateMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !m.startup.Warm() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service starting", http.StatusServiceUnavailable)
+		return
+	}
+
+	m.next.ServeHTTP(w, r)
+}
+
+// waitForUpstreams polls until one of the upstreams accepts connections, as
+// the readiness check does when there are no health checks.
+func waitForUpstreams(ctx context.Context, targets []*url.URL) error {
+	dialer := net.Dialer{Timeout: upstreamHealthPollInterval}
+
+	for {
+		for _, target := range targets {
+			conn, err := dialer.DialContext(ctx, upstreamNetwork(target.Scheme), upstreamAddress(target))
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(upstreamHealthPollInterval):
+		}
+	}
+}
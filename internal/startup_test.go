@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartup_runs_phases_in_order(t *testing.T) {
+	order := []string{}
+	phase := func(name string) StartupPhase {
+		return StartupPhase{Name: name, Required: true, Run: func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}}
+	}
+
+	err := NewStartup(phase("config"), phase("databases"), phase("listeners")).Run(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"config", "databases", "listeners"}, order)
+}
+
+func TestStartup_required_phase_failure_aborts(t *testing.T) {
+	ran := false
+
+	err := NewStartup(
+		StartupPhase{Name: "databases", Required: true, Run: func(ctx context.Context) error { return errors.New("boom") }},
+		StartupPhase{Name: "listeners", Required: true, Run: func(ctx context.Context) error { ran = true; return nil }},
+	).Run(context.Background())
+
+	assert.Error(t, err)
+	assert.False(t, ran)
+}
+
+func TestStartup_optional_phase_failure_continues(t *testing.T) {
+	ran := false
+
+	err := NewStartup(
+		StartupPhase{Name: "databases", Run: func(ctx context.Context) error { return errors.New("boom") }},
+		StartupPhase{Name: "listeners", Required: true, Run: func(ctx context.Context) error { ran = true; return nil }},
+	).Run(context.Background())
+
+	assert.NoError(t, err)
+	assert.True(t, ran)
+}
+
+func TestStartup_phase_timeout(t *testing.T) {
+	err := NewStartup(
+		StartupPhase{Name: "upstream", Required: true, Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	).Run(context.Background())
+
+	assert.ErrorContains(t, err, "timed out")
+}
+
+func TestStartup_deferred_phases_gate_requests(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	startup := NewStartup(
+		StartupPhase{Name: "reputation", Deferred: true, Run: func(ctx context.Context) error {
+			<-release
+			return nil
+		}},
+		StartupPhase{Name: "listeners", Required: true, Run: func(ctx context.Context) error { return nil }},
+	)
+	require.NoError(t, startup.Run(context.Background()))
+
+	handler := NewStartupGateMiddleware(startup, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(finished)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Eventually(t, startup.Warm, time.Second, time.Millisecond)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	<-finished
+}
+
+func TestWaitForUpstreams(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	targets := []*url.URL{{Scheme: "http", Host: address}, {Scheme: "unix", Path: socket}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, waitForUpstreams(ctx, targets))
+
+	socketListener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer socketListener.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, waitForUpstreams(ctx, targets))
+}
@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stateVersion = 1
+
+// State is the effective configuration in a canonical form, for comparing
+// what is running with what was intended.
+//
+// Options are keyed by the environment variable that sets them, with every
+// default filled in. Rules, such as the countries to block, are listed
+// individually, keyed by kind and subject, so that a change to one rule
+// shows up as just that change. Secrets are replaced by a digest, which
+// reveals whether they changed but not what they are.
+type State struct {
+	Version int               `json:"version"`
+	Options map[string]string `json:"options"`
+	Rules   map[string]string `json:"rules"`
+}
+
+func StateFromConfig(c *Config) State {
+	return State{
+		Version: stateVersion,
+		Options: stateOptions(c),
+		Rules:   stateRules(c),
+	}
+}
+
+// WriteJSON writes the state as indented JSON. Keys are sorted, so the same
+// state always produces the same output.
+func (s State) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// RunExportStateCommand implements
+// `thrust export-state [--config file] [command] [args...]`, which prints the
+// state that the proxy would run with, given the current environment.
+func RunExportStateCommand(args []string) int {
+	configFile, args, err := parseConfigFlag(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	var command string
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	config, err := newConfig(configFile, command, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	err = StateFromConfig(config).WriteJSON(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Private
+
+func stateOptions(c *Config) map[string]string {
+	targetURLs := []string{}
+	for _, target := range c.TargetURLs {
+		targetURLs = append(targetURLs, target.String())
+	}
+	webhookURLs := []string{}
+	for _, webhookURL := range c.WebhookURLs {
+		webhookURLs = append(webhookURLs, webhookURL.Redacted())
+	}
+
+	return map[string]string{
+		"TARGET_PORT":                       strconv.Itoa(c.TargetPort),
+		"TARGET_PROTOCOL":                   string(c.TargetProtocol),
+		"TARGET_URLS":                       strings.Join(targetURLs, ","),
+		"TARGET_HOST":                       c.TargetHost,
+		"LOAD_BALANCING":                    string(c.LoadBalancing),
+		"STICKY_SESSIONS":                   strconv.FormatBool(c.StickySessions.Enabled),
+		"STICKY_SESSION_COOKIE":             c.StickySessions.Cookie,
+		"STICKY_SESSION_TTL":                stateSeconds(c.StickySessions.TTL),
+		"HEALTH_CHECK_PATH":                 c.HealthCheck.Path,
+		"HEALTH_CHECK_INTERVAL":             stateSeconds(c.HealthCheck.Interval),
+		"HEALTH_CHECK_TIMEOUT":              stateSeconds(c.HealthCheck.Timeout),
+		"HEALTH_CHECK_HEALTHY_THRESHOLD":    strconv.Itoa(c.HealthCheck.HealthyThreshold),
+		"HEALTH_CHECK_UNHEALTHY_THRESHOLD":  strconv.Itoa(c.HealthCheck.UnhealthyThreshold),
+		"UPSTREAM_CONNECT_TIMEOUT":          stateSeconds(c.UpstreamTimeouts.Connect),
+		"UPSTREAM_READ_TIMEOUT":             stateSeconds(c.UpstreamTimeouts.Read),
+		"UPSTREAM_WRITE_TIMEOUT":            stateSeconds(c.UpstreamTimeouts.Write),
+		"UPSTREAM_RETRY_ATTEMPTS":           strconv.Itoa(c.UpstreamRetry.MaxAttempts),
+		"UPSTREAM_RETRY_BACKOFF_MS":         strconv.FormatInt(c.UpstreamRetry.Backoff.Milliseconds(), 10),
+		"UPSTREAM_TLS_CERT":                 c.UpstreamTLS.CertFile,
+		"UPSTREAM_TLS_KEY":                  c.UpstreamTLS.KeyFile,
+		"UPSTREAM_TLS_CA":                   c.UpstreamTLS.CAFile,
+		"UPSTREAM_TLS_SERVER_NAME":          c.UpstreamTLS.ServerName,
+		"UPSTREAM_TLS_INSECURE_SKIP_VERIFY": strconv.FormatBool(c.UpstreamTLS.InsecureSkipVerify),
+		"CIRCUIT_BREAKER_THRESHOLD":         strconv.Itoa(c.CircuitBreakerThreshold),
+		"CIRCUIT_BREAKER_COOLDOWN":          stateSeconds(c.CircuitBreakerCooldown),
+		"UPSTREAM_STATS_INTERVAL":           stateSeconds(c.UpstreamStatsInterval),
+		"UPSTREAM_LEAK_THRESHOLD":           stateSeconds(c.UpstreamLeakThreshold),
+
+		"LOW_MEMORY_MODE": strconv.FormatBool(c.LowMemoryMode),
+		"MEMORY_BUDGET":   strconv.Itoa(c.MemoryBudgetBytes),
+
+		"CACHE_BACKEND":             string(c.CacheBackend),
+		"CACHE_SIZE":                strconv.Itoa(c.CacheSizeBytes),
+		"CACHE_MAX_ENTRIES":         strconv.Itoa(c.CacheMaxEntries),
+		"CACHE_EVICTION":            string(c.CacheEviction),
+		"MAX_CACHE_ITEM_SIZE":       strconv.Itoa(c.MaxCacheItemSizeBytes),
+		"CACHE_DISK_PATH":           c.CacheDiskPath,
+		"CACHE_REDIS_URL":           stateRedactedURL(c.CacheRedisURL),
+		"CACHE_REDIS_PREFIX":        c.CacheRedisPrefix,
+		"CACHE_PURGE_TOKEN":         stateSecret(c.CachePurgeToken),
+		"CACHE_VARY_HEADERS":        strings.Join(c.CacheVaryHeaders, ","),
+		"CACHE_STATS_INTERVAL":      stateSeconds(c.CacheStatsInterval),
+		"CACHE_DEFAULT_TTL":         stateSeconds(c.CacheDefaultTTL),
+		"CACHE_SKIP_SET_COOKIE":     strconv.FormatBool(c.CacheSkipSetCookie),
+		"X_SENDFILE_ENABLED":        strconv.FormatBool(c.XSendfileEnabled),
+		"STATIC_FILES_ROOT":         c.StaticFilesRoot,
+		"STATIC_FILES_PATHS":        strings.Join(c.StaticFilesPaths, ","),
+		"COMPRESSION_ENABLED":       strconv.FormatBool(c.CompressionEnabled),
+		"COMPRESSION_ENCODINGS":     strings.Join(compressionEncodingNames(c.Compression.Encodings), ","),
+		"COMPRESSION_LEVEL":         string(c.Compression.Level),
+		"COMPRESSION_MIN_SIZE":      strconv.Itoa(c.Compression.MinSize),
+		"COMPRESSION_CONTENT_TYPES": strings.Join(c.Compression.ContentTypes, ","),
+		"MAX_REQUEST_BODY":          strconv.Itoa(c.MaxRequestBody),
+		"IDEMPOTENCY_WINDOW":        stateSeconds(c.IdempotencyWindow),
+
+		"TLS_DOMAIN":        strings.Join(c.TLSDomains, ","),
+		"ACME_DIRECTORY":    c.ACMEDirectoryURL,
+		"EAB_KID":           c.EAB_KID,
+		"EAB_HMAC_KEY":      stateSecret(c.EAB_HMACKey),
+		"STORAGE_PATH":      c.StoragePath,
+		"STATE_STORE":       string(c.StateStore),
+		"STATE_STORE_PATH":  c.StateStorePath,
+		"STATE_REDIS_URL":   stateRedactedURL(c.StateRedisURL),
+		"BAD_GATEWAY_PAGE":  c.BadGatewayPage,
+		"BLOCKED_PAGE":      c.BlockedPage,
+		"MAINTENANCE_PAGE":  c.MaintenancePage,
+		"CHALLENGE_PAGE":    c.ChallengePage,
+		"PAGE_LOCALES_PATH": c.PageLocalesPath,
+		"SUPPORT_URL":       c.SupportURL,
+
+		"HSTS_MAX_AGE":            stateSeconds(c.HSTSMaxAge),
+		"HSTS_INCLUDE_SUBDOMAINS": strconv.FormatBool(c.HSTSIncludeSubDomains),
+		"HSTS_PRELOAD":            strconv.FormatBool(c.HSTSPreload),
+		"HTTP3_ENABLED":           strconv.FormatBool(c.HTTP3Enabled),
+
+		"HTTP_PORT":                strconv.Itoa(c.HttpPort),
+		"HTTPS_PORT":               strconv.Itoa(c.HttpsPort),
+		"HTTP_IDLE_TIMEOUT":        stateSeconds(c.HttpIdleTimeout),
+		"HTTP_READ_TIMEOUT":        stateSeconds(c.HttpReadTimeout),
+		"HTTP_READ_HEADER_TIMEOUT": stateSeconds(c.HttpReadHeaderTimeout),
+		"HTTP_WRITE_TIMEOUT":       stateSeconds(c.HttpWriteTimeout),
+		"HTTP_WRITE_IDLE_TIMEOUT":  stateSeconds(c.HttpWriteIdleTimeout),
+		"HTTP_MAX_HEADER_BYTES":    strconv.Itoa(c.HttpMaxHeaderBytes),
+		"HTTP_MAX_CONNECTIONS":     strconv.Itoa(c.HttpMaxConnections),
+
+		"FORWARD_HEADERS":          strconv.FormatBool(c.ForwardHeaders),
+		"FORWARDED_HEADER_ENABLED": strconv.FormatBool(c.ForwardedHeaderEnabled),
+		"TRUSTED_PROXIES":          stateNetworks(c.TrustedProxies),
+		"HEADER_RULES_FILE":        c.HeaderRulesFile,
+		"REQUEST_ID_ENABLED":       strconv.FormatBool(c.RequestIDEnabled),
+		"REQUEST_ID_HEADER":        c.RequestIDHeader,
+
+		"HEALTH_PATH": c.HealthPath,
+		"READY_PATH":  c.ReadyPath,
+
+		"STARTUP_DATABASE_TIMEOUT": stateSeconds(c.StartupDatabaseTimeout),
+		"STARTUP_UPSTREAM_TIMEOUT": stateSeconds(c.StartupUpstreamTimeout),
+		"WAIT_FOR_UPSTREAM":        strconv.FormatBool(c.WaitForUpstream),
+		"STARTUP_FAIL_CLOSED":      strconv.FormatBool(c.StartupFailClosed),
+		"SHUTDOWN_DRAIN_TIMEOUT":   stateSeconds(c.ShutdownDrainTimeout),
+		"INIT_ENABLED":             strconv.FormatBool(c.InitEnabled),
+		"ADMIN_GRPC_ADDRESS":       c.AdminGRPCAddress,
+		"ADMIN_ADDRESS":            c.AdminAddress,
+		"ADMIN_TOKEN":              stateSecret(c.AdminToken),
+		"ADMIN_DEBUG":              strconv.FormatBool(c.AdminDebug),
+		"REPLICA_OF":               stateReplicaOf(c.ReplicaOf),
+		"CONFIG_CHANGE_LOG_SIZE":   strconv.Itoa(c.ConfigChangeLogSize),
+		"CONFIG_FILE":              c.ConfigFile,
+
+		"DEBUG":        strconv.FormatBool(c.LogLevel <= slog.LevelDebug),
+		"LOG_REQUESTS": strconv.FormatBool(c.LogRequests),
+
+		"ACCESS_LOG_FORMAT":          string(c.AccessLogFormat),
+		"ACCESS_LOG_PATH":            c.AccessLogPath,
+		"ACCESS_LOG_MAX_SIZE":        strconv.Itoa(c.AccessLogMaxSize),
+		"ACCESS_LOG_ROTATE_INTERVAL": stateSeconds(c.AccessLogRotateInterval),
+		"ACCESS_LOG_MAX_FILES":       strconv.Itoa(c.AccessLogMaxFiles),
+		"ACCESS_LOG_GEO_FIELDS":      strconv.FormatBool(c.AccessLogGeoFields),
+		"AUDIT_LOG":                  c.AuditLog.String(),
+
+		"GEOIP2_PROVIDER":                   string(c.GeoIP2Provider),
+		"GEO_HEADERS":                       c.GeoHeaders.String(),
+		"GEOIP2_DATABASE_PATH":              strings.Join(c.GeoIP2DatabasePaths, ","),
+		"GEOIP2_UPGRADE_SAMPLE_SIZE":        strconv.Itoa(c.GeoIP2UpgradeSampleSize),
+		"GEOIP2_UPGRADE_SUSPICIOUS_PERCENT": strconv.Itoa(c.GeoIP2UpgradeSuspiciousPercent),
+		"COUNTRY_STATS_ENABLED":             strconv.FormatBool(c.CountryStatsEnabled),
+		"BLOCKED_OPTIONS_POLICY":            string(c.BlockedOptionsPolicy),
+		"BLOCKED_HEAD_POLICY":               string(c.BlockedHeadPolicy),
+		"GEO_BYPASS_TOKENS":                 stateSecrets(c.GeoBypassTokens),
+		"GEO_BYPASS_DURATION":               stateSeconds(c.GeoBypassDuration),
+		"CHALLENGE_SECRET":                  stateSecret(c.ChallengeSecret),
+		"CHALLENGE_DIFFICULTY":              strconv.Itoa(c.ChallengeDifficulty),
+		"CHALLENGE_DURATION":                stateSeconds(c.ChallengeDuration),
+		"CHALLENGE_CAPTCHA_VERIFY_URL":      c.ChallengeCaptchaVerifyURL,
+		"CHALLENGE_CAPTCHA_SECRET":          stateSecret(c.ChallengeCaptchaSecret),
+		"COOKIE_SCOPE":                      string(c.CookieScope),
+		"COOKIE_DOMAINS":                    strings.Join(c.CookieDomains, ","),
+		"RATE_LIMIT":                        stateRateLimit(c.ClientRateLimit),
+		"CLIENT_FINGERPRINT_SECRET":         stateSecret(c.ClientFingerprintSecret),
+		"RISK_TAG_SCORE":                    strconv.Itoa(c.RiskThresholds.Tag),
+		"RISK_BLOCK_SCORE":                  strconv.Itoa(c.RiskThresholds.Block),
+		"BODY_INSPECTION_MAX_SIZE":          strconv.Itoa(c.BodyInspectionMaxSize),
+		"BLOCKLIST_REFRESH_INTERVAL":        stateSeconds(c.BlocklistRefreshInterval),
+		"BAN_THRESHOLD":                     strconv.Itoa(c.BanThreshold),
+		"BAN_WINDOW":                        stateSeconds(c.BanWindow),
+		"BAN_DURATION":                      stateSeconds(c.BanDuration),
+		"BAN_STATUSES":                      c.BanStatuses.String(),
+		"WEBHOOK_URLS":                      strings.Join(webhookURLs, ","),
+		"WEBHOOK_EVENTS":                    strings.Join(c.WebhookEvents, ","),
+		"WEBHOOK_SECRET":                    stateSecret(c.WebhookSecret),
+		"WEBHOOK_BATCH_SIZE":                strconv.Itoa(c.WebhookBatchSize),
+		"WEBHOOK_FLUSH_INTERVAL":            stateSeconds(c.WebhookFlushInterval),
+		"MAINTENANCE_FILE":                  c.MaintenanceFile,
+		"MAINTENANCE_RETRY_AFTER":           stateSeconds(c.MaintenanceRetryAfter),
+	}
+}
+
+func stateRules(c *Config) map[string]string {
+	rules := map[string]string{}
+
+	for _, country := range c.AllowCountries {
+		rules["allow_country:"+strings.ToUpper(country)] = "allow"
+	}
+	for _, country := range c.BlockCountries {
+		rules["block_country:"+strings.ToUpper(country)] = "block"
+	}
+	for _, country := range c.ChallengeCountries {
+		rules["challenge_country:"+strings.ToUpper(country)] = "challenge"
+	}
+	for country, limit := range c.CountryRateLimits {
+		rules["country_rate_limit:"+country] = limit.String()
+	}
+	if c.DefaultCountryRateLimit.Enabled() {
+		rules["country_rate_limit:*"] = c.DefaultCountryRateLimit.String()
+	}
+	for _, header := range c.FeatureHeaders {
+		rules["feature_header:"+header.Name] = header.String()
+	}
+	// Earlier cache rules take precedence, so they're keyed by position
+	for i, rule := range c.CacheRules {
+		rules["cache_rule:"+strconv.Itoa(i+1)] = rule.String()
+	}
+	for _, score := range c.RiskScores {
+		rules["risk_score:"+score.Key()] = strconv.Itoa(score.Weight)
+	}
+	// Body rules are keyed by position too, to tell apart rules for the same path
+	for i, rule := range c.BodyRules {
+		rules["body_rule:"+strconv.Itoa(i+1)] = rule.String()
+	}
+	// Header rules are applied in order, so they're keyed by position as well
+	for i, rule := range c.HeaderRules {
+		rules["header_rule:"+strconv.Itoa(i+1)] = rule.String()
+	}
+	for i, fence := range c.Geofences {
+		rules["geofence:"+strconv.Itoa(i+1)] = fence.String()
+	}
+	for i, window := range c.MaintenanceWindows {
+		rules["maintenance_window:"+strconv.Itoa(i+1)] = window.String()
+	}
+	for _, cidr := range c.RateLimitExemptCIDRs {
+		rules["rate_limit_exempt:"+cidr.String()] = "exempt"
+	}
+	for _, feed := range c.BlocklistFeeds {
+		rules["blocklist_feed:"+feed.Name] = feed.URL.Redacted()
+	}
+	for _, cidr := range c.MaintenanceAllowCIDRs {
+		rules["maintenance_allow:"+cidr.String()] = "allow"
+	}
+
+	return rules
+}
+
+func stateSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+func stateNetworks(networks []*net.IPNet) string {
+	values := make([]string, len(networks))
+	for i, network := range networks {
+		values[i] = network.String()
+	}
+	return strings.Join(values, ",")
+}
+
+func stateRateLimit(limit RateLimit) string {
+	if !limit.Enabled() {
+		return ""
+	}
+	return limit.String()
+}
+
+func stateReplicaOf(primary *url.URL) string {
+	if primary == nil {
+		return ""
+	}
+	return primary.Redacted()
+}
+
+func stateSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	digest := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(digest[:])[:12]
+}
+
+func stateSecrets(values []string) string {
+	secrets := []string{}
+	for _, value := range values {
+		secrets = append(secrets, stateSecret(value))
+	}
+	return strings.Join(secrets, ",")
+}
+
+func stateRedactedURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return stateSecret(value)
+	}
+	return parsed.Redacted()
+}
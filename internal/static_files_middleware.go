@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const staticFilesCacheControl = "public, max-age=31536000, immutable"
+
+type staticFileEncoding struct {
+	coding string
+	suffix string
+}
+
+// Precompressed variants of a file that we'll serve in its place, in order of
+// preference, by the coding and the suffix of the file that holds it.
+var staticFileEncodings = []staticFileEncoding{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// StaticFilesMiddleware serves files under the given path prefixes straight
+// from the root directory, without passing the request on. These are expected
+// to be fingerprinted assets, such as those under `/assets`, so they're served
+// with far-future cache headers.
+//
+// When the client accepts it, a precompressed `.br`, `.zst` or `.gz` file
+// alongside the requested one is served instead. Requests for files that don't
+// exist are passed on, so the upstream can still respond to them.
+type StaticFilesMiddleware struct {
+	root     string
+	prefixes []string
+	next     http.Handler
+}
+
+func NewStaticFilesMiddleware(root string, prefixes []string, next http.Handler) *StaticFilesMiddleware {
+	return &StaticFilesMiddleware{
+		root:     root,
+		prefixes: prefixes,
+		next:     next,
+	}
+}
+
+func (m *StaticFilesMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if !m.servesPath(name) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	filename := filepath.Join(m.root, filepath.FromSlash(name))
+	file, fi, ok := openStaticFile(filename)
+	if !ok {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	defer file.Close()
+
+	header := w.Header()
+	header.Set("Cache-Control", staticFilesCacheControl)
+
+	if variant, coding, ok := m.precompressed(filename, r); ok {
+		defer variant.file.Close()
+
+		header.Set("Content-Encoding", coding)
+		file, fi = variant.file, variant.fi
+	}
+
+	if m.hasPrecompressed(filename) {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	// ServeContent only sets the length for unencoded content
+	if header.Get("Content-Encoding") != "" && r.Header.Get("Range") == "" {
+		header.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	}
+
+	slog.Debug("Serving static file", "path", name, "encoding", header.Get("Content-Encoding"))
+
+	// The name of the requested file, rather than its variant, sets the
+	// Content-Type
+	http.ServeContent(w, r, name, fi.ModTime(), file)
+}
+
+// Private
+
+type staticFile struct {
+	file *os.File
+	fi   os.FileInfo
+}
+
+func (m *StaticFilesMiddleware) servesPath(name string) bool {
+	return slices.ContainsFunc(m.prefixes, func(prefix string) bool {
+		prefix = "/" + strings.Trim(prefix, "/")
+		return strings.HasPrefix(name, prefix+"/")
+	})
+}
+
+func (m *StaticFilesMiddleware) precompressed(filename string, r *http.Request) (staticFile, string, bool) {
+	accepted := strings.Split(normalizeAcceptEncoding(r.Header.Get("Accept-Encoding")), ",")
+
+	for _, encoding := range staticFileEncodings {
+		if !slices.Contains(accepted, encoding.coding) {
+			continue
+		}
+
+		file, fi, ok := openStaticFile(filename + encoding.suffix)
+		if ok {
+			return staticFile{file, fi}, encoding.coding, true
+		}
+	}
+
+	return staticFile{}, "", false
+}
+
+func (m *StaticFilesMiddleware) hasPrecompressed(filename string) bool {
+	return slices.ContainsFunc(staticFileEncodings, func(encoding staticFileEncoding) bool {
+		fi, err := os.Stat(filename + encoding.suffix)
+		return err == nil && fi.Mode().IsRegular()
+	})
+}
+
+func openStaticFile(filename string) (*os.File, os.FileInfo, bool) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	fi, err := file.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, false
+	}
+
+	return file, fi, true
+}
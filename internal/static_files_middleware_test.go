@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticFilesTestRoot(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+	}
+	return root
+}
+
+func TestStaticFilesMiddleware(t *testing.T) {
+	root := staticFilesTestRoot(t, map[string]string{
+		"assets/app.css": "body {}",
+		"secret.txt":     "secret",
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	})
+	handler := NewStaticFilesMiddleware(root, []string{"/assets/"}, next)
+
+	get := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := get("GET", "/assets/app.css")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "body {}", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/css")
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("Vary"))
+
+	assert.Equal(t, "upstream", get("GET", "/assets/missing.css").Body.String())
+	assert.Equal(t, "upstream", get("GET", "/assets").Body.String())
+	assert.Equal(t, "upstream", get("GET", "/assets/").Body.String())
+	assert.Equal(t, "upstream", get("GET", "/secret.txt").Body.String())
+	assert.Equal(t, "upstream", get("GET", "/assets/../secret.txt").Body.String())
+	assert.Equal(t, "upstream", get("POST", "/assets/app.css").Body.String())
+}
+
+func TestStaticFilesMiddleware_serves_precompressed_variants(t *testing.T) {
+	root := staticFilesTestRoot(t, map[string]string{
+		"packs/app.js":     "plain",
+		"packs/app.js.br":  "brotli",
+		"packs/app.js.zst": "zstd",
+		"packs/app.js.gz":  "gzipped",
+	})
+
+	handler := NewStaticFilesMiddleware(root, []string{"packs"}, http.NotFoundHandler())
+
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/packs/app.js", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("gzip, deflate, br")
+	assert.Equal(t, "brotli", w.Body.String())
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+
+	w = get("gzip, zstd")
+	assert.Equal(t, "zstd", w.Body.String())
+	assert.Equal(t, "zstd", w.Header().Get("Content-Encoding"))
+
+	w = get("gzip, br;q=0")
+	assert.Equal(t, "gzipped", w.Body.String())
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	w = get("")
+	assert.Equal(t, "plain", w.Body.String())
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+}
+
+func TestHandler_serves_static_files_without_proxying(t *testing.T) {
+	root := staticFilesTestRoot(t, map[string]string{"assets/app.css": "body {}"})
+
+	proxied := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+	}))
+	defer upstream.Close()
+
+	options := handlerOptions(upstream.URL)
+	options.staticFilesRoot = root
+	options.staticFilesPaths = []string{"/assets"}
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/assets/app.css", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "body {}", w.Body.String())
+	assert.False(t, proxied)
+}
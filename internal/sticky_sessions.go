@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+const defaultStickySessionCookie = "_thruster_upstream"
+
+var ErrInvalidStickySessionCookie = errors.New("sticky session cookie name must be a valid cookie name")
+
+// StickySessionPolicy says whether clients are kept on the same upstream, and
+// the cookie that records which.
+type StickySessionPolicy struct {
+	Enabled bool
+	Cookie  string
+	TTL     time.Duration
+}
+
+// StickySessions keeps each client on the same upstream, for apps that hold
+// session state in the process serving them. The upstream a client was sent
+// to is recorded in a cookie, and while that upstream stays healthy, the
+// client goes back to it. Clients without the cookie, including those that
+// don't keep cookies at all, are placed by hashing their IP, so that they
+// tend to stay put as well.
+//
+// A nil *StickySessions is valid, and leaves the choice to the pool's
+// balancing policy.
+type StickySessions struct {
+	cookieName  string
+	ttl         time.Duration
+	cookieScope *CookieScope
+}
+
+// CheckStickySessionCookie makes sure the name can be used for a cookie.
+func CheckStickySessionCookie(name string) error {
+	if (&http.Cookie{Name: name}).Valid() != nil {
+		return ErrInvalidStickySessionCookie
+	}
+	return nil
+}
+
+// NewStickySessions records upstreams in the named cookie, which lasts for
+// the ttl, or for the browser session when that's zero.
+func NewStickySessions(cookieName string, ttl time.Duration, cookieScope *CookieScope) *StickySessions {
+	return &StickySessions{
+		cookieName:  cookieName,
+		ttl:         ttl,
+		cookieScope: cookieScope,
+	}
+}
+
+// Acquire chooses the upstream for the request, setting the cookie on the
+// response when the client didn't already have it for that upstream. As with
+// UpstreamPool.Acquire, it must be paired with a call to Release.
+func (s *StickySessions) Acquire(pool *UpstreamPool, w http.ResponseWriter, r *http.Request) *Upstream {
+	if s == nil {
+		return pool.Acquire()
+	}
+
+	var id string
+	if cookie, err := r.Cookie(s.cookieName); err == nil {
+		id = cookie.Value
+	}
+	host, _ := clientIP(r)
+
+	upstream := pool.AcquireSticky(id, host)
+	if upstream.ID() != id {
+		s.issueCookie(w, r, upstream)
+	}
+
+	return upstream
+}
+
+// Private
+
+func (s *StickySessions) issueCookie(w http.ResponseWriter, r *http.Request, upstream *Upstream) {
+	cookie := &http.Cookie{
+		Name:     s.cookieName,
+		Value:    upstream.ID(),
+		Path:     "/",
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.ttl > 0 {
+		cookie.Expires = time.Now().Add(s.ttl)
+		cookie.MaxAge = int(s.ttl.Seconds())
+	}
+	if s.cookieScope != nil {
+		s.cookieScope.Apply(r, cookie)
+	}
+
+	http.SetCookie(w, cookie)
+}
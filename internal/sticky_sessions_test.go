@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStickySessionCookie(t *testing.T) {
+	assert.NoError(t, CheckStickySessionCookie("_thruster_upstream"))
+	assert.NoError(t, CheckStickySessionCookie("backend"))
+
+	assert.ErrorIs(t, CheckStickySessionCookie(""), ErrInvalidStickySessionCookie)
+	assert.ErrorIs(t, CheckStickySessionCookie("my backend"), ErrInvalidStickySessionCookie)
+	assert.ErrorIs(t, CheckStickySessionCookie("a;b"), ErrInvalidStickySessionCookie)
+}
+
+func TestStickySessions_Acquire(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b", "http://c")
+	sticky := NewStickySessions("_thruster_upstream", time.Hour, nil)
+
+	acquire := func(cookie string) (*Upstream, *http.Cookie) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "_thruster_upstream", Value: cookie})
+		}
+
+		upstream := sticky.Acquire(pool, w, r)
+		pool.Release(upstream)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			return upstream, nil
+		}
+		return upstream, cookies[0]
+	}
+
+	t.Run("records the upstream in a cookie", func(t *testing.T) {
+		upstream, cookie := acquire("")
+
+		require.NotNil(t, cookie)
+		assert.Equal(t, upstream.ID(), cookie.Value)
+		assert.Equal(t, 3600, cookie.MaxAge)
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	})
+
+	t.Run("places clients without a cookie by their IP", func(t *testing.T) {
+		first, _ := acquire("")
+		for range 5 {
+			upstream, _ := acquire("")
+			assert.Equal(t, first, upstream)
+		}
+	})
+
+	t.Run("sends clients back to the upstream in their cookie", func(t *testing.T) {
+		for _, expected := range pool.Upstreams() {
+			upstream, cookie := acquire(expected.ID())
+			assert.Equal(t, expected, upstream)
+			assert.Nil(t, cookie)
+		}
+	})
+
+	t.Run("moves clients off an unhealthy upstream", func(t *testing.T) {
+		unhealthy := pool.Upstreams()[0]
+		unhealthy.healthy.Store(false)
+		defer unhealthy.healthy.Store(true)
+
+		upstream, cookie := acquire(unhealthy.ID())
+		assert.NotEqual(t, unhealthy, upstream)
+		require.NotNil(t, cookie)
+		assert.Equal(t, upstream.ID(), cookie.Value)
+	})
+
+	t.Run("ignores cookies for upstreams it doesn't have", func(t *testing.T) {
+		upstream, cookie := acquire("0123456789abcdef")
+		require.NotNil(t, cookie)
+		assert.Equal(t, upstream.ID(), cookie.Value)
+	})
+}
+
+func TestStickySessions_session_cookie_without_ttl(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b")
+	sticky := NewStickySessions("backend", 0, nil)
+
+	w := httptest.NewRecorder()
+	pool.Release(sticky.Acquire(pool, w, httptest.NewRequest("GET", "/", nil)))
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "backend", cookies[0].Name)
+	assert.Zero(t, cookies[0].MaxAge)
+	assert.True(t, cookies[0].Expires.IsZero())
+}
+
+func TestStickySessions_nil_uses_the_balancing_policy(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b")
+	var sticky *StickySessions
+
+	hosts := []string{}
+	for range 4 {
+		w := httptest.NewRecorder()
+		upstream := sticky.Acquire(pool, w, httptest.NewRequest("GET", "/", nil))
+		hosts = append(hosts, upstream.URL.Host)
+		pool.Release(upstream)
+
+		assert.Empty(t, w.Result().Cookies())
+	}
+
+	assert.Equal(t, []string{"a", "b", "a", "b"}, hosts)
+}
+
+func TestHandlerStickySessions(t *testing.T) {
+	targets := []string{}
+	for _, name := range []string{"a", "b"} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer upstream.Close()
+
+		targets = append(targets, upstream.URL)
+	}
+
+	options := handlerOptions(targets[0])
+	options.upstreams = testUpstreamPool(BalancingRoundRobin, targets...)
+	options.stickySessions = StickySessionPolicy{Enabled: true, Cookie: "_thruster_upstream"}
+	h := NewHandler(options)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	first := w.Body.String()
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	for range 4 {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = "198.51.100.9:1234"
+		r.AddCookie(cookies[0])
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, first, w.Body.String())
+	}
+}
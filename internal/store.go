@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+type StoreBackend string
+
+const (
+	StoreBackendBolt   StoreBackend = "bolt"
+	StoreBackendMemory StoreBackend = "memory"
+	StoreBackendRedis  StoreBackend = "redis"
+)
+
+// Buckets for the kinds of state we keep, so that their keys can't collide.
+const (
+	StoreBucketBans        = "bans"
+	StoreBucketChallenges  = "challenges"
+	StoreBucketIdempotency = "idempotency"
+	StoreBucketCounters    = "counters"
+)
+
+const storePruneInterval = time.Minute
+
+var (
+	ErrInvalidStoreBackend  = errors.New("state store must be one of bolt, memory, or redis")
+	ErrStoreValueNotCounter = errors.New("stored value is not a counter")
+)
+
+func ParseStoreBackend(value string) (StoreBackend, error) {
+	backend := StoreBackend(strings.ToLower(strings.TrimSpace(value)))
+
+	switch backend {
+	case StoreBackendBolt, StoreBackendMemory, StoreBackendRedis:
+		return backend, nil
+	case "":
+		return StoreBackendBolt, nil
+	default:
+		return "", ErrInvalidStoreBackend
+	}
+}
+
+// Store keeps the state that stateful features need beyond a single request,
+// such as bans, challenge cookies and idempotency records, in one place.
+//
+// Items live in buckets, and expire after their TTL; a TTL of zero means they
+// never expire. Expired items are never returned, though they may take a while
+// to be removed. A counter's TTL starts when it's created, and isn't extended
+// by incrementing it.
+//
+// List returns every item in a bucket, keyed by their keys. It's meant for
+// inspecting small buckets, such as bans, rather than for serving requests.
+type Store interface {
+	Get(bucket, key string) ([]byte, bool)
+	List(bucket string) (map[string][]byte, error)
+	Set(bucket, key string, value []byte, ttl time.Duration) error
+	Delete(bucket, key string) error
+	Increment(bucket, key string, delta int64, ttl time.Duration) (int64, error)
+	Close() error
+}
+
+type memoryStoreEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryStoreEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !e.expiresAt.After(now)
+}
+
+// MemoryStore keeps state in memory, so it's lost on restart, and not shared
+// between instances.
+type MemoryStore struct {
+	sync.Mutex
+	buckets        map[string]map[string]memoryStoreEntry
+	prunedAt       time.Time
+	getCurrentTime GetCurrentTime
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:        map[string]map[string]memoryStoreEntry{},
+		getCurrentTime: time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(bucket, key string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	entry, ok := s.buckets[bucket][key]
+	if !ok || entry.expired(s.getCurrentTime()) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+func (s *MemoryStore) List(bucket string) (map[string][]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.getCurrentTime()
+	items := map[string][]byte{}
+
+	for key, entry := range s.buckets[bucket] {
+		if !entry.expired(now) {
+			items[key] = entry.value
+		}
+	}
+
+	return items, nil
+}
+
+func (s *MemoryStore) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.getCurrentTime()
+	s.pruneIfNeeded(now)
+
+	s.bucket(bucket)[key] = memoryStoreEntry{value: value, expiresAt: storeExpiry(now, ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(bucket, key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+func (s *MemoryStore) Increment(bucket, key string, delta int64, ttl time.Duration) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.getCurrentTime()
+	s.pruneIfNeeded(now)
+
+	entry, ok := s.buckets[bucket][key]
+	if !ok || entry.expired(now) {
+		entry = memoryStoreEntry{value: encodeStoreCounter(0), expiresAt: storeExpiry(now, ttl)}
+	}
+
+	count, err := decodeStoreCounter(entry.value)
+	if err != nil {
+		return 0, err
+	}
+
+	count += delta
+	entry.value = encodeStoreCounter(count)
+	s.bucket(bucket)[key] = entry
+
+	return count, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// Private
+
+func (s *MemoryStore) bucket(name string) map[string]memoryStoreEntry {
+	bucket, ok := s.buckets[name]
+	if !ok {
+		bucket = map[string]memoryStoreEntry{}
+		s.buckets[name] = bucket
+	}
+	return bucket
+}
+
+func (s *MemoryStore) pruneIfNeeded(now time.Time) {
+	if now.Sub(s.prunedAt) < storePruneInterval {
+		return
+	}
+
+	for _, bucket := range s.buckets {
+		for key, entry := range bucket {
+			if entry.expired(now) {
+				delete(bucket, key)
+			}
+		}
+	}
+
+	s.prunedAt = now
+}
+
+func storeExpiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// Counters are kept as 8 byte big-endian integers.
+
+func encodeStoreCounter(count int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(count))
+}
+
+func decodeStoreCounter(value []byte) (int64, error) {
+	if len(value) != 8 {
+		return 0, ErrStoreValueNotCounter
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStoreBackend(t *testing.T) {
+	backend, err := ParseStoreBackend("Redis")
+	require.NoError(t, err)
+	assert.Equal(t, StoreBackendRedis, backend)
+
+	backend, err = ParseStoreBackend("")
+	require.NoError(t, err)
+	assert.Equal(t, StoreBackendBolt, backend)
+
+	_, err = ParseStoreBackend("sqlite")
+	assert.ErrorIs(t, err, ErrInvalidStoreBackend)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) (Store, func(time.Duration)) {
+		store := NewMemoryStore()
+		now := time.Now()
+		store.getCurrentTime = func() time.Time { return now }
+
+		return store, func(d time.Duration) { now = now.Add(d) }
+	})
+}
+
+func TestMemoryStore_prunes_expired_items(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.getCurrentTime = func() time.Time { return now }
+
+	require.NoError(t, store.Set(StoreBucketBans, "a", []byte("1"), time.Second))
+
+	now = now.Add(storePruneInterval)
+	require.NoError(t, store.Set(StoreBucketBans, "b", []byte("1"), 0))
+
+	assert.Len(t, store.buckets[StoreBucketBans], 1)
+}
+
+// testStore checks the behaviour that every Store must share. The advance
+// function moves the store's clock forward.
+func testStore(t *testing.T, open func(t *testing.T) (Store, func(time.Duration))) {
+	t.Run("stores and retrieves items by bucket", func(t *testing.T) {
+		store, _ := open(t)
+		defer store.Close()
+
+		_, ok := store.Get(StoreBucketBans, "key")
+		assert.False(t, ok)
+
+		require.NoError(t, store.Set(StoreBucketBans, "key", []byte("banned"), 0))
+		require.NoError(t, store.Set(StoreBucketChallenges, "key", []byte("passed"), 0))
+
+		value, ok := store.Get(StoreBucketBans, "key")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("banned"), value)
+
+		value, ok = store.Get(StoreBucketChallenges, "key")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("passed"), value)
+
+		require.NoError(t, store.Delete(StoreBucketBans, "key"))
+		require.NoError(t, store.Delete(StoreBucketBans, "missing"))
+
+		_, ok = store.Get(StoreBucketBans, "key")
+		assert.False(t, ok)
+	})
+
+	t.Run("lists the items in a bucket", func(t *testing.T) {
+		store, advance := open(t)
+		defer store.Close()
+
+		items, err := store.List(StoreBucketBans)
+		require.NoError(t, err)
+		assert.Empty(t, items)
+
+		require.NoError(t, store.Set(StoreBucketBans, "192.0.2.1", []byte("a"), 0))
+		require.NoError(t, store.Set(StoreBucketBans, "2001:db8::1", []byte("b"), time.Hour))
+		require.NoError(t, store.Set(StoreBucketBans, "198.51.100.1", []byte("c"), time.Minute))
+		require.NoError(t, store.Set(StoreBucketChallenges, "192.0.2.2", []byte("d"), 0))
+
+		advance(2 * time.Minute)
+
+		items, err = store.List(StoreBucketBans)
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]byte{"192.0.2.1": []byte("a"), "2001:db8::1": []byte("b")}, items)
+	})
+
+	t.Run("expires items", func(t *testing.T) {
+		store, advance := open(t)
+		defer store.Close()
+
+		require.NoError(t, store.Set(StoreBucketBans, "short", []byte("1"), time.Minute))
+		require.NoError(t, store.Set(StoreBucketBans, "forever", []byte("1"), 0))
+
+		advance(2 * time.Minute)
+
+		_, ok := store.Get(StoreBucketBans, "short")
+		assert.False(t, ok)
+		_, ok = store.Get(StoreBucketBans, "forever")
+		assert.True(t, ok)
+	})
+
+	t.Run("increments counters without extending their TTL", func(t *testing.T) {
+		store, advance := open(t)
+		defer store.Close()
+
+		count, err := store.Increment(StoreBucketCounters, "hits", 1, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		advance(30 * time.Second)
+
+		count, err = store.Increment(StoreBucketCounters, "hits", 2, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+
+		advance(45 * time.Second)
+
+		count, err = store.Increment(StoreBucketCounters, "hits", 1, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+}
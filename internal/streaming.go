@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	streamKindUpgrade     = "upgrade"
+	streamKindEventStream = "event-stream"
+)
+
+// StreamingMiddleware prepares long-lived requests, such as WebSockets and
+// server-sent events, to be proxied. These can stay open far longer than an
+// ordinary request, so the server's write timeout doesn't apply to them.
+//
+// Only what the upstream sends back says that a response is an event stream,
+// since anyone can ask for one in a request's Accept header.
+type StreamingMiddleware struct {
+	next http.Handler
+}
+
+func NewStreamingMiddleware(next http.Handler) http.Handler {
+	return &StreamingMiddleware{next: next}
+}
+
+func (h *StreamingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if IsUpgradeRequest(r) {
+		RequestTagsFromContext(r.Context()).Set(TagStream, streamKindUpgrade)
+		liftWriteDeadline(w)
+
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	h.next.ServeHTTP(&eventStreamWriter{ResponseWriter: w, r: r}, r)
+}
+
+// IsUpgradeRequest reports whether the request is for a protocol upgrade,
+// like a WebSocket. Those are always GET requests, without a body.
+func IsUpgradeRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Private
+
+// eventStreamWriter lifts the write timeout from a response once it turns out
+// to be a stream of server-sent events.
+type eventStreamWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	wroteHeader bool
+}
+
+func (w *eventStreamWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader && statusCode >= http.StatusOK {
+		w.wroteHeader = true
+		if isEventStreamResponse(w.Header()) {
+			RequestTagsFromContext(w.r.Context()).Set(TagStream, streamKindEventStream)
+			liftWriteDeadline(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *eventStreamWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *eventStreamWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *eventStreamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func isEventStreamResponse(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// liftWriteDeadline lets the response take as long as it needs. Not every
+// writer supports deadlines (HTTP/3 doesn't, for one), in which case there's
+// no timeout for us to lift.
+func liftWriteDeadline(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// unlessUpgrade applies a middleware only to ordinary requests. Requests for
+// protocol upgrades skip it, going straight to the next handler.
+func unlessUpgrade(middleware Middleware) Middleware {
+	if middleware == nil {
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		wrapped := middleware(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsUpgradeRequest(r) {
+				next.ServeHTTP(w, r)
+			} else {
+				wrapped.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUpgradeRequest(t *testing.T) {
+	request := func(method string, headers map[string]string) *http.Request {
+		r := httptest.NewRequest(method, "/", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	assert.True(t, IsUpgradeRequest(request("GET", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket"})))
+
+	assert.False(t, IsUpgradeRequest(request("GET", map[string]string{})))
+	assert.False(t, IsUpgradeRequest(request("GET", map[string]string{"Connection": "Upgrade"})))
+	assert.False(t, IsUpgradeRequest(request("GET", map[string]string{"Upgrade": "websocket"})))
+	assert.False(t, IsUpgradeRequest(request("POST", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"})))
+	assert.False(t, IsUpgradeRequest(request("GET", map[string]string{"Accept": "text/event-stream"})))
+}
+
+func TestStreamingMiddleware_tags_upgrades(t *testing.T) {
+	middleware := NewStreamingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	middleware.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "upgrade", tags.Get(TagStream))
+}
+
+func TestStreamingMiddleware_tags_event_stream_responses(t *testing.T) {
+	serve := func(contentType string) string {
+		middleware := NewStreamingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte("data: hello\n\n"))
+		}))
+
+		r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+		r.Header.Set("Accept", "text/event-stream")
+		middleware.ServeHTTP(httptest.NewRecorder(), r)
+
+		return tags.Get(TagStream)
+	}
+
+	assert.Equal(t, "event-stream", serve("text/event-stream; charset=utf-8"))
+
+	// Asking for one isn't enough
+	assert.Empty(t, serve("text/html"))
+}
+
+func TestUnlessUpgrade(t *testing.T) {
+	applied := false
+	middleware := unlessUpgrade(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			applied = true
+			next.ServeHTTP(w, r)
+		})
+	})
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.True(t, applied)
+
+	applied = false
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.False(t, applied)
+
+	assert.Nil(t, unlessUpgrade(nil))
+}
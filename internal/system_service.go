@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const defaultSystemServiceName = "thruster"
+
+var ErrSystemServiceUnsupported = errors.New("system service management is not supported on this platform")
+
+type NewServiceFunc func() (*Service, error)
+
+type SystemServiceOptions struct {
+	Name       string
+	Dir        string
+	Executable string
+	Command    []string
+}
+
+// RunSystemServiceCommand implements `thrust service <action>`, which manages
+// running the proxy under the host's native service manager.
+func RunSystemServiceCommand(args []string, newService NewServiceFunc) int {
+	if len(args) < 1 {
+		printSystemServiceUsage(os.Stderr)
+		return 1
+	}
+
+	action := args[0]
+	options, err := parseSystemServiceOptions(action, args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	switch action {
+	case "install":
+		err = installSystemService(options)
+	case "uninstall":
+		err = uninstallSystemService(options)
+	case "run":
+		return runSystemService(options, newService)
+	case "launchd-plist":
+		err = WriteLaunchdPlist(os.Stdout, options)
+	default:
+		printSystemServiceUsage(os.Stderr)
+		return 1
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Private
+
+func parseSystemServiceOptions(action string, args []string) (SystemServiceOptions, error) {
+	flags := flag.NewFlagSet("service "+action, flag.ContinueOnError)
+	name := flags.String("name", defaultSystemServiceName, "name of the service")
+	dir := flags.String("dir", ".", "working directory for the service")
+	configFile := flags.String("config", "", "config file for the service to read, instead of CONFIG_FILE")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return SystemServiceOptions{}, err
+	}
+
+	absDir, err := filepath.Abs(*dir)
+	if err != nil {
+		return SystemServiceOptions{}, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return SystemServiceOptions{}, err
+	}
+
+	options := SystemServiceOptions{
+		Name:       *name,
+		Dir:        absDir,
+		Executable: executable,
+		Command:    flags.Args(),
+	}
+
+	needsCommand := action == "install" || action == "run" || action == "launchd-plist"
+	if needsCommand && len(options.Command) == 0 {
+		return SystemServiceOptions{}, errors.New("missing upstream command")
+	}
+
+	// The config file is passed on to the upstream command's configuration,
+	// where it's read from the start of the command
+	if *configFile != "" {
+		absConfigFile, err := filepath.Abs(*configFile)
+		if err != nil {
+			return SystemServiceOptions{}, err
+		}
+		options.Command = append([]string{"--config", absConfigFile}, options.Command...)
+	}
+
+	return options, nil
+}
+
+// prepareSystemServiceRun arranges the process so that the regular
+// configuration, which reads the upstream command from os.Args, sees the
+// service's upstream command.
+func prepareSystemServiceRun(options SystemServiceOptions) error {
+	err := os.Chdir(options.Dir)
+	if err != nil {
+		return err
+	}
+
+	os.Args = append([]string{os.Args[0]}, options.Command...)
+	return nil
+}
+
+func printSystemServiceUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: thrust service <install|uninstall|run|launchd-plist> [-name NAME] [-dir DIR] [-config FILE] [command] [args...]")
+}
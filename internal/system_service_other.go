@@ -0,0 +1,32 @@
+//go:build !windows
+
+package internal
+
+import (
+	"fmt"
+	"os"
+)
+
+func installSystemService(options SystemServiceOptions) error {
+	return ErrSystemServiceUnsupported
+}
+
+func uninstallSystemService(options SystemServiceOptions) error {
+	return ErrSystemServiceUnsupported
+}
+
+func runSystemService(options SystemServiceOptions, newService NewServiceFunc) int {
+	err := prepareSystemServiceRun(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	service, err := newService()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	return service.Run()
+}
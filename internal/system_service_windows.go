@@ -0,0 +1,201 @@
+//go:build windows
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const eventLogEventID = 1
+
+func installSystemService(options SystemServiceOptions) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	existing, err := m.OpenService(options.Name)
+	if err == nil {
+		existing.Close()
+		return fmt.Errorf("service %s already exists", options.Name)
+	}
+
+	args := append([]string{"service", "run", "-name", options.Name, "-dir", options.Dir}, options.Command...)
+	s, err := m.CreateService(options.Name, options.Executable, mgr.Config{
+		DisplayName: options.Name,
+		Description: "Thruster HTTP proxy",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	err = eventlog.InstallAsEventCreate(options.Name, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		s.Delete()
+		return fmt.Errorf("unable to register event log source: %w", err)
+	}
+
+	return nil
+}
+
+func uninstallSystemService(options SystemServiceOptions) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(options.Name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", options.Name)
+	}
+	defer s.Close()
+
+	err = s.Delete()
+	if err != nil {
+		return err
+	}
+
+	return eventlog.Remove(options.Name)
+}
+
+func runSystemService(options SystemServiceOptions, newService NewServiceFunc) int {
+	err := prepareSystemServiceRun(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	service, err := newService()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return 1
+	}
+
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return service.Run()
+	}
+
+	elog, err := eventlog.Open(options.Name)
+	if err == nil {
+		defer elog.Close()
+		slog.SetDefault(slog.New(newEventLogHandler(elog, slog.Default().Handler())))
+	}
+
+	handler := &windowsService{service: service}
+	err = svc.Run(options.Name, handler)
+	if err != nil {
+		slog.Error("Failed to run as Windows service", "name", options.Name, "error", err)
+		return 1
+	}
+
+	return handler.exitCode
+}
+
+type windowsService struct {
+	service  *Service
+	exitCode int
+}
+
+func (h *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	done := make(chan int, 1)
+	go func() {
+		done <- h.service.Run()
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case h.exitCode = <-done:
+			changes <- svc.Status{State: svc.StopPending}
+			return h.exitCode != 0, uint32(h.exitCode)
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				h.service.Stop()
+			}
+		}
+	}
+}
+
+// eventLogHandler writes log records to the Windows event log, formatted as
+// JSON in the same way as our regular logs.
+type eventLogHandler struct {
+	elog    *eventlog.Log
+	enabled slog.Handler
+	inner   slog.Handler
+	buffer  *bytes.Buffer
+	mu      *sync.Mutex
+}
+
+func newEventLogHandler(elog *eventlog.Log, enabled slog.Handler) *eventLogHandler {
+	buffer := &bytes.Buffer{}
+
+	return &eventLogHandler{
+		elog:    elog,
+		enabled: enabled,
+		inner:   slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		buffer:  buffer,
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (h *eventLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.enabled.Enabled(ctx, level)
+}
+
+func (h *eventLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buffer.Reset()
+	err := h.inner.Handle(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	message := strings.TrimSpace(h.buffer.String())
+
+	switch {
+	case record.Level >= slog.LevelError:
+		return h.elog.Error(eventLogEventID, message)
+	case record.Level >= slog.LevelWarn:
+		return h.elog.Warning(eventLogEventID, message)
+	default:
+		return h.elog.Info(eventLogEventID, message)
+	}
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.enabled = h.enabled.WithAttrs(attrs)
+	clone.inner = h.inner.WithAttrs(attrs)
+	return &clone
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.enabled = h.enabled.WithGroup(name)
+	clone.inner = h.inner.WithGroup(name)
+	return &clone
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const tlsFingerprintLength = 12
+
+// TLSFingerprints records a fingerprint of the TLS ClientHello of each HTTPS
+// connection, so that requests can be recognised by the TLS library that made
+// them rather than by what they claim to be. It's similar in spirit to JA3:
+// the offered versions, cipher suites, curves, point formats, signature
+// schemes and protocols are hashed together, ignoring GREASE values.
+//
+// Fingerprints are held by the connection's remote address until it closes.
+// HTTP/3 connections aren't fingerprinted.
+//
+// A nil *TLSFingerprints is valid, and records nothing.
+type TLSFingerprints struct {
+	fingerprints sync.Map
+}
+
+func NewTLSFingerprints() *TLSFingerprints {
+	return &TLSFingerprints{}
+}
+
+// WrapTLSConfig returns a copy of config that records the fingerprint of each
+// connection's ClientHello.
+func (f *TLSFingerprints) WrapTLSConfig(config *tls.Config) *tls.Config {
+	if f == nil {
+		return config
+	}
+
+	wrapped := config.Clone()
+	getConfigForClient := config.GetConfigForClient
+
+	wrapped.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if hello.Conn != nil {
+			f.fingerprints.Store(hello.Conn.RemoteAddr().String(), tlsFingerprint(hello))
+		}
+
+		if getConfigForClient != nil {
+			return getConfigForClient(hello)
+		}
+		return nil, nil
+	}
+
+	return wrapped
+}
+
+// ConnState forgets the fingerprints of closed connections. It's meant for
+// http.Server's ConnState hook.
+func (f *TLSFingerprints) ConnState(conn net.Conn, state http.ConnState) {
+	if f != nil && (state == http.StateClosed || state == http.StateHijacked) {
+		f.fingerprints.Delete(conn.RemoteAddr().String())
+	}
+}
+
+// For returns the fingerprint of the connection a request arrived on, or an
+// empty string if it has none.
+func (f *TLSFingerprints) For(r *http.Request) string {
+	if f == nil {
+		return ""
+	}
+
+	fingerprint, ok := f.fingerprints.Load(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	return fingerprint.(string)
+}
+
+// Private
+
+func tlsFingerprint(hello *tls.ClientHelloInfo) string {
+	fields := []string{
+		tlsFingerprintField(hello.SupportedVersions),
+		tlsFingerprintField(hello.CipherSuites),
+		tlsFingerprintField(hello.SupportedCurves),
+		tlsFingerprintField(hello.SupportedPoints),
+		tlsFingerprintField(hello.SignatureSchemes),
+		strings.Join(hello.SupportedProtos, "-"),
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, ",")))
+	return hex.EncodeToString(sum[:])[:tlsFingerprintLength]
+}
+
+func tlsFingerprintField[T ~uint8 | ~uint16](values []T) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		if !isGREASE(uint16(value)) {
+			parts = append(parts, strconv.Itoa(int(value)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// isGREASE reports whether a value is one of those that clients offer at
+// random to keep servers tolerant of unknown values (RFC 8701). They'd make
+// every connection from the same client look different.
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSFingerprints_records_fingerprints_by_connection(t *testing.T) {
+	fingerprints := NewTLSFingerprints()
+
+	conn, other := net.Pipe()
+	defer other.Close()
+
+	called := false
+	config := fingerprints.WrapTLSConfig(&tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			called = true
+			return nil, nil
+		},
+	})
+
+	_, err := config.GetConfigForClient(&tls.ClientHelloInfo{
+		Conn:         conn,
+		CipherSuites: []uint16{tls.TLS_AES_128_GCM_SHA256},
+	})
+	require.NoError(t, err)
+	assert.True(t, called)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = conn.RemoteAddr().String()
+
+	fingerprint := fingerprints.For(r)
+	assert.Len(t, fingerprint, tlsFingerprintLength)
+
+	fingerprints.ConnState(conn, http.StateActive)
+	assert.Equal(t, fingerprint, fingerprints.For(r))
+
+	fingerprints.ConnState(conn, http.StateClosed)
+	assert.Empty(t, fingerprints.For(r))
+}
+
+func TestTLSFingerprints_nil(t *testing.T) {
+	var fingerprints *TLSFingerprints
+	config := &tls.Config{}
+
+	assert.Same(t, config, fingerprints.WrapTLSConfig(config))
+	assert.Empty(t, fingerprints.For(httptest.NewRequest("GET", "/", nil)))
+}
+
+func TestTLSFingerprint_ignores_grease(t *testing.T) {
+	hello := &tls.ClientHelloInfo{
+		CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384},
+		SupportedCurves:   []tls.CurveID{tls.X25519},
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SupportedProtos:   []string{"h2", "http/1.1"},
+	}
+	greased := &tls.ClientHelloInfo{
+		CipherSuites:      []uint16{0x1a1a, tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384},
+		SupportedCurves:   []tls.CurveID{0xfafa, tls.X25519},
+		SupportedVersions: []uint16{0x2a2a, tls.VersionTLS13},
+		SupportedProtos:   []string{"h2", "http/1.1"},
+	}
+	different := &tls.ClientHelloInfo{
+		CipherSuites:      []uint16{tls.TLS_AES_256_GCM_SHA384, tls.TLS_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519},
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SupportedProtos:   []string{"h2", "http/1.1"},
+	}
+
+	assert.Equal(t, tlsFingerprint(hello), tlsFingerprint(greased))
+	assert.NotEqual(t, tlsFingerprint(hello), tlsFingerprint(different))
+}
+
+func TestIsGREASE(t *testing.T) {
+	assert.True(t, isGREASE(0x0a0a))
+	assert.True(t, isGREASE(0xfafa))
+	assert.False(t, isGREASE(0x0a1a))
+	assert.False(t, isGREASE(0x1301))
+	assert.False(t, isGREASE(0x000a))
+}
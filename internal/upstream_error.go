@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// UpstreamErrorClass describes why a request couldn't be proxied, so that
+// each kind of failure can be logged and answered differently.
+type UpstreamErrorClass string
+
+const (
+	UpstreamErrorDNS         UpstreamErrorClass = "dns"
+	UpstreamErrorRefused     UpstreamErrorClass = "refused"
+	UpstreamErrorTLS         UpstreamErrorClass = "tls"
+	UpstreamErrorTimeout     UpstreamErrorClass = "timeout"
+	UpstreamErrorCircuitOpen UpstreamErrorClass = "circuit_open"
+	UpstreamErrorServer      UpstreamErrorClass = "server_error"
+	UpstreamErrorOther       UpstreamErrorClass = "other"
+)
+
+var upstreamErrorClasses = []UpstreamErrorClass{
+	UpstreamErrorDNS,
+	UpstreamErrorRefused,
+	UpstreamErrorTLS,
+	UpstreamErrorTimeout,
+	UpstreamErrorCircuitOpen,
+	UpstreamErrorOther,
+}
+
+func ClassifyUpstreamError(err error) UpstreamErrorClass {
+	var dnsError *net.DNSError
+	var recordHeaderError tls.RecordHeaderError
+	var alertError tls.AlertError
+	var verificationError *tls.CertificateVerificationError
+	var unknownAuthorityError x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	var certificateInvalidError x509.CertificateInvalidError
+	var netError net.Error
+
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return UpstreamErrorCircuitOpen
+	case errors.As(err, &dnsError):
+		return UpstreamErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return UpstreamErrorRefused
+	case errors.As(err, &recordHeaderError), errors.As(err, &alertError), errors.As(err, &verificationError),
+		errors.As(err, &unknownAuthorityError), errors.As(err, &hostnameError), errors.As(err, &certificateInvalidError):
+		return UpstreamErrorTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		return UpstreamErrorTimeout
+	default:
+		return UpstreamErrorOther
+	}
+}
+
+// StatusCode is the status we respond with when this class of error occurs.
+func (c UpstreamErrorClass) StatusCode() int {
+	switch c {
+	case UpstreamErrorTimeout:
+		return http.StatusGatewayTimeout
+	case UpstreamErrorCircuitOpen:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
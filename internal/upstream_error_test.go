@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyUpstreamError(t *testing.T) {
+	tests := map[UpstreamErrorClass]error{
+		UpstreamErrorDNS:         &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "app.internal"}},
+		UpstreamErrorRefused:     &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		UpstreamErrorTLS:         fmt.Errorf("tls: %w", x509.UnknownAuthorityError{}),
+		UpstreamErrorTimeout:     fmt.Errorf("dial: %w", context.DeadlineExceeded),
+		UpstreamErrorCircuitOpen: ErrCircuitOpen,
+		UpstreamErrorOther:       errors.New("something else"),
+	}
+
+	for expected, err := range tests {
+		assert.Equal(t, expected, ClassifyUpstreamError(err), err.Error())
+	}
+}
+
+func TestProxyErrorHandler_serves_pages_by_error_class(t *testing.T) {
+	dir := t.TempDir()
+	badGatewayPage := filepath.Join(dir, "502.html")
+	os.WriteFile(badGatewayPage, []byte("bad gateway"), 0644)
+	os.WriteFile(filepath.Join(dir, "502-timeout.html"), []byte("timed out"), 0644)
+
+	handler := ProxyErrorHandler(nil, badGatewayPage)
+
+	respond := func(err error) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, tags := WithRequestTags(httptest.NewRequest("GET", "/", nil))
+		handler(w, r, err)
+
+		assert.Equal(t, string(ClassifyUpstreamError(err)), tags.Get(TagUpstreamError))
+		return w
+	}
+
+	w := respond(context.DeadlineExceeded)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "timed out", w.Body.String())
+
+	w = respond(errors.New("something else"))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "bad gateway", w.Body.String())
+
+	w = respond(ErrCircuitOpen)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "bad gateway", w.Body.String())
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// UpstreamTimeouts limit how long we'll wait on the upstream. Zero values
+// mean no limit, other than for Connect, which then uses the default.
+type UpstreamTimeouts struct {
+	// Connect is the time allowed to establish a connection
+	Connect time.Duration
+	// Read is the time allowed, once a request is sent, for the response to start
+	Read time.Duration
+	// Write is the time allowed for each write of the request to the connection
+	Write time.Duration
+}
+
+// RetryPolicy controls how requests are retried when the upstream can't be
+// reached. Only idempotent requests without a body are retried, since we can
+// neither be sure that others didn't take effect, nor send their body again.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// Delay is how long to wait before the given retry, doubling each time.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	return p.Backoff << (retry - 1)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type retryTransport struct {
+	policy RetryPolicy
+	next   http.RoundTripper
+}
+
+func newRetryTransport(policy RetryPolicy, next http.RoundTripper) http.RoundTripper {
+	if policy.MaxAttempts <= 1 {
+		return next
+	}
+	return &retryTransport{policy: policy, next: next}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := t.policy.MaxAttempts
+	if !isRetryableRequest(req) {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || attempt >= attempts || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		delay := t.policy.Delay(attempt)
+		slog.Debug("Retrying upstream request", "path", req.URL.Path, "attempt", attempt+1, "delay", delay, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody
+	default:
+		return false
+	}
+}
+
+// deadlineConn applies a fresh write deadline ahead of every write, so that
+// the limit is on a stalled upstream rather than on the size of the request.
+type deadlineConn struct {
+	net.Conn
+	writeTimeout time.Duration
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	return c.Conn.Write(b)
+}
+
+// upstreamDialer connects to upstreams, dialing the socket for any address
+// whose host is the placeholder for one of the pool's sockets. The pool is
+// consulted on each dial, so sockets that join it later can be reached too.
+func upstreamDialer(timeouts UpstreamTimeouts, upstreams *UpstreamPool) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if timeouts.Connect > 0 {
+		dialer.Timeout = timeouts.Connect
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(address)
+		if socket, ok := upstreams.Sockets()[host]; ok {
+			network, address = "unix", socket
+		}
+
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil || timeouts.Write <= 0 {
+			return conn, err
+		}
+		return &deadlineConn{Conn: conn, writeTimeout: timeouts.Write}, nil
+	}
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, Backoff: 100 * time.Millisecond}
+
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, policy.Delay(2))
+	assert.Equal(t, 400*time.Millisecond, policy.Delay(3))
+}
+
+func TestRetryTransport(t *testing.T) {
+	failing := func(failures int) (*int, http.RoundTripper) {
+		attempts := 0
+		return &attempts, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts <= failures {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		})
+	}
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	t.Run("retries idempotent requests until they succeed", func(t *testing.T) {
+		attempts, next := failing(2)
+		resp, err := newRetryTransport(policy, next).RoundTrip(httptest.NewRequest("GET", "/", nil))
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 3, *attempts)
+	})
+
+	t.Run("gives up after the maximum attempts", func(t *testing.T) {
+		attempts, next := failing(5)
+		_, err := newRetryTransport(policy, next).RoundTrip(httptest.NewRequest("GET", "/", nil))
+
+		require.Error(t, err)
+		assert.Equal(t, 3, *attempts)
+	})
+
+	t.Run("does not retry unsafe requests", func(t *testing.T) {
+		attempts, next := failing(1)
+		_, err := newRetryTransport(policy, next).RoundTrip(httptest.NewRequest("POST", "/", nil))
+
+		require.Error(t, err)
+		assert.Equal(t, 1, *attempts)
+	})
+
+	t.Run("does not retry requests with a body", func(t *testing.T) {
+		attempts, next := failing(1)
+		_, err := newRetryTransport(policy, next).RoundTrip(httptest.NewRequest("PUT", "/", strings.NewReader("body")))
+
+		require.Error(t, err)
+		assert.Equal(t, 1, *attempts)
+	})
+}
+
+func TestProxyHandler_read_timeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	targetUrl, _ := url.Parse(upstream.URL)
+	timeouts := UpstreamTimeouts{Read: 20 * time.Millisecond}
+	h := newTestProxyHandler(NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin), TargetProtocolHTTP1, timeouts, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
@@ -0,0 +1,449 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BalancingPolicy string
+
+const (
+	BalancingRoundRobin       BalancingPolicy = "round_robin"
+	BalancingLeastConnections BalancingPolicy = "least_connections"
+)
+
+var (
+	ErrInvalidBalancingPolicy = errors.New("load balancing policy must be one of round_robin or least_connections")
+	ErrInvalidTargetURL       = errors.New("target URL must include a scheme and host, or be a unix:// socket path")
+)
+
+func ParseBalancingPolicy(value string) (BalancingPolicy, error) {
+	policy := BalancingPolicy(strings.ToLower(strings.TrimSpace(value)))
+
+	switch policy {
+	case BalancingRoundRobin, BalancingLeastConnections:
+		return policy, nil
+	case "":
+		return BalancingRoundRobin, nil
+	default:
+		return "", ErrInvalidBalancingPolicy
+	}
+}
+
+func ParseTargetURLs(values []string) ([]*url.URL, error) {
+	targets := []*url.URL{}
+
+	for _, value := range values {
+		target, err := url.Parse(value)
+		if err != nil || !isValidTargetURL(target) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTargetURL, value)
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+func isValidTargetURL(target *url.URL) bool {
+	if target.Scheme == "unix" {
+		return target.Path != ""
+	}
+	return target.Scheme != "" && target.Host != ""
+}
+
+// proxyTarget is the URL that requests for an upstream are sent to. Sockets
+// are given a placeholder host, unique to each, which the transport's dialer
+// resolves back to the socket path.
+func proxyTarget(target *url.URL) *url.URL {
+	if target.Scheme != "unix" {
+		return target
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(target.Path))
+
+	return &url.URL{Scheme: "http", Host: fmt.Sprintf("unix-%x", hash.Sum64())}
+}
+
+func upstreamID(target *url.URL) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(target.String()))
+
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// HealthCheck describes how upstreams are actively probed. An upstream is
+// ejected from the pool after UnhealthyThreshold consecutive failed checks,
+// and reinstated after HealthyThreshold consecutive successful ones.
+type HealthCheck struct {
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+func (c HealthCheck) Enabled() bool {
+	return c.Path != "" && c.Interval > 0
+}
+
+type Upstream struct {
+	URL *url.URL
+
+	// Where requests are actually sent, which differs from URL for upstreams
+	// listening on a Unix socket
+	target *url.URL
+
+	// Names the upstream in sticky session cookies, without giving away its
+	// address
+	id string
+
+	healthy atomic.Bool
+	active  atomic.Int64
+
+	// Set when the pool has circuit breakers, and kept for as long as the
+	// upstream stays in the pool
+	breaker *CircuitBreaker
+
+	// Only touched by the health checker
+	successes int
+	failures  int
+
+	// Ends the upstream's health checks when it leaves the pool
+	stopChecks context.CancelFunc
+}
+
+func newUpstream(target *url.URL) *Upstream {
+	upstream := &Upstream{URL: target, target: proxyTarget(target), id: upstreamID(target)}
+	upstream.healthy.Store(true)
+	return upstream
+}
+
+func (u *Upstream) ID() string {
+	return u.id
+}
+
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load()
+}
+
+func (u *Upstream) ActiveRequests() int64 {
+	return u.active.Load()
+}
+
+// CircuitBreaker is the upstream's breaker, or nil if the pool has none.
+func (u *Upstream) CircuitBreaker() *CircuitBreaker {
+	return u.breaker
+}
+
+// available reports whether requests can be sent to the upstream: it's
+// passing its health checks, and its circuit isn't open.
+func (u *Upstream) available() bool {
+	return u.Healthy() && u.breaker.Available()
+}
+
+// UpstreamPool spreads requests over a set of upstreams, skipping those that
+// are failing their health checks or whose circuit is open.
+type UpstreamPool struct {
+	sync.Mutex
+	upstreams atomic.Pointer[[]*Upstream]
+	policy    BalancingPolicy
+	counter   atomic.Uint64
+
+	// Set once circuit breakers are enabled, for upstreams that join later
+	breakers *circuitBreakerSettings
+
+	// Set once health checks start, for checking upstreams that join later
+	ctx    context.Context
+	cancel context.CancelFunc
+	check  HealthCheck
+	client *http.Client
+	wg     sync.WaitGroup
+}
+
+func NewUpstreamPool(targets []*url.URL, policy BalancingPolicy) *UpstreamPool {
+	upstreams := make([]*Upstream, len(targets))
+	for i, target := range targets {
+		upstreams[i] = newUpstream(target)
+	}
+
+	pool := &UpstreamPool{policy: policy}
+	pool.upstreams.Store(&upstreams)
+	return pool
+}
+
+func (p *UpstreamPool) Upstreams() []*Upstream {
+	return *p.upstreams.Load()
+}
+
+// SetCircuitBreakers gives every upstream in the pool, and those that join it
+// later, a circuit breaker of its own.
+func (p *UpstreamPool) SetCircuitBreakers(threshold int, cooldown time.Duration, events *EventDispatcher) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.breakers = &circuitBreakerSettings{threshold: threshold, cooldown: cooldown, events: events}
+	for _, upstream := range p.Upstreams() {
+		upstream.breaker = p.breakers.breakerFor(upstream)
+	}
+}
+
+// SetTargets changes the upstreams in the pool. Those whose URL is in both
+// the old and new targets stay as they are, health and all. New ones join as
+// healthy, and are health checked if checks are running. Requests underway to
+// an upstream that's removed are left to finish.
+func (p *UpstreamPool) SetTargets(targets []*url.URL) {
+	p.Lock()
+	defer p.Unlock()
+
+	current := map[string]*Upstream{}
+	for _, upstream := range p.Upstreams() {
+		current[upstream.id] = upstream
+	}
+
+	upstreams := make([]*Upstream, 0, len(targets))
+	for _, target := range targets {
+		upstream, ok := current[upstreamID(target)]
+		if ok {
+			delete(current, upstream.id)
+		} else {
+			upstream = newUpstream(target)
+			upstream.breaker = p.breakers.breakerFor(upstream)
+			slog.Info("Adding upstream to the pool", "upstream", target.String())
+			p.startHealthCheck(upstream)
+		}
+		upstreams = append(upstreams, upstream)
+	}
+
+	for _, upstream := range current {
+		slog.Info("Removing upstream from the pool", "upstream", upstream.URL.String())
+		if upstream.stopChecks != nil {
+			upstream.stopChecks()
+		}
+	}
+
+	p.upstreams.Store(&upstreams)
+}
+
+// Sockets maps the placeholder hosts used for Unix socket upstreams to the
+// paths of their sockets, so that a dialer can connect to them.
+func (p *UpstreamPool) Sockets() map[string]string {
+	sockets := map[string]string{}
+	for _, upstream := range p.Upstreams() {
+		if upstream.URL.Scheme == "unix" {
+			sockets[upstream.target.Host] = upstream.URL.Path
+		}
+	}
+	return sockets
+}
+
+// Acquire chooses the upstream for a request. Each call must be paired with a
+// call to Release once the request is done.
+func (p *UpstreamPool) Acquire() *Upstream {
+	candidates := p.availableUpstreams()
+	if len(candidates) == 0 {
+		// With nothing available, trying one anyway gives a better chance of
+		// success than failing outright
+		candidates = p.Upstreams()
+	}
+
+	offset := int(p.counter.Add(1) - 1)
+	chosen := candidates[offset%len(candidates)]
+
+	if p.policy == BalancingLeastConnections {
+		// Start from the round-robin choice so that ties are spread evenly
+		for i := range candidates {
+			candidate := candidates[(offset+i)%len(candidates)]
+			if candidate.ActiveRequests() < chosen.ActiveRequests() {
+				chosen = candidate
+			}
+		}
+	}
+
+	chosen.active.Add(1)
+	return chosen
+}
+
+// AcquireSticky chooses the upstream with the given ID while it's available,
+// or else the one that the key hashes to. Hashing is by rendezvous, so that
+// when an upstream leaves or rejoins the pool, only the keys that hash to it
+// move.
+func (p *UpstreamPool) AcquireSticky(id, key string) *Upstream {
+	candidates := p.availableUpstreams()
+	if len(candidates) == 0 {
+		candidates = p.Upstreams()
+	}
+
+	var chosen *Upstream
+	var best uint64
+	for _, candidate := range candidates {
+		if id != "" && candidate.id == id {
+			chosen = candidate
+			break
+		}
+
+		hash := fnv.New64a()
+		hash.Write([]byte(key + "\x00" + candidate.id))
+		if score := hash.Sum64(); chosen == nil || score > best {
+			chosen, best = candidate, score
+		}
+	}
+
+	chosen.active.Add(1)
+	return chosen
+}
+
+func (p *UpstreamPool) Release(upstream *Upstream) {
+	upstream.active.Add(-1)
+}
+
+// StartHealthChecks probes every upstream in the background until Stop is
+// called.
+func (p *UpstreamPool) StartHealthChecks(check HealthCheck, transport http.RoundTripper) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.check = check
+	p.client = &http.Client{
+		Transport: transport,
+		Timeout:   check.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	slog.Info("Starting upstream health checks", "path", check.Path, "interval", check.Interval, "upstreams", len(p.Upstreams()))
+
+	for _, upstream := range p.Upstreams() {
+		p.startHealthCheck(upstream)
+	}
+}
+
+func (p *UpstreamPool) Stop() {
+	p.Lock()
+	cancel := p.cancel
+	p.Unlock()
+
+	if cancel != nil {
+		cancel()
+		p.wg.Wait()
+	}
+}
+
+// Private
+
+// startHealthCheck probes the upstream in the background, once health checks
+// have started, with the pool already locked.
+func (p *UpstreamPool) startHealthCheck(upstream *Upstream) {
+	if p.ctx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	upstream.stopChecks = cancel
+	client, check := p.client, p.check
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(check.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.recordCheck(upstream, check, p.probe(ctx, client, upstream, check))
+			}
+		}
+	}()
+}
+
+func (p *UpstreamPool) healthyUpstreams() []*Upstream {
+	upstreams := p.Upstreams()
+	healthy := make([]*Upstream, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if upstream.Healthy() {
+			healthy = append(healthy, upstream)
+		}
+	}
+	return healthy
+}
+
+func (p *UpstreamPool) availableUpstreams() []*Upstream {
+	upstreams := p.Upstreams()
+	available := make([]*Upstream, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if upstream.available() {
+			available = append(available, upstream)
+		}
+	}
+	return available
+}
+
+func (p *UpstreamPool) probe(ctx context.Context, client *http.Client, upstream *Upstream, check HealthCheck) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.target.JoinPath(check.Path).String(), nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Debug("Upstream health check failed", "upstream", upstream.URL.String(), "error", err)
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+func (p *UpstreamPool) recordCheck(upstream *Upstream, check HealthCheck, ok bool) {
+	if ok {
+		upstream.successes++
+		upstream.failures = 0
+	} else {
+		upstream.failures++
+		upstream.successes = 0
+	}
+
+	if upstream.Healthy() && upstream.failures >= max(check.UnhealthyThreshold, 1) {
+		upstream.healthy.Store(false)
+		slog.Warn("Upstream is unhealthy; removing it from the pool", "upstream", upstream.URL.String(), "failures", upstream.failures)
+	}
+
+	if !upstream.Healthy() && upstream.successes >= max(check.HealthyThreshold, 1) {
+		upstream.healthy.Store(true)
+		slog.Info("Upstream is healthy again; returning it to the pool", "upstream", upstream.URL.String())
+	}
+}
+
+type circuitBreakerSettings struct {
+	threshold int
+	cooldown  time.Duration
+	events    *EventDispatcher
+}
+
+// breakerFor returns a new breaker for the upstream, or nil when the pool has
+// no circuit breakers.
+func (s *circuitBreakerSettings) breakerFor(upstream *Upstream) *CircuitBreaker {
+	if s == nil {
+		return nil
+	}
+
+	breaker := NewCircuitBreaker(s.threshold, s.cooldown)
+	breaker.SetEvents(s.events)
+	breaker.upstream = upstream.URL.String()
+	return breaker
+}
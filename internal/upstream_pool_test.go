@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBalancingPolicy(t *testing.T) {
+	policy, err := ParseBalancingPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, BalancingRoundRobin, policy)
+
+	policy, err = ParseBalancingPolicy("Least_Connections")
+	require.NoError(t, err)
+	assert.Equal(t, BalancingLeastConnections, policy)
+
+	_, err = ParseBalancingPolicy("random")
+	assert.ErrorIs(t, err, ErrInvalidBalancingPolicy)
+}
+
+func TestParseTargetURLs(t *testing.T) {
+	targets, err := ParseTargetURLs([]string{"http://10.0.0.1:3000", "https://app.internal"})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:3000", targets[0].Host)
+	assert.Equal(t, "https", targets[1].Scheme)
+
+	_, err = ParseTargetURLs([]string{"10.0.0.1:3000"})
+	assert.ErrorIs(t, err, ErrInvalidTargetURL)
+
+	targets, err = ParseTargetURLs([]string{"unix:///tmp/app.sock"})
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/app.sock", targets[0].Path)
+
+	_, err = ParseTargetURLs([]string{"unix://"})
+	assert.ErrorIs(t, err, ErrInvalidTargetURL)
+}
+
+func TestUpstreamPool_Sockets(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "unix:///tmp/a.sock", "http://b", "unix:///tmp/c.sock")
+	upstreams := pool.Upstreams()
+
+	assert.Equal(t, map[string]string{
+		upstreams[0].target.Host: "/tmp/a.sock",
+		upstreams[2].target.Host: "/tmp/c.sock",
+	}, pool.Sockets())
+	assert.NotEqual(t, upstreams[0].target.Host, upstreams[2].target.Host)
+	assert.Equal(t, "http", upstreams[0].target.Scheme)
+}
+
+func TestUpstreamPool_round_robin(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b", "http://c")
+
+	hosts := []string{}
+	for range 6 {
+		upstream := pool.Acquire()
+		hosts = append(hosts, upstream.URL.Host)
+		pool.Release(upstream)
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, hosts)
+}
+
+func TestUpstreamPool_least_connections(t *testing.T) {
+	pool := testUpstreamPool(BalancingLeastConnections, "http://a", "http://b")
+
+	first := pool.Acquire()
+	second := pool.Acquire()
+	assert.NotEqual(t, first, second)
+
+	pool.Release(first)
+
+	// The first upstream is now idle, so it's chosen again regardless of turn
+	assert.Equal(t, first, pool.Acquire())
+	assert.Equal(t, int64(1), first.ActiveRequests())
+	assert.Equal(t, int64(1), second.ActiveRequests())
+}
+
+func TestUpstreamPool_skips_unhealthy_upstreams(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b")
+	check := HealthCheck{HealthyThreshold: 2, UnhealthyThreshold: 2}
+	a := pool.Upstreams()[0]
+
+	pool.recordCheck(a, check, false)
+	assert.True(t, a.Healthy())
+	pool.recordCheck(a, check, false)
+	assert.False(t, a.Healthy())
+
+	for range 4 {
+		assert.Equal(t, "b", pool.Acquire().URL.Host)
+	}
+
+	pool.recordCheck(a, check, true)
+	assert.False(t, a.Healthy())
+	pool.recordCheck(a, check, true)
+	assert.True(t, a.Healthy())
+}
+
+func TestUpstreamPool_uses_any_upstream_when_none_are_healthy(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a")
+	pool.Upstreams()[0].healthy.Store(false)
+
+	assert.Equal(t, "a", pool.Acquire().URL.Host)
+}
+
+func TestUpstreamPool_skips_upstreams_with_open_circuits(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b")
+	pool.SetCircuitBreakers(1, time.Minute, nil)
+	a, b := pool.Upstreams()[0], pool.Upstreams()[1]
+	assert.NotSame(t, a.CircuitBreaker(), b.CircuitBreaker())
+
+	a.CircuitBreaker().Failure()
+
+	for range 4 {
+		assert.Equal(t, "b", pool.Acquire().URL.Host)
+	}
+
+	pool.SetTargets(testTargetURLs("http://a", "http://c"))
+	assert.Same(t, a, pool.Upstreams()[0], "keeps the breaker of an upstream that stays")
+	assert.NotNil(t, pool.Upstreams()[1].CircuitBreaker(), "gives new upstreams a breaker")
+	assert.Equal(t, "c", pool.Acquire().URL.Host)
+}
+
+func TestUpstreamPool_AcquireSticky(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b", "http://c")
+	upstreams := pool.Upstreams()
+
+	t.Run("chooses the upstream with the ID", func(t *testing.T) {
+		for _, upstream := range upstreams {
+			chosen := pool.AcquireSticky(upstream.ID(), "10.0.0.1")
+			assert.Equal(t, upstream, chosen)
+			pool.Release(chosen)
+		}
+	})
+
+	t.Run("chooses the same upstream for the same key", func(t *testing.T) {
+		first := pool.AcquireSticky("", "10.0.0.1")
+		pool.Release(first)
+
+		for range 5 {
+			chosen := pool.AcquireSticky("unknown", "10.0.0.1")
+			assert.Equal(t, first, chosen)
+			pool.Release(chosen)
+		}
+	})
+
+	t.Run("spreads keys over the upstreams", func(t *testing.T) {
+		chosen := map[string]bool{}
+		for i := range 50 {
+			upstream := pool.AcquireSticky("", "10.0.0."+strconv.Itoa(i))
+			chosen[upstream.URL.Host] = true
+			pool.Release(upstream)
+		}
+		assert.Len(t, chosen, 3)
+	})
+
+	t.Run("only moves the keys of an unhealthy upstream", func(t *testing.T) {
+		before := map[string]*Upstream{}
+		for i := range 50 {
+			key := "10.0.0." + strconv.Itoa(i)
+			before[key] = pool.AcquireSticky("", key)
+			pool.Release(before[key])
+		}
+
+		upstreams[1].healthy.Store(false)
+		defer upstreams[1].healthy.Store(true)
+
+		for key, previous := range before {
+			upstream := pool.AcquireSticky("", key)
+			if previous == upstreams[1] {
+				assert.NotEqual(t, upstreams[1], upstream)
+			} else {
+				assert.Equal(t, previous, upstream)
+			}
+			pool.Release(upstream)
+		}
+	})
+
+	t.Run("counts the request as active", func(t *testing.T) {
+		upstream := pool.AcquireSticky(upstreams[0].ID(), "")
+		assert.Equal(t, int64(1), upstream.ActiveRequests())
+		pool.Release(upstream)
+	})
+}
+
+func TestUpstreamPool_health_checks(t *testing.T) {
+	var failing atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/up", r.URL.Path)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	pool := testUpstreamPool(BalancingRoundRobin, server.URL)
+	upstream := pool.Upstreams()[0]
+
+	pool.StartHealthChecks(HealthCheck{
+		Path:               "/up",
+		Interval:           10 * time.Millisecond,
+		Timeout:            time.Second,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+	}, http.DefaultTransport)
+	defer pool.Stop()
+
+	failing.Store(true)
+	assert.Eventually(t, func() bool { return !upstream.Healthy() }, time.Second, 10*time.Millisecond)
+
+	failing.Store(false)
+	assert.Eventually(t, upstream.Healthy, time.Second, 10*time.Millisecond)
+}
+
+func TestUpstreamPool_SetTargets(t *testing.T) {
+	pool := testUpstreamPool(BalancingRoundRobin, "http://a", "http://b")
+	a, b := pool.Upstreams()[0], pool.Upstreams()[1]
+
+	b.healthy.Store(false)
+	busy := pool.Acquire()
+	require.Equal(t, a, busy)
+
+	pool.SetTargets(testTargetURLs("http://b", "http://c"))
+	upstreams := pool.Upstreams()
+	require.Len(t, upstreams, 2)
+
+	assert.Same(t, b, upstreams[0], "keeps the upstreams it already had")
+	assert.False(t, upstreams[0].Healthy())
+	assert.Equal(t, "c", upstreams[1].URL.Host)
+	assert.True(t, upstreams[1].Healthy())
+
+	// Requests underway on a removed upstream finish as usual
+	pool.Release(busy)
+	assert.Zero(t, a.ActiveRequests())
+
+	for range 3 {
+		upstream := pool.Acquire()
+		assert.Equal(t, "c", upstream.URL.Host)
+		pool.Release(upstream)
+	}
+}
+
+func TestUpstreamPool_SetTargets_health_checks_new_upstreams(t *testing.T) {
+	var checked atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checked.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	pool := testUpstreamPool(BalancingRoundRobin, "http://127.0.0.1:1")
+	pool.StartHealthChecks(HealthCheck{
+		Path:               "/up",
+		Interval:           10 * time.Millisecond,
+		Timeout:            time.Second,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+	}, http.DefaultTransport)
+	defer pool.Stop()
+
+	pool.SetTargets(testTargetURLs(server.URL))
+	upstream := pool.Upstreams()[0]
+
+	assert.Eventually(t, func() bool { return !upstream.Healthy() }, time.Second, 10*time.Millisecond)
+
+	// Checks end once the upstream leaves the pool
+	pool.SetTargets(testTargetURLs("http://127.0.0.1:1"))
+	time.Sleep(30 * time.Millisecond)
+	count := checked.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, checked.Load())
+}
+
+// Helpers
+
+func testUpstreamPool(policy BalancingPolicy, targets ...string) *UpstreamPool {
+	return NewUpstreamPool(testTargetURLs(targets...), policy)
+}
+
+func testTargetURLs(targets ...string) []*url.URL {
+	urls := []*url.URL{}
+	for _, target := range targets {
+		u, _ := url.Parse(target)
+		urls = append(urls, u)
+	}
+	return urls
+}
@@ -21,20 +21,38 @@ func NewUpstreamProcess(name string, arg ...string) *UpstreamProcess {
 	}
 }
 
+// Run launches the process and waits for it to finish, relaying any SIGINT or
+// SIGTERM we receive to it in the meantime.
 func (p *UpstreamProcess) Run() (int, error) {
+	err := p.Start()
+	if err != nil {
+		return 0, err
+	}
+
+	go p.handleSignals()
+	return p.Wait()
+}
+
+// Start launches the process without waiting for it to finish. Signals are
+// left to the caller to relay.
+func (p *UpstreamProcess) Start() error {
 	p.cmd.Stdin = os.Stdin
 	p.cmd.Stdout = os.Stdout
 	p.cmd.Stderr = os.Stderr
 
 	err := p.cmd.Start()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
 	p.Started <- struct{}{}
 
-	go p.handleSignals()
-	err = p.cmd.Wait()
+	return nil
+}
+
+// Wait blocks until a started process exits, returning its exit code.
+func (p *UpstreamProcess) Wait() (int, error) {
+	err := p.cmd.Wait()
 
 	var exitErr *exec.ExitError
 	if errors.As(err, &exitErr) {
@@ -44,10 +62,32 @@ func (p *UpstreamProcess) Run() (int, error) {
 	return 0, err
 }
 
+// Pid returns the process ID of a started process, or 0 if it hasn't started.
+func (p *UpstreamProcess) Pid() int {
+	if p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
 func (p *UpstreamProcess) Signal(sig os.Signal) error {
 	return p.cmd.Process.Signal(sig)
 }
 
+// Stop asks the process to terminate, killing it outright on platforms that
+// can't deliver SIGTERM.
+func (p *UpstreamProcess) Stop() error {
+	if p.cmd.Process == nil {
+		return nil
+	}
+
+	err := p.Signal(syscall.SIGTERM)
+	if err != nil {
+		return p.cmd.Process.Kill()
+	}
+	return nil
+}
+
 func (p *UpstreamProcess) handleSignals() {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
@@ -21,15 +21,29 @@ func TestUpstreamProcess(t *testing.T) {
 		var err error
 
 		p := NewUpstreamProcess("sleep", "10")
+		done := make(chan struct{})
 
 		go func() {
 			exitCode, err = p.Run()
+			close(done)
 		}()
 
 		<-p.Started
 		p.Signal(syscall.SIGTERM)
+		<-done
 
 		assert.NoError(t, err)
-		assert.Equal(t, 0, exitCode)
+		assert.Equal(t, -1, exitCode)
+	})
+
+	t.Run("stop a process", func(t *testing.T) {
+		p := NewUpstreamProcess("sleep", "10")
+		assert.NoError(t, p.Start())
+
+		assert.NoError(t, p.Stop())
+
+		exitCode, err := p.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, -1, exitCode)
 	})
 }
@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultUpstreamLeakThreshold = 5 * time.Minute
+
+	// A count that has grown at every one of this many reports in a row is
+	// reported as a possible leak.
+	upstreamLeakTrendReports = 6
+)
+
+// UpstreamStats tracks the connections to upstreams and how well they're
+// reused, and looks for the kinds of leak that build up unnoticed in a
+// long-running proxy: response bodies that are never closed, and goroutines
+// or file descriptors that keep on growing.
+//
+// A response body that's closed before it's read to the end can't give its
+// connection back for reuse, so those are counted as undrained. Bodies still
+// open after the leak threshold are reported along with the path they were
+// requested for; a streaming response can legitimately stay open that long,
+// but anything else points at a handler that doesn't close what it's given.
+//
+// A nil *UpstreamStats is valid, and counts nothing.
+type UpstreamStats struct {
+	opened    atomic.Uint64
+	closed    atomic.Uint64
+	requests  atomic.Uint64
+	reused    atomic.Uint64
+	undrained atomic.Uint64
+
+	leakThreshold time.Duration
+	now           func() time.Time
+
+	bodiesMu sync.Mutex
+	bodies   map[*trackedBody]struct{}
+
+	goroutines upstreamLeakTrend
+	openFiles  upstreamLeakTrend
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type UpstreamStatsSnapshot struct {
+	Opened       uint64
+	Closed       uint64
+	Requests     uint64
+	Reused       uint64
+	Undrained    uint64
+	OpenBodies   int
+	LeakedBodies int
+	Goroutines   int
+	OpenFiles    int
+}
+
+func NewUpstreamStats(leakThreshold time.Duration) *UpstreamStats {
+	return &UpstreamStats{
+		leakThreshold: leakThreshold,
+		now:           time.Now,
+		bodies:        map[*trackedBody]struct{}{},
+	}
+}
+
+// Open is the number of connections to upstreams currently open.
+func (s UpstreamStatsSnapshot) Open() uint64 {
+	return s.Opened - s.Closed
+}
+
+// ReuseRatio is the proportion of requests sent on a connection that had
+// already been used.
+func (s UpstreamStatsSnapshot) ReuseRatio() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Reused) / float64(s.Requests)
+}
+
+func (s *UpstreamStats) Snapshot() UpstreamStatsSnapshot {
+	if s == nil {
+		return UpstreamStatsSnapshot{}
+	}
+
+	open, leaked := s.openBodies()
+
+	return UpstreamStatsSnapshot{
+		Opened:       s.opened.Load(),
+		Closed:       s.closed.Load(),
+		Requests:     s.requests.Load(),
+		Reused:       s.reused.Load(),
+		Undrained:    s.undrained.Load(),
+		OpenBodies:   open,
+		LeakedBodies: len(leaked),
+		Goroutines:   runtime.NumGoroutine(),
+		OpenFiles:    countOpenFiles(),
+	}
+}
+
+// StartLogging logs a snapshot of the stats at each interval, along with any
+// leaks found, until stopped.
+func (s *UpstreamStats) StartLogging(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.report()
+			}
+		}
+	}()
+}
+
+func (s *UpstreamStats) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+	}
+}
+
+// Private
+
+func (s *UpstreamStats) report() {
+	snapshot := s.Snapshot()
+	snapshot.log()
+
+	_, leaked := s.openBodies()
+	for _, body := range leaked {
+		if body.reported.CompareAndSwap(false, true) {
+			slog.Warn("Upstream response body still open; possible leak", "path", body.path, "age", s.now().Sub(body.openedAt).Round(time.Second).String())
+		}
+	}
+
+	if s.goroutines.record(snapshot.Goroutines) {
+		slog.Warn("Goroutines keep growing; possible leak", "goroutines", snapshot.Goroutines, "reports", upstreamLeakTrendReports)
+	}
+	if snapshot.OpenFiles >= 0 && s.openFiles.record(snapshot.OpenFiles) {
+		slog.Warn("Open files keep growing; possible leak", "open_files", snapshot.OpenFiles, "reports", upstreamLeakTrendReports)
+	}
+}
+
+func (s *UpstreamStats) openBodies() (int, []*trackedBody) {
+	s.bodiesMu.Lock()
+	defer s.bodiesMu.Unlock()
+
+	var leaked []*trackedBody
+	if s.leakThreshold > 0 {
+		cutoff := s.now().Add(-s.leakThreshold)
+		for body := range s.bodies {
+			if body.openedAt.Before(cutoff) {
+				leaked = append(leaked, body)
+			}
+		}
+	}
+
+	return len(s.bodies), leaked
+}
+
+// trackDial counts the connections made with dial, and those closed again.
+func (s *UpstreamStats) trackDial(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if s == nil {
+		return dial
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return conn, err
+		}
+
+		s.opened.Add(1)
+		return &trackedConn{Conn: conn, stats: s}, nil
+	}
+}
+
+// trackRequest notes whether the request is sent on a reused connection.
+func (s *UpstreamStats) trackRequest(req *http.Request) *http.Request {
+	if s == nil {
+		return req
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.requests.Add(1)
+			if info.Reused {
+				s.reused.Add(1)
+			}
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// trackResponse watches the response's body until it's closed. Upgraded
+// connections are left alone, as the proxy needs to write to their bodies.
+func (s *UpstreamStats) trackResponse(resp *http.Response) {
+	if s == nil || resp == nil || resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		return
+	}
+
+	body := &trackedBody{ReadCloser: resp.Body, stats: s, path: resp.Request.URL.Path, openedAt: s.now()}
+	resp.Body = body
+
+	s.bodiesMu.Lock()
+	s.bodies[body] = struct{}{}
+	s.bodiesMu.Unlock()
+}
+
+func (s UpstreamStatsSnapshot) log() {
+	args := []any{
+		"open", s.Open(),
+		"opened", s.Opened,
+		"closed", s.Closed,
+		"requests", s.Requests,
+		"reused", s.Reused,
+		"reuse_ratio", s.ReuseRatio(),
+		"undrained", s.Undrained,
+		"open_bodies", s.OpenBodies,
+		"leaked_bodies", s.LeakedBodies,
+		"goroutines", s.Goroutines,
+	}
+	if s.OpenFiles >= 0 {
+		args = append(args, "open_files", s.OpenFiles)
+	}
+
+	slog.Info("Upstream stats", args...)
+}
+
+type trackedConn struct {
+	net.Conn
+	stats  *UpstreamStats
+	closed atomic.Bool
+}
+
+func (c *trackedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.stats.closed.Add(1)
+	}
+	return c.Conn.Close()
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	stats    *UpstreamStats
+	path     string
+	openedAt time.Time
+	drained  atomic.Bool
+	closed   atomic.Bool
+	reported atomic.Bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.drained.Store(true)
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	if b.closed.CompareAndSwap(false, true) {
+		if !b.drained.Load() {
+			b.stats.undrained.Add(1)
+		}
+
+		b.stats.bodiesMu.Lock()
+		delete(b.stats.bodies, b)
+		b.stats.bodiesMu.Unlock()
+	}
+	return b.ReadCloser.Close()
+}
+
+// upstreamLeakTrend spots a count that rises at every report.
+type upstreamLeakTrend struct {
+	last   int
+	rising int
+}
+
+func (t *upstreamLeakTrend) record(value int) bool {
+	if t.last > 0 && value > t.last {
+		t.rising++
+	} else {
+		t.rising = 0
+	}
+	t.last = value
+
+	if t.rising >= upstreamLeakTrendReports {
+		t.rising = 0
+		return true
+	}
+	return false
+}
+
+// countOpenFiles counts the process's open file descriptors, or returns -1
+// where that isn't possible.
+func countOpenFiles() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		entries, err := os.ReadDir(dir)
+		if err == nil {
+			return len(entries)
+		}
+	}
+	return -1
+}
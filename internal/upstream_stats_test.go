@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamStats_connection_reuse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	stats := NewUpstreamStats(time.Minute)
+	pool := NewUpstreamPool([]*url.URL{target}, BalancingRoundRobin)
+	h := NewProxyHandler(pool, NewUpstreamTransport(pool, TargetProtocolHTTP1, UpstreamTimeouts{}, nil, stats), RetryPolicy{}, "", nil, true)
+	h.SetUpstreamStats(stats)
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, "hello", w.Body.String())
+	}
+
+	snapshot := stats.Snapshot()
+	assert.Equal(t, uint64(1), snapshot.Opened)
+	assert.Equal(t, uint64(1), snapshot.Open())
+	assert.Equal(t, uint64(3), snapshot.Requests)
+	assert.Equal(t, uint64(2), snapshot.Reused)
+	assert.InDelta(t, 2.0/3.0, snapshot.ReuseRatio(), 0.001)
+	assert.Equal(t, uint64(0), snapshot.Undrained)
+	assert.Equal(t, 0, snapshot.OpenBodies)
+
+	h.transport.CloseIdleConnections()
+	assert.Equal(t, uint64(1), stats.Snapshot().Closed)
+}
+
+func TestUpstreamStats_tracks_open_bodies(t *testing.T) {
+	now := time.Now()
+	stats := NewUpstreamStats(time.Minute)
+	stats.now = func() time.Time { return now }
+
+	response := func(path string) *http.Response {
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("body")),
+			Request:    httptest.NewRequest("GET", path, nil),
+		}
+		stats.trackResponse(resp)
+		return resp
+	}
+
+	drained := response("/drained")
+	undrained := response("/undrained")
+	leaked := response("/leaked")
+
+	io.ReadAll(drained.Body)
+	drained.Body.Close()
+	undrained.Body.Close()
+	undrained.Body.Close()
+
+	snapshot := stats.Snapshot()
+	assert.Equal(t, uint64(1), snapshot.Undrained)
+	assert.Equal(t, 1, snapshot.OpenBodies)
+	assert.Equal(t, 0, snapshot.LeakedBodies)
+
+	now = now.Add(2 * time.Minute)
+	_, bodies := stats.openBodies()
+	require.Len(t, bodies, 1)
+	assert.Equal(t, "/leaked", bodies[0].path)
+	assert.Equal(t, 1, stats.Snapshot().LeakedBodies)
+
+	leaked.Body.Close()
+	assert.Equal(t, 0, stats.Snapshot().OpenBodies)
+}
+
+func TestUpstreamStats_leaves_upgraded_connections_alone(t *testing.T) {
+	stats := NewUpstreamStats(time.Minute)
+	body := io.NopCloser(strings.NewReader(""))
+	resp := &http.Response{StatusCode: http.StatusSwitchingProtocols, Body: body, Request: httptest.NewRequest("GET", "/", nil)}
+
+	stats.trackResponse(resp)
+	assert.Equal(t, body, resp.Body)
+	assert.Equal(t, 0, stats.Snapshot().OpenBodies)
+}
+
+func TestUpstreamStats_nil_counts_nothing(t *testing.T) {
+	var stats *UpstreamStats
+
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader("")), Request: httptest.NewRequest("GET", "/", nil)}
+	stats.trackResponse(resp)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	assert.Equal(t, req, stats.trackRequest(req))
+	assert.Equal(t, UpstreamStatsSnapshot{}, stats.Snapshot())
+}
+
+func TestUpstreamLeakTrend(t *testing.T) {
+	var trend upstreamLeakTrend
+
+	for i, value := range []int{10, 11, 12, 12, 13, 14, 15, 16, 17} {
+		assert.False(t, trend.record(value), "report %d", i)
+	}
+	assert.True(t, trend.record(18))
+	assert.False(t, trend.record(19), "reported once per run of growth")
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrUpstreamTLSKeyPairIncomplete = errors.New("client certificate and key must be given together")
+	ErrUpstreamTLSNoCACertificates  = errors.New("no certificates found in CA file")
+)
+
+// UpstreamTLS describes how we authenticate to, and verify, upstreams that
+// are reached over HTTPS.
+type UpstreamTLS struct {
+	CertFile           string
+	KeyFile            string
+	CAFile             string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (t UpstreamTLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.CAFile != "" || t.ServerName != "" || t.InsecureSkipVerify
+}
+
+// ClientConfig loads the configured files into a TLS config for the proxy
+// transport. It returns nil when nothing is configured, so that the
+// transport's defaults apply.
+func (t UpstreamTLS) ClientConfig() (*tls.Config, error) {
+	if !t.Enabled() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, ErrUpstreamTLSKeyPairIncomplete
+		}
+
+		certificate, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrUpstreamTLSNoCACertificates
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamTLS_disabled_by_default(t *testing.T) {
+	config, err := UpstreamTLS{}.ClientConfig()
+	require.NoError(t, err)
+	assert.Nil(t, config)
+}
+
+func TestUpstreamTLS_loads_files(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	certFile, keyFile := ca.issue(t, "client")
+
+	config, err := UpstreamTLS{CertFile: certFile, KeyFile: keyFile, CAFile: ca.file, ServerName: "origin.internal"}.ClientConfig()
+	require.NoError(t, err)
+
+	assert.Len(t, config.Certificates, 1)
+	assert.NotNil(t, config.RootCAs)
+	assert.Equal(t, "origin.internal", config.ServerName)
+	assert.False(t, config.InsecureSkipVerify)
+}
+
+func TestUpstreamTLS_invalid_settings(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	certFile, _ := ca.issue(t, "client")
+
+	_, err := UpstreamTLS{CertFile: certFile}.ClientConfig()
+	assert.ErrorIs(t, err, ErrUpstreamTLSKeyPairIncomplete)
+
+	_, err = UpstreamTLS{CertFile: certFile, KeyFile: certFile}.ClientConfig()
+	assert.Error(t, err)
+
+	_, err = UpstreamTLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")}.ClientConfig()
+	assert.Error(t, err)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0600))
+	_, err = UpstreamTLS{CAFile: empty}.ClientConfig()
+	assert.ErrorIs(t, err, ErrUpstreamTLSNoCACertificates)
+}
+
+func TestProxyHandler_mutual_tls_upstream(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	serverCertFile, serverKeyFile := ca.issue(t, "server")
+	clientCertFile, clientKeyFile := ca.issue(t, "client")
+
+	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	require.NoError(t, err)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client-Subject", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	targetUrl, _ := url.Parse(upstream.URL)
+
+	proxy := func(settings UpstreamTLS) *httptest.ResponseRecorder {
+		tlsConfig, err := settings.ClientConfig()
+		require.NoError(t, err)
+
+		h := newTestProxyHandler(NewUpstreamPool([]*url.URL{targetUrl}, BalancingRoundRobin), TargetProtocolHTTP1, UpstreamTimeouts{}, tlsConfig)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+
+	t.Run("presents the client certificate", func(t *testing.T) {
+		w := proxy(UpstreamTLS{CertFile: clientCertFile, KeyFile: clientKeyFile, CAFile: ca.file})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "client", w.Header().Get("X-Client-Subject"))
+	})
+
+	t.Run("fails without the client certificate", func(t *testing.T) {
+		w := proxy(UpstreamTLS{CAFile: ca.file})
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("fails to verify the upstream without the CA", func(t *testing.T) {
+		w := proxy(UpstreamTLS{CertFile: clientCertFile, KeyFile: clientKeyFile})
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("can skip verifying the upstream", func(t *testing.T) {
+		w := proxy(UpstreamTLS{CertFile: clientCertFile, KeyFile: clientKeyFile, InsecureSkipVerify: true})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+// Helpers
+
+type testCertificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	file string
+}
+
+func newTestCertificateAuthority(t *testing.T) *testCertificateAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	return &testCertificateAuthority{cert: cert, key: key, pool: pool, file: file}
+}
+
+// issue creates a certificate signed by the CA that is valid for both client
+// and server use on 127.0.0.1, returning the paths of its PEM files.
+func (ca *testCertificateAuthority) issue(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
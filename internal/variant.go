@@ -4,11 +4,16 @@ import (
 	"hash/fnv"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
 )
 
+// Variant identifies the version of a response that a request should get. It
+// takes in the request headers named by the response's Vary header, along with
+// any that are configured to always vary the response.
 type Variant struct {
 	r           *http.Request
+	keyHeaders  []string
 	headerNames []string
 }
 
@@ -16,8 +21,15 @@ func NewVariant(r *http.Request) *Variant {
 	return &Variant{r: r}
 }
 
+// SetKeyHeaders sets request headers that distinguish responses whether or
+// not the response says it varies by them.
+func (v *Variant) SetKeyHeaders(names []string) {
+	v.keyHeaders = canonicalHeaderNames(names)
+	v.headerNames = mergeHeaderNames(v.keyHeaders, v.headerNames)
+}
+
 func (v *Variant) SetResponseHeader(header http.Header) {
-	v.headerNames = v.parseVaryHeader(header)
+	v.headerNames = mergeHeaderNames(v.keyHeaders, v.parseVaryHeader(header))
 }
 
 func (v *Variant) CacheKey() CacheKey {
@@ -28,7 +40,7 @@ func (v *Variant) CacheKey() CacheKey {
 	hash.Write([]byte(v.r.Host))
 
 	for _, name := range v.headerNames {
-		hash.Write([]byte(name + "=" + v.r.Header.Get(name)))
+		hash.Write([]byte(name + "=" + v.requestValue(name)))
 	}
 
 	return CacheKey(hash.Sum64())
@@ -36,7 +48,7 @@ func (v *Variant) CacheKey() CacheKey {
 
 func (v *Variant) Matches(responseHeader http.Header) bool {
 	for _, name := range v.headerNames {
-		if responseHeader.Get(name) != v.r.Header.Get(name) {
+		if responseHeader.Get(name) != v.requestValue(name) {
 			return false
 		}
 	}
@@ -46,7 +58,7 @@ func (v *Variant) Matches(responseHeader http.Header) bool {
 func (v *Variant) VariantHeader() http.Header {
 	requestHeader := http.Header{}
 	for _, name := range v.headerNames {
-		requestHeader.Set(name, v.r.Header.Get(name))
+		requestHeader.Set(name, v.requestValue(name))
 	}
 	return requestHeader
 }
@@ -59,11 +71,70 @@ func (v *Variant) parseVaryHeader(responseHeader http.Header) []string {
 		return []string{}
 	}
 
-	names := strings.Split(list, ",")
-	for i, name := range names {
-		names[i] = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	return canonicalHeaderNames(strings.Split(list, ","))
+}
+
+// requestValue is the request's value for a header, normalized so that
+// requests that are equivalent for the purpose of choosing a response share
+// a cache entry.
+func (v *Variant) requestValue(name string) string {
+	value := v.r.Header.Get(name)
+
+	switch name {
+	case "Accept-Encoding":
+		return normalizeAcceptEncoding(value)
+	case "Accept-Language":
+		return strings.ToLower(strings.Join(strings.Fields(value), ""))
+	default:
+		return strings.TrimSpace(value)
+	}
+}
+
+// normalizeAcceptEncoding reduces an Accept-Encoding value to the sorted set
+// of codings it accepts, since their order and weights don't change which
+// encoding an upstream picks in practice.
+func normalizeAcceptEncoding(value string) string {
+	codings := []string{}
+	for _, part := range strings.Split(value, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		if coding == "" || rejectsCoding(params) {
+			continue
+		}
+
+		codings = append(codings, coding)
+	}
+
+	slices.Sort(codings)
+	return strings.Join(slices.Compact(codings), ",")
+}
+
+func rejectsCoding(params string) bool {
+	value, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+	if !ok {
+		return false
 	}
-	slices.Sort(names)
 
-	return names
+	quality, err := strconv.ParseFloat(value, 64)
+	return err == nil && quality == 0
+}
+
+func canonicalHeaderNames(names []string) []string {
+	result := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			result = append(result, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+func mergeHeaderNames(a, b []string) []string {
+	merged := append(slices.Clone(a), b...)
+	slices.Sort(merged)
+	return slices.Compact(merged)
 }
@@ -68,3 +68,37 @@ func TestVariantMatches_missing_headers(t *testing.T) {
 	assert.True(t, v.Matches(http.Header{"Accept-Encoding": []string{"gzip"}}))
 	assert.False(t, v.Matches(http.Header{"Accept-Encoding": []string{"gzip"}, "Accept": []string{"text/html"}}))
 }
+
+func TestVariantCacheKey_includes_key_headers(t *testing.T) {
+	r1 := httptest.NewRequest("GET", "/home", nil)
+	r1.Header.Set("X-GeoIP-Country", "US")
+	r2 := httptest.NewRequest("GET", "/home", nil)
+	r2.Header.Set("X-GeoIP-Country", "GB")
+
+	v1 := NewVariant(r1)
+	v2 := NewVariant(r2)
+	v1.SetKeyHeaders([]string{"x-geoip-country"})
+	v2.SetKeyHeaders([]string{"x-geoip-country"})
+
+	assert.NotEqual(t, v1.CacheKey(), v2.CacheKey())
+
+	v1.SetResponseHeader(http.Header{"Vary": []string{"Accept"}})
+	assert.Equal(t, http.Header{"Accept": []string{""}, "X-Geoip-Country": []string{"US"}}, v1.VariantHeader())
+}
+
+func TestVariantCacheKey_normalizes_header_values(t *testing.T) {
+	keyFor := func(name, value string) CacheKey {
+		r := httptest.NewRequest("GET", "/home", nil)
+		r.Header.Set(name, value)
+
+		v := NewVariant(r)
+		v.SetResponseHeader(http.Header{"Vary": []string{name}})
+		return v.CacheKey()
+	}
+
+	assert.Equal(t, keyFor("Accept-Encoding", "gzip, br"), keyFor("Accept-Encoding", "br;q=0.8,GZIP, identity;q=0"))
+	assert.NotEqual(t, keyFor("Accept-Encoding", "gzip, br"), keyFor("Accept-Encoding", "gzip"))
+
+	assert.Equal(t, keyFor("Accept-Language", "en-US,en;q=0.9"), keyFor("Accept-Language", "en-us, en;q=0.9"))
+	assert.NotEqual(t, keyFor("Accept-Language", "en-US,en;q=0.9"), keyFor("Accept-Language", "de-DE,en;q=0.9"))
+}
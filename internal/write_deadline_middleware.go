@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"net/http"
+	"time"
+)
+
+// WriteDeadlineMiddleware gives each write to the client its own deadline,
+// in place of the server's deadline for the response as a whole. A client
+// that keeps reading can take as long as it needs over a large download, but
+// one that stalls is disconnected, rather than pinning the response (and
+// whatever is buffered for it) in memory.
+//
+// Streams of server-sent events can go quiet for as long as there's nothing
+// to send, so they have no deadline at all.
+type WriteDeadlineMiddleware struct {
+	timeout time.Duration
+	next    http.Handler
+}
+
+func NewWriteDeadlineMiddleware(timeout time.Duration, next http.Handler) *WriteDeadlineMiddleware {
+	return &WriteDeadlineMiddleware{
+		timeout: timeout,
+		next:    next,
+	}
+}
+
+func (h *WriteDeadlineMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.next.ServeHTTP(newDeadlineWriter(w, h.timeout), r)
+}
+
+type deadlineWriter struct {
+	http.ResponseWriter
+	controller  *http.ResponseController
+	timeout     time.Duration
+	wroteHeader bool
+	streaming   bool
+}
+
+func newDeadlineWriter(w http.ResponseWriter, timeout time.Duration) *deadlineWriter {
+	return &deadlineWriter{
+		ResponseWriter: w,
+		controller:     http.NewResponseController(w),
+		timeout:        timeout,
+	}
+}
+
+func (w *deadlineWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader && statusCode >= http.StatusOK {
+		w.wroteHeader = true
+		w.streaming = isEventStreamResponse(w.Header())
+	}
+
+	w.extendDeadline()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *deadlineWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.extendDeadline()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *deadlineWriter) Flush() {
+	w.extendDeadline()
+	w.controller.Flush()
+}
+
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Private
+
+func (w *deadlineWriter) extendDeadline() {
+	if w.streaming {
+		w.controller.SetWriteDeadline(time.Time{})
+		return
+	}
+	w.controller.SetWriteDeadline(time.Now().Add(w.timeout))
+}
@@ -0,0 +1,150 @@
+package thruster
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateAPI = flag.Bool("update-api", false, "record the current API in testdata/api.txt")
+
+// TestAPI_compatibility fails when anything is removed from or changed in the
+// API recorded in testdata/api.txt, and when something is added without being
+// recorded there, so that every change to the API is a deliberate one.
+func TestAPI_compatibility(t *testing.T) {
+	current := exportedAPI(t)
+
+	if *updateAPI {
+		require.NoError(t, os.WriteFile("testdata/api.txt", []byte(strings.Join(current, "\n")+"\n"), 0644))
+		return
+	}
+
+	content, err := os.ReadFile("testdata/api.txt")
+	require.NoError(t, err)
+	recorded := strings.Split(strings.TrimSpace(string(content)), "\n")
+
+	for _, line := range recorded {
+		assert.Contains(t, current, line, "removed or changed; v1 must stay compatible, so deprecate it instead")
+	}
+	for _, line := range current {
+		assert.Contains(t, recorded, line, "not recorded; run the tests with -update-api once the addition is intended")
+	}
+}
+
+func TestAPI_deprecated_identifiers_still_work(t *testing.T) {
+	handler := Gzip()(textHandler(strings.Repeat("compressible ", 200)))
+
+	w := serve(handler, "GET", "/", func(r *http.Request) {
+		r.Header.Set("Accept-Encoding", "br, gzip")
+	})
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+}
+
+// Helpers
+
+// exportedAPI lists the package's exported declarations, one per line, with
+// struct fields listed separately so that adding one isn't a change to the
+// others.
+func exportedAPI(t *testing.T) []string {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+
+	format := func(node any) string {
+		var b bytes.Buffer
+		require.NoError(t, printer.Fprint(&b, fset, node))
+		return strings.Join(strings.Fields(b.String()), " ")
+	}
+
+	var lines []string
+	for _, file := range packages["thruster"].Files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if !decl.Name.IsExported() || (decl.Recv != nil && !receiverExported(decl.Recv)) {
+					continue
+				}
+				// Names of receivers and parameters aren't part of the API
+				signature := &ast.FuncType{Params: unnamed(decl.Type.Params), Results: unnamed(decl.Type.Results)}
+				lines = append(lines, format(&ast.FuncDecl{Recv: unnamed(decl.Recv), Name: decl.Name, Type: signature}))
+
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if !spec.Name.IsExported() {
+							continue
+						}
+						structType, ok := spec.Type.(*ast.StructType)
+						if !ok {
+							lines = append(lines, "type "+spec.Name.Name+" "+format(spec.Type))
+							continue
+						}
+						lines = append(lines, "type "+spec.Name.Name+" struct")
+						for _, field := range structType.Fields.List {
+							for _, name := range field.Names {
+								if name.IsExported() {
+									lines = append(lines, "type "+spec.Name.Name+" struct, "+name.Name+" "+format(field.Type))
+								}
+							}
+						}
+
+					case *ast.ValueSpec:
+						for i, name := range spec.Names {
+							if !name.IsExported() {
+								continue
+							}
+							line := decl.Tok.String() + " " + name.Name
+							if spec.Type != nil {
+								line += " " + format(spec.Type)
+							}
+							if decl.Tok == token.CONST && i < len(spec.Values) {
+								line += " = " + format(spec.Values[i])
+							}
+							lines = append(lines, line)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	slices.Sort(lines)
+	return lines
+}
+
+func unnamed(fields *ast.FieldList) *ast.FieldList {
+	if fields == nil {
+		return nil
+	}
+
+	result := &ast.FieldList{}
+	for _, field := range fields.List {
+		for range max(len(field.Names), 1) {
+			result.List = append(result.List, &ast.Field{Type: field.Type})
+		}
+	}
+	return result
+}
+
+func receiverExported(recv *ast.FieldList) bool {
+	expr := recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.IsExported()
+}
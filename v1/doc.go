@@ -0,0 +1,52 @@
+// Package thruster is the stable public API for using Thruster's filtering
+// middleware from other Go programs.
+//
+// Everything else in this module lives under internal/ and changes freely
+// between releases. This package wraps the parts that are useful on their own
+// (the middleware, the GeoIP2 resolver, and the rules and options that
+// configure them) in types of its own, so that those changes don't reach the
+// programs that depend on it.
+//
+// # Compatibility
+//
+// The API follows semantic versioning, as given by [APIVersion]. Within v1:
+//
+//   - Exported identifiers are never removed or renamed, and the signatures
+//     of functions and methods don't change.
+//   - Fields may be added to option structs, with a zero value that keeps the
+//     existing behavior, so set them by name.
+//   - Identifiers that are superseded are marked Deprecated, and keep working
+//     for the rest of v1.
+//
+// The exported API is recorded in testdata/api.txt, and the tests fail if
+// anything listed there goes missing.
+//
+// # Usage
+//
+// Middleware wrap an http.Handler, and can be combined with [Chain]:
+//
+//	resolver, err := thruster.OpenGeoIP2("GeoLite2-Country.mmdb")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer resolver.Close()
+//
+//	handler := thruster.Chain(
+//		thruster.ClientAddress(thruster.ClientAddressOptions{TrustedProxies: proxies}),
+//		thruster.RequestID(thruster.RequestIDOptions{}),
+//		thruster.CountryFilter(resolver, thruster.CountryFilterOptions{Block: []string{"KP"}}),
+//		thruster.ClientRateLimit(thruster.RateLimit{Rate: 10, Burst: 20}, thruster.RateLimitOptions{}),
+//	)(app)
+//
+// To place middleware of your own among them by name, build them as [Stages]
+// instead, which start out with Thruster's stages in Thruster's order.
+//
+// Behind a proxy, [ClientAddress] goes first, so that the others know the
+// client by the address the proxy received the request from.
+//
+// Middleware that depend on the client's country, such as [CountryRateLimit]
+// and [Maintenance], need [CountryFilter] to run before them.
+package thruster
+
+// APIVersion is the version of this package's API.
+const APIVersion = "1.2.0"
@@ -0,0 +1,221 @@
+package thruster
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/basecamp/thruster/internal"
+)
+
+const DefaultBodyInspectionMaxSize = 16 * 1024
+
+// Middleware wraps a handler with some behavior of its own.
+type Middleware func(http.Handler) http.Handler
+
+// Chain combines middleware into one, with the first given outermost, so
+// that it sees each request first.
+func Chain(middleware ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		return next
+	}
+}
+
+type ClientAddressOptions struct {
+	// TrustedProxies are the networks of the proxies in front of the
+	// program, whose X-Forwarded-For is believed. Without any, the client's
+	// address is always that of the connection.
+	TrustedProxies []*net.IPNet
+}
+
+// ClientAddress works out the address of each request's client, for the
+// middleware after it to filter and limit by. That's the address of the
+// connection, unless it's one of the TrustedProxies, in which case it's the
+// rightmost address in X-Forwarded-For that isn't. Without ClientAddress,
+// X-Forwarded-For is ignored.
+func ClientAddress(options ClientAddressOptions) Middleware {
+	proxies := internal.NewTrustedProxies(options.TrustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return internal.NewClientAddressMiddleware(proxies, next)
+	}
+}
+
+type CountryFilterOptions struct {
+	// Allow, when set, refuses requests from all other countries.
+	Allow []string
+	// Block refuses requests from these countries. It's ignored when Allow is
+	// set.
+	Block []string
+	// OptionsPolicy and HeadPolicy decide what blocked OPTIONS and HEAD
+	// requests get back. Both default to BlockPolicyDeny.
+	OptionsPolicy BlockPolicy
+	HeadPolicy    BlockPolicy
+	Logger        *slog.Logger
+}
+
+// CountryFilter resolves the country of each request's client, and refuses
+// requests from countries that aren't allowed. The country is passed on to
+// later middleware (see CountryFromContext) and to the app, in the
+// X-GeoIP-Country header. Requests from local and private addresses are
+// always allowed.
+func CountryFilter(resolver *GeoIP2Resolver, options CountryFilterOptions) Middleware {
+	policies := internal.BlockPolicies{
+		Options: options.OptionsPolicy.toInternal(),
+		Head:    options.HeadPolicy.toInternal(),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return withRequestTags(internal.NewGeoIPMiddleware(resolver.resolver, logger(options.Logger), next, options.Allow, options.Block, policies))
+	}
+}
+
+type RateLimitOptions struct {
+	// ExemptNetworks are never limited, such as those of health checkers.
+	ExemptNetworks []*net.IPNet
+	Logger         *slog.Logger
+}
+
+// ClientRateLimit limits how often each client address can make requests.
+// Requests over the limit are answered with a 429 and a Retry-After.
+func ClientRateLimit(limit RateLimit, options RateLimitOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return withRequestTags(internal.NewClientRateLimitMiddleware(logger(options.Logger), next, limit.toInternal(), options.ExemptNetworks, internal.NewRateLimiter(nil)))
+	}
+}
+
+// CountryRateLimit limits how often requests can be made from each country,
+// using defaultLimit for countries without one of their own. Requests whose
+// country isn't known aren't limited.
+func CountryRateLimit(limits map[string]RateLimit, defaultLimit RateLimit, options RateLimitOptions) Middleware {
+	internalLimits := map[string]internal.RateLimit{}
+	for country, limit := range limits {
+		internalLimits[country] = limit.toInternal()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return withRequestTags(internal.NewCountryRateLimitMiddleware(logger(options.Logger), next, internalLimits, defaultLimit.toInternal(), internal.NewRateLimiter(nil)))
+	}
+}
+
+type BodyInspectionOptions struct {
+	// MaxSize is the largest body inspected, in bytes. Larger bodies sent to
+	// paths with rules are refused. Defaults to DefaultBodyInspectionMaxSize.
+	MaxSize int
+	Logger  *slog.Logger
+}
+
+// BodyInspection refuses requests whose body matches one of the rules.
+func BodyInspection(rules []BodyRule, options BodyInspectionOptions) Middleware {
+	internalRules := make(internal.BodyRules, len(rules))
+	for i, rule := range rules {
+		internalRules[i] = rule.toInternal()
+	}
+
+	maxSize := options.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultBodyInspectionMaxSize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return withRequestTags(internal.NewBodyInspectionMiddleware(logger(options.Logger), next, internalRules, maxSize))
+	}
+}
+
+type MaintenanceOptions struct {
+	Logger *slog.Logger
+}
+
+// Maintenance answers requests from countries in a maintenance window with a
+// 503, and a Retry-After of when the window closes.
+func Maintenance(windows []MaintenanceWindow, options MaintenanceOptions) Middleware {
+	internalWindows := make(internal.MaintenanceWindows, len(windows))
+	for i, window := range windows {
+		internalWindows[i] = window.toInternal()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return withRequestTags(internal.NewMaintenanceMiddleware(logger(options.Logger), next, internalWindows))
+	}
+}
+
+type RequestIDOptions struct {
+	// Header carries the ID. Defaults to X-Request-ID.
+	Header string
+	// TrustIncoming keeps IDs sent with requests, for when a proxy in front
+	// of us sets them.
+	TrustIncoming bool
+}
+
+// RequestID gives each request an ID, sent on to the app and back to the
+// client, and available from RequestIDFromContext.
+func RequestID(options RequestIDOptions) Middleware {
+	header := options.Header
+	if header == "" {
+		header = "X-Request-ID"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return withRequestTags(internal.NewRequestIDMiddleware(http.CanonicalHeaderKey(header), options.TrustIncoming, next))
+	}
+}
+
+// Gzip compresses responses with gzip.
+//
+// Deprecated: use Compression, which also negotiates Brotli and zstd.
+func Gzip() Middleware {
+	return Compression(CompressionOptions{Encodings: []string{"gzip"}})
+}
+
+type CompressionOptions struct {
+	// Encodings are those offered, in order of preference, from "br",
+	// "zstd" and "gzip". Others are ignored. Defaults to all three.
+	Encodings []string
+	// MinSize is the smallest response compressed, in bytes.
+	MinSize int
+}
+
+// Compression compresses responses in the best encoding the client accepts.
+func Compression(options CompressionOptions) Middleware {
+	var encodings []internal.CompressionEncoding
+	for _, name := range options.Encodings {
+		if encoding, err := internal.ParseCompressionEncodings([]string{name}); err == nil {
+			encodings = append(encodings, encoding...)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return internal.NewCompressionMiddleware(internal.CompressionSettings{Encodings: encodings, MinSize: options.MinSize}, next)
+	}
+}
+
+// CountryFromContext returns the ISO code of the country CountryFilter
+// resolved for the request, or an empty string if it has none.
+func CountryFromContext(ctx context.Context) string {
+	return internal.CountryFromContext(ctx)
+}
+
+// RequestIDFromContext returns the ID RequestID gave the request, or an empty
+// string if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	return internal.RequestIDFromContext(ctx)
+}
+
+// Private
+
+// withRequestTags makes sure there's somewhere for the middleware to record
+// what it learns about requests, for those that come after it.
+func withRequestTags(next http.Handler) http.Handler {
+	return internal.NewRequestTagsMiddleware(next)
+}
+
+func logger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
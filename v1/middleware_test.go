@@ -0,0 +1,181 @@
+package thruster
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	step := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	serve(Chain(step("first"), step("second"))(textHandler("ok")), "GET", "/", nil)
+	assert.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestCountryFilter(t *testing.T) {
+	resolver := openTestResolver(t)
+
+	var country string
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country = CountryFromContext(r.Context())
+	})
+
+	handler := CountryFilter(resolver, CountryFilterOptions{Block: []string{"gb"}, OptionsPolicy: BlockPolicyAllow})(app)
+
+	w := serve(handler, "GET", "/", fromGB)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(handler, "OPTIONS", "/", fromGB)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "GB", country)
+
+	handler = CountryFilter(resolver, CountryFilterOptions{Allow: []string{"GB"}})(app)
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", fromGB).Code)
+}
+
+func TestClientRateLimit(t *testing.T) {
+	handler := ClientRateLimit(RateLimit{Rate: 1, Burst: 1}, RateLimitOptions{})(textHandler("ok"))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", fromGB).Code)
+	w := serve(handler, "GET", "/", fromGB)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	_, network, _ := net.ParseCIDR("81.2.69.0/24")
+	handler = ClientRateLimit(RateLimit{Rate: 1, Burst: 1}, RateLimitOptions{ExemptNetworks: []*net.IPNet{network}})(textHandler("ok"))
+	for range 3 {
+		assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", fromGB).Code)
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	limit := ClientRateLimit(RateLimit{Rate: 1, Burst: 1}, RateLimitOptions{})
+	behindProxy := func(forwardedFor string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.RemoteAddr = "10.0.0.2:1234"
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+	}
+
+	handler := Chain(ClientAddress(ClientAddressOptions{TrustedProxies: []*net.IPNet{proxies}}), limit)(textHandler("ok"))
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", behindProxy("81.2.69.142")).Code)
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", behindProxy("81.2.69.143")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "GET", "/", behindProxy("81.2.69.142")).Code)
+
+	handler = Chain(ClientAddress(ClientAddressOptions{}), limit)(textHandler("ok"))
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", fromGB).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "GET", "/", func(r *http.Request) {
+		fromGB(r)
+		r.Header.Set("X-Forwarded-For", "81.2.69.143")
+	}).Code)
+}
+
+func TestCountryRateLimit(t *testing.T) {
+	limit := CountryRateLimit(map[string]RateLimit{"GB": {Rate: 1, Burst: 1}}, RateLimit{}, RateLimitOptions{})
+	handler := Chain(CountryFilter(openTestResolver(t), CountryFilterOptions{}), limit)(textHandler("ok"))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", fromGB).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(handler, "GET", "/", fromGB).Code)
+}
+
+func TestBodyInspection(t *testing.T) {
+	handler := BodyInspection([]BodyRule{{Path: "/signup", Field: "email", Patterns: []string{"*@Mailinator.com"}}}, BodyInspectionOptions{})(textHandler("ok"))
+
+	post := func(body string) int {
+		return serve(handler, "POST", "/signup", func(r *http.Request) {
+			r.Body = http.NoBody
+			if body != "" {
+				r.Body = httptest.NewRequest("POST", "/", strings.NewReader(body)).Body
+				r.ContentLength = int64(len(body))
+			}
+			r.Header.Set("Content-Type", "application/json")
+		}).Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, post(`{"email": "someone@mailinator.com"}`))
+	assert.Equal(t, http.StatusOK, post(`{"email": "someone@example.com"}`))
+}
+
+func TestMaintenance(t *testing.T) {
+	always := Schedule{Start: 0, End: 24 * time.Hour}
+	maintenance := Maintenance([]MaintenanceWindow{{Countries: []string{"gb"}, Schedule: always}}, MaintenanceOptions{})
+	handler := Chain(CountryFilter(openTestResolver(t), CountryFilterOptions{}), maintenance)(textHandler("ok"))
+
+	w := serve(handler, "GET", "/", fromGB)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/", nil).Code)
+}
+
+func TestRequestID(t *testing.T) {
+	var id string
+	handler := RequestID(RequestIDOptions{Header: "x-correlation-id", TrustIncoming: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = RequestIDFromContext(r.Context())
+	}))
+
+	w := serve(handler, "GET", "/", nil)
+	assert.NotEmpty(t, id)
+	assert.Equal(t, id, w.Header().Get("X-Correlation-Id"))
+
+	serve(handler, "GET", "/", func(r *http.Request) {
+		r.Header.Set("X-Correlation-Id", "abc123")
+	})
+	assert.Equal(t, "abc123", id)
+}
+
+func TestCompression(t *testing.T) {
+	handler := Compression(CompressionOptions{Encodings: []string{"deflate", "zstd"}})(textHandler(strings.Repeat("compressible ", 200)))
+
+	w := serve(handler, "GET", "/", func(r *http.Request) {
+		r.Header.Set("Accept-Encoding", "br, zstd, gzip")
+	})
+	assert.Equal(t, "zstd", w.Header().Get("Content-Encoding"))
+}
+
+// Helpers
+
+func openTestResolver(t *testing.T) *GeoIP2Resolver {
+	resolver, err := OpenGeoIP2("../internal/fixtures/GeoLite2-Country.mmdb")
+	require.NoError(t, err)
+	t.Cleanup(func() { resolver.Close() })
+	return resolver
+}
+
+func fromGB(r *http.Request) {
+	r.RemoteAddr = "81.2.69.142:1234"
+}
+
+func textHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	})
+}
+
+func serve(handler http.Handler, method, path string, prepare func(*http.Request)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if prepare != nil {
+		prepare(r)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
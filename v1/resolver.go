@@ -0,0 +1,36 @@
+package thruster
+
+import (
+	"net"
+
+	"github.com/basecamp/thruster/internal"
+)
+
+// GeoIP2Resolver looks up the countries of IP addresses in a GeoIP2 or
+// GeoLite2 country database.
+type GeoIP2Resolver struct {
+	resolver *internal.GeoResolver
+}
+
+// OpenGeoIP2 opens the database at path.
+func OpenGeoIP2(path string) (*GeoIP2Resolver, error) {
+	resolver, err := internal.OpenGeoResolver(internal.GeoProviderMaxMind, []string{path})
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP2Resolver{resolver: resolver}, nil
+}
+
+// Country returns the ISO code of the country the address is in, or an empty
+// string when the database doesn't know.
+func (r *GeoIP2Resolver) Country(ip net.IP) (string, error) {
+	info, err := r.resolver.Lookup(ip)
+	if err != nil {
+		return "", err
+	}
+	return info.Country, nil
+}
+
+func (r *GeoIP2Resolver) Close() error {
+	return r.resolver.Close()
+}
@@ -0,0 +1,24 @@
+package thruster
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoIP2Resolver(t *testing.T) {
+	resolver := openTestResolver(t)
+
+	country, err := resolver.Country(net.ParseIP("81.2.69.142"))
+	require.NoError(t, err)
+	assert.Equal(t, "GB", country)
+
+	country, err = resolver.Country(net.ParseIP("127.0.0.1"))
+	require.NoError(t, err)
+	assert.Empty(t, country)
+
+	_, err = OpenGeoIP2("missing.mmdb")
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package thruster
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/basecamp/thruster/internal"
+)
+
+var (
+	ErrInvalidRateLimit         = errors.New("rate limit must be in the form rps[:burst]")
+	ErrInvalidBodyRule          = errors.New("body rule must be in the form path[@COUNTRY|COUNTRY...]:field=pattern[|pattern...]")
+	ErrInvalidSchedule          = errors.New("schedule must be in the form [DAY|DAY-DAY...] HH:MM-HH:MM [TIME_ZONE]")
+	ErrInvalidMaintenanceWindow = errors.New("maintenance window must be in the form COUNTRY[|COUNTRY...]@schedule")
+	ErrInvalidBlockPolicy       = errors.New("block policy must be one of deny, allow, or empty")
+)
+
+// RateLimit allows Rate requests a second on average, with bursts of up to
+// Burst at once.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses a limit written as `rps[:burst]`, as in the
+// RATE_LIMIT setting. When the burst is omitted it's the rate, rounded up.
+func ParseRateLimit(value string) (RateLimit, error) {
+	limit, err := internal.ParseRateLimit(value)
+	if err != nil {
+		return RateLimit{}, ErrInvalidRateLimit
+	}
+	return RateLimit{Rate: limit.Rate, Burst: limit.Burst}, nil
+}
+
+func (l RateLimit) String() string {
+	return l.toInternal().String()
+}
+
+// BodyRule refuses requests to matching paths when a field of their JSON or
+// form body matches one of the patterns. See the BODY_RULES setting for how
+// paths, fields and patterns are matched.
+type BodyRule struct {
+	Path      string
+	Countries []string
+	Field     string
+	Patterns  []string
+}
+
+// ParseBodyRule parses a rule written as
+// `path[@COUNTRY|COUNTRY...]:field=pattern[|pattern...]`, as in the
+// BODY_RULES setting.
+func ParseBodyRule(value string) (BodyRule, error) {
+	rule, err := internal.ParseBodyRule(value)
+	if err != nil {
+		return BodyRule{}, ErrInvalidBodyRule
+	}
+	return BodyRule{Path: rule.Path, Countries: rule.Countries, Field: rule.Field, Patterns: rule.Patterns}, nil
+}
+
+func (r BodyRule) String() string {
+	return r.toInternal().String()
+}
+
+// Schedule is a window of time that recurs each day, or on the given days of
+// the week, between Start and End after midnight in Location (UTC when nil).
+// A window that ends before it starts runs past midnight.
+type Schedule struct {
+	Days     []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// ParseSchedule parses a schedule written as
+// `[DAY|DAY-DAY...] HH:MM-HH:MM [TIME_ZONE]`, such as
+// `Sat|Sun 02:00-04:00 Asia/Tokyo`.
+func ParseSchedule(value string) (Schedule, error) {
+	schedule, err := internal.ParseSchedule(value)
+	if err != nil {
+		return Schedule{}, ErrInvalidSchedule
+	}
+	return Schedule(schedule), nil
+}
+
+func (s Schedule) String() string {
+	return s.toInternal().String()
+}
+
+// Active reports whether the window is open at the given time.
+func (s Schedule) Active(now time.Time) bool {
+	return s.toInternal().Active(now)
+}
+
+// MaintenanceWindow takes the app down for maintenance for requests from some
+// countries, while its schedule is active.
+type MaintenanceWindow struct {
+	Countries []string
+	Schedule  Schedule
+}
+
+// ParseMaintenanceWindow parses a window written as
+// `COUNTRY[|COUNTRY...]@schedule`, as in the MAINTENANCE_WINDOWS setting.
+func ParseMaintenanceWindow(value string) (MaintenanceWindow, error) {
+	window, err := internal.ParseMaintenanceWindow(value)
+	if err != nil {
+		return MaintenanceWindow{}, ErrInvalidMaintenanceWindow
+	}
+	return MaintenanceWindow{Countries: window.Countries, Schedule: Schedule(window.Schedule)}, nil
+}
+
+func (w MaintenanceWindow) String() string {
+	return w.toInternal().String()
+}
+
+// BlockPolicy decides what a request from a blocked country gets back.
+type BlockPolicy string
+
+const (
+	// BlockPolicyDeny refuses the request with a 403. It's the default.
+	BlockPolicyDeny BlockPolicy = "deny"
+	// BlockPolicyAllow lets the request through, as for CORS preflights.
+	BlockPolicyAllow BlockPolicy = "allow"
+	// BlockPolicyEmpty answers with an empty 204.
+	BlockPolicyEmpty BlockPolicy = "empty"
+)
+
+// ParseBlockPolicy parses a policy name, with an empty name meaning
+// BlockPolicyDeny.
+func ParseBlockPolicy(value string) (BlockPolicy, error) {
+	policy, err := internal.ParseBlockPolicy(value)
+	if err != nil {
+		return "", ErrInvalidBlockPolicy
+	}
+	return BlockPolicy(policy), nil
+}
+
+// Private
+
+func (l RateLimit) toInternal() internal.RateLimit {
+	return internal.RateLimit{Rate: l.Rate, Burst: l.Burst}
+}
+
+func (r BodyRule) toInternal() internal.BodyRule {
+	patterns := make([]string, len(r.Patterns))
+	for i, pattern := range r.Patterns {
+		patterns[i] = strings.ToLower(pattern)
+	}
+	return internal.BodyRule{Path: r.Path, Countries: upperCase(r.Countries), Field: r.Field, Patterns: patterns}
+}
+
+func (s Schedule) toInternal() internal.Schedule {
+	return internal.Schedule(s)
+}
+
+func (w MaintenanceWindow) toInternal() internal.MaintenanceWindow {
+	return internal.MaintenanceWindow{Countries: upperCase(w.Countries), Schedule: w.Schedule.toInternal()}
+}
+
+func (p BlockPolicy) toInternal() internal.BlockPolicy {
+	policy, _ := internal.ParseBlockPolicy(string(p))
+	return policy
+}
+
+func upperCase(values []string) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = strings.ToUpper(value)
+	}
+	return result
+}
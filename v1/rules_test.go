@@ -0,0 +1,64 @@
+package thruster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("2.5")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 2.5, Burst: 3}, limit)
+	assert.Equal(t, "2.5:3", limit.String())
+
+	_, err = ParseRateLimit("fast")
+	assert.ErrorIs(t, err, ErrInvalidRateLimit)
+}
+
+func TestParseBodyRule(t *testing.T) {
+	rule, err := ParseBodyRule("/signup@RU:email=*@mailinator.com")
+	require.NoError(t, err)
+	assert.Equal(t, BodyRule{Path: "/signup", Countries: []string{"RU"}, Field: "email", Patterns: []string{"*@mailinator.com"}}, rule)
+	assert.Equal(t, "/signup@RU:email=*@mailinator.com", rule.String())
+
+	_, err = ParseBodyRule("/signup")
+	assert.ErrorIs(t, err, ErrInvalidBodyRule)
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("Sat 02:00-04:00")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Saturday}, schedule.Days)
+	assert.Equal(t, "Sat 02:00-04:00", schedule.String())
+	assert.True(t, schedule.Active(time.Date(2024, 3, 9, 3, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.Active(time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC)))
+
+	_, err = ParseSchedule("someday")
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+}
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	window, err := ParseMaintenanceWindow("jp|kr@22:00-02:00 Asia/Tokyo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"JP", "KR"}, window.Countries)
+	assert.Equal(t, "JP|KR@22:00-02:00 Asia/Tokyo", window.String())
+
+	_, err = ParseMaintenanceWindow("02:00-04:00")
+	assert.ErrorIs(t, err, ErrInvalidMaintenanceWindow)
+}
+
+func TestParseBlockPolicy(t *testing.T) {
+	policy, err := ParseBlockPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, BlockPolicyDeny, policy)
+
+	policy, err = ParseBlockPolicy("Empty")
+	require.NoError(t, err)
+	assert.Equal(t, BlockPolicyEmpty, policy)
+
+	_, err = ParseBlockPolicy("drop")
+	assert.ErrorIs(t, err, ErrInvalidBlockPolicy)
+}
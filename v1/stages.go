@@ -0,0 +1,90 @@
+package thruster
+
+import (
+	"net/http"
+
+	"github.com/basecamp/thruster/internal"
+)
+
+// Names of the stages of Thruster's own chain that this package has
+// middleware for, in the order Thruster runs them.
+const (
+	StageClientAddress    = "client_address"
+	StageRequestID        = "request_id"
+	StageClientRateLimit  = "client_rate_limit"
+	StageCountryFilter    = "geoip"
+	StageMaintenance      = "maintenance"
+	StageBodyInspection   = "body_inspection"
+	StageCountryRateLimit = "country_rate_limit"
+	StageCompression      = "compression"
+)
+
+var (
+	ErrStageNotFound = internal.ErrStageNotFound
+	ErrStageExists   = internal.ErrStageExists
+)
+
+// Stages is an ordered list of named middleware, from the outermost (the
+// first to see a request) to the innermost. Middleware of your own can be
+// placed relative to the stages by name, such as after StageCountryFilter,
+// where the client's country is known.
+//
+// A stage may have no middleware. It then keeps its place, for others to be
+// positioned around, but does nothing.
+type Stages struct {
+	chain *internal.Chain
+}
+
+// NewStages returns Thruster's stages, in Thruster's order, each without
+// middleware until given some with Replace:
+//
+//	stages := thruster.NewStages()
+//	stages.Replace(thruster.StageCountryFilter, thruster.CountryFilter(resolver, options))
+//	stages.InsertAfter(thruster.StageCountryFilter, "audit", audit)
+//	handler := stages.Then(app)
+func NewStages() *Stages {
+	stages := &Stages{chain: internal.NewChain()}
+	for _, name := range []string{
+		StageClientAddress, StageRequestID, StageClientRateLimit, StageCountryFilter,
+		StageMaintenance, StageBodyInspection, StageCountryRateLimit, StageCompression,
+	} {
+		stages.chain.Use(name, nil)
+	}
+	return stages
+}
+
+// Use appends a stage, making it the innermost one.
+func (s *Stages) Use(name string, middleware Middleware) error {
+	return s.chain.Use(name, internal.Middleware(middleware))
+}
+
+// InsertBefore adds a stage that sees requests before the existing stage.
+func (s *Stages) InsertBefore(existing, name string, middleware Middleware) error {
+	return s.chain.InsertBefore(existing, name, internal.Middleware(middleware))
+}
+
+// InsertAfter adds a stage that sees requests after the existing stage.
+func (s *Stages) InsertAfter(existing, name string, middleware Middleware) error {
+	return s.chain.InsertAfter(existing, name, internal.Middleware(middleware))
+}
+
+// Replace sets the middleware of an existing stage, or with nil, removes it
+// while keeping the stage's place.
+func (s *Stages) Replace(name string, middleware Middleware) error {
+	return s.chain.Replace(name, internal.Middleware(middleware))
+}
+
+// Remove takes a stage out altogether.
+func (s *Stages) Remove(name string) error {
+	return s.chain.Remove(name)
+}
+
+// Names lists the stages in order, including those without middleware.
+func (s *Stages) Names() []string {
+	return s.chain.Names()
+}
+
+// Then builds the stages around the handler.
+func (s *Stages) Then(handler http.Handler) http.Handler {
+	return s.chain.Then(handler)
+}
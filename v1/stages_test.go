@@ -0,0 +1,53 @@
+package thruster
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/basecamp/thruster/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStages(t *testing.T) {
+	var country string
+	remember := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			country = CountryFromContext(r.Context())
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	stages := NewStages()
+	require.NoError(t, stages.Replace(StageCountryFilter, CountryFilter(openTestResolver(t), CountryFilterOptions{Block: []string{"CN"}})))
+	require.NoError(t, stages.InsertAfter(StageCountryFilter, "remember_country", remember))
+	require.NoError(t, stages.Remove(StageCompression))
+
+	assert.Equal(t, []string{
+		StageClientAddress, StageRequestID, StageClientRateLimit, StageCountryFilter, "remember_country",
+		StageMaintenance, StageBodyInspection, StageCountryRateLimit,
+	}, stages.Names())
+
+	w := serve(stages.Then(textHandler("ok")), "GET", "/", fromGB)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "GB", country)
+}
+
+func TestStages_errors(t *testing.T) {
+	stages := NewStages()
+
+	assert.ErrorIs(t, stages.Use(StageRequestID, nil), ErrStageExists)
+	assert.ErrorIs(t, stages.InsertBefore("missing", "mine", nil), ErrStageNotFound)
+	assert.ErrorIs(t, stages.Replace("missing", nil), ErrStageNotFound)
+	assert.ErrorIs(t, stages.Remove("missing"), ErrStageNotFound)
+}
+
+func TestStages_are_in_thrusters_order(t *testing.T) {
+	names := NewStages().Names()
+	ours := slices.DeleteFunc(internal.NewHandlerChain(internal.HandlerOptions{}).Names(), func(name string) bool {
+		return !slices.Contains(names, name)
+	})
+
+	assert.Equal(t, names, ours)
+}